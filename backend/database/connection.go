@@ -64,8 +64,8 @@ func RunMigrations(db *sql.DB) error {
 	}
 
 	if tableExists {
-		log.Println("Database tables already exist, skipping migrations")
-		return nil
+		log.Println("Database tables already exist, applying pending migrations")
+		return applyMigrations(db)
 	}
 
 	// If tables don't exist, try to read and execute the schema file
@@ -73,7 +73,7 @@ func RunMigrations(db *sql.DB) error {
 	schema, err := os.ReadFile(schemaPath)
 	if err != nil {
 		log.Printf("Schema file not found, assuming database is initialized by Docker: %v", err)
-		return nil // Don't fail if schema file doesn't exist in container
+		return applyMigrations(db) // Don't fail if schema file doesn't exist in container
 	}
 
 	// Execute the schema
@@ -83,5 +83,5 @@ func RunMigrations(db *sql.DB) error {
 	}
 
 	log.Println("Database migrations completed successfully")
-	return nil
+	return applyMigrations(db)
 }
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- Authenticator-app 2FA alongside SMS
+ALTER TABLE users ADD COLUMN IF NOT EXISTS two_factor_method VARCHAR(20) NOT NULL DEFAULT 'sms'
+    CHECK (two_factor_method IN ('sms', 'totp'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_pending_secret VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_used_step BIGINT;
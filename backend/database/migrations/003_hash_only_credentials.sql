@@ -0,0 +1,20 @@
+-- Sessions keep only the SHA-256 (hex) of the refresh token. Existing rows are
+-- backfilled from the plaintext column before it is dropped, so refresh
+-- tokens issued before this migration keep working.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'user_sessions' AND column_name = 'refresh_token'
+    ) THEN
+        UPDATE user_sessions
+        SET refresh_token_hash = encode(sha256(convert_to(refresh_token, 'UTF8')), 'hex');
+        ALTER TABLE user_sessions DROP COLUMN refresh_token;
+        ALTER TABLE user_sessions ALTER COLUMN refresh_token_hash TYPE VARCHAR(64);
+        ALTER TABLE user_sessions ADD CONSTRAINT user_sessions_refresh_token_hash_key UNIQUE (refresh_token_hash);
+    END IF;
+END $$;
+
+-- The Argon2 encoded password hash already carries its salt. The separate
+-- column held raw bytes cast to text and was never read.
+ALTER TABLE users DROP COLUMN IF EXISTS password_salt;
@@ -0,0 +1,4 @@
+-- Change-email flow: the new address waits here until confirmed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_change_token VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_change_expires_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,3 @@
+-- Per-user settings for new-device sign-in alerts
+ALTER TABLE users ADD COLUMN IF NOT EXISTS login_alert_email BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS login_alert_sms BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,11 @@
+-- SMS codes are now stored as an HMAC only. Outstanding codes were hashed
+-- with Argon2 and can't be verified any more; they expire within minutes
+-- anyway, so drop them along with the plaintext column. Databases created
+-- after 009 already have verification_codes without the column.
+DO $$
+BEGIN
+    IF to_regclass('sms_verification_codes') IS NOT NULL THEN
+        DELETE FROM sms_verification_codes WHERE verified = FALSE;
+        ALTER TABLE sms_verification_codes DROP COLUMN IF EXISTS code;
+    END IF;
+END $$;
@@ -0,0 +1,28 @@
+-- 2FA codes can be delivered by email as well as SMS, so the table is
+-- generalized and records the channel each code went out on
+DO $$
+BEGIN
+    IF to_regclass('sms_verification_codes') IS NOT NULL AND to_regclass('verification_codes') IS NULL THEN
+        ALTER TABLE sms_verification_codes RENAME TO verification_codes;
+    END IF;
+END $$;
+
+ALTER TABLE verification_codes ADD COLUMN IF NOT EXISTS delivery_method VARCHAR(10) NOT NULL DEFAULT 'sms';
+ALTER TABLE verification_codes DROP CONSTRAINT IF EXISTS check_code_length;
+
+CREATE OR REPLACE FUNCTION cleanup_expired_records()
+RETURNS void AS $$
+BEGIN
+    -- Clean up expired 2FA verification codes
+    DELETE FROM verification_codes WHERE expires_at < NOW() - INTERVAL '1 day';
+    
+    -- Clean up expired user sessions
+    DELETE FROM user_sessions WHERE expires_at < NOW();
+    
+    -- Clean up old audit logs (keep for 1 year)
+    DELETE FROM security_audit_log WHERE created_at < NOW() - INTERVAL '1 year';
+    
+    -- Clean up old rate limit records
+    DELETE FROM rate_limits WHERE window_start < NOW() - INTERVAL '1 day' AND blocked_until < NOW();
+END;
+$$ LANGUAGE plpgsql;
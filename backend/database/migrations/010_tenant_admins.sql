@@ -0,0 +1,10 @@
+-- A tenant's creator is now its admin. Tenants created before that have no
+-- admin, so promote each one's longest-standing active member.
+UPDATE users SET role = 'admin', updated_at = NOW()
+WHERE id IN (
+    SELECT DISTINCT ON (tenant_id) id
+    FROM users
+    WHERE is_active = TRUE
+      AND tenant_id NOT IN (SELECT tenant_id FROM users WHERE role = 'admin' AND is_active = TRUE)
+    ORDER BY tenant_id, created_at
+);
@@ -0,0 +1,17 @@
+-- Impersonation sessions: a support user acting as a customer. Tokens last 15
+-- minutes, can't be refreshed, and can be ended early. Rows are kept for the
+-- audit trail.
+CREATE TABLE IF NOT EXISTS impersonation_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    support_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    target_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    access_token_jti VARCHAR(255) UNIQUE NOT NULL,
+    ip_address INET,
+    user_agent TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_sessions_support_user_id ON impersonation_sessions(support_user_id);
+CREATE INDEX IF NOT EXISTS idx_impersonation_sessions_target_user_id ON impersonation_sessions(target_user_id);
@@ -0,0 +1,3 @@
+-- Remember-me sessions keep their refresh token for 30 days instead of 7,
+-- and the sessions list shows which ones they are
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS remember_me BOOLEAN NOT NULL DEFAULT FALSE;
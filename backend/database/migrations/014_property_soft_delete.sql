@@ -0,0 +1,5 @@
+-- Deleted properties are kept, hidden, so ARV calculations referencing them
+-- aren't orphaned and an admin can restore them
+ALTER TABLE properties ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_properties_tenant_id_active ON properties(tenant_id) WHERE deleted_at IS NULL;
@@ -0,0 +1,14 @@
+-- Photos uploaded for a property. The files live in photo storage (local
+-- disk or S3) under storage_key; position orders the gallery.
+CREATE TABLE IF NOT EXISTS property_photos (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    storage_key VARCHAR(500) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_property_photos_property_id ON property_photos(property_id, position);
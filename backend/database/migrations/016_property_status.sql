@@ -0,0 +1,20 @@
+-- Deal pipeline: each property is at one stage, and every move between
+-- stages is kept with who made it and why
+ALTER TABLE properties ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'lead';
+
+ALTER TABLE properties DROP CONSTRAINT IF EXISTS check_property_status;
+ALTER TABLE properties ADD CONSTRAINT check_property_status
+    CHECK (status IN ('lead', 'analyzing', 'offer_made', 'under_contract', 'rehabbing', 'rented', 'sold', 'dead'));
+
+CREATE TABLE IF NOT EXISTS property_status_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_property_status_history_property_id ON property_status_history(property_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_properties_tenant_id_status ON properties(tenant_id, status) WHERE deleted_at IS NULL;
@@ -0,0 +1,10 @@
+-- Saved ARV calculations keep the rental returns they projected, so the
+-- portfolio summary can aggregate each property's latest analysis
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS total_investment DECIMAL(12,2);
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS monthly_cash_flow DECIMAL(12,2);
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS cash_on_cash_return DECIMAL(8,2);
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS cap_rate DECIMAL(8,2);
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS dscr DECIMAL(8,2);
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS risk_level VARCHAR(20);
+
+CREATE INDEX IF NOT EXISTS idx_arv_calculations_property_id_created_at ON arv_calculations(property_id, created_at DESC);
@@ -0,0 +1,20 @@
+-- Tenant-defined labels for deals. Names are unique per tenant regardless
+-- of case; deleting a tag drops its assignments but not the properties.
+CREATE TABLE IF NOT EXISTS property_tags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    color VARCHAR(7) NOT NULL DEFAULT '#6B7280',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS property_tag_assignments (
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tag_id UUID NOT NULL REFERENCES property_tags(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (property_id, tag_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_property_tags_tenant_id_name ON property_tags(tenant_id, LOWER(name));
+CREATE INDEX IF NOT EXISTS idx_property_tag_assignments_tag_id ON property_tag_assignments(tag_id);
@@ -0,0 +1,47 @@
+-- Offers made on properties and every status change they go through,
+-- including the seller's counters. A property can have only one accepted
+-- offer at a time.
+CREATE TABLE IF NOT EXISTS property_offers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    amount DECIMAL(12,2) NOT NULL,
+    counter_amount DECIMAL(12,2),
+    offer_date DATE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    financing_type VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'draft',
+    notes TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS property_offer_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    offer_id UUID NOT NULL REFERENCES property_offers(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    amount DECIMAL(12,2),
+    note TEXT,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE property_offers DROP CONSTRAINT IF EXISTS check_offer_status;
+ALTER TABLE property_offers ADD CONSTRAINT check_offer_status
+    CHECK (status IN ('draft', 'submitted', 'countered', 'accepted', 'rejected'));
+
+ALTER TABLE property_offers DROP CONSTRAINT IF EXISTS check_offer_financing_type;
+ALTER TABLE property_offers ADD CONSTRAINT check_offer_financing_type
+    CHECK (financing_type IN ('cash', 'conventional', 'fha', 'va', 'hard_money', 'private_money', 'seller_financing', 'other'));
+
+ALTER TABLE property_offers DROP CONSTRAINT IF EXISTS check_offer_amounts;
+ALTER TABLE property_offers ADD CONSTRAINT check_offer_amounts
+    CHECK (amount > 0 AND (counter_amount IS NULL OR counter_amount > 0));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_property_offers_one_accepted ON property_offers(property_id) WHERE status = 'accepted';
+CREATE INDEX IF NOT EXISTS idx_property_offers_property_id ON property_offers(property_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_property_offers_tenant_id_outstanding ON property_offers(tenant_id, expires_at)
+    WHERE status IN ('submitted', 'countered');
+CREATE INDEX IF NOT EXISTS idx_property_offer_history_offer_id ON property_offer_history(offer_id, created_at);
@@ -0,0 +1,36 @@
+-- Rehab budgets broken into line items, and where a saved ARV
+-- calculation's rehab cost came from: entered by hand or summed from the
+-- property's estimated rehab items
+CREATE TABLE IF NOT EXISTS rehab_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    category VARCHAR(30) NOT NULL,
+    description VARCHAR(500) NOT NULL,
+    estimated_cost DECIMAL(12,2) NOT NULL DEFAULT 0,
+    actual_cost DECIMAL(12,2),
+    status VARCHAR(20) NOT NULL DEFAULT 'planned',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE rehab_items DROP CONSTRAINT IF EXISTS check_rehab_item_category;
+ALTER TABLE rehab_items ADD CONSTRAINT check_rehab_item_category
+    CHECK (category IN ('roof', 'kitchen', 'bathroom', 'hvac', 'plumbing', 'electrical', 'flooring', 'paint',
+        'windows_doors', 'foundation', 'exterior', 'landscaping', 'permits', 'other'));
+
+ALTER TABLE rehab_items DROP CONSTRAINT IF EXISTS check_rehab_item_status;
+ALTER TABLE rehab_items ADD CONSTRAINT check_rehab_item_status
+    CHECK (status IN ('planned', 'in_progress', 'completed'));
+
+ALTER TABLE rehab_items DROP CONSTRAINT IF EXISTS check_rehab_item_costs;
+ALTER TABLE rehab_items ADD CONSTRAINT check_rehab_item_costs
+    CHECK (estimated_cost >= 0 AND (actual_cost IS NULL OR actual_cost >= 0));
+
+CREATE INDEX IF NOT EXISTS idx_rehab_items_property_id ON rehab_items(property_id, created_at);
+
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS rehab_cost_source VARCHAR(20) NOT NULL DEFAULT 'manual';
+
+ALTER TABLE arv_calculations DROP CONSTRAINT IF EXISTS check_arv_calculation_rehab_cost_source;
+ALTER TABLE arv_calculations ADD CONSTRAINT check_arv_calculation_rehab_cost_source
+    CHECK (rehab_cost_source IN ('manual', 'rehab_items'));
@@ -0,0 +1,10 @@
+-- Each user's starred properties. Favorites belong to a user rather than
+-- the tenant, so teammates keep separate watchlists.
+CREATE TABLE IF NOT EXISTS property_favorites (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, property_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_property_favorites_property_id ON property_favorites(property_id);
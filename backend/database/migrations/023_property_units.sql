@@ -0,0 +1,23 @@
+-- Rent rolls for multi-unit properties: one row per rentable unit with its
+-- current and market rent and whether it is leased
+CREATE TABLE IF NOT EXISTS property_units (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    label VARCHAR(50) NOT NULL,
+    bedrooms INTEGER DEFAULT 0,
+    bathrooms DECIMAL(3,1) DEFAULT 0,
+    square_feet INTEGER DEFAULT 0,
+    current_rent DECIMAL(10,2) NOT NULL DEFAULT 0,
+    market_rent DECIMAL(10,2) NOT NULL DEFAULT 0,
+    occupied BOOLEAN NOT NULL DEFAULT FALSE,
+    lease_end_date DATE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE property_units DROP CONSTRAINT IF EXISTS check_unit_rents;
+ALTER TABLE property_units ADD CONSTRAINT check_unit_rents
+    CHECK (current_rent >= 0 AND market_rent >= 0);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_property_units_property_id_label ON property_units(property_id, LOWER(label));
@@ -0,0 +1,7 @@
+-- Archived properties drop out of the active list and portfolio totals but
+-- stay readable, with their calculations and comparables, unlike deleted
+-- ones
+ALTER TABLE properties ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_properties_tenant_id_unarchived ON properties(tenant_id)
+    WHERE deleted_at IS NULL AND archived_at IS NULL;
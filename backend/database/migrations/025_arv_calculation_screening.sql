@@ -0,0 +1,5 @@
+-- Quick screening metrics kept with saved ARV calculations: the gross rent
+-- multiplier and monthly rent as a percentage of the all-in price. Both are
+-- NULL when the calculation's rent was estimated rather than entered.
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS gross_rent_multiplier DECIMAL(8,2);
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS rent_to_price_ratio DECIMAL(6,3);
@@ -0,0 +1,7 @@
+-- Saved ARV calculations flag an infinite return, where the refinance
+-- recovered all the cash and the property cash flows, instead of storing
+-- 999.99 as its cash-on-cash return
+ALTER TABLE arv_calculations ADD COLUMN IF NOT EXISTS is_infinite_return BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE arv_calculations SET is_infinite_return = TRUE, cash_on_cash_return = NULL
+WHERE cash_on_cash_return = 999.99;
@@ -0,0 +1,11 @@
+-- Each tenant's comp adjustment schedule. Tenants without a row use the
+-- built-in schedule, and an ARV estimate can override either per request.
+CREATE TABLE IF NOT EXISTS comp_adjustment_settings (
+    tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    per_bedroom DECIMAL(12,2) NOT NULL DEFAULT 5000,
+    per_bathroom DECIMAL(12,2) NOT NULL DEFAULT 3000,
+    per_sqft DECIMAL(10,2) NOT NULL DEFAULT 50,
+    derive_per_sqft BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
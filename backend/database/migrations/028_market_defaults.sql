@@ -0,0 +1,9 @@
+-- Tenants' default vacancy and credit loss, tenant-wide or for a market: a
+-- state such as 'OH' or a zip code prefix such as '441'. They share the comp
+-- adjustment settings table, where the tenant-wide row has an empty market.
+ALTER TABLE comp_adjustment_settings ADD COLUMN IF NOT EXISTS market VARCHAR(5) NOT NULL DEFAULT '';
+ALTER TABLE comp_adjustment_settings ADD COLUMN IF NOT EXISTS vacancy_rate DECIMAL(5,2);
+ALTER TABLE comp_adjustment_settings ADD COLUMN IF NOT EXISTS credit_loss_rate DECIMAL(5,2);
+
+ALTER TABLE comp_adjustment_settings DROP CONSTRAINT IF EXISTS comp_adjustment_settings_pkey;
+ALTER TABLE comp_adjustment_settings ADD PRIMARY KEY (tenant_id, market);
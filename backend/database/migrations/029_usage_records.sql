@@ -0,0 +1,10 @@
+-- How many ARV calculations each tenant has run in a calendar month (UTC),
+-- counted against its plan's monthly limit. A new month starts a new row.
+CREATE TABLE IF NOT EXISTS usage_records (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    month DATE NOT NULL, -- first of the month
+    arv_calculations INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, month)
+);
@@ -0,0 +1,33 @@
+-- Tenants' Stripe subscriptions, kept in sync by the billing webhook. A
+-- tenant without a row is on the free Starter plan.
+CREATE TABLE IF NOT EXISTS subscriptions (
+    tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    stripe_customer_id VARCHAR(255) NOT NULL,
+    stripe_subscription_id VARCHAR(255) UNIQUE,
+    tier VARCHAR(50) NOT NULL DEFAULT 'starter',
+    status VARCHAR(50) NOT NULL,
+    current_period_end TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_stripe_customer_id ON subscriptions(stripe_customer_id);
+
+-- One-time report payments that have gone through, each good for one report
+-- until consumed_at is set
+CREATE TABLE IF NOT EXISTS report_purchases (
+    payment_intent_id VARCHAR(255) PRIMARY KEY,
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+    property_id VARCHAR(255) NOT NULL,
+    stripe_customer_id VARCHAR(255),
+    amount INTEGER NOT NULL, -- cents
+    consumed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Stripe webhook events already handled, so retried deliveries are ignored
+CREATE TABLE IF NOT EXISTS stripe_events (
+    id VARCHAR(255) PRIMARY KEY,
+    type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    processed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,8 @@
+-- Each tenant's Stripe customer, so repeat purchases reuse it instead of
+-- creating another
+CREATE TABLE IF NOT EXISTS stripe_customers (
+    tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    stripe_customer_id VARCHAR(255) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
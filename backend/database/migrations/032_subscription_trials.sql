@@ -0,0 +1,6 @@
+-- When a trialing subscription's trial ends
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS trial_end TIMESTAMP WITH TIME ZONE;
+
+-- When the tenant started its one free trial. Set once and never cleared, so
+-- canceling and subscribing again doesn't start another.
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS trial_started_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,10 @@
+-- Responses to payment requests made with an idempotency key, so a retried
+-- request gets the original response instead of paying twice. Rows older
+-- than a day are ignored and may be deleted.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(64) PRIMARY KEY, -- SHA-256 of tenant, operation, client key and request
+    operation VARCHAR(100) NOT NULL,
+    response JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
@@ -0,0 +1,3 @@
+-- Reports are authorized by spending a tenant's unused purchase for the
+-- property
+CREATE INDEX IF NOT EXISTS idx_report_purchases_unused ON report_purchases(tenant_id, property_id, created_at) WHERE consumed_at IS NULL;
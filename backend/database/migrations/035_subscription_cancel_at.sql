@@ -0,0 +1,3 @@
+-- When a subscription set to cancel at the end of its period cancels. The
+-- plan is kept until then, and clearing it resumes the subscription.
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS cancel_at TIMESTAMP WITH TIME ZONE;
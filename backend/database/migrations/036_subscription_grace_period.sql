@@ -0,0 +1,4 @@
+-- When a past-due subscription's grace period ends. Set by the first failed
+-- payment and cleared by the next successful one; once it's passed the
+-- tenant is held to Starter's limits.
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS grace_period_ends_at TIMESTAMP WITH TIME ZONE;
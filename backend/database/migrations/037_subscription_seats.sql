@@ -0,0 +1,10 @@
+-- Seats an Enterprise subscription bills for, its quantity in Stripe. Each
+-- active member takes one. Existing Enterprise tenants start with a seat
+-- for each of their active members.
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS seats INTEGER NOT NULL DEFAULT 1;
+
+UPDATE subscriptions SET seats = GREATEST(1, (
+    SELECT COUNT(*) FROM users
+    WHERE users.tenant_id = subscriptions.tenant_id AND is_active AND deleted_at IS NULL
+))
+WHERE tier = 'enterprise';
@@ -0,0 +1,3 @@
+-- Where each tenant's Stripe customer is billed, which Stripe Tax works out
+-- sales tax from. Kept here so it's asked for once.
+ALTER TABLE stripe_customers ADD COLUMN IF NOT EXISTS billing_address JSONB;
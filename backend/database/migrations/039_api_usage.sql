@@ -0,0 +1,15 @@
+-- API-key-authenticated requests each tenant makes a day. Calls beyond what
+-- the plan includes a month are reported to Stripe as metered usage once the
+-- day's over; reported_at marks a day done, so a failed report is retried.
+CREATE TABLE IF NOT EXISTS api_usage (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    day DATE NOT NULL, -- UTC
+    requests INTEGER NOT NULL DEFAULT 0,
+    overage INTEGER NOT NULL DEFAULT 0, -- calls billed, set when reported
+    reported_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_usage_unreported ON api_usage(day) WHERE reported_at IS NULL;
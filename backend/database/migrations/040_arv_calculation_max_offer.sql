@@ -0,0 +1,4 @@
+-- Saved ARV calculations keep the max offer the calculator worked out at the
+-- request's rule percentage, instead of generating one at 70%. Calculations
+-- already saved were all at 70%, so their values stand.
+ALTER TABLE arv_calculations ALTER COLUMN max_offer DROP EXPRESSION IF EXISTS;
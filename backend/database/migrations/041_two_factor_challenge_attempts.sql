@@ -0,0 +1,4 @@
+-- Wrong codes entered against a 2FA login challenge. Once there have been
+-- too many the challenge is revoked, so an authenticator code can't be
+-- guessed within the challenge's 10 minutes.
+ALTER TABLE two_factor_challenges ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func migrationVersions(t *testing.T) []string {
+	entries, err := migrationFiles.ReadDir("migrations")
+	require.NoError(t, err)
+	var versions []string
+	for _, entry := range entries {
+		versions = append(versions, entry.Name())
+	}
+	return versions
+}
+
+func TestApplyMigrations_SkipsApplied(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	versions := migrationVersions(t)
+	require.NotEmpty(t, versions)
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for i, version := range versions {
+		// Everything but the newest migration is already recorded
+		applied := i < len(versions)-1
+		mock.ExpectQuery(`SELECT EXISTS`).
+			WithArgs(version).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(applied))
+		if !applied {
+			mock.ExpectBegin()
+			mock.ExpectExec(`.+`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`INSERT INTO schema_migrations`).
+				WithArgs(version).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+	}
+
+	require.NoError(t, applyMigrations(db))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyMigrations_RollsBackFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	first := migrationVersions(t)[0]
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs(first).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`.+`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	err = applyMigrations(db)
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Contains(t, err.Error(), first)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
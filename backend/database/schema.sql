@@ -6,6 +6,7 @@ CREATE TABLE tenants (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     name VARCHAR(255) NOT NULL,
     subscription_tier VARCHAR(50) NOT NULL DEFAULT 'starter',
+    trial_started_at TIMESTAMP WITH TIME ZONE, -- the tenant's one free trial
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
@@ -18,8 +19,10 @@ CREATE TABLE users (
     email_verified BOOLEAN NOT NULL DEFAULT FALSE,
     email_verification_token VARCHAR(255),
     email_verification_expires_at TIMESTAMP WITH TIME ZONE,
-    password_hash VARCHAR(512) NOT NULL, -- Increased for Argon2
-    password_salt VARCHAR(128) NOT NULL, -- Dedicated salt field
+    pending_email VARCHAR(255), -- New address awaiting confirmation
+    email_change_token VARCHAR(64), -- SHA-256 of the email change token
+    email_change_expires_at TIMESTAMP WITH TIME ZONE,
+    password_hash VARCHAR(512) NOT NULL, -- Argon2 encoded hash, includes its salt
     password_reset_token VARCHAR(255),
     password_reset_expires_at TIMESTAMP WITH TIME ZONE,
     first_name VARCHAR(100),
@@ -29,12 +32,19 @@ CREATE TABLE users (
     role VARCHAR(50) NOT NULL DEFAULT 'user',
     is_active BOOLEAN NOT NULL DEFAULT TRUE,
     two_factor_enabled BOOLEAN NOT NULL DEFAULT FALSE,
-    two_factor_secret VARCHAR(255), -- For TOTP
+    two_factor_method VARCHAR(20) NOT NULL DEFAULT 'sms' CHECK (two_factor_method IN ('sms', 'totp')),
+    two_factor_secret VARCHAR(255), -- For TOTP (AES-GCM encrypted)
+    totp_pending_secret VARCHAR(255), -- Encrypted secret awaiting enrollment confirmation
+    totp_last_used_step BIGINT, -- Last redeemed TOTP time step, blocks code reuse
     backup_codes TEXT[], -- Array of backup codes
+    login_alert_email BOOLEAN NOT NULL DEFAULT TRUE, -- Email on sign-in from a new device
+    login_alert_sms BOOLEAN NOT NULL DEFAULT FALSE, -- Also text the verified phone
     last_login_at TIMESTAMP WITH TIME ZONE,
     last_login_ip INET,
     failed_login_attempts INTEGER NOT NULL DEFAULT 0,
     locked_until TIMESTAMP WITH TIME ZONE,
+    deleted_at TIMESTAMP WITH TIME ZONE, -- Set when the user deletes their account
+    purge_after TIMESTAMP WITH TIME ZONE, -- Hard deletion once the grace period ends
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
@@ -43,25 +53,25 @@ CREATE TABLE users (
 CREATE TABLE user_sessions (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-    refresh_token VARCHAR(512) NOT NULL UNIQUE,
-    refresh_token_hash VARCHAR(512) NOT NULL, -- Hashed version for security
+    refresh_token_hash VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 of the refresh token; the token itself is never stored
     access_token_jti VARCHAR(255) NOT NULL, -- JWT ID for access token
     device_fingerprint VARCHAR(255),
     user_agent TEXT,
     ip_address INET,
     expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
     revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    remember_me BOOLEAN NOT NULL DEFAULT FALSE, -- Refresh token lasts 30 days instead of 7
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
--- Create SMS 2FA verification codes table
-CREATE TABLE sms_verification_codes (
+-- Create 2FA verification codes table (sent by SMS or email)
+CREATE TABLE verification_codes (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     phone_number VARCHAR(20) NOT NULL,
-    code VARCHAR(10) NOT NULL,
-    code_hash VARCHAR(255) NOT NULL, -- Hashed version of code
+    code_hash VARCHAR(255) NOT NULL, -- HMAC-SHA256 of the code; the code itself is never stored
     purpose VARCHAR(50) NOT NULL, -- 'login', 'register', 'password_reset'
+    delivery_method VARCHAR(10) NOT NULL DEFAULT 'sms', -- 'sms' or 'email'
     attempts INTEGER NOT NULL DEFAULT 0,
     max_attempts INTEGER NOT NULL DEFAULT 3,
     expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
@@ -69,6 +79,51 @@ CREATE TABLE sms_verification_codes (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Create 2FA login challenges table (temp token issued after password check)
+CREATE TABLE two_factor_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 of the temp token
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE,
+    failed_attempts INTEGER NOT NULL DEFAULT 0, -- wrong codes; revoked at MaxTwoFactorAttempts
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create trusted devices table (skip 2FA on a device for 30 days)
+CREATE TABLE trusted_devices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 of the trust token
+    device_fingerprint VARCHAR(255) NOT NULL,
+    user_agent TEXT,
+    ip_address INET,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create impersonation sessions table (support acting as a customer)
+CREATE TABLE impersonation_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    support_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    target_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    access_token_jti VARCHAR(255) UNIQUE NOT NULL,
+    ip_address INET,
+    user_agent TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create password history table (previous hashes, to block reuse)
+CREATE TABLE password_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
 -- Create security audit log table
 CREATE TABLE security_audit_log (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -114,10 +169,24 @@ CREATE TABLE properties (
     year_built INTEGER,
     property_type VARCHAR(100),
     notes TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'lead',
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    archived_at TIMESTAMP WITH TIME ZONE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Create property_status_history table
+CREATE TABLE property_status_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
 -- Create arv_calculations table
 CREATE TABLE arv_calculations (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -128,7 +197,7 @@ CREATE TABLE arv_calculations (
     holding_costs DECIMAL(12,2) DEFAULT 0,
     closing_costs DECIMAL(12,2) DEFAULT 0,
     arv DECIMAL(12,2) NOT NULL,
-    max_offer DECIMAL(12,2) GENERATED ALWAYS AS (arv * 0.7 - rehab_cost) STORED,
+    max_offer DECIMAL(12,2), -- at the calculation's rule percentage
     potential_profit DECIMAL(12,2) GENERATED ALWAYS AS (arv - purchase_price - rehab_cost - holding_costs - closing_costs) STORED,
     profit_margin DECIMAL(5,2) GENERATED ALWAYS AS (
         CASE 
@@ -137,6 +206,16 @@ CREATE TABLE arv_calculations (
             ELSE 0 
         END
     ) STORED,
+    total_investment DECIMAL(12,2),
+    monthly_cash_flow DECIMAL(12,2),
+    cash_on_cash_return DECIMAL(8,2),
+    is_infinite_return BOOLEAN NOT NULL DEFAULT FALSE,
+    cap_rate DECIMAL(8,2),
+    dscr DECIMAL(8,2),
+    risk_level VARCHAR(20),
+    rehab_cost_source VARCHAR(20) NOT NULL DEFAULT 'manual',
+    gross_rent_multiplier DECIMAL(8,2),
+    rent_to_price_ratio DECIMAL(6,3),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
@@ -159,6 +238,208 @@ CREATE TABLE comparables (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Create property_photos table
+CREATE TABLE property_photos (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    storage_key VARCHAR(500) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create property_tags table
+CREATE TABLE property_tags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    color VARCHAR(7) NOT NULL DEFAULT '#6B7280',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create property_tag_assignments table
+CREATE TABLE property_tag_assignments (
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tag_id UUID NOT NULL REFERENCES property_tags(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (property_id, tag_id)
+);
+
+-- Create property_share_links table
+CREATE TABLE property_share_links (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    view_count INTEGER NOT NULL DEFAULT 0,
+    last_viewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create property_offers table
+CREATE TABLE property_offers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    amount DECIMAL(12,2) NOT NULL,
+    counter_amount DECIMAL(12,2),
+    offer_date DATE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    financing_type VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'draft',
+    notes TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create property_offer_history table
+CREATE TABLE property_offer_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    offer_id UUID NOT NULL REFERENCES property_offers(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    amount DECIMAL(12,2),
+    note TEXT,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create rehab_items table
+CREATE TABLE rehab_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    category VARCHAR(30) NOT NULL,
+    description VARCHAR(500) NOT NULL,
+    estimated_cost DECIMAL(12,2) NOT NULL DEFAULT 0,
+    actual_cost DECIMAL(12,2),
+    status VARCHAR(20) NOT NULL DEFAULT 'planned',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create property_favorites table (each user's starred properties)
+CREATE TABLE property_favorites (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, property_id)
+);
+
+-- Create property_units table (rent roll of multi-unit properties)
+CREATE TABLE property_units (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    property_id UUID NOT NULL REFERENCES properties(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    label VARCHAR(50) NOT NULL,
+    bedrooms INTEGER DEFAULT 0,
+    bathrooms DECIMAL(3,1) DEFAULT 0,
+    square_feet INTEGER DEFAULT 0,
+    current_rent DECIMAL(10,2) NOT NULL DEFAULT 0,
+    market_rent DECIMAL(10,2) NOT NULL DEFAULT 0,
+    occupied BOOLEAN NOT NULL DEFAULT FALSE,
+    lease_end_date DATE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create comp_adjustment_settings table (tenants' own comp adjustment schedules,
+-- and vacancy and credit loss defaults tenant-wide or by state or zip prefix)
+CREATE TABLE comp_adjustment_settings (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    market VARCHAR(5) NOT NULL DEFAULT '',
+    per_bedroom DECIMAL(12,2) NOT NULL DEFAULT 5000,
+    per_bathroom DECIMAL(12,2) NOT NULL DEFAULT 3000,
+    per_sqft DECIMAL(10,2) NOT NULL DEFAULT 50,
+    derive_per_sqft BOOLEAN NOT NULL DEFAULT FALSE,
+    vacancy_rate DECIMAL(5,2),
+    credit_loss_rate DECIMAL(5,2),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, market)
+);
+
+-- Create usage_records table (ARV calculations each tenant has run a month)
+CREATE TABLE usage_records (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    month DATE NOT NULL, -- first of the month, UTC
+    arv_calculations INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, month)
+);
+
+-- Create api_usage table (API-key requests each tenant makes a day, billed beyond the plan's included calls)
+CREATE TABLE api_usage (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    day DATE NOT NULL, -- UTC
+    requests INTEGER NOT NULL DEFAULT 0,
+    overage INTEGER NOT NULL DEFAULT 0, -- calls billed, set when reported
+    reported_at TIMESTAMP WITH TIME ZONE, -- reported to Stripe; unset days are retried
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, day)
+);
+
+-- Create subscriptions table (tenants' Stripe subscriptions, synced by webhooks)
+CREATE TABLE subscriptions (
+    tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    stripe_customer_id VARCHAR(255) NOT NULL,
+    stripe_subscription_id VARCHAR(255) UNIQUE,
+    tier VARCHAR(50) NOT NULL DEFAULT 'starter',
+    status VARCHAR(50) NOT NULL,
+    current_period_end TIMESTAMP WITH TIME ZONE,
+    trial_end TIMESTAMP WITH TIME ZONE,
+    cancel_at TIMESTAMP WITH TIME ZONE, -- set while a cancellation is pending
+    grace_period_ends_at TIMESTAMP WITH TIME ZONE, -- set while a failed payment is outstanding
+    seats INTEGER NOT NULL DEFAULT 1, -- bought, each active member takes one (Enterprise)
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create report_purchases table (paid one-time reports, each used once)
+CREATE TABLE report_purchases (
+    payment_intent_id VARCHAR(255) PRIMARY KEY,
+    tenant_id UUID REFERENCES tenants(id) ON DELETE CASCADE,
+    property_id VARCHAR(255) NOT NULL,
+    stripe_customer_id VARCHAR(255),
+    amount INTEGER NOT NULL, -- cents
+    consumed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create stripe_events table (webhook events already handled)
+CREATE TABLE stripe_events (
+    id VARCHAR(255) PRIMARY KEY,
+    type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    processed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create stripe_customers table (each tenant's one Stripe customer)
+CREATE TABLE stripe_customers (
+    tenant_id UUID PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    stripe_customer_id VARCHAR(255) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL,
+    billing_address JSONB, -- for Stripe Tax
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create idempotency_keys table (payment responses replayed to retries for a day)
+CREATE TABLE idempotency_keys (
+    key VARCHAR(64) PRIMARY KEY,
+    operation VARCHAR(100) NOT NULL,
+    response JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
 -- Create indexes for performance and security
 CREATE INDEX idx_users_tenant_id ON users(tenant_id);
 CREATE INDEX idx_users_email ON users(email);
@@ -167,19 +448,34 @@ CREATE INDEX idx_users_password_reset_token ON users(password_reset_token);
 CREATE INDEX idx_users_phone_number ON users(phone_number);
 CREATE INDEX idx_users_last_login_at ON users(last_login_at);
 CREATE INDEX idx_users_locked_until ON users(locked_until);
+CREATE INDEX idx_users_purge_after ON users(purge_after) WHERE purge_after IS NOT NULL;
 
 -- Session management indexes
 CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
-CREATE INDEX idx_user_sessions_refresh_token ON user_sessions(refresh_token);
 CREATE INDEX idx_user_sessions_access_token_jti ON user_sessions(access_token_jti);
 CREATE INDEX idx_user_sessions_expires_at ON user_sessions(expires_at);
 CREATE INDEX idx_user_sessions_revoked ON user_sessions(revoked);
 
 -- SMS 2FA indexes
-CREATE INDEX idx_sms_verification_codes_user_id ON sms_verification_codes(user_id);
-CREATE INDEX idx_sms_verification_codes_phone ON sms_verification_codes(phone_number);
-CREATE INDEX idx_sms_verification_codes_expires_at ON sms_verification_codes(expires_at);
-CREATE INDEX idx_sms_verification_codes_purpose ON sms_verification_codes(purpose);
+CREATE INDEX idx_verification_codes_user_id ON verification_codes(user_id);
+CREATE INDEX idx_verification_codes_phone ON verification_codes(phone_number);
+CREATE INDEX idx_verification_codes_expires_at ON verification_codes(expires_at);
+CREATE INDEX idx_verification_codes_purpose ON verification_codes(purpose);
+
+-- 2FA challenge indexes
+CREATE INDEX idx_two_factor_challenges_user_id ON two_factor_challenges(user_id);
+CREATE INDEX idx_two_factor_challenges_expires_at ON two_factor_challenges(expires_at);
+
+-- Trusted device indexes
+CREATE INDEX idx_trusted_devices_user_id ON trusted_devices(user_id);
+CREATE INDEX idx_trusted_devices_expires_at ON trusted_devices(expires_at);
+
+-- Impersonation indexes
+CREATE INDEX idx_impersonation_sessions_support_user_id ON impersonation_sessions(support_user_id);
+CREATE INDEX idx_impersonation_sessions_target_user_id ON impersonation_sessions(target_user_id);
+
+-- Password history indexes
+CREATE INDEX idx_password_history_user_id ON password_history(user_id, created_at);
 
 -- Security audit indexes
 CREATE INDEX idx_security_audit_log_user_id ON security_audit_log(user_id);
@@ -195,10 +491,31 @@ CREATE INDEX idx_rate_limits_blocked_until ON rate_limits(blocked_until);
 -- Property indexes
 CREATE INDEX idx_properties_tenant_id ON properties(tenant_id);
 CREATE INDEX idx_properties_address ON properties(address);
+CREATE INDEX idx_properties_tenant_id_active ON properties(tenant_id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_properties_tenant_id_status ON properties(tenant_id, status) WHERE deleted_at IS NULL;
+CREATE INDEX idx_properties_tenant_id_unarchived ON properties(tenant_id) WHERE deleted_at IS NULL AND archived_at IS NULL;
+CREATE INDEX idx_property_status_history_property_id ON property_status_history(property_id, created_at);
 CREATE INDEX idx_arv_calculations_tenant_id ON arv_calculations(tenant_id);
 CREATE INDEX idx_arv_calculations_property_id ON arv_calculations(property_id);
+CREATE INDEX idx_arv_calculations_property_id_created_at ON arv_calculations(property_id, created_at DESC);
 CREATE INDEX idx_comparables_property_id ON comparables(property_id);
 CREATE INDEX idx_comparables_sale_date ON comparables(sale_date);
+CREATE INDEX idx_property_photos_property_id ON property_photos(property_id, position);
+CREATE UNIQUE INDEX idx_property_tags_tenant_id_name ON property_tags(tenant_id, LOWER(name));
+CREATE INDEX idx_property_tag_assignments_tag_id ON property_tag_assignments(tag_id);
+CREATE INDEX idx_property_share_links_property_id ON property_share_links(property_id, created_at);
+CREATE UNIQUE INDEX idx_property_offers_one_accepted ON property_offers(property_id) WHERE status = 'accepted';
+CREATE INDEX idx_property_offers_property_id ON property_offers(property_id, created_at);
+CREATE INDEX idx_property_offers_tenant_id_outstanding ON property_offers(tenant_id, expires_at)
+    WHERE status IN ('submitted', 'countered');
+CREATE INDEX idx_property_offer_history_offer_id ON property_offer_history(offer_id, created_at);
+CREATE INDEX idx_rehab_items_property_id ON rehab_items(property_id, created_at);
+CREATE INDEX idx_property_favorites_property_id ON property_favorites(property_id);
+CREATE UNIQUE INDEX idx_property_units_property_id_label ON property_units(property_id, LOWER(label));
+CREATE INDEX idx_subscriptions_stripe_customer_id ON subscriptions(stripe_customer_id);
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+CREATE INDEX idx_report_purchases_unused ON report_purchases(tenant_id, property_id, created_at) WHERE consumed_at IS NULL;
+CREATE INDEX idx_api_usage_unreported ON api_usage(day) WHERE reported_at IS NULL;
 
 -- Create updated_at trigger function
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -232,21 +549,46 @@ ALTER TABLE users ADD CONSTRAINT check_phone_format
 ALTER TABLE users ADD CONSTRAINT check_failed_login_attempts 
     CHECK (failed_login_attempts >= 0 AND failed_login_attempts <= 100);
 
-ALTER TABLE sms_verification_codes ADD CONSTRAINT check_code_length 
-    CHECK (LENGTH(code) >= 4 AND LENGTH(code) <= 10);
-
-ALTER TABLE sms_verification_codes ADD CONSTRAINT check_attempts_range 
+ALTER TABLE verification_codes ADD CONSTRAINT check_attempts_range 
     CHECK (attempts >= 0 AND attempts <= max_attempts);
 
 ALTER TABLE rate_limits ADD CONSTRAINT check_attempts_positive 
     CHECK (attempts > 0);
 
+ALTER TABLE properties ADD CONSTRAINT check_property_status
+    CHECK (status IN ('lead', 'analyzing', 'offer_made', 'under_contract', 'rehabbing', 'rented', 'sold', 'dead'));
+
+ALTER TABLE property_offers ADD CONSTRAINT check_offer_status
+    CHECK (status IN ('draft', 'submitted', 'countered', 'accepted', 'rejected'));
+
+ALTER TABLE property_offers ADD CONSTRAINT check_offer_financing_type
+    CHECK (financing_type IN ('cash', 'conventional', 'fha', 'va', 'hard_money', 'private_money', 'seller_financing', 'other'));
+
+ALTER TABLE property_offers ADD CONSTRAINT check_offer_amounts
+    CHECK (amount > 0 AND (counter_amount IS NULL OR counter_amount > 0));
+
+ALTER TABLE rehab_items ADD CONSTRAINT check_rehab_item_category
+    CHECK (category IN ('roof', 'kitchen', 'bathroom', 'hvac', 'plumbing', 'electrical', 'flooring', 'paint',
+        'windows_doors', 'foundation', 'exterior', 'landscaping', 'permits', 'other'));
+
+ALTER TABLE rehab_items ADD CONSTRAINT check_rehab_item_status
+    CHECK (status IN ('planned', 'in_progress', 'completed'));
+
+ALTER TABLE rehab_items ADD CONSTRAINT check_rehab_item_costs
+    CHECK (estimated_cost >= 0 AND (actual_cost IS NULL OR actual_cost >= 0));
+
+ALTER TABLE property_units ADD CONSTRAINT check_unit_rents
+    CHECK (current_rent >= 0 AND market_rent >= 0);
+
+ALTER TABLE arv_calculations ADD CONSTRAINT check_arv_calculation_rehab_cost_source
+    CHECK (rehab_cost_source IN ('manual', 'rehab_items'));
+
 -- Create function to clean up expired records
 CREATE OR REPLACE FUNCTION cleanup_expired_records()
 RETURNS void AS $$
 BEGIN
-    -- Clean up expired SMS verification codes
-    DELETE FROM sms_verification_codes WHERE expires_at < NOW() - INTERVAL '1 day';
+    -- Clean up expired 2FA verification codes
+    DELETE FROM verification_codes WHERE expires_at < NOW() - INTERVAL '1 day';
     
     -- Clean up expired user sessions
     DELETE FROM user_sessions WHERE expires_at < NOW();
@@ -277,8 +619,8 @@ CREATE TRIGGER trigger_check_and_lock_user BEFORE UPDATE ON users
 INSERT INTO tenants (id, name, subscription_tier) VALUES 
     ('00000000-0000-0000-0000-000000000001', 'Demo Tenant', 'professional');
 
-INSERT INTO users (id, tenant_id, email, password_hash, password_salt, first_name, last_name, role, email_verified) VALUES 
-    ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000001', 'demo@arvfinder.com', '$2a$10$dummy.hash.for.demo.user', 'dummy_salt_for_demo', 'Demo', 'User', 'admin', TRUE);
+INSERT INTO users (id, tenant_id, email, password_hash, first_name, last_name, role, email_verified) VALUES 
+    ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000001', 'demo@arvfinder.com', '$2a$10$dummy.hash.for.demo.user', 'Demo', 'User', 'admin', TRUE);
 
 INSERT INTO properties (tenant_id, address, city, state, zip_code, price, arv, bedrooms, bathrooms, square_feet, property_type) VALUES 
     ('00000000-0000-0000-0000-000000000001', '123 Main St', 'Denver', 'CO', '80202', 180000, 250000, 3, 2, 1200, 'Single Family'),
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler lets tenant admins manage their tenant's users and support
+// staff impersonate customers
+type AdminHandler struct {
+	authService  *services.AuthService
+	adminService *services.TenantAdminService
+	seats        *services.SeatManager
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(authService *services.AuthService) *AdminHandler {
+	return &AdminHandler{
+		authService:  authService,
+		adminService: services.NewTenantAdminService(database.GetDB(), authService),
+	}
+}
+
+// SetSeatManager gives deactivated members' seats back, so Enterprise
+// tenants stop paying for them at the end of the period
+func (h *AdminHandler) SetSeatManager(seats *services.SeatManager) {
+	h.seats = seats
+}
+
+// ChangeRoleRequest sets a member's role
+type ChangeRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// ListUsers returns the users in the caller's tenant
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	users, err := h.adminService.ListUsers(c.GetString("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load users",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"users":   users,
+	})
+}
+
+// ChangeRole changes the role of a user in the caller's tenant
+func (h *AdminHandler) ChangeRole(c *gin.Context) {
+	var req ChangeRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	targetID := c.Param("id")
+	err := h.adminService.ChangeRole(c.GetString("tenant_id"), targetID, req.Role)
+	if h.respondAdminError(c, err, "Failed to change role") {
+		return
+	}
+
+	h.authService.LogSecurityEvent(c.GetString("user_id"), "admin_role_changed", "Admin changed a user's role",
+		c.ClientIP(), c.GetHeader("User-Agent"), map[string]interface{}{
+			"target_user_id": targetID,
+			"role":           req.Role,
+		})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Role updated",
+	})
+}
+
+// DeactivateUser signs a user in the caller's tenant out everywhere and
+// stops them signing in again
+func (h *AdminHandler) DeactivateUser(c *gin.Context) {
+	targetID := c.Param("id")
+	err := h.adminService.DeactivateUser(c.GetString("tenant_id"), targetID)
+	if h.respondAdminError(c, err, "Failed to deactivate user") {
+		return
+	}
+
+	h.authService.LogSecurityEvent(c.GetString("user_id"), "admin_user_deactivated", "Admin deactivated a user",
+		c.ClientIP(), c.GetHeader("User-Agent"), map[string]interface{}{
+			"target_user_id": targetID,
+		})
+	if h.seats != nil {
+		if err := h.seats.ReleaseSeat(c.GetString("tenant_id")); err != nil {
+			log.Printf("Failed to release seat: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "User deactivated",
+	})
+}
+
+// Impersonate issues a support user a 15-minute token that acts as another
+// user. Everything done with it is audited and destructive operations are
+// refused.
+func (h *AdminHandler) Impersonate(c *gin.Context) {
+	supportUserID := c.GetString("user_id")
+	targetID := c.Param("userID")
+
+	token, err := h.authService.Impersonate(supportUserID, targetID, c.ClientIP(), c.GetHeader("User-Agent"))
+	switch {
+	case errors.Is(err, services.ErrCannotImpersonate):
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Support users can't be impersonated",
+		})
+		return
+	case h.respondAdminError(c, err, "Failed to start impersonation"):
+		return
+	}
+
+	h.authService.LogSecurityEvent(targetID, "impersonation_started", "Support started impersonating this user",
+		c.ClientIP(), c.GetHeader("User-Agent"), map[string]interface{}{
+			"impersonator_id": supportUserID,
+			"expires_at":      token.ExpiresAt,
+		})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tokens":  token,
+	})
+}
+
+// EndImpersonation ends the impersonation the caller's token belongs to
+// before it expires
+func (h *AdminHandler) EndImpersonation(c *gin.Context) {
+	impersonatorID := c.GetString("impersonator_id")
+	if impersonatorID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Not impersonating a user",
+		})
+		return
+	}
+
+	if err := h.authService.EndImpersonation(c.GetString("token_jti")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to end impersonation",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(c.GetString("user_id"), "impersonation_ended", "Support stopped impersonating this user",
+		c.ClientIP(), c.GetHeader("User-Agent"), map[string]interface{}{
+			"impersonator_id": impersonatorID,
+		})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Impersonation ended",
+	})
+}
+
+// respondAdminError writes the response for a failed admin change and
+// reports whether there was one
+func (h *AdminHandler) respondAdminError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "User not found",
+		})
+	case errors.Is(err, services.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Role must be admin or user",
+		})
+	case errors.Is(err, services.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "The account must keep at least one active admin",
+			"code":    "LAST_ADMIN",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": fallback,
+		})
+	}
+	return true
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"arvfinder-backend/database"
 	"arvfinder-backend/services"
 	
 	"github.com/gin-gonic/gin"
@@ -9,18 +12,25 @@ import (
 
 // ArvHandler handles ARV-related endpoints
 type ArvHandler struct {
-	arvService *services.ArvService
+	arvService     *services.ArvService
+	marketDefaults *services.MarketDefaultsRepository
 }
 
 // NewArvHandler creates a new ARV handler
 func NewArvHandler() *ArvHandler {
 	return &ArvHandler{
-		arvService: services.NewArvService(),
+		arvService:     services.NewArvService(),
+		marketDefaults: services.NewMarketDefaultsRepository(database.GetDB()),
 	}
 }
 
 // CalculateARV handles ARV calculation requests
 func (h *ArvHandler) CalculateARV(c *gin.Context) {
+	if c.Query("mode") == "draft" {
+		h.calculateDraft(c)
+		return
+	}
+
 	var req services.ArvRequest
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -30,10 +40,376 @@ func (h *ArvHandler) CalculateARV(c *gin.Context) {
 		})
 		return
 	}
+
+	// Signed-in callers' deals assume their own vacancy and credit loss
+	// for the deal's market
+	if tenantID := c.GetString("tenant_id"); tenantID != "" {
+		defaults, err := h.marketDefaults.Resolve(tenantID, req.State, req.ZipCode)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to load market defaults",
+			})
+			return
+		}
+		req.MarketDefaults = &defaults
+	}
 	
 	// Perform ARV calculation
 	result := h.arvService.CalculateARV(req)
 	
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": arvResultData(c, result),
+	})
+}
+
+// arvResultData returns result as the caller asked for it. Clients that
+// still expect plain string warnings and recommendations pass
+// response_version=1.
+func arvResultData(c *gin.Context, result services.ArvResult) interface{} {
+	if c.Query("response_version") == "1" {
+		return result.Legacy()
+	}
+	return result
+}
+
+// calculateDraft handles mode=draft requests where only some inputs are known.
+// Draft results are returned to the caller only and never saved.
+func (h *ArvHandler) calculateDraft(c *gin.Context) {
+	var req services.DraftArvRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.CalculateDraft(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculateFlip handles fix & flip analysis requests
+func (h *ArvHandler) CalculateFlip(c *gin.Context) {
+	var req services.FlipRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.CalculateFlip(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculateAmortization handles amortization schedule requests
+func (h *ArvHandler) CalculateAmortization(c *gin.Context) {
+	var req services.AmortizationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.CalculateAmortization(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculateEquityChart handles equity build chart requests. Points are
+// yearly unless ?granularity=monthly is given.
+func (h *ArvHandler) CalculateEquityChart(c *gin.Context) {
+	var req services.EquityChartRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.arvService.CalculateEquityChart(req, c.Query("granularity"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculateSensitivity handles sensitivity analysis requests
+func (h *ArvHandler) CalculateSensitivity(c *gin.Context) {
+	var req services.SensitivityRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.arvService.CalculateSensitivity(req)
+	if errors.Is(err, services.ErrInvalidSensitivityRange) || errors.Is(err, services.ErrTooManyScenarios) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to run sensitivity analysis",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// ProjectCashFlows handles multi-year cash flow and equity projection requests
+func (h *ArvHandler) ProjectCashFlows(c *gin.Context) {
+	var req services.ProjectionRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.ProjectCashFlows(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculateWholesale handles wholesale deal requests
+func (h *ArvHandler) CalculateWholesale(c *gin.Context) {
+	var req services.WholesaleRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.arvService.CalculateWholesale(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// SolveMaxOffer handles target-profit offer requests
+func (h *ArvHandler) SolveMaxOffer(c *gin.Context) {
+	var req services.MaxOfferRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.arvService.SolveMaxOffer(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculatePartnershipSplit handles partner equity split requests
+func (h *ArvHandler) CalculatePartnershipSplit(c *gin.Context) {
+	var req services.PartnershipRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.arvService.CalculatePartnershipSplit(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CompareStrategies handles flip, BRRRR and rental comparison requests
+func (h *ArvHandler) CompareStrategies(c *gin.Context) {
+	var req services.StrategyComparisonRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.CompareStrategies(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// QuickScreen handles GRM and 1%/2% rule screening requests
+func (h *ArvHandler) QuickScreen(c *gin.Context) {
+	var req services.QuickScreenRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.QuickScreen(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculateCreativeFinance handles subject-to and seller financing requests
+func (h *ArvHandler) CalculateCreativeFinance(c *gin.Context) {
+	var req services.CreativeFinanceRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.CalculateCreativeFinance(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculateMortgagePayment handles PITI mortgage payment requests
+func (h *ArvHandler) CalculateMortgagePayment(c *gin.Context) {
+	var req services.MortgagePaymentRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.CalculateMortgagePayment(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// AnalyzeRefinance handles refinance break-even requests
+func (h *ArvHandler) AnalyzeRefinance(c *gin.Context) {
+	var req services.RefinanceRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.AnalyzeRefinance(req)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": result,
+	})
+}
+
+// CalculateSaleProceeds handles net sale proceeds and capital gains requests
+func (h *ArvHandler) CalculateSaleProceeds(c *gin.Context) {
+	var req services.SaleProceedsRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result := h.arvService.CalculateSaleProceeds(req)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": result,
@@ -43,8 +419,9 @@ func (h *ArvHandler) CalculateARV(c *gin.Context) {
 // Calculate70Rule handles 70% rule calculation requests
 func (h *ArvHandler) Calculate70Rule(c *gin.Context) {
 	var req struct {
-		ARV       float64 `json:"arv" binding:"required,min=1"`
-		RehabCost float64 `json:"rehab_cost" binding:"min=0"`
+		ARV            float64 `json:"arv" binding:"required,min=1"`
+		RehabCost      float64 `json:"rehab_cost" binding:"min=0"`
+		RulePercentage float64 `json:"rule_percentage" binding:"omitempty,min=50,max=90"`
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -55,7 +432,11 @@ func (h *ArvHandler) Calculate70Rule(c *gin.Context) {
 		return
 	}
 	
-	maxOffer := h.arvService.Calculate70Rule(req.ARV, req.RehabCost)
+	rule := req.RulePercentage
+	if rule == 0 {
+		rule = services.DefaultRulePercentage
+	}
+	maxOffer := h.arvService.CalculateRule(req.ARV, req.RehabCost, rule)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -63,7 +444,8 @@ func (h *ArvHandler) Calculate70Rule(c *gin.Context) {
 			"arv": req.ARV,
 			"rehab_cost": req.RehabCost,
 			"max_offer": maxOffer,
-			"rule": "70% Rule: Max offer = (ARV × 0.70) - Rehab costs",
+			"rule_percentage": rule,
+			"rule": fmt.Sprintf("%g%% Rule: Max offer = (ARV × %.2f) - Rehab costs", rule, rule/100),
 		},
 	})
 }
@@ -72,7 +454,7 @@ func (h *ArvHandler) Calculate70Rule(c *gin.Context) {
 func (h *ArvHandler) CalculateROI(c *gin.Context) {
 	var req struct {
 		Profit     float64 `json:"profit" binding:"required"`
-		Investment float64 `json:"investment" binding:"required,min=1"`
+		Investment float64 `json:"investment"`
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -83,7 +465,11 @@ func (h *ArvHandler) CalculateROI(c *gin.Context) {
 		return
 	}
 	
-	roi := h.arvService.CalculateROI(req.Profit, req.Investment)
+	roi, err := h.arvService.CalculateROI(req.Profit, req.Investment)
+	if err != nil {
+		invalidCalculatorInput(c, err)
+		return
+	}
 	
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -100,7 +486,7 @@ func (h *ArvHandler) CalculateROI(c *gin.Context) {
 func (h *ArvHandler) CalculateCashOnCash(c *gin.Context) {
 	var req struct {
 		AnnualCashFlow     float64 `json:"annual_cash_flow" binding:"required"`
-		TotalCashInvested  float64 `json:"total_cash_invested" binding:"required,min=1"`
+		TotalCashInvested  float64 `json:"total_cash_invested"`
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -111,7 +497,11 @@ func (h *ArvHandler) CalculateCashOnCash(c *gin.Context) {
 		return
 	}
 	
-	cashOnCashReturn := h.arvService.CalculateCashOnCashReturn(req.AnnualCashFlow, req.TotalCashInvested)
+	cashOnCashReturn, err := h.arvService.CalculateCashOnCashReturn(req.AnnualCashFlow, req.TotalCashInvested)
+	if err != nil {
+		invalidCalculatorInput(c, err)
+		return
+	}
 	
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -127,7 +517,7 @@ func (h *ArvHandler) CalculateCashOnCash(c *gin.Context) {
 func (h *ArvHandler) CalculateCapRate(c *gin.Context) {
 	var req struct {
 		NetOperatingIncome float64 `json:"net_operating_income" binding:"required"`
-		PropertyValue      float64 `json:"property_value" binding:"required,min=1"`
+		PropertyValue      float64 `json:"property_value"`
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -138,7 +528,11 @@ func (h *ArvHandler) CalculateCapRate(c *gin.Context) {
 		return
 	}
 	
-	capRate := h.arvService.CalculateCapRate(req.NetOperatingIncome, req.PropertyValue)
+	capRate, err := h.arvService.CalculateCapRate(req.NetOperatingIncome, req.PropertyValue)
+	if err != nil {
+		invalidCalculatorInput(c, err)
+		return
+	}
 	
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -150,6 +544,29 @@ func (h *ArvHandler) CalculateCapRate(c *gin.Context) {
 	})
 }
 
+// calculatorErrorCodes are the codes clients get for the inputs the ROI,
+// cash-on-cash and cap rate calculators reject
+var calculatorErrorCodes = map[error]string{
+	services.ErrInvestmentNotPositive:    "INVESTMENT_NOT_POSITIVE",
+	services.ErrPropertyValueNotPositive: "PROPERTY_VALUE_NOT_POSITIVE",
+	services.ErrInputNotFinite:           "INPUT_NOT_FINITE",
+}
+
+// invalidCalculatorInput responds to a calculator rejecting its inputs,
+// rather than reporting a 0% return
+func invalidCalculatorInput(c *gin.Context, err error) {
+	response := gin.H{
+		"error": "Invalid request data",
+		"details": err.Error(),
+	}
+	for target, code := range calculatorErrorCodes {
+		if errors.Is(err, target) {
+			response["code"] = code
+		}
+	}
+	c.JSON(http.StatusBadRequest, response)
+}
+
 // EstimateARVFromComps handles ARV estimation from comparable properties
 func (h *ArvHandler) EstimateARVFromComps(c *gin.Context) {
 	var req struct {
@@ -157,6 +574,51 @@ func (h *ArvHandler) EstimateARVFromComps(c *gin.Context) {
 		SubjectBedrooms   int                          `json:"subject_bedrooms" binding:"required,min=0"`
 		SubjectBathrooms  float64                      `json:"subject_bathrooms" binding:"required,min=0"`
 		SubjectSquareFeet int                          `json:"subject_square_feet" binding:"required,min=1"`
+		services.CompEstimateOptions
+	}
+	
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+	
+	estimate := h.arvService.EstimateARVFromCompsBreakdown(
+		req.Comparables,
+		req.SubjectBedrooms,
+		req.SubjectBathrooms,
+		req.SubjectSquareFeet,
+		req.CompEstimateOptions,
+	)
+	
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"estimated_arv": estimate.EstimatedARV,
+			"median_adjusted_value": estimate.MedianAdjustedValue,
+			"standard_deviation": estimate.StandardDeviation,
+			"confidence": estimate.Confidence,
+			"comparables": estimate.Comparables,
+			"comparables_used": len(req.Comparables),
+			"adjustment_schedule": estimate.AdjustmentSchedule,
+			"subject_property": gin.H{
+				"bedrooms": req.SubjectBedrooms,
+				"bathrooms": req.SubjectBathrooms,
+				"square_feet": req.SubjectSquareFeet,
+			},
+		},
+	})
+}
+
+// EstimateRentFromComps handles monthly rent estimation from rental comps
+func (h *ArvHandler) EstimateRentFromComps(c *gin.Context) {
+	var req struct {
+		Comparables       []services.RentComp `json:"comparables" binding:"required,min=1,dive"`
+		SubjectBedrooms   int                 `json:"subject_bedrooms" binding:"min=0"`
+		SubjectBathrooms  float64             `json:"subject_bathrooms" binding:"min=0"`
+		SubjectSquareFeet int                 `json:"subject_square_feet" binding:"required,min=1"`
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -167,7 +629,7 @@ func (h *ArvHandler) EstimateARVFromComps(c *gin.Context) {
 		return
 	}
 	
-	estimatedARV := h.arvService.EstimateARVFromComps(
+	estimate := h.arvService.EstimateRentFromComps(
 		req.Comparables,
 		req.SubjectBedrooms,
 		req.SubjectBathrooms,
@@ -177,8 +639,13 @@ func (h *ArvHandler) EstimateARVFromComps(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"estimated_arv": estimatedARV,
+			"estimated_rent": estimate.EstimatedRent,
+			"median_adjusted_rent": estimate.MedianAdjustedRent,
+			"standard_deviation": estimate.StandardDeviation,
+			"confidence": estimate.Confidence,
+			"comparables": estimate.Comparables,
 			"comparables_used": len(req.Comparables),
+			"rent_source": services.RentSourceRentComps,
 			"subject_property": gin.H{
 				"bedrooms": req.SubjectBedrooms,
 				"bathrooms": req.SubjectBathrooms,
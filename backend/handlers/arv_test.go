@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculatorsRejectNonPositiveDenominators(t *testing.T) {
+	handler := &ArvHandler{arvService: services.NewArvService()}
+	cases := []struct {
+		name    string
+		handler gin.HandlerFunc
+		body    string
+		code    string
+	}{
+		{"roi", handler.CalculateROI, `{"profit": 10000, "investment": 0}`, "INVESTMENT_NOT_POSITIVE"},
+		{"cash on cash", handler.CalculateCashOnCash, `{"annual_cash_flow": 1200, "total_cash_invested": -5000}`, "INVESTMENT_NOT_POSITIVE"},
+		{"cap rate", handler.CalculateCapRate, `{"net_operating_income": 15000}`, "PROPERTY_VALUE_NOT_POSITIVE"},
+	}
+	for _, tc := range cases {
+		w := performComparableRequest(tc.handler, "", http.MethodPost, tc.body)
+
+		require.Equal(t, http.StatusBadRequest, w.Code, tc.name)
+		var resp struct {
+			Code string `json:"code"`
+		}
+		decodeJSON(t, w, &resp)
+		assert.Equal(t, tc.code, resp.Code, tc.name)
+	}
+}
+
+func TestCalculateCapRate_ValidInput(t *testing.T) {
+	handler := &ArvHandler{arvService: services.NewArvService()}
+
+	w := performComparableRequest(handler.CalculateCapRate, "", http.MethodPost, `{"net_operating_income": 15000, "property_value": 200000}`)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Success bool               `json:"success"`
+		Data    map[string]float64 `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.True(t, resp.Success)
+	assert.Equal(t, map[string]float64{"net_operating_income": 15000, "property_value": 200000, "cap_rate": 7.5}, resp.Data)
+}
+
+func TestCalculateROI_RejectsNonFiniteJSON(t *testing.T) {
+	handler := &ArvHandler{arvService: services.NewArvService()}
+
+	w := performComparableRequest(handler.CalculateROI, "", http.MethodPost, `{"profit": 1e400, "investment": 100000}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
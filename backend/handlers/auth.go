@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -13,24 +17,35 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // AuthHandler handles authentication-related HTTP requests
 type AuthHandler struct {
-	authService   *services.AuthService
-	rateLimiter   *services.RateLimiter
-	sms2FAService *services.SMS2FAService
-	db            *sql.DB
+	authService    *services.AuthService
+	rateLimiter    *services.RateLimiter
+	sms2FAService  *services.SMS2FAService
+	totp2FAService *services.TOTP2FAService
+	passwordReset  *services.PasswordResetService
+	emailVerifier  *services.EmailVerificationService
+	emailChanger   *services.EmailChangeService
+	emailSender    services.EmailSender
+	emailDomains   *services.EmailDomainValidator
+	captcha        services.CaptchaVerifier // nil disables CAPTCHA checks
+	db             *sql.DB
 }
 
 // LoginResponse represents the response for successful login
 type LoginResponse struct {
-	Success      bool                    `json:"success"`
-	Message      string                  `json:"message"`
-	User         *services.User          `json:"user,omitempty"`
-	Tokens       *services.TokenPair     `json:"tokens,omitempty"`
-	Requires2FA  bool                    `json:"requires_2fa"`
-	TempToken    string                  `json:"temp_token,omitempty"` // For 2FA flow
+	Success            bool                `json:"success"`
+	Message            string              `json:"message"`
+	User               *services.User      `json:"user,omitempty"`
+	Tokens             *services.TokenPair `json:"tokens,omitempty"`
+	Requires2FA        bool                `json:"requires_2fa"`
+	TwoFactorMethod    string              `json:"two_factor_method,omitempty"`    // "sms" or "totp" when Requires2FA
+	UserID             string              `json:"user_id,omitempty"`              // For 2FA flow
+	TempToken          string              `json:"temp_token,omitempty"`           // For 2FA flow
+	TrustedDeviceToken string              `json:"trusted_device_token,omitempty"` // When Verify2FA was asked to trust the device
 }
 
 // RegisterResponse represents the response for registration
@@ -41,32 +56,32 @@ type RegisterResponse struct {
 	RequiresVerification bool `json:"requires_verification"`
 }
 
-// NewAuthHandler creates a new authentication handler
-func NewAuthHandler() *AuthHandler {
+// NewAuthHandler creates a new authentication handler. authService is shared
+// with AuthMiddleware so both see the same session cache.
+func NewAuthHandler(authService *services.AuthService) *AuthHandler {
 	// Get database connection
 	db := database.GetDB()
-	
-	// Get JWT secret from environment
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "your-super-secret-jwt-key-change-in-production" // Default for development
-	}
 
 	// Initialize services
-	authService := services.NewAuthService(db, jwtSecret)
 	rateLimiter := services.NewRateLimiter(db)
 	
 	// Initialize SMS 2FA service
-	twilioSID := os.Getenv("TWILIO_ACCOUNT_SID")
-	twilioToken := os.Getenv("TWILIO_AUTH_TOKEN")
-	twilioPhone := os.Getenv("TWILIO_PHONE_NUMBER")
-	sms2FAService := services.NewSMS2FAService(db, authService, twilioSID, twilioToken, twilioPhone)
+	sms2FAService := services.NewSMS2FAServiceFromEnv(db, authService)
+
+	emailSender := services.NewEmailSenderFromEnv()
 
 	return &AuthHandler{
-		authService:   authService,
-		rateLimiter:   rateLimiter,
-		sms2FAService: sms2FAService,
-		db:            db,
+		authService:    authService,
+		rateLimiter:    rateLimiter,
+		sms2FAService:  sms2FAService,
+		totp2FAService: services.NewTOTP2FAService(db, services.TOTPKeyFromEnv()),
+		passwordReset:  services.NewPasswordResetService(db, authService, emailSender),
+		emailVerifier:  services.NewEmailVerificationService(db, emailSender),
+		emailChanger:   services.NewEmailChangeService(db, authService, emailSender),
+		emailSender:    emailSender,
+		emailDomains:   services.NewEmailDomainValidatorFromEnv(),
+		captcha:        services.NewCaptchaVerifierFromEnv(),
+		db:             db,
 	}
 }
 
@@ -107,6 +122,10 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	if h.captcha != nil && !h.verifyCaptcha(c, req.CaptchaToken, clientIP) {
+		return
+	}
+
 	// Validate password strength
 	if !h.isPasswordStrong(req.Password) {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -116,27 +135,18 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Check if user already exists
-	var existingUserID string
-	err = h.db.QueryRow("SELECT id FROM users WHERE email = $1", req.Email).Scan(&existingUserID)
-	if err != sql.ErrNoRows {
-		c.JSON(http.StatusConflict, gin.H{
-			"success": false,
-			"message": "User with this email already exists",
-		})
-		return
-	}
-
-	// Create or get tenant
-	tenantID, err := h.createOrGetTenant(req.TenantName, req.Email)
-	if err != nil {
-		h.authService.LogSecurityEvent("", "registration_failed", "Failed to create tenant", clientIP, userAgent, map[string]interface{}{
-			"email": req.Email,
-			"error": err.Error(),
-		})
-		c.JSON(http.StatusInternalServerError, gin.H{
+	// Reject throwaway and undeliverable addresses
+	if err := h.emailDomains.Validate(req.Email); err != nil {
+		code := "EMAIL_DOMAIN_INVALID"
+		message := "This email domain can't receive mail. Please use a different address."
+		if errors.Is(err, services.ErrDisposableEmail) {
+			code = "DISPOSABLE_EMAIL"
+			message = "Disposable email addresses are not allowed. Please use a permanent address."
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "Failed to create account",
+			"message": message,
+			"code":    code,
 		})
 		return
 	}
@@ -151,23 +161,20 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// The encoded hash embeds its salt, so nothing else needs storing
 	passwordHash := h.authService.HashPassword(req.Password, salt)
-	saltString := string(salt)
-
-	// Generate email verification token
-	emailVerificationToken := uuid.New().String()
-	emailVerificationExpires := time.Now().Add(24 * time.Hour)
-
-	// Create user
-	userID := uuid.New().String()
-	_, err = h.db.Exec(`
-		INSERT INTO users (
-			id, tenant_id, email, password_hash, password_salt, first_name, last_name, 
-			phone_number, email_verification_token, email_verification_expires_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-	`, userID, tenantID, req.Email, passwordHash, saltString, req.FirstName, req.LastName, 
-		req.PhoneNumber, emailVerificationToken, emailVerificationExpires)
 
+	// Create the user and their tenant together so a failure can't leave an
+	// orphan tenant behind. The unique index on email decides which of two
+	// concurrent registrations for the same address wins.
+	userID, err := h.createUserWithTenant(&req, passwordHash)
+	if errors.Is(err, services.ErrEmailInUse) {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "User with this email already exists",
+		})
+		return
+	}
 	if err != nil {
 		h.authService.LogSecurityEvent("", "registration_failed", "Database error during user creation", clientIP, userAgent, map[string]interface{}{
 			"email": req.Email,
@@ -188,8 +195,13 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		"email": req.Email,
 	})
 
-	// In production, you would send an email verification here
-	// For now, we'll just return success
+	// Send the verification email; the user can request another via
+	// /auth/resend-verification if this one fails
+	if err := h.emailVerifier.SendVerification(userID, req.Email); err != nil {
+		h.authService.LogSecurityEvent(userID, "email_verification_send_failed", "Failed to send verification email", clientIP, userAgent, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
 
 	c.JSON(http.StatusCreated, RegisterResponse{
 		Success:              true,
@@ -238,18 +250,33 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	req.IPAddress = clientIP
 	req.DeviceInfo = userAgent
 
+	// After repeated failures from this IP, logins must pass a CAPTCHA
+	if h.captcha != nil {
+		required, _, err := h.rateLimiter.GetBlockStatus(clientIP, "login_captcha")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Internal server error",
+			})
+			return
+		}
+		if required && !h.verifyCaptcha(c, req.CaptchaToken, clientIP) {
+			return
+		}
+	}
+
 	// Get user from database
 	var user services.User
-	var passwordHash, passwordSalt string
+	var passwordHash string
 	err = h.db.QueryRow(`
-		SELECT id, tenant_id, email, password_hash, password_salt, first_name, last_name, 
-		       phone_number, phone_verified, role, is_active, two_factor_enabled, 
+		SELECT id, tenant_id, email, password_hash, first_name, last_name, 
+		       phone_number, phone_verified, role, is_active, two_factor_enabled, two_factor_method,
 		       last_login_at, failed_login_attempts, locked_until, created_at, updated_at, email_verified
 		FROM users WHERE email = $1
 	`, req.Email).Scan(
-		&user.ID, &user.TenantID, &user.Email, &passwordHash, &passwordSalt,
+		&user.ID, &user.TenantID, &user.Email, &passwordHash,
 		&user.FirstName, &user.LastName, &user.PhoneNumber, &user.PhoneVerified,
-		&user.Role, &user.IsActive, &user.TwoFactorEnabled, &user.LastLoginAt,
+		&user.Role, &user.IsActive, &user.TwoFactorEnabled, &user.TwoFactorMethod, &user.LastLoginAt,
 		&user.FailedLoginAttempts, &user.LockedUntil, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerified,
 	)
 
@@ -257,6 +284,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		h.authService.LogSecurityEvent("", "login_failed", "User not found", clientIP, userAgent, map[string]interface{}{
 			"email": req.Email,
 		})
+		h.recordLoginFailure(clientIP)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
 			"message": "Invalid email or password",
@@ -301,7 +329,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		// Increment failed attempts
 		h.authService.IncrementFailedAttempts(user.ID)
 		h.authService.LogSecurityEvent(user.ID, "login_failed", "Invalid password", clientIP, userAgent, nil)
-		
+		h.recordLoginFailure(clientIP)
+
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
 			"message": "Invalid email or password",
@@ -309,6 +338,13 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	// Upgrade hashes made with older Argon2 params while we have the password
+	if h.authService.NeedsRehash(passwordHash) {
+		if err := h.authService.RehashPassword(user.ID, req.Password, passwordHash); err != nil {
+			fmt.Printf("Failed to rehash password for user %s: %v\n", user.ID, err)
+		}
+	}
+
 	// Check if email is verified
 	if !user.EmailVerified {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -319,40 +355,81 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Check if 2FA is enabled
-	if user.TwoFactorEnabled && user.PhoneVerified {
-		// Send 2FA code
-		smsRequest := &services.SMSVerificationRequest{
-			PhoneNumber: user.PhoneNumber,
-			Purpose:     "login",
-			UserID:      user.ID,
+	if user.TwoFactorEnabled && (user.TwoFactorMethod == "totp" || user.PhoneVerified) && !h.isTrustedDevice(&req, user.ID) {
+		message := "Enter the code from your authenticator app"
+
+		if user.TwoFactorMethod != "totp" {
+			// Send 2FA code
+			smsRequest := &services.SMSVerificationRequest{
+				PhoneNumber: user.PhoneNumber,
+				Purpose:     "login",
+				UserID:      user.ID,
+				Method:      req.Method,
+				Email:       user.Email,
+				ClientIP:    clientIP,
+			}
+
+			smsResp, err := h.sms2FAService.SendVerificationCode(smsRequest)
+			if errors.Is(err, services.ErrUndeliverablePhoneNumber) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"message": "Your phone number can't receive SMS. Contact support to update it.",
+					"code":    "PHONE_UNDELIVERABLE",
+				})
+				return
+			}
+			if err != nil {
+				h.authService.LogSecurityEvent(user.ID, "2fa_send_failed", "Failed to send 2FA code", clientIP, userAgent, map[string]interface{}{
+					"error": err.Error(),
+				})
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"success": false,
+					"message": "Failed to send verification code",
+				})
+				return
+			}
+			if !smsResp.Success {
+				status := http.StatusServiceUnavailable
+				if smsResp.RetryAfter > 0 {
+					status = http.StatusTooManyRequests
+				}
+				c.JSON(status, gin.H{
+					"success":     false,
+					"message":     smsResp.Message,
+					"retry_after": smsResp.RetryAfter,
+				})
+				return
+			}
+			message = "Verification code sent to your phone"
+			if smsResp.DeliveryMethod == "email" {
+				message = "Verification code sent to your email"
+			}
 		}
 
-		_, err = h.sms2FAService.SendVerificationCode(smsRequest)
+		// Issue a short-lived temp token that Verify2FA must present, so the
+		// code alone is never enough to log in
+		tempToken, err := h.authService.CreateTwoFactorChallenge(user.ID)
 		if err != nil {
-			h.authService.LogSecurityEvent(user.ID, "2fa_send_failed", "Failed to send 2FA code", clientIP, userAgent, map[string]interface{}{
-				"error": err.Error(),
-			})
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"success": false,
-				"message": "Failed to send verification code",
+				"message": "Failed to start 2FA verification",
 			})
 			return
 		}
-
-		// Generate temporary token for 2FA flow
-		tempToken := uuid.New().String()
 		
 		c.JSON(http.StatusOK, LoginResponse{
-			Success:     true,
-			Message:     "Verification code sent to your phone",
-			Requires2FA: true,
-			TempToken:   tempToken,
+			Success:         true,
+			Message:         message,
+			UserID:          user.ID,
+			Requires2FA:     true,
+			TwoFactorMethod: user.TwoFactorMethod,
+			TempToken:       tempToken,
 		})
 		return
 	}
 
 	// Generate token pair
-	tokens, err := h.authService.GenerateTokenPair(&user, req.DeviceInfo, req.IPAddress)
+	tokens, err := h.authService.GenerateTokenPair(&user, req.DeviceInfo, req.IPAddress, req.RememberMe)
 	if err != nil {
 		h.authService.LogSecurityEvent(user.ID, "login_failed", "Token generation failed", clientIP, userAgent, map[string]interface{}{
 			"error": err.Error(),
@@ -367,6 +444,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	// Reset failed attempts and update last login
 	h.authService.ResetFailedAttempts(user.ID)
 	h.rateLimiter.ResetAttempts(clientIP, "login")
+	h.resetLoginFailures(clientIP)
 
 	// Log successful login
 	h.authService.LogSecurityEvent(user.ID, "login_success", "User successfully logged in", clientIP, userAgent, nil)
@@ -398,38 +476,26 @@ func (h *AuthHandler) Verify2FA(c *gin.Context) {
 		return
 	}
 
-	// Verify the 2FA code
-	response, err := h.sms2FAService.VerifyCode(&req)
-	if err != nil {
-		h.authService.LogSecurityEvent(req.UserID, "2fa_verification_failed", "2FA verification error", clientIP, userAgent, map[string]interface{}{
-			"error": err.Error(),
-		})
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"message": "Verification failed",
-		})
-		return
-	}
-
-	if !response.Verified {
-		h.authService.LogSecurityEvent(req.UserID, "2fa_verification_failed", "Invalid 2FA code", clientIP, userAgent, nil)
+	// The temp token proves the password step was completed for this user
+	if err := h.authService.ValidateTwoFactorChallenge(req.TempToken, req.UserID); err != nil {
+		h.authService.LogSecurityEvent(req.UserID, "2fa_verification_failed", "Invalid 2FA temp token", clientIP, userAgent, nil)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"success": false,
-			"message": response.Message,
+			"message": "Login session expired, please sign in again",
 		})
 		return
 	}
 
-	// Get user for token generation
+	// Get user for code verification and token generation
 	var user services.User
-	err = h.db.QueryRow(`
+	err := h.db.QueryRow(`
 		SELECT id, tenant_id, email, first_name, last_name, phone_number, 
-		       phone_verified, role, is_active, two_factor_enabled, created_at, updated_at
+		       phone_verified, role, is_active, two_factor_enabled, two_factor_method, created_at, updated_at
 		FROM users WHERE id = $1 AND is_active = TRUE
 	`, req.UserID).Scan(
 		&user.ID, &user.TenantID, &user.Email, &user.FirstName, &user.LastName,
 		&user.PhoneNumber, &user.PhoneVerified, &user.Role, &user.IsActive,
-		&user.TwoFactorEnabled, &user.CreatedAt, &user.UpdatedAt,
+		&user.TwoFactorEnabled, &user.TwoFactorMethod, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -440,8 +506,71 @@ func (h *AuthHandler) Verify2FA(c *gin.Context) {
 		return
 	}
 
+	if user.TwoFactorMethod == "totp" {
+		if err := h.totp2FAService.VerifyCode(user.ID, req.Code); err != nil {
+			h.authService.LogSecurityEvent(user.ID, "2fa_verification_failed", "Invalid authenticator code", clientIP, userAgent, map[string]interface{}{
+				"error": err.Error(),
+			})
+			// Unlike an SMS code, nothing else limits guesses at this one
+			revoked, recordErr := h.authService.RecordTwoFactorFailure(req.TempToken, user.ID)
+			if recordErr != nil {
+				log.Printf("Failed to record 2FA attempt: %v", recordErr)
+			}
+			if revoked {
+				h.authService.LogSecurityEvent(user.ID, "2fa_challenge_revoked", "Too many invalid authenticator codes", clientIP, userAgent, map[string]interface{}{
+					"max_attempts": services.MaxTwoFactorAttempts,
+				})
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"success": false,
+					"message": "Too many invalid codes, please sign in again",
+				})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid verification code",
+			})
+			return
+		}
+	} else {
+		// Only a login code sent to the user's own phone counts
+		req.PhoneNumber = user.PhoneNumber
+		req.Purpose = "login"
+
+		response, err := h.sms2FAService.VerifyCode(&req)
+		if err != nil {
+			h.authService.LogSecurityEvent(user.ID, "2fa_verification_failed", "2FA verification error", clientIP, userAgent, map[string]interface{}{
+				"error": err.Error(),
+			})
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Verification failed",
+			})
+			return
+		}
+
+		if !response.Verified {
+			h.authService.LogSecurityEvent(user.ID, "2fa_verification_failed", "Invalid 2FA code", clientIP, userAgent, nil)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": response.Message,
+			})
+			return
+		}
+	}
+
+	// Use up the temp token; losing this race means it was already redeemed
+	if err := h.authService.ConsumeTwoFactorChallenge(req.TempToken, req.UserID); err != nil {
+		h.authService.LogSecurityEvent(req.UserID, "2fa_verification_failed", "2FA temp token already used", clientIP, userAgent, nil)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Login session expired, please sign in again",
+		})
+		return
+	}
+
 	// Generate token pair
-	tokens, err := h.authService.GenerateTokenPair(&user, userAgent, clientIP)
+	tokens, err := h.authService.GenerateTokenPair(&user, userAgent, clientIP, req.RememberMe)
 	if err != nil {
 		h.authService.LogSecurityEvent(user.ID, "2fa_login_failed", "Token generation failed after 2FA", clientIP, userAgent, map[string]interface{}{
 			"error": err.Error(),
@@ -456,86 +585,926 @@ func (h *AuthHandler) Verify2FA(c *gin.Context) {
 	// Reset failed attempts and update last login
 	h.authService.ResetFailedAttempts(user.ID)
 	h.rateLimiter.ResetAttempts(clientIP, "login")
+	h.resetLoginFailures(clientIP)
 
 	// Log successful 2FA login
 	h.authService.LogSecurityEvent(user.ID, "2fa_login_success", "User successfully logged in with 2FA", clientIP, userAgent, nil)
 
-	c.JSON(http.StatusOK, LoginResponse{
+	response := LoginResponse{
 		Success:     true,
 		Message:     "Login successful",
 		User:        &user,
 		Tokens:      tokens,
 		Requires2FA: false,
-	})
-}
+	}
 
-// Helper functions
+	if req.TrustDevice {
+		// Login still succeeds without trust; the user just gets asked next time
+		trustToken, err := h.authService.TrustDevice(user.ID, userAgent, clientIP)
+		if err != nil {
+			fmt.Printf("Failed to trust device for user %s: %v\n", user.ID, err)
+		} else {
+			response.TrustedDeviceToken = trustToken
+			h.authService.LogSecurityEvent(user.ID, "device_trusted", "User trusted a device for 2FA", clientIP, userAgent, nil)
+		}
+	}
 
-func (h *AuthHandler) getClientIP(c *gin.Context) string {
-	// Check various headers for the real IP
-	ip := c.GetHeader("X-Forwarded-For")
-	if ip == "" {
-		ip = c.GetHeader("X-Real-IP")
+	c.JSON(http.StatusOK, response)
+}
+
+// verifyCaptcha checks the request's CAPTCHA token and writes the error
+// response if it doesn't pass. The codes tell the frontend to show the widget.
+func (h *AuthHandler) verifyCaptcha(c *gin.Context, token, clientIP string) bool {
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Please complete the CAPTCHA",
+			"code":    "CAPTCHA_REQUIRED",
+		})
+		return false
 	}
-	if ip == "" {
-		ip = c.ClientIP()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	err := h.captcha.Verify(ctx, token, clientIP)
+	switch {
+	case errors.Is(err, services.ErrCaptchaFailed):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "CAPTCHA verification failed. Please try again.",
+			"code":    "CAPTCHA_FAILED",
+		})
+		return false
+	case err != nil:
+		fmt.Printf("CAPTCHA verification error: %v\n", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"message": "CAPTCHA verification is unavailable. Please try again later.",
+		})
+		return false
 	}
-	
-	// Extract first IP if comma-separated
-	if strings.Contains(ip, ",") {
-		ip = strings.TrimSpace(strings.Split(ip, ",")[0])
+	return true
+}
+
+// recordLoginFailure counts a failed login from clientIP towards requiring a
+// CAPTCHA. Nothing is tracked when CAPTCHA is off.
+func (h *AuthHandler) recordLoginFailure(clientIP string) {
+	if h.captcha != nil {
+		h.rateLimiter.RecordAttempt(clientIP, "login_captcha")
 	}
-	
-	// Validate IP address
-	if net.ParseIP(ip) == nil {
-		ip = "127.0.0.1" // Fallback
+}
+
+// resetLoginFailures stops requiring a CAPTCHA from clientIP after a login
+// succeeds
+func (h *AuthHandler) resetLoginFailures(clientIP string) {
+	if h.captcha != nil {
+		h.rateLimiter.ResetAttempts(clientIP, "login_captcha")
 	}
-	
-	return ip
 }
 
-func (h *AuthHandler) isPasswordStrong(password string) bool {
-	if len(password) < 8 {
+// isTrustedDevice reports whether the login carries a valid trusted-device
+// token for this device, letting it skip 2FA
+func (h *AuthHandler) isTrustedDevice(req *services.LoginRequest, userID string) bool {
+	if req.TrustedDeviceToken == "" {
 		return false
 	}
 
-	hasUpper := false
-	hasLower := false
-	hasDigit := false
-	hasSpecial := false
+	trusted, err := h.authService.IsTrustedDevice(userID, req.TrustedDeviceToken, req.DeviceInfo, req.IPAddress)
+	if err != nil {
+		fmt.Printf("Failed to check trusted device for user %s: %v\n", userID, err)
+		return false
+	}
+	if trusted {
+		h.authService.LogSecurityEvent(userID, "2fa_skipped_trusted_device", "2FA skipped on trusted device", req.IPAddress, req.DeviceInfo, nil)
+	}
+	return trusted
+}
 
-	for _, char := range password {
-		switch {
-		case char >= 'A' && char <= 'Z':
-			hasUpper = true
-		case char >= 'a' && char <= 'z':
-			hasLower = true
-		case char >= '0' && char <= '9':
-			hasDigit = true
-		case strings.ContainsRune("!@#$%^&*()_+-=[]{}|;:,.<>?", char):
-			hasSpecial = true
+// ConfirmTOTPRequest confirms authenticator enrollment
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" binding:"required,len=6"`
+}
+
+// BeginTOTPEnrollment starts authenticator-app setup for the caller and
+// returns the secret and otpauth:// URI to show as a QR code
+func (h *AuthHandler) BeginTOTPEnrollment(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	enrollment, err := h.totp2FAService.BeginEnrollment(userID, c.GetString("user_email"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to start authenticator setup",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    enrollment,
+	})
+}
+
+// ConfirmTOTPEnrollment activates authenticator 2FA once the caller proves
+// their app produces valid codes
+func (h *AuthHandler) ConfirmTOTPEnrollment(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+	userID := c.GetString("user_id")
+
+	var req ConfirmTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	err := h.totp2FAService.ConfirmEnrollment(userID, req.Code)
+	switch {
+	case errors.Is(err, services.ErrTOTPNoPendingEnrollment):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Start authenticator setup first",
+		})
+		return
+	case errors.Is(err, services.ErrInvalidTOTPCode):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid verification code",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to enable authenticator",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, "2fa_totp_enabled", "Authenticator app 2FA enabled", clientIP, userAgent, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Authenticator app enabled",
+	})
+}
+
+// RefreshTokenRequest represents a token refresh request
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RefreshTokenResponse represents the response for a successful token refresh
+type RefreshTokenResponse struct {
+	Success bool                `json:"success"`
+	Message string              `json:"message"`
+	Tokens  *services.TokenPair `json:"tokens"`
+}
+
+// RefreshToken exchanges a refresh token for a new, rotated token pair
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	tokens, user, err := h.authService.RefreshTokens(req.RefreshToken, userAgent, clientIP)
+	if err != nil {
+		userID := ""
+		if user != nil {
+			userID = user.ID
+		}
+
+		switch err {
+		case services.ErrRefreshTokenRevoked:
+			h.authService.LogSecurityEvent(userID, "refresh_token_reuse", "Revoked refresh token presented", clientIP, userAgent, nil)
+		case services.ErrRefreshTokenExpired, services.ErrInvalidRefreshToken:
+			h.authService.LogSecurityEvent(userID, "refresh_failed", err.Error(), clientIP, userAgent, nil)
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Failed to refresh session",
+			})
+			return
 		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Invalid or expired refresh token",
+		})
+		return
 	}
 
-	return hasUpper && hasLower && hasDigit && hasSpecial
+	h.authService.LogSecurityEvent(user.ID, "token_refreshed", "Session refreshed", clientIP, userAgent, nil)
+
+	c.JSON(http.StatusOK, RefreshTokenResponse{
+		Success: true,
+		Message: "Token refreshed successfully",
+		Tokens:  tokens,
+	})
 }
 
-func (h *AuthHandler) createOrGetTenant(tenantName, userEmail string) (string, error) {
-	// If no tenant name provided, create a personal tenant
-	if tenantName == "" {
-		tenantName = "Personal Account"
+// LogoutRequest represents a logout request; the body is optional
+type LogoutRequest struct {
+	AllDevices bool `json:"all_devices"`
+}
+
+// Logout revokes the caller's current session, or every session when
+// all_devices is set. Must run behind AuthMiddleware.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+
+	var req LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
 	}
 
-	tenantID := uuid.New().String()
-	_, err := h.db.Exec(`
-		INSERT INTO tenants (id, name, subscription_tier) 
-		VALUES ($1, $2, 'starter')
-	`, tenantID, tenantName)
+	userID := c.GetString("user_id")
+	jti := c.GetString("token_jti")
+
+	var err error
+	eventType := "logout"
+	if req.AllDevices {
+		eventType = "logout_all_devices"
+		err = h.authService.RevokeAllUserSessions(userID)
+	} else {
+		err = h.authService.RevokeSessionByJTI(userID, jti)
+	}
 
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to log out",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, eventType, "User logged out", clientIP, userAgent, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Logged out successfully",
+	})
+}
+
+// ListSessions returns the caller's active sessions
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.authService.ListActiveSessions(c.GetString("user_id"), c.GetString("token_jti"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession signs out one of the caller's sessions
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+	userID := c.GetString("user_id")
+	sessionID := c.Param("id")
+
+	err := h.authService.RevokeUserSession(userID, sessionID)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Session not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to revoke session",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, "session_revoked", "User revoked a session", clientIP, userAgent, map[string]interface{}{
+		"session_id": sessionID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Session revoked",
+	})
+}
+
+// ListTrustedDevices returns the devices allowed to skip 2FA
+func (h *AuthHandler) ListTrustedDevices(c *gin.Context) {
+	devices, err := h.authService.ListTrustedDevices(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load trusted devices",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"devices": devices,
+	})
+}
+
+// RevokeTrustedDevice makes a device ask for 2FA again on its next login
+func (h *AuthHandler) RevokeTrustedDevice(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+	userID := c.GetString("user_id")
+	deviceID := c.Param("id")
+
+	err := h.authService.RevokeTrustedDevice(userID, deviceID)
+	if errors.Is(err, services.ErrTrustedDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Trusted device not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to revoke trusted device",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, "trusted_device_revoked", "User revoked a trusted device", clientIP, userAgent, map[string]interface{}{
+		"device_id": deviceID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Trusted device revoked",
+	})
+}
+
+// ForgotPasswordRequest represents a forgot-password request
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents a reset-password request
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// ForgotPassword emails a password reset link. It answers 200 whether or not
+// the account exists so it can't be used to enumerate emails.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+
+	allowed, blockTime, err := h.rateLimiter.IsAllowed(clientIP, "password_reset")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":     false,
+			"message":     "Too many password reset requests. Please try again later.",
+			"retry_after": int(blockTime.Seconds()),
+		})
+		return
+	}
+
+	h.rateLimiter.RecordAttempt(clientIP, "password_reset")
+
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	if err := h.passwordReset.RequestReset(req.Email); err != nil {
+		h.authService.LogSecurityEvent("", "password_reset_request_failed", "Failed to issue password reset", clientIP, userAgent, map[string]interface{}{
+			"email": req.Email,
+			"error": err.Error(),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "If an account exists for that email, a password reset link has been sent.",
+	})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	if !h.isPasswordStrong(req.NewPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Password must be at least 8 characters with uppercase, lowercase, number, and special character",
+		})
+		return
+	}
+
+	userID, err := h.passwordReset.ResetPassword(req.Token, req.NewPassword)
+	switch {
+	case errors.Is(err, services.ErrInvalidResetToken), errors.Is(err, services.ErrResetTokenExpired):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Password reset link is invalid or has expired",
+		})
+		return
+	case errors.Is(err, services.ErrPasswordReused):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": passwordReusedMessage,
+			"code":    "PASSWORD_REUSED",
+		})
+		return
+	case err != nil && userID == "":
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to reset password",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, "password_reset", "Password reset via email token", clientIP, userAgent, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Password has been reset. Please log in with your new password.",
+	})
+}
+
+// ChangePasswordRequest represents a signed-in user's password change
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+const passwordReusedMessage = "You've used that password recently. Please choose a different one."
+
+// ChangePassword sets a new password after confirming the current one and
+// signs out the caller's other sessions
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+	userID := c.GetString("user_id")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	if !h.isPasswordStrong(req.NewPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Password must be at least 8 characters with uppercase, lowercase, number, and special character",
+		})
+		return
+	}
+
+	err := h.passwordReset.ChangePassword(userID, c.GetString("token_jti"), req.CurrentPassword, req.NewPassword)
+	switch {
+	case errors.Is(err, services.ErrIncorrectPassword):
+		h.authService.LogSecurityEvent(userID, "password_change_failed", "Incorrect password", clientIP, userAgent, nil)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Current password is incorrect",
+		})
+		return
+	case errors.Is(err, services.ErrPasswordReused):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": passwordReusedMessage,
+			"code":    "PASSWORD_REUSED",
+		})
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "User not found",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to change password",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, "password_changed", "Password changed", clientIP, userAgent, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Password changed. Your other sessions have been signed out.",
+	})
+}
+
+// VerifyEmailRequest represents an email verification request
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ResendVerificationRequest represents a request for a new verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// VerifyEmail confirms an email address. The token is accepted as a ?token=
+// query parameter (GET links from the email) or in a JSON body (POST).
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+
+	token := c.Query("token")
+	if token == "" && c.Request.Method == http.MethodPost {
+		var req VerifyEmailRequest
+		if err := c.ShouldBindJSON(&req); err == nil {
+			token = req.Token
+		}
+	}
+
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Verification token is required",
+		})
+		return
+	}
+
+	userID, err := h.emailVerifier.VerifyEmail(token)
+	switch {
+	case errors.Is(err, services.ErrEmailAlreadyVerified):
+		c.JSON(http.StatusOK, gin.H{
+			"success":          true,
+			"message":          "Your email address is already verified",
+			"already_verified": true,
+		})
+		return
+	case errors.Is(err, services.ErrVerificationTokenExpired):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"code":    "VERIFICATION_TOKEN_EXPIRED",
+			"message": "Verification link has expired. Please request a new one.",
+		})
+		return
+	case errors.Is(err, services.ErrInvalidVerificationToken):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"code":    "VERIFICATION_TOKEN_INVALID",
+			"message": "Verification link is invalid",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to verify email",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, "email_verified", "Email address verified", clientIP, userAgent, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Email verified successfully. You can now log in.",
+	})
+}
+
+// ChangeEmailRequest represents a request to move the account to a new email
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email" binding:"required,email"`
+	CurrentPassword string `json:"current_password" binding:"required"`
+}
+
+// ConfirmEmailChangeRequest confirms a pending email change
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ChangeEmail starts an email change for the caller. users.email only changes
+// once the link sent to the new address is followed.
+func (h *AuthHandler) ChangeEmail(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+	userID := c.GetString("user_id")
+
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	err := h.emailChanger.RequestChange(userID, req.CurrentPassword, req.NewEmail)
+	switch {
+	case errors.Is(err, services.ErrIncorrectPassword):
+		h.authService.LogSecurityEvent(userID, "email_change_failed", "Incorrect password", clientIP, userAgent, nil)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Current password is incorrect",
+		})
+		return
+	case errors.Is(err, services.ErrSameEmail):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "That is already your email address",
+		})
+		return
+	case errors.Is(err, services.ErrEmailInUse):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"code":    "EMAIL_IN_USE",
+			"message": "That email address is already in use",
+		})
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "User not found",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to start email change",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, "email_change_requested", "Email change requested", clientIP, userAgent, map[string]interface{}{
+		"new_email": req.NewEmail,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Check your new email address for a confirmation link",
+	})
+}
+
+// ConfirmEmailChange completes an email change. Like VerifyEmail, the token
+// may come from a ?token= query parameter or a JSON body.
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+
+	token := c.Query("token")
+	if token == "" && c.Request.Method == http.MethodPost {
+		var req ConfirmEmailChangeRequest
+		if err := c.ShouldBindJSON(&req); err == nil {
+			token = req.Token
+		}
+	}
+
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Confirmation token is required",
+		})
+		return
+	}
+
+	userID, err := h.emailChanger.ConfirmChange(token)
+	switch {
+	case errors.Is(err, services.ErrEmailInUse):
+		h.authService.LogSecurityEvent(userID, "email_change_failed", "Pending email claimed by another account", clientIP, userAgent, nil)
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"code":    "EMAIL_IN_USE",
+			"message": "That email address is now used by another account. Please choose a different one.",
+		})
+		return
+	case errors.Is(err, services.ErrEmailChangeTokenExpired):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"code":    "EMAIL_CHANGE_TOKEN_EXPIRED",
+			"message": "Confirmation link has expired. Please request the change again.",
+		})
+		return
+	case errors.Is(err, services.ErrInvalidEmailChangeToken):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"code":    "EMAIL_CHANGE_TOKEN_INVALID",
+			"message": "Confirmation link is invalid",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to change email",
+		})
+		return
+	}
+
+	h.authService.LogSecurityEvent(userID, "email_changed", "Email address changed", clientIP, userAgent, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Your email address has been changed",
+	})
+}
+
+// ResendVerification sends a fresh verification email to an unverified account
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	clientIP := h.getClientIP(c)
+	userAgent := c.GetHeader("User-Agent")
+
+	allowed, blockTime, err := h.rateLimiter.IsAllowed(clientIP, "email_verification")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":     false,
+			"message":     "Too many verification requests. Please try again later.",
+			"retry_after": int(blockTime.Seconds()),
+		})
+		return
+	}
+
+	h.rateLimiter.RecordAttempt(clientIP, "email_verification")
+
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	userID, err := h.emailVerifier.ResendVerification(req.Email)
+	if errors.Is(err, services.ErrEmailAlreadyVerified) {
+		c.JSON(http.StatusOK, gin.H{
+			"success":          true,
+			"message":          "Your email address is already verified",
+			"already_verified": true,
+		})
+		return
+	}
+	if err != nil {
+		h.authService.LogSecurityEvent(userID, "email_verification_send_failed", "Failed to resend verification email", clientIP, userAgent, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "If an unverified account exists for that email, a new verification link has been sent.",
+	})
+}
+
+// Helper functions
+
+func (h *AuthHandler) getClientIP(c *gin.Context) string {
+	// Check various headers for the real IP
+	ip := c.GetHeader("X-Forwarded-For")
+	if ip == "" {
+		ip = c.GetHeader("X-Real-IP")
+	}
+	if ip == "" {
+		ip = c.ClientIP()
+	}
+	
+	// Extract first IP if comma-separated
+	if strings.Contains(ip, ",") {
+		ip = strings.TrimSpace(strings.Split(ip, ",")[0])
+	}
+	
+	// Validate IP address
+	if net.ParseIP(ip) == nil {
+		ip = "127.0.0.1" // Fallback
+	}
+	
+	return ip
+}
+
+func (h *AuthHandler) isPasswordStrong(password string) bool {
+	if len(password) < 8 {
+		return false
+	}
+
+	hasUpper := false
+	hasLower := false
+	hasDigit := false
+	hasSpecial := false
+
+	for _, char := range password {
+		switch {
+		case char >= 'A' && char <= 'Z':
+			hasUpper = true
+		case char >= 'a' && char <= 'z':
+			hasLower = true
+		case char >= '0' && char <= '9':
+			hasDigit = true
+		case strings.ContainsRune("!@#$%^&*()_+-=[]{}|;:,.<>?", char):
+			hasSpecial = true
+		}
+	}
+
+	return hasUpper && hasLower && hasDigit && hasSpecial
+}
+
+// createUserWithTenant inserts a new user and a personal tenant for them in
+// one transaction. Registration never joins an existing tenant, even one with
+// the same name: tenant names aren't unique or secret, so matching on them
+// would let anyone add themselves to another company's account. Joining a
+// team has to go through an invitation instead. It returns
+// services.ErrEmailInUse if the email is already registered.
+func (h *AuthHandler) createUserWithTenant(req *services.RegisterRequest, passwordHash string) (string, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// If no tenant name provided, create a personal tenant
+	tenantName := strings.TrimSpace(req.TenantName)
+	if tenantName == "" {
+		tenantName = "Personal Account"
+	}
+
+	tenantID := uuid.New().String()
+	_, err = tx.Exec(`
+		INSERT INTO tenants (id, name, subscription_tier) 
+		VALUES ($1, $2, 'starter')
+	`, tenantID, tenantName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create tenant: %w", err)
+	}
+
+	// The creator is the new tenant's admin
+	userID := uuid.New().String()
+	_, err = tx.Exec(`
+		INSERT INTO users (
+			id, tenant_id, email, password_hash, first_name, last_name, 
+			phone_number, role
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, 'admin')
+	`, userID, tenantID, req.Email, passwordHash, req.FirstName, req.LastName, 
+		req.PhoneNumber)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return "", services.ErrEmailInUse
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
 		return "", err
 	}
 
-	return tenantID, nil
+	return userID, nil
 }
 
@@ -0,0 +1,782 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testArgon2Params keeps password hashing cheap in tests
+var testArgon2Params = &services.Argon2Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func newTestAuthHandler(t *testing.T) (*AuthHandler, sqlmock.Sqlmock) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	authService := services.NewAuthServiceWithArgon2(db, "test-secret", testArgon2Params)
+	return &AuthHandler{
+		authService:    authService,
+		rateLimiter:    services.NewRateLimiter(db),
+		totp2FAService: services.NewTOTP2FAService(db, "test-key"),
+		db:             db,
+	}, mock
+}
+
+// performAuthenticated runs handler with the context values AuthMiddleware sets
+func performAuthenticated(handler gin.HandlerFunc, method, body string, params gin.Params) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	c.Set("user_id", "user-1")
+	c.Set("tenant_id", "tenant-1")
+	c.Set("user_role", "user")
+	c.Set("token_jti", "jti-1")
+	handler(c)
+	return w
+}
+
+func TestLogout_CurrentSession(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	mock.ExpectExec(`UPDATE user_sessions\s+SET revoked = TRUE\s+WHERE user_id = \$1 AND access_token_jti = \$2`).
+		WithArgs("user-1", "jti-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "logout", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performAuthenticated(handler.Logout, http.MethodPost, "", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLogout_AllDevices(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	mock.ExpectExec(`UPDATE user_sessions\s+SET revoked = TRUE\s+WHERE user_id = \$1\s*$`).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "logout_all_devices", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performAuthenticated(handler.Logout, http.MethodPost, `{"all_devices": true}`, nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func performJSON(handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+func TestVerify2FA_RequiresTempToken(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	w := performJSON(handler.Verify2FA, `{"phone_number": "+15555550100", "code": "123456", "purpose": "login", "user_id": "user-1"}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerify2FA_RejectsInvalidTempToken(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	mock.ExpectQuery(`FROM two_factor_challenges`).
+		WithArgs(sqlmock.AnyArg(), "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "2fa_verification_failed", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performJSON(handler.Verify2FA, `{"phone_number": "+15555550100", "code": "123456", "purpose": "login", "user_id": "user-1", "temp_token": "forged"}`)
+
+	// Rejected before the code is checked, so no SMS lookup happens
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListSessions_MarksCurrent(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM user_sessions`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_agent", "device_fingerprint", "ip", "created_at", "expires_at", "remember_me", "access_token_jti"}).
+			AddRow("11111111-1111-1111-1111-111111111111", "Firefox", "fp-1", "10.0.0.1", now, now.Add(time.Hour), false, "jti-1").
+			AddRow("22222222-2222-2222-2222-222222222222", "Safari", "fp-2", "10.0.0.2", now, now.Add(30*24*time.Hour), true, "jti-2"))
+
+	w := performAuthenticated(handler.ListSessions, http.MethodGet, "", nil)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Sessions []services.Session `json:"sessions"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Len(t, body.Sessions, 2)
+	assert.True(t, body.Sessions[0].Current)
+	assert.False(t, body.Sessions[1].Current)
+	assert.False(t, body.Sessions[0].RememberMe)
+	assert.True(t, body.Sessions[1].RememberMe)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeSession_OtherUsersSession(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+	otherSessionID := "33333333-3333-3333-3333-333333333333"
+
+	// The user_id filter means another user's session matches no rows
+	mock.ExpectExec(`UPDATE user_sessions`).
+		WithArgs(otherSessionID, "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := performAuthenticated(handler.RevokeSession, http.MethodDelete, "", gin.Params{{Key: "id", Value: otherSessionID}})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeSession_MalformedID(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	w := performAuthenticated(handler.RevokeSession, http.MethodDelete, "", gin.Params{{Key: "id", Value: "not-a-uuid"}})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeSession_CurrentSession(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+	sessionID := "11111111-1111-1111-1111-111111111111"
+
+	mock.ExpectExec(`UPDATE user_sessions`).
+		WithArgs(sessionID, "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "session_revoked", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performAuthenticated(handler.RevokeSession, http.MethodDelete, "", gin.Params{{Key: "id", Value: sessionID}})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// capturedArg is a sqlmock argument that records the value it was matched against
+type capturedArg struct {
+	value driver.Value
+}
+
+func (c *capturedArg) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+}
+
+func TestTOTP_EnrollThenLogin(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	// Enroll: the secret is stored encrypted as pending
+	pendingSecret := &capturedArg{}
+	mock.ExpectExec(`UPDATE users\s+SET totp_pending_secret`).
+		WithArgs("user-1", pendingSecret).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performAuthenticated(handler.BeginTOTPEnrollment, http.MethodPost, "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var enrollResp struct {
+		Data services.TOTPEnrollment `json:"data"`
+	}
+	decodeJSON(t, w, &enrollResp)
+	secret := enrollResp.Data.Secret
+	assert.Contains(t, enrollResp.Data.OTPAuthURI, "otpauth://totp/")
+	assert.NotContains(t, pendingSecret.value, secret)
+
+	// Confirm with a code from the "app"
+	code, err := services.GenerateTOTPCode(secret, time.Now())
+	require.NoError(t, err)
+	mock.ExpectQuery(`SELECT totp_pending_secret FROM users`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"totp_pending_secret"}).AddRow(pendingSecret.value))
+	mock.ExpectExec(`SET two_factor_secret = totp_pending_secret`).
+		WithArgs("user-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w = performAuthenticated(handler.ConfirmTOTPEnrollment, http.MethodPost, `{"code": "`+code+`"}`, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Login: password is accepted and a TOTP code is requested, no SMS sent
+	passwordHash := handler.authService.HashPassword("Sup3r$ecretPass", []byte("0123456789abcdef0123456789abcdef"))
+	now := time.Now()
+	mock.ExpectQuery(`SELECT blocked_until`).WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}))
+	mock.ExpectQuery(`SELECT attempts`).WillReturnRows(sqlmock.NewRows([]string{"attempts", "exists"}))
+	mock.ExpectQuery(`SELECT COALESCE\(attempts, 0\)`).WillReturnRows(sqlmock.NewRows([]string{"attempts"}))
+	mock.ExpectExec(`INSERT INTO rate_limits`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "tenant_id", "email", "password_hash", "first_name", "last_name",
+			"phone_number", "phone_verified", "role", "is_active", "two_factor_enabled", "two_factor_method",
+			"last_login_at", "failed_login_attempts", "locked_until", "created_at", "updated_at", "email_verified",
+		}).AddRow(
+			"user-1", "tenant-1", "user@example.com", passwordHash, "Test", "User",
+			"", false, "user", true, true, "totp",
+			nil, 0, nil, now, now, true,
+		))
+	challengeHash := &capturedArg{}
+	mock.ExpectExec(`INSERT INTO two_factor_challenges`).
+		WithArgs("user-1", challengeHash, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w = performJSON(handler.Login, `{"email": "user@example.com", "password": "Sup3r$ecretPass"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var loginResp LoginResponse
+	decodeJSON(t, w, &loginResp)
+	assert.True(t, loginResp.Requires2FA)
+	assert.Equal(t, "totp", loginResp.TwoFactorMethod)
+	assert.Equal(t, "user-1", loginResp.UserID)
+	require.NotEmpty(t, loginResp.TempToken)
+
+	// Verify with the next code; the enrollment code's step is already spent
+	code, err = services.GenerateTOTPCode(secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	mock.ExpectQuery(`FROM two_factor_challenges`).
+		WithArgs(challengeHash.value, "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND is_active = TRUE`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "tenant_id", "email", "first_name", "last_name", "phone_number",
+			"phone_verified", "role", "is_active", "two_factor_enabled", "two_factor_method", "created_at", "updated_at",
+		}).AddRow("user-1", "tenant-1", "user@example.com", "Test", "User", "", false, "user", true, true, "totp", now, now))
+	mock.ExpectQuery(`SELECT two_factor_secret, two_factor_method FROM users`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"two_factor_secret", "two_factor_method"}).AddRow(pendingSecret.value, "totp"))
+	mock.ExpectExec(`SET totp_last_used_step`).
+		WithArgs("user-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE two_factor_challenges`).
+		WithArgs(challengeHash.value, "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users`).WithArgs("user-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE rate_limits`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "2fa_login_success", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w = performJSON(handler.Verify2FA, `{"user_id": "user-1", "code": "`+code+`", "temp_token": "`+loginResp.TempToken+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var verifyResp LoginResponse
+	decodeJSON(t, w, &verifyResp)
+	assert.NotEmpty(t, verifyResp.Tokens.AccessToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerify2FA_TOTPRevokedAfterTooManyWrongCodes(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+	secret := &capturedArg{}
+	mock.ExpectExec(`UPDATE users\s+SET totp_pending_secret`).
+		WithArgs("user-1", secret).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	enrollment, err := handler.totp2FAService.BeginEnrollment("user-1", "user@example.com")
+	require.NoError(t, err)
+
+	tempToken := "temp-token"
+	challengeHash := &capturedArg{}
+	now := time.Now()
+	expectAttempt := func(valid bool) {
+		mock.ExpectQuery(`FROM two_factor_challenges`).
+			WithArgs(challengeHash, "user-1").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(valid))
+		if !valid {
+			mock.ExpectExec(`INSERT INTO security_audit_log`).WillReturnResult(sqlmock.NewResult(0, 1))
+			return
+		}
+		mock.ExpectQuery(`FROM users WHERE id = \$1 AND is_active = TRUE`).
+			WithArgs("user-1").
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "tenant_id", "email", "first_name", "last_name", "phone_number",
+				"phone_verified", "role", "is_active", "two_factor_enabled", "two_factor_method", "created_at", "updated_at",
+			}).AddRow("user-1", "tenant-1", "user@example.com", "Test", "User", "", false, "user", true, true, "totp", now, now))
+		mock.ExpectQuery(`SELECT two_factor_secret, two_factor_method FROM users`).
+			WithArgs("user-1").
+			WillReturnRows(sqlmock.NewRows([]string{"two_factor_secret", "two_factor_method"}).AddRow(secret.value, "totp"))
+		mock.ExpectExec(`INSERT INTO security_audit_log`).
+			WithArgs("user-1", "2fa_verification_failed", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	// Each wrong code counts against the challenge, and the fifth revokes it
+	wrongCode, err := services.GenerateTOTPCode(enrollment.Secret, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	for attempt := 1; attempt <= services.MaxTwoFactorAttempts; attempt++ {
+		expectAttempt(true)
+		mock.ExpectQuery(`UPDATE two_factor_challenges\s+SET failed_attempts`).
+			WithArgs(challengeHash, "user-1", services.MaxTwoFactorAttempts).
+			WillReturnRows(sqlmock.NewRows([]string{"used"}).AddRow(attempt == services.MaxTwoFactorAttempts))
+		if attempt == services.MaxTwoFactorAttempts {
+			mock.ExpectExec(`INSERT INTO security_audit_log`).
+				WithArgs("user-1", "2fa_challenge_revoked", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		w := performJSON(handler.Verify2FA, `{"user_id": "user-1", "code": "`+wrongCode+`", "temp_token": "`+tempToken+`"}`)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		if attempt == services.MaxTwoFactorAttempts {
+			assert.Contains(t, w.Body.String(), "Too many invalid codes")
+		}
+	}
+
+	// The sixth is refused even with the right code, which isn't checked
+	code, err := services.GenerateTOTPCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	expectAttempt(false)
+
+	w := performJSON(handler.Verify2FA, `{"user_id": "user-1", "code": "`+code+`", "temp_token": "`+tempToken+`"}`)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "sign in again")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUserWithTenant_DuplicateTenantNamesStaySeparate(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	tenantIDs := []*capturedArg{{}, {}}
+	for i, email := range []string{"alice@acme.com", "mallory@example.com"} {
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO tenants`).
+			WithArgs(tenantIDs[i], "Acme Realty").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO users`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), email, "hash", "First", "Last", "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		_, err := handler.createUserWithTenant(&services.RegisterRequest{
+			Email:      email,
+			FirstName:  "First",
+			LastName:   "Last",
+			TenantName: "Acme Realty",
+		}, "hash")
+		require.NoError(t, err)
+	}
+
+	// Typing a company's name must not join its tenant
+	assert.NotEqual(t, tenantIDs[0].value, tenantIDs[1].value)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUserWithTenant_RollsBackOnUserInsertFailure(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO tenants`).
+		WithArgs(sqlmock.AnyArg(), "Personal Account").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO users`).
+		WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	_, err := handler.createUserWithTenant(&services.RegisterRequest{
+		Email:     "user@example.com",
+		FirstName: "First",
+		LastName:  "Last",
+	}, "hash")
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLogin_RehashesPasswordWithOldParams(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	oldParams := *testArgon2Params
+	oldParams.Iterations = 2
+	oldService := services.NewAuthServiceWithArgon2(nil, "test-secret", &oldParams)
+	oldHash := oldService.HashPassword("Sup3r$ecretPass", []byte("0123456789abcdef"))
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT blocked_until`).WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}))
+	mock.ExpectQuery(`SELECT attempts`).WillReturnRows(sqlmock.NewRows([]string{"attempts", "exists"}))
+	mock.ExpectQuery(`SELECT COALESCE\(attempts, 0\)`).WillReturnRows(sqlmock.NewRows([]string{"attempts"}))
+	mock.ExpectExec(`INSERT INTO rate_limits`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "tenant_id", "email", "password_hash", "first_name", "last_name",
+			"phone_number", "phone_verified", "role", "is_active", "two_factor_enabled", "two_factor_method",
+			"last_login_at", "failed_login_attempts", "locked_until", "created_at", "updated_at", "email_verified",
+		}).AddRow(
+			"user-1", "tenant-1", "user@example.com", oldHash, "Test", "User",
+			"", false, "user", true, false, "sms",
+			nil, 0, nil, now, now, false,
+		))
+	newHash := &capturedArg{}
+	mock.ExpectExec(`UPDATE users SET password_hash = \$2`).
+		WithArgs("user-1", newHash, oldHash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performJSON(handler.Login, `{"email": "user@example.com", "password": "Sup3r$ecretPass"}`)
+
+	// Login stops at email verification, but the hash was already upgraded
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, newHash.value, "$m=1024,t=1,p=1$")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRegister_RejectsDisposableEmail(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+	handler.emailDomains = services.NewEmailDomainValidator(net.DefaultResolver, nil)
+
+	mock.ExpectQuery(`SELECT blocked_until`).WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}))
+	mock.ExpectQuery(`SELECT attempts`).WillReturnRows(sqlmock.NewRows([]string{"attempts", "exists"}))
+	mock.ExpectQuery(`SELECT COALESCE\(attempts, 0\)`).WillReturnRows(sqlmock.NewRows([]string{"attempts"}))
+	mock.ExpectExec(`INSERT INTO rate_limits`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performJSON(handler.Register, `{"email": "user@mailinator.com", "password": "Sup3r$ecretPass", "first_name": "Test", "last_name": "User"}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"DISPOSABLE_EMAIL"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectTOTPUserLogin mocks the rate limiter and user lookup for a password
+// login by a user with authenticator-app 2FA
+func expectTOTPUserLogin(handler *AuthHandler, mock sqlmock.Sqlmock) {
+	passwordHash := handler.authService.HashPassword("Sup3r$ecretPass", []byte("0123456789abcdef"))
+	now := time.Now()
+	mock.ExpectQuery(`SELECT blocked_until`).WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}))
+	mock.ExpectQuery(`SELECT attempts`).WillReturnRows(sqlmock.NewRows([]string{"attempts", "exists"}))
+	mock.ExpectQuery(`SELECT COALESCE\(attempts, 0\)`).WillReturnRows(sqlmock.NewRows([]string{"attempts"}))
+	mock.ExpectExec(`INSERT INTO rate_limits`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "tenant_id", "email", "password_hash", "first_name", "last_name",
+			"phone_number", "phone_verified", "role", "is_active", "two_factor_enabled", "two_factor_method",
+			"last_login_at", "failed_login_attempts", "locked_until", "created_at", "updated_at", "email_verified",
+		}).AddRow(
+			"user-1", "tenant-1", "user@example.com", passwordHash, "Test", "User",
+			"", false, "user", true, true, "totp",
+			nil, 0, nil, now, now, true,
+		))
+}
+
+const trustedLoginBody = `{"email": "user@example.com", "password": "Sup3r$ecretPass", "trusted_device_token": "trust-token"}`
+
+func TestLogin_TrustedDeviceSkips2FA(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	expectTOTPUserLogin(handler, mock)
+	mock.ExpectExec(`UPDATE trusted_devices\s+SET last_used_at = NOW\(\)`).
+		WithArgs(sqlmock.AnyArg(), "user-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "2fa_skipped_trusted_device", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users`).WithArgs("user-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE rate_limits`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "login_success", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performJSON(handler.Login, trustedLoginBody)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp LoginResponse
+	decodeJSON(t, w, &resp)
+	assert.False(t, resp.Requires2FA)
+	assert.NotEmpty(t, resp.Tokens.AccessToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLogin_ExpiredTrustTokenRequires2FA(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+
+	// The expires_at filter matches nothing once the 30 days are up
+	expectTOTPUserLogin(handler, mock)
+	mock.ExpectExec(`UPDATE trusted_devices\s+SET last_used_at = NOW\(\)\s+WHERE .* AND expires_at > NOW\(\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO two_factor_challenges`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performJSON(handler.Login, trustedLoginBody)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp LoginResponse
+	decodeJSON(t, w, &resp)
+	assert.True(t, resp.Requires2FA)
+	assert.Nil(t, resp.Tokens)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeTrustedDevice_NextLoginRequires2FA(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+	deviceID := "44444444-4444-4444-4444-444444444444"
+
+	mock.ExpectExec(`DELETE FROM trusted_devices WHERE id = \$1 AND user_id = \$2`).
+		WithArgs(deviceID, "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "trusted_device_revoked", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performAuthenticated(handler.RevokeTrustedDevice, http.MethodDelete, "", gin.Params{{Key: "id", Value: deviceID}})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The row is gone, so the old token no longer matches
+	expectTOTPUserLogin(handler, mock)
+	mock.ExpectExec(`UPDATE trusted_devices`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO two_factor_challenges`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w = performJSON(handler.Login, trustedLoginBody)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp LoginResponse
+	decodeJSON(t, w, &resp)
+	assert.True(t, resp.Requires2FA)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// fakeCaptcha accepts one token and reports err for every other
+type fakeCaptcha struct {
+	valid    string
+	err      error
+	remoteIP string
+}
+
+func (f *fakeCaptcha) Verify(ctx context.Context, token, remoteIP string) error {
+	f.remoteIP = remoteIP
+	if token == f.valid {
+		return nil
+	}
+	return f.err
+}
+
+// expectAttemptRecorded mocks the rate limiter letting a request through and
+// counting it
+func expectAttemptRecorded(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT blocked_until`).WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}))
+	mock.ExpectQuery(`SELECT attempts`).WillReturnRows(sqlmock.NewRows([]string{"attempts", "exists"}))
+	mock.ExpectQuery(`SELECT COALESCE\(attempts, 0\)`).WillReturnRows(sqlmock.NewRows([]string{"attempts"}))
+	mock.ExpectExec(`INSERT INTO rate_limits`).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+const captchaRegisterBody = `{"email": "user@example.com", "password": "Sup3r$ecretPass", "first_name": "Test", "last_name": "User"%s}`
+
+func TestRegister_CaptchaChecked(t *testing.T) {
+	for _, tc := range []struct {
+		name, token string
+		err         error
+		status      int
+		code        string
+	}{
+		{"missing", "", services.ErrCaptchaFailed, http.StatusBadRequest, `"code":"CAPTCHA_REQUIRED"`},
+		{"rejected", "bad-token", services.ErrCaptchaFailed, http.StatusBadRequest, `"code":"CAPTCHA_FAILED"`},
+		{"provider down", "bad-token", errors.New("connection refused"), http.StatusServiceUnavailable, "unavailable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mock := newTestAuthHandler(t)
+			handler.captcha = &fakeCaptcha{valid: "good-token", err: tc.err}
+
+			expectAttemptRecorded(mock)
+			body := fmt.Sprintf(captchaRegisterBody, "")
+			if tc.token != "" {
+				body = fmt.Sprintf(captchaRegisterBody, `, "captcha_token": "`+tc.token+`"`)
+			}
+			w := performJSON(handler.Register, body)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Contains(t, w.Body.String(), tc.code)
+			assert.NoError(t, mock.ExpectationsWereMet(), "the user lookup never ran")
+		})
+	}
+}
+
+func TestRegister_ValidCaptchaPassesClientIP(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+	handler.emailDomains = services.NewEmailDomainValidator(net.DefaultResolver, nil)
+	captcha := &fakeCaptcha{valid: "good-token", err: services.ErrCaptchaFailed}
+	handler.captcha = captcha
+
+	// Gets as far as the next check
+	expectAttemptRecorded(mock)
+	w := performJSON(handler.Register, `{"email": "user@mailinator.com", "password": "Sup3r$ecretPass", "first_name": "Test", "last_name": "User", "captcha_token": "good-token"}`)
+
+	assert.Contains(t, w.Body.String(), `"code":"DISPOSABLE_EMAIL"`)
+	assert.Equal(t, "192.0.2.1", captcha.remoteIP)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLogin_CaptchaRequiredAfterFailures(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+	handler.captcha = &fakeCaptcha{valid: "good-token", err: services.ErrCaptchaFailed}
+
+	expectAttemptRecorded(mock)
+	mock.ExpectQuery(`SELECT blocked_until`).
+		WithArgs("192.0.2.1", "login_captcha").
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}).AddRow(time.Now().Add(time.Hour)))
+
+	w := performJSON(handler.Login, `{"email": "user@example.com", "password": "Sup3r$ecretPass"}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"CAPTCHA_REQUIRED"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLogin_FailureCountsTowardsCaptcha(t *testing.T) {
+	handler, mock := newTestAuthHandler(t)
+	handler.captcha = &fakeCaptcha{valid: "good-token", err: services.ErrCaptchaFailed}
+
+	// No CAPTCHA needed yet, so none is sent
+	expectAttemptRecorded(mock)
+	mock.ExpectQuery(`SELECT blocked_until`).
+		WithArgs("192.0.2.1", "login_captcha").
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}))
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec(`INSERT INTO security_audit_log`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COALESCE\(attempts, 0\)`).
+		WithArgs("192.0.2.1", "login_captcha", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO rate_limits`).
+		WithArgs("192.0.2.1", "login_captcha", 2, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := performJSON(handler.Login, `{"email": "user@example.com", "password": "Sup3r$ecretPass"}`)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// uniqueEmailDriver is a minimal database/sql driver standing in for Postgres
+// during concurrent registrations. Selects find nothing, and inserting a user
+// whose email was already inserted fails like the unique index would.
+type uniqueEmailDriver struct {
+	mu     sync.Mutex
+	emails map[string]bool
+}
+
+type uniqueEmailConn struct{ d *uniqueEmailDriver }
+type uniqueEmailStmt struct {
+	d     *uniqueEmailDriver
+	query string
+}
+type uniqueEmailTx struct{}
+type noRows struct{}
+
+func (d *uniqueEmailDriver) Open(string) (driver.Conn, error) { return uniqueEmailConn{d}, nil }
+func (d *uniqueEmailDriver) Connect(context.Context) (driver.Conn, error) {
+	return uniqueEmailConn{d}, nil
+}
+func (d *uniqueEmailDriver) Driver() driver.Driver { return d }
+
+func (c uniqueEmailConn) Prepare(query string) (driver.Stmt, error) {
+	return uniqueEmailStmt{c.d, query}, nil
+}
+func (c uniqueEmailConn) Close() error              { return nil }
+func (c uniqueEmailConn) Begin() (driver.Tx, error) { return uniqueEmailTx{}, nil }
+
+func (uniqueEmailTx) Commit() error   { return nil }
+func (uniqueEmailTx) Rollback() error { return nil }
+
+func (s uniqueEmailStmt) Close() error  { return nil }
+func (s uniqueEmailStmt) NumInput() int { return -1 }
+func (s uniqueEmailStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "INSERT INTO users") {
+		email := args[2].(string)
+		s.d.mu.Lock()
+		defer s.d.mu.Unlock()
+		if s.d.emails[email] {
+			return nil, &pq.Error{Code: "23505", Constraint: "users_email_key"}
+		}
+		s.d.emails[email] = true
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s uniqueEmailStmt) Query([]driver.Value) (driver.Rows, error) { return noRows{}, nil }
+
+func (noRows) Columns() []string         { return []string{"value"} }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+// staticMX answers every MX lookup with a working mail server
+type staticMX struct{}
+
+func (staticMX) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return []*net.MX{{Host: "mx." + name + ".", Pref: 10}}, nil
+}
+
+func TestRegister_ConcurrentSameEmailOnlyOneSucceeds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := sql.OpenDB(&uniqueEmailDriver{emails: map[string]bool{}})
+	t.Cleanup(func() { db.Close() })
+
+	authService := services.NewAuthServiceWithArgon2(db, "test-secret", testArgon2Params)
+	handler := &AuthHandler{
+		authService:   authService,
+		rateLimiter:   services.NewRateLimiter(db),
+		emailVerifier: services.NewEmailVerificationService(db, services.LogEmailSender{}),
+		emailDomains:  services.NewEmailDomainValidator(staticMX{}, nil),
+		db:            db,
+	}
+
+	const attempts = 8
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := performJSON(handler.Register, `{"email": "race@example.com", "password": "Sup3r$ecretPass", "first_name": "Test", "last_name": "User"}`)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusCreated: 1, http.StatusConflict: attempts - 1}, counts)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CompAdjustmentSettingsHandler manages the adjustment schedule a tenant's
+// comps are adjusted by
+type CompAdjustmentSettingsHandler struct {
+	settings *services.CompAdjustmentSettingsRepository
+}
+
+// NewCompAdjustmentSettingsHandler creates a new comp adjustment settings
+// handler
+func NewCompAdjustmentSettingsHandler() *CompAdjustmentSettingsHandler {
+	return &CompAdjustmentSettingsHandler{
+		settings: services.NewCompAdjustmentSettingsRepository(database.GetDB()),
+	}
+}
+
+// GetCompAdjustments returns the caller's tenant's adjustment schedule
+func (h *CompAdjustmentSettingsHandler) GetCompAdjustments(c *gin.Context) {
+	schedule, err := h.settings.Get(c.GetString("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load comp adjustment settings",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"settings": schedule,
+	})
+}
+
+// UpdateCompAdjustments changes the caller's tenant's adjustment schedule.
+// Fields left out keep their current value.
+func (h *CompAdjustmentSettingsHandler) UpdateCompAdjustments(c *gin.Context) {
+	var req services.CompAdjustmentOverrides
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Adjustments can't be negative",
+		})
+		return
+	}
+
+	schedule, err := h.settings.Update(c.GetString("tenant_id"), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to save comp adjustment settings",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"settings": schedule,
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCompSettingsHandler(t *testing.T) (*CompAdjustmentSettingsHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &CompAdjustmentSettingsHandler{settings: services.NewCompAdjustmentSettingsRepository(db)}, mock
+}
+
+func TestGetCompAdjustments_DefaultsWithoutSettings(t *testing.T) {
+	handler, mock := newTestCompSettingsHandler(t)
+	expectCompSettingsLookup(mock, "tenant-1", nil)
+
+	w := performComparableRequest(handler.GetCompAdjustments, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Settings services.CompAdjustmentSchedule `json:"settings"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, services.DefaultCompAdjustmentSchedule(), resp.Settings)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateCompAdjustments_KeepsFieldsLeftOut(t *testing.T) {
+	handler, mock := newTestCompSettingsHandler(t)
+	expectCompSettingsLookup(mock, "tenant-1", &services.CompAdjustmentSchedule{PerBedroom: 8000, PerBathroom: 4000, PerSqFt: 60})
+	mock.ExpectExec(`INSERT INTO comp_adjustment_settings .* ON CONFLICT \(tenant_id, market\) DO UPDATE`).
+		WithArgs("tenant-1", 8000.0, 4000.0, 75.0, true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	body := `{"per_sqft": 75, "derive_per_sqft": true}`
+	w := performComparableRequest(handler.UpdateCompAdjustments, "tenant-1", http.MethodPut, body)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Settings services.CompAdjustmentSchedule `json:"settings"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, services.CompAdjustmentSchedule{PerBedroom: 8000, PerBathroom: 4000, PerSqFt: 75, DerivePerSqFt: true}, resp.Settings)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateCompAdjustments_RejectsNegative(t *testing.T) {
+	handler, mock := newTestCompSettingsHandler(t)
+
+	w := performComparableRequest(handler.UpdateCompAdjustments, "tenant-1", http.MethodPut, `{"per_bedroom": -1}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ComparableHandler manages the comparable sales saved against a tenant's
+// properties
+type ComparableHandler struct {
+	comparables *services.ComparableRepository
+}
+
+// NewComparableHandler creates a new comparable handler
+func NewComparableHandler() *ComparableHandler {
+	return &ComparableHandler{
+		comparables: services.NewComparableRepository(database.GetDB()),
+	}
+}
+
+// ListComparables returns the comparables saved for a property
+func (h *ComparableHandler) ListComparables(c *gin.Context) {
+	comps, err := h.comparables.List(c.GetString("tenant_id"), c.Param("id"))
+	if respondComparableError(c, err, "Failed to load comparables") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"comparables": comps,
+	})
+}
+
+// CreateComparable saves a comparable sale for a property
+func (h *ComparableHandler) CreateComparable(c *gin.Context) {
+	var req services.ComparableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Address, sale price and sale date are required, and distance can't be negative",
+		})
+		return
+	}
+
+	comp, err := h.comparables.Create(c.GetString("tenant_id"), c.Param("id"), req)
+	if respondComparableError(c, err, "Failed to save comparable") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":    true,
+		"comparable": comp,
+	})
+}
+
+// UpdateComparable replaces a comparable saved for a property
+func (h *ComparableHandler) UpdateComparable(c *gin.Context) {
+	var req services.ComparableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Address, sale price and sale date are required, and distance can't be negative",
+		})
+		return
+	}
+
+	comp, err := h.comparables.Update(c.GetString("tenant_id"), c.Param("id"), c.Param("compID"), req)
+	if respondComparableError(c, err, "Failed to update comparable") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"comparable": comp,
+	})
+}
+
+// DeleteComparable removes a comparable saved for a property
+func (h *ComparableHandler) DeleteComparable(c *gin.Context) {
+	err := h.comparables.Delete(c.GetString("tenant_id"), c.Param("id"), c.Param("compID"))
+	if respondComparableError(c, err, "Failed to delete comparable") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Comparable deleted",
+	})
+}
+
+// EstimateARV estimates a property's ARV from its saved comparables,
+// adjusted by the tenant's schedule with any adjustments the request
+// overrides
+func (h *ComparableHandler) EstimateARV(c *gin.Context) {
+	var req struct {
+		Adjustments *services.CompAdjustmentOverrides `json:"adjustments"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Adjustment overrides can't be negative",
+			})
+			return
+		}
+	}
+
+	estimate, err := h.comparables.EstimateARV(c.GetString("tenant_id"), c.Param("id"), req.Adjustments)
+	if errors.Is(err, services.ErrNoComparables) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Save at least one comparable before estimating ARV",
+		})
+		return
+	}
+	if respondComparableError(c, err, "Failed to estimate ARV") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    estimate,
+	})
+}
+
+// respondComparableError writes the response for a failed comparable
+// lookup or change and reports whether there was one
+func respondComparableError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrComparableNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Comparable not found",
+		})
+	case errors.Is(err, services.ErrInvalidSaleDate):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Sale date must be formatted YYYY-MM-DD",
+		})
+	case errors.Is(err, services.ErrPropertyFieldBlank):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Address cannot be blank",
+		})
+	default:
+		return respondPropertyError(c, err, fallback)
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testComparableID = "7a6b5c4d-3e2f-4a1b-9c8d-7e6f5a4b3c2d"
+
+func newTestComparableHandler(t *testing.T) (*ComparableHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &ComparableHandler{comparables: services.NewComparableRepository(db)}, mock
+}
+
+// performComparableRequest calls handler for testPropertyID and
+// testComparableID as a user of tenantID
+func performComparableRequest(handler gin.HandlerFunc, tenantID, method, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: testPropertyID}, {Key: "compID", Value: testComparableID}}
+	c.Set("user_id", "user-1")
+	c.Set("tenant_id", tenantID)
+	handler(c)
+	return w
+}
+
+// expectPropertyLookup expects tenantID's property to be loaded, and found
+// if found is set
+func expectPropertyLookup(mock sqlmock.Sqlmock, tenantID string, found bool) {
+	rows := sqlmock.NewRows(propertyRowColumns)
+	if found {
+		rows = propertyRows(tenantID, testPropertyID)
+	}
+	mock.ExpectQuery(`FROM properties\s+WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+		WithArgs(testPropertyID, tenantID).
+		WillReturnRows(rows)
+}
+
+var compSettingsRowColumns = []string{"per_bedroom", "per_bathroom", "per_sqft", "derive_per_sqft"}
+
+// expectCompSettingsLookup expects tenantID's comp adjustment settings to
+// be loaded, and found when schedule isn't nil
+func expectCompSettingsLookup(mock sqlmock.Sqlmock, tenantID string, schedule *services.CompAdjustmentSchedule) {
+	rows := sqlmock.NewRows(compSettingsRowColumns)
+	if schedule != nil {
+		rows.AddRow(schedule.PerBedroom, schedule.PerBathroom, schedule.PerSqFt, schedule.DerivePerSqFt)
+	}
+	mock.ExpectQuery(`FROM comp_adjustment_settings\s+WHERE tenant_id = \$1 AND market = ''`).
+		WithArgs(tenantID).
+		WillReturnRows(rows)
+}
+
+var comparableRowColumns = []string{
+	"id", "property_id", "address", "sale_price", "sale_date", "distance", "bedrooms", "bathrooms",
+	"square_feet", "price_per_sq_ft", "adjustments", "adjusted_value", "created_at",
+}
+
+func comparableRow(salePrice, distance, adjustments float64) *sqlmock.Rows {
+	return sqlmock.NewRows(comparableRowColumns).AddRow(testComparableID, testPropertyID, "456 Oak Ave",
+		salePrice, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), distance, 2, 1.0, 1200,
+		salePrice/1200, adjustments, salePrice+adjustments, time.Now())
+}
+
+func TestCreateComparable_StoresAdjustments(t *testing.T) {
+	handler, mock := newTestComparableHandler(t)
+
+	// The property has 3 beds, 2 baths and 1400 sq ft; the comp is 1 bed,
+	// 1 bath and 200 sq ft smaller: 5000 + 3000 + 200*50
+	expectPropertyLookup(mock, "tenant-1", true)
+	expectCompSettingsLookup(mock, "tenant-1", nil)
+	mock.ExpectQuery(`INSERT INTO comparables`).
+		WithArgs(testPropertyID, "456 Oak Ave", 240000.0, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			0.5, 2, 1.0, 1200, 18000.0).
+		WillReturnRows(comparableRow(240000, 0.5, 18000))
+
+	body := `{"address": "456 Oak Ave", "sale_price": 240000, "sale_date": "2026-03-01",
+		"distance": 0.5, "bedrooms": 2, "bathrooms": 1, "square_feet": 1200}`
+	w := performComparableRequest(handler.CreateComparable, "tenant-1", http.MethodPost, body)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"adjusted_value":258000`)
+	assert.Contains(t, w.Body.String(), `"price_per_sq_ft":200`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateComparable_ValidationFailures(t *testing.T) {
+	cases := map[string]string{
+		"missing address":    `{"sale_price": 1, "sale_date": "2026-03-01"}`,
+		"blank address":      `{"address": "  ", "sale_price": 1, "sale_date": "2026-03-01"}`,
+		"missing sale price": `{"address": "456 Oak Ave", "sale_date": "2026-03-01"}`,
+		"missing sale date":  `{"address": "456 Oak Ave", "sale_price": 1}`,
+		"bad sale date":      `{"address": "456 Oak Ave", "sale_price": 1, "sale_date": "03/01/2026"}`,
+		"negative distance":  `{"address": "456 Oak Ave", "sale_price": 1, "sale_date": "2026-03-01", "distance": -1}`,
+	}
+	for name, body := range cases {
+		handler, mock := newTestComparableHandler(t)
+		// Only bodies that bind get as far as loading the property
+		if strings.Contains(name, "blank") || strings.Contains(name, "bad") {
+			expectPropertyLookup(mock, "tenant-1", true)
+		}
+
+		w := performComparableRequest(handler.CreateComparable, "tenant-1", http.MethodPost, body)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		assert.NoError(t, mock.ExpectationsWereMet(), name)
+	}
+}
+
+func TestComparables_OtherTenantsPropertyNotFound(t *testing.T) {
+	body := `{"address": "456 Oak Ave", "sale_price": 240000, "sale_date": "2026-03-01"}`
+	for name, call := range map[string]func(*ComparableHandler) *httptest.ResponseRecorder{
+		"list": func(h *ComparableHandler) *httptest.ResponseRecorder {
+			return performComparableRequest(h.ListComparables, "tenant-2", http.MethodGet, "")
+		},
+		"create": func(h *ComparableHandler) *httptest.ResponseRecorder {
+			return performComparableRequest(h.CreateComparable, "tenant-2", http.MethodPost, body)
+		},
+		"update": func(h *ComparableHandler) *httptest.ResponseRecorder {
+			return performComparableRequest(h.UpdateComparable, "tenant-2", http.MethodPut, body)
+		},
+		"delete": func(h *ComparableHandler) *httptest.ResponseRecorder {
+			return performComparableRequest(h.DeleteComparable, "tenant-2", http.MethodDelete, "")
+		},
+		"estimate": func(h *ComparableHandler) *httptest.ResponseRecorder {
+			return performComparableRequest(h.EstimateARV, "tenant-2", http.MethodPost, "")
+		},
+	} {
+		handler, mock := newTestComparableHandler(t)
+		expectPropertyLookup(mock, "tenant-2", false)
+
+		w := call(handler)
+
+		assert.Equal(t, http.StatusNotFound, w.Code, name)
+		assert.NoError(t, mock.ExpectationsWereMet(), name)
+	}
+}
+
+func TestUpdateComparable_RecalculatesAdjustments(t *testing.T) {
+	handler, mock := newTestComparableHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	expectCompSettingsLookup(mock, "tenant-1", nil)
+	mock.ExpectQuery(`UPDATE comparables\s+SET address = \$3.*WHERE id = \$1 AND property_id = \$2`).
+		WithArgs(testComparableID, testPropertyID, "456 Oak Ave", 250000.0, sqlmock.AnyArg(),
+			0.0, 3, 2.0, 1400, 0.0).
+		WillReturnRows(comparableRow(250000, 0, 0))
+
+	body := `{"address": "456 Oak Ave", "sale_price": 250000, "sale_date": "2026-03-01",
+		"bedrooms": 3, "bathrooms": 2, "square_feet": 1400}`
+	w := performComparableRequest(handler.UpdateComparable, "tenant-1", http.MethodPut, body)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteComparable_NotFound(t *testing.T) {
+	handler, mock := newTestComparableHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectExec(`DELETE FROM comparables WHERE id = \$1 AND property_id = \$2`).
+		WithArgs(testComparableID, testPropertyID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := performComparableRequest(handler.DeleteComparable, "tenant-1", http.MethodDelete, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEstimateARV_FromSavedComparables(t *testing.T) {
+	handler, mock := newTestComparableHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM comparables\s+WHERE property_id = \$1\s+ORDER BY sale_date DESC`).
+		WithArgs(testPropertyID).
+		WillReturnRows(comparableRow(240000, 0, 18000))
+	expectCompSettingsLookup(mock, "tenant-1", nil)
+
+	w := performComparableRequest(handler.EstimateARV, "tenant-1", http.MethodPost, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data services.ARVEstimate `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, 258000.0, resp.Data.EstimatedARV)
+	assert.Equal(t, 1, resp.Data.ComparablesUsed)
+	assert.Equal(t, 1400, resp.Data.SquareFeet)
+	assert.Equal(t, services.DefaultCompAdjustmentSchedule(), resp.Data.AdjustmentSchedule)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEstimateARV_AdjustmentScheduleFallback(t *testing.T) {
+	// The comp is 1 bed, 1 bath and 200 sq ft smaller than the property
+	tenantSchedule := &services.CompAdjustmentSchedule{PerBedroom: 10000, PerBathroom: 5000, PerSqFt: 100}
+	cases := []struct {
+		name     string
+		schedule *services.CompAdjustmentSchedule
+		body     string
+		arv      float64
+	}{
+		{"built-in", nil, "", 258000},          // 5000 + 3000 + 200*50
+		{"tenant", tenantSchedule, "", 275000}, // 10000 + 5000 + 200*100
+		{"request", tenantSchedule, `{"adjustments": {"per_bedroom": 0, "per_sqft": 25}}`, 250000}, // 0 + 5000 + 200*25
+		{"derived", nil, `{"adjustments": {"derive_per_sqft": true}}`, 288000},                     // 5000 + 3000 + 200*200
+	}
+	for _, tc := range cases {
+		handler, mock := newTestComparableHandler(t)
+		expectPropertyLookup(mock, "tenant-1", true)
+		mock.ExpectQuery(`FROM comparables`).
+			WithArgs(testPropertyID).
+			WillReturnRows(comparableRow(240000, 0, 18000))
+		expectCompSettingsLookup(mock, "tenant-1", tc.schedule)
+
+		w := performComparableRequest(handler.EstimateARV, "tenant-1", http.MethodPost, tc.body)
+
+		require.Equal(t, http.StatusOK, w.Code, tc.name)
+		var resp struct {
+			Data services.ARVEstimate `json:"data"`
+		}
+		decodeJSON(t, w, &resp)
+		assert.Equal(t, tc.arv, resp.Data.EstimatedARV, tc.name)
+		assert.NoError(t, mock.ExpectationsWereMet(), tc.name)
+	}
+}
+
+func TestEstimateARV_NegativeOverride(t *testing.T) {
+	handler, mock := newTestComparableHandler(t)
+
+	w := performComparableRequest(handler.EstimateARV, "tenant-1", http.MethodPost, `{"adjustments": {"per_sqft": -50}}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEstimateARV_NoComparables(t *testing.T) {
+	handler, mock := newTestComparableHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM comparables`).WillReturnRows(sqlmock.NewRows(comparableRowColumns))
+
+	w := performComparableRequest(handler.EstimateARV, "tenant-1", http.MethodPost, "")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
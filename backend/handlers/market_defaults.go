@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MarketDefaultsHandler manages the vacancy and credit loss a tenant's
+// deals assume, tenant-wide or by state or zip code prefix
+type MarketDefaultsHandler struct {
+	defaults *services.MarketDefaultsRepository
+}
+
+// NewMarketDefaultsHandler creates a new market defaults handler
+func NewMarketDefaultsHandler() *MarketDefaultsHandler {
+	return &MarketDefaultsHandler{
+		defaults: services.NewMarketDefaultsRepository(database.GetDB()),
+	}
+}
+
+// ListMarketDefaults returns every market the caller's tenant has set
+// defaults for
+func (h *MarketDefaultsHandler) ListMarketDefaults(c *gin.Context) {
+	defaults, err := h.defaults.List(c.GetString("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load market defaults",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"settings": defaults,
+	})
+}
+
+// SetMarketDefaults replaces the caller's tenant's defaults for one market
+func (h *MarketDefaultsHandler) SetMarketDefaults(c *gin.Context) {
+	var req services.MarketDefaults
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Rates must be percentages between 0 and 100",
+		})
+		return
+	}
+
+	defaults, err := h.defaults.Set(c.GetString("tenant_id"), req)
+	switch {
+	case errors.Is(err, services.ErrInvalidMarket):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	case errors.Is(err, services.ErrMarketDefaultsNotOnPlan):
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Market defaults are available on the Professional plan",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to save market defaults",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"settings": defaults,
+	})
+}
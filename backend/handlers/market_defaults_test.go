@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var marketDefaultsRowColumns = []string{"market", "vacancy_rate", "credit_loss_rate"}
+
+func newTestMarketDefaultsHandler(t *testing.T) (*MarketDefaultsHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &MarketDefaultsHandler{defaults: services.NewMarketDefaultsRepository(db)}, mock
+}
+
+// expectTenantTier expects tenantID's subscription tier to be loaded
+func expectTenantTier(mock sqlmock.Sqlmock, tenantID string, tier services.SubscriptionTier) {
+	mock.ExpectQuery(`SELECT subscription_tier FROM tenants WHERE id = \$1`).
+		WithArgs(tenantID).
+		WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow(string(tier)))
+}
+
+func TestSetMarketDefaults(t *testing.T) {
+	handler, mock := newTestMarketDefaultsHandler(t)
+	expectTenantTier(mock, "tenant-1", services.TierProfessional)
+	mock.ExpectExec(`INSERT INTO comp_adjustment_settings .* ON CONFLICT \(tenant_id, market\) DO UPDATE`).
+		WithArgs("tenant-1", "441", 10.0, 2.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	body := `{"market": "441", "vacancy_rate": 10, "credit_loss_rate": 2}`
+	w := performComparableRequest(handler.SetMarketDefaults, "tenant-1", http.MethodPut, body)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetMarketDefaults_NeedsProfessionalPlan(t *testing.T) {
+	handler, mock := newTestMarketDefaultsHandler(t)
+	expectTenantTier(mock, "tenant-1", services.TierStarter)
+
+	w := performComparableRequest(handler.SetMarketDefaults, "tenant-1", http.MethodPut, `{"market": "OH", "vacancy_rate": 10}`)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetMarketDefaults_RejectsInvalidMarket(t *testing.T) {
+	handler, mock := newTestMarketDefaultsHandler(t)
+
+	w := performComparableRequest(handler.SetMarketDefaults, "tenant-1", http.MethodPut, `{"market": "Ohio", "vacancy_rate": 10}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCalculateARV_UsesMarketDefaults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	handler := &ArvHandler{
+		arvService:     services.NewArvService(),
+		marketDefaults: services.NewMarketDefaultsRepository(db),
+	}
+	mock.ExpectQuery(`FROM comp_adjustment_settings\s+WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows(marketDefaultsRowColumns).
+			AddRow("", 6.0, nil).
+			AddRow("441", 10.0, 2.0))
+
+	body := `{"purchase_price": 150000, "rehab_cost": 30000, "arv": 250000, "monthly_rent": 2000, "loan_term": 30, "state": "OH", "zip_code": "44102"}`
+	w := performComparableRequest(handler.CalculateARV, "tenant-1", http.MethodPost, body)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data services.ArvResult `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, 10.0, resp.Data.VacancyRate)
+	assert.Equal(t, 2.0, resp.Data.CreditLossRate)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Anonymous callers get the built-in defaults
+	w = performComparableRequest(handler.CalculateARV, "", http.MethodPost, body)
+	require.Equal(t, http.StatusOK, w.Code)
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, 8.0, resp.Data.VacancyRate)
+	assert.Zero(t, resp.Data.CreditLossRate)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PortfolioHandler serves the dashboard's view of a tenant's portfolio
+type PortfolioHandler struct {
+	portfolio *services.PortfolioService
+}
+
+// NewPortfolioHandler creates a new portfolio handler
+func NewPortfolioHandler() *PortfolioHandler {
+	return &PortfolioHandler{
+		portfolio: services.NewPortfolioService(database.GetDB()),
+	}
+}
+
+// GetSummary returns the caller's tenant's portfolio totals and most
+// recently updated deals
+func (h *PortfolioHandler) GetSummary(c *gin.Context) {
+	summary, err := h.portfolio.Summary(c.GetString("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load portfolio summary",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    summary,
+	})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPortfolioHandler(t *testing.T) (*PortfolioHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &PortfolioHandler{portfolio: services.NewPortfolioService(db)}, mock
+}
+
+func TestPortfolioSummary_Aggregates(t *testing.T) {
+	handler, mock := newTestPortfolioHandler(t)
+
+	// A dozen properties: 4 leads, 3 analyzing, 2 rehabbing, 1 rented, 2 dead
+	mock.ExpectQuery(`SELECT status, COUNT\(\*\) FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).
+			AddRow("lead", 4).AddRow("analyzing", 3).AddRow("rehabbing", 2).AddRow("rented", 1).AddRow("dead", 2))
+	mock.ExpectQuery(`SUM\(.*\)\s+FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL AND status <> \$2`).
+		WithArgs("tenant-1", "dead").
+		WillReturnRows(sqlmock.NewRows([]string{"invested", "arv"}).AddRow(1850000.0, 2600000.0))
+	mock.ExpectQuery(`SELECT DISTINCT ON \(c.property_id\).*FROM arv_calculations c.*p.archived_at IS NULL.*ORDER BY c.property_id, c.created_at DESC`).
+		WithArgs("tenant-1", "dead").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "cash_flow", "cap_rate"}).AddRow(3, 1275.5, 7.456666))
+	mock.ExpectQuery(`FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL\s+ORDER BY updated_at DESC, id\s+LIMIT \$2`).
+		WithArgs("tenant-1", 5).
+		WillReturnRows(propertyRows("tenant-1", "property-12", "property-11", "property-10", "property-9", "property-8"))
+
+	w := performAuthenticated(handler.GetSummary, http.MethodGet, "", nil)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data services.PortfolioSummary `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	summary := resp.Data
+	assert.Equal(t, 12, summary.PropertyCount)
+	assert.Equal(t, 4, summary.StatusCounts["lead"])
+	assert.Equal(t, 2, summary.StatusCounts["dead"])
+	assert.Equal(t, 0, summary.StatusCounts["sold"])
+	assert.Equal(t, 1850000.0, summary.TotalInvestedCapital)
+	assert.Equal(t, 2600000.0, summary.TotalEstimatedARV)
+	assert.Equal(t, 3, summary.PropertiesAnalyzed)
+	assert.Equal(t, 1275.5, summary.MonthlyCashFlow)
+	assert.Equal(t, 7.46, summary.AverageCapRate)
+	require.Len(t, summary.RecentlyUpdatedDeals, 5)
+	assert.Equal(t, "property-12", summary.RecentlyUpdatedDeals[0].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPortfolioSummary_EmptyPortfolio(t *testing.T) {
+	handler, mock := newTestPortfolioHandler(t)
+
+	mock.ExpectQuery(`SELECT status, COUNT\(\*\) FROM properties`).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}))
+
+	w := performAuthenticated(handler.GetSummary, http.MethodGet, "", nil)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"property_count":0`)
+	assert.Contains(t, w.Body.String(), `"recently_updated_deals":[]`)
+	assert.Contains(t, w.Body.String(), `"average_cap_rate":0`)
+	assert.NoError(t, mock.ExpectationsWereMet(), "no totals are queried")
+}
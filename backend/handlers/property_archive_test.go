@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"arvfinder-backend/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// archivedPropertyRows is propertyRows for properties archived at archivedAt
+func archivedPropertyRows(tenantID string, archivedAt time.Time, ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(propertyRowColumns)
+	for _, id := range ids {
+		rows.AddRow(id, tenantID, "123 Main St", "Denver", "CO", "80202", 180000.0, 250000.0, 0.0, 0.0,
+			0.0, 3, 2.0, 1400, 0.0, 1990, "single_family", "", "lead", archivedAt, archivedAt, archivedAt)
+	}
+	return rows
+}
+
+func TestArchiveProperty_SetsArchivedAt(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+	archivedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`UPDATE properties SET\s+archived_at = COALESCE\(archived_at, NOW\(\)\),\s+`+
+		`updated_at = CASE WHEN archived_at IS NULL THEN NOW\(\) ELSE updated_at END\s+`+
+		`WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL\s+RETURNING`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(archivedPropertyRows("tenant-1", archivedAt, testPropertyID))
+
+	w := performPropertyRequest(handler.ArchiveProperty, "tenant-1", http.MethodPost, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Property models.Property `json:"property"`
+	}
+	decodeJSON(t, w, &resp)
+	require.NotNil(t, resp.Property.ArchivedAt)
+	assert.True(t, archivedAt.Equal(*resp.Property.ArchivedAt))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveProperty_AlreadyArchivedIsNoOp(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+	archivedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// COALESCE keeps the original archived_at, so archiving twice succeeds
+	// without moving it
+	mock.ExpectQuery(`UPDATE properties SET\s+archived_at = COALESCE\(archived_at, NOW\(\)\)`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(archivedPropertyRows("tenant-1", archivedAt, testPropertyID))
+
+	w := performPropertyRequest(handler.ArchiveProperty, "tenant-1", http.MethodPost, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"archived_at":"2024-03-01T12:00:00Z"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveProperty_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`UPDATE properties SET\s+archived_at`).
+		WithArgs(testPropertyID, "tenant-2").
+		WillReturnRows(sqlmock.NewRows(propertyRowColumns))
+
+	w := performPropertyRequest(handler.ArchiveProperty, "tenant-2", http.MethodPost, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnarchiveProperty_ClearsArchivedAt(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`UPDATE properties SET\s+archived_at = NULL,\s+`+
+		`updated_at = CASE WHEN archived_at IS NOT NULL THEN NOW\(\) ELSE updated_at END\s+`+
+		`WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+
+	w := performPropertyRequest(handler.UnarchiveProperty, "tenant-1", http.MethodPost, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "archived_at")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetProperty_ArchivedStillReadable(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+	archivedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`FROM properties\s+WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(archivedPropertyRows("tenant-1", archivedAt, testPropertyID))
+	expectNoUnits(mock)
+
+	w := performPropertyRequest(handler.GetProperty, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"archived_at"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProperties_ArchivedFilter(t *testing.T) {
+	for query, condition := range map[string]string{
+		"":               ` AND archived_at IS NULL`,
+		"archived=false": ` AND archived_at IS NULL`,
+		"archived=true":  ` AND archived_at IS NOT NULL`,
+		"archived=all":   ``,
+	} {
+		t.Run(query, func(t *testing.T) {
+			handler, mock := newTestPropertyCRUDHandler(t)
+
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM properties WHERE tenant_id = \$1 AND deleted_at IS NULL` + condition + `$`).
+				WithArgs("tenant-1").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery(`WHERE tenant_id = \$1 AND deleted_at IS NULL`+condition+`\s+ORDER BY`).
+				WithArgs("tenant-1", 20, 0, "user-1").
+				WillReturnRows(listedPropertyRows("tenant-1", false, "property-1"))
+
+			w := listProperties(handler, "tenant-1", query)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestListProperties_InvalidArchivedFilter(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	w := listProperties(handler, "tenant-1", "archived=maybe")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "archived must be true, false or all")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPortfolioSummary_ExcludesArchivedProperties(t *testing.T) {
+	handler, mock := newTestPortfolioHandler(t)
+
+	// Every aggregate skips archived properties, so archiving the tenant's
+	// only other deal leaves just the one counted here
+	mock.ExpectQuery(`SELECT status, COUNT\(\*\) FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("rented", 1))
+	mock.ExpectQuery(`FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL AND status <> \$2`).
+		WithArgs("tenant-1", "dead").
+		WillReturnRows(sqlmock.NewRows([]string{"invested", "arv"}).AddRow(180000.0, 250000.0))
+	mock.ExpectQuery(`FROM arv_calculations c.*WHERE p.tenant_id = \$1 AND p.deleted_at IS NULL AND p.archived_at IS NULL`).
+		WithArgs("tenant-1", "dead").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "cash_flow", "cap_rate"}).AddRow(1, 400.0, 6.5))
+	mock.ExpectQuery(`FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL\s+ORDER BY updated_at DESC`).
+		WithArgs("tenant-1", 5).
+		WillReturnRows(propertyRows("tenant-1", "property-1"))
+
+	w := performAuthenticated(handler.GetSummary, http.MethodGet, "", nil)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"property_count":1`)
+	assert.Contains(t, w.Body.String(), `"total_invested_capital":180000`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"arvfinder-backend/models"
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var comparedPropertyIDs = []string{
+	"1a1a1a1a-0000-4000-8000-000000000001",
+	"1a1a1a1a-0000-4000-8000-000000000002",
+	"1a1a1a1a-0000-4000-8000-000000000003",
+	"1a1a1a1a-0000-4000-8000-000000000004",
+}
+
+// compareProperties calls CompareProperties as a user of tenantID
+func compareProperties(handler *PropertyCRUDHandler, tenantID, query string, ids ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := `{"property_ids": ["` + strings.Join(ids, `", "`) + `"]}`
+	c.Request = httptest.NewRequest(http.MethodPost, "/compare?"+query, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", "user-1")
+	c.Set("tenant_id", tenantID)
+	handler.CompareProperties(c)
+	return w
+}
+
+// calculationRow adds a saved calculation for propertyID to rows
+func calculationRow(rows *sqlmock.Rows, propertyID string, cashFlow, coc, profit float64) *sqlmock.Rows {
+	return rows.AddRow("calc-"+propertyID[len(propertyID)-1:], propertyID, "tenant-1", 150000.0, 20000.0, 0.0, 0.0,
+		240000.0, 148000.0, profit, 20.0, 170000.0, cashFlow, coc, false, 7.0, 1.25, "Low", "manual", nil, nil, time.Now())
+}
+
+func expectComparedProperties(mock sqlmock.Sqlmock, tenantID string, ids ...string) {
+	mock.ExpectQuery(`FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND id = ANY\(\$2::uuid\[\]\)`).
+		WithArgs(tenantID, sqlmock.AnyArg()).
+		WillReturnRows(propertyRows(tenantID, ids...))
+}
+
+func decodeComparison(t *testing.T, w *httptest.ResponseRecorder) services.DealComparison {
+	var resp struct {
+		Comparison services.DealComparison `json:"comparison"`
+	}
+	decodeJSON(t, w, &resp)
+	return resp.Comparison
+}
+
+func TestCompareProperties_RanksTiesAndFlagsMissingCalculations(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	ids := comparedPropertyIDs
+	expectComparedProperties(mock, "tenant-1", ids...)
+	rows := sqlmock.NewRows(arvCalculationRowColumns)
+	calculationRow(rows, ids[0], 300, 8, 40000)
+	calculationRow(rows, ids[1], 300, 9, 60000)
+	calculationRow(rows, ids[2], 150, 10, 50000)
+	mock.ExpectQuery(`SELECT DISTINCT ON \(property_id\).*FROM arv_calculations\s+WHERE property_id = ANY\(\$1::uuid\[\]\)`).
+		WillReturnRows(rows)
+
+	w := compareProperties(handler, "tenant-1", "", ids...)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	comparison := decodeComparison(t, w)
+	assert.Equal(t, "cash_flow", comparison.RankBy)
+	require.Len(t, comparison.Deals, 4)
+	for i, deal := range comparison.Deals {
+		assert.Equal(t, ids[i], deal.PropertyID, "deals keep the requested order")
+	}
+	// The two $300 deals tie for first, so the next rank is 3
+	assert.Equal(t, 1, comparison.Deals[0].Rank)
+	assert.Equal(t, 1, comparison.Deals[1].Rank)
+	assert.Equal(t, 3, comparison.Deals[2].Rank)
+	assert.Equal(t, models.Money(14800000), comparison.Deals[0].Metrics.MaxOffer)
+	assert.True(t, comparison.Deals[3].MissingCalculation)
+	assert.Nil(t, comparison.Deals[3].Metrics)
+	assert.Zero(t, comparison.Deals[3].Rank, "deals without a calculation aren't ranked")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompareProperties_RankByProfit(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	ids := comparedPropertyIDs[:3]
+	expectComparedProperties(mock, "tenant-1", ids...)
+	rows := sqlmock.NewRows(arvCalculationRowColumns)
+	calculationRow(rows, ids[0], 300, 8, 40000)
+	calculationRow(rows, ids[1], 200, 9, 60000)
+	calculationRow(rows, ids[2], 150, 10, 50000)
+	mock.ExpectQuery(`FROM arv_calculations`).WillReturnRows(rows)
+
+	w := compareProperties(handler, "tenant-1", "rank_by=profit", ids...)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	comparison := decodeComparison(t, w)
+	assert.Equal(t, []int{3, 1, 2}, []int{comparison.Deals[0].Rank, comparison.Deals[1].Rank, comparison.Deals[2].Rank})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompareProperties_InfiniteReturnRanksFirstByCoC(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	ids := comparedPropertyIDs[:3]
+	expectComparedProperties(mock, "tenant-1", ids...)
+	rows := sqlmock.NewRows(arvCalculationRowColumns)
+	calculationRow(rows, ids[0], 300, 8, 40000)
+	rows.AddRow("calc-2", ids[1], "tenant-1", 150000.0, 20000.0, 0.0, 0.0,
+		240000.0, 148000.0, 60000.0, 20.0, 170000.0, 200.0, nil, true, 7.0, 1.25, "Low", "manual", nil, nil, time.Now())
+	calculationRow(rows, ids[2], 150, 25, 50000)
+	mock.ExpectQuery(`FROM arv_calculations`).WillReturnRows(rows)
+
+	w := compareProperties(handler, "tenant-1", "rank_by=coc", ids...)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	comparison := decodeComparison(t, w)
+	assert.Equal(t, []int{3, 1, 2}, []int{comparison.Deals[0].Rank, comparison.Deals[1].Rank, comparison.Deals[2].Rank})
+	assert.Nil(t, comparison.Deals[1].Metrics.CashOnCashReturn)
+	assert.True(t, comparison.Deals[1].Metrics.IsInfiniteReturn)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompareProperties_UnknownIDs(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	// The second property belongs to another tenant, and the third ID can't
+	// be a property at all
+	ids := []string{comparedPropertyIDs[0], comparedPropertyIDs[1], "not-a-uuid"}
+	expectComparedProperties(mock, "tenant-1", ids[0])
+
+	w := compareProperties(handler, "tenant-1", "", ids...)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var resp struct {
+		PropertyIDs []string `json:"property_ids"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, ids[1:], resp.PropertyIDs)
+	assert.NoError(t, mock.ExpectationsWereMet(), "no calculations are loaded")
+}
+
+func TestCompareProperties_InvalidRequests(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	for name, tc := range map[string]struct {
+		query string
+		ids   []string
+	}{
+		"one property":     {"", comparedPropertyIDs[:1]},
+		"duplicates":       {"", []string{comparedPropertyIDs[0], strings.ToUpper(comparedPropertyIDs[0])}},
+		"six properties":   {"", append(comparedPropertyIDs[:4:4], "a", "b")},
+		"unknown criteria": {"rank_by=roi", comparedPropertyIDs[:2]},
+	} {
+		w := compareProperties(handler, "tenant-1", tc.query, tc.ids...)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PropertyCRUDHandler manages the properties a tenant has saved
+type PropertyCRUDHandler struct {
+	properties   *services.PropertyRepository
+	calculations *services.ArvCalculationRepository
+	units        *services.UnitRepository
+}
+
+// NewPropertyCRUDHandler creates a new property CRUD handler
+func NewPropertyCRUDHandler() *PropertyCRUDHandler {
+	return &PropertyCRUDHandler{
+		properties:   services.NewPropertyRepository(database.GetDB()),
+		calculations: services.NewArvCalculationRepository(database.GetDB()),
+		units:        services.NewUnitRepository(database.GetDB()),
+	}
+}
+
+// ListProperties returns a page of the caller's tenant's properties, each
+// marked with whether the caller has starred it
+func (h *PropertyCRUDHandler) ListProperties(c *gin.Context) {
+	var opts services.PropertyListOptions
+	if err := c.ShouldBindQuery(&opts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid query parameters",
+		})
+		return
+	}
+
+	page, err := h.properties.List(c.GetString("tenant_id"), c.GetString("user_id"), opts)
+	if errors.Is(err, services.ErrInvalidSort) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Sort must be one of created_at, price, arv or roi, optionally prefixed with -",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidStatus) {
+		respondPropertyError(c, err, "")
+		return
+	}
+	if errors.Is(err, services.ErrInvalidTagFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "tag_match must be any or all",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidArchived) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "archived must be true, false or all",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load properties",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"properties": page.Properties,
+		"pagination": gin.H{
+			"total":       page.Total,
+			"page":        page.Page,
+			"page_size":   page.PageSize,
+			"total_pages": page.TotalPages,
+		},
+	})
+}
+
+// CreateProperty saves a new property in the caller's tenant
+func (h *PropertyCRUDHandler) CreateProperty(c *gin.Context) {
+	var req services.CreatePropertyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Address, city, state, zip code and a price of 0 or more are required",
+		})
+		return
+	}
+
+	tenantID := c.GetString("tenant_id")
+	if req.TenantID != "" && req.TenantID != tenantID {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Properties can only be created in your own account",
+		})
+		return
+	}
+
+	property, err := h.properties.Create(tenantID, req)
+	if respondPropertyError(c, err, "Failed to create property") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":  true,
+		"property": property,
+	})
+}
+
+// GetProperty returns one of the caller's tenant's properties with a
+// summary of its rent roll
+func (h *PropertyCRUDHandler) GetProperty(c *gin.Context) {
+	property, err := h.properties.Get(c.GetString("tenant_id"), c.Param("id"))
+	if respondPropertyError(c, err, "Failed to load property") {
+		return
+	}
+	rentRoll, err := h.units.Summary(property)
+	if respondPropertyError(c, err, "Failed to load property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"property":  property,
+		"rent_roll": rentRoll,
+	})
+}
+
+// UpdateProperty changes the fields given in the request on one of the
+// caller's tenant's properties
+func (h *PropertyCRUDHandler) UpdateProperty(c *gin.Context) {
+	var req services.UpdatePropertyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid property data",
+		})
+		return
+	}
+
+	property, err := h.properties.Update(c.GetString("tenant_id"), c.Param("id"), req)
+	if respondPropertyError(c, err, "Failed to update property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"property": property,
+	})
+}
+
+// DeleteProperty deletes one of the caller's tenant's properties. It stays
+// in the database for the ARV calculations made from it and can be restored
+// by an admin.
+func (h *PropertyCRUDHandler) DeleteProperty(c *gin.Context) {
+	err := h.properties.Delete(c.GetString("tenant_id"), c.Param("id"))
+	if respondPropertyError(c, err, "Failed to delete property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Property deleted",
+	})
+}
+
+// RestoreProperty brings back a deleted property in the caller's tenant
+func (h *PropertyCRUDHandler) RestoreProperty(c *gin.Context) {
+	err := h.properties.Restore(c.GetString("tenant_id"), c.Param("id"))
+	if respondPropertyError(c, err, "Failed to restore property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Property restored",
+	})
+}
+
+// ArchiveProperty takes one of the caller's tenant's properties off the
+// active list without deleting it
+func (h *PropertyCRUDHandler) ArchiveProperty(c *gin.Context) {
+	property, err := h.properties.Archive(c.GetString("tenant_id"), c.Param("id"))
+	if respondPropertyError(c, err, "Failed to archive property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"property": property,
+	})
+}
+
+// UnarchiveProperty puts one of the caller's tenant's archived properties
+// back on the active list
+func (h *PropertyCRUDHandler) UnarchiveProperty(c *gin.Context) {
+	property, err := h.properties.Unarchive(c.GetString("tenant_id"), c.Param("id"))
+	if respondPropertyError(c, err, "Failed to unarchive property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"property": property,
+	})
+}
+
+// FavoriteProperty stars one of the caller's tenant's properties for the
+// caller
+func (h *PropertyCRUDHandler) FavoriteProperty(c *gin.Context) {
+	err := h.properties.Favorite(c.GetString("tenant_id"), c.GetString("user_id"), c.Param("id"))
+	if respondPropertyError(c, err, "Failed to favorite property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"is_favorite": true,
+	})
+}
+
+// UnfavoriteProperty removes the caller's star from one of their tenant's
+// properties
+func (h *PropertyCRUDHandler) UnfavoriteProperty(c *gin.Context) {
+	err := h.properties.Unfavorite(c.GetString("tenant_id"), c.GetString("user_id"), c.Param("id"))
+	if respondPropertyError(c, err, "Failed to unfavorite property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"is_favorite": false,
+	})
+}
+
+// ChangeStatus moves one of the caller's tenant's properties to another
+// pipeline stage
+func (h *PropertyCRUDHandler) ChangeStatus(c *gin.Context) {
+	var req services.ChangeStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	property, err := h.properties.ChangeStatus(c.GetString("tenant_id"), c.Param("id"), c.GetString("user_id"), req)
+	if respondPropertyError(c, err, "Failed to change property status") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"property": property,
+	})
+}
+
+// GetStatusHistory returns the status changes of one of the caller's
+// tenant's properties, oldest first
+func (h *PropertyCRUDHandler) GetStatusHistory(c *gin.Context) {
+	history, err := h.properties.StatusHistory(c.GetString("tenant_id"), c.Param("id"))
+	if respondPropertyError(c, err, "Failed to load status history") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"history": history,
+	})
+}
+
+// GetPipeline returns how many of the caller's tenant's properties are at
+// each pipeline stage
+func (h *PropertyCRUDHandler) GetPipeline(c *gin.Context) {
+	counts, err := h.properties.CountByStatus(c.GetString("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load pipeline",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"stages":   services.PropertyStatuses,
+		"pipeline": counts,
+	})
+}
+
+// SaveCalculation runs an ARV analysis for one of the caller's tenant's
+// properties and saves it as the property's latest. use_rehab_items takes
+// the rehab cost from the property's rehab budget, and use_rent_roll the
+// monthly rent from its units' market rents.
+func (h *PropertyCRUDHandler) SaveCalculation(c *gin.Context) {
+	var req services.SaveCalculationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	calc, result, err := h.calculations.Save(c.GetString("tenant_id"), c.Param("id"), req)
+	if errors.Is(err, services.ErrNoRehabItems) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Add rehab items to the property before using them for the rehab cost",
+			"code":    "NO_REHAB_ITEMS",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrNoUnits) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Add units to the property before using its rent roll for the monthly rent",
+			"code":    "NO_UNITS",
+		})
+		return
+	}
+	if respondPropertyError(c, err, "Failed to save ARV calculation") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":     true,
+		"calculation": calc,
+		"data":        arvResultData(c, *result),
+	})
+}
+
+// CompareProperties sets 2 to 5 of the caller's tenant's properties side by
+// side, ranked by the rank_by query parameter
+func (h *PropertyCRUDHandler) CompareProperties(c *gin.Context) {
+	var req services.CompareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "property_ids must list 2 to 5 properties",
+		})
+		return
+	}
+
+	comparison, err := h.calculations.Compare(c.GetString("tenant_id"), req.PropertyIDs, c.Query("rank_by"))
+	var unknown *services.UnknownPropertiesError
+	switch {
+	case errors.As(err, &unknown):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success":      false,
+			"message":      "Some properties were not found",
+			"property_ids": unknown.PropertyIDs,
+		})
+		return
+	case errors.Is(err, services.ErrInvalidComparison):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "property_ids must list 2 to 5 different properties",
+		})
+		return
+	case errors.Is(err, services.ErrInvalidRankBy):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "rank_by must be cash_flow, coc or profit",
+		})
+		return
+	}
+	if respondPropertyError(c, err, "Failed to compare properties") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"comparison": comparison,
+	})
+}
+
+// respondPropertyError writes the response for a failed property lookup or
+// change and reports whether there was one
+func respondPropertyError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrPropertyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Property not found",
+		})
+	case errors.Is(err, services.ErrPropertyFieldBlank):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Address, city, state and zip code cannot be blank",
+		})
+	case errors.Is(err, services.ErrInvalidStatus):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Status must be one of " + strings.Join(services.PropertyStatuses, ", "),
+		})
+	case errors.Is(err, services.ErrStatusReasonRequired):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Give a reason for moving a deal backwards or reviving a dead deal",
+			"code":    "REASON_REQUIRED",
+		})
+	case errors.Is(err, services.ErrInvalidStatusTransition):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "The property can't move to that status from its current one",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": fallback,
+		})
+	}
+	return true
+}
@@ -0,0 +1,650 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"arvfinder-backend/models"
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPropertyCRUDHandler(t *testing.T) (*PropertyCRUDHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &PropertyCRUDHandler{
+		properties:   services.NewPropertyRepository(db),
+		calculations: services.NewArvCalculationRepository(db),
+		units:        services.NewUnitRepository(db),
+	}, mock
+}
+
+func TestCreateProperty_InsertsInCallersTenant(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO properties`).
+		WithArgs("tenant-1", "123 Main St", "Denver", "CO", "80202", 180000.0, 250000.0, 30000.0,
+			0.0, 0.0, 3, 2.0, 1400, 0.0, 0, "", "", "lead").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow("property-1", now, now))
+
+	body := `{"address": " 123 Main St ", "city": "Denver", "state": "CO", "zip_code": "80202",
+		"price": 180000, "arv": 250000, "rehab_cost": 30000, "bedrooms": 3, "bathrooms": 2, "square_feet": 1400}`
+	w := performAuthenticated(handler.CreateProperty, http.MethodPost, body, nil)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Property models.Property `json:"property"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, "property-1", resp.Property.ID)
+	assert.Equal(t, "tenant-1", resp.Property.TenantID)
+	assert.Equal(t, "123 Main St", resp.Property.Address)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateProperty_ZeroPriceAllowed(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO properties`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow("property-1", now, now))
+
+	body := `{"address": "123 Main St", "city": "Denver", "state": "CO", "zip_code": "80202", "price": 0}`
+	w := performAuthenticated(handler.CreateProperty, http.MethodPost, body, nil)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateProperty_ValidationFailures(t *testing.T) {
+	cases := map[string]string{
+		"missing address": `{"city": "Denver", "state": "CO", "zip_code": "80202", "price": 1}`,
+		"missing city":    `{"address": "123 Main St", "state": "CO", "zip_code": "80202", "price": 1}`,
+		"missing state":   `{"address": "123 Main St", "city": "Denver", "zip_code": "80202", "price": 1}`,
+		"missing zip":     `{"address": "123 Main St", "city": "Denver", "state": "CO", "price": 1}`,
+		"missing price":   `{"address": "123 Main St", "city": "Denver", "state": "CO", "zip_code": "80202"}`,
+		"negative price":  `{"address": "123 Main St", "city": "Denver", "state": "CO", "zip_code": "80202", "price": -1}`,
+		"negative rehab":  `{"address": "123 Main St", "city": "Denver", "state": "CO", "zip_code": "80202", "price": 1, "rehab_cost": -5}`,
+		"blank address":   `{"address": "   ", "city": "Denver", "state": "CO", "zip_code": "80202", "price": 1}`,
+		"malformed":       `{"address": `,
+	}
+	for name, body := range cases {
+		handler, mock := newTestPropertyCRUDHandler(t)
+
+		w := performAuthenticated(handler.CreateProperty, http.MethodPost, body, nil)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		assert.NoError(t, mock.ExpectationsWereMet(), name)
+	}
+}
+
+func TestCreateProperty_RejectsOtherTenant(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	body := `{"tenant_id": "tenant-2", "address": "123 Main St", "city": "Denver", "state": "CO", "zip_code": "80202", "price": 1}`
+	w := performAuthenticated(handler.CreateProperty, http.MethodPost, body, nil)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is inserted")
+}
+
+// listProperties calls ListProperties as a user of tenantID
+func listProperties(handler *PropertyCRUDHandler, tenantID, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	c.Set("user_id", "user-1")
+	c.Set("tenant_id", tenantID)
+	handler.ListProperties(c)
+	return w
+}
+
+var propertyRowColumns = []string{
+	"id", "tenant_id", "address", "city", "state", "zip_code", "price", "arv", "rehab_cost", "holding_costs",
+	"closing_costs", "bedrooms", "bathrooms", "square_feet", "lot_size", "year_built", "property_type", "notes",
+	"status", "archived_at", "created_at", "updated_at",
+}
+
+func propertyRows(tenantID string, ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(propertyRowColumns)
+	now := time.Now()
+	for _, id := range ids {
+		rows.AddRow(id, tenantID, "123 Main St", "Denver", "CO", "80202", 180000.0, 250000.0, 0.0, 0.0,
+			0.0, 3, 2.0, 1400, 0.0, 1990, "single_family", "", "lead", nil, now, now)
+	}
+	return rows
+}
+
+// listedPropertyRows is propertyRows with the is_favorite column property
+// lists select
+func listedPropertyRows(tenantID string, favorite bool, ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(append(propertyRowColumns[:len(propertyRowColumns):len(propertyRowColumns)], "is_favorite"))
+	now := time.Now()
+	for _, id := range ids {
+		rows.AddRow(id, tenantID, "123 Main St", "Denver", "CO", "80202", 180000.0, 250000.0, 0.0, 0.0,
+			0.0, 3, 2.0, 1400, 0.0, 1990, "single_family", "", "lead", nil, now, now, favorite)
+	}
+	return rows
+}
+
+func TestListProperties_ScopedToCallersTenant(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	for _, tenantID := range []string{"tenant-1", "tenant-2"} {
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM properties WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL$`).
+			WithArgs(tenantID).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL\s+ORDER BY created_at DESC NULLS LAST, id\s+LIMIT \$2 OFFSET \$3`).
+			WithArgs(tenantID, 20, 0, "user-1").
+			WillReturnRows(listedPropertyRows(tenantID, false, "property-"+tenantID))
+
+		// A tenant_id in the query string is ignored
+		w := listProperties(handler, tenantID, "tenant_id=tenant-3")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Properties []models.Property `json:"properties"`
+		}
+		decodeJSON(t, w, &resp)
+		require.Len(t, resp.Properties, 1)
+		assert.Equal(t, tenantID, resp.Properties[0].TenantID)
+		assert.Equal(t, "property-"+tenantID, resp.Properties[0].ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProperties_FiltersSortAndPagination(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	filter := `tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL AND LOWER\(city\) = LOWER\(\$2\) AND LOWER\(state\) = LOWER\(\$3\) AND price >= \$4 AND price <= \$5 ` +
+		`AND bedrooms = \$6 AND LOWER\(property_type\) = LOWER\(\$7\)`
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM properties WHERE `+filter).
+		WithArgs("tenant-1", "Denver", "CO", 100000.0, 300000.0, 3, "single_family").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(45))
+	mock.ExpectQuery(`WHERE `+filter+`\s+ORDER BY price ASC NULLS LAST, id\s+LIMIT \$8 OFFSET \$9`).
+		WithArgs("tenant-1", "Denver", "CO", 100000.0, 300000.0, 3, "single_family", 10, 20, "user-1").
+		WillReturnRows(listedPropertyRows("tenant-1", false, "property-21", "property-22"))
+
+	w := listProperties(handler, "tenant-1",
+		"city=Denver&state=CO&min_price=100000&max_price=300000&bedrooms=3&property_type=single_family&sort=price&page=3&page_size=10")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Properties []models.Property `json:"properties"`
+		Pagination map[string]int    `json:"pagination"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Len(t, resp.Properties, 2)
+	assert.Equal(t, map[string]int{"total": 45, "page": 3, "page_size": 10, "total_pages": 5}, resp.Pagination)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProperties_SortByROIDescending(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`SELECT COUNT`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`ORDER BY \(COALESCE\(arv, 0\).*DESC NULLS LAST, id`).
+		WillReturnRows(listedPropertyRows("tenant-1", false, "property-1"))
+
+	w := listProperties(handler, "tenant-1", "sort=-roi")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProperties_PageSizeClampedTo100(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`SELECT COUNT`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(250))
+	mock.ExpectQuery(`LIMIT \$2 OFFSET \$3`).
+		WithArgs("tenant-1", 100, 0, "user-1").
+		WillReturnRows(listedPropertyRows("tenant-1", false, "property-1"))
+
+	w := listProperties(handler, "tenant-1", "page_size=500")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"page_size":100`)
+	assert.Contains(t, w.Body.String(), `"total_pages":3`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProperties_EmptyResult(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`SELECT COUNT`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	w := listProperties(handler, "tenant-1", "city=Nowhere")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"properties":[]`)
+	assert.Contains(t, w.Body.String(), `"total":0`)
+	assert.NoError(t, mock.ExpectationsWereMet(), "no page query when nothing matches")
+}
+
+func TestListProperties_InvalidParameters(t *testing.T) {
+	for _, query := range []string{
+		"sort=address",
+		"sort=price%3BDROP%20TABLE%20properties",
+		"sort=--price",
+		"page=-1",
+		"page_size=abc",
+		"min_price=-5",
+	} {
+		handler, mock := newTestPropertyCRUDHandler(t)
+
+		w := listProperties(handler, "tenant-1", query)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		assert.NoError(t, mock.ExpectationsWereMet(), query)
+	}
+}
+
+const testPropertyID = "4f8e2c1a-9b3d-4e5f-8a7b-6c5d4e3f2a1b"
+
+// performPropertyRequest calls handler for testPropertyID as a user of tenantID
+func performPropertyRequest(handler gin.HandlerFunc, tenantID, method, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: testPropertyID}}
+	c.Set("user_id", "user-1")
+	c.Set("tenant_id", tenantID)
+	handler(c)
+	return w
+}
+
+func TestGetProperty_ReturnsCallersProperty(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`FROM properties\s+WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+	expectNoUnits(mock)
+
+	w := performPropertyRequest(handler.GetProperty, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Property models.Property `json:"property"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, testPropertyID, resp.Property.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetProperty_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	// The property belongs to tenant-1, so tenant-2's lookup matches nothing
+	mock.ExpectQuery(`WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testPropertyID, "tenant-2").
+		WillReturnRows(sqlmock.NewRows(propertyRowColumns))
+
+	w := performPropertyRequest(handler.GetProperty, "tenant-2", http.MethodGet, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetProperty_MalformedIDNotFound(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Params = gin.Params{{Key: "id", Value: "not-a-uuid"}}
+	c.Set("tenant_id", "tenant-1")
+	handler.GetProperty(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "no query for an ID that can't exist")
+}
+
+func TestUpdateProperty_PartialUpdate(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`UPDATE properties\s+SET updated_at = NOW\(\), city = \$3, price = \$4, bedrooms = \$5\s+`+
+		`WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL\s+RETURNING`).
+		WithArgs(testPropertyID, "tenant-1", "Boulder", 195000.0, 4).
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+
+	w := performPropertyRequest(handler.UpdateProperty, "tenant-1", http.MethodPut,
+		`{"city": " Boulder ", "price": 195000, "bedrooms": 4}`)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), testPropertyID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProperty_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`UPDATE properties`).
+		WithArgs(testPropertyID, "tenant-2", "Boulder").
+		WillReturnRows(sqlmock.NewRows(propertyRowColumns))
+
+	w := performPropertyRequest(handler.UpdateProperty, "tenant-2", http.MethodPut, `{"city": "Boulder"}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProperty_ValidationFailures(t *testing.T) {
+	cases := map[string]string{
+		"negative price": `{"price": -1}`,
+		"negative rehab": `{"rehab_cost": -5}`,
+		"too many baths": `{"bathrooms": 120}`,
+		"blank address":  `{"address": "  "}`,
+		"blank zip code": `{"zip_code": ""}`,
+		"wrong type":     `{"bedrooms": "three"}`,
+		"malformed":      `{"city": `,
+	}
+	for name, body := range cases {
+		handler, mock := newTestPropertyCRUDHandler(t)
+
+		w := performPropertyRequest(handler.UpdateProperty, "tenant-1", http.MethodPut, body)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		assert.NoError(t, mock.ExpectationsWereMet(), name)
+	}
+}
+
+func TestDeleteProperty_SoftDeletes(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE properties\s+SET deleted_at = NOW\(\), updated_at = NOW\(\)\s+`+
+		`WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM property_favorites WHERE property_id = \$1`).
+		WithArgs(testPropertyID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	w := performPropertyRequest(handler.DeleteProperty, "tenant-1", http.MethodDelete, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "the row is updated, not deleted, and its favorites removed")
+}
+
+func TestDeleteProperty_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE properties\s+SET deleted_at = NOW\(\)`).
+		WithArgs(testPropertyID, "tenant-2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	w := performPropertyRequest(handler.DeleteProperty, "tenant-2", http.MethodDelete, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRestoreProperty(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectExec(`UPDATE properties\s+SET deleted_at = NULL, updated_at = NOW\(\)\s+`+
+		`WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NOT NULL`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE properties\s+SET deleted_at = NULL`).
+		WithArgs(testPropertyID, "tenant-2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := performPropertyRequest(handler.RestoreProperty, "tenant-1", http.MethodPost, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performPropertyRequest(handler.RestoreProperty, "tenant-2", http.MethodPost, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangeStatus_RecordsHistory(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM properties\s+WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL\s+FOR UPDATE`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("dead"))
+	mock.ExpectQuery(`UPDATE properties SET status = \$2, updated_at = NOW\(\)`).
+		WithArgs(testPropertyID, "under_contract").
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+	mock.ExpectExec(`INSERT INTO property_status_history`).
+		WithArgs(testPropertyID, "dead", "under_contract", "Seller accepted our revised offer", "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performPropertyRequest(handler.ChangeStatus, "tenant-1", http.MethodPost,
+		`{"status": "under_contract", "reason": " Seller accepted our revised offer "}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangeStatus_ReviveWithoutReason(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM properties`).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("dead"))
+	mock.ExpectRollback()
+
+	w := performPropertyRequest(handler.ChangeStatus, "tenant-1", http.MethodPost, `{"status": "under_contract"}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "REASON_REQUIRED")
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is changed")
+}
+
+func TestChangeStatus_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM properties`).
+		WithArgs(testPropertyID, "tenant-2").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}))
+	mock.ExpectRollback()
+
+	w := performPropertyRequest(handler.ChangeStatus, "tenant-2", http.MethodPost, `{"status": "analyzing"}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPropertyStatus_InvalidValues(t *testing.T) {
+	for _, status := range []string{"closed", "Under_Contract", "rented/sold", " lead"} {
+		handler, mock := newTestPropertyCRUDHandler(t)
+
+		w := performPropertyRequest(handler.ChangeStatus, "tenant-1", http.MethodPost, `{"status": "`+status+`"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "change to %q", status)
+
+		body := `{"address": "123 Main St", "city": "Denver", "state": "CO", "zip_code": "80202", "price": 1, "status": "` + status + `"}`
+		w = performAuthenticated(handler.CreateProperty, http.MethodPost, body, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "create with %q", status)
+
+		w = listProperties(handler, "tenant-1", "status="+url.QueryEscape(status))
+		assert.Equal(t, http.StatusBadRequest, w.Code, "filter by %q", status)
+
+		assert.NoError(t, mock.ExpectationsWereMet(), status)
+	}
+}
+
+func TestListProperties_StatusFilter(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM properties WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL AND status = \$2$`).
+		WithArgs("tenant-1", "under_contract").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	w := listProperties(handler, "tenant-1", "status=under_contract")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetStatusHistory_OldestFirst(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	start := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`FROM properties\s+WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+	mock.ExpectQuery(`FROM property_status_history\s+WHERE property_id = \$1\s+ORDER BY created_at, id`).
+		WithArgs(testPropertyID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "property_id", "from_status", "to_status", "reason", "changed_by", "created_at"}).
+			AddRow("change-1", testPropertyID, "lead", "analyzing", "", "user-1", start).
+			AddRow("change-2", testPropertyID, "analyzing", "dead", "", "user-1", start.Add(time.Hour)).
+			AddRow("change-3", testPropertyID, "dead", "offer_made", "Price dropped", "user-2", start.Add(2*time.Hour)))
+
+	w := performPropertyRequest(handler.GetStatusHistory, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		History []models.PropertyStatusChange `json:"history"`
+	}
+	decodeJSON(t, w, &resp)
+	require.Len(t, resp.History, 3)
+	for i, id := range []string{"change-1", "change-2", "change-3"} {
+		assert.Equal(t, id, resp.History[i].ID)
+	}
+	assert.Equal(t, "Price dropped", resp.History[2].Reason)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPipeline_CountsEveryStage(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`SELECT status, COUNT\(\*\) FROM properties\s+WHERE tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL\s+GROUP BY status`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("lead", 4).AddRow("rehabbing", 1))
+
+	w := performAuthenticated(handler.GetPipeline, http.MethodGet, "", nil)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Pipeline map[string]int `json:"pipeline"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Len(t, resp.Pipeline, len(services.PropertyStatuses))
+	assert.Equal(t, 4, resp.Pipeline["lead"])
+	assert.Equal(t, 1, resp.Pipeline["rehabbing"])
+	assert.Equal(t, 0, resp.Pipeline["sold"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+var arvCalculationRowColumns = []string{
+	"id", "property_id", "tenant_id", "purchase_price", "rehab_cost", "holding_costs", "closing_costs", "arv",
+	"max_offer", "potential_profit", "profit_margin", "total_investment", "monthly_cash_flow",
+	"cash_on_cash_return", "is_infinite_return", "cap_rate", "dscr", "risk_level", "rehab_cost_source", "gross_rent_multiplier",
+	"rent_to_price_ratio", "created_at",
+}
+
+func TestSaveCalculation_StoresReturns(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`FROM properties\s+WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+	mock.ExpectQuery(`INSERT INTO arv_calculations`).
+		WithArgs(testPropertyID, "tenant-1", models.Money(15000000), models.Money(3000000), models.Money(0), models.Money(0), models.Money(25000000), models.Money(14500000),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), services.RehabCostSourceManual, 7.5, 1.111).
+		WillReturnRows(sqlmock.NewRows(arvCalculationRowColumns).
+			AddRow("calc-1", testPropertyID, "tenant-1", 150000.0, 30000.0, 0.0, 0.0, 250000.0, 145000.0, 70000.0, 38.89,
+				180000.0, 310.0, 8.5, false, 7.2, 1.3, "Low", "manual", 7.5, 1.111, time.Now()))
+
+	w := performPropertyRequest(handler.SaveCalculation, "tenant-1", http.MethodPost,
+		`{"purchase_price": 150000, "rehab_cost": 30000, "arv": 250000, "monthly_rent": 2000, "loan_term": 30}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Calculation models.ArvCalculation `json:"calculation"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, "calc-1", resp.Calculation.ID)
+	assert.Equal(t, models.Money(31000), resp.Calculation.MonthlyCashFlow)
+	require.NotNil(t, resp.Calculation.GrossRentMultiplier)
+	assert.Equal(t, 7.5, *resp.Calculation.GrossRentMultiplier)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveCalculation_KeepsMaxOfferAtRulePercentage(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`FROM properties\s+WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+	// 65% of $250,000, less $30,000 of rehab
+	mock.ExpectQuery(`INSERT INTO arv_calculations`).
+		WithArgs(testPropertyID, "tenant-1", models.Money(15000000), models.Money(3000000), models.Money(0), models.Money(0), models.Money(25000000), models.Money(13250000),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), services.RehabCostSourceManual, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(arvCalculationRowColumns).
+			AddRow("calc-1", testPropertyID, "tenant-1", 150000.0, 30000.0, 0.0, 0.0, 250000.0, 132500.0, 70000.0, 38.89,
+				180000.0, 310.0, 8.5, false, 7.2, 1.3, "Low", "manual", 7.5, 1.111, time.Now()))
+
+	w := performPropertyRequest(handler.SaveCalculation, "tenant-1", http.MethodPost,
+		`{"purchase_price": 150000, "rehab_cost": 30000, "arv": 250000, "monthly_rent": 2000, "loan_term": 30, "rule_percentage": 65}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Calculation models.ArvCalculation `json:"calculation"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, models.Money(13250000), resp.Calculation.MaxOffer)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveCalculation_LeavesGeneratedColumnsToPostgres(t *testing.T) {
+	var insert string
+	matcher := sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+		if strings.Contains(actualSQL, "INSERT INTO arv_calculations") {
+			insert = actualSQL
+		}
+		return sqlmock.QueryMatcherRegexp.Match(expectedSQL, actualSQL)
+	})
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	handler := &PropertyCRUDHandler{calculations: services.NewArvCalculationRepository(db)}
+
+	mock.ExpectQuery(`FROM properties\s+WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+	mock.ExpectQuery(`INSERT INTO arv_calculations`).
+		WillReturnRows(sqlmock.NewRows(arvCalculationRowColumns).
+			AddRow("calc-1", testPropertyID, "tenant-1", 150000.0, 30000.0, 0.0, 0.0, 250000.0, 145000.0, 70000.0, 38.89,
+				180000.0, 310.0, 8.5, false, 7.2, 1.3, "Low", "manual", nil, nil, time.Now()))
+
+	w := performPropertyRequest(handler.SaveCalculation, "tenant-1", http.MethodPost,
+		`{"purchase_price": 150000, "rehab_cost": 30000, "arv": 250000, "monthly_rent": 2000, "loan_term": 30}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	// Postgres refuses a value for a GENERATED ALWAYS column
+	start := strings.Index(insert, "(")
+	end := strings.Index(insert, ")")
+	require.True(t, start >= 0 && end > start, "INSERT has a column list")
+	var columns []string
+	for _, column := range strings.Split(insert[start+1:end], ",") {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	assert.Contains(t, columns, "max_offer", "kept at the request's rule percentage")
+	for _, generated := range []string{"potential_profit", "profit_margin"} {
+		assert.NotContains(t, columns, generated)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
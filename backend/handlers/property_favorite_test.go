@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"arvfinder-backend/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requestAsUser calls handler for testPropertyID as userID of tenant-1
+func requestAsUser(handler gin.HandlerFunc, userID, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, nil)
+	c.Params = gin.Params{{Key: "id", Value: testPropertyID}}
+	c.Set("user_id", userID)
+	c.Set("tenant_id", "tenant-1")
+	handler(c)
+	return w
+}
+
+func TestFavoriteProperty_EachUserHasTheirOwn(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	// user-1 stars the property, which user-2 in the same tenant hasn't
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectExec(`INSERT INTO property_favorites \(user_id, property_id\) VALUES \(\$1, \$2\)\s+ON CONFLICT DO NOTHING`).
+		WithArgs("user-1", testPropertyID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	w := requestAsUser(handler.FavoriteProperty, "user-1", http.MethodPost, "/")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for _, user := range []struct {
+		id       string
+		favorite bool
+	}{{"user-1", true}, {"user-2", false}} {
+		mock.ExpectQuery(`SELECT COUNT`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`EXISTS\(SELECT 1 FROM property_favorites f WHERE f.property_id = properties.id AND f.user_id = \$4\)`).
+			WithArgs("tenant-1", 20, 0, user.id).
+			WillReturnRows(listedPropertyRows("tenant-1", user.favorite, testPropertyID))
+
+		w := requestAsUser(handler.ListProperties, user.id, http.MethodGet, "/")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Properties []models.Property `json:"properties"`
+		}
+		decodeJSON(t, w, &resp)
+		require.Len(t, resp.Properties, 1)
+		require.NotNil(t, resp.Properties[0].IsFavorite)
+		assert.Equal(t, user.favorite, *resp.Properties[0].IsFavorite, user.id)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProperties_FavoritesFilter(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	filter := `tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL AND id IN \(SELECT property_id FROM property_favorites WHERE user_id = \$2\)`
+	for _, user := range []struct {
+		id    string
+		count int
+	}{{"user-1", 1}, {"user-2", 0}} {
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM properties WHERE `+filter+`$`).
+			WithArgs("tenant-1", user.id).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(user.count))
+		if user.count > 0 {
+			mock.ExpectQuery(`WHERE `+filter+`\s+ORDER BY`).
+				WithArgs("tenant-1", user.id, 20, 0, user.id).
+				WillReturnRows(listedPropertyRows("tenant-1", true, testPropertyID))
+		}
+
+		w := requestAsUser(handler.ListProperties, user.id, http.MethodGet, "/?favorites=true")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Properties []models.Property `json:"properties"`
+		}
+		decodeJSON(t, w, &resp)
+		assert.Len(t, resp.Properties, user.count, user.id)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnfavoriteProperty_OnlyRemovesCallersFavorite(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectExec(`DELETE FROM property_favorites WHERE user_id = \$1 AND property_id = \$2`).
+		WithArgs("user-2", testPropertyID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := requestAsUser(handler.UnfavoriteProperty, "user-2", http.MethodDelete, "/")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"is_favorite":false`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFavoriteProperty_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	expectPropertyLookup(mock, "tenant-2", false)
+
+	w := performPropertyRequest(handler.FavoriteProperty, "tenant-2", http.MethodPost, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is starred")
+}
+
+func TestGetProperty_OmitsFavoriteFlag(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	expectNoUnits(mock)
+
+	w := performPropertyRequest(handler.GetProperty, "tenant-1", http.MethodGet, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "is_favorite")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
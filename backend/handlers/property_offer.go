@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OfferHandler manages the offers made on a tenant's properties
+type OfferHandler struct {
+	offers *services.OfferRepository
+}
+
+// NewOfferHandler creates a new offer handler
+func NewOfferHandler() *OfferHandler {
+	return &OfferHandler{
+		offers: services.NewOfferRepository(database.GetDB()),
+	}
+}
+
+// ListOffers returns the offers made on a property
+func (h *OfferHandler) ListOffers(c *gin.Context) {
+	offers, err := h.offers.List(c.GetString("tenant_id"), c.Param("id"))
+	if respondOfferError(c, err, "Failed to load offers") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"offers":  offers,
+	})
+}
+
+// CreateOffer saves a draft offer on a property
+func (h *OfferHandler) CreateOffer(c *gin.Context) {
+	var req services.OfferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "An amount greater than 0 and a financing type are required",
+		})
+		return
+	}
+
+	offer, err := h.offers.Create(c.GetString("tenant_id"), c.Param("id"), c.GetString("user_id"), req)
+	if respondOfferError(c, err, "Failed to create offer") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"offer":   offer,
+	})
+}
+
+// GetOffer returns an offer on a property with its status history
+func (h *OfferHandler) GetOffer(c *gin.Context) {
+	offer, err := h.offers.Get(c.GetString("tenant_id"), c.Param("id"), c.Param("offerID"))
+	if respondOfferError(c, err, "Failed to load offer") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"offer":   offer,
+	})
+}
+
+// UpdateOffer replaces the fields of a draft offer
+func (h *OfferHandler) UpdateOffer(c *gin.Context) {
+	var req services.OfferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "An amount greater than 0 and a financing type are required",
+		})
+		return
+	}
+
+	offer, err := h.offers.Update(c.GetString("tenant_id"), c.Param("id"), c.Param("offerID"), req)
+	if respondOfferError(c, err, "Failed to update offer") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"offer":   offer,
+	})
+}
+
+// DeleteOffer removes a draft offer
+func (h *OfferHandler) DeleteOffer(c *gin.Context) {
+	err := h.offers.Delete(c.GetString("tenant_id"), c.Param("id"), c.Param("offerID"))
+	if respondOfferError(c, err, "Failed to delete offer") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Offer deleted",
+	})
+}
+
+// ChangeOfferStatus submits, counters, accepts or rejects an offer
+func (h *OfferHandler) ChangeOfferStatus(c *gin.Context) {
+	var req services.ChangeOfferStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	change, err := h.offers.ChangeStatus(c.GetString("tenant_id"), c.Param("id"), c.Param("offerID"), c.GetString("user_id"), req)
+	if respondOfferError(c, err, "Failed to change offer status") {
+		return
+	}
+
+	response := gin.H{
+		"success": true,
+		"offer":   change.Offer,
+	}
+	if change.Property != nil {
+		response["property"] = change.Property
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ListOutstandingOffers returns the submitted and countered offers across
+// the caller's tenant's properties. expiring_within_hours narrows them to
+// offers expiring within that many hours.
+func (h *OfferHandler) ListOutstandingOffers(c *gin.Context) {
+	var within time.Duration
+	if hours := c.Query("expiring_within_hours"); hours != "" {
+		n, err := strconv.Atoi(hours)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "expiring_within_hours must be a whole number of hours",
+			})
+			return
+		}
+		within = time.Duration(n) * time.Hour
+	}
+
+	offers, err := h.offers.Outstanding(c.GetString("tenant_id"), within)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load offers",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"offers":  offers,
+	})
+}
+
+// respondOfferError writes the response for a failed offer lookup or
+// change and reports whether there was one
+func respondOfferError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrOfferNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Offer not found",
+		})
+	case errors.Is(err, services.ErrInvalidOfferStatus):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Status must be one of " + strings.Join(services.OfferStatuses, ", "),
+		})
+	case errors.Is(err, services.ErrInvalidFinancingType):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Financing type must be one of " + strings.Join(services.FinancingTypes, ", "),
+		})
+	case errors.Is(err, services.ErrInvalidOfferDate):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Offer date must be formatted YYYY-MM-DD",
+		})
+	case errors.Is(err, services.ErrCounterAmountRequired):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Give the seller's counter-offer amount",
+			"code":    "COUNTER_AMOUNT_REQUIRED",
+		})
+	case errors.Is(err, services.ErrInvalidOfferTransition):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "The offer can't move to that status from its current one",
+		})
+	case errors.Is(err, services.ErrOfferAlreadyAccepted):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "This property already has an accepted offer",
+			"code":    "OFFER_ALREADY_ACCEPTED",
+		})
+	case errors.Is(err, services.ErrOfferNotEditable):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Only draft offers can be changed or deleted",
+		})
+	default:
+		return respondPropertyError(c, err, fallback)
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"arvfinder-backend/models"
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOfferID = "8c7b6a5d-4e3f-4a2b-9c1d-0e9f8a7b6c5d"
+
+var offerRowColumns = []string{
+	"id", "property_id", "amount", "counter_amount", "offer_date", "expires_at", "financing_type", "status",
+	"notes", "created_at", "updated_at",
+}
+
+func newTestOfferHandler(t *testing.T) (*OfferHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &OfferHandler{offers: services.NewOfferRepository(db)}, mock
+}
+
+// performOfferRequest calls handler for testPropertyID and testOfferID as a
+// user of tenantID
+func performOfferRequest(handler gin.HandlerFunc, tenantID, method, body string) *httptest.ResponseRecorder {
+	return performTagRequest(handler, tenantID, method, body,
+		gin.Params{{Key: "id", Value: testPropertyID}, {Key: "offerID", Value: testOfferID}})
+}
+
+// listOutstandingOffers calls ListOutstandingOffers as a user of tenantID
+func listOutstandingOffers(handler *OfferHandler, tenantID, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/offers?"+query, nil)
+	c.Set("user_id", "user-1")
+	c.Set("tenant_id", tenantID)
+	handler.ListOutstandingOffers(c)
+	return w
+}
+
+func offerRow(status string, amount float64, counterAmount interface{}) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(offerRowColumns).AddRow(testOfferID, testPropertyID, amount, counterAmount,
+		time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), now.Add(72*time.Hour), "cash", status, "", now, now)
+}
+
+// expectOfferLocked expects the offer to be loaded for a status change
+func expectOfferLocked(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM property_offers\s+WHERE id = \$1 AND property_id = \$2\s+FOR UPDATE`).
+		WithArgs(testOfferID, testPropertyID).
+		WillReturnRows(rows)
+}
+
+func TestCreateOffer_SavesDraft(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`INSERT INTO property_offers`).
+		WithArgs(testPropertyID, "tenant-1", 180000.0, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+			sqlmock.AnyArg(), "hard_money", "Close in 14 days", "user-1").
+		WillReturnRows(offerRow(services.OfferStatusDraft, 180000, nil))
+
+	w := performOfferRequest(handler.CreateOffer, "tenant-1", http.MethodPost,
+		`{"amount": 180000, "offer_date": "2026-10-01", "financing_type": "hard_money", "notes": " Close in 14 days "}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Offer models.PropertyOffer `json:"offer"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, services.OfferStatusDraft, resp.Offer.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateOffer_ValidationFailures(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	for _, body := range []string{
+		`{"financing_type": "cash"}`,
+		`{"amount": 0, "financing_type": "cash"}`,
+		`{"amount": 180000}`,
+		`{"amount": 180000, "financing_type": "crypto"}`,
+		`{"amount": 180000, "financing_type": "cash", "offer_date": "10/01/2026"}`,
+	} {
+		w := performOfferRequest(handler.CreateOffer, "tenant-1", http.MethodPost, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangeOfferStatus_AcceptCounterUpdatesProperty(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	// The seller countered at 195,000 and accepting closes at that price
+	expectOfferLocked(mock, offerRow(services.OfferStatusCountered, 180000, 195000.0))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM property_offers WHERE property_id = \$1 AND status = 'accepted' AND id <> \$2\)`).
+		WithArgs(testPropertyID, testOfferID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`UPDATE property_offers SET status = \$2, amount = \$3, counter_amount = \$4`).
+		WithArgs(testOfferID, services.OfferStatusAccepted, 195000.0, 195000.0).
+		WillReturnRows(offerRow(services.OfferStatusAccepted, 195000, 195000.0))
+	mock.ExpectExec(`INSERT INTO property_offer_history`).
+		WithArgs(testOfferID, services.OfferStatusCountered, services.OfferStatusAccepted, 195000.0, "Signed", "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE properties SET price = \$2`).
+		WithArgs(testPropertyID, 195000.0).
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+	mock.ExpectQuery(`SELECT status FROM properties\s+WHERE id = \$1 AND tenant_id = \$2 AND deleted_at IS NULL\s+FOR UPDATE`).
+		WithArgs(testPropertyID, "tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(services.StatusOfferMade))
+	mock.ExpectQuery(`UPDATE properties SET status = \$2`).
+		WithArgs(testPropertyID, services.StatusUnderContract).
+		WillReturnRows(propertyRows("tenant-1", testPropertyID))
+	mock.ExpectExec(`INSERT INTO property_status_history`).
+		WithArgs(testPropertyID, services.StatusOfferMade, services.StatusUnderContract, "Offer accepted", "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performOfferRequest(handler.ChangeOfferStatus, "tenant-1", http.MethodPost,
+		`{"status": "accepted", "note": "Signed", "update_property": true}`)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Offer    models.PropertyOffer `json:"offer"`
+		Property *models.Property     `json:"property"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, services.OfferStatusAccepted, resp.Offer.Status)
+	assert.Equal(t, 195000.0, resp.Offer.Amount)
+	assert.NotNil(t, resp.Property)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangeOfferStatus_AcceptWithoutUpdatingProperty(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	expectOfferLocked(mock, offerRow(services.OfferStatusSubmitted, 180000, nil))
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`UPDATE property_offers SET status = \$2`).
+		WithArgs(testOfferID, services.OfferStatusAccepted, 180000.0, nil).
+		WillReturnRows(offerRow(services.OfferStatusAccepted, 180000, nil))
+	mock.ExpectExec(`INSERT INTO property_offer_history`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performOfferRequest(handler.ChangeOfferStatus, "tenant-1", http.MethodPost, `{"status": "accepted"}`)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), `"property"`)
+	assert.NoError(t, mock.ExpectationsWereMet(), "the property is left alone")
+}
+
+func TestChangeOfferStatus_OnlyOneAcceptedOffer(t *testing.T) {
+	t.Run("another offer already accepted", func(t *testing.T) {
+		handler, mock := newTestOfferHandler(t)
+
+		expectOfferLocked(mock, offerRow(services.OfferStatusSubmitted, 180000, nil))
+		mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		w := performOfferRequest(handler.ChangeOfferStatus, "tenant-1", http.MethodPost, `{"status": "accepted"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "OFFER_ALREADY_ACCEPTED")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("lost a race to the unique index", func(t *testing.T) {
+		handler, mock := newTestOfferHandler(t)
+
+		expectOfferLocked(mock, offerRow(services.OfferStatusSubmitted, 180000, nil))
+		mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery(`UPDATE property_offers`).
+			WillReturnError(&pq.Error{Code: "23505", Constraint: "idx_property_offers_one_accepted"})
+		mock.ExpectRollback()
+
+		w := performOfferRequest(handler.ChangeOfferStatus, "tenant-1", http.MethodPost, `{"status": "accepted"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "OFFER_ALREADY_ACCEPTED")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestChangeOfferStatus_RecordsCounter(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	expectOfferLocked(mock, offerRow(services.OfferStatusSubmitted, 180000, nil))
+	mock.ExpectQuery(`UPDATE property_offers SET status = \$2`).
+		WithArgs(testOfferID, services.OfferStatusCountered, 180000.0, 195000.0).
+		WillReturnRows(offerRow(services.OfferStatusCountered, 180000, 195000.0))
+	mock.ExpectExec(`INSERT INTO property_offer_history`).
+		WithArgs(testOfferID, services.OfferStatusSubmitted, services.OfferStatusCountered, 195000.0, "", "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performOfferRequest(handler.ChangeOfferStatus, "tenant-1", http.MethodPost,
+		`{"status": "countered", "amount": 195000}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangeOfferStatus_RejectedTransitions(t *testing.T) {
+	for _, tc := range []struct {
+		name, from, body string
+		status           int
+	}{
+		{"counter without amount", services.OfferStatusSubmitted, `{"status": "countered"}`, http.StatusBadRequest},
+		{"rejected is final", services.OfferStatusRejected, `{"status": "submitted"}`, http.StatusConflict},
+		{"accepted is final", services.OfferStatusAccepted, `{"status": "rejected"}`, http.StatusConflict},
+		{"draft can't be accepted", services.OfferStatusDraft, `{"status": "accepted"}`, http.StatusConflict},
+		{"unknown status", services.OfferStatusSubmitted, `{"status": "withdrawn"}`, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mock := newTestOfferHandler(t)
+
+			expectOfferLocked(mock, offerRow(tc.from, 180000, nil))
+			mock.ExpectRollback()
+
+			w := performOfferRequest(handler.ChangeOfferStatus, "tenant-1", http.MethodPost, tc.body)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet(), "nothing is changed")
+		})
+	}
+}
+
+func TestChangeOfferStatus_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	expectPropertyLookup(mock, "tenant-2", false)
+
+	w := performOfferRequest(handler.ChangeOfferStatus, "tenant-2", http.MethodPost, `{"status": "rejected"}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateOffer_SubmittedNotEditable(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_offers\s+WHERE id = \$1 AND property_id = \$2`).
+		WithArgs(testOfferID, testPropertyID).
+		WillReturnRows(offerRow(services.OfferStatusSubmitted, 180000, nil))
+	mock.ExpectQuery(`UPDATE property_offers\s+SET amount = \$2.*WHERE id = \$1 AND status = 'draft'`).
+		WillReturnRows(sqlmock.NewRows(offerRowColumns))
+
+	w := performOfferRequest(handler.UpdateOffer, "tenant-1", http.MethodPut, `{"amount": 170000, "financing_type": "cash"}`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListOutstandingOffers_ExpiringWithin48Hours(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM property_offers o\s+JOIN properties p ON p.id = o.property_id\s+`+
+		`WHERE o.tenant_id = \$1 AND p.deleted_at IS NULL AND o.status IN \('submitted', 'countered'\) `+
+		`AND o.expires_at > NOW\(\) AND o.expires_at <= NOW\(\) \+ make_interval\(secs => \$2\) `+
+		`ORDER BY o.expires_at ASC NULLS LAST, o.created_at`).
+		WithArgs("tenant-1", float64(48*60*60)).
+		WillReturnRows(sqlmock.NewRows(append(offerRowColumns, "address", "city", "state")).
+			AddRow(testOfferID, testPropertyID, 180000.0, nil, now, now.Add(20*time.Hour), "cash",
+				services.OfferStatusSubmitted, "", now, now, "123 Main St", "Denver", "CO"))
+
+	w := listOutstandingOffers(handler, "tenant-1", "expiring_within_hours=48")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Offers []services.OutstandingOffer `json:"offers"`
+	}
+	decodeJSON(t, w, &resp)
+	require.Len(t, resp.Offers, 1)
+	assert.Equal(t, "123 Main St", resp.Offers[0].Address)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListOutstandingOffers_InvalidWindow(t *testing.T) {
+	handler, mock := newTestOfferHandler(t)
+
+	for _, query := range []string{"expiring_within_hours=soon", "expiring_within_hours=0"} {
+		w := listOutstandingOffers(handler, "tenant-1", query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
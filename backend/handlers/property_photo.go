@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PropertyPhotoHandler manages the photos of a tenant's properties
+type PropertyPhotoHandler struct {
+	photos *services.PropertyPhotoService
+}
+
+// NewPropertyPhotoHandler creates a new property photo handler
+func NewPropertyPhotoHandler(storage services.PhotoStorage) *PropertyPhotoHandler {
+	return &PropertyPhotoHandler{
+		photos: services.NewPropertyPhotoService(database.GetDB(), storage),
+	}
+}
+
+// ReorderPhotosRequest lists a property's photo IDs in their new order
+type ReorderPhotosRequest struct {
+	PhotoIDs []string `json:"photo_ids" binding:"required"`
+}
+
+// ListPhotos returns a property's photos in gallery order
+func (h *PropertyPhotoHandler) ListPhotos(c *gin.Context) {
+	photos, err := h.photos.List(c.GetString("tenant_id"), c.Param("id"))
+	if respondPhotoError(c, err, "Failed to load photos") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"photos":  photos,
+	})
+}
+
+// UploadPhoto adds the multipart "photo" file to a property's photos
+func (h *PropertyPhotoHandler) UploadPhoto(c *gin.Context) {
+	// Leave room for the multipart framing around a maximum-size photo
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxPhotoSize+1<<20)
+	header, err := c.FormFile("photo")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondPhotoError(c, services.ErrPhotoTooLarge, "")
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A photo file is required",
+		})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to read photo",
+		})
+		return
+	}
+	defer file.Close()
+
+	photo, err := h.photos.Upload(c.Request.Context(), c.GetString("tenant_id"), c.Param("id"), header.Filename, file, header.Size)
+	if respondPhotoError(c, err, "Failed to upload photo") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"photo":   photo,
+	})
+}
+
+// ReorderPhotos changes the order of a property's photos
+func (h *PropertyPhotoHandler) ReorderPhotos(c *gin.Context) {
+	var req ReorderPhotosRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+		})
+		return
+	}
+
+	photos, err := h.photos.Reorder(c.GetString("tenant_id"), c.Param("id"), req.PhotoIDs)
+	if respondPhotoError(c, err, "Failed to reorder photos") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"photos":  photos,
+	})
+}
+
+// DeletePhoto removes one of a property's photos
+func (h *PropertyPhotoHandler) DeletePhoto(c *gin.Context) {
+	err := h.photos.Delete(c.Request.Context(), c.GetString("tenant_id"), c.Param("id"), c.Param("photoID"))
+	if respondPhotoError(c, err, "Failed to delete photo") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Photo deleted",
+	})
+}
+
+// GetPhotoURL returns a URL to download a photo from. Storage that can sign
+// URLs serves the photo directly for 15 minutes; otherwise the URL points at
+// DownloadPhoto.
+func (h *PropertyPhotoHandler) GetPhotoURL(c *gin.Context) {
+	propertyID, photoID := c.Param("id"), c.Param("photoID")
+	url, expiresAt, err := h.photos.SignedURL(c.GetString("tenant_id"), propertyID, photoID)
+	if respondPhotoError(c, err, "Failed to get photo URL") {
+		return
+	}
+
+	if url == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"url":     fmt.Sprintf("/api/v1/properties/%s/photos/%s/file", propertyID, photoID),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"url":        url,
+		"expires_at": expiresAt,
+	})
+}
+
+// DownloadPhoto streams a photo's file through the API
+func (h *PropertyPhotoHandler) DownloadPhoto(c *gin.Context) {
+	photo, contents, err := h.photos.Open(c.Request.Context(), c.GetString("tenant_id"), c.Param("id"), c.Param("photoID"))
+	if respondPhotoError(c, err, "Failed to load photo") {
+		return
+	}
+	defer contents.Close()
+
+	c.Header("Content-Type", photo.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(photo.SizeBytes, 10))
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", photo.Filename))
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, contents)
+}
+
+// respondPhotoError writes the response for a failed photo lookup or
+// change and reports whether there was one
+func respondPhotoError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrPhotoNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Photo not found",
+		})
+	case errors.Is(err, services.ErrPhotoTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"success": false,
+			"message": "Photos can be at most 10MB",
+		})
+	case errors.Is(err, services.ErrUnsupportedPhoto):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"success": false,
+			"message": "Photos must be JPEG, PNG or WebP images",
+		})
+	case errors.Is(err, services.ErrPhotoLimitReached):
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "This property has as many photos as your plan allows",
+			"code":    "PHOTO_LIMIT_REACHED",
+		})
+	case errors.Is(err, services.ErrInvalidPhotoOrder):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "List each of the property's photos exactly once",
+		})
+	default:
+		return respondPropertyError(c, err, fallback)
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadPhoto posts contents as the multipart "photo" file for
+// testPropertyID as a user of tenant-1
+func uploadPhoto(t *testing.T, handler *PropertyPhotoHandler, field string, contents []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, "front.jpg")
+	require.NoError(t, err)
+	part.Write(contents)
+	require.NoError(t, form.Close())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", &body)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	c.Params = gin.Params{{Key: "id", Value: testPropertyID}}
+	c.Set("user_id", "user-1")
+	c.Set("tenant_id", "tenant-1")
+	handler.UploadPhoto(c)
+	return w
+}
+
+func newTestPropertyPhotoHandler(t *testing.T) (*PropertyPhotoHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	storage := services.NewLocalPhotoStorage(t.TempDir())
+	return &PropertyPhotoHandler{photos: services.NewPropertyPhotoService(db, storage)}, mock
+}
+
+func TestUploadPhoto_SniffsContents(t *testing.T) {
+	handler, mock := newTestPropertyPhotoHandler(t)
+	expectPropertyLookup(mock, "tenant-1", true)
+
+	// Named .jpg, but it's a GIF
+	w := uploadPhoto(t, handler, "photo", []byte("GIF89a\x01\x00\x01\x00\x00\x00"))
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUploadPhoto_TooLarge(t *testing.T) {
+	handler, mock := newTestPropertyPhotoHandler(t)
+
+	contents := append([]byte{0xFF, 0xD8, 0xFF}, make([]byte, services.MaxPhotoSize)...)
+	w := uploadPhoto(t, handler, "photo", contents)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUploadPhoto_MissingFile(t *testing.T) {
+	handler, mock := newTestPropertyPhotoHandler(t)
+
+	w := uploadPhoto(t, handler, "document", []byte{0xFF, 0xD8, 0xFF})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
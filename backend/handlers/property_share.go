@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PropertyShareHandler manages read-only links to a tenant's properties and
+// serves them to people without an account
+type PropertyShareHandler struct {
+	shares      *services.PropertyShareService
+	rateLimiter *services.RateLimiter
+}
+
+// NewPropertyShareHandler creates a new property share handler
+func NewPropertyShareHandler() *PropertyShareHandler {
+	db := database.GetDB()
+	return &PropertyShareHandler{
+		shares:      services.NewPropertyShareService(db),
+		rateLimiter: services.NewRateLimiter(db),
+	}
+}
+
+// CreateShareLink issues a read-only link to a property
+func (h *PropertyShareHandler) CreateShareLink(c *gin.Context) {
+	var req services.CreateShareLinkRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "expires_at must be an RFC 3339 timestamp",
+			})
+			return
+		}
+	}
+
+	link, err := h.shares.Create(c.GetString("tenant_id"), c.Param("id"), c.GetString("user_id"), req)
+	if respondShareError(c, err, "Failed to create share link") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"link":    link,
+	})
+}
+
+// ListShareLinks returns a property's share links
+func (h *PropertyShareHandler) ListShareLinks(c *gin.Context) {
+	links, err := h.shares.List(c.GetString("tenant_id"), c.Param("id"))
+	if respondShareError(c, err, "Failed to load share links") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"links":   links,
+	})
+}
+
+// RevokeShareLink stops one of a property's share links from working
+func (h *PropertyShareHandler) RevokeShareLink(c *gin.Context) {
+	err := h.shares.Revoke(c.GetString("tenant_id"), c.Param("id"), c.Param("linkID"))
+	if respondShareError(c, err, "Failed to revoke share link") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Share link revoked",
+	})
+}
+
+// ViewSharedProperty serves the property behind a share link. It needs no
+// account, so it is rate limited per IP.
+func (h *PropertyShareHandler) ViewSharedProperty(c *gin.Context) {
+	// Revocation must take effect at once, so nothing may cache the view
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+
+	clientIP := c.ClientIP()
+	allowed, blockTime, err := h.rateLimiter.IsAllowed(clientIP, "shared_property_view")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Internal server error",
+		})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":     false,
+			"message":     "Too many requests. Please try again later.",
+			"retry_after": int(blockTime.Seconds()),
+		})
+		return
+	}
+	h.rateLimiter.RecordAttempt(clientIP, "shared_property_view")
+
+	property, err := h.shares.View(c.Param("token"))
+	if respondShareError(c, err, "Failed to load shared property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"property": property,
+	})
+}
+
+// respondShareError writes the response for a failed share link lookup or
+// change and reports whether there was one
+func respondShareError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrShareLinkNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Share link not found",
+		})
+	case errors.Is(err, services.ErrShareLinkExpired):
+		c.JSON(http.StatusGone, gin.H{
+			"success": false,
+			"message": "This share link has expired",
+			"code":    "SHARE_LINK_EXPIRED",
+		})
+	case errors.Is(err, services.ErrShareLinkRevoked):
+		c.JSON(http.StatusGone, gin.H{
+			"success": false,
+			"message": "This share link has been revoked",
+			"code":    "SHARE_LINK_REVOKED",
+		})
+	case errors.Is(err, services.ErrInvalidShareExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "expires_at must be in the future",
+		})
+	default:
+		return respondPropertyError(c, err, fallback)
+	}
+	return true
+}
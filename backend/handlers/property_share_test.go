@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"arvfinder-backend/models"
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testShareLinkID = "5d4c3b2a-1f0e-4d9c-8b7a-6f5e4d3c2b1a"
+	testShareToken  = "dGhpcyBpcyBub3QgYSByZWFsIHNoYXJlIHRva2VuIQ"
+)
+
+var shareLinkRowColumns = []string{
+	"id", "property_id", "expires_at", "revoked_at", "view_count", "last_viewed_at", "created_at",
+}
+
+func newTestShareHandler(t *testing.T) (*PropertyShareHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &PropertyShareHandler{
+		shares:      services.NewPropertyShareService(db),
+		rateLimiter: services.NewRateLimiter(db),
+	}, mock
+}
+
+// viewShared calls ViewSharedProperty for token without any credentials
+func viewShared(handler *PropertyShareHandler, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Params = gin.Params{{Key: "token", Value: token}}
+	handler.ViewSharedProperty(c)
+	return w
+}
+
+// expectShareLinkLookup mocks the share link for testShareToken
+func expectShareLinkLookup(mock sqlmock.Sqlmock, expiresAt, revokedAt *time.Time) {
+	mock.ExpectQuery(`FROM property_share_links\s+WHERE token_hash = \$1`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "property_id", "tenant_id", "expires_at", "revoked_at"}).
+			AddRow(testShareLinkID, testPropertyID, "tenant-1", expiresAt, revokedAt))
+}
+
+func TestViewSharedProperty_SanitizedView(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	expectAttemptRecorded(mock)
+	expectShareLinkLookup(mock, &expiresAt, nil)
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM arv_calculations\s+WHERE property_id = \$1\s+ORDER BY created_at DESC`).
+		WithArgs(testPropertyID).
+		WillReturnRows(sqlmock.NewRows(arvCalculationRowColumns).
+			AddRow("calc-1", testPropertyID, "tenant-1", 180000.0, 20000.0, 0.0, 0.0, 250000.0, 155000.0, 50000.0, 20.0,
+				200000.0, 250.0, 6.5, false, 7.1, 1.2, "Medium", "manual", nil, nil, time.Now()))
+	mock.ExpectQuery(`FROM comparables\s+WHERE property_id = \$1`).
+		WithArgs(testPropertyID).
+		WillReturnRows(comparableRow(245000, 0.4, 0))
+	mock.ExpectExec(`UPDATE property_share_links SET view_count = view_count \+ 1`).
+		WithArgs(testShareLinkID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := viewShared(handler, testShareToken)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var resp struct {
+		Property services.SharedProperty `json:"property"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, "123 Main St", resp.Property.Address)
+	require.NotNil(t, resp.Property.LatestCalculation)
+	assert.Equal(t, models.Money(25000), resp.Property.LatestCalculation.MonthlyCashFlow)
+	require.Len(t, resp.Property.Comparables, 1)
+	assert.Equal(t, "456 Oak Ave", resp.Property.Comparables[0].Address)
+
+	body := w.Body.String()
+	for _, private := range []string{"tenant", "user", "notes", testPropertyID, testComparableID, "calc-1"} {
+		assert.NotContains(t, body, private)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestViewSharedProperty_ExpiredToken(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	expiresAt := time.Now().Add(-time.Minute)
+	expectAttemptRecorded(mock)
+	expectShareLinkLookup(mock, &expiresAt, nil)
+
+	w := viewShared(handler, testShareToken)
+
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "SHARE_LINK_EXPIRED")
+	assert.NoError(t, mock.ExpectationsWereMet(), "the property is never loaded")
+}
+
+func TestViewSharedProperty_RevokedToken(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	// Revoked links stop working even before they expire
+	expiresAt := time.Now().Add(time.Hour)
+	revokedAt := time.Now().Add(-time.Second)
+	expectAttemptRecorded(mock)
+	expectShareLinkLookup(mock, &expiresAt, &revokedAt)
+
+	w := viewShared(handler, testShareToken)
+
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "SHARE_LINK_REVOKED")
+	assert.NoError(t, mock.ExpectationsWereMet(), "the property is never loaded")
+}
+
+func TestViewSharedProperty_UnknownToken(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	expectAttemptRecorded(mock)
+	mock.ExpectQuery(`FROM property_share_links`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "property_id", "tenant_id", "expires_at", "revoked_at"}))
+
+	w := viewShared(handler, "guessed-token")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestViewSharedProperty_DeletedPropertyNotFound(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	expectAttemptRecorded(mock)
+	expectShareLinkLookup(mock, nil, nil)
+	expectPropertyLookup(mock, "tenant-1", false)
+
+	w := viewShared(handler, testShareToken)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "Share link not found")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestViewSharedProperty_RateLimited(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	mock.ExpectQuery(`SELECT blocked_until`).
+		WithArgs(sqlmock.AnyArg(), "shared_property_view").
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}).AddRow(time.Now().Add(time.Minute)))
+
+	w := viewShared(handler, testShareToken)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "the token is never looked up")
+}
+
+func TestCreateShareLink_StoresOnlyTokenHash(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	expiresAt := time.Now().Add(7 * 24 * time.Hour).UTC().Truncate(time.Second)
+	tokenHash := &capturedArg{}
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`INSERT INTO property_share_links \(property_id, tenant_id, token_hash, created_by, expires_at\)`).
+		WithArgs(testPropertyID, "tenant-1", tokenHash, "user-1", expiresAt).
+		WillReturnRows(sqlmock.NewRows(shareLinkRowColumns).
+			AddRow(testShareLinkID, testPropertyID, expiresAt, nil, 0, nil, time.Now()))
+
+	w := performPropertyRequest(handler.CreateShareLink, "tenant-1", http.MethodPost,
+		`{"expires_at": "`+expiresAt.Format(time.RFC3339)+`"}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Link struct {
+			ID    string `json:"id"`
+			Token string `json:"token"`
+			URL   string `json:"url"`
+		} `json:"link"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, testShareLinkID, resp.Link.ID)
+	assert.GreaterOrEqual(t, len(resp.Link.Token), 43, "256 bits of entropy")
+	assert.True(t, strings.HasSuffix(resp.Link.URL, "/shared/"+resp.Link.Token))
+	assert.Len(t, tokenHash.value, 64)
+	assert.NotEqual(t, resp.Link.Token, tokenHash.value)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateShareLink_PastExpiryRejected(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	w := performPropertyRequest(handler.CreateShareLink, "tenant-1", http.MethodPost,
+		`{"expires_at": "2020-01-01T00:00:00Z"}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeShareLink(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		tenantID string
+		affected int64
+		status   int
+	}{
+		{"own link", "tenant-1", 1, http.StatusOK},
+		{"unknown link", "tenant-1", 0, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mock := newTestShareHandler(t)
+
+			expectPropertyLookup(mock, tc.tenantID, true)
+			mock.ExpectExec(`UPDATE property_share_links SET revoked_at = COALESCE\(revoked_at, NOW\(\)\)\s+WHERE id = \$1 AND property_id = \$2`).
+				WithArgs(testShareLinkID, testPropertyID).
+				WillReturnResult(sqlmock.NewResult(0, tc.affected))
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodDelete, "/", nil)
+			c.Params = gin.Params{{Key: "id", Value: testPropertyID}, {Key: "linkID", Value: testShareLinkID}}
+			c.Set("user_id", "user-1")
+			c.Set("tenant_id", tc.tenantID)
+			handler.RevokeShareLink(c)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRevokeShareLink_OtherTenantsProperty(t *testing.T) {
+	handler, mock := newTestShareHandler(t)
+
+	expectPropertyLookup(mock, "tenant-2", false)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/", nil)
+	c.Params = gin.Params{{Key: "id", Value: testPropertyID}, {Key: "linkID", Value: testShareLinkID}}
+	c.Set("tenant_id", "tenant-2")
+	handler.RevokeShareLink(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is revoked")
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TagHandler manages a tenant's tags and the properties they label
+type TagHandler struct {
+	tags *services.TagRepository
+}
+
+// NewTagHandler creates a new tag handler
+func NewTagHandler() *TagHandler {
+	return &TagHandler{
+		tags: services.NewTagRepository(database.GetDB()),
+	}
+}
+
+// ListTags returns the caller's tenant's tags
+func (h *TagHandler) ListTags(c *gin.Context) {
+	tags, err := h.tags.List(c.GetString("tenant_id"))
+	if respondTagError(c, err, "Failed to load tags") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tags":    tags,
+	})
+}
+
+// CreateTag adds a tag to the caller's tenant
+func (h *TagHandler) CreateTag(c *gin.Context) {
+	var req services.CreateTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A tag name of at most 50 characters is required",
+		})
+		return
+	}
+
+	tag, err := h.tags.Create(c.GetString("tenant_id"), req)
+	if respondTagError(c, err, "Failed to create tag") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"tag":     tag,
+	})
+}
+
+// UpdateTag renames or recolors one of the caller's tenant's tags
+func (h *TagHandler) UpdateTag(c *gin.Context) {
+	var req services.UpdateTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Tag names can be at most 50 characters",
+		})
+		return
+	}
+
+	tag, err := h.tags.Update(c.GetString("tenant_id"), c.Param("id"), req)
+	if respondTagError(c, err, "Failed to update tag") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tag":     tag,
+	})
+}
+
+// DeleteTag removes one of the caller's tenant's tags from every property
+// and deletes it
+func (h *TagHandler) DeleteTag(c *gin.Context) {
+	err := h.tags.Delete(c.GetString("tenant_id"), c.Param("id"))
+	if respondTagError(c, err, "Failed to delete tag") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Tag deleted",
+	})
+}
+
+// ListPropertyTags returns the tags on a property
+func (h *TagHandler) ListPropertyTags(c *gin.Context) {
+	tags, err := h.tags.ForProperty(c.GetString("tenant_id"), c.Param("id"))
+	if respondTagError(c, err, "Failed to load property tags") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tags":    tags,
+	})
+}
+
+// TagProperty puts a tag on a property
+func (h *TagHandler) TagProperty(c *gin.Context) {
+	tags, err := h.tags.Attach(c.GetString("tenant_id"), c.Param("id"), c.Param("tagID"))
+	if respondTagError(c, err, "Failed to tag property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tags":    tags,
+	})
+}
+
+// UntagProperty takes a tag off a property
+func (h *TagHandler) UntagProperty(c *gin.Context) {
+	tags, err := h.tags.Detach(c.GetString("tenant_id"), c.Param("id"), c.Param("tagID"))
+	if respondTagError(c, err, "Failed to untag property") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tags":    tags,
+	})
+}
+
+// respondTagError writes the response for a failed tag lookup or change
+// and reports whether there was one
+func respondTagError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrTagNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Tag not found",
+		})
+	case errors.Is(err, services.ErrTagExists):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "You already have a tag with that name",
+			"code":    "TAG_EXISTS",
+		})
+	case errors.Is(err, services.ErrTagLimitReached):
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("You can have at most %d tags", services.MaxTagsPerTenant),
+			"code":    "TAG_LIMIT_REACHED",
+		})
+	case errors.Is(err, services.ErrInvalidTagName):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Tag names can't be blank or contain commas",
+		})
+	case errors.Is(err, services.ErrInvalidTagColor):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Tag colors must be hex colors like #1A2B3C",
+		})
+	default:
+		return respondPropertyError(c, err, fallback)
+	}
+	return true
+}
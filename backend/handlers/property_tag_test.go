@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTagID = "2b3c4d5e-6f7a-4b8c-9d0e-1f2a3b4c5d6e"
+
+var tagRowColumns = []string{"id", "tenant_id", "name", "color", "created_at", "updated_at"}
+
+func newTestTagHandler(t *testing.T) (*TagHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &TagHandler{tags: services.NewTagRepository(db)}, mock
+}
+
+// performTagRequest calls handler with params as a user of tenantID
+func performTagRequest(handler gin.HandlerFunc, tenantID, method, body string, params gin.Params) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	c.Set("user_id", "user-1")
+	c.Set("tenant_id", tenantID)
+	handler(c)
+	return w
+}
+
+func tagRow(tenantID, name string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(tagRowColumns).AddRow(testTagID, tenantID, name, "#6B7280", now, now)
+}
+
+func TestListProperties_AllTagsFilter(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	// Names are matched case-insensitively and duplicates are dropped, so
+	// the HAVING count compares against two distinct names
+	filter := `tenant_id = \$1 AND deleted_at IS NULL AND archived_at IS NULL AND id IN \(SELECT a.property_id FROM property_tag_assignments a\s+` +
+		`JOIN property_tags t ON t.id = a.tag_id\s+WHERE t.tenant_id = \$1 AND LOWER\(t.name\) = ANY\(\$2\) ` +
+		`GROUP BY a.property_id HAVING COUNT\(\*\) = cardinality\(\$2::text\[\]\)\)`
+	names := `{"out-of-state","section 8"}`
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM properties WHERE `+filter+`$`).
+		WithArgs("tenant-1", names).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`WHERE `+filter+`\s+ORDER BY`).
+		WithArgs("tenant-1", names, 20, 0, "user-1").
+		WillReturnRows(listedPropertyRows("tenant-1", false, testPropertyID))
+
+	query := url.Values{"tags": {"Out-of-State, Section 8,out-of-state"}, "tag_match": {"all"}}
+	w := listProperties(handler, "tenant-1", query.Encode())
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProperties_AnyTagsFilter(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM properties WHERE .*LOWER\(t.name\) = ANY\(\$2\)\)$`).
+		WithArgs("tenant-1", `{"rental"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	w := listProperties(handler, "tenant-1", "tags=Rental")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProperties_InvalidTagMatch(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	w := listProperties(handler, "tenant-1", "tags=rental&tag_match=some")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateTag(t *testing.T) {
+	handler, mock := newTestTagHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM tenants WHERE id = \$1 FOR UPDATE`).
+		WithArgs("tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM property_tags WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`INSERT INTO property_tags`).
+		WithArgs("tenant-1", "Section 8", "#AA00FF").
+		WillReturnRows(tagRow("tenant-1", "Section 8"))
+	mock.ExpectCommit()
+
+	w := performTagRequest(handler.CreateTag, "tenant-1", http.MethodPost,
+		`{"name":"  Section 8 ","color":"#aa00ff"}`, nil)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateTag_DuplicateNameConflict(t *testing.T) {
+	handler, mock := newTestTagHandler(t)
+
+	// "section 8" collides with an existing "Section 8" on the
+	// case-insensitive unique index
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM tenants`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM property_tags`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO property_tags`).
+		WithArgs("tenant-1", "section 8", "#6B7280").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "idx_property_tags_tenant_id_name"})
+	mock.ExpectRollback()
+
+	w := performTagRequest(handler.CreateTag, "tenant-1", http.MethodPost, `{"name":"section 8"}`, nil)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var resp struct {
+		Code string `json:"code"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, "TAG_EXISTS", resp.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateTag_LimitReached(t *testing.T) {
+	handler, mock := newTestTagHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM tenants`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM property_tags`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(services.MaxTagsPerTenant))
+	mock.ExpectRollback()
+
+	w := performTagRequest(handler.CreateTag, "tenant-1", http.MethodPost, `{"name":"one too many"}`, nil)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is inserted")
+}
+
+func TestCreateTag_ValidationFailures(t *testing.T) {
+	handler, mock := newTestTagHandler(t)
+
+	for _, body := range []string{
+		`{}`,
+		`{"name":"   "}`,
+		`{"name":"out-of-state,rental"}`,
+		`{"name":"` + strings.Repeat("x", 51) + `"}`,
+		`{"name":"rental","color":"red"}`,
+	} {
+		w := performTagRequest(handler.CreateTag, "tenant-1", http.MethodPost, body, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTag_RenameToExistingNameConflicts(t *testing.T) {
+	handler, mock := newTestTagHandler(t)
+
+	mock.ExpectQuery(`FROM property_tags\s+WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testTagID, "tenant-1").
+		WillReturnRows(tagRow("tenant-1", "Rental"))
+	mock.ExpectQuery(`UPDATE property_tags SET name = \$3, color = \$4`).
+		WithArgs(testTagID, "tenant-1", "OUT-OF-STATE", "#6B7280").
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	w := performTagRequest(handler.UpdateTag, "tenant-1", http.MethodPut, `{"name":"OUT-OF-STATE"}`,
+		gin.Params{{Key: "id", Value: testTagID}})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteTag_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestTagHandler(t)
+
+	mock.ExpectExec(`DELETE FROM property_tags WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testTagID, "tenant-2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := performTagRequest(handler.DeleteTag, "tenant-2", http.MethodDelete, "",
+		gin.Params{{Key: "id", Value: testTagID}})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTagProperty(t *testing.T) {
+	handler, mock := newTestTagHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_tags\s+WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testTagID, "tenant-1").
+		WillReturnRows(tagRow("tenant-1", "Rental"))
+	mock.ExpectExec(`INSERT INTO property_tag_assignments \(property_id, tag_id\)\s+VALUES \(\$1, \$2\)\s+ON CONFLICT DO NOTHING`).
+		WithArgs(testPropertyID, testTagID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`JOIN property_tag_assignments a ON a.tag_id = t.id\s+WHERE a.property_id = \$1`).
+		WithArgs(testPropertyID).
+		WillReturnRows(tagRow("tenant-1", "Rental"))
+
+	w := performTagRequest(handler.TagProperty, "tenant-1", http.MethodPut, "",
+		gin.Params{{Key: "id", Value: testPropertyID}, {Key: "tagID", Value: testTagID}})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Tags []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+	}
+	decodeJSON(t, w, &resp)
+	require.Len(t, resp.Tags, 1)
+	assert.Equal(t, "Rental", resp.Tags[0].Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTagProperty_OtherTenantsTagNotFound(t *testing.T) {
+	handler, mock := newTestTagHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_tags\s+WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testTagID, "tenant-1").
+		WillReturnRows(sqlmock.NewRows(tagRowColumns))
+
+	w := performTagRequest(handler.TagProperty, "tenant-1", http.MethodPut, "",
+		gin.Params{{Key: "id", Value: testPropertyID}, {Key: "tagID", Value: testTagID}})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is assigned")
+}
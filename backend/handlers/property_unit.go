@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UnitHandler manages the rent rolls of a tenant's multi-unit properties
+type UnitHandler struct {
+	units *services.UnitRepository
+}
+
+// NewUnitHandler creates a new unit handler
+func NewUnitHandler() *UnitHandler {
+	return &UnitHandler{
+		units: services.NewUnitRepository(database.GetDB()),
+	}
+}
+
+// ListUnits returns a property's units
+func (h *UnitHandler) ListUnits(c *gin.Context) {
+	units, err := h.units.List(c.GetString("tenant_id"), c.Param("id"))
+	if respondUnitError(c, err, "Failed to load units") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"units":   units,
+	})
+}
+
+// CreateUnit adds a unit to a property's rent roll
+func (h *UnitHandler) CreateUnit(c *gin.Context) {
+	var req services.UnitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A label is required, and rents, beds, baths and square feet can't be negative",
+		})
+		return
+	}
+
+	unit, err := h.units.Create(c.GetString("tenant_id"), c.Param("id"), req)
+	if respondUnitError(c, err, "Failed to create unit") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"unit":    unit,
+	})
+}
+
+// UpdateUnit replaces a unit of a property's rent roll
+func (h *UnitHandler) UpdateUnit(c *gin.Context) {
+	var req services.UnitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "A label is required, and rents, beds, baths and square feet can't be negative",
+		})
+		return
+	}
+
+	unit, err := h.units.Update(c.GetString("tenant_id"), c.Param("id"), c.Param("unitID"), req)
+	if respondUnitError(c, err, "Failed to update unit") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"unit":    unit,
+	})
+}
+
+// DeleteUnit removes a unit from a property's rent roll
+func (h *UnitHandler) DeleteUnit(c *gin.Context) {
+	err := h.units.Delete(c.GetString("tenant_id"), c.Param("id"), c.Param("unitID"))
+	if respondUnitError(c, err, "Failed to delete unit") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Unit deleted",
+	})
+}
+
+// respondUnitError writes the response for a failed unit lookup or change
+// and reports whether there was one
+func respondUnitError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrUnitNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Unit not found",
+		})
+	case errors.Is(err, services.ErrUnitLabelBlank):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Label cannot be blank",
+		})
+	case errors.Is(err, services.ErrInvalidLeaseEndDate):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Lease end date must be formatted YYYY-MM-DD",
+		})
+	case errors.Is(err, services.ErrUnitLabelTaken):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "This property already has a unit with that label",
+		})
+	default:
+		return respondPropertyError(c, err, fallback)
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"arvfinder-backend/models"
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testUnitID = "3b2a1908-f7e6-4d5c-b4a3-928170f6e5d4"
+
+var unitRowColumns = []string{
+	"id", "property_id", "label", "bedrooms", "bathrooms", "square_feet", "current_rent", "market_rent", "occupied",
+	"lease_end_date", "created_at", "updated_at",
+}
+
+func newTestUnitHandler(t *testing.T) (*UnitHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &UnitHandler{units: services.NewUnitRepository(db)}, mock
+}
+
+// performUnitRequest calls handler for testPropertyID and testUnitID as a
+// user of tenantID
+func performUnitRequest(handler gin.HandlerFunc, tenantID, method, body string) *httptest.ResponseRecorder {
+	return performTagRequest(handler, tenantID, method, body,
+		gin.Params{{Key: "id", Value: testPropertyID}, {Key: "unitID", Value: testUnitID}})
+}
+
+// fourplexRows is a fourplex with units A and B leased and C and D vacant
+func fourplexRows() *sqlmock.Rows {
+	now := time.Now()
+	leaseEnd := time.Date(2027, 5, 31, 0, 0, 0, 0, time.UTC)
+	return sqlmock.NewRows(unitRowColumns).
+		AddRow("unit-a", testPropertyID, "A", 2, 1.0, 850, 1200.0, 1300.0, true, leaseEnd, now, now).
+		AddRow("unit-b", testPropertyID, "B", 2, 1.0, 850, 1150.0, 1300.0, true, leaseEnd, now, now).
+		AddRow("unit-c", testPropertyID, "C", 2, 1.0, 900, 0.0, 1350.0, false, nil, now, now).
+		AddRow("unit-d", testPropertyID, "D", 2, 1.0, 900, 0.0, 1350.0, false, nil, now, now)
+}
+
+// expectNoUnits expects the rent roll of a single-dwelling property to be
+// loaded for its detail response
+func expectNoUnits(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM property_units\s+WHERE property_id = \$1`).
+		WithArgs(testPropertyID).
+		WillReturnRows(sqlmock.NewRows(unitRowColumns))
+}
+
+func TestGetProperty_FourplexRentRoll(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_units\s+WHERE property_id = \$1\s+ORDER BY label`).
+		WithArgs(testPropertyID).
+		WillReturnRows(fourplexRows())
+
+	w := performPropertyRequest(handler.GetProperty, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		RentRoll services.RentRollSummary `json:"rent_roll"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, services.RentRollSummary{
+		UnitCount:        4,
+		OccupiedUnits:    2,
+		TotalCurrentRent: 2350,
+		TotalMarketRent:  5300,
+		OccupancyPercent: 50,
+	}, resp.RentRoll)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveCalculation_UsesRentRoll(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(market_rent\), 0\), COUNT\(\*\) FROM property_units WHERE property_id = \$1`).
+		WithArgs(testPropertyID).
+		WillReturnRows(sqlmock.NewRows([]string{"sum", "count"}).AddRow(5300.0, 4))
+	mock.ExpectQuery(`INSERT INTO arv_calculations`).
+		WillReturnRows(sqlmock.NewRows(arvCalculationRowColumns).
+			AddRow("calc-1", testPropertyID, "tenant-1", 400000.0, 30000.0, 0.0, 0.0, 520000.0, 334000.0, 90000.0, 20.93,
+				430000.0, 850.0, 7.9, false, 8.1, 1.3, "Low", "manual", 6.76, 1.233, time.Now()))
+
+	// The fourplex's market rents replace the manual monthly_rent, vacant
+	// units included
+	w := performPropertyRequest(handler.SaveCalculation, "tenant-1", http.MethodPost,
+		`{"purchase_price": 400000, "rehab_cost": 30000, "arv": 520000, "monthly_rent": 2350, "loan_term": 30, "use_rent_roll": true}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Data services.ArvResult `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, 5300.0, resp.Data.MonthlyRent)
+	assert.Equal(t, 5300.0*12, resp.Data.AnnualGrossIncome)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveCalculation_UseRentRollWithoutUnits(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_units WHERE property_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"sum", "count"}).AddRow(0.0, 0))
+
+	w := performPropertyRequest(handler.SaveCalculation, "tenant-1", http.MethodPost,
+		`{"purchase_price": 150000, "arv": 250000, "loan_term": 30, "use_rent_roll": true}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "NO_UNITS")
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is saved")
+}
+
+func TestCreateUnit(t *testing.T) {
+	handler, mock := newTestUnitHandler(t)
+
+	now := time.Now()
+	leaseEnd := time.Date(2027, 5, 31, 0, 0, 0, 0, time.UTC)
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`INSERT INTO property_units`).
+		WithArgs(testPropertyID, "tenant-1", "Unit 2", 2, 1.0, 850, 1200.0, 1300.0, true, leaseEnd).
+		WillReturnRows(sqlmock.NewRows(unitRowColumns).
+			AddRow(testUnitID, testPropertyID, "Unit 2", 2, 1.0, 850, 1200.0, 1300.0, true, leaseEnd, now, now))
+
+	w := performUnitRequest(handler.CreateUnit, "tenant-1", http.MethodPost,
+		`{"label": " Unit 2 ", "bedrooms": 2, "bathrooms": 1, "square_feet": 850, "current_rent": 1200, "market_rent": 1300,
+		  "occupied": true, "lease_end_date": "2027-05-31"}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Unit models.PropertyUnit `json:"unit"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, "Unit 2", resp.Unit.Label)
+	assert.True(t, resp.Unit.Occupied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUnit_DuplicateLabel(t *testing.T) {
+	handler, mock := newTestUnitHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`INSERT INTO property_units`).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "idx_property_units_property_id_label"})
+
+	w := performUnitRequest(handler.CreateUnit, "tenant-1", http.MethodPost, `{"label": "a", "market_rent": 1300}`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateUnit_ValidationFailures(t *testing.T) {
+	handler, mock := newTestUnitHandler(t)
+
+	for _, body := range []string{
+		`{"market_rent": 1300}`,
+		`{"label": "   "}`,
+		`{"label": "A", "current_rent": -1}`,
+		`{"label": "A", "lease_end_date": "05/31/2027"}`,
+	} {
+		w := performUnitRequest(handler.CreateUnit, "tenant-1", http.MethodPost, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateUnit_MarksVacant(t *testing.T) {
+	handler, mock := newTestUnitHandler(t)
+
+	now := time.Now()
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`UPDATE property_units\s+SET label = \$3.*WHERE id = \$1 AND property_id = \$2`).
+		WithArgs(testUnitID, testPropertyID, "C", 2, 1.0, 900, 0.0, 1350.0, false, nil).
+		WillReturnRows(sqlmock.NewRows(unitRowColumns).
+			AddRow(testUnitID, testPropertyID, "C", 2, 1.0, 900, 0.0, 1350.0, false, nil, now, now))
+
+	w := performUnitRequest(handler.UpdateUnit, "tenant-1", http.MethodPut,
+		`{"label": "C", "bedrooms": 2, "bathrooms": 1, "square_feet": 900, "market_rent": 1350}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteUnit_OtherTenantNotFound(t *testing.T) {
+	handler, mock := newTestUnitHandler(t)
+
+	expectPropertyLookup(mock, "tenant-2", false)
+
+	w := performUnitRequest(handler.DeleteUnit, "tenant-2", http.MethodDelete, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RehabItemHandler manages the rehab budget line items of a tenant's
+// properties
+type RehabItemHandler struct {
+	items *services.RehabItemRepository
+}
+
+// NewRehabItemHandler creates a new rehab item handler
+func NewRehabItemHandler() *RehabItemHandler {
+	return &RehabItemHandler{
+		items: services.NewRehabItemRepository(database.GetDB()),
+	}
+}
+
+// ListRehabItems returns a property's rehab items
+func (h *RehabItemHandler) ListRehabItems(c *gin.Context) {
+	items, err := h.items.List(c.GetString("tenant_id"), c.Param("id"))
+	if respondRehabItemError(c, err, "Failed to load rehab items") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"rehab_items": items,
+	})
+}
+
+// CreateRehabItem adds a line item to a property's rehab budget
+func (h *RehabItemHandler) CreateRehabItem(c *gin.Context) {
+	var req services.RehabItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Category and description are required, and costs can't be negative",
+		})
+		return
+	}
+
+	item, err := h.items.Create(c.GetString("tenant_id"), c.Param("id"), req)
+	if respondRehabItemError(c, err, "Failed to create rehab item") {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":    true,
+		"rehab_item": item,
+	})
+}
+
+// UpdateRehabItem replaces a line item of a property's rehab budget
+func (h *RehabItemHandler) UpdateRehabItem(c *gin.Context) {
+	var req services.RehabItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Category and description are required, and costs can't be negative",
+		})
+		return
+	}
+
+	item, err := h.items.Update(c.GetString("tenant_id"), c.Param("id"), c.Param("itemID"), req)
+	if respondRehabItemError(c, err, "Failed to update rehab item") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"rehab_item": item,
+	})
+}
+
+// DeleteRehabItem removes a line item from a property's rehab budget
+func (h *RehabItemHandler) DeleteRehabItem(c *gin.Context) {
+	err := h.items.Delete(c.GetString("tenant_id"), c.Param("id"), c.Param("itemID"))
+	if respondRehabItemError(c, err, "Failed to delete rehab item") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Rehab item deleted",
+	})
+}
+
+// GetRehabRollup returns a property's estimated and actual rehab costs by
+// category
+func (h *RehabItemHandler) GetRehabRollup(c *gin.Context) {
+	rollup, err := h.items.Rollup(c.GetString("tenant_id"), c.Param("id"))
+	if respondRehabItemError(c, err, "Failed to total rehab items") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"rollup":  rollup,
+	})
+}
+
+// respondRehabItemError writes the response for a failed rehab item lookup
+// or change and reports whether there was one
+func respondRehabItemError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrRehabItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Rehab item not found",
+		})
+	case errors.Is(err, services.ErrInvalidRehabCategory):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Category must be one of " + strings.Join(services.RehabCategories, ", "),
+		})
+	case errors.Is(err, services.ErrInvalidRehabItemStatus):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Status must be one of " + strings.Join(services.RehabItemStatuses, ", "),
+		})
+	case errors.Is(err, services.ErrRehabDescriptionBlank):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Description cannot be blank",
+		})
+	default:
+		return respondPropertyError(c, err, fallback)
+	}
+	return true
+}
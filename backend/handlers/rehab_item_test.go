@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"arvfinder-backend/models"
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRehabItemID = "5e4d3c2b-1a09-4f8e-8d7c-6b5a49382716"
+
+var rehabItemRowColumns = []string{
+	"id", "property_id", "category", "description", "estimated_cost", "actual_cost", "status", "created_at", "updated_at",
+}
+
+func newTestRehabItemHandler(t *testing.T) (*RehabItemHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &RehabItemHandler{items: services.NewRehabItemRepository(db)}, mock
+}
+
+// performRehabItemRequest calls handler for testPropertyID and
+// testRehabItemID as a user of tenantID
+func performRehabItemRequest(handler gin.HandlerFunc, tenantID, method, body string) *httptest.ResponseRecorder {
+	return performTagRequest(handler, tenantID, method, body,
+		gin.Params{{Key: "id", Value: testPropertyID}, {Key: "itemID", Value: testRehabItemID}})
+}
+
+func TestCreateRehabItem(t *testing.T) {
+	handler, mock := newTestRehabItemHandler(t)
+
+	now := time.Now()
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`INSERT INTO rehab_items`).
+		WithArgs(testPropertyID, "tenant-1", "kitchen", "Cabinets and counters", 18000.0, nil, "planned").
+		WillReturnRows(sqlmock.NewRows(rehabItemRowColumns).
+			AddRow(testRehabItemID, testPropertyID, "kitchen", "Cabinets and counters", 18000.0, nil, "planned", now, now))
+
+	w := performRehabItemRequest(handler.CreateRehabItem, "tenant-1", http.MethodPost,
+		`{"category": "Kitchen", "description": " Cabinets and counters ", "estimated_cost": 18000}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		RehabItem models.RehabItem `json:"rehab_item"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, "kitchen", resp.RehabItem.Category)
+	assert.Nil(t, resp.RehabItem.ActualCost)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateRehabItem_ValidationFailures(t *testing.T) {
+	handler, mock := newTestRehabItemHandler(t)
+
+	for _, body := range []string{
+		`{"description": "Shingles"}`,
+		`{"category": "roof", "description": "Shingles", "estimated_cost": -1}`,
+		`{"category": "roof", "description": "Shingles", "actual_cost": -1}`,
+		`{"category": "pool", "description": "Resurface"}`,
+		`{"category": "roof", "description": "Shingles", "status": "done"}`,
+	} {
+		w := performRehabItemRequest(handler.CreateRehabItem, "tenant-1", http.MethodPost, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateRehabItem_RecordsActualCost(t *testing.T) {
+	handler, mock := newTestRehabItemHandler(t)
+
+	now := time.Now()
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`UPDATE rehab_items\s+SET category = \$3.*WHERE id = \$1 AND property_id = \$2`).
+		WithArgs(testRehabItemID, testPropertyID, "roof", "Tear-off and shingles", 9500.0, 9800.0, "completed").
+		WillReturnRows(sqlmock.NewRows(rehabItemRowColumns).
+			AddRow(testRehabItemID, testPropertyID, "roof", "Tear-off and shingles", 9500.0, 9800.0, "completed", now, now))
+
+	w := performRehabItemRequest(handler.UpdateRehabItem, "tenant-1", http.MethodPut,
+		`{"category": "roof", "description": "Tear-off and shingles", "estimated_cost": 9500, "actual_cost": 9800, "status": "completed"}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteRehabItem_OtherPropertyNotFound(t *testing.T) {
+	handler, mock := newTestRehabItemHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectExec(`DELETE FROM rehab_items WHERE id = \$1 AND property_id = \$2`).
+		WithArgs(testRehabItemID, testPropertyID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	w := performRehabItemRequest(handler.DeleteRehabItem, "tenant-1", http.MethodDelete, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetRehabRollup(t *testing.T) {
+	handler, mock := newTestRehabItemHandler(t)
+
+	now := time.Now()
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM rehab_items\s+WHERE property_id = \$1`).
+		WithArgs(testPropertyID).
+		WillReturnRows(sqlmock.NewRows(rehabItemRowColumns).
+			AddRow("item-1", testPropertyID, "kitchen", "Cabinets", 18000.0, 19250.0, "completed", now, now).
+			AddRow("item-2", testPropertyID, "hvac", "Furnace", 6000.0, nil, "planned", now, now).
+			AddRow("item-3", testPropertyID, "kitchen", "Appliances", 4500.0, nil, "in_progress", now, now))
+
+	w := performRehabItemRequest(handler.GetRehabRollup, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Rollup services.RehabRollup `json:"rollup"`
+	}
+	decodeJSON(t, w, &resp)
+	require.Len(t, resp.Rollup.Categories, 2)
+	assert.Equal(t, services.RehabCategoryTotal{Category: "kitchen", Items: 2, Estimated: 22500, Actual: 19250, Variance: 1250},
+		resp.Rollup.Categories[0])
+	assert.Equal(t, services.RehabCategoryTotal{Category: "hvac", Items: 1, Estimated: 6000}, resp.Rollup.Categories[1])
+	assert.Equal(t, 28500.0, resp.Rollup.EstimatedTotal)
+	assert.Equal(t, 19250.0, resp.Rollup.ActualTotal)
+	assert.Equal(t, 1250.0, resp.Rollup.Variance)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveCalculation_UsesRehabItems(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(estimated_cost\), 0\), COUNT\(\*\) FROM rehab_items WHERE property_id = \$1`).
+		WithArgs(testPropertyID).
+		WillReturnRows(sqlmock.NewRows([]string{"sum", "count"}).AddRow(42500.0, 4))
+	// The summed items replace the rehab_cost in the request
+	mock.ExpectQuery(`INSERT INTO arv_calculations`).
+		WithArgs(testPropertyID, "tenant-1", models.Money(15000000), models.Money(4250000), models.Money(0), models.Money(0), models.Money(25000000), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), services.RehabCostSourceRehabItems, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(arvCalculationRowColumns).
+			AddRow("calc-1", testPropertyID, "tenant-1", 150000.0, 42500.0, 0.0, 0.0, 250000.0, 132500.0, 57500.0, 29.87,
+				192500.0, 120.0, 3.1, false, 6.4, 1.1, "Medium", "rehab_items", nil, nil, time.Now()))
+
+	w := performPropertyRequest(handler.SaveCalculation, "tenant-1", http.MethodPost,
+		`{"purchase_price": 150000, "rehab_cost": 30000, "arv": 250000, "monthly_rent": 2000, "loan_term": 30, "use_rehab_items": true}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Calculation models.ArvCalculation `json:"calculation"`
+		Data        services.ArvResult    `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, services.RehabCostSourceRehabItems, resp.Calculation.RehabCostSource)
+	assert.Equal(t, 42500.0, resp.Data.RehabCost)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveCalculation_UseRehabItemsWithoutItems(t *testing.T) {
+	handler, mock := newTestPropertyCRUDHandler(t)
+
+	expectPropertyLookup(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM rehab_items WHERE property_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"sum", "count"}).AddRow(0.0, 0))
+
+	w := performPropertyRequest(handler.SaveCalculation, "tenant-1", http.MethodPost,
+		`{"purchase_price": 150000, "arv": 250000, "loan_term": 30, "use_rehab_items": true}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "NO_REHAB_ITEMS")
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is saved")
+}
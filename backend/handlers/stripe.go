@@ -1,25 +1,126 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"log"
 	"net/http"
+	"time"
+	"arvfinder-backend/database"
 	"arvfinder-backend/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v79"
 )
 
 // StripeHandler handles Stripe-related endpoints
 type StripeHandler struct {
 	stripeService *services.StripeService
+	usage         *services.UsageRepository
+	billing       *services.BillingRepository
+	customers     *services.StripeCustomerRepository
+	idempotency   *services.IdempotencyStore
+	reports       *services.ReportPurchaseRepository
+	seats         *services.SeatManager
+	apiUsage      *services.APIUsageMeter
+	webhookSecret string
+
+	unsignedWebhooks bool // set by AllowUnsignedWebhooks, for local development
 }
 
-// NewStripeHandler creates a new Stripe handler
-func NewStripeHandler(stripeSecretKey string) *StripeHandler {
+// NewStripeHandler creates a new Stripe handler. Webhooks are verified with
+// webhookSecret, the endpoint's signing secret.
+func NewStripeHandler(stripeSecretKey, webhookSecret string) *StripeHandler {
+	stripeService := services.NewStripeService(stripeSecretKey)
+	billing := services.NewBillingRepository(database.GetDB(), services.NewEmailSenderFromEnv())
 	return &StripeHandler{
-		stripeService: services.NewStripeService(stripeSecretKey),
+		stripeService: stripeService,
+		usage:         services.NewUsageRepository(database.GetDB()),
+		billing:       billing,
+		customers:     services.NewStripeCustomerRepository(database.GetDB()),
+		idempotency:   services.NewIdempotencyStore(database.GetDB()),
+		reports:       services.NewReportPurchaseRepository(database.GetDB()),
+		seats:         services.NewSeatManager(billing, stripeService),
+		apiUsage:      services.NewAPIUsageMeter(database.GetDB(), stripeService),
+		webhookSecret: webhookSecret,
 	}
 }
 
+// Seats returns the seat manager keeping Enterprise seats in step with
+// tenants' members
+func (h *StripeHandler) Seats() *services.SeatManager {
+	return h.seats
+}
+
+// APIUsage returns the meter counting tenants' API calls and billing those
+// beyond their plans' included calls
+func (h *StripeHandler) APIUsage() *services.APIUsageMeter {
+	return h.apiUsage
+}
+
+// AllowUnsignedWebhooks takes webhooks as sent, without checking their
+// signatures, so they can be sent by hand in local development without a
+// signing secret. It's refused in release mode.
+func (h *StripeHandler) AllowUnsignedWebhooks() error {
+	if gin.Mode() == gin.ReleaseMode {
+		return errors.New("unsigned Stripe webhooks can't be allowed in release mode")
+	}
+	h.unsignedWebhooks = true
+	return nil
+}
+
+// SetPaymentGracePeriod sets how long a tenant keeps its plan after a
+// payment fails
+func (h *StripeHandler) SetPaymentGracePeriod(gracePeriod time.Duration) {
+	h.billing.SetGracePeriod(gracePeriod)
+}
+
+// SetCheckoutURLs sets where Checkout sends customers after paying and
+// after giving up; an empty URL leaves the frontend's default page
+func (h *StripeHandler) SetCheckoutURLs(successURL, cancelURL string) {
+	h.stripeService.SetCheckoutURLs(successURL, cancelURL)
+}
+
+// EnableAutomaticTax has Stripe Tax add sales tax to payments, returning
+// false when the account isn't registered to collect tax anywhere
+func (h *StripeHandler) EnableAutomaticTax() (bool, error) {
+	return h.stripeService.EnableAutomaticTax()
+}
+
+// SetPriceID sets the Stripe price for a plan's lookup key instead of
+// looking it up
+func (h *StripeHandler) SetPriceID(lookupKey, priceID string) {
+	h.stripeService.SetPriceID(lookupKey, priceID)
+}
+
+// LoadPrices finds each plan's Stripe price by its lookup key, returning
+// the lookup keys without one
+func (h *StripeHandler) LoadPrices() ([]string, error) {
+	return h.stripeService.RefreshPrices()
+}
+
+// RefreshPrices finds each plan's Stripe price again, e.g. after prices
+// were changed in the dashboard, and returns the plans with them
+func (h *StripeHandler) RefreshPrices(c *gin.Context) {
+	missing, err := h.stripeService.RefreshPrices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to refresh prices",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"plans":   h.stripeService.GetSubscriptionPlans(),
+			"missing": missing,
+		},
+	})
+}
+
 // GetSubscriptionPlans returns available subscription plans
 func (h *StripeHandler) GetSubscriptionPlans(c *gin.Context) {
 	plans := h.stripeService.GetSubscriptionPlans()
@@ -34,12 +135,23 @@ func (h *StripeHandler) GetSubscriptionPlans(c *gin.Context) {
 	})
 }
 
-// CreateSubscription creates a new subscription for a customer
+// CreateSubscription creates a new subscription for the caller's tenant,
+// billed to its Stripe customer. The price is given by ID or as a plan and
+// a billing interval.
 func (h *StripeHandler) CreateSubscription(c *gin.Context) {
+	key := h.idempotencyKey(c, "create-subscription")
+	if h.replayIdempotent(c, key) {
+		return
+	}
+
 	var req struct {
-		Email   string `json:"email" binding:"required,email"`
-		Name    string `json:"name" binding:"required"`
-		PriceID string `json:"price_id" binding:"required"`
+		Email         string `json:"email" binding:"required,email"`
+		Name          string `json:"name" binding:"required"`
+		PriceID       string `json:"price_id" binding:"required_without=Plan"`
+		Plan          string `json:"plan"`
+		Interval      string `json:"interval" binding:"omitempty,oneof=month year"`
+		PromotionCode string `json:"promotion_code"`
+		BillingAddress *services.BillingAddress `json:"billing_address"` // required the first time when tax is collected
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -49,9 +161,11 @@ func (h *StripeHandler) CreateSubscription(c *gin.Context) {
 		})
 		return
 	}
+	if !h.selectPrice(c, &req.PriceID, req.Plan, req.Interval) {
+		return
+	}
 
-	// Create customer first
-	customer, err := h.stripeService.CreateCustomer(req.Email, req.Name)
+	customerID, err := h.customers.GetOrCreateCustomer(c.GetString("tenant_id"), customerEmail(c, req.Email), req.Name, stripeKey(key, "customer"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create customer",
@@ -59,10 +173,33 @@ func (h *StripeHandler) CreateSubscription(c *gin.Context) {
 		})
 		return
 	}
+	if !h.billingAddress(c, c.GetString("tenant_id"), customerID, req.BillingAddress) {
+		return
+	}
+
+	var discount *services.SubscriptionDiscount
+	if req.PromotionCode != "" {
+		discount, err = h.stripeService.ResolvePromotionCode(req.PromotionCode, req.PriceID, customerID)
+		if err != nil {
+			respondPromotionCodeError(c, err)
+			return
+		}
+	}
+
+	tenantID := c.GetString("tenant_id")
+	trialDays, ok := h.claimTrial(c, tenantID, req.PriceID)
+	if !ok {
+		return
+	}
 
 	// Create subscription
-	subscription, err := h.stripeService.CreateSubscription(customer.ID, req.PriceID)
+	subscription, err := h.stripeService.CreateSubscription(customerID, req.PriceID, discount, trialDays, stripeKey(key, "subscription"))
 	if err != nil {
+		if trialDays > 0 {
+			if err := h.billing.ReleaseTrial(tenantID); err != nil {
+				log.Printf("Failed to release trial: %v", err)
+			}
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create subscription",
 			"details": err.Error(),
@@ -70,19 +207,358 @@ func (h *StripeHandler) CreateSubscription(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	data := gin.H{
+		"customer_id":     customerID,
+		"subscription_id": subscription.ID,
+		"status":          subscription.Status,
+		"discount":        discount,
+	}
+	if subscription.TrialEnd > 0 {
+		data["trial_end"] = time.Unix(subscription.TrialEnd, 0).UTC()
+	}
+	// A first invoice discounted to nothing has no payment to confirm, and a
+	// trial saves the card for later instead
+	if invoice := subscription.LatestInvoice; invoice != nil {
+		data["first_invoice_amount"] = invoice.AmountDue
+		if invoice.PaymentIntent != nil {
+			data["client_secret"] = invoice.PaymentIntent.ClientSecret
+		}
+	}
+	if subscription.PendingSetupIntent != nil {
+		data["client_secret"] = subscription.PendingSetupIntent.ClientSecret
+	}
+
+	h.respondIdempotent(c, key, "create-subscription", gin.H{
+		"success": true,
+		"data": data,
+	})
+}
+
+// billingAddress makes sure customerID has a billing address to work out
+// tax from when tax is collected: the one in the request, which is saved,
+// or one tenantID gave before. It returns false, having answered, without
+// one.
+func (h *StripeHandler) billingAddress(c *gin.Context, tenantID, customerID string, address *services.BillingAddress) bool {
+	if address != nil {
+		if err := h.customers.SaveBillingAddress(tenantID, customerID, *address); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to save billing address",
+				"details": err.Error(),
+			})
+			return false
+		}
+		return true
+	}
+	if !h.stripeService.AutomaticTax() {
+		return true
+	}
+
+	if tenantID != "" {
+		saved, err := h.customers.BillingAddress(tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to load billing address",
+				"details": err.Error(),
+			})
+			return false
+		}
+		if saved != nil {
+			return true
+		}
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":   "Billing address is required",
+		"details": services.ErrBillingAddressRequired.Error(),
+		"code":    "BILLING_ADDRESS_REQUIRED",
+	})
+	return false
+}
+
+// claimTrial returns the free trial a subscription to priceID starts with.
+// A tenant gets one trial, however often it subscribes, so once it's used
+// this returns 0. It returns false, having answered, when the trial can't
+// be claimed.
+func (h *StripeHandler) claimTrial(c *gin.Context, tenantID, priceID string) (int, bool) {
+	trialDays := h.stripeService.TrialDays(priceID)
+	if trialDays == 0 {
+		return 0, true
+	}
+	claimed, err := h.billing.ClaimTrial(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to create subscription",
+			"details": err.Error(),
+		})
+		return 0, false
+	}
+	if !claimed {
+		return 0, true
+	}
+	return trialDays, true
+}
+
+// CreateCheckoutSession starts a Stripe Checkout session for the caller's
+// tenant, an alternative to paying in the app: in subscription mode for a
+// price, given by ID or as a plan and billing interval, and in payment mode
+// for a report on a property. The customer is sent to the session's URL and
+// the webhook records what they bought.
+func (h *StripeHandler) CreateCheckoutSession(c *gin.Context) {
+	key := h.idempotencyKey(c, "checkout-session")
+	if h.replayIdempotent(c, key) {
+		return
+	}
+
+	var req struct {
+		Mode       string `json:"mode" binding:"required,oneof=subscription payment"`
+		Email      string `json:"email" binding:"required,email"`
+		Name       string `json:"name" binding:"required"`
+		PriceID    string `json:"price_id"`
+		Plan       string `json:"plan"`
+		Interval   string `json:"interval" binding:"omitempty,oneof=month year"`
+		PropertyID string `json:"property_id" binding:"required_if=Mode payment"`
+		// Optional; Checkout asks for it when tax is collected
+		BillingAddress *services.BillingAddress `json:"billing_address"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+	subscribing := req.Mode == "subscription"
+	if subscribing && req.PriceID == "" && req.Plan == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": "price_id or plan is required to subscribe",
+		})
+		return
+	}
+	if subscribing && !h.selectPrice(c, &req.PriceID, req.Plan, req.Interval) {
+		return
+	}
+
+	tenantID := c.GetString("tenant_id")
+	if !subscribing {
+		tier, err := h.usage.Tier(tenantID)
+		if err != nil {
+			log.Printf("Failed to load tenant plan: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to create checkout session",
+			})
+			return
+		}
+		if h.stripeService.CanGenerateReportForFree(tier) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": true,
+				"data": gin.H{
+					"free_report": true,
+					"message":     "Report generation is included in your subscription",
+				},
+			})
+			return
+		}
+	}
+
+	customerID, err := h.customers.GetOrCreateCustomer(tenantID, customerEmail(c, req.Email), req.Name, stripeKey(key, "customer"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to create customer",
+			"details": err.Error(),
+		})
+		return
+	}
+	if req.BillingAddress != nil {
+		if err := h.customers.SaveBillingAddress(tenantID, customerID, *req.BillingAddress); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to save billing address",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	var sessionID, url string
+	if subscribing {
+		trialDays, ok := h.claimTrial(c, tenantID, req.PriceID)
+		if !ok {
+			return
+		}
+		session, err := h.stripeService.CreateSubscriptionCheckout(tenantID, customerID, req.PriceID, trialDays, stripeKey(key, "checkout"))
+		if err != nil {
+			if trialDays > 0 {
+				if err := h.billing.ReleaseTrial(tenantID); err != nil {
+					log.Printf("Failed to release trial: %v", err)
+				}
+			}
+			respondCheckoutError(c, err)
+			return
+		}
+		sessionID, url = session.ID, session.URL
+	} else {
+		session, err := h.stripeService.CreateReportCheckout(tenantID, customerID, req.PropertyID, stripeKey(key, "checkout"))
+		if err != nil {
+			respondCheckoutError(c, err)
+			return
+		}
+		sessionID, url = session.ID, session.URL
+	}
+
+	h.respondIdempotent(c, key, "checkout-session", gin.H{
 		"success": true,
 		"data": gin.H{
-			"customer_id":     customer.ID,
-			"subscription_id": subscription.ID,
-			"client_secret":   subscription.LatestInvoice.PaymentIntent.ClientSecret,
-			"status":          subscription.Status,
+			"session_id":  sessionID,
+			"url":         url,
+			"mode":        req.Mode,
+			"customer_id": customerID,
 		},
 	})
 }
 
+// respondCheckoutError answers a Checkout session Stripe wouldn't create
+func respondCheckoutError(c *gin.Context, err error) {
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   "Failed to create checkout session",
+		"details": err.Error(),
+	})
+}
+
+// idempotencyKey returns the key a payment request is remembered by, from
+// its Idempotency-Key header or else its X-Request-ID. Without either, a
+// signed-in tenant's key comes from the operation and body, so a form
+// submitted twice in a few minutes is still only sent to Stripe once. Anonymous
+// callers can't be told apart that way, so without a header theirs is ""
+// and nothing's remembered. The body is read and put back for binding.
+func (h *StripeHandler) idempotencyKey(c *gin.Context, operation string) string {
+	clientKey := c.GetHeader("Idempotency-Key")
+	if clientKey == "" {
+		clientKey = c.GetHeader("X-Request-ID")
+	}
+	if clientKey == "" && c.GetString("tenant_id") == "" {
+		return ""
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return ""
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if clientKey == "" {
+		return h.idempotency.DerivedKey(c.GetString("tenant_id"), operation, body)
+	}
+	return services.IdempotencyKey(c.GetString("tenant_id"), operation, clientKey, body)
+}
+
+// replayIdempotent answers with the response saved for key, returning true,
+// when the request is a retry. If the saved responses can't be read the
+// request goes ahead, relying on Stripe's own idempotency to not charge twice.
+func (h *StripeHandler) replayIdempotent(c *gin.Context, key string) bool {
+	if key == "" {
+		return false
+	}
+	response, err := h.idempotency.Lookup(key)
+	if err != nil {
+		log.Printf("Failed to look up idempotency key: %v", err)
+		return false
+	}
+	if response == nil {
+		return false
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", response)
+	return true
+}
+
+// respondIdempotent answers with response, saving it for retries with key
+func (h *StripeHandler) respondIdempotent(c *gin.Context, key, operation string, response gin.H) {
+	if key != "" {
+		if err := h.idempotency.Save(key, operation, response); err != nil {
+			log.Printf("Failed to save idempotency key: %v", err)
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// stripeKey is the idempotency key for one Stripe call made for the request
+// with key, or "" without one
+func stripeKey(key, call string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "-" + call
+}
+
+// customerEmail is the email a purchase's Stripe customer is found or
+// created by: a signed-in caller's own, not whatever the body gives, which
+// is only used before signing in
+func customerEmail(c *gin.Context, given string) string {
+	if email := c.GetString("user_email"); email != "" && c.GetString("tenant_id") != "" {
+		return email
+	}
+	return given
+}
+
+// selectPrice sets priceID to plan's price for interval when it isn't given
+// already. It returns false, having answered, for a plan or interval that
+// can't be bought.
+func (h *StripeHandler) selectPrice(c *gin.Context, priceID *string, plan, interval string) bool {
+	if *priceID != "" {
+		return true
+	}
+	selected, err := h.stripeService.PriceFor(services.SubscriptionTier(plan), interval)
+	if errors.Is(err, services.ErrPlanPriceMissing) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Plan's price hasn't been set up in Stripe",
+			"code":  "PLAN_PRICE_MISSING",
+		})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Plan isn't available with this billing interval",
+			"code":  "PLAN_NOT_AVAILABLE",
+		})
+		return false
+	}
+	*priceID = selected
+	return true
+}
+
+// respondPromotionCodeError answers a promotion code that can't be used
+// with a code saying why
+func respondPromotionCodeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrPromotionCodeInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Promotion code not found",
+			"code":  "PROMO_CODE_INVALID",
+		})
+	case errors.Is(err, services.ErrPromotionCodeExpired):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Promotion code has expired",
+			"code":  "PROMO_CODE_EXPIRED",
+		})
+	case errors.Is(err, services.ErrPromotionCodeNotApplicable):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Promotion code doesn't apply to this plan",
+			"code":  "PROMO_CODE_NOT_APPLICABLE",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check promotion code",
+			"details": err.Error(),
+		})
+	}
+}
+
 // CreatePaymentIntent creates a payment intent for one-time payments
 func (h *StripeHandler) CreatePaymentIntent(c *gin.Context) {
+	key := h.idempotencyKey(c, "create-payment-intent")
+	if h.replayIdempotent(c, key) {
+		return
+	}
+
 	var req struct {
 		Amount     int64  `json:"amount" binding:"required,min=50"`     // Minimum $0.50
 		Currency   string `json:"currency" binding:"required"`
@@ -102,7 +578,7 @@ func (h *StripeHandler) CreatePaymentIntent(c *gin.Context) {
 		req.Currency = "usd"
 	}
 
-	paymentIntent, err := h.stripeService.CreatePaymentIntent(req.Amount, req.Currency, req.CustomerID)
+	paymentIntent, err := h.stripeService.CreatePaymentIntent(req.Amount, req.Currency, req.CustomerID, stripeKey(key, "payment-intent"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create payment intent",
@@ -111,7 +587,7 @@ func (h *StripeHandler) CreatePaymentIntent(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	h.respondIdempotent(c, key, "create-payment-intent", gin.H{
 		"success": true,
 		"data": gin.H{
 			"client_secret": paymentIntent.ClientSecret,
@@ -122,11 +598,17 @@ func (h *StripeHandler) CreatePaymentIntent(c *gin.Context) {
 
 // CreateReportPayment creates a payment intent for report generation
 func (h *StripeHandler) CreateReportPayment(c *gin.Context) {
+	key := h.idempotencyKey(c, "create-report-payment")
+	if h.replayIdempotent(c, key) {
+		return
+	}
+
 	var req struct {
 		CustomerEmail string `json:"customer_email" binding:"required,email"`
 		CustomerName  string `json:"customer_name" binding:"required"`
 		PropertyID    string `json:"property_id" binding:"required"`
 		UserTier      string `json:"user_tier"` // starter, professional, enterprise
+		BillingAddress *services.BillingAddress `json:"billing_address"` // required when tax is collected, unless the tenant's given one before
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -154,8 +636,8 @@ func (h *StripeHandler) CreateReportPayment(c *gin.Context) {
 		return
 	}
 
-	// Create customer first if they don't exist
-	customer, err := h.stripeService.CreateCustomer(req.CustomerEmail, req.CustomerName)
+	// Signed in, the tenant's own customer is used
+	customerID, err := h.customers.GetOrCreateCustomer(c.GetString("tenant_id"), customerEmail(c, req.CustomerEmail), req.CustomerName, stripeKey(key, "customer"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create customer",
@@ -163,9 +645,12 @@ func (h *StripeHandler) CreateReportPayment(c *gin.Context) {
 		})
 		return
 	}
+	if !h.billingAddress(c, c.GetString("tenant_id"), customerID, req.BillingAddress) {
+		return
+	}
 
 	// Create payment intent for report
-	paymentIntent, err := h.stripeService.CreateReportPaymentIntent(customer.ID, req.PropertyID)
+	paymentIntent, err := h.stripeService.CreateReportPaymentIntent(customerID, req.PropertyID, stripeKey(key, "payment-intent"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create payment intent",
@@ -176,13 +661,15 @@ func (h *StripeHandler) CreateReportPayment(c *gin.Context) {
 
 	reportInfo := h.stripeService.GetReportPaymentInfo()
 
-	c.JSON(http.StatusOK, gin.H{
+	h.respondIdempotent(c, key, "create-report-payment", gin.H{
 		"success": true,
 		"data": gin.H{
 			"client_secret": paymentIntent.ClientSecret,
 			"payment_intent_id": paymentIntent.ID,
-			"customer_id": customer.ID,
+			"customer_id": customerID,
 			"amount": reportInfo.Price,
+			"tax": paymentIntent.Amount - reportInfo.Price,
+			"total": paymentIntent.Amount,
 			"currency": reportInfo.Currency,
 			"description": reportInfo.Description,
 			"free_report": false,
@@ -190,10 +677,13 @@ func (h *StripeHandler) CreateReportPayment(c *gin.Context) {
 	})
 }
 
-// CancelSubscription cancels a user's subscription
-func (h *StripeHandler) CancelSubscription(c *gin.Context) {
+// AuthorizeReport is asked before generating a report on a property. Plans
+// that include reports are let through; on Starter it uses up a report
+// payment for the property, so each payment buys one report, and answers
+// 402 Payment Required without one.
+func (h *StripeHandler) AuthorizeReport(c *gin.Context) {
 	var req struct {
-		SubscriptionID string `json:"subscription_id" binding:"required"`
+		PropertyID string `json:"property_id" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -204,7 +694,83 @@ func (h *StripeHandler) CancelSubscription(c *gin.Context) {
 		return
 	}
 
-	subscription, err := h.stripeService.CancelSubscription(req.SubscriptionID)
+	tenantID := c.GetString("tenant_id")
+	tier, err := h.usage.Tier(tenantID)
+	if err != nil {
+		log.Printf("Failed to load tenant plan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to authorize report",
+		})
+		return
+	}
+	if h.stripeService.CanGenerateReportForFree(tier) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data": gin.H{
+				"authorized": true,
+				"free_report": true,
+				"tier": tier,
+			},
+		})
+		return
+	}
+
+	paymentIntentID, err := h.reports.ConsumePurchase(tenantID, req.PropertyID)
+	if errors.Is(err, services.ErrReportNotPaid) {
+		reportInfo := h.stripeService.GetReportPaymentInfo()
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error": "Report has not been paid for",
+			"code": "REPORT_PAYMENT_REQUIRED",
+			"tier": tier,
+			"amount": reportInfo.Price,
+			"currency": reportInfo.Currency,
+			"payment_url": "/api/v1/payments/create-report-payment",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to use report purchase: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to authorize report",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"authorized": true,
+			"free_report": false,
+			"tier": tier,
+			"payment_intent_id": paymentIntentID,
+		},
+	})
+}
+
+// CancelSubscription cancels the caller's tenant's subscription. By
+// default it cancels at the end of the period, keeping the plan for the time
+// already paid for; cancel_at_period_end false cancels it straight away.
+func (h *StripeHandler) CancelSubscription(c *gin.Context) {
+	var req struct {
+		SubscriptionID    string `json:"subscription_id"`
+		CancelAtPeriodEnd *bool  `json:"cancel_at_period_end"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+	atPeriodEnd := req.CancelAtPeriodEnd == nil || *req.CancelAtPeriodEnd
+
+	subscriptionID, ok := h.tenantSubscription(c, req.SubscriptionID)
+	if !ok {
+		return
+	}
+
+	subscription, err := h.stripeService.CancelSubscription(subscriptionID, atPeriodEnd)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to cancel subscription",
@@ -212,22 +778,111 @@ func (h *StripeHandler) CancelSubscription(c *gin.Context) {
 		})
 		return
 	}
+	cancelAt := services.PendingCancellation(subscription)
+	if err := h.billing.SavePendingCancellation(c.GetString("tenant_id"), cancelAt); err != nil {
+		// The webhook records it as well
+		log.Printf("Failed to save pending cancellation: %v", err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
 			"subscription_id": subscription.ID,
 			"status": subscription.Status,
+			"cancel_at_period_end": subscription.CancelAtPeriodEnd,
+			"cancel_at": cancelAt,
 			"canceled_at": subscription.CanceledAt,
 		},
 	})
 }
 
-// UpdateSubscription updates a subscription to a new plan
+// ResumeSubscription takes back the caller's tenant's cancellation at the
+// period end, before the period's over
+func (h *StripeHandler) ResumeSubscription(c *gin.Context) {
+	var req struct {
+		SubscriptionID string `json:"subscription_id"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	subscriptionID, ok := h.tenantSubscription(c, req.SubscriptionID)
+	if !ok {
+		return
+	}
+
+	subscription, err := h.stripeService.ResumeSubscription(subscriptionID)
+	if errors.Is(err, services.ErrSubscriptionNotCanceling) || errors.Is(err, services.ErrSubscriptionEnded) {
+		code := "SUBSCRIPTION_NOT_CANCELING"
+		if errors.Is(err, services.ErrSubscriptionEnded) {
+			code = "SUBSCRIPTION_ENDED"
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Subscription can't be resumed",
+			"details": err.Error(),
+			"code": code,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to resume subscription",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err := h.billing.SavePendingCancellation(c.GetString("tenant_id"), nil); err != nil {
+		log.Printf("Failed to clear pending cancellation: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"subscription_id": subscription.ID,
+			"status": subscription.Status,
+			"cancel_at_period_end": subscription.CancelAtPeriodEnd,
+		},
+	})
+}
+
+// tenantSubscription returns the ID of the caller's tenant's subscription,
+// which requested must match when given. It returns false, having answered,
+// when the tenant has no such subscription.
+func (h *StripeHandler) tenantSubscription(c *gin.Context, requested string) (string, bool) {
+	sub, err := h.billing.Subscription(c.GetString("tenant_id"))
+	if err != nil {
+		log.Printf("Failed to load subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load subscription",
+		})
+		return "", false
+	}
+	if sub == nil || sub.SubscriptionID == "" || (requested != "" && requested != sub.SubscriptionID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Subscription not found",
+			"code": "SUBSCRIPTION_NOT_FOUND",
+		})
+		return "", false
+	}
+	return sub.SubscriptionID, true
+}
+
+// UpdateSubscription moves the caller's tenant's subscription to a new plan,
+// given as a price ID or a plan and billing interval. Downgrades without a
+// proration_behavior take effect at the end of the period; switching
+// between monthly and yearly is invoiced straight away, so preview it first.
 func (h *StripeHandler) UpdateSubscription(c *gin.Context) {
 	var req struct {
-		SubscriptionID string `json:"subscription_id" binding:"required"`
-		NewPriceID     string `json:"new_price_id" binding:"required"`
+		SubscriptionID    string `json:"subscription_id"`
+		NewPriceID        string `json:"new_price_id" binding:"required_without=NewPlan"`
+		NewPlan           string `json:"new_plan"`
+		Interval          string `json:"interval" binding:"omitempty,oneof=month year"`
+		ProrationBehavior string `json:"proration_behavior" binding:"omitempty,oneof=create_prorations none always_invoice"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -237,8 +892,16 @@ func (h *StripeHandler) UpdateSubscription(c *gin.Context) {
 		})
 		return
 	}
+	if !h.selectPrice(c, &req.NewPriceID, req.NewPlan, req.Interval) {
+		return
+	}
 
-	subscription, err := h.stripeService.UpdateSubscription(req.SubscriptionID, req.NewPriceID)
+	subscriptionID, ok := h.tenantSubscription(c, req.SubscriptionID)
+	if !ok {
+		return
+	}
+
+	change, err := h.stripeService.UpdateSubscription(subscriptionID, req.NewPriceID, req.ProrationBehavior)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to update subscription",
@@ -247,23 +910,164 @@ func (h *StripeHandler) UpdateSubscription(c *gin.Context) {
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": change,
+	})
+}
+
+// PreviewPlanChange returns what moving the caller's tenant's subscription to
+// a new plan would charge, now and each period after, without changing it
+func (h *StripeHandler) PreviewPlanChange(c *gin.Context) {
+	var req struct {
+		SubscriptionID    string `json:"subscription_id"`
+		NewPriceID        string `json:"new_price_id" binding:"required_without=NewPlan"`
+		NewPlan           string `json:"new_plan"`
+		Interval          string `json:"interval" binding:"omitempty,oneof=month year"`
+		ProrationBehavior string `json:"proration_behavior" binding:"omitempty,oneof=create_prorations none always_invoice"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !h.selectPrice(c, &req.NewPriceID, req.NewPlan, req.Interval) {
+		return
+	}
+
+	subscriptionID, ok := h.tenantSubscription(c, req.SubscriptionID)
+	if !ok {
+		return
+	}
+
+	preview, err := h.stripeService.PreviewPlanChange(subscriptionID, req.NewPriceID, req.ProrationBehavior)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to preview plan change",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": preview,
+	})
+}
+
+// seatChangeRequest asks for a number of seats
+type seatChangeRequest struct {
+	Seats             int64  `json:"seats" binding:"required,min=1"`
+	ProrationBehavior string `json:"proration_behavior" binding:"omitempty,oneof=create_prorations none always_invoice"`
+}
+
+// UpdateSeats sets how many seats the caller's Enterprise tenant pays for.
+// Added seats are prorated; removed ones are billed until the period ends.
+func (h *StripeHandler) UpdateSeats(c *gin.Context) {
+	var req seatChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	sub, err := h.seats.ChangeSeats(c.GetString("tenant_id"), req.Seats, req.ProrationBehavior)
+	if respondSeatError(c, err, "Failed to update seats") {
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"subscription_id": subscription.ID,
-			"status": subscription.Status,
+			"seats_used": sub.SeatsUsed,
+			"seats_purchased": sub.Seats,
 		},
 	})
 }
 
-// GetSubscriptionStatus returns subscription status and usage
+// PreviewSeats returns what changing the caller's tenant's seats would
+// charge, now and each period after, without changing them
+func (h *StripeHandler) PreviewSeats(c *gin.Context) {
+	var req seatChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	preview, err := h.seats.PreviewSeats(c.GetString("tenant_id"), req.Seats, req.ProrationBehavior)
+	if respondSeatError(c, err, "Failed to preview seat change") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": preview,
+	})
+}
+
+// respondSeatError answers for a failed seat change and reports whether
+// there was one
+func respondSeatError(c *gin.Context, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrNotSeatBilled):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Only Enterprise is billed per seat",
+			"code": "NOT_SEAT_BILLED",
+		})
+	case errors.Is(err, services.ErrSeatsBelowMembers):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Every active member needs a seat; deactivate members first",
+			"code": "SEATS_BELOW_MEMBERS",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fallback,
+			"details": err.Error(),
+		})
+	}
+	return true
+}
+
+// GetSubscriptionStatus returns the caller's tenant's subscription status
+// and its ARV calculations this month
 func (h *StripeHandler) GetSubscriptionStatus(c *gin.Context) {
-	// This would typically get the user's current subscription from the database
-	// For now, return a mock response
-	tier := services.TierStarter // This would come from the user's database record
-	currentUsage := 3            // This would come from usage tracking
+	usage, err := h.usage.ArvUsage(c.GetString("tenant_id"))
+	if err != nil {
+		log.Printf("Failed to load ARV usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load subscription status",
+		})
+		return
+	}
 
-	status := h.stripeService.GetSubscriptionStatus(tier, currentUsage)
+	sub, err := h.billing.Subscription(c.GetString("tenant_id"))
+	if err != nil {
+		log.Printf("Failed to load subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load subscription status",
+		})
+		return
+	}
+
+	status := h.stripeService.GetSubscriptionStatus(usage.Tier, usage.Used).WithSubscription(sub, time.Now())
+	status.APIUsage, err = h.apiUsage.Usage(c.GetString("tenant_id"), usage.Tier)
+	if err != nil {
+		log.Printf("Failed to load API usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load subscription status",
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -271,6 +1075,37 @@ func (h *StripeHandler) GetSubscriptionStatus(c *gin.Context) {
 	})
 }
 
+// GetInvoices returns the caller's tenant's recent invoices, with the tax
+// on each
+func (h *StripeHandler) GetInvoices(c *gin.Context) {
+	customerID, err := h.customers.Customer(c.GetString("tenant_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load invoices",
+			"details": err.Error(),
+		})
+		return
+	}
+	invoices := []services.InvoiceSummary{}
+	if customerID != "" {
+		invoices, err = h.stripeService.Invoices(customerID, 24)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to load invoices",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"invoices": invoices,
+		},
+	})
+}
+
 // HandleWebhook handles Stripe webhooks
 func (h *StripeHandler) HandleWebhook(c *gin.Context) {
 	const MaxBodyBytes = int64(65536)
@@ -284,13 +1119,22 @@ func (h *StripeHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
+	if h.webhookSecret == "" && !h.unsignedWebhooks {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Stripe webhooks aren't configured",
+		})
+		return
+	}
+
 	// Get the signature header
 	signature := c.GetHeader("Stripe-Signature")
-	
-	// In production, you would store this in environment variables
-	endpointSecret := "whsec_your_webhook_secret_here"
 
-	event, err := h.stripeService.ValidateWebhookSignature(payload, signature, endpointSecret)
+	var event stripe.Event
+	if h.unsignedWebhooks {
+		event, err = h.stripeService.ParseUnsignedWebhook(payload)
+	} else {
+		event, err = h.stripeService.ValidateWebhookSignature(payload, signature, h.webhookSecret)
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid signature",
@@ -298,37 +1142,67 @@ func (h *StripeHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	// Handle the event
-	switch event.Type {
-	case "payment_intent.succeeded":
-		// Handle successful payment
-		// Update user's subscription status in database
-		break
-	case "invoice.payment_succeeded":
-		// Handle successful subscription payment
-		// Update user's subscription status and reset usage counters
-		break
-	case "customer.subscription.deleted":
-		// Handle subscription cancellation
-		// Update user's subscription status in database
-		break
-	case "customer.subscription.updated":
-		// Handle subscription updates
-		// Update user's subscription tier in database
-		break
-	default:
-		// Unexpected event type
-		break
+	// A failure is answered with a 500 so Stripe retries the event
+	processed, err := h.billing.HandleWebhookEvent(event)
+	if err != nil {
+		log.Printf("Failed to handle Stripe webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to handle event",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"received":  true,
+		"duplicate": !processed,
+	})
+}
+
+// ReplayWebhook applies a Stripe event that's been handled again by its ID,
+// the same way as when it was delivered
+func (h *StripeHandler) ReplayWebhook(c *gin.Context) {
+	var req struct {
+		EventID string `json:"event_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	eventType, err := h.billing.ReplayWebhookEvent(req.EventID)
+	if errors.Is(err, services.ErrWebhookEventNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Stripe event not found",
+			"code":  "EVENT_NOT_FOUND",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to replay event",
+			"details": err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"received": true,
+		"success": true,
+		"data": gin.H{
+			"event_id": req.EventID,
+			"type":     eventType,
+		},
 	})
 }
 
-// SetupPrices creates the subscription prices in Stripe (for initial setup)
+// SetupPrices creates the subscription products and prices in Stripe
+// that don't exist yet, so it's safe to call again. With ?dry_run=true it
+// only says what it would create.
 func (h *StripeHandler) SetupPrices(c *gin.Context) {
-	err := h.stripeService.CreatePrices()
+	dryRun := c.Query("dry_run") == "true"
+	setup, err := h.stripeService.CreatePrices(dryRun)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create prices",
@@ -337,8 +1211,16 @@ func (h *StripeHandler) SetupPrices(c *gin.Context) {
 		return
 	}
 
+	message := "Prices created successfully"
+	switch {
+	case dryRun:
+		message = "Dry run; nothing was created"
+	case len(setup.Created) == 0:
+		message = "Prices already set up; nothing was created"
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "Prices created successfully",
+		"message": message,
+		"data":    setup,
 	})
 }
\ No newline at end of file
@@ -0,0 +1,1257 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/webhook"
+)
+
+const testWebhookSecret = "whsec_test"
+
+func newTestStripeHandler(t *testing.T) (*StripeHandler, sqlmock.Sqlmock) {
+	return newTestStripeHandlerWithEmail(t, services.LogEmailSender{})
+}
+
+func newTestStripeHandlerWithEmail(t *testing.T, email services.EmailSender) (*StripeHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	stripeService := services.NewStripeService("")
+	stripeService.SetPriceLister(stubPriceLister{})
+	_, err = stripeService.RefreshPrices()
+	require.NoError(t, err)
+	return &StripeHandler{
+		stripeService: stripeService,
+		usage:         services.NewUsageRepository(db),
+		billing:       services.NewBillingRepository(db, email),
+		customers:     services.NewStripeCustomerRepository(db),
+		idempotency:   services.NewIdempotencyStore(db),
+		reports:       services.NewReportPurchaseRepository(db),
+		apiUsage:      services.NewAPIUsageMeter(db, stripeService),
+		webhookSecret: testWebhookSecret,
+	}, mock
+}
+
+// expectNoSavedResponse expects a payment request's idempotency key to be
+// looked up and found unused
+func expectNoSavedResponse(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT response FROM idempotency_keys WHERE key = \$1`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"response"}))
+}
+
+// expectResponseSaved expects the response to a payment request to be
+// remembered for retries
+func expectResponseSaved(mock sqlmock.Sqlmock, operation string) {
+	mock.ExpectExec(`INSERT INTO idempotency_keys`).
+		WithArgs(sqlmock.AnyArg(), operation, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// stubPriceLister has a price_<lookup key> price for every plan
+type stubPriceLister struct{}
+
+func (stubPriceLister) ListPricesByLookupKey(lookupKeys []string) ([]*stripe.Price, error) {
+	var prices []*stripe.Price
+	for _, key := range lookupKeys {
+		prices = append(prices, &stripe.Price{ID: "price_" + key, LookupKey: key})
+	}
+	return prices, nil
+}
+
+// recordingEmailSender keeps the emails sent, by recipient and subject
+type recordingEmailSender struct {
+	sent []string
+}
+
+func (r *recordingEmailSender) Send(to, subject, body string) error {
+	r.sent = append(r.sent, to+": "+subject)
+	return nil
+}
+
+// performWebhook posts the fixture in testdata/stripe to the webhook, signed
+// with secret
+func performWebhook(t *testing.T, handler *StripeHandler, fixture, secret string) *httptest.ResponseRecorder {
+	payload, err := os.ReadFile(filepath.Join("testdata", "stripe", fixture))
+	require.NoError(t, err)
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: secret})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload))
+	c.Request.Header.Set("Stripe-Signature", signed.Header)
+	handler.HandleWebhook(c)
+	return w
+}
+
+// expectStripeEvent expects the event to be recorded, as new when isNew
+func expectStripeEvent(mock sqlmock.Sqlmock, id, eventType string, isNew bool) {
+	mock.ExpectBegin()
+	affected := int64(0)
+	if isNew {
+		affected = 1
+	}
+	mock.ExpectExec(`INSERT INTO stripe_events .* ON CONFLICT \(id\) DO NOTHING`).
+		WithArgs(id, eventType, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, affected))
+}
+
+// expectSubscriptionSaved expects tenant-1's subscription to be saved and
+// the tenant moved to the tier
+func expectSubscriptionSaved(mock sqlmock.Sqlmock, tier services.SubscriptionTier, status string, trialEnd *time.Time) {
+	periodEnd := time.Unix(1793491200, 0).UTC()
+	mock.ExpectQuery(`INSERT INTO subscriptions .* ON CONFLICT \(tenant_id\) DO UPDATE`).
+		WithArgs("tenant-1", "cus_1", "sub_1", string(tier), status, &periodEnd, trialEnd, int64(0)).
+		WillReturnRows(sqlmock.NewRows([]string{"tier"}).AddRow(string(tier)))
+	mock.ExpectExec(`UPDATE tenants SET subscription_tier = \$2`).
+		WithArgs("tenant-1", string(tier)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectPendingCancellation expects when tenant-1's subscription cancels to
+// be saved, or cleared with nil
+func expectPendingCancellation(mock sqlmock.Sqlmock, cancelAt *time.Time) {
+	mock.ExpectExec(`UPDATE subscriptions SET cancel_at = \$2`).
+		WithArgs("tenant-1", cancelAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectGracePeriodEnded expects a payment to end tenant-1's grace period
+func expectGracePeriodEnded(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`UPDATE subscriptions SET grace_period_ends_at = NULL`).
+		WithArgs("tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestHandleWebhook_InvoicePaidActivatesAndResetsUsage(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_invoice_paid", "invoice.payment_succeeded", true)
+	expectGracePeriodEnded(mock)
+	expectSubscriptionSaved(mock, services.TierProfessional, "active", nil)
+	mock.ExpectExec(`UPDATE usage_records SET arv_calculations = 0`).
+		WithArgs("tenant-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "invoice_payment_succeeded.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"received": true, "duplicate": false}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_RetriedEventIsIgnored(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_invoice_paid", "invoice.payment_succeeded", false)
+	mock.ExpectRollback()
+
+	w := performWebhook(t, handler, "invoice_payment_succeeded.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"received": true, "duplicate": true}`, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_SubscriptionUpdatedSyncsTier(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_subscription_updated", "customer.subscription.updated", true)
+	expectSubscriptionSaved(mock, services.TierEnterprise, "active", nil)
+	expectPendingCancellation(mock, nil)
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "customer_subscription_updated.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_SubscriptionDeletedDowngradesToStarter(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_subscription_deleted", "customer.subscription.deleted", true)
+	// Without a tenant_id in its metadata the customer finds the tenant
+	mock.ExpectQuery(`SELECT tenant_id FROM stripe_customers WHERE stripe_customer_id = \$1 .* SELECT tenant_id FROM subscriptions WHERE stripe_customer_id = \$1`).
+		WithArgs("cus_1").
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}).AddRow("tenant-1"))
+	expectSubscriptionSaved(mock, services.TierStarter, "canceled", nil)
+	expectPendingCancellation(mock, nil)
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "customer_subscription_deleted.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_ReportPaymentRecordsPurchase(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_report_paid", "payment_intent.succeeded", true)
+	mock.ExpectExec(`INSERT INTO report_purchases .* ON CONFLICT \(payment_intent_id\) DO NOTHING`).
+		WithArgs("pi_1", "tenant-1", "property-1", "cus_1", int64(999)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "payment_intent_succeeded.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_FailureIsRetried(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_report_paid", "payment_intent.succeeded", true)
+	mock.ExpectExec(`INSERT INTO report_purchases`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	w := performWebhook(t, handler, "payment_intent_succeeded.json", testWebhookSecret)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "Stripe retries on a 5xx")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_RejectsBadSignature(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+
+	w := performWebhook(t, handler, "invoice_payment_succeeded.json", "whsec_someone_else")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_RefusedWithoutSecret(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	handler.webhookSecret = ""
+
+	w := performWebhook(t, handler, "invoice_payment_succeeded.json", testWebhookSecret)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// subscriptionQuery loads a tenant's subscription, with subscriptionColumns
+const subscriptionQuery = `SELECT stripe_subscription_id, tier, status, current_period_end, trial_end, cancel_at, grace_period_ends_at, seats,.*\s+FROM subscriptions WHERE tenant_id = \$1`
+
+var subscriptionColumns = []string{"stripe_subscription_id", "tier", "status", "current_period_end", "trial_end", "cancel_at", "grace_period_ends_at", "seats", "seats_used"}
+
+func TestGetSubscriptionStatus_ReadsUsage(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectTenantTier(mock, "tenant-1", services.TierStarter)
+	mock.ExpectQuery(`SELECT arv_calculations FROM usage_records WHERE tenant_id = \$1 AND month = \$2`).
+		WithArgs("tenant-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}).AddRow(7))
+	mock.ExpectQuery(subscriptionQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows(subscriptionColumns))
+
+	w := performComparableRequest(handler.GetSubscriptionStatus, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data services.SubscriptionStatus `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, services.TierStarter, resp.Data.Tier)
+	assert.Equal(t, 7, resp.Data.ArvUsed)
+	assert.Equal(t, 10, resp.Data.ArvLimit)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSubscriptionStatus_ShowsAPIUsage(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectTenantTier(mock, "tenant-1", services.TierEnterprise)
+	mock.ExpectQuery(`SELECT arv_calculations FROM usage_records`).
+		WithArgs("tenant-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}).AddRow(40))
+	mock.ExpectQuery(subscriptionQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows(subscriptionColumns))
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(requests\), 0\) FROM api_usage`).
+		WithArgs("tenant-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(12500))
+
+	w := performComparableRequest(handler.GetSubscriptionStatus, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data services.SubscriptionStatus `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	require.NotNil(t, resp.Data.APIUsage)
+	assert.Equal(t, 12500, resp.Data.APIUsage.Calls)
+	assert.Equal(t, 10000, resp.Data.APIUsage.Included)
+	assert.Equal(t, 2500, resp.Data.APIUsage.Overage)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_SubscriptionCreatedRecordsTrial(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	trialEnd := time.Unix(1793491200, 0).UTC()
+	expectStripeEvent(mock, "evt_subscription_created", "customer.subscription.created", true)
+	expectSubscriptionSaved(mock, services.TierProfessional, "trialing", &trialEnd)
+	expectPendingCancellation(mock, nil)
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "customer_subscription_created_trialing.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_TrialWillEndEmailsAdmins(t *testing.T) {
+	emails := &recordingEmailSender{}
+	handler, mock := newTestStripeHandlerWithEmail(t, emails)
+	expectStripeEvent(mock, "evt_trial_will_end", "customer.subscription.trial_will_end", true)
+	mock.ExpectQuery(`SELECT email FROM users\s+WHERE tenant_id = \$1 AND role = 'admin'`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("owner@example.com").AddRow("partner@example.com"))
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "customer_subscription_trial_will_end.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{
+		"owner@example.com: Your ArvFinder trial ends on November 1, 2026",
+		"partner@example.com: Your ArvFinder trial ends on November 1, 2026",
+	}, emails.sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// stubStripeSubscriptions records the subscriptions created. Other calls
+// aren't expected and panic on the nil embedded API.
+type stubStripeSubscriptions struct {
+	services.StripeSubscriptionAPI
+	created []*stripe.SubscriptionParams
+}
+
+func (s *stubStripeSubscriptions) CreateSubscription(params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	s.created = append(s.created, params)
+	return &stripe.Subscription{ID: "sub_1", Status: stripe.SubscriptionStatusIncomplete}, nil
+}
+
+func TestCreateSubscription_TrialOncePerTenant(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	subscriptions := &stubStripeSubscriptions{}
+	handler.stripeService.SetSubscriptionAPI(subscriptions)
+
+	body := `{"email": "jane@example.com", "name": "Jane Doe", "price_id": "price_professional_monthly"}`
+	for _, trialUsed := range []bool{false, true} {
+		// Resubscribing comes well after the first response stops being replayed
+		expectNoSavedResponse(mock)
+		mock.ExpectQuery(`SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = \$1`).
+			WithArgs("tenant-1").
+			WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_1"))
+		claimed := int64(1)
+		if trialUsed {
+			claimed = 0
+		}
+		mock.ExpectExec(`UPDATE tenants SET trial_started_at = NOW\(\), updated_at = NOW\(\)\s+WHERE id = \$1 AND trial_started_at IS NULL`).
+			WithArgs("tenant-1").
+			WillReturnResult(sqlmock.NewResult(0, claimed))
+		expectResponseSaved(mock, "create-subscription")
+
+		w := performComparableRequest(handler.CreateSubscription, "tenant-1", http.MethodPost, body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// The first subscription gets fourteen days; subscribing again after
+	// canceling gets none
+	require.Len(t, subscriptions.created, 2)
+	require.NotNil(t, subscriptions.created[0].TrialPeriodDays)
+	assert.Equal(t, int64(14), *subscriptions.created[0].TrialPeriodDays)
+	assert.Equal(t, "cancel", *subscriptions.created[0].TrialSettings.EndBehavior.MissingPaymentMethod)
+	assert.Nil(t, subscriptions.created[1].TrialPeriodDays)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSubscriptionStatus_ReportsTrial(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectTenantTier(mock, "tenant-1", services.TierProfessional)
+	mock.ExpectQuery(`SELECT arv_calculations FROM usage_records WHERE tenant_id = \$1 AND month = \$2`).
+		WithArgs("tenant-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}))
+	trialEnd := time.Now().Add(3*24*time.Hour + time.Hour).UTC()
+	mock.ExpectQuery(subscriptionQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows(subscriptionColumns).
+			AddRow("sub_1", "professional", "trialing", trialEnd, trialEnd, nil, nil, 1, 1))
+
+	w := performComparableRequest(handler.GetSubscriptionStatus, "tenant-1", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data services.SubscriptionStatus `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, "trialing", resp.Data.Status)
+	assert.Equal(t, 4, resp.Data.TrialDaysRemaining, "part of a day counts as a day")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSubscriptionPlans_IncludesBothIntervals(t *testing.T) {
+	handler, _ := newTestStripeHandler(t)
+
+	w := performComparableRequest(handler.GetSubscriptionPlans, "", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data struct {
+			Plans map[services.SubscriptionTier]services.SubscriptionPlan `json:"plans"`
+		} `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	for _, tier := range []services.SubscriptionTier{services.TierProfessional, services.TierEnterprise} {
+		prices := resp.Data.Plans[tier].Prices
+		require.Len(t, prices, 2, tier)
+		assert.Equal(t, "month", prices[0].Interval)
+		assert.Equal(t, "year", prices[1].Interval)
+		assert.Equal(t, prices[0].Amount*2, prices[1].Savings, "%s: two months free", tier)
+	}
+}
+
+// noPriceLister finds no prices, as before setup-prices has been run
+type noPriceLister struct{}
+
+func (noPriceLister) ListPricesByLookupKey(lookupKeys []string) ([]*stripe.Price, error) {
+	return nil, nil
+}
+
+func TestGetSubscriptionPlans_FlagsMissingPrices(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	handler.stripeService.SetPriceLister(noPriceLister{})
+	_, err := handler.LoadPrices()
+	require.NoError(t, err)
+
+	w := performComparableRequest(handler.GetSubscriptionPlans, "", http.MethodGet, "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data struct {
+			Plans map[services.SubscriptionTier]services.SubscriptionPlan `json:"plans"`
+		} `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	plan := resp.Data.Plans[services.TierProfessional]
+	assert.Empty(t, plan.PriceID)
+	for _, price := range plan.Prices {
+		assert.False(t, price.Available, price.LookupKey)
+		assert.Empty(t, price.PriceID, price.LookupKey)
+	}
+
+	// Subscribing by plan says why rather than sending Stripe a bogus price
+	body := `{"email": "jane@example.com", "name": "Jane Doe", "plan": "professional"}`
+	expectNoSavedResponse(mock)
+	w = performComparableRequest(handler.CreateSubscription, "tenant-1", http.MethodPost, body)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "PLAN_PRICE_MISSING")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateSubscription_SelectsPriceByPlanAndInterval(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	subscriptions := &stubStripeSubscriptions{}
+	handler.stripeService.SetSubscriptionAPI(subscriptions)
+	expectNoSavedResponse(mock)
+	mock.ExpectQuery(`SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_1"))
+	mock.ExpectExec(`UPDATE tenants SET trial_started_at`).
+		WithArgs("tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectResponseSaved(mock, "create-subscription")
+
+	body := `{"email": "jane@example.com", "name": "Jane Doe", "plan": "professional", "interval": "year"}`
+	w := performComparableRequest(handler.CreateSubscription, "tenant-1", http.MethodPost, body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Len(t, subscriptions.created, 1)
+	assert.Equal(t, "price_professional_yearly", *subscriptions.created[0].Items[0].Price)
+
+	// Starter isn't for sale
+	body = `{"email": "jane@example.com", "name": "Jane Doe", "plan": "starter", "interval": "year"}`
+	expectNoSavedResponse(mock)
+	w = performComparableRequest(handler.CreateSubscription, "tenant-1", http.MethodPost, body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "PLAN_NOT_AVAILABLE")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// countingPaymentIntents counts the payment intents created and the
+// idempotency keys they were created with
+type countingPaymentIntents struct {
+	keys []string
+}
+
+func (p *countingPaymentIntents) CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	p.keys = append(p.keys, stripe.StringValue(params.IdempotencyKey))
+	return &stripe.PaymentIntent{ID: fmt.Sprintf("pi_%d", len(p.keys)), Amount: stripe.Int64Value(params.Amount), ClientSecret: "pi_secret"}, nil
+}
+
+func TestCreatePaymentIntent_RetryReplaysResponse(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	paymentIntents := &countingPaymentIntents{}
+	handler.stripeService.SetPaymentIntentAPI(paymentIntents)
+
+	body := `{"amount": 2900, "currency": "usd"}`
+	key := services.IdempotencyKey("tenant-1", "create-payment-intent", "req-1", []byte(body))
+	post := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Header.Set("Idempotency-Key", "req-1")
+		c.Set("tenant_id", "tenant-1")
+		handler.CreatePaymentIntent(c)
+		return w
+	}
+
+	// The first request goes to Stripe and its response is saved...
+	mock.ExpectQuery(`SELECT response FROM idempotency_keys WHERE key = \$1`).
+		WithArgs(key, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"response"}))
+	mock.ExpectExec(`INSERT INTO idempotency_keys`).
+		WithArgs(key, "create-payment-intent", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	first := post()
+	require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	saved := first.Body.String()
+
+	// ...so the retry after a timeout gets the same payment intent, without
+	// a second charge
+	mock.ExpectQuery(`SELECT response FROM idempotency_keys WHERE key = \$1`).
+		WithArgs(key, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"response"}).AddRow([]byte(saved)))
+	retry := post()
+
+	require.Equal(t, http.StatusOK, retry.Code)
+	assert.JSONEq(t, saved, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, []string{key + "-payment-intent"}, paymentIntents.keys)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePaymentIntent_WithoutKeyDerivesOne(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	paymentIntents := &countingPaymentIntents{}
+	handler.stripeService.SetPaymentIntentAPI(paymentIntents)
+
+	// A double submit: the second arrives before the first's response is
+	// saved, and neither sends a key
+	body := `{"amount": 2900, "currency": "usd"}`
+	for i := 0; i < 2; i++ {
+		expectNoSavedResponse(mock)
+		expectResponseSaved(mock, "create-payment-intent")
+
+		w := performComparableRequest(handler.CreatePaymentIntent, "tenant-1", http.MethodPost, body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// Stripe gets the same key both times, so it creates one payment intent
+	require.Len(t, paymentIntents.keys, 2)
+	assert.NotEmpty(t, paymentIntents.keys[0])
+	assert.Equal(t, paymentIntents.keys[0], paymentIntents.keys[1])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePaymentIntent_AnonymousWithoutKeyIsNotRemembered(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	paymentIntents := &countingPaymentIntents{}
+	handler.stripeService.SetPaymentIntentAPI(paymentIntents)
+
+	body := `{"amount": 2900, "currency": "usd"}`
+	for i := 0; i < 2; i++ {
+		w := performComparableRequest(handler.CreatePaymentIntent, "", http.MethodPost, body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	assert.Equal(t, []string{"", ""}, paymentIntents.keys, "another caller's request isn't replayed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuthorizeReport_PurchaseIsUsedOnce(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	consume := `UPDATE report_purchases SET consumed_at = \$3\s+WHERE payment_intent_id = \(.*consumed_at IS NULL.*\) AND consumed_at IS NULL\s+RETURNING payment_intent_id`
+	body := `{"property_id": "prop-1"}`
+
+	// The paid report is generated...
+	expectTenantTier(mock, "tenant-1", services.TierStarter)
+	mock.ExpectQuery(consume).
+		WithArgs("tenant-1", "prop-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"payment_intent_id"}).AddRow("pi_report"))
+	w := performComparableRequest(handler.AuthorizeReport, "tenant-1", http.MethodPost, body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"payment_intent_id":"pi_report"`)
+
+	// ...but the same payment doesn't buy a second one
+	expectTenantTier(mock, "tenant-1", services.TierStarter)
+	mock.ExpectQuery(consume).
+		WithArgs("tenant-1", "prop-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"payment_intent_id"}))
+	w = performComparableRequest(handler.AuthorizeReport, "tenant-1", http.MethodPost, body)
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"REPORT_PAYMENT_REQUIRED"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuthorizeReport_IncludedInProfessional(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectTenantTier(mock, "tenant-1", services.TierProfessional)
+
+	w := performComparableRequest(handler.AuthorizeReport, "tenant-1", http.MethodPost, `{"property_id": "prop-1"}`)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"free_report":true`)
+	assert.NoError(t, mock.ExpectationsWereMet(), "no purchase is used")
+}
+
+func TestHandleWebhook_SubscriptionSetToCancelRecordsCancelAt(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	periodEnd := time.Unix(1793491200, 0).UTC()
+	expectStripeEvent(mock, "evt_subscription_canceling", "customer.subscription.updated", true)
+	expectSubscriptionSaved(mock, services.TierProfessional, "active", nil)
+	expectPendingCancellation(mock, &periodEnd)
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "customer_subscription_updated_canceling.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// cancelingStripeSubscriptions stands in for Stripe with one Professional
+// subscription, canceling and resuming it
+type cancelingStripeSubscriptions struct {
+	services.StripeSubscriptionAPI
+	sub      *stripe.Subscription
+	canceled bool
+}
+
+func (s *cancelingStripeSubscriptions) GetSubscription(id string) (*stripe.Subscription, error) {
+	return s.sub, nil
+}
+
+func (s *cancelingStripeSubscriptions) UpdateSubscription(id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	s.sub.CancelAtPeriodEnd = *params.CancelAtPeriodEnd
+	return s.sub, nil
+}
+
+func (s *cancelingStripeSubscriptions) CancelSubscription(id string, params *stripe.SubscriptionCancelParams) (*stripe.Subscription, error) {
+	s.canceled = true
+	s.sub.Status = stripe.SubscriptionStatusCanceled
+	return s.sub, nil
+}
+
+func newTestCancelingHandler(t *testing.T) (*StripeHandler, *cancelingStripeSubscriptions, sqlmock.Sqlmock) {
+	handler, mock := newTestStripeHandler(t)
+	subscriptions := &cancelingStripeSubscriptions{sub: &stripe.Subscription{
+		ID:               "sub_1",
+		Status:           stripe.SubscriptionStatusActive,
+		CurrentPeriodEnd: 1793491200,
+	}}
+	handler.stripeService.SetSubscriptionAPI(subscriptions)
+	return handler, subscriptions, mock
+}
+
+// expectTenantSubscription expects tenant-1's subscription sub_1 to be loaded
+func expectTenantSubscription(mock sqlmock.Sqlmock, cancelAt *time.Time) {
+	mock.ExpectQuery(subscriptionQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows(subscriptionColumns).
+			AddRow("sub_1", "professional", "active", time.Unix(1793491200, 0).UTC(), nil, cancelAt, nil, 1, 1))
+}
+
+func TestCancelSubscription_AtPeriodEndThenResume(t *testing.T) {
+	handler, subscriptions, mock := newTestCancelingHandler(t)
+	periodEnd := time.Unix(1793491200, 0).UTC()
+
+	// Canceling keeps the plan until the period's over...
+	expectTenantSubscription(mock, nil)
+	expectPendingCancellation(mock, &periodEnd)
+	w := performComparableRequest(handler.CancelSubscription, "tenant-1", http.MethodPost, `{}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.False(t, subscriptions.canceled)
+	assert.Contains(t, w.Body.String(), `"cancel_at_period_end":true`)
+
+	// ...shows when it ends...
+	expectTenantTier(mock, "tenant-1", services.TierProfessional)
+	mock.ExpectQuery(`SELECT arv_calculations FROM usage_records`).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}))
+	expectTenantSubscription(mock, &periodEnd)
+	w = performComparableRequest(handler.GetSubscriptionStatus, "tenant-1", http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data services.SubscriptionStatus `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, "Cancels on November 1", resp.Data.CancellationNotice)
+
+	// ...and can be taken back until then
+	expectTenantSubscription(mock, &periodEnd)
+	expectPendingCancellation(mock, nil)
+	w = performComparableRequest(handler.ResumeSubscription, "tenant-1", http.MethodPost, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.False(t, subscriptions.sub.CancelAtPeriodEnd)
+
+	// Resuming again has nothing to take back
+	expectTenantSubscription(mock, nil)
+	w = performComparableRequest(handler.ResumeSubscription, "tenant-1", http.MethodPost, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"SUBSCRIPTION_NOT_CANCELING"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCancelSubscription_Immediately(t *testing.T) {
+	handler, subscriptions, mock := newTestCancelingHandler(t)
+	expectTenantSubscription(mock, nil)
+	expectPendingCancellation(mock, nil)
+
+	w := performComparableRequest(handler.CancelSubscription, "tenant-1", http.MethodPost, `{"cancel_at_period_end": false}`)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, subscriptions.canceled)
+	assert.Contains(t, w.Body.String(), `"status":"canceled"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCancelSubscription_OnlyTheTenantsOwn(t *testing.T) {
+	handler, subscriptions, mock := newTestCancelingHandler(t)
+	expectTenantSubscription(mock, nil)
+
+	w := performComparableRequest(handler.CancelSubscription, "tenant-1", http.MethodPost, `{"subscription_id": "sub_someone_else"}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.False(t, subscriptions.canceled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// untouchedStripeSubscriptions fails the test if Stripe is asked about any
+// subscription
+type untouchedStripeSubscriptions struct {
+	services.StripeSubscriptionAPI
+	t *testing.T
+}
+
+func (s untouchedStripeSubscriptions) GetSubscription(id string) (*stripe.Subscription, error) {
+	s.t.Errorf("subscription %s was loaded", id)
+	return nil, errors.New("not the tenant's subscription")
+}
+
+func (s untouchedStripeSubscriptions) UpdateSubscription(id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	s.t.Errorf("subscription %s was updated", id)
+	return nil, errors.New("not the tenant's subscription")
+}
+
+func TestPlanChange_OnlyTheTenantsOwn(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	handler.stripeService.SetSubscriptionAPI(untouchedStripeSubscriptions{t: t})
+	body := `{"subscription_id": "sub_someone_else", "new_price_id": "price_enterprise_monthly"}`
+
+	expectTenantSubscription(mock, nil)
+	w := performComparableRequest(handler.UpdateSubscription, "tenant-1", http.MethodPost, body)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"SUBSCRIPTION_NOT_FOUND"`)
+
+	expectTenantSubscription(mock, nil)
+	w = performComparableRequest(handler.PreviewPlanChange, "tenant-1", http.MethodPost, body)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_FailedPaymentGracePeriodThenRecovery(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	handler.SetPaymentGracePeriod(3 * 24 * time.Hour)
+
+	// The card's declined: the subscription's past due and the grace period
+	// starts...
+	expectStripeEvent(mock, "evt_invoice_failed", "invoice.payment_failed", true)
+	mock.ExpectExec(`UPDATE subscriptions\s+SET status = \$2, grace_period_ends_at = COALESCE\(grace_period_ends_at, \$3\)`).
+		WithArgs("tenant-1", "past_due", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w := performWebhook(t, handler, "invoice_payment_failed.json", testWebhookSecret)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// ...during which the plan's kept, with a warning...
+	graceEnd := time.Now().Add(3 * 24 * time.Hour).UTC()
+	expectTenantTier(mock, "tenant-1", services.TierProfessional)
+	mock.ExpectQuery(`SELECT arv_calculations FROM usage_records`).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}))
+	mock.ExpectQuery(subscriptionQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows(subscriptionColumns).
+			AddRow("sub_1", "professional", "past_due", time.Unix(1793491200, 0).UTC(), nil, nil, graceEnd, 1, 1))
+	w = performComparableRequest(handler.GetSubscriptionStatus, "tenant-1", http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data services.SubscriptionStatus `json:"data"`
+	}
+	decodeJSON(t, w, &resp)
+	assert.Equal(t, services.TierProfessional, resp.Data.Tier)
+	assert.Equal(t, "past_due", resp.Data.Status)
+	assert.True(t, resp.Data.PaymentFailed)
+	require.NotNil(t, resp.Data.GracePeriodEnd)
+
+	// ...then without a payment the tenant drops to Starter...
+	mock.ExpectExec(`UPDATE tenants SET subscription_tier = \$2, updated_at = NOW\(\)\s+FROM subscriptions\s+WHERE subscriptions.tenant_id = tenants.id\s+AND subscriptions.grace_period_ends_at <= \$1`).
+		WithArgs(sqlmock.AnyArg(), "starter").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	downgraded, err := handler.billing.DowngradeLapsedSubscriptions()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), downgraded)
+
+	// ...until a retried payment goes through and restores the plan
+	expectStripeEvent(mock, "evt_invoice_paid", "invoice.payment_succeeded", true)
+	expectGracePeriodEnded(mock)
+	expectSubscriptionSaved(mock, services.TierProfessional, "active", nil)
+	mock.ExpectExec(`UPDATE usage_records SET arv_calculations = 0`).
+		WithArgs("tenant-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w = performWebhook(t, handler, "invoice_payment_succeeded.json", testWebhookSecret)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// recordingCheckout keeps the Checkout sessions created
+type recordingCheckout struct {
+	created []*stripe.CheckoutSessionParams
+}
+
+func (r *recordingCheckout) CreateCheckoutSession(params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	r.created = append(r.created, params)
+	id := fmt.Sprintf("cs_%d", len(r.created))
+	return &stripe.CheckoutSession{ID: id, URL: "https://checkout.stripe.com/c/pay/" + id}, nil
+}
+
+func newTestCheckoutHandler(t *testing.T) (*StripeHandler, *recordingCheckout, sqlmock.Sqlmock) {
+	handler, mock := newTestStripeHandler(t)
+	checkout := &recordingCheckout{}
+	handler.stripeService.SetCheckoutAPI(checkout)
+	handler.SetCheckoutURLs("https://app.example.com/welcome?session_id={CHECKOUT_SESSION_ID}", "https://app.example.com/pricing")
+	return handler, checkout, mock
+}
+
+func TestCreateCheckoutSession_Subscription(t *testing.T) {
+	handler, checkout, mock := newTestCheckoutHandler(t)
+	expectNoSavedResponse(mock)
+	mock.ExpectQuery(`SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_1"))
+	mock.ExpectExec(`UPDATE tenants SET trial_started_at = NOW\(\)`).
+		WithArgs("tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectResponseSaved(mock, "checkout-session")
+
+	body := `{"mode": "subscription", "email": "jane@example.com", "name": "Jane Doe", "plan": "professional", "interval": "year"}`
+	w := performComparableRequest(handler.CreateCheckoutSession, "tenant-1", http.MethodPost, body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"url":"https://checkout.stripe.com/c/pay/cs_1"`)
+	require.Len(t, checkout.created, 1)
+	params := checkout.created[0]
+	assert.Equal(t, "subscription", *params.Mode)
+	assert.Equal(t, "cus_1", *params.Customer)
+	assert.Equal(t, "price_professional_yearly", *params.LineItems[0].Price)
+	assert.Equal(t, "https://app.example.com/welcome?session_id={CHECKOUT_SESSION_ID}", *params.SuccessURL)
+	assert.Equal(t, "https://app.example.com/pricing", *params.CancelURL)
+	assert.Equal(t, map[string]string{"tenant_id": "tenant-1", "tier": "professional", "trial_days": "14"}, params.Metadata)
+	assert.Equal(t, "tenant-1", params.SubscriptionData.Metadata["tenant_id"])
+	assert.Equal(t, int64(14), *params.SubscriptionData.TrialPeriodDays)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateCheckoutSession_ReportPayment(t *testing.T) {
+	handler, checkout, mock := newTestCheckoutHandler(t)
+	expectNoSavedResponse(mock)
+	expectTenantTier(mock, "tenant-1", services.TierStarter)
+	mock.ExpectQuery(`SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_1"))
+	expectResponseSaved(mock, "checkout-session")
+
+	body := `{"mode": "payment", "email": "jane@example.com", "name": "Jane Doe", "property_id": "property-1"}`
+	w := performComparableRequest(handler.CreateCheckoutSession, "tenant-1", http.MethodPost, body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Len(t, checkout.created, 1)
+	params := checkout.created[0]
+	assert.Equal(t, "payment", *params.Mode)
+	assert.Equal(t, int64(999), *params.LineItems[0].PriceData.UnitAmount)
+	assert.Equal(t, map[string]string{"tenant_id": "tenant-1", "type": "report_generation", "property_id": "property-1"}, params.Metadata)
+	assert.Equal(t, "tenant-1", params.PaymentIntentData.Metadata["tenant_id"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// A report bought in payment mode needs the property it's for
+	body = `{"mode": "payment", "email": "jane@example.com", "name": "Jane Doe"}`
+	expectNoSavedResponse(mock)
+	w = performComparableRequest(handler.CreateCheckoutSession, "tenant-1", http.MethodPost, body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreateCheckoutSession_ReportIncludedInPlan(t *testing.T) {
+	handler, checkout, mock := newTestCheckoutHandler(t)
+	expectNoSavedResponse(mock)
+	expectTenantTier(mock, "tenant-1", services.TierEnterprise)
+
+	body := `{"mode": "payment", "email": "jane@example.com", "name": "Jane Doe", "property_id": "property-1"}`
+	w := performComparableRequest(handler.CreateCheckoutSession, "tenant-1", http.MethodPost, body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"free_report":true`)
+	assert.Empty(t, checkout.created)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectCheckoutCustomer expects a completed Checkout session's customer to
+// be stored for tenant-1
+func expectCheckoutCustomer(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`INSERT INTO stripe_customers .* ON CONFLICT DO NOTHING`).
+		WithArgs("tenant-1", "cus_1", "jane@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestHandleWebhook_CheckoutSubscriptionCompleted(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_checkout_subscription", "checkout.session.completed", true)
+	expectCheckoutCustomer(mock)
+	mock.ExpectQuery(`INSERT INTO subscriptions .* ON CONFLICT \(tenant_id\) DO UPDATE`).
+		WithArgs("tenant-1", "cus_1", "sub_1", "professional", "", (*time.Time)(nil), (*time.Time)(nil), int64(0)).
+		WillReturnRows(sqlmock.NewRows([]string{"tier"}).AddRow("professional"))
+	mock.ExpectExec(`UPDATE tenants SET subscription_tier = \$2`).
+		WithArgs("tenant-1", "professional").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "checkout_session_completed_subscription.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_CheckoutReportPaymentCompleted(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_checkout_report", "checkout.session.completed", true)
+	expectCheckoutCustomer(mock)
+	// The same purchase as its payment_intent.succeeded records
+	mock.ExpectExec(`INSERT INTO report_purchases .* ON CONFLICT \(payment_intent_id\) DO NOTHING`).
+		WithArgs("pi_2", "tenant-1", "property-1", "cus_1", int64(999)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "checkout_session_completed_payment.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_CheckoutExpiredReleasesTrial(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStripeEvent(mock, "evt_checkout_expired", "checkout.session.expired", true)
+	mock.ExpectExec(`UPDATE tenants SET trial_started_at = NULL`).
+		WithArgs("tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "checkout_session_expired.json", testWebhookSecret)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// taxedStripe is registered to collect tax and charges 8.25% on reports
+type taxedStripe struct {
+	addresses []*stripe.AddressParams
+}
+
+func (s *taxedStripe) ActiveRegistrations() (int, error) { return 1, nil }
+
+func (s *taxedStripe) CalculateTax(params *stripe.TaxCalculationParams) (*stripe.TaxCalculation, error) {
+	return &stripe.TaxCalculation{ID: "taxcalc_1", AmountTotal: 1081, TaxAmountExclusive: 82}, nil
+}
+
+func (s *taxedStripe) FindByEmail(email string) (*stripe.Customer, error) { return nil, nil }
+
+func (s *taxedStripe) Create(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return &stripe.Customer{ID: "cus_1"}, nil
+}
+
+func (s *taxedStripe) Update(id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	s.addresses = append(s.addresses, params.Address)
+	return &stripe.Customer{ID: id}, nil
+}
+
+func TestCreateReportPayment_TaxNeedsBillingAddress(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	taxed := &taxedStripe{}
+	handler.stripeService.SetTaxAPI(taxed)
+	handler.stripeService.SetPaymentIntentAPI(&countingPaymentIntents{})
+	handler.customers.SetAPI(taxed)
+	_, err := handler.EnableAutomaticTax()
+	require.NoError(t, err)
+
+	storedCustomer := func() {
+		mock.ExpectQuery(`SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = \$1`).
+			WithArgs("tenant-1").
+			WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_1"))
+	}
+	expectNoSavedResponse(mock)
+	storedCustomer()
+	mock.ExpectQuery(`SELECT billing_address FROM stripe_customers WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"billing_address"}).AddRow(nil))
+
+	body := `{"customer_email": "jane@example.com", "customer_name": "Jane Doe", "property_id": "property-1"}`
+	w := performComparableRequest(handler.CreateReportPayment, "tenant-1", http.MethodPost, body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "BILLING_ADDRESS_REQUIRED")
+
+	// Given once, it's saved in Stripe and for the tenant
+	expectNoSavedResponse(mock)
+	storedCustomer()
+	mock.ExpectExec(`UPDATE stripe_customers SET billing_address = \$2 WHERE tenant_id = \$1`).
+		WithArgs("tenant-1", `{"line1":"1 Main St","city":"Austin","state":"TX","postal_code":"78701","country":"US"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectResponseSaved(mock, "create-report-payment")
+
+	body = `{"customer_email": "jane@example.com", "customer_name": "Jane Doe", "property_id": "property-1",
+		"billing_address": {"line1": "1 Main St", "city": "Austin", "state": "TX", "postal_code": "78701", "country": "US"}}`
+	w = performComparableRequest(handler.CreateReportPayment, "tenant-1", http.MethodPost, body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"tax":82`)
+	assert.Contains(t, w.Body.String(), `"total":1081`)
+	require.Len(t, taxed.addresses, 1)
+	assert.Equal(t, "78701", *taxed.addresses[0].PostalCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// emailedStripeCustomers has one customer, tenant-2's, under
+// victim@example.com and records the emails looked up
+type emailedStripeCustomers struct {
+	lookedUp []string
+}
+
+func (s *emailedStripeCustomers) FindByEmail(email string) (*stripe.Customer, error) {
+	s.lookedUp = append(s.lookedUp, email)
+	if email == "victim@example.com" {
+		return &stripe.Customer{ID: "cus_victim", Metadata: map[string]string{"tenant_id": "tenant-2"}}, nil
+	}
+	return nil, nil
+}
+
+func (s *emailedStripeCustomers) Create(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return &stripe.Customer{ID: "cus_1", Email: *params.Email}, nil
+}
+
+func (s *emailedStripeCustomers) Update(id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return &stripe.Customer{ID: id}, nil
+}
+
+func TestCreateReportPayment_CustomerFoundByCallersOwnEmail(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	customers := &emailedStripeCustomers{}
+	handler.customers.SetAPI(customers)
+	handler.stripeService.SetPaymentIntentAPI(&countingPaymentIntents{})
+	expectNoSavedResponse(mock)
+	mock.ExpectQuery(`SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}))
+	mock.ExpectQuery(`INSERT INTO stripe_customers`).
+		WithArgs("tenant-1", "cus_1", "jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_1"))
+	expectResponseSaved(mock, "create-report-payment")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(
+		`{"customer_email": "victim@example.com", "customer_name": "Jane Doe", "property_id": "property-1"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("tenant_id", "tenant-1")
+	c.Set("user_email", "jane@example.com")
+	handler.CreateReportPayment(c)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"jane@example.com"}, customers.lookedUp)
+	assert.Contains(t, w.Body.String(), `"customer_id":"cus_1"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateReportPayment_SignedOutGetsNoTenantsCustomer(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	handler.customers.SetAPI(&emailedStripeCustomers{})
+	handler.stripeService.SetPaymentIntentAPI(&countingPaymentIntents{})
+
+	body := `{"customer_email": "victim@example.com", "customer_name": "Jane Doe", "property_id": "property-1"}`
+	w := performComparableRequest(handler.CreateReportPayment, "", http.MethodPost, body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "cus_victim")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHandleWebhook_UnsignedForLocalDevelopment(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	handler.webhookSecret = ""
+	require.NoError(t, handler.AllowUnsignedWebhooks())
+	expectStripeEvent(mock, "evt_report_paid", "payment_intent.succeeded", true)
+	mock.ExpectExec(`INSERT INTO report_purchases`).
+		WithArgs("pi_1", "tenant-1", "property-1", "cus_1", int64(999)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := performWebhook(t, handler, "payment_intent_succeeded.json", "whsec_made_up")
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAllowUnsignedWebhooks_RefusedInRelease(t *testing.T) {
+	handler, _ := newTestStripeHandler(t)
+	mode := gin.Mode()
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(mode)
+
+	assert.Error(t, handler.AllowUnsignedWebhooks())
+	assert.False(t, handler.unsignedWebhooks)
+}
+
+// expectStoredEvent expects the event in the fixture in testdata/stripe to
+// be loaded to replay, as HandleWebhook kept it
+func expectStoredEvent(t *testing.T, mock sqlmock.Sqlmock, fixture string) stripe.Event {
+	payload, err := os.ReadFile(filepath.Join("testdata", "stripe", fixture))
+	require.NoError(t, err)
+	var event stripe.Event
+	require.NoError(t, json.Unmarshal(payload, &event))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT type, payload FROM stripe_events WHERE id = \$1 FOR UPDATE`).
+		WithArgs(event.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "payload"}).AddRow(string(event.Type), []byte(event.Data.Raw)))
+	return event
+}
+
+func TestReplayWebhook_DispatchesEachEventType(t *testing.T) {
+	trialEnd := time.Unix(1793491200, 0).UTC()
+	tests := []struct {
+		fixture string
+		expect  func(mock sqlmock.Sqlmock)
+	}{
+		{"invoice_payment_succeeded.json", func(mock sqlmock.Sqlmock) {
+			expectGracePeriodEnded(mock)
+			expectSubscriptionSaved(mock, services.TierProfessional, "active", nil)
+			mock.ExpectExec(`UPDATE usage_records SET arv_calculations = 0`).
+				WithArgs("tenant-1", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"invoice_payment_failed.json", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`UPDATE subscriptions\s+SET status = \$2, grace_period_ends_at`).
+				WithArgs("tenant-1", "past_due", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"customer_subscription_created_trialing.json", func(mock sqlmock.Sqlmock) {
+			expectSubscriptionSaved(mock, services.TierProfessional, "trialing", &trialEnd)
+			expectPendingCancellation(mock, nil)
+		}},
+		{"customer_subscription_updated.json", func(mock sqlmock.Sqlmock) {
+			expectSubscriptionSaved(mock, services.TierEnterprise, "active", nil)
+			expectPendingCancellation(mock, nil)
+		}},
+		{"customer_subscription_deleted.json", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT tenant_id FROM stripe_customers WHERE stripe_customer_id = \$1`).
+				WithArgs("cus_1").
+				WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}).AddRow("tenant-1"))
+			expectSubscriptionSaved(mock, services.TierStarter, "canceled", nil)
+			expectPendingCancellation(mock, nil)
+		}},
+		{"customer_subscription_trial_will_end.json", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT email FROM users\s+WHERE tenant_id = \$1 AND role = 'admin'`).
+				WithArgs("tenant-1").
+				WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("owner@example.com"))
+		}},
+		{"payment_intent_succeeded.json", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`INSERT INTO report_purchases`).
+				WithArgs("pi_1", "tenant-1", "property-1", "cus_1", int64(999)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"checkout_session_completed_subscription.json", func(mock sqlmock.Sqlmock) {
+			expectCheckoutCustomer(mock)
+			mock.ExpectQuery(`INSERT INTO subscriptions .* ON CONFLICT \(tenant_id\) DO UPDATE`).
+				WithArgs("tenant-1", "cus_1", "sub_1", "professional", "", (*time.Time)(nil), (*time.Time)(nil), int64(0)).
+				WillReturnRows(sqlmock.NewRows([]string{"tier"}).AddRow("professional"))
+			mock.ExpectExec(`UPDATE tenants SET subscription_tier = \$2`).
+				WithArgs("tenant-1", "professional").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"checkout_session_completed_payment.json", func(mock sqlmock.Sqlmock) {
+			expectCheckoutCustomer(mock)
+			mock.ExpectExec(`INSERT INTO report_purchases`).
+				WithArgs("pi_2", "tenant-1", "property-1", "cus_1", int64(999)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"checkout_session_expired.json", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`UPDATE tenants SET trial_started_at = NULL`).
+				WithArgs("tenant-1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			handler, mock := newTestStripeHandler(t)
+			event := expectStoredEvent(t, mock, tt.fixture)
+			tt.expect(mock)
+			mock.ExpectExec(`UPDATE stripe_events SET processed_at = NOW\(\) WHERE id = \$1`).
+				WithArgs(event.ID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			w := performComparableRequest(handler.ReplayWebhook, "tenant-1", http.MethodPost, fmt.Sprintf(`{"event_id": %q}`, event.ID))
+
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.JSONEq(t, fmt.Sprintf(`{"success": true, "data": {"event_id": %q, "type": %q}}`, event.ID, event.Type), w.Body.String())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestReplayWebhook_UnknownEvent(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT type, payload FROM stripe_events`).
+		WithArgs("evt_never_sent").
+		WillReturnRows(sqlmock.NewRows([]string{"type", "payload"}))
+	mock.ExpectRollback()
+
+	w := performComparableRequest(handler.ReplayWebhook, "tenant-1", http.MethodPost, `{"event_id": "evt_never_sent"}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "EVENT_NOT_FOUND")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplayWebhook_FailureKeepsNothing(t *testing.T) {
+	handler, mock := newTestStripeHandler(t)
+	expectStoredEvent(t, mock, "payment_intent_succeeded.json")
+	mock.ExpectExec(`INSERT INTO report_purchases`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	w := performComparableRequest(handler.ReplayWebhook, "tenant-1", http.MethodPost, `{"event_id": "evt_report_paid"}`)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"arvfinder-backend/database"
+	"arvfinder-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserHandler handles the authenticated user's own account
+type UserHandler struct {
+	userService    *services.UserService
+	accountService *services.AccountService
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(authService *services.AuthService) *UserHandler {
+	db := database.GetDB()
+	return &UserHandler{
+		userService: services.NewUserService(db),
+		accountService: services.NewAccountService(db, authService,
+			services.NewTOTP2FAService(db, services.TOTPKeyFromEnv()),
+			services.NewSMS2FAServiceFromEnv(db, authService)),
+	}
+}
+
+// GetProfile returns the caller's profile
+func (h *UserHandler) GetProfile(c *gin.Context) {
+	profile, err := h.userService.GetProfile(c.GetString("user_id"))
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "User not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to load profile",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"user":    profile,
+	})
+}
+
+// UpdateProfile changes the caller's name and phone number
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	var req services.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+			"errors":  err.Error(),
+		})
+		return
+	}
+
+	profile, err := h.userService.UpdateProfile(c.GetString("user_id"), req)
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "User not found",
+		})
+		return
+	case errors.Is(err, services.ErrBlankName):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "First and last name cannot be blank",
+		})
+		return
+	case errors.Is(err, services.ErrInvalidPhoneNumber):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Enter a valid phone number, e.g. (303) 555-0147, or +44 20 7946 0958 outside North America",
+		})
+		return
+	case errors.Is(err, services.ErrPhoneLockedBy2FA):
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"message": "Disable SMS two-factor authentication before changing your phone number",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to update profile",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Profile updated",
+		"user":    profile,
+	})
+}
+
+// DeleteAccount deletes the caller's account after confirming their password
+// and, if enabled, a two-factor code
+func (h *UserHandler) DeleteAccount(c *gin.Context) {
+	var req services.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request data",
+			"errors":  err.Error(),
+		})
+		return
+	}
+
+	deletion, err := h.accountService.DeleteAccount(c.GetString("user_id"), req)
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "User not found",
+		})
+		return
+	case errors.Is(err, services.ErrIncorrectPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Password is incorrect",
+		})
+		return
+	case errors.Is(err, services.ErrTwoFactorRequired):
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Enter your two-factor code to delete your account",
+			"code":    "TWO_FACTOR_REQUIRED",
+		})
+		return
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Invalid two-factor code",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to delete account",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Your account has been deleted and will be permanently removed after the grace period",
+		"deletion": deletion,
+	})
+}
+
+// ExportData streams a JSON bundle of the caller's personal data
+func (h *UserHandler) ExportData(c *gin.Context) {
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Disposition", `attachment; filename="arvfinder-export.json"`)
+
+	err := h.accountService.ExportData(c.GetString("user_id"), c.Writer)
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "User not found",
+		})
+		return
+	}
+	if err != nil {
+		// Headers are already sent once streaming starts; all we can do is
+		// cut the response short so the client sees invalid JSON
+		c.Error(err)
+		c.Abort()
+	}
+}
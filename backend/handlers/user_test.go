@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUserHandler(t *testing.T) (*UserHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &UserHandler{userService: services.NewUserService(db)}, mock
+}
+
+func TestGetProfile_ScopedToCaller(t *testing.T) {
+	handler, mock := newTestUserHandler(t)
+
+	now := time.Now()
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "tenant_id", "email", "email_verified", "first_name", "last_name",
+			"phone_number", "phone_verified", "role", "two_factor_enabled", "two_factor_method",
+			"login_alert_email", "login_alert_sms", "last_login_at", "created_at", "updated_at",
+		}).AddRow("user-1", "tenant-1", "user@example.com", true, "Jane", "Doe", "", false, "user", false, "sms", true, false, nil, now, now))
+
+	w := performAuthenticated(handler.GetProfile, http.MethodGet, "", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"email":"user@example.com"`)
+	assert.NotContains(t, w.Body.String(), "password")
+	assert.NotContains(t, w.Body.String(), "failed_login_attempts")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProfile_NameTooLong(t *testing.T) {
+	handler, mock := newTestUserHandler(t)
+
+	body := `{"first_name": "` + strings.Repeat("a", 101) + `"}`
+	w := performAuthenticated(handler.UpdateProfile, http.MethodPut, body, nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
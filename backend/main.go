@@ -4,9 +4,13 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 	"arvfinder-backend/database"
 	"arvfinder-backend/handlers"
 	"arvfinder-backend/middleware"
+	"arvfinder-backend/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,15 +37,155 @@ func main() {
 		stripeSecretKey = "sk_test_51Rf9L600n2nnxa7pNjxkeVUzm8I54V9VZO1gg4P5iDckkGJzZegdbzyGMMHz7RzeocEequ2Ah1Wtb3Ru73Q8ES4m0041YIezPX"
 	}
 
+	// One AuthService for handlers and middleware, so the JWT secret and
+	// session cache are shared
+	argon2Params, err := services.Argon2ParamsFromEnv()
+	if err != nil {
+		log.Fatal("Invalid Argon2 configuration:", err)
+	}
+	authService := services.NewAuthServiceWithArgon2(db, services.JWTSecretFromEnv(), argon2Params)
+	jwtKeys, err := services.JWTKeysFromEnv()
+	if err != nil {
+		log.Fatal("Invalid JWT_KEYS:", err)
+	}
+	if jwtKeys != nil {
+		authService.SetJWTKeys(jwtKeys)
+	}
+	if ttl := os.Getenv("SESSION_CACHE_TTL"); ttl != "" {
+		cacheTTL, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal("Invalid SESSION_CACHE_TTL:", err)
+		}
+		authService.EnableSessionCache(cacheTTL)
+	}
+	if value := os.Getenv("MAX_SESSIONS_PER_USER"); value != "" {
+		maxSessions, err := strconv.Atoi(value)
+		if err != nil {
+			log.Fatal("Invalid MAX_SESSIONS_PER_USER:", err)
+		}
+		authService.SetMaxSessionsPerUser(maxSessions)
+	}
+	if code := os.Getenv("PHONE_DEFAULT_COUNTRY_CODE"); code != "" {
+		if err := services.SetDefaultPhoneCountryCode(code); err != nil {
+			log.Fatal("Invalid PHONE_DEFAULT_COUNTRY_CODE:", err)
+		}
+	}
+	// Alert users by email (and SMS if they opt in) about sign-ins from new devices
+	authService.EnableLoginAlerts(
+		services.NewLoginNotifier(services.NewEmailSenderFromEnv(), services.NewSMS2FAServiceFromEnv(db, authService)),
+		services.NewIPLocatorFromEnv(),
+	)
+	requireAuth := middleware.AuthMiddleware(authService)
+	// Public routes that use the caller's settings when signed in
+	optionalAuth := middleware.OptionalAuth(authService)
+	// Operations support must not perform while impersonating a customer
+	notImpersonating := middleware.BlockImpersonation(authService)
+
+	// Hard-delete accounts whose deletion grace period has ended. Purging
+	// doesn't check second factors, so no 2FA services are needed.
+	go func() {
+		accounts := services.NewAccountService(db, authService, nil, nil)
+		for range time.Tick(time.Hour) {
+			if _, err := accounts.PurgeDeletedAccounts(); err != nil {
+				log.Printf("Failed to purge deleted accounts: %v", err)
+			}
+		}
+	}()
+
+	// Drop tenants whose payment's still failing after the grace period to
+	// Starter's limits
+	go func() {
+		billing := services.NewBillingRepository(db, nil)
+		for range time.Tick(time.Hour) {
+			if _, err := billing.DowngradeLapsedSubscriptions(); err != nil {
+				log.Printf("Failed to downgrade lapsed subscriptions: %v", err)
+			}
+		}
+	}()
+
 	// Initialize handlers
 	arvHandler := handlers.NewArvHandler()
-	stripeHandler := handlers.NewStripeHandler(stripeSecretKey)
+	webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
+	if webhookSecret == "" {
+		log.Println("STRIPE_WEBHOOK_SECRET is not set; Stripe webhooks will be refused")
+	}
+	stripeHandler := handlers.NewStripeHandler(stripeSecretKey, webhookSecret)
+	// Local development only: take webhooks without checking their signatures
+	if value := os.Getenv("STRIPE_WEBHOOK_SKIP_SIGNATURE"); value != "" {
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatal("Invalid STRIPE_WEBHOOK_SKIP_SIGNATURE:", value)
+		}
+		if skip {
+			if err := stripeHandler.AllowUnsignedWebhooks(); err != nil {
+				log.Fatal("STRIPE_WEBHOOK_SKIP_SIGNATURE:", err)
+			}
+			log.Println("Stripe webhook signatures aren't checked (STRIPE_WEBHOOK_SKIP_SIGNATURE); never use this in production")
+		}
+	}
+	if value := os.Getenv("PAYMENT_GRACE_PERIOD_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			log.Fatal("Invalid PAYMENT_GRACE_PERIOD_DAYS:", value)
+		}
+		stripeHandler.SetPaymentGracePeriod(time.Duration(days) * 24 * time.Hour)
+	}
+	if value := os.Getenv("STRIPE_AUTOMATIC_TAX"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatal("Invalid STRIPE_AUTOMATIC_TAX:", value)
+		}
+		if enabled {
+			if collecting, err := stripeHandler.EnableAutomaticTax(); err != nil {
+				log.Println("Failed to enable Stripe Tax, tax won't be collected:", err)
+			} else if !collecting {
+				log.Println("No active Stripe Tax registrations; tax won't be collected")
+			}
+		}
+	}
+	stripeHandler.SetCheckoutURLs(os.Getenv("CHECKOUT_SUCCESS_URL"), os.Getenv("CHECKOUT_CANCEL_URL"))
+	for _, lookupKey := range services.PlanLookupKeys() {
+		if priceID := os.Getenv("STRIPE_PRICE_" + strings.ToUpper(lookupKey)); priceID != "" {
+			stripeHandler.SetPriceID(lookupKey, priceID)
+		}
+	}
+	if missing, err := stripeHandler.LoadPrices(); err != nil {
+		log.Println("Failed to load Stripe prices:", err)
+	} else if len(missing) > 0 {
+		log.Println("No Stripe price for:", strings.Join(missing, ", "), "- run setup-prices or set STRIPE_PRICE_* to offer them")
+	}
+	// Bill API calls beyond what a plan includes. Each day's are reported
+	// once it's over; a day Stripe fails to take is retried the next hour.
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := stripeHandler.APIUsage().ReportOverage(); err != nil {
+				log.Printf("Failed to report API usage: %v", err)
+			}
+		}
+	}()
 	propertyHandler := handlers.NewPropertyHandler()
-	authHandler := handlers.NewAuthHandler()
+	propertyCRUDHandler := handlers.NewPropertyCRUDHandler()
+	comparableHandler := handlers.NewComparableHandler()
+	photoHandler := handlers.NewPropertyPhotoHandler(services.NewPhotoStorageFromEnv())
+	portfolioHandler := handlers.NewPortfolioHandler()
+	tagHandler := handlers.NewTagHandler()
+	shareHandler := handlers.NewPropertyShareHandler()
+	offerHandler := handlers.NewOfferHandler()
+	rehabItemHandler := handlers.NewRehabItemHandler()
+	unitHandler := handlers.NewUnitHandler()
+	compSettingsHandler := handlers.NewCompAdjustmentSettingsHandler()
+	marketDefaultsHandler := handlers.NewMarketDefaultsHandler()
+	// Signed-in tenants' ARV calculations count towards their plan's monthly limit
+	arvUsageLimit := middleware.ArvUsageLimit(services.NewUsageRepository(db))
+	authHandler := handlers.NewAuthHandler(authService)
+	userHandler := handlers.NewUserHandler(authService)
+	adminHandler := handlers.NewAdminHandler(authService)
+	adminHandler.SetSeatManager(stripeHandler.Seats())
 
 	// Security middleware
 	r.Use(middleware.SecurityHeadersMiddleware())
 	r.Use(middleware.RateLimitMiddleware())
+	r.Use(middleware.AuditImpersonation(authService))
 
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
@@ -74,34 +218,132 @@ func main() {
 			auth.POST("/login", authHandler.Login)
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/verify-2fa", authHandler.Verify2FA)
-			auth.POST("/refresh", refreshTokenHandler) // TODO: Implement
-			auth.POST("/logout", logoutHandler)        // TODO: Implement
-			auth.POST("/forgot-password", forgotPasswordHandler) // TODO: Implement
-			auth.POST("/reset-password", resetPasswordHandler)   // TODO: Implement
+			auth.POST("/refresh", authHandler.RefreshToken)
+			auth.POST("/logout", requireAuth, authHandler.Logout)
+			auth.POST("/forgot-password", authHandler.ForgotPassword)
+			auth.POST("/reset-password", authHandler.ResetPassword)
+			auth.POST("/change-password", requireAuth, notImpersonating, authHandler.ChangePassword)
+			auth.GET("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/resend-verification", authHandler.ResendVerification)
+			auth.POST("/change-email", requireAuth, notImpersonating, authHandler.ChangeEmail)
+			auth.GET("/confirm-email-change", authHandler.ConfirmEmailChange)
+			auth.POST("/confirm-email-change", authHandler.ConfirmEmailChange)
+			auth.GET("/sessions", requireAuth, authHandler.ListSessions)
+			auth.DELETE("/sessions/:id", requireAuth, authHandler.RevokeSession)
+			auth.GET("/trusted-devices", requireAuth, authHandler.ListTrustedDevices)
+			auth.DELETE("/trusted-devices/:id", requireAuth, authHandler.RevokeTrustedDevice)
+			auth.POST("/2fa/totp/enroll", requireAuth, notImpersonating, authHandler.BeginTOTPEnrollment)
+			auth.POST("/2fa/totp/confirm", requireAuth, notImpersonating, authHandler.ConfirmTOTPEnrollment)
+		}
+
+		// User profile routes (protected)
+		users := api.Group("/users")
+		users.Use(requireAuth)
+		{
+			users.GET("/me", userHandler.GetProfile)
+			users.PUT("/me", userHandler.UpdateProfile)
+			users.POST("/me/delete", notImpersonating, userHandler.DeleteAccount)
+			users.GET("/me/export", userHandler.ExportData)
+		}
+
+		// Tenant user management (admins only) and support impersonation
+		admin := api.Group("/admin")
+		admin.Use(requireAuth)
+		{
+			requireAdmin := middleware.RequireRole("admin")
+			admin.GET("/users", requireAdmin, adminHandler.ListUsers)
+			admin.PUT("/users/:id/role", requireAdmin, notImpersonating, adminHandler.ChangeRole)
+			admin.POST("/users/:id/deactivate", requireAdmin, notImpersonating, adminHandler.DeactivateUser)
+			admin.POST("/impersonate/:userID", middleware.RequirePlatformOperator(), adminHandler.Impersonate)
+			admin.DELETE("/impersonate", adminHandler.EndImpersonation)
 		}
 
 		// Property routes (protected)
 		properties := api.Group("/properties")
-		properties.Use(middleware.AuthMiddleware())
+		properties.Use(requireAuth)
 		{
-			properties.GET("/", getPropertiesHandler)
-			properties.POST("/", createPropertyHandler)
-			properties.GET("/:id", getPropertyHandler)
-			properties.PUT("/:id", updatePropertyHandler)
-			properties.DELETE("/:id", deletePropertyHandler)
+			properties.GET("/", propertyCRUDHandler.ListProperties)
+			properties.POST("/", propertyCRUDHandler.CreateProperty)
+			properties.GET("/pipeline", propertyCRUDHandler.GetPipeline)
+			properties.POST("/compare", propertyCRUDHandler.CompareProperties)
+			properties.GET("/:id", propertyCRUDHandler.GetProperty)
+			properties.PUT("/:id", propertyCRUDHandler.UpdateProperty)
+			properties.DELETE("/:id", propertyCRUDHandler.DeleteProperty)
+			properties.POST("/:id/restore", middleware.RequireRole("admin"), propertyCRUDHandler.RestoreProperty)
+			properties.POST("/:id/archive", propertyCRUDHandler.ArchiveProperty)
+			properties.POST("/:id/unarchive", propertyCRUDHandler.UnarchiveProperty)
+			properties.POST("/:id/status", propertyCRUDHandler.ChangeStatus)
+			properties.POST("/:id/favorite", propertyCRUDHandler.FavoriteProperty)
+			properties.DELETE("/:id/favorite", propertyCRUDHandler.UnfavoriteProperty)
+			properties.GET("/:id/status-history", propertyCRUDHandler.GetStatusHistory)
+			properties.POST("/:id/arv-calculations", arvUsageLimit, propertyCRUDHandler.SaveCalculation)
+			properties.GET("/:id/comparables", comparableHandler.ListComparables)
+			properties.POST("/:id/comparables", comparableHandler.CreateComparable)
+			properties.PUT("/:id/comparables/:compID", comparableHandler.UpdateComparable)
+			properties.DELETE("/:id/comparables/:compID", comparableHandler.DeleteComparable)
+			properties.POST("/:id/estimate-arv", comparableHandler.EstimateARV)
+			properties.GET("/:id/photos", photoHandler.ListPhotos)
+			properties.POST("/:id/photos", photoHandler.UploadPhoto)
+			properties.PUT("/:id/photos/order", photoHandler.ReorderPhotos)
+			properties.DELETE("/:id/photos/:photoID", photoHandler.DeletePhoto)
+			properties.GET("/:id/photos/:photoID/url", photoHandler.GetPhotoURL)
+			properties.GET("/:id/photos/:photoID/file", photoHandler.DownloadPhoto)
+			properties.GET("/:id/tags", tagHandler.ListPropertyTags)
+			properties.PUT("/:id/tags/:tagID", tagHandler.TagProperty)
+			properties.DELETE("/:id/tags/:tagID", tagHandler.UntagProperty)
+			properties.POST("/:id/share", shareHandler.CreateShareLink)
+			properties.GET("/:id/share", shareHandler.ListShareLinks)
+			properties.DELETE("/:id/share/:linkID", shareHandler.RevokeShareLink)
+			properties.GET("/:id/offers", offerHandler.ListOffers)
+			properties.POST("/:id/offers", offerHandler.CreateOffer)
+			properties.GET("/:id/offers/:offerID", offerHandler.GetOffer)
+			properties.PUT("/:id/offers/:offerID", offerHandler.UpdateOffer)
+			properties.DELETE("/:id/offers/:offerID", offerHandler.DeleteOffer)
+			properties.POST("/:id/offers/:offerID/status", offerHandler.ChangeOfferStatus)
+			properties.GET("/:id/rehab-items", rehabItemHandler.ListRehabItems)
+			properties.POST("/:id/rehab-items", rehabItemHandler.CreateRehabItem)
+			properties.GET("/:id/rehab-items/rollup", rehabItemHandler.GetRehabRollup)
+			properties.PUT("/:id/rehab-items/:itemID", rehabItemHandler.UpdateRehabItem)
+			properties.DELETE("/:id/rehab-items/:itemID", rehabItemHandler.DeleteRehabItem)
+			properties.GET("/:id/units", unitHandler.ListUnits)
+			properties.POST("/:id/units", unitHandler.CreateUnit)
+			properties.PUT("/:id/units/:unitID", unitHandler.UpdateUnit)
+			properties.DELETE("/:id/units/:unitID", unitHandler.DeleteUnit)
 		}
 
+		// Tag routes (protected)
+		tags := api.Group("/tags")
+		tags.Use(requireAuth)
+		{
+			tags.GET("/", tagHandler.ListTags)
+			tags.POST("/", tagHandler.CreateTag)
+			tags.PUT("/:id", tagHandler.UpdateTag)
+			tags.DELETE("/:id", tagHandler.DeleteTag)
+		}
+
+		// Tenant settings (protected, admins change them)
+		settings := api.Group("/settings")
+		settings.Use(requireAuth)
+		{
+			settings.GET("/comp-adjustments", compSettingsHandler.GetCompAdjustments)
+			settings.PUT("/comp-adjustments", middleware.RequireRole("admin"), compSettingsHandler.UpdateCompAdjustments)
+			settings.GET("/market-defaults", marketDefaultsHandler.ListMarketDefaults)
+			settings.PUT("/market-defaults", middleware.RequireRole("admin"), marketDefaultsHandler.SetMarketDefaults)
+		}
+
+		// Shared property links (public, rate limited per IP)
+		api.GET("/shared/:token", shareHandler.ViewSharedProperty)
+
+		// Portfolio dashboard (protected)
+		api.GET("/portfolio/summary", requireAuth, portfolioHandler.GetSummary)
+		api.GET("/offers", requireAuth, offerHandler.ListOutstandingOffers)
+
 		// ARV calculation routes (protected - disabled for now)
 		arv := api.Group("/arv")
 		// arv.Use(authMiddleware()) // Disable auth for now to test functionality
-		{
-			arv.POST("/calculate", arvHandler.CalculateARV)
-			arv.POST("/70-rule", arvHandler.Calculate70Rule)
-			arv.POST("/roi", arvHandler.CalculateROI)
-			arv.POST("/cash-on-cash", arvHandler.CalculateCashOnCash)
-			arv.POST("/cap-rate", arvHandler.CalculateCapRate)
-			arv.POST("/estimate-from-comps", arvHandler.EstimateARVFromComps)
-		}
+		arv.Use(optionalAuth)
+		arvRoutes(arv, arvHandler, arvUsageLimit)
 
 		// Property estimate routes
 		api.POST("/property-estimate", propertyHandler.GetPropertyEstimate)
@@ -112,16 +354,33 @@ func main() {
 
 		// Stripe payment routes
 		payments := api.Group("/payments")
+		payments.Use(notImpersonating)
 		{
 			payments.GET("/plans", stripeHandler.GetSubscriptionPlans)
-			payments.POST("/create-subscription", stripeHandler.CreateSubscription)
-			payments.POST("/create-payment-intent", stripeHandler.CreatePaymentIntent)
-			payments.POST("/create-report-payment", stripeHandler.CreateReportPayment)
-			payments.POST("/cancel-subscription", stripeHandler.CancelSubscription)
-			payments.POST("/update-subscription", stripeHandler.UpdateSubscription)
-			payments.GET("/subscription-status", stripeHandler.GetSubscriptionStatus)
+			payments.POST("/create-subscription", requireAuth, stripeHandler.CreateSubscription)
+			payments.POST("/create-payment-intent", optionalAuth, stripeHandler.CreatePaymentIntent)
+			payments.POST("/create-report-payment", optionalAuth, stripeHandler.CreateReportPayment)
+			payments.POST("/checkout-session", requireAuth, stripeHandler.CreateCheckoutSession)
+			payments.POST("/cancel-subscription", requireAuth, stripeHandler.CancelSubscription)
+			payments.POST("/resume-subscription", requireAuth, stripeHandler.ResumeSubscription)
+			payments.POST("/update-subscription", requireAuth, stripeHandler.UpdateSubscription)
+			payments.POST("/preview-plan-change", requireAuth, stripeHandler.PreviewPlanChange)
+			payments.POST("/update-seats", requireAuth, middleware.RequireRole("admin"), stripeHandler.UpdateSeats)
+			payments.POST("/preview-seats", requireAuth, middleware.RequireRole("admin"), stripeHandler.PreviewSeats)
+			payments.GET("/subscription-status", requireAuth, stripeHandler.GetSubscriptionStatus)
+			payments.GET("/invoices", requireAuth, stripeHandler.GetInvoices)
 			payments.POST("/webhook", stripeHandler.HandleWebhook)
-			payments.POST("/setup-prices", stripeHandler.SetupPrices) // For initial setup only
+			// Internal: support staff apply a handled event again
+			payments.POST("/webhook/replay", requireAuth, middleware.RequirePlatformOperator(), stripeHandler.ReplayWebhook)
+			payments.POST("/setup-prices", requireAuth, middleware.RequirePlatformOperator(), stripeHandler.SetupPrices)
+			payments.POST("/refresh-prices", requireAuth, middleware.RequirePlatformOperator(), stripeHandler.RefreshPrices)
+		}
+
+		// Report generation checks the report's paid for first
+		reports := api.Group("/reports")
+		reports.Use(requireAuth, notImpersonating)
+		{
+			reports.POST("/authorize", stripeHandler.AuthorizeReport)
 		}
 	}
 
@@ -129,58 +388,29 @@ func main() {
 	log.Fatal(r.Run(":8080"))
 }
 
-// TODO: Implement these handlers
-func refreshTokenHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Refresh token endpoint - to be implemented"})
-}
-
-func logoutHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Logout endpoint - to be implemented"})
-}
-
-func forgotPasswordHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Forgot password endpoint - to be implemented"})
-}
-
-func resetPasswordHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Reset password endpoint - to be implemented"})
-}
-
-func getPropertiesHandler(c *gin.Context) {
-	// Sample data for now
-	properties := []map[string]interface{}{
-		{
-			"id":      "1",
-			"address": "123 Main St, Denver, CO",
-			"price":   180000,
-			"arv":     250000,
-			"roi":     15.8,
-		},
-		{
-			"id":      "2",
-			"address": "456 Oak Ave, Boulder, CO",
-			"price":   220000,
-			"arv":     300000,
-			"roi":     12.4,
-		},
-	}
-	c.JSON(http.StatusOK, properties)
-}
-
-func createPropertyHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Create property endpoint - to be implemented"})
+// arvRoutes registers the ARV calculators. Only a full ARV analysis counts
+// towards a plan's monthly ARV calculations, through usageLimit; the
+// helpers a page calls as it loads, like roi and cap-rate, don't.
+func arvRoutes(arv gin.IRoutes, h *handlers.ArvHandler, usageLimit gin.HandlerFunc) {
+	arv.POST("/calculate", usageLimit, h.CalculateARV)
+	arv.POST("/flip", h.CalculateFlip)
+	arv.POST("/amortization", h.CalculateAmortization)
+	arv.POST("/equity-chart", h.CalculateEquityChart)
+	arv.POST("/sensitivity", h.CalculateSensitivity)
+	arv.POST("/projection", h.ProjectCashFlows)
+	arv.POST("/wholesale", h.CalculateWholesale)
+	arv.POST("/max-offer", h.SolveMaxOffer)
+	arv.POST("/compare-strategies", h.CompareStrategies)
+	arv.POST("/partnership-split", h.CalculatePartnershipSplit)
+	arv.POST("/quick-screen", h.QuickScreen)
+	arv.POST("/creative-finance", h.CalculateCreativeFinance)
+	arv.POST("/mortgage-payment", h.CalculateMortgagePayment)
+	arv.POST("/refinance", h.AnalyzeRefinance)
+	arv.POST("/sale-proceeds", h.CalculateSaleProceeds)
+	arv.POST("/70-rule", h.Calculate70Rule)
+	arv.POST("/roi", h.CalculateROI)
+	arv.POST("/cash-on-cash", h.CalculateCashOnCash)
+	arv.POST("/cap-rate", h.CalculateCapRate)
+	arv.POST("/estimate-from-comps", h.EstimateARVFromComps)
+	arv.POST("/estimate-rent-from-comps", h.EstimateRentFromComps)
 }
-
-func getPropertyHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Get property endpoint - to be implemented"})
-}
-
-func updatePropertyHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Update property endpoint - to be implemented"})
-}
-
-func deletePropertyHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Delete property endpoint - to be implemented"})
-}
-
-
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"arvfinder-backend/handlers"
+	"arvfinder-backend/middleware"
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingUsage counts the ARV calculations recorded in usage_records. An
+// unexpected statement only fails the recording, which the limit lets
+// through, so the mock alone wouldn't notice one.
+type countingUsage struct {
+	*services.UsageRepository
+	recorded int
+}
+
+func (u *countingUsage) RecordArvCalculation(tenantID string) (services.ArvUsage, error) {
+	u.recorded++
+	return u.UsageRepository.RecordArvCalculation(tenantID)
+}
+
+func TestArvRoutes_OnlyCalculateCountsTowardsUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	r := gin.New()
+	arv := r.Group("/arv")
+	arv.Use(func(c *gin.Context) {
+		c.Set("tenant_id", "tenant-1")
+	})
+	usage := &countingUsage{UsageRepository: services.NewUsageRepository(db)}
+	arvRoutes(arv, handlers.NewArvHandler(), middleware.ArvUsageLimit(usage))
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A helper calculator leaves usage_records alone...
+	w := post("/arv/roi", `{"profit": 20000, "investment": 100000}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Zero(t, usage.recorded)
+
+	// ...while a full analysis is counted, and given back when it's rejected
+	mock.ExpectQuery(`SELECT subscription_tier FROM tenants WHERE id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow("starter"))
+	mock.ExpectQuery(`INSERT INTO usage_records`).
+		WithArgs("tenant-1", sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}).AddRow(3))
+	mock.ExpectExec(`UPDATE usage_records SET arv_calculations = arv_calculations - 1`).
+		WithArgs("tenant-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w = post("/arv/calculate", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 1, usage.recorded)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
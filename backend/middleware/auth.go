@@ -1,17 +1,26 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
-	"arvfinder-backend/database"
 	"arvfinder-backend/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware creates an authentication middleware
-func AuthMiddleware() gin.HandlerFunc {
+// TokenValidator validates access tokens. *services.AuthService satisfies it.
+type TokenValidator interface {
+	ValidateToken(tokenString string) (*services.JWTClaims, error)
+}
+
+// AuthMiddleware creates an authentication middleware. The validator is shared
+// across requests, so build it once at startup.
+func AuthMiddleware(validator TokenValidator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -37,13 +46,8 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		token := parts[1]
 
-		// Initialize auth service
-		db := database.GetDB()
-		jwtSecret := "your-super-secret-jwt-key-change-in-production" // Should come from env
-		authService := services.NewAuthService(db, jwtSecret)
-
 		// Validate the token
-		claims, err := authService.ValidateToken(token)
+		claims, err := validator.ValidateToken(token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
@@ -53,14 +57,179 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Set user information in context
-		c.Set("user_id", claims.UserID)
-		c.Set("tenant_id", claims.TenantID)
-		c.Set("user_email", claims.Email)
-		c.Set("user_role", claims.Role)
-		c.Set("session_id", claims.SessionID)
+		setClaims(c, claims)
+		c.Next()
+	}
+}
+
+// OptionalAuth sets the caller's user information like AuthMiddleware when
+// the request carries a valid bearer token, and lets it through anonymously
+// when it doesn't, for public routes that do more for signed-in users
+func OptionalAuth(validator TokenValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token, ok := bearerToken(c); ok {
+			if claims, err := validator.ValidateToken(token); err == nil {
+				setClaims(c, claims)
+			}
+		}
+		c.Next()
+	}
+}
+
+// setClaims sets the user information in claims in the context
+func setClaims(c *gin.Context, claims *services.JWTClaims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("tenant_id", claims.TenantID)
+	c.Set("user_email", claims.Email)
+	c.Set("user_role", claims.Role)
+	c.Set("session_id", claims.SessionID)
+	c.Set("token_jti", claims.ID)
+	if claims.Impersonation {
+		c.Set("impersonator_id", claims.ImpersonatorID)
+	}
+}
+
+// SecurityEventLogger records audit events. *services.AuthService satisfies it.
+type SecurityEventLogger interface {
+	LogSecurityEvent(userID, eventType, description, ipAddress, userAgent string, additionalData map[string]interface{}) error
+}
+
+// AuditImpersonation writes every request made with an impersonation token to
+// the security audit log, along with its outcome. Install it with r.Use so it
+// wraps the route's own AuthMiddleware.
+func AuditImpersonation(logger SecurityEventLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		impersonatorID := c.GetString("impersonator_id")
+		if impersonatorID == "" {
+			return
+		}
+		logger.LogSecurityEvent(c.GetString("user_id"), "impersonated_request", "Request made while impersonating", c.ClientIP(), c.Request.UserAgent(), map[string]interface{}{
+			"impersonator_id": impersonatorID,
+			"method":          c.Request.Method,
+			"path":            c.Request.URL.Path,
+			"status":          c.Writer.Status(),
+		})
+	}
+}
+
+// BlockImpersonation refuses the request if it is made with an impersonation
+// token, for operations support must never perform on a customer's behalf.
+// On routes without AuthMiddleware it checks the bearer token itself.
+func BlockImpersonation(validator TokenValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		impersonating := c.GetString("impersonator_id") != ""
+		if !impersonating {
+			if token, ok := bearerToken(c); ok {
+				if claims, err := validator.ValidateToken(token); err == nil && claims.Impersonation {
+					c.Set("user_id", claims.UserID)
+					c.Set("impersonator_id", claims.ImpersonatorID)
+					impersonating = true
+				}
+			}
+		}
+
+		if impersonating {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "This action isn't available while impersonating a user",
+				"code":    "IMPERSONATION_FORBIDDEN",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// bearerToken returns the token from a "Bearer" Authorization header
+func bearerToken(c *gin.Context) (string, bool) {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// RequireRole allows the request through only when AuthMiddleware has set
+// one of roles for the caller. It must run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("user_role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "You don't have permission to do this",
+		})
+		c.Abort()
+	}
+}
+
+// RequirePlatformOperator allows the request through only for ArvFinder's
+// own support staff. Their "support" role is one no tenant can grant: every
+// self-registered user is their new tenant's admin, and tenant admins only
+// hand out admin and user. Routes that act across tenants, or on the
+// platform's Stripe account, use it rather than RequireRole("admin"). It
+// must run after AuthMiddleware.
+func RequirePlatformOperator() gin.HandlerFunc {
+	return RequireRole("support")
+}
+
+// ArvUsageRecorder counts tenants' ARV calculations against their plans.
+// *services.UsageRepository satisfies it.
+type ArvUsageRecorder interface {
+	RecordArvCalculation(tenantID string) (services.ArvUsage, error)
+	ReleaseArvCalculation(tenantID string, month time.Time) error
+}
+
+// ArvUsageLimit counts each ARV calculation a signed-in tenant runs towards
+// its plan's monthly limit, and refuses the request with 402 Payment
+// Required once the limit's used up. A calculation the handler rejects isn't
+// counted, and neither are anonymous ones. It must run after AuthMiddleware
+// or OptionalAuth.
+func ArvUsageLimit(usage ArvUsageRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID := c.GetString("tenant_id")
+		if tenantID == "" {
+			c.Next()
+			return
+		}
+
+		recorded, err := usage.RecordArvCalculation(tenantID)
+		if errors.Is(err, services.ErrArvLimitReached) {
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"success":     false,
+				"message":     fmt.Sprintf("You've used all %d ARV calculations included in your plan this month", recorded.Limit),
+				"code":        "ARV_LIMIT_REACHED",
+				"tier":        recorded.Tier,
+				"arv_limit":   recorded.Limit,
+				"arv_used":    recorded.Used,
+				"upgrade_url": "/api/v1/payments/plans",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			// Don't turn calculations away because usage can't be counted
+			log.Printf("Failed to record ARV usage for tenant %s: %v", tenantID, err)
+			c.Next()
+			return
+		}
 
 		c.Next()
+
+		if c.Writer.Status() >= http.StatusBadRequest {
+			if err := usage.ReleaseArvCalculation(tenantID, recorded.Month); err != nil {
+				log.Printf("Failed to release ARV usage for tenant %s: %v", tenantID, err)
+			}
+		}
 	}
 }
 
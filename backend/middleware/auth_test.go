@@ -0,0 +1,402 @@
+package middleware
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"arvfinder-backend/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRouter(validator TokenValidator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/protected", AuthMiddleware(validator), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("user_id"))
+	})
+	return r
+}
+
+func request(r *gin.Engine, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddleware_RejectsRevokedSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService := services.NewAuthService(db, "test-secret")
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	tokens, err := authService.GenerateTokenPair(&services.User{ID: "user-1", TenantID: "tenant-1", Role: "user"}, "agent", "127.0.0.1", false)
+	require.NoError(t, err)
+
+	r := newTestRouter(authService)
+
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	w := request(r, tokens.AccessToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-1", w.Body.String())
+
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	w = request(r, tokens.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuthMiddleware_CachedSessionRevokedLocally(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	authService := services.NewAuthService(db, "test-secret")
+	authService.EnableSessionCache(time.Minute)
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	tokens, err := authService.GenerateTokenPair(&services.User{ID: "user-1", TenantID: "tenant-1", Role: "user"}, "agent", "127.0.0.1", false)
+	require.NoError(t, err)
+
+	r := newTestRouter(authService)
+
+	// The second request is served from the cache without a query
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	assert.Equal(t, http.StatusOK, request(r, tokens.AccessToken).Code)
+	assert.Equal(t, http.StatusOK, request(r, tokens.AccessToken).Code)
+
+	// Logging out everywhere evicts the cache, so the revocation is seen at once
+	mock.ExpectExec(`UPDATE user_sessions`).WithArgs("user-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, authService.RevokeAllUserSessions("user-1"))
+
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	assert.Equal(t, http.StatusUnauthorized, request(r, tokens.AccessToken).Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuthMiddleware_MissingHeader(t *testing.T) {
+	r := newTestRouter(nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/protected", nil))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// sessionDriver is a minimal database/sql driver for benchmarks that answers
+// every query with a single TRUE row and counts round trips. sqlmock's
+// per-expectation bookkeeping would dominate the timings.
+type sessionDriver struct {
+	queries *int64
+}
+
+type sessionConn struct{ queries *int64 }
+type sessionStmt struct{ queries *int64 }
+type sessionRows struct{ done bool }
+
+func (d sessionDriver) Open(string) (driver.Conn, error) { return sessionConn{d.queries}, nil }
+
+func (c sessionConn) Prepare(string) (driver.Stmt, error) { return sessionStmt{c.queries}, nil }
+func (c sessionConn) Close() error                        { return nil }
+func (c sessionConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (s sessionStmt) Close() error  { return nil }
+func (s sessionStmt) NumInput() int { return -1 }
+func (s sessionStmt) Exec([]driver.Value) (driver.Result, error) {
+	atomic.AddInt64(s.queries, 1)
+	return driver.RowsAffected(1), nil
+}
+func (s sessionStmt) Query([]driver.Value) (driver.Rows, error) {
+	atomic.AddInt64(s.queries, 1)
+	// Stand in for the network hop to Postgres
+	time.Sleep(100 * time.Microsecond)
+	return &sessionRows{}, nil
+}
+
+func (r *sessionRows) Columns() []string { return []string{"exists"} }
+func (r *sessionRows) Close() error      { return nil }
+func (r *sessionRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = true
+	return nil
+}
+
+var registerSessionDriver sync.Once
+var benchQueries int64
+
+func openBenchDB(b *testing.B) *sql.DB {
+	registerSessionDriver.Do(func() {
+		sql.Register("middleware-bench", sessionDriver{queries: &benchQueries})
+	})
+	db, err := sql.Open("middleware-bench", "")
+	require.NoError(b, err)
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+func benchmarkMiddleware(b *testing.B, newValidator func(db *sql.DB) TokenValidator) {
+	db := openBenchDB(b)
+	authService := services.NewAuthService(db, "test-secret")
+	tokens, err := authService.GenerateTokenPair(&services.User{ID: "user-1", TenantID: "tenant-1", Role: "user"}, "agent", "127.0.0.1", false)
+	require.NoError(b, err)
+
+	r := newTestRouter(newValidator(db))
+	atomic.StoreInt64(&benchQueries, 0)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := request(r, tokens.AccessToken); w.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&benchQueries))/float64(b.N), "queries/op")
+}
+
+// perRequestValidator reproduces the old behaviour of building an
+// AuthService inside every request
+type perRequestValidator struct{ db *sql.DB }
+
+func (v perRequestValidator) ValidateToken(token string) (*services.JWTClaims, error) {
+	return services.NewAuthService(v.db, "test-secret").ValidateToken(token)
+}
+
+func BenchmarkAuthMiddleware_PerRequestService(b *testing.B) {
+	benchmarkMiddleware(b, func(db *sql.DB) TokenValidator { return perRequestValidator{db} })
+}
+
+func BenchmarkAuthMiddleware_SharedService(b *testing.B) {
+	benchmarkMiddleware(b, func(db *sql.DB) TokenValidator { return services.NewAuthService(db, "test-secret") })
+}
+
+func BenchmarkAuthMiddleware_SharedServiceCached(b *testing.B) {
+	benchmarkMiddleware(b, func(db *sql.DB) TokenValidator {
+		authService := services.NewAuthService(db, "test-secret")
+		authService.EnableSessionCache(5 * time.Second)
+		return authService
+	})
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/admin", func(c *gin.Context) {
+		c.Set("user_role", c.Query("role"))
+	}, RequireRole("admin"), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for role, want := range map[string]int{"admin": http.StatusOK, "user": http.StatusForbidden, "": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin?role="+role, nil))
+		assert.Equal(t, want, w.Code, "role %q", role)
+	}
+}
+
+func TestRequirePlatformOperator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	setRole := func(c *gin.Context) {
+		c.Set("user_role", c.Query("role"))
+	}
+	ok := func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	}
+	paths := []string{"/payments/setup-prices", "/payments/refresh-prices"}
+	for _, path := range paths {
+		r.POST(path, setRole, RequirePlatformOperator(), ok)
+	}
+
+	// A tenant's admin, which anyone who signs up becomes, is refused
+	for _, path := range paths {
+		for role, want := range map[string]int{"support": http.StatusOK, "admin": http.StatusForbidden, "user": http.StatusForbidden} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path+"?role="+role, nil))
+			assert.Equal(t, want, w.Code, "%s as %q", path, role)
+		}
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	validator := claimsValidator{"good": {UserID: "user-1", TenantID: "tenant-1"}}
+	r.GET("/public", OptionalAuth(validator), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("tenant_id"))
+	})
+
+	for header, want := range map[string]string{"Bearer good": "tenant-1", "Bearer bad": "", "": ""} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/public", nil)
+		req.Header.Set("Authorization", header)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, "header %q", header)
+		assert.Equal(t, want, w.Body.String(), "header %q", header)
+	}
+}
+
+// claimsValidator accepts the tokens it knows, with the claims given
+type claimsValidator map[string]*services.JWTClaims
+
+func (v claimsValidator) ValidateToken(token string) (*services.JWTClaims, error) {
+	if claims, ok := v[token]; ok {
+		return claims, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+// recordingLogger captures security events
+type recordingLogger struct {
+	events []map[string]interface{}
+}
+
+func (l *recordingLogger) LogSecurityEvent(userID, eventType, description, ipAddress, userAgent string, data map[string]interface{}) error {
+	event := map[string]interface{}{"user_id": userID, "event_type": eventType}
+	for k, v := range data {
+		event[k] = v
+	}
+	l.events = append(l.events, event)
+	return nil
+}
+
+// newImpersonationRouter mirrors main.go: an authenticated read, an
+// authenticated destructive route and an unauthenticated payments route
+func newImpersonationRouter(validator TokenValidator, logger SecurityEventLogger) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(AuditImpersonation(logger))
+	requireAuth := AuthMiddleware(validator)
+	notImpersonating := BlockImpersonation(validator)
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+
+	r.GET("/users/me", requireAuth, ok)
+	r.POST("/users/me/delete", requireAuth, notImpersonating, ok)
+	r.POST("/payments/create-subscription", notImpersonating, ok)
+	return r
+}
+
+func send(r *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+var impersonationClaims = claimsValidator{
+	"customer":      {UserID: "user-1", Role: "user"},
+	"impersonation": {UserID: "user-1", Role: "user", Impersonation: true, ImpersonatorID: "support-1"},
+}
+
+func TestBlockImpersonation_DestructiveOperationsRefused(t *testing.T) {
+	r := newImpersonationRouter(impersonationClaims, &recordingLogger{})
+
+	for _, path := range []string{"/users/me/delete", "/payments/create-subscription"} {
+		w := send(r, http.MethodPost, path, "impersonation")
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+		assert.Contains(t, w.Body.String(), "IMPERSONATION_FORBIDDEN", path)
+
+		assert.Equal(t, http.StatusOK, send(r, http.MethodPost, path, "customer").Code, path)
+	}
+
+	// Reads still work, and payments stay open to unauthenticated callers
+	assert.Equal(t, http.StatusOK, send(r, http.MethodGet, "/users/me", "impersonation").Code)
+	assert.Equal(t, http.StatusOK, send(r, http.MethodPost, "/payments/create-subscription", "").Code)
+}
+
+func TestAuditImpersonation_LogsEveryImpersonatedRequest(t *testing.T) {
+	logger := &recordingLogger{}
+	r := newImpersonationRouter(impersonationClaims, logger)
+
+	send(r, http.MethodGet, "/users/me", "impersonation")
+	send(r, http.MethodPost, "/payments/create-subscription", "impersonation")
+	send(r, http.MethodGet, "/users/me", "customer")
+
+	require.Len(t, logger.events, 2, "the customer's own request isn't logged")
+	assert.Equal(t, map[string]interface{}{
+		"user_id": "user-1", "event_type": "impersonated_request", "impersonator_id": "support-1",
+		"method": http.MethodGet, "path": "/users/me", "status": http.StatusOK,
+	}, logger.events[0])
+	assert.Equal(t, "/payments/create-subscription", logger.events[1]["path"])
+	assert.Equal(t, http.StatusForbidden, logger.events[1]["status"])
+}
+
+// countingRecorder counts ARV calculations in memory against a limit
+type countingRecorder struct {
+	mu       sync.Mutex
+	used     map[string]int
+	limit    int
+	released int
+}
+
+func (r *countingRecorder) RecordArvCalculation(tenantID string) (services.ArvUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.used[tenantID] >= r.limit {
+		return services.ArvUsage{Tier: services.TierStarter, Used: r.used[tenantID], Limit: r.limit}, services.ErrArvLimitReached
+	}
+	r.used[tenantID]++
+	return services.ArvUsage{Tier: services.TierStarter, Used: r.used[tenantID], Limit: r.limit}, nil
+}
+
+func (r *countingRecorder) ReleaseArvCalculation(tenantID string, month time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.used[tenantID]--
+	r.released++
+	return nil
+}
+
+func TestArvUsageLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := &countingRecorder{used: map[string]int{"tenant-1": 9}, limit: 10}
+	r := gin.New()
+	r.POST("/arv", func(c *gin.Context) {
+		c.Set("tenant_id", c.Query("tenant"))
+	}, ArvUsageLimit(recorder), func(c *gin.Context) {
+		if c.Query("invalid") != "" {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	post := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/arv?"+query, nil))
+		return w
+	}
+
+	// A rejected request doesn't use up the tenth calculation
+	assert.Equal(t, http.StatusBadRequest, post("tenant=tenant-1&invalid=1").Code)
+	assert.Equal(t, 9, recorder.used["tenant-1"])
+	assert.Equal(t, 1, recorder.released)
+
+	assert.Equal(t, http.StatusOK, post("tenant=tenant-1").Code)
+	w := post("tenant=tenant-1")
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"ARV_LIMIT_REACHED"`)
+	assert.Contains(t, w.Body.String(), `"upgrade_url":"/api/v1/payments/plans"`)
+	assert.Equal(t, 10, recorder.used["tenant-1"])
+
+	// Anonymous calculations aren't counted
+	assert.Equal(t, http.StatusOK, post("").Code)
+	assert.NotContains(t, recorder.used, "")
+}
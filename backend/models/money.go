@@ -0,0 +1,129 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of currency in whole cents. Adding and subtracting
+// Money is exact, where float64 dollars pick up representation error, and
+// it reads and writes as a plain number with two decimals in JSON and from
+// DECIMAL columns.
+type Money int64
+
+// MoneyFromFloat rounds dollars to the nearest cent, halves away from zero.
+// It rounds the shortest decimal that reads back as dollars, so 1.005 is
+// $1.01 rather than the $1.00 its binary value would round to.
+func MoneyFromFloat(dollars float64) Money {
+	m, _ := ParseMoney(strconv.FormatFloat(dollars, 'f', -1, 64))
+	return m
+}
+
+// ParseMoney reads a decimal amount of dollars such as "-1234.565",
+// rounding to the nearest cent, halves away from zero
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		dollars, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+		}
+		s = strconv.FormatFloat(dollars, 'f', -1, 64)
+	}
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	fraction += "000"
+	for _, digit := range whole + fraction {
+		if digit < '0' || digit > '9' {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+
+	cents, err := strconv.ParseInt(whole+fraction[:2], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	if fraction[2] >= '5' {
+		cents++
+	}
+	if negative {
+		cents = -cents
+	}
+	return Money(cents), nil
+}
+
+// Cents returns m in cents
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// Float64 returns m in dollars
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// String formats m as dollars with two decimals, such as "-1234.50"
+func (m Money) String() string {
+	sign, cents := "", int64(m)
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// MarshalJSON writes m as a number with two decimals
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads a number, rounding it to the cent
+func (m *Money) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseMoney(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value writes m as a decimal for DECIMAL columns
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads a DECIMAL column, or a NULL as zero
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case []byte:
+		parsed, err := ParseMoney(string(v))
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case string:
+		parsed, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case float64:
+		*m = MoneyFromFloat(v)
+	case int64:
+		*m = Money(v * 100)
+	default:
+		return fmt.Errorf("can't scan %T into Money", src)
+	}
+	return nil
+}
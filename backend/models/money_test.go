@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	for input, want := range map[string]Money{
+		"1234.5":    123450,
+		"1234.565":  123457,
+		"-1234.565": -123457,
+		"0.004":     0,
+		".5":        50,
+		"+7":        700,
+		"1.5e3":     150000,
+	} {
+		got, err := ParseMoney(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{"", "-", "12a", "1.2.3"} {
+		_, err := ParseMoney(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMoneyFromFloat_RoundsTheDecimalNotItsBinaryValue(t *testing.T) {
+	// 1.005 is stored as 1.00499999..., which math.Round(x*100) takes down
+	assert.Equal(t, Money(101), MoneyFromFloat(1.005))
+	assert.Equal(t, Money(-101), MoneyFromFloat(-1.005))
+	assert.Equal(t, 0.3, (MoneyFromFloat(0.1) + MoneyFromFloat(0.2)).Float64())
+}
+
+func TestMoney_JSONIsANumberWithTwoDecimals(t *testing.T) {
+	data, err := json.Marshal(map[string]Money{"amount": -123450})
+	require.NoError(t, err)
+	assert.Equal(t, `{"amount":-1234.50}`, string(data))
+
+	var decoded struct {
+		Amount Money `json:"amount"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"amount": 99.999}`), &decoded))
+	assert.Equal(t, Money(10000), decoded.Amount)
+}
+
+func TestMoney_Scan(t *testing.T) {
+	var m Money
+	require.NoError(t, m.Scan([]byte("150000.00")))
+	assert.Equal(t, Money(15000000), m)
+	require.NoError(t, m.Scan(int64(3)))
+	assert.Equal(t, Money(300), m)
+	require.NoError(t, m.Scan(nil))
+	assert.Zero(t, m)
+	assert.Error(t, m.Scan(true))
+}
@@ -23,22 +23,45 @@ type Property struct {
 	YearBuilt    int       `json:"year_built" db:"year_built"`
 	PropertyType string    `json:"property_type" db:"property_type"`
 	Notes        string    `json:"notes" db:"notes"`
+	Status       string    `json:"status" db:"status"`
+	ArchivedAt   *time.Time `json:"archived_at,omitempty" db:"archived_at"`
+	IsFavorite   *bool     `json:"is_favorite,omitempty" db:"is_favorite"` // only set in property lists
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
 
+type PropertyStatusChange struct {
+	ID         string    `json:"id" db:"id"`
+	PropertyID string    `json:"property_id" db:"property_id"`
+	FromStatus string    `json:"from_status" db:"from_status"`
+	ToStatus   string    `json:"to_status" db:"to_status"`
+	Reason     string    `json:"reason,omitempty" db:"reason"`
+	ChangedBy  string    `json:"changed_by" db:"changed_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
 type ArvCalculation struct {
 	ID           string    `json:"id" db:"id"`
 	PropertyID   string    `json:"property_id" db:"property_id"`
 	TenantID     string    `json:"tenant_id" db:"tenant_id"`
-	PurchasePrice float64  `json:"purchase_price" db:"purchase_price"`
-	RehabCost    float64   `json:"rehab_cost" db:"rehab_cost"`
-	HoldingCosts float64   `json:"holding_costs" db:"holding_costs"`
-	ClosingCosts float64   `json:"closing_costs" db:"closing_costs"`
-	ARV          float64   `json:"arv" db:"arv"`
-	MaxOffer     float64   `json:"max_offer" db:"max_offer"`
-	PotentialProfit float64 `json:"potential_profit" db:"potential_profit"`
+	PurchasePrice Money    `json:"purchase_price" db:"purchase_price"`
+	RehabCost    Money     `json:"rehab_cost" db:"rehab_cost"`
+	HoldingCosts Money     `json:"holding_costs" db:"holding_costs"`
+	ClosingCosts Money     `json:"closing_costs" db:"closing_costs"`
+	ARV          Money     `json:"arv" db:"arv"`
+	MaxOffer     Money     `json:"max_offer" db:"max_offer"`
+	PotentialProfit Money   `json:"potential_profit" db:"potential_profit"`
 	ProfitMargin float64   `json:"profit_margin" db:"profit_margin"`
+	TotalInvestment  Money   `json:"total_investment" db:"total_investment"`
+	MonthlyCashFlow  Money   `json:"monthly_cash_flow" db:"monthly_cash_flow"`
+	CashOnCashReturn *float64 `json:"cash_on_cash_return" db:"cash_on_cash_return"` // null when infinite
+	IsInfiniteReturn bool     `json:"is_infinite_return" db:"is_infinite_return"`
+	CapRate          float64 `json:"cap_rate" db:"cap_rate"`
+	DSCR             float64 `json:"dscr" db:"dscr"`
+	RiskLevel        string  `json:"risk_level" db:"risk_level"`
+	RehabCostSource  string  `json:"rehab_cost_source" db:"rehab_cost_source"`
+	GrossRentMultiplier *float64 `json:"gross_rent_multiplier" db:"gross_rent_multiplier"`
+	RentToPriceRatio    *float64 `json:"rent_to_price_ratio" db:"rent_to_price_ratio"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -56,4 +79,85 @@ type Comparable struct {
 	Adjustments  float64   `json:"adjustments" db:"adjustments"`
 	AdjustedValue float64  `json:"adjusted_value" db:"adjusted_value"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-}
\ No newline at end of file
+}
+type PropertyPhoto struct {
+	ID          string    `json:"id" db:"id"`
+	PropertyID  string    `json:"property_id" db:"property_id"`
+	StorageKey  string    `json:"-" db:"storage_key"`
+	Filename    string    `json:"filename" db:"filename"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	SizeBytes   int64     `json:"size_bytes" db:"size_bytes"`
+	Position    int       `json:"position" db:"position"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type PropertyTag struct {
+	ID        string    `json:"id" db:"id"`
+	TenantID  string    `json:"tenant_id" db:"tenant_id"`
+	Name      string    `json:"name" db:"name"`
+	Color     string    `json:"color" db:"color"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type PropertyShareLink struct {
+	ID           string     `json:"id" db:"id"`
+	PropertyID   string     `json:"property_id" db:"property_id"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	ViewCount    int        `json:"view_count" db:"view_count"`
+	LastViewedAt *time.Time `json:"last_viewed_at,omitempty" db:"last_viewed_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+type PropertyOffer struct {
+	ID            string     `json:"id" db:"id"`
+	PropertyID    string     `json:"property_id" db:"property_id"`
+	Amount        float64    `json:"amount" db:"amount"`
+	CounterAmount *float64   `json:"counter_amount,omitempty" db:"counter_amount"`
+	OfferDate     time.Time  `json:"offer_date" db:"offer_date"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	FinancingType string     `json:"financing_type" db:"financing_type"`
+	Status        string     `json:"status" db:"status"`
+	Notes         string     `json:"notes,omitempty" db:"notes"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type PropertyOfferChange struct {
+	ID         string    `json:"id" db:"id"`
+	OfferID    string    `json:"offer_id" db:"offer_id"`
+	FromStatus string    `json:"from_status" db:"from_status"`
+	ToStatus   string    `json:"to_status" db:"to_status"`
+	Amount     *float64  `json:"amount,omitempty" db:"amount"`
+	Note       string    `json:"note,omitempty" db:"note"`
+	ChangedBy  string    `json:"changed_by" db:"changed_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+type RehabItem struct {
+	ID            string    `json:"id" db:"id"`
+	PropertyID    string    `json:"property_id" db:"property_id"`
+	Category      string    `json:"category" db:"category"`
+	Description   string    `json:"description" db:"description"`
+	EstimatedCost float64   `json:"estimated_cost" db:"estimated_cost"`
+	ActualCost    *float64  `json:"actual_cost,omitempty" db:"actual_cost"`
+	Status        string    `json:"status" db:"status"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type PropertyUnit struct {
+	ID           string     `json:"id" db:"id"`
+	PropertyID   string     `json:"property_id" db:"property_id"`
+	Label        string     `json:"label" db:"label"`
+	Bedrooms     int        `json:"bedrooms" db:"bedrooms"`
+	Bathrooms    float64    `json:"bathrooms" db:"bathrooms"`
+	SquareFeet   int        `json:"square_feet" db:"square_feet"`
+	CurrentRent  float64    `json:"current_rent" db:"current_rent"`
+	MarketRent   float64    `json:"market_rent" db:"market_rent"`
+	Occupied     bool       `json:"occupied" db:"occupied"`
+	LeaseEndDate *time.Time `json:"lease_end_date,omitempty" db:"lease_end_date"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
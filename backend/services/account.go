@@ -0,0 +1,404 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Account deletion errors
+var (
+	ErrTwoFactorRequired    = errors.New("two-factor code required")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+// DeleteAccountRequest confirms an account deletion. Code is required when
+// two-factor authentication is enabled; for SMS, submitting without a code
+// sends one.
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"omitempty,len=6"`
+}
+
+// AccountDeletion describes what DeleteAccount scheduled
+type AccountDeletion struct {
+	PurgeAfter time.Time `json:"purge_after"`
+	// TenantDeleted is true when the user was the tenant's last member, so
+	// the tenant and its properties are purged with them
+	TenantDeleted bool `json:"tenant_deleted"`
+}
+
+// AccountService handles account deletion and personal data export
+type AccountService struct {
+	db          *sql.DB
+	authService *AuthService
+	totp        *TOTP2FAService
+	sms         *SMS2FAService
+	gracePeriod time.Duration
+}
+
+// NewAccountService creates a new account service
+func NewAccountService(db *sql.DB, authService *AuthService, totp *TOTP2FAService, sms *SMS2FAService) *AccountService {
+	return &AccountService{
+		db:          db,
+		authService: authService,
+		totp:        totp,
+		sms:         sms,
+		gracePeriod: 30 * 24 * time.Hour, // Hard deletion after 30 days
+	}
+}
+
+// DeleteAccount soft-deletes the user after re-checking their password and
+// second factor. Their sessions are revoked and audit log rows anonymized
+// right away; the row itself is removed by PurgeDeletedAccounts once the
+// grace period is over. If other members remain in the tenant and the user
+// was its admin, the longest-standing member becomes admin.
+func (s *AccountService) DeleteAccount(userID string, req DeleteAccountRequest) (*AccountDeletion, error) {
+	var (
+		passwordHash, method, phone string
+		twoFactor, phoneVerified    bool
+	)
+	err := s.db.QueryRow(`
+		SELECT password_hash, two_factor_enabled, two_factor_method, COALESCE(phone_number, ''), phone_verified
+		FROM users WHERE id = $1 AND is_active = TRUE
+	`, userID).Scan(&passwordHash, &twoFactor, &method, &phone, &phoneVerified)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	if !s.authService.VerifyPassword(req.Password, passwordHash) {
+		return nil, ErrIncorrectPassword
+	}
+
+	// Same rule as login for whether 2FA is actually in force
+	if twoFactor && (method == "totp" || phoneVerified) {
+		if err := s.verifySecondFactor(userID, method, phone, req.Code); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var tenantID, role string
+	err = tx.QueryRow(`SELECT tenant_id, role FROM users WHERE id = $1`, userID).Scan(&tenantID, &role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tenant: %w", err)
+	}
+
+	// Lock the tenant so two members deleting at once can't both think the
+	// other one is staying
+	if _, err := tx.Exec(`SELECT id FROM tenants WHERE id = $1 FOR UPDATE`, tenantID); err != nil {
+		return nil, fmt.Errorf("failed to lock tenant: %w", err)
+	}
+
+	var successorID string
+	err = tx.QueryRow(`
+		SELECT id FROM users
+		WHERE tenant_id = $1 AND id <> $2 AND deleted_at IS NULL
+		ORDER BY created_at
+		LIMIT 1
+	`, tenantID, userID).Scan(&successorID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to find remaining members: %w", err)
+	}
+
+	if successorID != "" && role == "admin" {
+		_, err = tx.Exec(`UPDATE users SET role = 'admin', updated_at = NOW() WHERE id = $1`, successorID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transfer tenant ownership: %w", err)
+		}
+	}
+
+	purgeAfter := time.Now().Add(s.gracePeriod)
+	_, err = tx.Exec(`
+		UPDATE users
+		SET is_active = FALSE, deleted_at = NOW(), purge_after = $2, updated_at = NOW()
+		WHERE id = $1
+	`, userID, purgeAfter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE user_sessions SET revoked = TRUE WHERE user_id = $1 AND revoked = FALSE`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE security_audit_log
+		SET user_id = NULL, ip_address = NULL, user_agent = NULL, additional_data = NULL
+		WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize audit log: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit account deletion: %w", err)
+	}
+	s.authService.forgetSessions(userID)
+
+	return &AccountDeletion{PurgeAfter: purgeAfter, TenantDeleted: successorID == ""}, nil
+}
+
+// verifySecondFactor checks code against the user's 2FA method. An SMS user
+// submitting without a code is sent one.
+func (s *AccountService) verifySecondFactor(userID, method, phone, code string) error {
+	if method == "totp" {
+		if code == "" {
+			return ErrTwoFactorRequired
+		}
+		err := s.totp.VerifyCode(userID, code)
+		if errors.Is(err, ErrInvalidTOTPCode) || errors.Is(err, ErrTOTPCodeReused) {
+			return ErrInvalidTwoFactorCode
+		}
+		return err
+	}
+
+	if code == "" {
+		resp, err := s.sms.SendVerificationCode(&SMSVerificationRequest{
+			PhoneNumber: phone,
+			Purpose:     "account_deletion",
+			UserID:      userID,
+		})
+		if err != nil {
+			return err
+		}
+		if !resp.Success {
+			return fmt.Errorf("failed to send verification code: %s", resp.Message)
+		}
+		return ErrTwoFactorRequired
+	}
+
+	result, err := s.sms.VerifyCode(&VerifyCodeRequest{
+		PhoneNumber: phone,
+		Code:        code,
+		Purpose:     "account_deletion",
+		UserID:      userID,
+	})
+	if err != nil {
+		return err
+	}
+	if !result.Verified {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// PurgeDeletedAccounts hard-deletes users whose grace period is over. A user
+// who is the last one left in their tenant takes the tenant, its properties
+// and its calculations with them. It returns how many users were purged.
+func (s *AccountService) PurgeDeletedAccounts() (int, error) {
+	rows, err := s.db.Query(`SELECT id FROM users WHERE purge_after <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list deleted accounts: %w", err)
+	}
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, userID := range userIDs {
+		if err := s.purgeAccount(userID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeAccount removes one soft-deleted user, and their tenant if nobody else
+// belongs to it
+func (s *AccountService) purgeAccount(userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var tenantID string
+	err = tx.QueryRow(`SELECT tenant_id FROM users WHERE id = $1`, userID).Scan(&tenantID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load tenant: %w", err)
+	}
+
+	if _, err := tx.Exec(`SELECT id FROM tenants WHERE id = $1 FOR UPDATE`, tenantID); err != nil {
+		return fmt.Errorf("failed to lock tenant: %w", err)
+	}
+
+	var othersRemain bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE tenant_id = $1 AND id <> $2)
+	`, tenantID, userID).Scan(&othersRemain)
+	if err != nil {
+		return fmt.Errorf("failed to check remaining members: %w", err)
+	}
+
+	if othersRemain {
+		_, err = tx.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	} else {
+		// Cascades to the user, properties and calculations
+		_, err = tx.Exec(`DELETE FROM tenants WHERE id = $1`, tenantID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to purge account: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ExportedProperty is a saved property in a data export
+type ExportedProperty struct {
+	ID           string    `json:"id"`
+	Address      string    `json:"address"`
+	City         *string   `json:"city"`
+	State        *string   `json:"state"`
+	ZipCode      *string   `json:"zip_code"`
+	Price        *float64  `json:"price"`
+	ARV          *float64  `json:"arv"`
+	RehabCost    *float64  `json:"rehab_cost"`
+	Bedrooms     *int      `json:"bedrooms"`
+	Bathrooms    *float64  `json:"bathrooms"`
+	SquareFeet   *int      `json:"square_feet"`
+	PropertyType *string   `json:"property_type"`
+	Notes        *string   `json:"notes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ExportedArvCalculation is a saved ARV calculation in a data export
+type ExportedArvCalculation struct {
+	ID              string    `json:"id"`
+	PropertyID      *string   `json:"property_id"`
+	PurchasePrice   float64   `json:"purchase_price"`
+	RehabCost       *float64  `json:"rehab_cost"`
+	HoldingCosts    *float64  `json:"holding_costs"`
+	ClosingCosts    *float64  `json:"closing_costs"`
+	ARV             float64   `json:"arv"`
+	MaxOffer        *float64  `json:"max_offer"`
+	PotentialProfit *float64  `json:"potential_profit"`
+	ProfitMargin    *float64  `json:"profit_margin"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ExportData writes the user's profile, their tenant's properties and ARV
+// calculations to w as one JSON document. Rows are encoded as they are read
+// so large accounts don't have to fit in memory. The profile is loaded before
+// anything is written, so ErrUserNotFound can still be reported cleanly.
+func (s *AccountService) ExportData(userID string, w io.Writer) error {
+	profile, err := NewUserService(s.db).GetProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	write := func(text string) error {
+		_, err := io.WriteString(w, text)
+		return err
+	}
+
+	if err := write(`{"exported_at":`); err != nil {
+		return err
+	}
+	if err := encoder.Encode(time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := write(`,"profile":`); err != nil {
+		return err
+	}
+	if err := encoder.Encode(profile); err != nil {
+		return err
+	}
+
+	if err := write(`,"properties":[`); err != nil {
+		return err
+	}
+	rows, err := s.db.Query(`
+		SELECT id, address, city, state, zip_code, price, arv, rehab_cost,
+		       bedrooms, bathrooms, square_feet, property_type, notes, created_at
+		FROM properties WHERE tenant_id = $1
+		ORDER BY created_at
+	`, profile.TenantID)
+	if err != nil {
+		return fmt.Errorf("failed to export properties: %w", err)
+	}
+	err = encodeRows(rows, w, encoder, func(rows *sql.Rows) (interface{}, error) {
+		var p ExportedProperty
+		err := rows.Scan(&p.ID, &p.Address, &p.City, &p.State, &p.ZipCode, &p.Price, &p.ARV, &p.RehabCost,
+			&p.Bedrooms, &p.Bathrooms, &p.SquareFeet, &p.PropertyType, &p.Notes, &p.CreatedAt)
+		return p, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export properties: %w", err)
+	}
+
+	if err := write(`],"arv_calculations":[`); err != nil {
+		return err
+	}
+	rows, err = s.db.Query(`
+		SELECT id, property_id, purchase_price, rehab_cost, holding_costs, closing_costs, arv,
+		       max_offer, potential_profit, profit_margin, created_at
+		FROM arv_calculations WHERE tenant_id = $1
+		ORDER BY created_at
+	`, profile.TenantID)
+	if err != nil {
+		return fmt.Errorf("failed to export ARV calculations: %w", err)
+	}
+	err = encodeRows(rows, w, encoder, func(rows *sql.Rows) (interface{}, error) {
+		var a ExportedArvCalculation
+		err := rows.Scan(&a.ID, &a.PropertyID, &a.PurchasePrice, &a.RehabCost, &a.HoldingCosts, &a.ClosingCosts,
+			&a.ARV, &a.MaxOffer, &a.PotentialProfit, &a.ProfitMargin, &a.CreatedAt)
+		return a, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export ARV calculations: %w", err)
+	}
+
+	return write("]}\n")
+}
+
+// encodeRows writes each scanned row as a comma-separated JSON array element
+// and closes rows
+func encodeRows(rows *sql.Rows, w io.Writer, encoder *json.Encoder, scan func(*sql.Rows) (interface{}, error)) error {
+	defer rows.Close()
+
+	first := true
+	for rows.Next() {
+		value, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAccountService(t *testing.T) (*AccountService, sqlmock.Sqlmock) {
+	authService, mock := newTestAuthService(t)
+	totp := NewTOTP2FAService(authService.db, "test-key")
+	sms := NewSMS2FAService(authService.db, authService, nil)
+	return NewAccountService(authService.db, authService, totp, sms), mock
+}
+
+func expectDeletableUser(t *testing.T, service *AccountService, mock sqlmock.Sqlmock, twoFactor bool, method string) {
+	mock.ExpectQuery(`SELECT password_hash, two_factor_enabled`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"password_hash", "two_factor_enabled", "two_factor_method", "phone_number", "phone_verified",
+		}).AddRow(hashForTest(t, service.authService, "Sup3r$ecretPass"), twoFactor, method, "", false))
+}
+
+func TestDeleteAccount_LastMemberTakesTenant(t *testing.T) {
+	service, mock := newTestAccountService(t)
+
+	expectDeletableUser(t, service, mock, false, "sms")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT tenant_id, role FROM users`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "role"}).AddRow("tenant-1", "admin"))
+	mock.ExpectExec(`FROM tenants WHERE id = \$1 FOR UPDATE`).
+		WithArgs("tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT id FROM users\s+WHERE tenant_id = \$1 AND id <> \$2`).
+		WithArgs("tenant-1", "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec(`SET is_active = FALSE, deleted_at = NOW\(\)`).
+		WithArgs("user-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_sessions SET revoked = TRUE`).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE security_audit_log\s+SET user_id = NULL, ip_address = NULL`).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectCommit()
+
+	deletion, err := service.DeleteAccount("user-1", DeleteAccountRequest{Password: "Sup3r$ecretPass"})
+
+	require.NoError(t, err)
+	assert.True(t, deletion.TenantDeleted)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), deletion.PurgeAfter, time.Minute)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteAccount_OwnershipTransfersToRemainingMember(t *testing.T) {
+	service, mock := newTestAccountService(t)
+
+	expectDeletableUser(t, service, mock, false, "sms")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT tenant_id, role FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "role"}).AddRow("tenant-1", "admin"))
+	mock.ExpectExec(`FOR UPDATE`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT id FROM users\s+WHERE tenant_id = \$1 AND id <> \$2`).
+		WithArgs("tenant-1", "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-2"))
+	mock.ExpectExec(`UPDATE users SET role = 'admin'`).
+		WithArgs("user-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SET is_active = FALSE`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE security_audit_log`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	deletion, err := service.DeleteAccount("user-1", DeleteAccountRequest{Password: "Sup3r$ecretPass"})
+
+	require.NoError(t, err)
+	assert.False(t, deletion.TenantDeleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteAccount_WrongPassword(t *testing.T) {
+	service, mock := newTestAccountService(t)
+
+	expectDeletableUser(t, service, mock, false, "sms")
+
+	_, err := service.DeleteAccount("user-1", DeleteAccountRequest{Password: "wrong"})
+
+	assert.ErrorIs(t, err, ErrIncorrectPassword)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteAccount_RequiresTOTPCode(t *testing.T) {
+	service, mock := newTestAccountService(t)
+
+	expectDeletableUser(t, service, mock, true, "totp")
+
+	_, err := service.DeleteAccount("user-1", DeleteAccountRequest{Password: "Sup3r$ecretPass"})
+
+	assert.ErrorIs(t, err, ErrTwoFactorRequired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	service, mock := newTestAccountService(t)
+
+	mock.ExpectQuery(`SELECT id FROM users WHERE purge_after <= NOW\(\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-1").AddRow("user-2"))
+
+	// user-1 was alone in tenant-1: the tenant goes, cascading to properties
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT tenant_id FROM users`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}).AddRow("tenant-1"))
+	mock.ExpectExec(`FOR UPDATE`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs("tenant-1", "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`DELETE FROM tenants WHERE id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// user-2's tenant still has members, so only the user row goes
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT tenant_id FROM users`).
+		WithArgs("user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id"}).AddRow("tenant-2"))
+	mock.ExpectExec(`FOR UPDATE`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs("tenant-2", "user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec(`DELETE FROM users WHERE id = \$1`).
+		WithArgs("user-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	purged, err := service.PurgeDeletedAccounts()
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportData_StreamsProfilePropertiesAndCalculations(t *testing.T) {
+	service, mock := newTestAccountService(t)
+	now := time.Now()
+
+	expectProfile(mock, "", false, false)
+	mock.ExpectQuery(`FROM properties WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "address", "city", "state", "zip_code", "price", "arv", "rehab_cost",
+			"bedrooms", "bathrooms", "square_feet", "property_type", "notes", "created_at",
+		}).
+			AddRow("prop-1", "123 Main St", "Denver", "CO", "80202", 180000.0, 250000.0, 20000.0, 3, 2.0, 1500, "single_family", nil, now).
+			AddRow("prop-2", "456 Oak Ave", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, now))
+	mock.ExpectQuery(`FROM arv_calculations WHERE tenant_id = \$1`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "property_id", "purchase_price", "rehab_cost", "holding_costs", "closing_costs", "arv",
+			"max_offer", "potential_profit", "profit_margin", "created_at",
+		}).AddRow("calc-1", "prop-1", 180000.0, 20000.0, 0.0, 0.0, 250000.0, 155000.0, 50000.0, 25.0, now))
+
+	var buf bytes.Buffer
+	require.NoError(t, service.ExportData("user-1", &buf))
+
+	var export struct {
+		Profile         UserProfile              `json:"profile"`
+		Properties      []ExportedProperty       `json:"properties"`
+		ArvCalculations []ExportedArvCalculation `json:"arv_calculations"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &export), buf.String())
+	assert.Equal(t, "user@example.com", export.Profile.Email)
+	require.Len(t, export.Properties, 2)
+	assert.Equal(t, "Denver", *export.Properties[0].City)
+	assert.Nil(t, export.Properties[1].City)
+	require.Len(t, export.ArvCalculations, 1)
+	assert.Equal(t, 155000.0, *export.ArvCalculations[0].MaxOffer)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportData_UnknownUserWritesNothing(t *testing.T) {
+	service, mock := newTestAccountService(t)
+
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND is_active = TRUE`).
+		WillReturnRows(sqlmock.NewRows(profileColumns))
+
+	var buf bytes.Buffer
+	err := service.ExportData("user-1", &buf)
+
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	assert.Empty(t, buf.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// APIUsage is how many API calls a tenant has made in a month, and how many
+// of them go beyond what its plan includes
+type APIUsage struct {
+	Month    time.Time `json:"month"` // first of the month, UTC
+	Calls    int       `json:"calls"`
+	Included int       `json:"included"`
+	Overage  int       `json:"overage"` // billed per 1,000 as the days are reported
+}
+
+// APIUsageMeter counts tenants' API-key-authenticated requests a day at a
+// time, and reports the calls beyond what a plan includes to Stripe as
+// metered usage. Like ARV calculations, the included calls are a calendar
+// month's.
+type APIUsageMeter struct {
+	db     *sql.DB
+	stripe *StripeService
+	now    func() time.Time
+}
+
+// NewAPIUsageMeter creates a new API usage meter reporting through stripe
+func NewAPIUsageMeter(db *sql.DB, stripe *StripeService) *APIUsageMeter {
+	return &APIUsageMeter{db: db, stripe: stripe, now: time.Now}
+}
+
+// usageDay is the day t falls on, in UTC
+func usageDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// RecordRequest counts one API request tenantID made with an API key today
+func (m *APIUsageMeter) RecordRequest(tenantID string) error {
+	_, err := m.db.Exec(`
+		INSERT INTO api_usage (tenant_id, day, requests)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (tenant_id, day) DO UPDATE
+		SET requests = api_usage.requests + 1, updated_at = NOW()
+	`, tenantID, usageDay(m.now()))
+	if err != nil {
+		return fmt.Errorf("failed to record API usage: %w", err)
+	}
+	return nil
+}
+
+// Usage returns tenantID's API calls this month on tier's plan, or nil for
+// a plan without API access
+func (m *APIUsageMeter) Usage(tenantID string, tier SubscriptionTier) (*APIUsage, error) {
+	plan := subscriptionPlans()[tier]
+	if !apiMetered(plan) {
+		return nil, nil
+	}
+	usage := &APIUsage{Month: usageMonth(m.now()), Included: plan.APICallsIncluded}
+
+	err := m.db.QueryRow(`
+		SELECT COALESCE(SUM(requests), 0) FROM api_usage WHERE tenant_id = $1 AND day >= $2
+	`, tenantID, usage.Month).Scan(&usage.Calls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API usage: %w", err)
+	}
+	if usage.Calls > usage.Included {
+		usage.Overage = usage.Calls - usage.Included
+	}
+	return usage, nil
+}
+
+// apiUsageDay is a tenant's API calls on a day
+type apiUsageDay struct {
+	tenantID       string
+	subscriptionID string
+	tier           SubscriptionTier
+	day            time.Time
+	calls          int
+	reported       bool
+}
+
+// apiOverage works out how many of each day's calls go beyond what the plan
+// includes, counting the calls earlier in the month first. days are in
+// order, each tenant's together.
+func apiOverage(days []apiUsageDay) []int {
+	overage := make([]int, len(days))
+	calls := 0
+	for i, d := range days {
+		if i == 0 || d.tenantID != days[i-1].tenantID || !usageMonth(d.day).Equal(usageMonth(days[i-1].day)) {
+			calls = 0
+		}
+		included := subscriptionPlans()[d.tier].APICallsIncluded
+		before := calls - included
+		calls += d.calls
+		after := calls - included
+		overage[i] = max(after, 0) - max(before, 0)
+	}
+	return overage
+}
+
+// unreportedDays loads the days before today with calls not yet reported,
+// along with the reported days of the same months, which count first
+// toward the included calls
+func (m *APIUsageMeter) unreportedDays() ([]apiUsageDay, error) {
+	rows, err := m.db.Query(`
+		SELECT u.tenant_id, COALESCE(s.stripe_subscription_id, ''), t.subscription_tier, u.day, u.requests,
+			u.reported_at IS NOT NULL
+		FROM api_usage u
+		JOIN tenants t ON t.id = u.tenant_id
+		LEFT JOIN subscriptions s ON s.tenant_id = u.tenant_id
+		WHERE u.day < $1 AND (u.tenant_id, date_trunc('month', u.day)) IN (
+			SELECT tenant_id, date_trunc('month', day) FROM api_usage WHERE reported_at IS NULL AND day < $1
+		)
+		ORDER BY u.tenant_id, u.day
+	`, usageDay(m.now()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API usage: %w", err)
+	}
+	defer rows.Close()
+
+	var days []apiUsageDay
+	for rows.Next() {
+		var d apiUsageDay
+		if err := rows.Scan(&d.tenantID, &d.subscriptionID, &d.tier, &d.day, &d.calls, &d.reported); err != nil {
+			return nil, fmt.Errorf("failed to load API usage: %w", err)
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// ReportOverage reports to Stripe, once for each day that's over, the calls
+// that day beyond what the plan includes, and returns how many days it
+// reported. A day that fails to report is left for the next run to retry;
+// its Stripe idempotency key keeps a report that went through before the
+// day was marked from counting twice.
+func (m *APIUsageMeter) ReportOverage() (int, error) {
+	days, err := m.unreportedDays()
+	if err != nil {
+		return 0, err
+	}
+	overage := apiOverage(days)
+
+	reported, failed := 0, 0
+	var lastErr error
+	for i, d := range days {
+		if d.reported {
+			continue
+		}
+		billed := 0
+		// Days on a plan without API billing are marked with nothing billed
+		if overage[i] > 0 && d.subscriptionID != "" && apiMetered(subscriptionPlans()[d.tier]) {
+			key := fmt.Sprintf("api-usage-%s-%s", d.tenantID, d.day.Format("2006-01-02"))
+			if err := m.stripe.ReportAPIUsage(d.subscriptionID, int64(overage[i]), key); err != nil {
+				log.Printf("Failed to report API usage for tenant %s on %s: %v", d.tenantID, d.day.Format("2006-01-02"), err)
+				failed++
+				lastErr = err
+				continue
+			}
+			billed = overage[i]
+		}
+		if err := m.markReported(d, billed); err != nil {
+			return reported, err
+		}
+		reported++
+	}
+	if failed > 0 {
+		return reported, fmt.Errorf("failed to report %d days of API usage: %w", failed, lastErr)
+	}
+	return reported, nil
+}
+
+// markReported records d as reported with billed calls
+func (m *APIUsageMeter) markReported(d apiUsageDay, billed int) error {
+	_, err := m.db.Exec(`
+		UPDATE api_usage SET overage = $3, reported_at = $4, updated_at = NOW()
+		WHERE tenant_id = $1 AND day = $2
+	`, d.tenantID, d.day, billed, m.now())
+	if err != nil {
+		return fmt.Errorf("failed to mark API usage reported: %w", err)
+	}
+	return nil
+}
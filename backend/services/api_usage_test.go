@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+)
+
+// recordingUsageRecords keeps the usage reported, failing the first fail
+// reports
+type recordingUsageRecords struct {
+	fail    int
+	records []*stripe.UsageRecordParams
+}
+
+func (r *recordingUsageRecords) CreateUsageRecord(params *stripe.UsageRecordParams) (*stripe.UsageRecord, error) {
+	r.records = append(r.records, params)
+	if r.fail > 0 {
+		r.fail--
+		return nil, errors.New("stripe is unavailable")
+	}
+	return &stripe.UsageRecord{Quantity: *params.Quantity, SubscriptionItem: *params.SubscriptionItem}, nil
+}
+
+// meteringSubscriptions adds the items an update adds to the fake
+// subscription
+type meteringSubscriptions struct {
+	*fakeStripeSubscriptions
+}
+
+func (f meteringSubscriptions) UpdateSubscription(id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	for _, item := range params.Items {
+		if item.ID == nil {
+			f.sub.Items.Data = append(f.sub.Items.Data, &stripe.SubscriptionItem{ID: "si_metered", Price: meteredPrice(*item.Price)})
+		}
+	}
+	return f.fakeStripeSubscriptions.UpdateSubscription(id, params)
+}
+
+func meteredPrice(id string) *stripe.Price {
+	return &stripe.Price{
+		ID:        id,
+		Recurring: &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, UsageType: stripe.PriceRecurringUsageTypeMetered},
+	}
+}
+
+// newTestAPIUsageMeter meters API calls for tenants on the fake Enterprise
+// subscription, which already has its metered price, on October 16
+func newTestAPIUsageMeter(t *testing.T) (*APIUsageMeter, *recordingUsageRecords, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	s, api := newTestSubscriptionService()
+	onEnterprise(api)
+	api.sub.Items.Data = append(api.sub.Items.Data, &stripe.SubscriptionItem{ID: "si_metered", Price: meteredPrice("price_enterprise_api_calls_monthly")})
+	records := &recordingUsageRecords{}
+	s.SetUsageRecordAPI(records)
+
+	meter := NewAPIUsageMeter(db, s)
+	meter.now = s.now
+	return meter, records, mock
+}
+
+func october(day int) time.Time {
+	return time.Date(2026, time.October, day, 0, 0, 0, 0, time.UTC)
+}
+
+var apiUsageColumns = []string{"tenant_id", "stripe_subscription_id", "subscription_tier", "day", "requests", "reported"}
+
+func expectAPIUsageMarked(mock sqlmock.Sqlmock, tenantID string, day time.Time, billed int) {
+	mock.ExpectExec(`UPDATE api_usage SET overage = \$3, reported_at = \$4`).
+		WithArgs(tenantID, day, billed, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestAPIOverage(t *testing.T) {
+	days := []apiUsageDay{
+		// September's calls don't count toward October's
+		{tenantID: "tenant-1", tier: TierEnterprise, day: time.Date(2026, time.September, 30, 0, 0, 0, 0, time.UTC), calls: 12000},
+		{tenantID: "tenant-1", tier: TierEnterprise, day: october(1), calls: 6000},
+		{tenantID: "tenant-1", tier: TierEnterprise, day: october(2), calls: 5000},
+		{tenantID: "tenant-1", tier: TierEnterprise, day: october(3), calls: 1000},
+		// Nor another tenant's
+		{tenantID: "tenant-2", tier: TierEnterprise, day: october(1), calls: 10500},
+	}
+
+	assert.Equal(t, []int{2000, 0, 1000, 1000, 500}, apiOverage(days))
+}
+
+func TestReportOverage_RetriesOnlyFailedDays(t *testing.T) {
+	meter, records, mock := newTestAPIUsageMeter(t)
+	records.fail = 1
+
+	// 9,000 calls reported on the 13th, then 1,000 over on the 14th and 500
+	// on the 15th. The 14th fails to report.
+	mock.ExpectQuery(`FROM api_usage u`).
+		WithArgs(october(16)).
+		WillReturnRows(sqlmock.NewRows(apiUsageColumns).
+			AddRow("tenant-1", "sub_1", "enterprise", october(13), 9000, true).
+			AddRow("tenant-1", "sub_1", "enterprise", october(14), 2000, false).
+			AddRow("tenant-1", "sub_1", "enterprise", october(15), 500, false))
+	expectAPIUsageMarked(mock, "tenant-1", october(15), 500)
+
+	reported, err := meter.ReportOverage()
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, reported)
+	require.Len(t, records.records, 2)
+	assert.Equal(t, int64(1000), *records.records[0].Quantity)
+	assert.Equal(t, int64(500), *records.records[1].Quantity)
+	assert.Equal(t, "si_metered", *records.records[1].SubscriptionItem)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The next run reports the 14th again, with the same idempotency key so
+	// Stripe counts it once even if the first try went through, and leaves
+	// the 15th alone
+	mock.ExpectQuery(`FROM api_usage u`).
+		WithArgs(october(16)).
+		WillReturnRows(sqlmock.NewRows(apiUsageColumns).
+			AddRow("tenant-1", "sub_1", "enterprise", october(13), 9000, true).
+			AddRow("tenant-1", "sub_1", "enterprise", october(14), 2000, false).
+			AddRow("tenant-1", "sub_1", "enterprise", october(15), 500, true))
+	expectAPIUsageMarked(mock, "tenant-1", october(14), 1000)
+
+	reported, err = meter.ReportOverage()
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, reported)
+	require.Len(t, records.records, 3)
+	assert.Equal(t, int64(1000), *records.records[2].Quantity)
+	assert.Equal(t, "api-usage-tenant-1-2026-10-14", *records.records[2].IdempotencyKey)
+	assert.Equal(t, *records.records[0].IdempotencyKey, *records.records[2].IdempotencyKey)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReportOverage_MarksDaysWithNothingToBill(t *testing.T) {
+	meter, records, mock := newTestAPIUsageMeter(t)
+
+	mock.ExpectQuery(`FROM api_usage u`).
+		WithArgs(october(16)).
+		WillReturnRows(sqlmock.NewRows(apiUsageColumns).
+			AddRow("tenant-1", "sub_1", "enterprise", october(15), 400, false).
+			// Downgraded since, so it's not billed for API calls
+			AddRow("tenant-2", "", "starter", october(15), 20000, false))
+	expectAPIUsageMarked(mock, "tenant-1", october(15), 0)
+	expectAPIUsageMarked(mock, "tenant-2", october(15), 0)
+
+	reported, err := meter.ReportOverage()
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, reported)
+	assert.Empty(t, records.records)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIUsageMeter_Usage(t *testing.T) {
+	meter, _, mock := newTestAPIUsageMeter(t)
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(requests\), 0\) FROM api_usage WHERE tenant_id = \$1 AND day >= \$2`).
+		WithArgs("tenant-1", october(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(10250))
+
+	usage, err := meter.Usage("tenant-1", TierEnterprise)
+
+	require.NoError(t, err)
+	assert.Equal(t, &APIUsage{Month: october(1), Calls: 10250, Included: 10000, Overage: 250}, usage)
+
+	usage, err = meter.Usage("tenant-1", TierProfessional)
+	require.NoError(t, err)
+	assert.Nil(t, usage, "no API access")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReportAPIUsage_AddsMeteredPrice(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	onEnterprise(api)
+	s.subscriptions = meteringSubscriptions{api}
+	s.SetPriceID("enterprise_api_calls_monthly", "price_enterprise_api_calls_monthly")
+	records := &recordingUsageRecords{}
+	s.SetUsageRecordAPI(records)
+
+	require.NoError(t, s.ReportAPIUsage("sub_1", 1200, "key-1"))
+
+	require.NotNil(t, api.updated)
+	assert.Equal(t, "price_enterprise_api_calls_monthly", *api.updated.Items[0].Price)
+	assert.Equal(t, ProrationNone, *api.updated.ProrationBehavior)
+	require.Len(t, records.records, 1)
+	assert.Equal(t, "si_metered", *records.records[0].SubscriptionItem)
+	assert.Equal(t, int64(1200), *records.records[0].Quantity)
+	assert.Equal(t, "increment", *records.records[0].Action)
+
+	// The plan's still the first item, not the metered one
+	assert.Equal(t, "price_enterprise_monthly", planItem(api.sub).Price.ID)
+}
+
+func TestUpdateSubscription_DropsMeteredItem(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	onEnterprise(api)
+	api.sub.Items.Data = append([]*stripe.SubscriptionItem{{ID: "si_metered", Price: meteredPrice("price_enterprise_api_calls_monthly")}}, api.sub.Items.Data...)
+
+	_, err := s.UpdateSubscription("sub_1", "price_professional_monthly", ProrationCreateProrations)
+
+	require.NoError(t, err)
+	require.Len(t, api.updated.Items, 2)
+	assert.Equal(t, "si_1", *api.updated.Items[0].ID, "the plan's item changes, wherever it is")
+	assert.Equal(t, "si_metered", *api.updated.Items[1].ID)
+	assert.True(t, *api.updated.Items[1].Deleted)
+}
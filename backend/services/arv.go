@@ -1,9 +1,24 @@
 package services
 
 import (
+	"errors"
+	"fmt"
 	"math"
+
+	"arvfinder-backend/models"
+)
+
+// Errors for calculator inputs a return can't be worked out from
+var (
+	ErrInvestmentNotPositive    = errors.New("investment must be greater than zero")
+	ErrPropertyValueNotPositive = errors.New("property value must be greater than zero")
+	ErrInputNotFinite           = errors.New("inputs must be finite numbers")
 )
 
+// DefaultRulePercentage is the share of ARV, less rehab, a deal can cost
+// under the classic 70% rule
+const DefaultRulePercentage = 70.0
+
 // ArvRequest represents the input data for ARV calculation
 type ArvRequest struct {
 	PurchasePrice    float64 `json:"purchase_price" binding:"required,min=1"`
@@ -13,19 +28,66 @@ type ArvRequest struct {
 	ARV              float64 `json:"arv" binding:"required,min=1"`
 	FinancingCosts   float64 `json:"financing_costs" binding:"min=0"`
 	SellingCosts     float64 `json:"selling_costs" binding:"min=0"`
+	RulePercentage   float64 `json:"rule_percentage" binding:"omitempty,min=50,max=90"` // max offer rule, default 70%
 
 	// BRRRR-specific fields
 	MonthlyRent      float64 `json:"monthly_rent" binding:"min=0"`
+	RentSource       string  `json:"rent_source" binding:"omitempty,oneof=provided rent_comps"` // where MonthlyRent came from, default provided
 	VacancyRate      float64 `json:"vacancy_rate" binding:"min=0,max=100"` // percentage
+	CreditLossRate   float64 `json:"credit_loss_rate" binding:"min=0,max=100"` // percentage of rent owed but not collected
 	PropertyTaxes    float64 `json:"property_taxes" binding:"min=0"`       // annual
 	Insurance        float64 `json:"insurance" binding:"min=0"`            // annual
 	Maintenance      float64 `json:"maintenance" binding:"min=0"`          // annual
-	PropertyMgmt     float64 `json:"property_mgmt" binding:"min=0"`        // annual or percentage
+	PropertyMgmt     float64 `json:"property_mgmt" binding:"min=0"`        // deprecated: percentage up to 20, annual above
+	PropertyMgmtPercent float64 `json:"property_mgmt_percent" binding:"min=0,max=20"` // percentage of gross rent
+	PropertyMgmtAnnual  float64 `json:"property_mgmt_annual" binding:"min=0"`         // flat annual fee
 	CapEx            float64 `json:"capex" binding:"min=0"`                // annual capital expenditures
 	OtherExpenses    float64 `json:"other_expenses" binding:"min=0"`       // annual
 	RefinanceLTV     float64 `json:"refinance_ltv" binding:"min=0,max=100"` // percentage, default 75%
 	InterestRate     float64 `json:"interest_rate" binding:"min=0,max=30"`  // percentage for refinance loan
 	LoanTerm         int     `json:"loan_term" binding:"min=1,max=50"`      // years, default 30
+
+	// Refinance loan type, fixed by default. An interest-only loan pays just
+	// the interest for InterestOnlyYears, then amortizes over the rest of the
+	// term. An ARM pays InterestRate for ARMInitialYears, then resets once to
+	// ARMAdjustedRate, held within its caps of InterestRate.
+	LoanType              string  `json:"loan_type" binding:"omitempty,oneof=fixed interest_only arm"`
+	InterestOnlyYears     int     `json:"interest_only_years" binding:"min=0,max=49"`      // default 10
+	ARMInitialYears       int     `json:"arm_initial_years" binding:"min=0,max=49"`        // default 5
+	ARMAdjustedRate       float64 `json:"arm_adjusted_rate" binding:"min=0,max=30"`        // percentage, default the initial rate plus its cap
+	ARMFirstAdjustmentCap float64 `json:"arm_first_adjustment_cap" binding:"min=0,max=10"` // percentage points either way, default 2
+	ARMLifetimeCap        float64 `json:"arm_lifetime_cap" binding:"min=0,max=10"`         // percentage points over InterestRate, default 5
+
+	// Hard money acquisition loan, left out when the deal isn't funded with one
+	HardMoneyLTC          float64 `json:"hard_money_ltc" binding:"min=0,max=100"`   // percentage of purchase price plus rehab
+	HardMoneyPoints       float64 `json:"hard_money_points" binding:"min=0,max=10"` // percentage of the loan
+	HardMoneyRate         float64 `json:"hard_money_rate" binding:"min=0,max=30"`   // annual percentage
+	HardMoneyInterestOnly bool    `json:"hard_money_interest_only"`
+	HardMoneyTermMonths   int     `json:"hard_money_term_months" binding:"min=0,max=60"` // months until the refinance, default 6
+
+	// PITI inputs. When any is given the refinance payment includes mortgage
+	// insurance, and HOA dues are added to the expenses.
+	HOADues               float64 `json:"hoa_dues" binding:"min=0"`           // monthly
+	PMIRate               float64 `json:"pmi_rate" binding:"min=0,max=5"`     // annual percentage, default 0.5% over 80% LTV
+	FHA                   bool    `json:"fha"`
+
+	// Tax inputs, left out to skip the after-tax estimate
+	TaxRate               float64 `json:"tax_rate" binding:"min=0,max=60"`             // marginal percentage
+	LandValuePercent      float64 `json:"land_value_percent" binding:"min=0,max=100"` // of the depreciation basis, default 20%
+
+	// Closing costs are estimated from the buyer closing cost schedule for
+	// State when asked, unless ClosingCosts is given. State and ZipCode also
+	// pick the caller's market defaults.
+	EstimateClosingCosts  bool    `json:"estimate_closing_costs"`
+	State                 string  `json:"state" binding:"omitempty,len=2"`
+	ZipCode               string  `json:"zip_code" binding:"omitempty,max=10"`
+
+	// The caller's vacancy and credit loss defaults for the deal's market,
+	// used in place of the built-in ones
+	MarketDefaults        *MarketDefaults `json:"-"`
+
+	// Short-term rental inputs, left out to analyze a long-term rental only
+	STR                   *STRInputs `json:"str,omitempty"`
 }
 
 // ArvResult represents the calculated ARV analysis results
@@ -39,10 +101,25 @@ type ArvResult struct {
 	FinancingCosts   float64 `json:"financing_costs"`
 	SellingCosts     float64 `json:"selling_costs"`
 
+	// Inputs as used, after any defaults in Assumptions were applied
+	VacancyRate      float64 `json:"vacancy_rate"`
+	CreditLossRate   float64 `json:"credit_loss_rate"`
+	PropertyTaxes    float64 `json:"property_taxes"`
+	Insurance        float64 `json:"insurance"`
+	Maintenance      float64 `json:"maintenance"`
+	CapEx            float64 `json:"capex"`
+	RefinanceLTV     float64 `json:"refinance_ltv"`
+	InterestRate     float64 `json:"interest_rate"`
+	LoanTerm         int     `json:"loan_term"`
+	LoanType         string  `json:"loan_type"` // fixed, interest_only or arm
+
 	// Income Analysis
 	MonthlyRent      float64 `json:"monthly_rent"`
+	RentSource       string  `json:"rent_source"` // provided, rent_comps or one_percent_rule
 	AnnualGrossIncome float64 `json:"annual_gross_income"`
-	EffectiveIncome  float64 `json:"effective_income"` // after vacancy
+	VacancyLoss      float64 `json:"vacancy_loss"`     // annual
+	CreditLoss       float64 `json:"credit_loss"`      // annual
+	EffectiveIncome  float64 `json:"effective_income"` // after vacancy and credit loss
 
 	// Expense Analysis
 	AnnualExpenses   float64 `json:"annual_expenses"`
@@ -51,7 +128,8 @@ type ArvResult struct {
 	// NOI and Cash Flow
 	NOI              float64 `json:"noi"` // Net Operating Income
 
-	// 70% Rule calculations (kept for comparison)
+	// 70% Rule calculations (kept for comparison), at RulePercentage
+	RulePercentage   float64 `json:"rule_percentage"`
 	MaxOffer70       float64 `json:"max_offer_70"`
 	Is70RuleGood     bool    `json:"is_70_rule_good"`
 
@@ -67,33 +145,68 @@ type ArvResult struct {
 	RefinanceAmount  float64 `json:"refinance_amount"`  // 75% of ARV by default
 	CashRecovered    float64 `json:"cash_recovered"`    // cash pulled out in refinance
 	CashLeftIn       float64 `json:"cash_left_in"`      // remaining cash investment
-	MonthlyDebtService float64 `json:"monthly_debt_service"` // P&I payment
+	MonthlyDebtService float64 `json:"monthly_debt_service"` // P&I payment, plus any mortgage insurance
 	MonthlyCashFlow  float64 `json:"monthly_cash_flow"`
 	AnnualCashFlow   float64 `json:"annual_cash_flow"`
 
+	HardMoney        *HardMoneyBreakdown `json:"hard_money,omitempty"`
+	Mortgage         *MortgagePayment    `json:"mortgage,omitempty"` // full PITI, only with the PITI inputs
+	LoanReset        *LoanReset          `json:"loan_reset,omitempty"` // only for a loan whose payment changes
+
 	// Returns
-	CashOnCashReturn float64 `json:"cash_on_cash_return"` // based on cash left in deal
+	CashOnCashReturn *float64 `json:"cash_on_cash_return"` // based on cash left in deal, null when infinite
+	CashOnCashDisplay string  `json:"cash_on_cash_display"` // "∞" when infinite
 	CapRate          float64 `json:"cap_rate"`            // NOI / ARV
 	DSCR             float64 `json:"dscr"`                // Debt Service Coverage Ratio
 
+	// Break-even points, holding expenses and debt service constant. Each
+	// is clamped and flagged when no occupancy or rent would break even.
+	BreakEvenOccupancy           float64 `json:"break_even_occupancy"` // percentage at which NOI covers debt service
+	BreakEvenOccupancyImpossible bool    `json:"break_even_occupancy_impossible"`
+	BreakEvenRent                float64 `json:"break_even_rent"` // monthly, after vacancy
+	BreakEvenRentImpossible      bool    `json:"break_even_rent_impossible"`
+
 	// Analysis flags
 	IsInfiniteReturn bool    `json:"is_infinite_return"` // all cash recovered
 	IsCashFlowPositive bool  `json:"is_cash_flow_positive"`
 
 	// Risk assessment
+	DealScore        DealScore `json:"deal_score"`
 	RiskLevel        string   `json:"risk_level"`
-	Recommendations  []string `json:"recommendations"`
+	Recommendations  []Notice `json:"recommendations"`
+
+	// Quick screening metrics, only when the rent was entered rather than
+	// estimated
+	QuickScreen      *QuickScreen `json:"quick_screen,omitempty"`
+
+	// First-year after-tax estimate, only when a tax rate was given
+	Tax              *TaxAnalysis `json:"tax,omitempty"`
+
+	// The same property as a short-term rental, only when STR inputs were
+	// given
+	STR              *STRResult `json:"str,omitempty"`
+
+	// Itemized closing costs, only when they were estimated
+	ClosingCostEstimate *ClosingCostEstimate `json:"closing_cost_estimate,omitempty"`
 
 	// Validation warnings
-	Warnings         []string `json:"warnings"`
+	Warnings         []Notice `json:"warnings"`
+
+	// Values used in place of inputs the request left out
+	Assumptions      []Assumption `json:"assumptions"`
 }
 
 // ArvService handles ARV calculations and analysis
-type ArvService struct{}
+type ArvService struct {
+	closingCosts *ClosingCostSchedule
+}
 
-// NewArvService creates a new ARV service instance
+// NewArvService creates a new ARV service instance, estimating closing costs
+// from the schedule at CLOSING_COST_SCHEDULE or the bundled one
 func NewArvService() *ArvService {
-	return &ArvService{}
+	return &ArvService{
+		closingCosts: closingCostScheduleFromEnv(),
+	}
 }
 
 // CalculateARV performs comprehensive BRRRR analysis with income-based calculations
@@ -106,36 +219,61 @@ func (s *ArvService) CalculateARV(req ArvRequest) ArvResult {
 		ARV:            req.ARV,
 		FinancingCosts: req.FinancingCosts,
 		SellingCosts:   req.SellingCosts,
-		Warnings:       []string{},
+		Warnings:       []Notice{},
+		Assumptions:    []Assumption{},
+	}
+
+	if req.MonthlyRent > 0 {
+		screen := s.QuickScreen(QuickScreenRequest{
+			PurchasePrice: req.PurchasePrice,
+			RehabCost:     req.RehabCost,
+			MonthlyRent:   req.MonthlyRent,
+		})
+		result.QuickScreen = &screen
 	}
 
 	// Set defaults and validate inputs
 	s.setDefaultsAndValidate(&req, &result)
+	result.VacancyRate = req.VacancyRate
+	result.CreditLossRate = req.CreditLossRate
+	result.PropertyTaxes = req.PropertyTaxes
+	result.Insurance = req.Insurance
+	result.Maintenance = req.Maintenance
+	result.CapEx = req.CapEx
+	result.RefinanceLTV = req.RefinanceLTV
+	result.InterestRate = req.InterestRate
+	result.LoanTerm = req.LoanTerm
+	result.LoanType = req.LoanType
 
 	// Calculate total investment
 	result.TotalInvestment = req.PurchasePrice + req.RehabCost + req.HoldingCosts +
 		req.ClosingCosts + req.FinancingCosts
 
+	// A hard money loan's points and interest are part of the investment,
+	// and the refinance pays off the loan before any cash comes back out
+	result.HardMoney = s.calculateHardMoney(req, &result)
+	hardMoneyPayoff := 0.0
+	if result.HardMoney != nil {
+		result.TotalInvestment += result.HardMoney.TotalCost
+		hardMoneyPayoff = result.HardMoney.Payoff
+	}
+
 	// Income calculations
 	result.MonthlyRent = req.MonthlyRent
+	result.RentSource = req.RentSource
 	result.AnnualGrossIncome = req.MonthlyRent * 12
 
-	// Apply vacancy rate
-	vacancyLoss := result.AnnualGrossIncome * (req.VacancyRate / 100)
-	result.EffectiveIncome = result.AnnualGrossIncome - vacancyLoss
+	// Apply vacancy rate, then credit loss on the rent that's billed
+	result.VacancyLoss = result.AnnualGrossIncome * (req.VacancyRate / 100)
+	result.CreditLoss = (result.AnnualGrossIncome - result.VacancyLoss) * (req.CreditLossRate / 100)
+	result.EffectiveIncome = result.AnnualGrossIncome - result.VacancyLoss - result.CreditLoss
 
 	// Calculate total annual expenses
 	result.AnnualExpenses = req.PropertyTaxes + req.Insurance + req.Maintenance +
-		req.CapEx + req.OtherExpenses
-
-	// Add property management (could be flat fee or percentage)
-	if req.PropertyMgmt > 0 {
-		if req.PropertyMgmt < 1000 { // Assume percentage if less than $1000
-			result.AnnualExpenses += result.AnnualGrossIncome * (req.PropertyMgmt / 100)
-		} else { // Assume flat annual fee
-			result.AnnualExpenses += req.PropertyMgmt
-		}
-	}
+		req.CapEx + req.OtherExpenses + req.HOADues*12
+
+	// Add property management, a percentage of rent and/or a flat fee
+	result.AnnualExpenses += result.AnnualGrossIncome*(req.PropertyMgmtPercent/100) + req.PropertyMgmtAnnual
 
 	// Calculate expense ratio
 	if result.AnnualGrossIncome > 0 {
@@ -147,13 +285,38 @@ func (s *ArvService) CalculateARV(req ArvRequest) ArvResult {
 
 	// BRRRR refinance calculations
 	result.RefinanceAmount = req.ARV * (req.RefinanceLTV / 100)
-	result.CashRecovered = math.Min(result.RefinanceAmount, result.TotalInvestment)
-	result.CashLeftIn = math.Max(0, result.TotalInvestment - result.CashRecovered)
+	cashInvested := result.TotalInvestment - hardMoneyPayoff // what the hard money lender didn't fund
+	result.CashRecovered = math.Min(math.Max(0, result.RefinanceAmount-hardMoneyPayoff), cashInvested)
+	result.CashLeftIn = math.Max(0, result.TotalInvestment - result.RefinanceAmount)
 
-	// Calculate monthly debt service for refinance loan
+	// Calculate monthly debt service for refinance loan, at its payment
+	// until any reset
+	terms := req.refinanceTerms()
 	if req.InterestRate > 0 && req.LoanTerm > 0 {
-		result.MonthlyDebtService = s.calculateMonthlyPayment(
-			result.RefinanceAmount, req.InterestRate, req.LoanTerm)
+		result.MonthlyDebtService = terms.initialPayment(result.RefinanceAmount)
+	}
+
+	// Taxes, insurance and HOA dues are already expenses, so only the
+	// mortgage insurance is added to the debt service
+	if req.HOADues > 0 || req.PMIRate > 0 || req.FHA {
+		mortgage := s.CalculateMortgagePayment(MortgagePaymentRequest{
+			PropertyValue: req.ARV,
+			LoanAmount:    result.RefinanceAmount,
+			InterestRate:  req.InterestRate,
+			LoanTerm:      req.LoanTerm,
+			PropertyTaxes: req.PropertyTaxes,
+			Insurance:     req.Insurance,
+			HOADues:       req.HOADues,
+			PMIRate:       req.PMIRate,
+			FHA:           req.FHA,
+		})
+		if terms.resets() {
+			mortgage.PrincipalAndInterest = roundCents(terms.initialPayment(mortgage.FinancedLoanAmount))
+			mortgage.Total = roundCents(mortgage.PrincipalAndInterest + mortgage.PropertyTax + mortgage.Insurance +
+				mortgage.HOADues + mortgage.MortgageInsurance)
+		}
+		result.Mortgage = &mortgage
+		result.MonthlyDebtService = mortgage.PrincipalAndInterest + mortgage.MortgageInsurance
 	}
 
 	// Calculate monthly and annual cash flow
@@ -161,12 +324,8 @@ func (s *ArvService) CalculateARV(req ArvRequest) ArvResult {
 	result.AnnualCashFlow = result.MonthlyCashFlow * 12
 
 	// Calculate returns
-	if result.CashLeftIn > 0 {
-		result.CashOnCashReturn = (result.AnnualCashFlow / result.CashLeftIn) * 100
-	} else if result.AnnualCashFlow > 0 {
-		result.IsInfiniteReturn = true
-		result.CashOnCashReturn = 999.99 // Represent infinite return
-	}
+	result.CashOnCashReturn = cashOnCashReturn(result.AnnualCashFlow, result.CashLeftIn)
+	result.IsInfiniteReturn = result.CashOnCashReturn == nil
 
 	// Calculate cap rate
 	if req.ARV > 0 {
@@ -178,12 +337,18 @@ func (s *ArvService) CalculateARV(req ArvRequest) ArvResult {
 	if annualDebtService > 0 {
 		result.DSCR = result.NOI / annualDebtService
 	}
+	result.LoanReset = s.calculateLoanReset(terms, &result)
+
+	s.calculateBreakEven(req, &result)
+	result.STR = s.calculateSTR(req, &result)
+	result.Tax = s.calculateTax(req, &result)
 
 	// Set analysis flags
 	result.IsCashFlowPositive = result.MonthlyCashFlow > 0
 
 	// Keep 70% rule for comparison
-	result.MaxOffer70 = (req.ARV * 0.70) - req.RehabCost
+	result.RulePercentage = req.RulePercentage
+	result.MaxOffer70 = s.CalculateRule(req.ARV, req.RehabCost, req.RulePercentage)
 	result.Is70RuleGood = req.PurchasePrice <= result.MaxOffer70
 
 	// Keep legacy calculations for backward compatibility
@@ -198,40 +363,119 @@ func (s *ArvService) CalculateARV(req ArvRequest) ArvResult {
 	// Risk assessment and recommendations - use legacy for backward compatibility
 	result.RiskLevel = s.assessRisk(result.ProfitMargin, result.Is70RuleGood, req.ARV, req.PurchasePrice)
 	result.Recommendations = s.generateRecommendations(req, result.ProfitMargin, result.Is70RuleGood)
+	if recommendation, ok := breakEvenRecommendation(result); ok {
+		result.Recommendations = append(result.Recommendations, recommendation)
+	}
+	if recommendation, ok := strRecommendation(result); ok {
+		result.Recommendations = append(result.Recommendations, recommendation)
+	}
 
 	// Round all financial values
 	s.roundFinancialValues(&result)
+	result.CashOnCashDisplay = cashOnCashDisplay(result)
+
+	result.DealScore = ScoreDeal(result, DefaultDealScoreWeights)
 
 	return result
 }
 
+// maxComfortableBreakEvenOccupancy is the break-even occupancy above which a
+// deal has little room for vacancy
+const maxComfortableBreakEvenOccupancy = 85.0
+
+// calculateBreakEven works out the occupancy and the monthly rent at which
+// a deal's income just covers its expenses and debt service
+func (s *ArvService) calculateBreakEven(req ArvRequest, result *ArvResult) {
+	outgoings := result.AnnualExpenses + result.MonthlyDebtService*12
+
+	// Only the share of billed rent that's collected counts towards it
+	collected := 1 - req.CreditLossRate/100
+	if result.AnnualGrossIncome > 0 && collected > 0 {
+		result.BreakEvenOccupancy = outgoings / (result.AnnualGrossIncome * collected) * 100
+	} else {
+		result.BreakEvenOccupancy = 100
+		result.BreakEvenOccupancyImpossible = true
+	}
+	if result.BreakEvenOccupancy > 100 {
+		result.BreakEvenOccupancy = 100
+		result.BreakEvenOccupancyImpossible = true
+	}
+	result.BreakEvenOccupancy = math.Max(0, result.BreakEvenOccupancy)
+
+	occupancy := (1 - req.VacancyRate/100) * collected
+	if occupancy > 0 {
+		result.BreakEvenRent = math.Max(0, outgoings/12/occupancy)
+	} else {
+		result.BreakEvenRentImpossible = true
+	}
+}
+
+// breakEvenRecommendation warns about a deal that needs high occupancy to
+// cover its debt, and reports whether it does
+func breakEvenRecommendation(result ArvResult) (Notice, bool) {
+	switch {
+	case result.BreakEvenOccupancyImpossible:
+		return Notice{
+			Code: RecBreakEvenImpossible, Severity: SeverityCritical,
+			Message: "CRITICAL: Expenses and debt service exceed gross rent - no occupancy breaks even",
+		}, true
+	case result.BreakEvenOccupancy > maxComfortableBreakEvenOccupancy:
+		return Notice{
+			Code: RecBreakEvenOccupancyHigh, Severity: SeverityWarning,
+			Message: fmt.Sprintf("Break-even occupancy of %.1f%% leaves little room for vacancy - lenders typically look for 85%% or less", result.BreakEvenOccupancy),
+		}, true
+	}
+	return Notice{}, false
+}
+
 // Calculate70Rule specifically calculates the 70% rule
 func (s *ArvService) Calculate70Rule(arv, rehabCost float64) float64 {
-	return (arv * 0.70) - rehabCost
+	return s.CalculateRule(arv, rehabCost, DefaultRulePercentage)
 }
 
-// CalculateROI calculates return on investment
-func (s *ArvService) CalculateROI(profit, investment float64) float64 {
-	if investment <= 0 {
-		return 0
-	}
-	return (profit / investment) * 100
+// CalculateRule calculates the max offer under a rule percentage other than
+// 70%, such as the 75-80% common in hot markets
+func (s *ArvService) CalculateRule(arv, rehabCost, rulePercentage float64) float64 {
+	return (arv * rulePercentage / 100) - rehabCost
 }
 
-// CalculateCashOnCashReturn calculates cash-on-cash return for rental properties
-func (s *ArvService) CalculateCashOnCashReturn(annualCashFlow, totalCashInvested float64) float64 {
-	if totalCashInvested <= 0 {
-		return 0
+// rulePercentage returns the rule percentage a request asked for, or the
+// default when it didn't ask
+func rulePercentage(requested float64) float64 {
+	if requested == 0 {
+		return DefaultRulePercentage
 	}
-	return (annualCashFlow / totalCashInvested) * 100
+	return requested
 }
 
-// CalculateCapRate calculates capitalization rate
-func (s *ArvService) CalculateCapRate(netOperatingIncome, propertyValue float64) float64 {
-	if propertyValue <= 0 {
-		return 0
+// CalculateROI calculates return on investment. It returns an error, and
+// 0, when there's no positive investment to return on.
+func (s *ArvService) CalculateROI(profit, investment float64) (float64, error) {
+	return percentageOf(profit, investment, ErrInvestmentNotPositive)
+}
+
+// CalculateCashOnCashReturn calculates cash-on-cash return for rental
+// properties. It returns an error, and 0, when no cash is invested.
+func (s *ArvService) CalculateCashOnCashReturn(annualCashFlow, totalCashInvested float64) (float64, error) {
+	return percentageOf(annualCashFlow, totalCashInvested, ErrInvestmentNotPositive)
+}
+
+// CalculateCapRate calculates capitalization rate. It returns an error, and
+// 0, when the property value isn't positive.
+func (s *ArvService) CalculateCapRate(netOperatingIncome, propertyValue float64) (float64, error) {
+	return percentageOf(netOperatingIncome, propertyValue, ErrPropertyValueNotPositive)
+}
+
+// percentageOf is amount as a percentage of base, or errNotPositive when
+// base isn't positive
+func percentageOf(amount, base float64, errNotPositive error) (float64, error) {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) || math.IsNaN(base) || math.IsInf(base, 0) {
+		return 0, ErrInputNotFinite
 	}
-	return (netOperatingIncome / propertyValue) * 100
+	if base <= 0 {
+		return 0, errNotPositive
+	}
+	return (amount / base) * 100, nil
 }
 
 // assessRisk determines the risk level of the investment
@@ -250,37 +494,58 @@ func (s *ArvService) assessRisk(profitMargin float64, meets70Rule bool, arv, pur
 }
 
 // generateRecommendations provides investment recommendations based on analysis
-func (s *ArvService) generateRecommendations(req ArvRequest, profitMargin float64, meets70Rule bool) []string {
-	var recommendations []string
+func (s *ArvService) generateRecommendations(req ArvRequest, profitMargin float64, meets70Rule bool) []Notice {
+	var recommendations []Notice
 
 	if !meets70Rule {
-		recommendations = append(recommendations, "Property does not meet the 70% rule - consider negotiating a lower purchase price")
+		recommendations = append(recommendations, Notice{
+			Code: RecRuleNotMet, Severity: SeverityWarning, Field: "purchase_price",
+			Message: fmt.Sprintf("Property does not meet the %g%% rule - consider negotiating a lower purchase price", rulePercentage(req.RulePercentage)),
+		})
 	}
 
 	if profitMargin < 10 {
-		recommendations = append(recommendations, "Low profit margin - consider reducing rehab costs or finding a lower purchase price")
+		recommendations = append(recommendations, Notice{
+			Code: RecLowProfitMargin, Severity: SeverityWarning,
+			Message: "Low profit margin - consider reducing rehab costs or finding a lower purchase price",
+		})
 	}
 
 	if req.RehabCost > req.ARV*0.3 {
-		recommendations = append(recommendations, "Rehab costs are high (>30% of ARV) - verify estimates with contractors")
+		recommendations = append(recommendations, Notice{
+			Code: RecRehabOver30PctARV, Severity: SeverityWarning, Field: "rehab_cost",
+			Message: "Rehab costs are high (>30% of ARV) - verify estimates with contractors",
+		})
 	}
 
 	if req.HoldingCosts > req.ARV*0.05 {
-		recommendations = append(recommendations, "Holding costs seem high - consider faster renovation timeline")
+		recommendations = append(recommendations, Notice{
+			Code: RecHoldingCostsHigh, Severity: SeverityWarning, Field: "holding_costs",
+			Message: "Holding costs seem high - consider faster renovation timeline",
+		})
 	}
 
 	if profitMargin >= 20 && meets70Rule {
-		recommendations = append(recommendations, "Excellent investment opportunity with strong profit potential")
+		recommendations = append(recommendations, Notice{
+			Code: RecStrongProfit, Severity: SeverityPositive,
+			Message: "Excellent investment opportunity with strong profit potential",
+		})
 	}
 
 	// Market-based recommendations
 	equityPercent := ((req.ARV - req.PurchasePrice) / req.ARV) * 100
 	if equityPercent >= 30 {
-		recommendations = append(recommendations, "High equity position - good for BRRRR strategy")
+		recommendations = append(recommendations, Notice{
+			Code: RecHighEquity, Severity: SeverityPositive,
+			Message: "High equity position - good for BRRRR strategy",
+		})
 	}
 
 	if len(recommendations) == 0 {
-		recommendations = append(recommendations, "Moderate investment opportunity - proceed with careful due diligence")
+		recommendations = append(recommendations, Notice{
+			Code: RecModerateOpportunity, Severity: SeverityInfo,
+			Message: "Moderate investment opportunity - proceed with careful due diligence",
+		})
 	}
 
 	return recommendations
@@ -301,121 +566,220 @@ type ComparableProperty struct {
 
 // EstimateARVFromComps estimates ARV based on comparable properties
 func (s *ArvService) EstimateARVFromComps(comps []ComparableProperty, subjectBedrooms int, subjectBathrooms float64, subjectSquareFeet int) float64 {
-	if len(comps) == 0 {
-		return 0
-	}
-
-	totalAdjustedValue := 0.0
-	weightedTotal := 0.0
-
-	for _, comp := range comps {
-		// Calculate adjustments based on differences
-		adjustments := s.calculateComparableAdjustments(comp, subjectBedrooms, subjectBathrooms, float64(subjectSquareFeet))
-		adjustedValue := comp.SalePrice + adjustments
-
-		// Weight by distance (closer properties have more weight)
-		weight := 1.0 / (1.0 + comp.Distance)
-
-		totalAdjustedValue += adjustedValue * weight
-		weightedTotal += weight
-	}
-
-	if weightedTotal == 0 {
-		return 0
-	}
-
-	estimatedArv := totalAdjustedValue / weightedTotal
-	return math.Round(estimatedArv*100) / 100
+	return s.EstimateARVFromCompsBreakdown(comps, subjectBedrooms, subjectBathrooms, subjectSquareFeet, CompEstimateOptions{}).EstimatedARV
 }
 
 // calculateComparableAdjustments calculates adjustments for comparable properties
 func (s *ArvService) calculateComparableAdjustments(comp ComparableProperty, subjectBeds int, subjectBaths, subjectSqFt float64) float64 {
-	adjustments := 0.0
+	return s.itemizeComparableAdjustments(comp, subjectBeds, subjectBaths, subjectSqFt, DefaultCompAdjustmentSchedule()).Total
+}
+
+// itemizeComparableAdjustments works out each adjustment for a comparable
+// property
+func (s *ArvService) itemizeComparableAdjustments(comp ComparableProperty, subjectBeds int, subjectBaths, subjectSqFt float64, schedule CompAdjustmentSchedule) CompAdjustments {
+	var adjustments CompAdjustments
 
-	// Bedroom adjustment (~$5,000 per bedroom difference)
+	// Bedroom adjustment (~$5,000 per bedroom difference by default)
 	bedroomDiff := subjectBeds - comp.Bedrooms
-	adjustments += float64(bedroomDiff) * 5000
+	adjustments.Bedrooms = float64(bedroomDiff) * schedule.PerBedroom
 
-	// Bathroom adjustment (~$3,000 per bathroom difference)
+	// Bathroom adjustment (~$3,000 per bathroom difference by default)
 	bathroomDiff := subjectBaths - comp.Bathrooms
-	adjustments += bathroomDiff * 3000
+	adjustments.Bathrooms = roundCents(bathroomDiff * schedule.PerBathroom)
 
-	// Square footage adjustment (~$50 per sq ft difference)
+	// Square footage adjustment (~$50 per sq ft difference by default)
 	sqFtDiff := subjectSqFt - float64(comp.SquareFeet)
-	adjustments += sqFtDiff * 50
+	adjustments.SquareFeet = roundCents(sqFtDiff * schedule.PerSqFt)
 
+	adjustments.Total = roundCents(adjustments.Bedrooms + adjustments.Bathrooms + adjustments.SquareFeet)
 	return adjustments
 }
 
 // setDefaultsAndValidate sets reasonable defaults and validates inputs
 func (s *ArvService) setDefaultsAndValidate(req *ArvRequest, result *ArvResult) {
+	s.estimateClosingCosts(req, result)
+
 	// Set default refinance LTV if not provided
 	if req.RefinanceLTV == 0 {
 		req.RefinanceLTV = 75.0
+		result.assume("refinance_ltv", req.RefinanceLTV, "Typical cash-out refinance LTV of 75%")
 	}
 
+	if req.RulePercentage == 0 {
+		result.assume("rule_percentage", DefaultRulePercentage, "Classic 70% rule")
+	}
+	req.RulePercentage = rulePercentage(req.RulePercentage)
+
 	// Set default loan term if not provided
 	if req.LoanTerm == 0 {
 		req.LoanTerm = 30
+		result.assume("loan_term", float64(req.LoanTerm), "Standard 30-year mortgage")
 	}
+	setLoanTypeDefaults(req, result)
+
+	mapPropertyMgmt(req, result)
+
+	// Set default vacancy and credit loss from the caller's market defaults
+	setMarketDefaults(req, result)
 
 	// Set default vacancy rate if not provided (market average)
 	if req.VacancyRate == 0 {
 		req.VacancyRate = 8.0 // 8% is reasonable default
-		result.Warnings = append(result.Warnings, "Using default vacancy rate of 8%")
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnVacancyDefaulted, Severity: SeverityInfo, Field: "vacancy_rate",
+			Message: "Using default vacancy rate of 8%",
+		})
+		result.assume("vacancy_rate", req.VacancyRate, "Market average vacancy of 8%")
 	}
 
 	// Estimate monthly rent if not provided (1% rule as fallback)
 	if req.MonthlyRent == 0 {
 		req.MonthlyRent = req.ARV * 0.01 // 1% rule
-		result.Warnings = append(result.Warnings, "Monthly rent estimated using 1% rule - verify with market data")
+		req.RentSource = RentSourceOnePercentRule
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnRentEstimated, Severity: SeverityWarning, Field: "monthly_rent",
+			Message: "Monthly rent estimated using 1% rule - verify with market data",
+		})
+		result.assume("monthly_rent", req.MonthlyRent, "1% rule: 1% of ARV a month")
+	} else if req.RentSource == "" {
+		req.RentSource = RentSourceProvided
 	}
 
 	// Estimate expenses if not provided
 	if req.PropertyTaxes == 0 {
 		req.PropertyTaxes = req.ARV * 0.015 // 1.5% of ARV annually
-		result.Warnings = append(result.Warnings, "Property taxes estimated at 1.5% of ARV")
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnTaxesEstimated, Severity: SeverityInfo, Field: "property_taxes",
+			Message: "Property taxes estimated at 1.5% of ARV",
+		})
+		result.assume("property_taxes", req.PropertyTaxes, "1.5% of ARV a year")
 	}
 
 	if req.Insurance == 0 {
 		req.Insurance = req.ARV * 0.005 // 0.5% of ARV annually
-		result.Warnings = append(result.Warnings, "Insurance estimated at 0.5% of ARV")
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnInsuranceEstimated, Severity: SeverityInfo, Field: "insurance",
+			Message: "Insurance estimated at 0.5% of ARV",
+		})
+		result.assume("insurance", req.Insurance, "0.5% of ARV a year")
 	}
 
 	if req.Maintenance == 0 {
 		req.Maintenance = req.MonthlyRent * 12 * 0.10 // 10% of gross rent
-		result.Warnings = append(result.Warnings, "Maintenance estimated at 10% of gross rent")
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnMaintenanceEstimated, Severity: SeverityInfo, Field: "maintenance",
+			Message: "Maintenance estimated at 10% of gross rent",
+		})
+		result.assume("maintenance", req.Maintenance, "10% of gross rent")
 	}
 
 	if req.CapEx == 0 {
 		req.CapEx = req.MonthlyRent * 12 * 0.05 // 5% of gross rent
-		result.Warnings = append(result.Warnings, "CapEx estimated at 5% of gross rent")
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnCapExEstimated, Severity: SeverityInfo, Field: "capex",
+			Message: "CapEx estimated at 5% of gross rent",
+		})
+		result.assume("capex", req.CapEx, "5% of gross rent")
 	}
 
 	// Set default interest rate if not provided
 	if req.InterestRate == 0 {
 		req.InterestRate = 7.0 // Current market rate
-		result.Warnings = append(result.Warnings, "Using default interest rate of 7%")
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnInterestRateDefaulted, Severity: SeverityInfo, Field: "interest_rate",
+			Message: "Using default interest rate of 7%",
+		})
+		result.assume("interest_rate", req.InterestRate, "Current market rate of 7%")
 	}
 
 	// Validate critical inputs
 	if req.ARV <= req.PurchasePrice + req.RehabCost {
-		result.Warnings = append(result.Warnings, "WARNING: ARV may be too low compared to total acquisition costs")
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnARVBelowCosts, Severity: SeverityWarning, Field: "arv",
+			Message: "WARNING: ARV may be too low compared to total acquisition costs",
+		})
 	}
 
 	if req.VacancyRate > 20 {
-		result.Warnings = append(result.Warnings, "WARNING: Vacancy rate seems unusually high")
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnVacancyHigh, Severity: SeverityWarning, Field: "vacancy_rate",
+			Message: "WARNING: Vacancy rate seems unusually high",
+		})
+	}
+}
+
+// cashOnCashReturn is annualCashFlow as a percentage of the cash left in,
+// or nil for an infinite return: none left in and positive cash flow
+func cashOnCashReturn(annualCashFlow, cashLeftIn float64) *float64 {
+	if cashLeftIn <= 0 && annualCashFlow > 0 {
+		return nil
+	}
+	cashOnCash := 0.0
+	if cashLeftIn > 0 {
+		cashOnCash = annualCashFlow / cashLeftIn * 100
+	}
+	return &cashOnCash
+}
+
+// cashOnCashValue returns a cash-on-cash return as +Inf when it's
+// infinite, for comparing and scoring
+func cashOnCashValue(cashOnCash *float64, infinite bool) float64 {
+	if infinite {
+		return math.Inf(1)
 	}
+	if cashOnCash == nil {
+		return 0
+	}
+	return *cashOnCash
+}
+
+// cashOnCashDisplay formats r's cash-on-cash return for display
+func cashOnCashDisplay(r ArvResult) string {
+	if r.CashOnCashReturn == nil {
+		return "∞"
+	}
+	return fmt.Sprintf("%.2f%%", *r.CashOnCashReturn)
+}
+
+// maxPropertyMgmtPercent is the highest management fee, as a percentage of
+// rent, a request can give
+const maxPropertyMgmtPercent = 20.0
+
+// mapPropertyMgmt moves the deprecated property_mgmt field onto the explicit
+// ones when they aren't given: a percentage if it could be one, otherwise a
+// flat annual fee
+func mapPropertyMgmt(req *ArvRequest, result *ArvResult) {
+	if req.PropertyMgmt == 0 {
+		return
+	}
+	notice := Notice{Code: WarnPropertyMgmtDeprecated, Severity: SeverityWarning, Field: "property_mgmt"}
+	if req.PropertyMgmtPercent > 0 || req.PropertyMgmtAnnual > 0 {
+		notice.Message = "property_mgmt is deprecated and was ignored in favor of property_mgmt_percent and property_mgmt_annual"
+	} else if req.PropertyMgmt <= maxPropertyMgmtPercent {
+		req.PropertyMgmtPercent = req.PropertyMgmt
+		notice.Message = fmt.Sprintf("property_mgmt is deprecated - read as %g%% of rent; use property_mgmt_percent", req.PropertyMgmt)
+	} else {
+		req.PropertyMgmtAnnual = req.PropertyMgmt
+		notice.Message = fmt.Sprintf("property_mgmt is deprecated - read as a $%.2f annual fee; use property_mgmt_annual", req.PropertyMgmt)
+	}
+	req.PropertyMgmt = 0
+	result.Warnings = append(result.Warnings, notice)
 }
 
 // calculateMonthlyPayment calculates monthly P&I payment
 func (s *ArvService) calculateMonthlyPayment(principal, annualRate float64, years int) float64 {
+	return monthlyPayment(principal, annualRate, years*12)
+}
+
+// monthlyPayment calculates the monthly P&I payment that pays off principal
+// over a number of months, which needn't be whole years
+func monthlyPayment(principal, annualRate float64, months int) float64 {
 	if annualRate == 0 {
-		return principal / float64(years*12)
+		return principal / float64(months)
 	}
 
 	monthlyRate := annualRate / 100 / 12
-	numPayments := float64(years * 12)
+	numPayments := float64(months)
 
 	// Standard mortgage payment formula
 	payment := principal * (monthlyRate * math.Pow(1+monthlyRate, numPayments)) /
@@ -454,9 +818,9 @@ func (s *ArvService) assessBRRRRisk(result ArvResult) string {
 	}
 
 	// Cash-on-cash return risk
-	if result.CashOnCashReturn < 8 && !result.IsInfiniteReturn {
+	if cashOnCashValue(result.CashOnCashReturn, result.IsInfiniteReturn) < 8 {
 		riskScore += 2
-	} else if result.CashOnCashReturn < 12 && !result.IsInfiniteReturn {
+	} else if cashOnCashValue(result.CashOnCashReturn, result.IsInfiniteReturn) < 12 {
 		riskScore += 1
 	}
 
@@ -480,86 +844,152 @@ func (s *ArvService) assessBRRRRisk(result ArvResult) string {
 }
 
 // generateBRRRRRecommendations provides specific BRRRR strategy recommendations
-func (s *ArvService) generateBRRRRRecommendations(req ArvRequest, result ArvResult) []string {
-	var recommendations []string
+func (s *ArvService) generateBRRRRRecommendations(req ArvRequest, result ArvResult) []Notice {
+	var recommendations []Notice
 
 	// Cash flow recommendations
 	if result.MonthlyCashFlow < 0 {
-		recommendations = append(recommendations, "CRITICAL: Negative cash flow - property will require monthly contributions")
+		recommendations = append(recommendations, Notice{
+			Code: RecNegativeCashFlow, Severity: SeverityCritical,
+			Message: "CRITICAL: Negative cash flow - property will require monthly contributions",
+		})
 	} else if result.MonthlyCashFlow < 100 {
-		recommendations = append(recommendations, "Low cash flow - consider higher rent or lower expenses")
+		recommendations = append(recommendations, Notice{
+			Code: RecLowCashFlow, Severity: SeverityWarning,
+			Message: "Low cash flow - consider higher rent or lower expenses",
+		})
 	}
 
 	// DSCR recommendations
 	if result.DSCR < 1.0 {
-		recommendations = append(recommendations, "CRITICAL: DSCR below 1.0 - property cannot service debt from income")
+		recommendations = append(recommendations, Notice{
+			Code: RecDSCRBelow1, Severity: SeverityCritical,
+			Message: "CRITICAL: DSCR below 1.0 - property cannot service debt from income",
+		})
 	} else if result.DSCR < 1.25 {
-		recommendations = append(recommendations, "Low DSCR - lender may require higher down payment or reject loan")
+		recommendations = append(recommendations, Notice{
+			Code: RecDSCRBelow125, Severity: SeverityWarning,
+			Message: "Low DSCR - lender may require higher down payment or reject loan",
+		})
 	}
 
 	// Refinance recommendations
 	if result.CashRecovered >= result.TotalInvestment * 0.9 {
-		recommendations = append(recommendations, "Excellent BRRRR opportunity - can recover most/all invested capital")
+		recommendations = append(recommendations, Notice{
+			Code: RecStrongCashRecovery, Severity: SeverityPositive,
+			Message: "Excellent BRRRR opportunity - can recover most/all invested capital",
+		})
 	} else if result.CashRecovered < result.TotalInvestment * 0.5 {
-		recommendations = append(recommendations, "Limited cash recovery in refinance - consider if BRRRR is optimal strategy")
+		recommendations = append(recommendations, Notice{
+			Code: RecLimitedCashRecovery, Severity: SeverityWarning,
+			Message: "Limited cash recovery in refinance - consider if BRRRR is optimal strategy",
+		})
 	}
 
 	// Cap rate recommendations
 	if result.CapRate < 4 {
-		recommendations = append(recommendations, "Low cap rate - property may be overvalued for rental income")
+		recommendations = append(recommendations, Notice{
+			Code: RecLowCapRate, Severity: SeverityWarning,
+			Message: "Low cap rate - property may be overvalued for rental income",
+		})
 	} else if result.CapRate > 10 {
-		recommendations = append(recommendations, "High cap rate - verify income and expense estimates for accuracy")
+		recommendations = append(recommendations, Notice{
+			Code: RecHighCapRate, Severity: SeverityWarning,
+			Message: "High cap rate - verify income and expense estimates for accuracy",
+		})
 	}
 
 	// Expense ratio recommendations
 	if result.ExpenseRatio > 60 {
-		recommendations = append(recommendations, "High expense ratio - review all expense categories for accuracy")
+		recommendations = append(recommendations, Notice{
+			Code: RecHighExpenseRatio, Severity: SeverityWarning,
+			Message: "High expense ratio - review all expense categories for accuracy",
+		})
 	} else if result.ExpenseRatio < 30 {
-		recommendations = append(recommendations, "Low expense ratio - ensure all expenses are accounted for")
+		recommendations = append(recommendations, Notice{
+			Code: RecLowExpenseRatio, Severity: SeverityWarning,
+			Message: "Low expense ratio - ensure all expenses are accounted for",
+		})
+	}
+
+	if recommendation, ok := breakEvenRecommendation(result); ok {
+		recommendations = append(recommendations, recommendation)
+	}
+	if recommendation, ok := strRecommendation(result); ok {
+		recommendations = append(recommendations, recommendation)
 	}
 
 	// 70% rule comparison
 	if !result.Is70RuleGood {
-		recommendations = append(recommendations, "Property fails 70% rule - higher risk flip/BRRRR deal")
+		recommendations = append(recommendations, Notice{
+			Code: RecRuleNotMet, Severity: SeverityWarning, Field: "purchase_price",
+			Message: fmt.Sprintf("Property fails %g%% rule - higher risk flip/BRRRR deal", rulePercentage(result.RulePercentage)),
+		})
 	}
 
 	// Positive recommendations
 	if result.IsInfiniteReturn && result.IsCashFlowPositive {
-		recommendations = append(recommendations, "EXCELLENT: Infinite return with positive cash flow - ideal BRRRR deal")
-	} else if result.CashOnCashReturn > 15 && result.IsCashFlowPositive {
-		recommendations = append(recommendations, "Strong BRRRR opportunity with good returns and cash flow")
+		recommendations = append(recommendations, Notice{
+			Code: RecInfiniteReturn, Severity: SeverityPositive,
+			Message: "EXCELLENT: Infinite return with positive cash flow - ideal BRRRR deal",
+		})
+	} else if cashOnCashValue(result.CashOnCashReturn, result.IsInfiniteReturn) > 15 && result.IsCashFlowPositive {
+		recommendations = append(recommendations, Notice{
+			Code: RecStrongBRRRR, Severity: SeverityPositive,
+			Message: "Strong BRRRR opportunity with good returns and cash flow",
+		})
 	}
 
 	if len(recommendations) == 0 {
-		recommendations = append(recommendations, "Moderate BRRRR opportunity - perform detailed due diligence")
+		recommendations = append(recommendations, Notice{
+			Code: RecModerateBRRRR, Severity: SeverityInfo,
+			Message: "Moderate BRRRR opportunity - perform detailed due diligence",
+		})
 	}
 
 	return recommendations
 }
 
 // roundFinancialValues rounds all financial values to 2 decimal places
+// Currency goes through Money, so effective income and NOI are the exact
+// differences of their rounded parts; ratios stay floats.
 func (s *ArvService) roundFinancialValues(result *ArvResult) {
-	result.AnnualGrossIncome = math.Round(result.AnnualGrossIncome*100) / 100
-	result.EffectiveIncome = math.Round(result.EffectiveIncome*100) / 100
-	result.AnnualExpenses = math.Round(result.AnnualExpenses*100) / 100
+	grossIncome := models.MoneyFromFloat(result.AnnualGrossIncome)
+	vacancyLoss := models.MoneyFromFloat(result.VacancyLoss)
+	creditLoss := models.MoneyFromFloat(result.CreditLoss)
+	effectiveIncome := grossIncome - vacancyLoss - creditLoss
+	expenses := models.MoneyFromFloat(result.AnnualExpenses)
+	result.AnnualGrossIncome = grossIncome.Float64()
+	result.VacancyLoss = vacancyLoss.Float64()
+	result.CreditLoss = creditLoss.Float64()
+	result.EffectiveIncome = effectiveIncome.Float64()
+	result.AnnualExpenses = expenses.Float64()
+	result.NOI = (effectiveIncome - expenses).Float64()
+
 	result.ExpenseRatio = math.Round(result.ExpenseRatio*100) / 100
-	result.NOI = math.Round(result.NOI*100) / 100
-	result.MaxOffer70 = math.Round(result.MaxOffer70*100) / 100
-	result.TotalInvestment = math.Round(result.TotalInvestment*100) / 100
-	result.PotentialProfit = math.Round(result.PotentialProfit*100) / 100
+	result.MaxOffer70 = roundCents(result.MaxOffer70)
+	result.TotalInvestment = roundCents(result.TotalInvestment)
+	result.PotentialProfit = roundCents(result.PotentialProfit)
 	result.ProfitMargin = math.Round(result.ProfitMargin*100) / 100
 	result.ROI = math.Round(result.ROI*100) / 100
-	result.BrrrrMaxOffer = math.Round(result.BrrrrMaxOffer*100) / 100
-	result.BrrrrProfit = math.Round(result.BrrrrProfit*100) / 100
-	result.RefinanceAmount = math.Round(result.RefinanceAmount*100) / 100
-	result.CashRecovered = math.Round(result.CashRecovered*100) / 100
-	result.CashLeftIn = math.Round(result.CashLeftIn*100) / 100
-	result.MonthlyDebtService = math.Round(result.MonthlyDebtService*100) / 100
-	result.MonthlyCashFlow = math.Round(result.MonthlyCashFlow*100) / 100
-	result.AnnualCashFlow = math.Round(result.AnnualCashFlow*100) / 100
-	result.CashOnCashReturn = math.Round(result.CashOnCashReturn*100) / 100
+	result.BrrrrMaxOffer = roundCents(result.BrrrrMaxOffer)
+	result.BrrrrProfit = roundCents(result.BrrrrProfit)
+	result.RefinanceAmount = roundCents(result.RefinanceAmount)
+	result.CashRecovered = roundCents(result.CashRecovered)
+	result.CashLeftIn = roundCents(result.CashLeftIn)
+	result.MonthlyDebtService = roundCents(result.MonthlyDebtService)
+	result.MonthlyCashFlow = roundCents(result.MonthlyCashFlow)
+	result.AnnualCashFlow = roundCents(result.AnnualCashFlow)
+	if result.CashOnCashReturn != nil {
+		*result.CashOnCashReturn = math.Round(*result.CashOnCashReturn*100) / 100
+	}
 	result.CapRate = math.Round(result.CapRate*100) / 100
 	result.DSCR = math.Round(result.DSCR*100) / 100
+	result.BreakEvenOccupancy = math.Round(result.BreakEvenOccupancy*100) / 100
+	result.BreakEvenRent = roundCents(result.BreakEvenRent)
+	if result.HardMoney != nil {
+		roundHardMoney(result.HardMoney)
+	}
 }
 
 // CalculateEnhancedBRRRR performs enhanced BRRRR analysis with new risk assessment
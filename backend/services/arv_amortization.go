@@ -0,0 +1,140 @@
+package services
+
+import (
+	"time"
+
+	"arvfinder-backend/models"
+)
+
+// AmortizationRequest is the input for an amortization schedule.
+// AnnualLumpSum is paid towards principal with every twelfth payment, on
+// top of any ExtraMonthlyPayment. FirstPaymentDate, formatted YYYY-MM-DD,
+// dates the payoff.
+type AmortizationRequest struct {
+	Principal           float64 `json:"principal" binding:"required,min=1"`
+	AnnualRate          float64 `json:"annual_rate" binding:"min=0,max=30"` // percentage
+	TermYears           int     `json:"term_years" binding:"required,min=1,max=50"`
+	ExtraMonthlyPayment float64 `json:"extra_monthly_payment" binding:"min=0"`
+	AnnualLumpSum       float64 `json:"annual_lump_sum" binding:"min=0"`
+	FirstPaymentDate    string  `json:"first_payment_date" binding:"omitempty,datetime=2006-01-02"`
+}
+
+// AmortizationPayment is one month of an amortization schedule. Payment
+// and Principal include ExtraPrincipal, the part paid ahead of schedule.
+// Amounts are in cents, so a schedule's rows add up exactly.
+type AmortizationPayment struct {
+	PaymentNumber  int          `json:"payment_number"`
+	Payment        models.Money `json:"payment"`
+	Interest       models.Money `json:"interest"`
+	Principal      models.Money `json:"principal"`
+	ExtraPrincipal models.Money `json:"extra_principal"`
+	Balance        models.Money `json:"balance"`
+}
+
+// prepayments are paid towards a loan's principal on top of its scheduled
+// payment: monthly every month, and annual with every twelfth payment
+type prepayments struct {
+	monthly float64
+	annual  float64
+}
+
+// any reports whether anything is prepaid
+func (p prepayments) any() bool {
+	return p.monthly > 0 || p.annual > 0
+}
+
+// due is what's prepaid with payment number n
+func (p prepayments) due(n int) models.Money {
+	if n%12 == 0 {
+		return models.MoneyFromFloat(p.monthly) + models.MoneyFromFloat(p.annual)
+	}
+	return models.MoneyFromFloat(p.monthly)
+}
+
+// AmortizationSchedule is a loan's month-by-month payoff. InterestSaved and
+// MonthsSaved compare it with paying no extra principal.
+type AmortizationSchedule struct {
+	MonthlyPayment models.Money          `json:"monthly_payment"` // principal and interest, without extra
+	Schedule       []AmortizationPayment `json:"schedule"`
+	TotalInterest  models.Money          `json:"total_interest"`
+	TotalPaid      models.Money          `json:"total_paid"`
+	PayoffMonth    int                   `json:"payoff_month"`
+	PayoffDate     string                `json:"payoff_date,omitempty"` // only with a first payment date
+	InterestSaved  models.Money          `json:"interest_saved"`
+	MonthsSaved    int                   `json:"months_saved"`
+}
+
+// maxAmortizationMonths caps a schedule at 50 years
+const maxAmortizationMonths = 50 * 12
+
+// CalculateAmortization builds the amortization schedule of a fixed-rate
+// loan, paying ExtraMonthlyPayment and AnnualLumpSum towards principal
+func (s *ArvService) CalculateAmortization(req AmortizationRequest) AmortizationSchedule {
+	months := req.TermYears * 12
+	if months > maxAmortizationMonths {
+		months = maxAmortizationMonths
+	}
+	payment := s.calculateMonthlyPayment(req.Principal, req.AnnualRate, months/12)
+
+	result := AmortizationSchedule{MonthlyPayment: models.MoneyFromFloat(payment)}
+	extra := prepayments{monthly: req.ExtraMonthlyPayment, annual: req.AnnualLumpSum}
+	result.Schedule, result.TotalInterest = amortizeWithPrepayments(req.Principal, req.AnnualRate, months, payment, extra)
+	result.PayoffMonth = len(result.Schedule)
+	for _, row := range result.Schedule {
+		result.TotalPaid += row.Payment
+	}
+	if first, err := time.Parse("2006-01-02", req.FirstPaymentDate); err == nil && result.PayoffMonth > 0 {
+		result.PayoffDate = first.AddDate(0, result.PayoffMonth-1, 0).Format("2006-01-02")
+	}
+
+	if extra.any() {
+		baseline, baselineInterest := amortize(req.Principal, req.AnnualRate, months, payment)
+		result.InterestSaved = baselineInterest - result.TotalInterest
+		result.MonthsSaved = len(baseline) - result.PayoffMonth
+	}
+	return result
+}
+
+// amortize pays payment a month, rounded to the cent like a lender would,
+// until principal is paid off or months run out. The last payment is
+// whatever clears the balance.
+func amortize(principal, annualRate float64, months int, payment float64) ([]AmortizationPayment, models.Money) {
+	return amortizeWithPrepayments(principal, annualRate, months, payment, prepayments{})
+}
+
+// amortizeWithPrepayments amortizes like amortize, paying extra towards
+// principal on top of each scheduled payment. A prepayment is cut down to
+// what's left of the balance, so the payment that clears the loan is only
+// as big as it needs to be. Each month's interest is rounded to the cent
+// and everything else is added up in cents.
+func amortizeWithPrepayments(principal, annualRate float64, months int, payment float64, extra prepayments) ([]AmortizationPayment, models.Money) {
+	monthlyRate := annualRate / 100 / 12
+	balance := models.MoneyFromFloat(principal)
+	scheduled := models.MoneyFromFloat(payment)
+	schedule := []AmortizationPayment{}
+	var totalInterest models.Money
+
+	for n := 1; balance > 0 && n <= months; n++ {
+		interest := models.MoneyFromFloat(balance.Float64() * monthlyRate)
+		principalPaid := scheduled - interest
+		var prepaid models.Money
+		if principalPaid >= balance || n == months {
+			principalPaid = balance
+		} else {
+			prepaid = min(extra.due(n), balance-principalPaid)
+			principalPaid += prepaid
+		}
+		balance -= principalPaid
+		totalInterest += interest
+
+		schedule = append(schedule, AmortizationPayment{
+			PaymentNumber:  n,
+			Payment:        interest + principalPaid,
+			Interest:       interest,
+			Principal:      principalPaid,
+			ExtraPrincipal: prepaid,
+			Balance:        balance,
+		})
+	}
+	return schedule, totalInterest
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"testing"
+
+	"arvfinder-backend/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dollars is an expected amount as Money
+func dollars(amount float64) models.Money {
+	return models.MoneyFromFloat(amount)
+}
+
+func TestCalculateAmortization_ThirtyYearLoan(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateAmortization(AmortizationRequest{
+		Principal:  200000,
+		AnnualRate: 6,
+		TermYears:  30,
+	})
+
+	assert.Equal(t, dollars(1199.10), result.MonthlyPayment)
+	require.Len(t, result.Schedule, 360)
+	assert.Equal(t, 360, result.PayoffMonth)
+
+	first := result.Schedule[0]
+	assert.Equal(t, 1, first.PaymentNumber)
+	assert.Equal(t, dollars(1000), first.Interest) // 200,000 at 0.5% a month
+	assert.Equal(t, dollars(199.10), first.Principal)
+	assert.Equal(t, dollars(199800.90), first.Balance)
+
+	last := result.Schedule[359]
+	assert.Equal(t, 360, last.PaymentNumber)
+	assert.Zero(t, last.Balance)
+	assert.InDelta(t, 1199.10, last.Payment.Float64(), 2, "the last payment only makes up for rounding")
+
+	assert.InDelta(t, 231676, result.TotalInterest.Float64(), 5)
+	assert.Equal(t, dollars(200000)+result.TotalInterest, result.TotalPaid)
+	assert.Zero(t, result.InterestSaved)
+}
+
+func TestCalculateAmortization_ExtraPayments(t *testing.T) {
+	service := NewArvService()
+
+	base := service.CalculateAmortization(AmortizationRequest{Principal: 200000, AnnualRate: 6, TermYears: 30})
+	result := service.CalculateAmortization(AmortizationRequest{
+		Principal:           200000,
+		AnnualRate:          6,
+		TermYears:           30,
+		ExtraMonthlyPayment: 200,
+	})
+
+	assert.Equal(t, dollars(1199.10), result.MonthlyPayment)
+	assert.Equal(t, dollars(1399.10), result.Schedule[0].Payment)
+	assert.Equal(t, dollars(399.10), result.Schedule[0].Principal)
+	assert.Less(t, result.PayoffMonth, 360)
+	assert.Equal(t, 360-result.PayoffMonth, result.MonthsSaved)
+	assert.Equal(t, base.TotalInterest-result.TotalInterest, result.InterestSaved)
+	assert.Positive(t, result.InterestSaved)
+	assert.Zero(t, result.Schedule[len(result.Schedule)-1].Balance)
+}
+
+func TestCalculateAmortization_ZeroRate(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateAmortization(AmortizationRequest{Principal: 12000, TermYears: 1})
+
+	assert.Equal(t, dollars(1000), result.MonthlyPayment)
+	require.Len(t, result.Schedule, 12)
+	for i, row := range result.Schedule {
+		assert.Zero(t, row.Interest)
+		assert.Equal(t, dollars(1000), row.Principal)
+		assert.Equal(t, dollars(12000-1000*float64(i+1)), row.Balance)
+	}
+	assert.Zero(t, result.TotalInterest)
+	assert.Equal(t, dollars(12000), result.TotalPaid)
+}
+
+func TestCalculateAmortization_ExtraPaymentPaysOffInOneMonth(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateAmortization(AmortizationRequest{
+		Principal:           5000,
+		AnnualRate:          12,
+		TermYears:           5,
+		ExtraMonthlyPayment: 10000,
+	})
+
+	require.Len(t, result.Schedule, 1)
+	assert.Equal(t, dollars(5050), result.Schedule[0].Payment)
+	assert.Zero(t, result.Schedule[0].Balance)
+	assert.Equal(t, 59, result.MonthsSaved)
+}
+
+func TestCalculateAmortization_CappedAtFiftyYears(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateAmortization(AmortizationRequest{Principal: 100000, AnnualRate: 5, TermYears: 80})
+
+	assert.Len(t, result.Schedule, 600)
+	assert.Zero(t, result.Schedule[599].Balance)
+}
+
+func TestCalculateAmortization_InterestSavedMatchesSpreadsheet(t *testing.T) {
+	service := NewArvService()
+
+	// $200,000 at 6% over 30 years is $1,199.10 a month. Rounding it up to
+	// $1,299.10 pays the loan off in NPER(0.5%, 1299.10, -200000) = 294.46
+	// payments, so the 295th is a partial one.
+	result := service.CalculateAmortization(AmortizationRequest{
+		Principal:           200000,
+		AnnualRate:          6,
+		TermYears:           30,
+		ExtraMonthlyPayment: 100,
+		FirstPaymentDate:    "2026-01-01",
+	})
+
+	assert.Equal(t, 295, result.PayoffMonth)
+	assert.Equal(t, 65, result.MonthsSaved)
+	assert.Equal(t, "2050-07-01", result.PayoffDate)
+	assert.Equal(t, dollars(182538.19), result.TotalInterest)
+	assert.Equal(t, dollars(49138.85), result.InterestSaved) // 231,677.04 without the extra $100
+
+	last := result.Schedule[len(result.Schedule)-1]
+	assert.Zero(t, last.Balance)
+	assert.Less(t, last.Payment, dollars(1299.10))
+	assert.Less(t, last.ExtraPrincipal, dollars(100))
+}
+
+func TestCalculateAmortization_AnnualLumpSum(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateAmortization(AmortizationRequest{
+		Principal:     200000,
+		AnnualRate:    6,
+		TermYears:     30,
+		AnnualLumpSum: 1000,
+	})
+
+	assert.Zero(t, result.Schedule[10].ExtraPrincipal)
+	assert.Equal(t, dollars(1000), result.Schedule[11].ExtraPrincipal)
+	assert.Equal(t, dollars(2199.10), result.Schedule[11].Payment)
+	assert.Equal(t, 305, result.PayoffMonth)
+	assert.Equal(t, dollars(41013.23), result.InterestSaved)
+	assert.Empty(t, result.PayoffDate)
+}
+
+func TestCalculateAmortization_LumpSumCutToBalance(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateAmortization(AmortizationRequest{
+		Principal:     10000,
+		TermYears:     5,
+		AnnualLumpSum: 50000,
+	})
+
+	// Eleven payments of $166.67 leave $8,166.63, which the twelfth pays
+	// off instead of the whole lump sum
+	require.Len(t, result.Schedule, 12)
+	last := result.Schedule[11]
+	assert.Equal(t, dollars(8166.63), last.Payment)
+	assert.Equal(t, dollars(7999.96), last.ExtraPrincipal)
+	assert.Zero(t, last.Balance)
+	assert.Equal(t, dollars(10000), result.TotalPaid)
+}
+
+func TestCalculateAmortization_FiftyYearScheduleSumsExactly(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateAmortization(AmortizationRequest{
+		Principal:           333333.33,
+		AnnualRate:          7.125,
+		TermYears:           50,
+		ExtraMonthlyPayment: 17.17,
+		AnnualLumpSum:       1234.56,
+	})
+
+	// 600 rows of cents add up to the loan and the totals without a cent
+	// of drift
+	var principal, interest, paid models.Money
+	for _, row := range result.Schedule {
+		assert.Equal(t, row.Interest+row.Principal, row.Payment, "payment %d", row.PaymentNumber)
+		principal += row.Principal
+		interest += row.Interest
+		paid += row.Payment
+	}
+	assert.Equal(t, dollars(333333.33), principal)
+	assert.Equal(t, result.TotalInterest, interest)
+	assert.Equal(t, result.TotalPaid, paid)
+	assert.Equal(t, principal+interest, result.TotalPaid)
+	assert.Zero(t, result.Schedule[len(result.Schedule)-1].Balance)
+}
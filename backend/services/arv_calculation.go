@@ -0,0 +1,122 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"arvfinder-backend/models"
+)
+
+// arvCalculationColumns are selected, in this order, by scanArvCalculation
+const arvCalculationColumns = `id, COALESCE(property_id::text, ''), tenant_id, purchase_price, COALESCE(rehab_cost, 0),
+	COALESCE(holding_costs, 0), COALESCE(closing_costs, 0), arv, COALESCE(max_offer, 0), COALESCE(potential_profit, 0),
+	COALESCE(profit_margin, 0), COALESCE(total_investment, 0), COALESCE(monthly_cash_flow, 0),
+	cash_on_cash_return, is_infinite_return, COALESCE(cap_rate, 0), COALESCE(dscr, 0), COALESCE(risk_level, ''),
+	rehab_cost_source, gross_rent_multiplier, rent_to_price_ratio, created_at`
+
+// SaveCalculationRequest is an ARV analysis to run and save for a property.
+// UseRehabItems replaces RehabCost with the sum of the property's estimated
+// rehab items, and UseRentRoll replaces MonthlyRent with the sum of its
+// units' market rents.
+type SaveCalculationRequest struct {
+	ArvRequest
+	UseRehabItems bool `json:"use_rehab_items"`
+	UseRentRoll   bool `json:"use_rent_roll"`
+}
+
+// ArvCalculationRepository stores the ARV analyses run against a tenant's
+// properties
+type ArvCalculationRepository struct {
+	db         *sql.DB
+	properties *PropertyRepository
+	arvService *ArvService
+}
+
+// NewArvCalculationRepository creates a new ARV calculation repository
+func NewArvCalculationRepository(db *sql.DB) *ArvCalculationRepository {
+	return &ArvCalculationRepository{
+		db:         db,
+		properties: NewPropertyRepository(db),
+		arvService: NewArvService(),
+	}
+}
+
+// Save runs an ARV analysis for one of tenantID's properties and keeps its
+// key figures, including where its rehab cost came from and the max offer at
+// the request's rule percentage. The full result is returned alongside the
+// saved record.
+func (r *ArvCalculationRepository) Save(tenantID, propertyID string, req SaveCalculationRequest) (*models.ArvCalculation, *ArvResult, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	rehabCostSource := RehabCostSourceManual
+	if req.UseRehabItems {
+		if req.RehabCost, err = estimatedRehabCost(r.db, property.ID); err != nil {
+			return nil, nil, err
+		}
+		rehabCostSource = RehabCostSourceRehabItems
+	}
+	if req.UseRentRoll {
+		if req.MonthlyRent, err = marketRentTotal(r.db, property.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	result := r.arvService.CalculateARV(req.ArvRequest)
+	var grossRentMultiplier, rentToPriceRatio *float64
+	if result.QuickScreen != nil {
+		grossRentMultiplier = result.QuickScreen.GrossRentMultiplier
+		rentToPriceRatio = result.QuickScreen.RentToPriceRatio
+	}
+
+	calc, err := scanArvCalculation(r.db.QueryRow(`
+		INSERT INTO arv_calculations (
+			property_id, tenant_id, purchase_price, rehab_cost, holding_costs, closing_costs, arv, max_offer,
+			total_investment, monthly_cash_flow, cash_on_cash_return, is_infinite_return, cap_rate, dscr, risk_level,
+			rehab_cost_source, gross_rent_multiplier, rent_to_price_ratio
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		RETURNING `+arvCalculationColumns,
+		property.ID, tenantID, models.MoneyFromFloat(result.PurchasePrice), models.MoneyFromFloat(result.RehabCost),
+		models.MoneyFromFloat(result.HoldingCosts), models.MoneyFromFloat(result.ClosingCosts), models.MoneyFromFloat(result.ARV),
+		models.MoneyFromFloat(result.MaxOffer70), models.MoneyFromFloat(result.TotalInvestment), models.MoneyFromFloat(result.MonthlyCashFlow),
+		result.CashOnCashReturn, result.IsInfiniteReturn, result.CapRate, result.DSCR, result.RiskLevel, rehabCostSource, grossRentMultiplier, rentToPriceRatio,
+	))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to save ARV calculation: %w", err)
+	}
+	return calc, &result, nil
+}
+
+// latest returns the calculation most recently saved for a property, or nil
+// if none has been
+func (r *ArvCalculationRepository) latest(propertyID string) (*models.ArvCalculation, error) {
+	calc, err := scanArvCalculation(r.db.QueryRow(`
+		SELECT `+arvCalculationColumns+` FROM arv_calculations
+		WHERE property_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT 1
+	`, propertyID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load latest ARV calculation: %w", err)
+	}
+	return calc, nil
+}
+
+// scanArvCalculation reads a row selected with arvCalculationColumns
+func scanArvCalculation(row interface{ Scan(...interface{}) error }) (*models.ArvCalculation, error) {
+	var a models.ArvCalculation
+	err := row.Scan(
+		&a.ID, &a.PropertyID, &a.TenantID, &a.PurchasePrice, &a.RehabCost,
+		&a.HoldingCosts, &a.ClosingCosts, &a.ARV, &a.MaxOffer, &a.PotentialProfit,
+		&a.ProfitMargin, &a.TotalInvestment, &a.MonthlyCashFlow,
+		&a.CashOnCashReturn, &a.IsInfiniteReturn, &a.CapRate, &a.DSCR, &a.RiskLevel, &a.RehabCostSource,
+		&a.GrossRentMultiplier, &a.RentToPriceRatio, &a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
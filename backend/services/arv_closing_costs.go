@@ -0,0 +1,164 @@
+package services
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Bundled buyer closing cost schedule, replaced by the JSON file at
+// CLOSING_COST_SCHEDULE
+//
+//go:embed closing_cost_schedule.json
+var bundledClosingCostSchedule []byte
+
+// TitleInsuranceTier charges RatePerThousand on the part of the price up to
+// UpTo. The last tier has no UpTo and covers the rest.
+type TitleInsuranceTier struct {
+	UpTo            float64 `json:"up_to"`
+	RatePerThousand float64 `json:"rate_per_thousand"`
+}
+
+// ClosingCostSchedule is what a buyer typically pays to close. Transfer tax
+// rates are the buyer's customary share, as a percentage of the price, by
+// state; states that aren't listed use DefaultTransferTaxRate. Lender fees
+// are only charged when the purchase is financed.
+type ClosingCostSchedule struct {
+	TitleInsuranceTiers    []TitleInsuranceTier `json:"title_insurance_tiers"`
+	RecordingFees          float64              `json:"recording_fees"`
+	EscrowFee              float64              `json:"escrow_fee"`
+	LenderFees             float64              `json:"lender_fees"`
+	DefaultTransferTaxRate float64              `json:"default_transfer_tax_rate"`
+	TransferTaxRates       map[string]float64   `json:"transfer_tax_rates"`
+}
+
+// ClosingCostEstimate itemizes the closing costs estimated for a purchase
+type ClosingCostEstimate struct {
+	State           string  `json:"state"`
+	TitleInsurance  float64 `json:"title_insurance"`
+	RecordingFees   float64 `json:"recording_fees"`
+	EscrowFee       float64 `json:"escrow_fee"`
+	LenderFees      float64 `json:"lender_fees"`
+	TransferTaxRate float64 `json:"transfer_tax_rate"` // percentage
+	TransferTax     float64 `json:"transfer_tax"`
+	Total           float64 `json:"total"`
+}
+
+// ParseClosingCostSchedule reads a closing cost schedule from JSON
+func ParseClosingCostSchedule(data []byte) (*ClosingCostSchedule, error) {
+	var schedule ClosingCostSchedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("failed to parse closing cost schedule: %w", err)
+	}
+	rates := make(map[string]float64, len(schedule.TransferTaxRates))
+	for state, rate := range schedule.TransferTaxRates {
+		rates[strings.ToUpper(state)] = rate
+	}
+	schedule.TransferTaxRates = rates
+	return &schedule, nil
+}
+
+// defaultClosingCostSchedule returns the bundled schedule
+func defaultClosingCostSchedule() *ClosingCostSchedule {
+	schedule, err := ParseClosingCostSchedule(bundledClosingCostSchedule)
+	if err != nil {
+		panic(err)
+	}
+	return schedule
+}
+
+// closingCostScheduleFromEnv loads the schedule at CLOSING_COST_SCHEDULE,
+// falling back to the bundled one when it's unset or can't be read
+func closingCostScheduleFromEnv() *ClosingCostSchedule {
+	path := os.Getenv("CLOSING_COST_SCHEDULE")
+	if path == "" {
+		return defaultClosingCostSchedule()
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var schedule *ClosingCostSchedule
+		if schedule, err = ParseClosingCostSchedule(data); err == nil {
+			return schedule
+		}
+	}
+	log.Printf("Using the bundled closing cost schedule: %v", err)
+	return defaultClosingCostSchedule()
+}
+
+// Estimate works out a buyer's closing costs on price in state
+func (c *ClosingCostSchedule) Estimate(price float64, state string, financed bool) ClosingCostEstimate {
+	state = strings.ToUpper(strings.TrimSpace(state))
+	estimate := ClosingCostEstimate{
+		State:         state,
+		RecordingFees: c.RecordingFees,
+		EscrowFee:     c.EscrowFee,
+	}
+
+	floor := 0.0
+	for _, tier := range c.TitleInsuranceTiers {
+		top := price
+		if tier.UpTo > 0 && tier.UpTo < price {
+			top = tier.UpTo
+		}
+		if top > floor {
+			estimate.TitleInsurance += (top - floor) / 1000 * tier.RatePerThousand
+		}
+		if tier.UpTo == 0 || tier.UpTo >= price {
+			break
+		}
+		floor = tier.UpTo
+	}
+
+	if financed {
+		estimate.LenderFees = c.LenderFees
+	}
+
+	rate, ok := c.TransferTaxRates[state]
+	if !ok {
+		rate = c.DefaultTransferTaxRate
+	}
+	estimate.TransferTaxRate = rate
+	estimate.TransferTax = price * rate / 100
+
+	estimate.TitleInsurance = roundCents(estimate.TitleInsurance)
+	estimate.TransferTax = roundCents(estimate.TransferTax)
+	estimate.Total = roundCents(estimate.TitleInsurance + estimate.RecordingFees + estimate.EscrowFee +
+		estimate.LenderFees + estimate.TransferTax)
+	return estimate
+}
+
+// estimateClosingCosts fills in req's closing costs from the schedule when
+// it asked for an estimate and didn't give its own
+func (s *ArvService) estimateClosingCosts(req *ArvRequest, result *ArvResult) {
+	if !req.EstimateClosingCosts || req.ClosingCosts > 0 {
+		return
+	}
+	schedule := s.closingCosts
+	if schedule == nil {
+		schedule = defaultClosingCostSchedule()
+	}
+
+	estimate := schedule.Estimate(req.PurchasePrice, req.State, req.HardMoneyLTC > 0)
+	req.ClosingCosts = estimate.Total
+	result.ClosingCosts = estimate.Total
+	result.ClosingCostEstimate = &estimate
+
+	notice := Notice{Code: WarnClosingCostsEstimated, Severity: SeverityInfo, Field: "closing_costs"}
+	if _, ok := schedule.TransferTaxRates[estimate.State]; !ok {
+		notice.Message = fmt.Sprintf(
+			"Closing costs estimated from default fees, with a %g%% transfer tax since there's no rate for the state - verify with a title company",
+			estimate.TransferTaxRate)
+	} else {
+		notice.Message = fmt.Sprintf(
+			"Closing costs estimated from default fees and %s's transfer tax - verify with a title company", estimate.State)
+	}
+	result.Warnings = append(result.Warnings, notice)
+	heuristic := "Buyer closing cost schedule"
+	if estimate.State != "" {
+		heuristic += " for " + estimate.State
+	}
+	result.assume("closing_costs", estimate.Total, heuristic)
+}
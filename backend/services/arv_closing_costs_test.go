@@ -0,0 +1,103 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func closingCostRequest(state string) ArvRequest {
+	return ArvRequest{
+		PurchasePrice:        300000,
+		RehabCost:            40000,
+		ARV:                  450000,
+		MonthlyRent:          3200,
+		EstimateClosingCosts: true,
+		State:                state,
+	}
+}
+
+func TestCalculateARV_EstimatesClosingCostsByState(t *testing.T) {
+	service := NewArvService()
+
+	texas := service.CalculateARV(closingCostRequest("TX"))
+	delaware := service.CalculateARV(closingCostRequest("de"))
+
+	// Title insurance is 5.75 per 1,000 on the first 100,000 and 5.00 on
+	// the next 200,000, plus 250 recording and 750 escrow
+	require.NotNil(t, texas.ClosingCostEstimate)
+	assert.Equal(t, 1575.0, texas.ClosingCostEstimate.TitleInsurance)
+	assert.Equal(t, 250.0, texas.ClosingCostEstimate.RecordingFees)
+	assert.Equal(t, 750.0, texas.ClosingCostEstimate.EscrowFee)
+	assert.Equal(t, 0.0, texas.ClosingCostEstimate.LenderFees, "bought with cash")
+
+	// Texas has no transfer tax, while a Delaware buyer pays half of 4%
+	assert.Equal(t, 0.0, texas.ClosingCostEstimate.TransferTax)
+	assert.Equal(t, 2575.0, texas.ClosingCosts)
+	require.NotNil(t, delaware.ClosingCostEstimate)
+	assert.Equal(t, "DE", delaware.ClosingCostEstimate.State)
+	assert.Equal(t, 2.0, delaware.ClosingCostEstimate.TransferTaxRate)
+	assert.Equal(t, 6000.0, delaware.ClosingCostEstimate.TransferTax)
+	assert.Equal(t, 8575.0, delaware.ClosingCosts)
+
+	assert.Equal(t, 342575.0, texas.TotalInvestment)
+	assert.Equal(t, 348575.0, delaware.TotalInvestment)
+	assert.Contains(t, NoticeMessages(delaware.Warnings),
+		"Closing costs estimated from default fees and DE's transfer tax - verify with a title company")
+}
+
+func TestCalculateARV_ManualClosingCostsWin(t *testing.T) {
+	service := NewArvService()
+	req := closingCostRequest("DE")
+	req.ClosingCosts = 5000
+
+	result := service.CalculateARV(req)
+
+	assert.Nil(t, result.ClosingCostEstimate)
+	assert.Equal(t, 5000.0, result.ClosingCosts)
+	assert.Equal(t, 345000.0, result.TotalInvestment)
+}
+
+func TestCalculateARV_ClosingCostsForUnlistedStateAndFinancedPurchase(t *testing.T) {
+	service := NewArvService()
+	req := closingCostRequest("OH")
+	req.HardMoneyLTC = 80
+
+	result := service.CalculateARV(req)
+
+	require.NotNil(t, result.ClosingCostEstimate)
+	assert.Equal(t, 0.5, result.ClosingCostEstimate.TransferTaxRate)
+	assert.Equal(t, 1500.0, result.ClosingCostEstimate.TransferTax)
+	assert.Equal(t, 1500.0, result.ClosingCostEstimate.LenderFees)
+	assert.Equal(t, 5575.0, result.ClosingCosts)
+	assert.Contains(t, NoticeMessages(result.Warnings),
+		"Closing costs estimated from default fees, with a 0.5% transfer tax since there's no rate for the state - verify with a title company")
+}
+
+func TestClosingCostSchedule_TitleInsuranceTiers(t *testing.T) {
+	schedule := defaultClosingCostSchedule()
+
+	// 575 + 2,000 on 100,000-500,000 + 2.50 per 1,000 on the last 250,000
+	assert.Equal(t, 3200.0, schedule.Estimate(750000, "TX", false).TitleInsurance)
+	assert.Equal(t, 287.5, schedule.Estimate(50000, "TX", false).TitleInsurance)
+}
+
+func TestNewArvService_ClosingCostScheduleFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"title_insurance_tiers": [{"rate_per_thousand": 4}],
+		"recording_fees": 100,
+		"transfer_tax_rates": {"ny": 0.4}
+	}`), 0o600))
+	t.Setenv("CLOSING_COST_SCHEDULE", path)
+
+	result := NewArvService().CalculateARV(closingCostRequest("NY"))
+
+	require.NotNil(t, result.ClosingCostEstimate)
+	assert.Equal(t, 1200.0, result.ClosingCostEstimate.TitleInsurance)
+	assert.Equal(t, 1200.0, result.ClosingCostEstimate.TransferTax)
+	assert.Equal(t, 2500.0, result.ClosingCosts)
+}
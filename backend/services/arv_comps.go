@@ -0,0 +1,386 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultMaxCompAgeMonths is how old a comp's sale can be before it's
+// down-weighted, when the caller doesn't say
+const defaultMaxCompAgeMonths = 12
+
+// defaultOutlierMADs is how many median absolute deviations a comp's
+// adjusted price per square foot can be from the median before it's left
+// out as an outlier, when the caller doesn't say
+const defaultOutlierMADs = 2.0
+
+// minCompsForOutliers is how many comps it takes to tell an outlier apart
+const minCompsForOutliers = 3
+
+// Default comp adjustments, used when neither the request nor the
+// tenant's settings give one
+const (
+	defaultAdjustmentPerBedroom  = 5000.0
+	defaultAdjustmentPerBathroom = 3000.0
+	defaultAdjustmentPerSqFt     = 50.0
+)
+
+// Why a comp was left out of an estimate
+const (
+	CompExcludedStale   = "stale"
+	CompExcludedOutlier = "outlier"
+)
+
+// Comp estimate confidence levels
+const (
+	ConfidenceHigh   = "high"
+	ConfidenceMedium = "medium"
+	ConfidenceLow    = "low"
+)
+
+// compConfidenceThresholds are what a set of comps needs for a confidence
+// level: enough comps, adjusted values that agree (their standard deviation
+// as a percentage of the estimate) and none too far away (miles)
+var compConfidenceThresholds = []struct {
+	level       string
+	minComps    int
+	maxSpread   float64
+	maxDistance float64
+}{
+	{ConfidenceHigh, 3, 5, 1},
+	{ConfidenceMedium, 2, 10, 3},
+}
+
+// CompAdjustmentSchedule is what each difference between a comp and the
+// subject property is worth. With DerivePerSqFt, the square footage
+// adjustment is the comps' own median price per square foot instead of
+// PerSqFt.
+type CompAdjustmentSchedule struct {
+	PerBedroom    float64 `json:"per_bedroom"`
+	PerBathroom   float64 `json:"per_bathroom"`
+	PerSqFt       float64 `json:"per_sqft"`
+	DerivePerSqFt bool    `json:"derive_per_sqft"`
+}
+
+// DefaultCompAdjustmentSchedule returns the built-in adjustment schedule
+func DefaultCompAdjustmentSchedule() CompAdjustmentSchedule {
+	return CompAdjustmentSchedule{
+		PerBedroom:  defaultAdjustmentPerBedroom,
+		PerBathroom: defaultAdjustmentPerBathroom,
+		PerSqFt:     defaultAdjustmentPerSqFt,
+	}
+}
+
+// CompAdjustmentOverrides replace parts of an adjustment schedule. Fields
+// left out keep the schedule's value.
+type CompAdjustmentOverrides struct {
+	PerBedroom    *float64 `json:"per_bedroom" binding:"omitempty,min=0"`
+	PerBathroom   *float64 `json:"per_bathroom" binding:"omitempty,min=0"`
+	PerSqFt       *float64 `json:"per_sqft" binding:"omitempty,min=0"`
+	DerivePerSqFt *bool    `json:"derive_per_sqft"`
+}
+
+// override returns schedule with overrides' fields in place of its own
+func (schedule CompAdjustmentSchedule) override(overrides *CompAdjustmentOverrides) CompAdjustmentSchedule {
+	if overrides == nil {
+		return schedule
+	}
+	if overrides.PerBedroom != nil {
+		schedule.PerBedroom = *overrides.PerBedroom
+	}
+	if overrides.PerBathroom != nil {
+		schedule.PerBathroom = *overrides.PerBathroom
+	}
+	if overrides.PerSqFt != nil {
+		schedule.PerSqFt = *overrides.PerSqFt
+	}
+	if overrides.DerivePerSqFt != nil {
+		schedule.DerivePerSqFt = *overrides.DerivePerSqFt
+	}
+	return schedule
+}
+
+// forComps returns schedule with its square footage adjustment derived
+// from comps when it asks for one. Comps without a square footage don't
+// count, and PerSqFt is kept when none have one.
+func (schedule CompAdjustmentSchedule) forComps(comps []ComparableProperty) CompAdjustmentSchedule {
+	if !schedule.DerivePerSqFt {
+		return schedule
+	}
+	var pricesPerSqFt []float64
+	for _, comp := range comps {
+		if comp.SquareFeet > 0 {
+			pricesPerSqFt = append(pricesPerSqFt, comp.SalePrice/float64(comp.SquareFeet))
+		}
+	}
+	if len(pricesPerSqFt) > 0 {
+		schedule.PerSqFt = roundCents(median(pricesPerSqFt))
+	}
+	return schedule
+}
+
+// CompEstimateOptions tune how comps are adjusted and which count.
+// Appreciation compounds monthly from the sale date to AsOf;
+// AnnualAppreciationRate is converted to a monthly rate when no monthly one
+// is given. Comps that sold more than MaxAgeMonths ago are down-weighted in
+// proportion to their age, or left out with ExcludeStale. Comps whose
+// adjusted price per square foot is more than OutlierMADs median absolute
+// deviations from the median are left out, once there are enough to tell.
+// Adjustments override parts of Schedule, the tenant's adjustment
+// schedule, which is the built-in one when nil.
+type CompEstimateOptions struct {
+	MonthlyAppreciationRate float64                  `json:"monthly_appreciation_rate" binding:"min=-5,max=5"`  // percentage
+	AnnualAppreciationRate  float64                  `json:"annual_appreciation_rate" binding:"min=-50,max=50"` // percentage
+	MaxAgeMonths            int                      `json:"max_comp_age_months" binding:"min=0,max=120"`       // default 12
+	ExcludeStale            bool                     `json:"exclude_stale_comps"`
+	OutlierMADs             float64                  `json:"outlier_mads" binding:"min=0,max=10"` // default 2
+	AsOf                    time.Time                `json:"-"`                                   // default now
+	Adjustments             *CompAdjustmentOverrides `json:"adjustments"`
+	Schedule                *CompAdjustmentSchedule  `json:"-"`
+}
+
+// adjustmentSchedule resolves the schedule comps are adjusted by: the
+// overrides, then the tenant's schedule, then the built-in one
+func (o CompEstimateOptions) adjustmentSchedule(comps []ComparableProperty) CompAdjustmentSchedule {
+	schedule := DefaultCompAdjustmentSchedule()
+	if o.Schedule != nil {
+		schedule = *o.Schedule
+	}
+	return schedule.override(o.Adjustments).forComps(comps)
+}
+
+// monthlyRate returns the appreciation rate per month as a fraction
+func (o CompEstimateOptions) monthlyRate() float64 {
+	if o.MonthlyAppreciationRate != 0 {
+		return o.MonthlyAppreciationRate / 100
+	}
+	return math.Pow(1+o.AnnualAppreciationRate/100, 1.0/12) - 1
+}
+
+// CompAdjustments itemizes how a comp's sale price is adjusted towards the
+// subject property. Market brings the price forward to today.
+type CompAdjustments struct {
+	Market     float64 `json:"market"`
+	Bedrooms   float64 `json:"bedrooms"`
+	Bathrooms  float64 `json:"bathrooms"`
+	SquareFeet float64 `json:"square_feet"`
+	Total      float64 `json:"total"`
+}
+
+// CompBreakdown is one comp's part in an ARV estimate. Weight is its share
+// of the estimate as a percentage; closer and more recent comps count for
+// more, and excluded ones for nothing.
+type CompBreakdown struct {
+	Address              string          `json:"address"`
+	SalePrice            float64         `json:"sale_price"`
+	MonthsSinceSale      *int            `json:"months_since_sale"` // null when the sale date is unknown
+	Distance             float64         `json:"distance"`
+	PricePerSqFt         float64         `json:"price_per_sqft"`
+	Adjustments          CompAdjustments `json:"adjustments"`
+	AdjustedValue        float64         `json:"adjusted_value"`
+	AdjustedPricePerSqFt float64         `json:"adjusted_price_per_sqft"`
+	Weight               float64         `json:"weight"`
+	Excluded             bool            `json:"excluded"`
+	ExcludedReason       string          `json:"excluded_reason,omitempty"`
+	Warnings             []string        `json:"warnings,omitempty"`
+}
+
+// CompsEstimate is an ARV estimated from comps, with how each comp
+// contributed and how far the comps agree
+type CompsEstimate struct {
+	EstimatedARV        float64         `json:"estimated_arv"` // distance-weighted
+	MedianAdjustedValue float64         `json:"median_adjusted_value"`
+	StandardDeviation   float64         `json:"standard_deviation"` // of the adjusted values
+	Confidence          string          `json:"confidence"`
+	Comparables         []CompBreakdown `json:"comparables"`
+
+	// AdjustmentSchedule is what the comps were adjusted by, with PerSqFt
+	// as derived when it was
+	AdjustmentSchedule CompAdjustmentSchedule `json:"adjustment_schedule"`
+}
+
+// EstimateARVFromCompsBreakdown estimates ARV from comps, brought forward
+// to today's market, itemizing each comp's adjustments and weight and
+// leaving out stale comps and outliers as options says
+func (s *ArvService) EstimateARVFromCompsBreakdown(comps []ComparableProperty, subjectBedrooms int, subjectBathrooms float64, subjectSquareFeet int, options CompEstimateOptions) CompsEstimate {
+	estimate := CompsEstimate{
+		Confidence:         ConfidenceLow,
+		Comparables:        make([]CompBreakdown, 0, len(comps)),
+		AdjustmentSchedule: options.adjustmentSchedule(comps),
+	}
+	if options.AsOf.IsZero() {
+		options.AsOf = time.Now()
+	}
+	if options.MaxAgeMonths == 0 {
+		options.MaxAgeMonths = defaultMaxCompAgeMonths
+	}
+	if options.OutlierMADs == 0 {
+		options.OutlierMADs = defaultOutlierMADs
+	}
+	appreciating := options.MonthlyAppreciationRate != 0 || options.AnnualAppreciationRate != 0
+
+	weights := make([]float64, len(comps))
+	for i, comp := range comps {
+		breakdown := CompBreakdown{
+			Address:     comp.Address,
+			SalePrice:   comp.SalePrice,
+			Distance:    comp.Distance,
+			Adjustments: s.itemizeComparableAdjustments(comp, subjectBedrooms, subjectBathrooms, float64(subjectSquareFeet), estimate.AdjustmentSchedule),
+		}
+		if comp.SquareFeet > 0 {
+			breakdown.PricePerSqFt = roundCents(comp.SalePrice / float64(comp.SquareFeet))
+		}
+
+		// Weight by distance (closer properties have more weight)
+		weights[i] = 1.0 / (1.0 + comp.Distance)
+
+		saleDate, err := time.Parse("2006-01-02", strings.TrimSpace(comp.SaleDate))
+		switch {
+		case err == nil:
+			months := monthsBetween(saleDate, options.AsOf)
+			breakdown.MonthsSinceSale = &months
+			breakdown.Adjustments.Market = roundCents(comp.SalePrice * (math.Pow(1+options.monthlyRate(), float64(months)) - 1))
+			breakdown.Adjustments.Total = roundCents(breakdown.Adjustments.Total + breakdown.Adjustments.Market)
+			if months > options.MaxAgeMonths {
+				if options.ExcludeStale {
+					breakdown.Excluded = true
+					breakdown.ExcludedReason = CompExcludedStale
+					weights[i] = 0
+					breakdown.Warnings = append(breakdown.Warnings, fmt.Sprintf("Sold %d months ago, more than %d - left out", months, options.MaxAgeMonths))
+				} else {
+					weights[i] *= float64(options.MaxAgeMonths) / float64(months)
+					breakdown.Warnings = append(breakdown.Warnings, fmt.Sprintf("Sold %d months ago, more than %d - down-weighted", months, options.MaxAgeMonths))
+				}
+			}
+		case comp.SaleDate != "" || appreciating:
+			breakdown.Warnings = append(breakdown.Warnings, "Sale date couldn't be read - no market adjustment applied")
+		}
+
+		breakdown.AdjustedValue = roundCents(comp.SalePrice + breakdown.Adjustments.Total)
+		if comp.SquareFeet > 0 {
+			breakdown.AdjustedPricePerSqFt = roundCents(breakdown.AdjustedValue / float64(comp.SquareFeet))
+		}
+		estimate.Comparables = append(estimate.Comparables, breakdown)
+	}
+	excludeOutliers(estimate.Comparables, weights, options.OutlierMADs)
+
+	var adjustedValues []float64
+	totalWeight, weightedTotal, maxDistance := 0.0, 0.0, 0.0
+	for i, comp := range estimate.Comparables {
+		if comp.Excluded {
+			continue
+		}
+		adjustedValues = append(adjustedValues, comp.AdjustedValue)
+		totalWeight += weights[i]
+		weightedTotal += comp.AdjustedValue * weights[i]
+		maxDistance = math.Max(maxDistance, comp.Distance)
+	}
+	if totalWeight == 0 {
+		return estimate
+	}
+
+	for i := range estimate.Comparables {
+		estimate.Comparables[i].Weight = roundCents(weights[i] / totalWeight * 100)
+	}
+	estimate.EstimatedARV = roundCents(weightedTotal / totalWeight)
+	estimate.MedianAdjustedValue = roundCents(median(adjustedValues))
+	estimate.StandardDeviation = roundCents(standardDeviation(adjustedValues))
+
+	spread := 0.0
+	if estimate.EstimatedARV > 0 {
+		spread = estimate.StandardDeviation / estimate.EstimatedARV * 100
+	}
+	estimate.Confidence = compConfidence(len(adjustedValues), spread, maxDistance)
+	return estimate
+}
+
+// excludeOutliers leaves out comps whose adjusted price per square foot is
+// more than mads median absolute deviations from the median, zeroing their
+// weights. Comps already left out, or without a square footage, aren't
+// considered, and nothing is left out without enough comps to compare.
+func excludeOutliers(comps []CompBreakdown, weights []float64, mads float64) {
+	var candidates []int
+	var values []float64
+	for i, comp := range comps {
+		if !comp.Excluded && comp.AdjustedPricePerSqFt > 0 {
+			candidates = append(candidates, i)
+			values = append(values, comp.AdjustedPricePerSqFt)
+		}
+	}
+	if len(candidates) < minCompsForOutliers {
+		return
+	}
+
+	middle := median(values)
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - middle)
+	}
+	mad := median(deviations)
+	if mad == 0 {
+		return
+	}
+
+	for i, index := range candidates {
+		if distance := deviations[i] / mad; distance > mads {
+			comps[index].Excluded = true
+			comps[index].ExcludedReason = CompExcludedOutlier
+			weights[index] = 0
+			comps[index].Warnings = append(comps[index].Warnings, fmt.Sprintf(
+				"Adjusted $%.2f/sqft is %.1f median absolute deviations from the median $%.2f/sqft - left out as an outlier",
+				values[i], distance, middle))
+		}
+	}
+}
+
+// monthsBetween counts the whole months from one date to a later one
+func monthsBetween(from, to time.Time) int {
+	months := (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+	if to.Day() < from.Day() {
+		months--
+	}
+	if months < 0 {
+		return 0
+	}
+	return months
+}
+
+// compConfidence rates how far an estimate from count comps, spread and at
+// most maxDistance away can be relied on
+func compConfidence(count int, spread, maxDistance float64) string {
+	for _, threshold := range compConfidenceThresholds {
+		if count >= threshold.minComps && spread <= threshold.maxSpread && maxDistance <= threshold.maxDistance {
+			return threshold.level
+		}
+	}
+	return ConfidenceLow
+}
+
+// median returns the middle of values, or the mean of the middle two
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// standardDeviation returns the population standard deviation of values
+func standardDeviation(values []float64) float64 {
+	mean := 0.0
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)))
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateARVFromCompsBreakdown(t *testing.T) {
+	service := NewArvService()
+	comps := []ComparableProperty{
+		{Address: "1 Oak St", SalePrice: 100000, Distance: 0.5, Bedrooms: 3, Bathrooms: 2.0, SquareFeet: 1200},
+		{Address: "2 Oak St", SalePrice: 95000, Distance: 1.0, Bedrooms: 3, Bathrooms: 1.5, SquareFeet: 1100},
+		{Address: "3 Oak St", SalePrice: 104000, Distance: 0.8, Bedrooms: 4, Bathrooms: 2.0, SquareFeet: 1300},
+	}
+
+	estimate := service.EstimateARVFromCompsBreakdown(comps, 3, 2.0, 1200, CompEstimateOptions{})
+
+	assert.Equal(t, 98500.0, estimate.EstimatedARV)
+	assert.Equal(t, 100000.0, estimate.MedianAdjustedValue)
+	assert.Equal(t, 3240.37, estimate.StandardDeviation)
+	assert.Equal(t, ConfidenceHigh, estimate.Confidence) // 3 comps within a mile, 3.3% spread
+	assert.Equal(t, estimate.EstimatedARV, service.EstimateARVFromComps(comps, 3, 2.0, 1200))
+
+	require.Len(t, estimate.Comparables, 3)
+	second := estimate.Comparables[1]
+	assert.Equal(t, "2 Oak St", second.Address)
+	assert.Equal(t, CompAdjustments{Bedrooms: 0, Bathrooms: 1500, SquareFeet: 5000, Total: 6500}, second.Adjustments)
+	assert.Equal(t, 101500.0, second.AdjustedValue)
+	assert.Equal(t, 86.36, second.PricePerSqFt)
+
+	third := estimate.Comparables[2]
+	assert.Equal(t, CompAdjustments{Bedrooms: -5000, Bathrooms: 0, SquareFeet: -5000, Total: -10000}, third.Adjustments)
+
+	weights := 0.0
+	for _, comp := range estimate.Comparables {
+		assert.Equal(t, comp.Adjustments.Total, comp.Adjustments.Bedrooms+comp.Adjustments.Bathrooms+comp.Adjustments.SquareFeet)
+		assert.Equal(t, comp.AdjustedValue, comp.SalePrice+comp.Adjustments.Total)
+		weights += comp.Weight
+	}
+	assert.InDelta(t, 100, weights, 0.01)
+	assert.Equal(t, []float64{38.71, 29.03, 32.26}, []float64{
+		estimate.Comparables[0].Weight, estimate.Comparables[1].Weight, estimate.Comparables[2].Weight,
+	})
+}
+
+func TestEstimateARVFromCompsBreakdown_NoComps(t *testing.T) {
+	service := NewArvService()
+
+	estimate := service.EstimateARVFromCompsBreakdown(nil, 3, 2.0, 1200, CompEstimateOptions{})
+
+	assert.Zero(t, estimate.EstimatedARV)
+	assert.Equal(t, ConfidenceLow, estimate.Confidence)
+	assert.NotNil(t, estimate.Comparables)
+}
+
+func TestCompConfidence(t *testing.T) {
+	tests := []struct {
+		name        string
+		count       int
+		spread      float64
+		maxDistance float64
+		want        string
+	}{
+		{"close agreeing comps", 3, 5, 1, ConfidenceHigh},
+		{"too few for high", 2, 2, 0.5, ConfidenceMedium},
+		{"spread too wide for high", 4, 8, 0.5, ConfidenceMedium},
+		{"too far for high", 5, 2, 2.5, ConfidenceMedium},
+		{"one comp", 1, 0, 0.2, ConfidenceLow},
+		{"spread too wide", 4, 12, 0.5, ConfidenceLow},
+		{"too far", 4, 2, 4, ConfidenceLow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, compConfidence(tt.count, tt.spread, tt.maxDistance))
+		})
+	}
+}
+
+// agedComps are the subject's twins, sold 1, 6 and 14 months before
+// 2026-06-15
+func agedComps() []ComparableProperty {
+	comp := ComparableProperty{SalePrice: 300000, Distance: 0.5, Bedrooms: 3, Bathrooms: 2, SquareFeet: 1500}
+	comps := make([]ComparableProperty, 3)
+	for i, saleDate := range []string{"2026-05-15", "2025-12-15", "2025-04-10"} {
+		comps[i] = comp
+		comps[i].SaleDate = saleDate
+	}
+	return comps
+}
+
+var compsAsOf = time.Date(2026, 6, 15, 0, 0, 0, 0, time.UTC)
+
+func TestEstimateARVFromCompsBreakdown_MarketAdjustment(t *testing.T) {
+	service := NewArvService()
+
+	estimate := service.EstimateARVFromCompsBreakdown(agedComps(), 3, 2, 1500, CompEstimateOptions{
+		MonthlyAppreciationRate: 0.5,
+		AsOf:                    compsAsOf,
+	})
+
+	require.Len(t, estimate.Comparables, 3)
+	var months []int
+	for _, comp := range estimate.Comparables {
+		require.NotNil(t, comp.MonthsSinceSale)
+		months = append(months, *comp.MonthsSinceSale)
+	}
+	assert.Equal(t, []int{1, 6, 14}, months)
+
+	// 0.5% a month compounds on the sale price
+	assert.Equal(t, 1500.0, estimate.Comparables[0].Adjustments.Market)
+	assert.Equal(t, 9113.25, estimate.Comparables[1].Adjustments.Market)
+	assert.Equal(t, 21696.34, estimate.Comparables[2].Adjustments.Market)
+	assert.Equal(t, 321696.34, estimate.Comparables[2].AdjustedValue)
+	assert.Empty(t, estimate.Comparables[1].Warnings)
+
+	// The 14-month-old sale counts for 12/14 of the others
+	assert.Equal(t, []float64{35, 35, 30}, []float64{
+		estimate.Comparables[0].Weight, estimate.Comparables[1].Weight, estimate.Comparables[2].Weight,
+	})
+	assert.Equal(t, []string{"Sold 14 months ago, more than 12 - down-weighted"}, estimate.Comparables[2].Warnings)
+	assert.Equal(t, 310223.54, estimate.EstimatedARV)
+}
+
+func TestEstimateARVFromCompsBreakdown_ExcludeStale(t *testing.T) {
+	service := NewArvService()
+
+	estimate := service.EstimateARVFromCompsBreakdown(agedComps(), 3, 2, 1500, CompEstimateOptions{
+		MonthlyAppreciationRate: 0.5,
+		ExcludeStale:            true,
+		AsOf:                    compsAsOf,
+	})
+
+	stale := estimate.Comparables[2]
+	assert.True(t, stale.Excluded)
+	assert.Zero(t, stale.Weight)
+	assert.Equal(t, []string{"Sold 14 months ago, more than 12 - left out"}, stale.Warnings)
+	assert.Equal(t, 305306.63, estimate.EstimatedARV)
+	assert.Equal(t, 305306.63, estimate.MedianAdjustedValue)
+
+	// A longer cutoff keeps it at full weight
+	estimate = service.EstimateARVFromCompsBreakdown(agedComps(), 3, 2, 1500, CompEstimateOptions{
+		ExcludeStale: true,
+		MaxAgeMonths: 18,
+		AsOf:         compsAsOf,
+	})
+	assert.False(t, estimate.Comparables[2].Excluded)
+	assert.Equal(t, 300000.0, estimate.EstimatedARV)
+}
+
+func TestEstimateARVFromCompsBreakdown_AnnualRateAndBadDates(t *testing.T) {
+	service := NewArvService()
+	comps := agedComps()
+	comps[0].SaleDate = "last spring"
+
+	estimate := service.EstimateARVFromCompsBreakdown(comps, 3, 2, 1500, CompEstimateOptions{
+		AnnualAppreciationRate: 6,
+		AsOf:                   compsAsOf,
+	})
+
+	unread := estimate.Comparables[0]
+	assert.Nil(t, unread.MonthsSinceSale)
+	assert.Zero(t, unread.Adjustments.Market)
+	assert.Equal(t, 300000.0, unread.AdjustedValue)
+	assert.Equal(t, []string{"Sale date couldn't be read - no market adjustment applied"}, unread.Warnings)
+
+	// 6% a year is about 0.487% a month
+	assert.Equal(t, 8868.9, estimate.Comparables[1].Adjustments.Market)
+}
+
+// compsWithForeclosure are four of the subject's twins and a foreclosure
+// that sold for half as much
+func compsWithForeclosure() []ComparableProperty {
+	var comps []ComparableProperty
+	for i, price := range []float64{300000, 305000, 295000, 307500, 150000} {
+		comps = append(comps, ComparableProperty{
+			Address:    fmt.Sprintf("%d Elm St", i+1),
+			SalePrice:  price,
+			Distance:   0.5,
+			Bedrooms:   3,
+			Bathrooms:  2,
+			SquareFeet: 1500,
+		})
+	}
+	return comps
+}
+
+func TestEstimateARVFromCompsBreakdown_ExcludesOutliers(t *testing.T) {
+	service := NewArvService()
+
+	estimate := service.EstimateARVFromCompsBreakdown(compsWithForeclosure(), 3, 2, 1500, CompEstimateOptions{})
+
+	foreclosure := estimate.Comparables[4]
+	assert.True(t, foreclosure.Excluded)
+	assert.Equal(t, CompExcludedOutlier, foreclosure.ExcludedReason)
+	assert.Equal(t, 100.0, foreclosure.AdjustedPricePerSqFt)
+	assert.Zero(t, foreclosure.Weight)
+	assert.Equal(t, []string{
+		"Adjusted $100.00/sqft is 30.0 median absolute deviations from the median $200.00/sqft - left out as an outlier",
+	}, foreclosure.Warnings)
+
+	// $205/sqft is 1.5 deviations out, so it stays
+	assert.False(t, estimate.Comparables[3].Excluded)
+	assert.Equal(t, 301875.0, estimate.EstimatedARV)
+	assert.Equal(t, 302500.0, estimate.MedianAdjustedValue)
+
+	// A tighter threshold catches it too
+	estimate = service.EstimateARVFromCompsBreakdown(compsWithForeclosure(), 3, 2, 1500, CompEstimateOptions{OutlierMADs: 1.25})
+	assert.True(t, estimate.Comparables[3].Excluded)
+	assert.Equal(t, 300000.0, estimate.EstimatedARV)
+}
+
+func TestEstimateARVFromCompsBreakdown_NeedsThreeCompsForOutliers(t *testing.T) {
+	service := NewArvService()
+	comps := compsWithForeclosure()
+	comps = []ComparableProperty{comps[0], comps[4]}
+
+	estimate := service.EstimateARVFromCompsBreakdown(comps, 3, 2, 1500, CompEstimateOptions{})
+
+	for _, comp := range estimate.Comparables {
+		assert.False(t, comp.Excluded)
+	}
+	assert.Equal(t, 225000.0, estimate.EstimatedARV)
+}
+
+func TestEstimateARVFromCompsBreakdown_AdjustmentSchedule(t *testing.T) {
+	service := NewArvService()
+	comps := []ComparableProperty{
+		{Address: "1 Oak St", SalePrice: 100000, Distance: 0.5, Bedrooms: 3, Bathrooms: 2.0, SquareFeet: 1200},
+		{Address: "2 Oak St", SalePrice: 95000, Distance: 1.0, Bedrooms: 3, Bathrooms: 1.5, SquareFeet: 1100},
+		{Address: "3 Oak St", SalePrice: 104000, Distance: 0.8, Bedrooms: 4, Bathrooms: 2.0, SquareFeet: 1300},
+	}
+	tenant := CompAdjustmentSchedule{PerBedroom: 8000, PerBathroom: 4000, PerSqFt: 60}
+	zero, derive := 0.0, true
+
+	// Without a tenant schedule or overrides, the built-in one applies
+	estimate := service.EstimateARVFromCompsBreakdown(comps, 3, 2.0, 1200, CompEstimateOptions{})
+	assert.Equal(t, DefaultCompAdjustmentSchedule(), estimate.AdjustmentSchedule)
+
+	estimate = service.EstimateARVFromCompsBreakdown(comps, 3, 2.0, 1200, CompEstimateOptions{Schedule: &tenant})
+	assert.Equal(t, tenant, estimate.AdjustmentSchedule)
+	assert.Equal(t, CompAdjustments{Bathrooms: 2000, SquareFeet: 6000, Total: 8000}, estimate.Comparables[1].Adjustments)
+	assert.Equal(t, CompAdjustments{Bedrooms: -8000, SquareFeet: -6000, Total: -14000}, estimate.Comparables[2].Adjustments)
+
+	// Overrides replace only the fields they give, even with zero
+	estimate = service.EstimateARVFromCompsBreakdown(comps, 3, 2.0, 1200, CompEstimateOptions{
+		Schedule:    &tenant,
+		Adjustments: &CompAdjustmentOverrides{PerBedroom: &zero},
+	})
+	assert.Equal(t, CompAdjustmentSchedule{PerBathroom: 4000, PerSqFt: 60}, estimate.AdjustmentSchedule)
+	assert.Equal(t, CompAdjustments{SquareFeet: -6000, Total: -6000}, estimate.Comparables[2].Adjustments)
+
+	// The comps sold for $83.33, $86.36 and $80.00 a square foot
+	estimate = service.EstimateARVFromCompsBreakdown(comps, 3, 2.0, 1200, CompEstimateOptions{
+		Adjustments: &CompAdjustmentOverrides{DerivePerSqFt: &derive},
+	})
+	assert.Equal(t, 83.33, estimate.AdjustmentSchedule.PerSqFt)
+	assert.True(t, estimate.AdjustmentSchedule.DerivePerSqFt)
+	assert.Equal(t, CompAdjustments{Bathrooms: 1500, SquareFeet: 8333, Total: 9833}, estimate.Comparables[1].Adjustments)
+}
+
+func TestAdjustmentSchedule_DeriveWithoutSquareFootage(t *testing.T) {
+	schedule := CompAdjustmentSchedule{PerSqFt: 50, DerivePerSqFt: true}
+
+	derived := schedule.forComps([]ComparableProperty{{SalePrice: 100000}})
+
+	assert.Equal(t, 50.0, derived.PerSqFt, "comps without a square footage leave PerSqFt as it was")
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"fmt"
+	"math"
+)
+
+// CreativeFinanceRequest is a deal bought subject to the seller's existing
+// loan, with the seller carrying a note for part of the price. The buyer
+// takes over the existing loan's payment as it stands and pays the seller
+// note on its own amortization until the balloon. Leaving out
+// SellerCarryAmortizationYears makes the note interest-only.
+type CreativeFinanceRequest struct {
+	ArvRequest
+
+	ExistingLoanBalance float64 `json:"existing_loan_balance" binding:"min=0"`
+	ExistingLoanRate    float64 `json:"existing_loan_rate" binding:"min=0,max=30"` // annual percentage
+	ExistingLoanPayment float64 `json:"existing_loan_payment" binding:"min=0"`     // monthly principal and interest
+
+	SellerCarryAmount            float64 `json:"seller_carry_amount" binding:"min=0"`
+	SellerCarryRate              float64 `json:"seller_carry_rate" binding:"min=0,max=30"` // annual percentage
+	SellerCarryAmortizationYears int     `json:"seller_carry_amortization_years" binding:"min=0,max=40"`
+	SellerCarryBalloonMonth      int     `json:"seller_carry_balloon_month" binding:"min=0,max=360"`
+
+	Appreciation  float64 `json:"appreciation" binding:"min=-50,max=50"` // annual percentage, for the value at the balloon
+	SkipRefinance bool    `json:"skip_refinance"`
+}
+
+// BalloonProjection compares a seller note's balloon with the equity the
+// property is projected to have, over the existing loan, when it falls due
+type BalloonProjection struct {
+	Month               int     `json:"month"`
+	Balance             float64 `json:"balance"`
+	ProjectedValue      float64 `json:"projected_value"`
+	ExistingLoanBalance float64 `json:"existing_loan_balance"`
+	ProjectedEquity     float64 `json:"projected_equity"`
+	ExceedsEquity       bool    `json:"exceeds_equity"`
+}
+
+// CreativeFinanceResult is a subject-to or seller-financed deal's debt and
+// returns. Refinance is the regular BRRRR analysis, left out when the
+// refinance is skipped.
+type CreativeFinanceResult struct {
+	PurchasePrice             float64            `json:"purchase_price"`
+	ExistingLoanBalance       float64            `json:"existing_loan_balance"`
+	ExistingLoanPayment       float64            `json:"existing_loan_payment"`
+	SellerCarryAmount         float64            `json:"seller_carry_amount"`
+	SellerCarryPayment        float64            `json:"seller_carry_payment"`
+	BlendedMonthlyDebtService float64            `json:"blended_monthly_debt_service"`
+	BlendedRate               float64            `json:"blended_rate"` // balance-weighted annual percentage
+	CashToClose               float64            `json:"cash_to_close"`
+	NOI                       float64            `json:"noi"`
+	MonthlyCashFlow           float64            `json:"monthly_cash_flow"`
+	AnnualCashFlow            float64            `json:"annual_cash_flow"`
+	CashOnCashReturn          *float64           `json:"cash_on_cash_return"` // null when infinite
+	DSCR                      float64            `json:"dscr"`
+	Balloon                   *BalloonProjection `json:"balloon,omitempty"`
+	Refinance                 *ArvResult         `json:"refinance,omitempty"`
+	Recommendations           []string           `json:"recommendations"`
+	Warnings                  []string           `json:"warnings"`
+}
+
+// CalculateCreativeFinance analyzes a deal bought subject to an existing
+// loan and/or with seller financing
+func (s *ArvService) CalculateCreativeFinance(req CreativeFinanceRequest) CreativeFinanceResult {
+	// The income and expenses are the same as any rental's
+	base := s.CalculateARV(req.ArvRequest)
+
+	result := CreativeFinanceResult{
+		PurchasePrice:       req.PurchasePrice,
+		ExistingLoanBalance: req.ExistingLoanBalance,
+		ExistingLoanPayment: req.ExistingLoanPayment,
+		SellerCarryAmount:   req.SellerCarryAmount,
+		NOI:                 base.NOI,
+		Recommendations:     []string{},
+		Warnings:            NoticeMessages(base.Warnings),
+	}
+	if !req.SkipRefinance {
+		result.Refinance = &base
+	}
+
+	if req.SellerCarryAmortizationYears > 0 {
+		result.SellerCarryPayment = s.calculateMonthlyPayment(req.SellerCarryAmount, req.SellerCarryRate, req.SellerCarryAmortizationYears)
+	} else {
+		result.SellerCarryPayment = req.SellerCarryAmount * req.SellerCarryRate / 100 / 12
+	}
+	result.BlendedMonthlyDebtService = req.ExistingLoanPayment + result.SellerCarryPayment
+	if financed := req.ExistingLoanBalance + req.SellerCarryAmount; financed > 0 {
+		result.BlendedRate = (req.ExistingLoanBalance*req.ExistingLoanRate + req.SellerCarryAmount*req.SellerCarryRate) / financed
+	}
+
+	if req.ExistingLoanBalance+req.SellerCarryAmount > req.PurchasePrice {
+		result.Warnings = append(result.Warnings, "WARNING: Existing loan and seller carry are more than the purchase price")
+	}
+	if req.ExistingLoanBalance > 0 && req.ExistingLoanPayment <= req.ExistingLoanBalance*req.ExistingLoanRate/100/12 {
+		result.Warnings = append(result.Warnings, "WARNING: Existing loan payment doesn't cover its interest")
+	}
+	result.CashToClose = math.Max(0, req.PurchasePrice-req.ExistingLoanBalance-req.SellerCarryAmount) +
+		req.ClosingCosts + req.RehabCost + req.HoldingCosts + req.FinancingCosts
+
+	result.MonthlyCashFlow = base.NOI/12 - result.BlendedMonthlyDebtService
+	result.AnnualCashFlow = result.MonthlyCashFlow * 12
+	result.CashOnCashReturn = cashOnCashReturn(result.AnnualCashFlow, result.CashToClose)
+	if result.BlendedMonthlyDebtService > 0 {
+		result.DSCR = base.NOI / (result.BlendedMonthlyDebtService * 12)
+	}
+
+	if req.SellerCarryAmount > 0 && req.SellerCarryBalloonMonth > 0 {
+		result.Balloon = s.projectBalloon(req, result.SellerCarryPayment)
+	}
+	result.Recommendations = creativeFinanceRecommendations(result)
+
+	result.SellerCarryPayment = roundCents(result.SellerCarryPayment)
+	result.BlendedMonthlyDebtService = roundCents(result.BlendedMonthlyDebtService)
+	result.BlendedRate = roundCents(result.BlendedRate)
+	result.CashToClose = roundCents(result.CashToClose)
+	result.MonthlyCashFlow = roundCents(result.MonthlyCashFlow)
+	result.AnnualCashFlow = roundCents(result.AnnualCashFlow)
+	if result.CashOnCashReturn != nil {
+		*result.CashOnCashReturn = roundCents(*result.CashOnCashReturn)
+	}
+	result.DSCR = roundCents(result.DSCR)
+	return result
+}
+
+// projectBalloon works out what's owed on the seller note when it balloons
+// and the equity over the existing loan at that date
+func (s *ArvService) projectBalloon(req CreativeFinanceRequest, carryPayment float64) *BalloonProjection {
+	month := req.SellerCarryBalloonMonth
+	balloon := &BalloonProjection{
+		Month:          month,
+		Balance:        req.SellerCarryAmount,
+		ProjectedValue: req.ARV * math.Pow(1+req.Appreciation/100, float64(month)/12),
+	}
+
+	if req.SellerCarryAmortizationYears > 0 {
+		schedule, _ := amortize(req.SellerCarryAmount, req.SellerCarryRate, req.SellerCarryAmortizationYears*12, roundCents(carryPayment))
+		if month <= len(schedule) {
+			balloon.Balance = schedule[month-1].Balance.Float64()
+		} else {
+			balloon.Balance = 0
+		}
+	}
+
+	balloon.ExistingLoanBalance = req.ExistingLoanBalance
+	if req.ExistingLoanBalance > 0 && req.ExistingLoanPayment > 0 {
+		schedule, _ := amortize(req.ExistingLoanBalance, req.ExistingLoanRate, maxAmortizationMonths, req.ExistingLoanPayment)
+		if month <= len(schedule) {
+			balloon.ExistingLoanBalance = schedule[month-1].Balance.Float64()
+		} else {
+			balloon.ExistingLoanBalance = 0
+		}
+	}
+
+	balloon.ProjectedEquity = balloon.ProjectedValue - balloon.ExistingLoanBalance
+	balloon.ExceedsEquity = balloon.Balance > balloon.ProjectedEquity
+
+	balloon.ProjectedValue = roundCents(balloon.ProjectedValue)
+	balloon.ProjectedEquity = roundCents(balloon.ProjectedEquity)
+	return balloon
+}
+
+// creativeFinanceRecommendations flags the risks of a creative finance deal
+func creativeFinanceRecommendations(result CreativeFinanceResult) []string {
+	recommendations := []string{}
+
+	if balloon := result.Balloon; balloon != nil && balloon.Balance > 0 {
+		if balloon.ExceedsEquity {
+			recommendations = append(recommendations, fmt.Sprintf(
+				"CRITICAL: Seller note balloon of $%.2f in month %d exceeds the projected equity of $%.2f - plan how it will be paid",
+				balloon.Balance, balloon.Month, balloon.ProjectedEquity))
+		} else {
+			recommendations = append(recommendations, fmt.Sprintf(
+				"Seller note balloons for $%.2f in month %d - line up a refinance or sale before then",
+				balloon.Balance, balloon.Month))
+		}
+	}
+	if result.MonthlyCashFlow < 0 {
+		recommendations = append(recommendations, "CRITICAL: Negative cash flow after the existing loan and seller note payments")
+	}
+	if result.ExistingLoanBalance > 0 {
+		recommendations = append(recommendations, "Existing loan stays in the seller's name - review the due-on-sale clause risk")
+	}
+	if len(recommendations) == 0 {
+		recommendations = append(recommendations, "Moderate creative finance opportunity - perform detailed due diligence")
+	}
+	return recommendations
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func subjectToRequest() CreativeFinanceRequest {
+	return CreativeFinanceRequest{
+		ArvRequest: ArvRequest{
+			PurchasePrice: 200000,
+			RehabCost:     10000,
+			ClosingCosts:  3000,
+			ARV:           250000,
+			MonthlyRent:   2200,
+			VacancyRate:   5,
+			PropertyTaxes: 3000,
+			Insurance:     1200,
+			Maintenance:   1200,
+		},
+		ExistingLoanBalance:          140000,
+		ExistingLoanRate:             3.5,
+		ExistingLoanPayment:          850,
+		SellerCarryAmount:            40000,
+		SellerCarryRate:              6,
+		SellerCarryAmortizationYears: 30,
+		SellerCarryBalloonMonth:      60,
+		Appreciation:                 3,
+	}
+}
+
+func TestCalculateCreativeFinance_SubjectToWithSellerCarryBalloon(t *testing.T) {
+	service := NewArvService()
+	req := subjectToRequest()
+
+	result := service.CalculateCreativeFinance(req)
+
+	// 40,000 at 6% over 30 years is 239.82 a month, on top of the 850 taken
+	// over on the existing loan
+	assert.Equal(t, 239.82, result.SellerCarryPayment)
+	assert.Equal(t, 1089.82, result.BlendedMonthlyDebtService)
+	assert.Equal(t, 4.06, result.BlendedRate) // (140,000 x 3.5 + 40,000 x 6) / 180,000
+
+	// 20,000 down plus 3,000 closing and 10,000 rehab
+	assert.Equal(t, 33000.0, result.CashToClose)
+
+	noi := service.CalculateARV(req.ArvRequest).NOI
+	assert.InDelta(t, noi/12-1089.82, result.MonthlyCashFlow, 0.01)
+	assert.InDelta(t, result.MonthlyCashFlow*12, result.AnnualCashFlow, 0.01)
+	assert.InDelta(t, result.AnnualCashFlow/33000*100, *result.CashOnCashReturn, 0.01)
+
+	// After 5 years the note is down to 37,221.75 and the existing loan to
+	// 111,085.80, while 250,000 at 3% a year is worth 289,818.52
+	require.NotNil(t, result.Balloon)
+	assert.Equal(t, 60, result.Balloon.Month)
+	assert.InDelta(t, 37221.75, result.Balloon.Balance, 0.05)
+	assert.InDelta(t, 111085.80, result.Balloon.ExistingLoanBalance, 0.05)
+	assert.Equal(t, 289818.52, result.Balloon.ProjectedValue)
+	assert.InDelta(t, 178732.72, result.Balloon.ProjectedEquity, 0.05)
+	assert.False(t, result.Balloon.ExceedsEquity)
+	assert.Contains(t, result.Recommendations[0], "Seller note balloons")
+
+	require.NotNil(t, result.Refinance, "the BRRRR refinance is included by default")
+}
+
+func TestCalculateCreativeFinance_BalloonExceedsEquity(t *testing.T) {
+	service := NewArvService()
+	req := subjectToRequest()
+	req.PurchasePrice = 225000
+	req.ARV = 190000
+	req.Appreciation = 0
+	req.SellerCarryAmount = 85000
+	req.SellerCarryRate = 5
+	req.SellerCarryAmortizationYears = 0
+	req.SkipRefinance = true
+
+	result := service.CalculateCreativeFinance(req)
+
+	// Interest-only, so the whole 85,000 is due, against 190,000 less the
+	// 111,085.80 still owed on the existing loan
+	assert.Equal(t, 354.17, result.SellerCarryPayment)
+	assert.Equal(t, 13000.0, result.CashToClose, "nothing down, just closing and rehab")
+	require.NotNil(t, result.Balloon)
+	assert.Equal(t, 85000.0, result.Balloon.Balance)
+	assert.InDelta(t, 78914.20, result.Balloon.ProjectedEquity, 0.05)
+	assert.True(t, result.Balloon.ExceedsEquity)
+	assert.Contains(t, result.Recommendations[0], "CRITICAL: Seller note balloon")
+	assert.Nil(t, result.Refinance)
+}
+
+func TestCalculateCreativeFinance_NoSellerCarry(t *testing.T) {
+	service := NewArvService()
+	req := subjectToRequest()
+	req.SellerCarryAmount = 0
+
+	result := service.CalculateCreativeFinance(req)
+
+	assert.Equal(t, 0.0, result.SellerCarryPayment)
+	assert.Equal(t, 850.0, result.BlendedMonthlyDebtService)
+	assert.Equal(t, 3.5, result.BlendedRate)
+	assert.Equal(t, 73000.0, result.CashToClose)
+	assert.Nil(t, result.Balloon)
+}
+
+func TestCalculateCreativeFinance_WarnsWhenPaymentDoesNotCoverInterest(t *testing.T) {
+	service := NewArvService()
+	req := subjectToRequest()
+	req.ExistingLoanPayment = 400 // interest alone is 408.33
+
+	result := service.CalculateCreativeFinance(req)
+
+	assert.Contains(t, result.Warnings, "WARNING: Existing loan payment doesn't cover its interest")
+}
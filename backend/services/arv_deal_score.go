@@ -0,0 +1,147 @@
+package services
+
+// DealScoreWeights sets how much each component counts towards a deal's
+// overall score. They're relative, so they needn't add up to 100.
+type DealScoreWeights struct {
+	CashFlow      float64 `json:"cash_flow"`
+	CashOnCash    float64 `json:"cash_on_cash"`
+	EquityCapture float64 `json:"equity_capture"`
+	DSCR          float64 `json:"dscr"`
+	ExpenseRatio  float64 `json:"expense_ratio"`
+}
+
+// DefaultDealScoreWeights are what CalculateARV scores deals with
+var DefaultDealScoreWeights = DealScoreWeights{
+	CashFlow:      25,
+	CashOnCash:    25,
+	EquityCapture: 20,
+	DSCR:          20,
+	ExpenseRatio:  10,
+}
+
+// Deal score components, in the order they're reported
+const (
+	DealScoreCashFlow      = "cash_flow"
+	DealScoreCashOnCash    = "cash_on_cash"
+	DealScoreEquityCapture = "equity_capture"
+	DealScoreDSCR          = "dscr"
+	DealScoreExpenseRatio  = "expense_ratio"
+)
+
+// scorePoint is a point on a scoring curve: value scores score
+type scorePoint struct{ value, score float64 }
+
+// The curves each component is scored on. Scores are interpolated linearly
+// between points and held at the first and last score beyond them.
+var (
+	// Monthly cash flow: losing money scores under 40, $200 a month 75 and
+	// $400 or more 100
+	cashFlowCurve = []scorePoint{{-200, 0}, {0, 40}, {200, 75}, {400, 100}}
+
+	// Cash-on-cash return: 8% scores 60, 12% 80 and 20% or more 100
+	cashOnCashCurve = []scorePoint{{0, 0}, {8, 60}, {12, 80}, {20, 100}}
+
+	// Equity captured, as a share of what the rule percentage leaves (30%
+	// of ARV under the 70% rule): meeting the rule scores 90
+	equityCaptureCurve = []scorePoint{{0, 0}, {0.5, 50}, {1, 90}, {1.5, 100}}
+
+	// DSCR: 1.0 scores 40, the 1.25 lenders look for 75 and 1.5 or more 100
+	dscrCurve = []scorePoint{{0.8, 0}, {1, 40}, {1.25, 75}, {1.5, 100}}
+
+	// Expense ratio: 35% or less scores 100, 50% 70 and 80% or more 0
+	expenseRatioCurve = []scorePoint{{35, 100}, {50, 70}, {65, 30}, {80, 0}}
+)
+
+// DealScoreComponent is one part of a deal's score. Value is the metric it
+// was scored on, nil for an infinite cash-on-cash return, which scores the
+// most the curve allows.
+type DealScoreComponent struct {
+	Name   string   `json:"name"`
+	Value  *float64 `json:"value"`
+	Score  float64  `json:"score"`
+	Weight float64  `json:"weight"`
+}
+
+// DealScore rates a deal from 0 to 100, with a letter grade and the
+// components that make it up
+type DealScore struct {
+	Score      float64              `json:"score"`
+	Grade      string               `json:"grade"`
+	Components []DealScoreComponent `json:"components"`
+}
+
+// ScoreDeal rates the deal in result with weights
+func ScoreDeal(result ArvResult, weights DealScoreWeights) DealScore {
+	rule := rulePercentage(result.RulePercentage)
+	equityCapture := 0.0
+	if result.ARV > 0 {
+		equityCapture = (result.ARV - result.TotalInvestment) / result.ARV * 100 / (100 - rule)
+	}
+
+	dscrScore := 100.0 // nothing borrowed
+	if result.MonthlyDebtService > 0 {
+		dscrScore = interpolateScore(dscrCurve, result.DSCR)
+	}
+
+	var cashOnCash *float64
+	if !result.IsInfiniteReturn {
+		cashOnCash = scoredValue(cashOnCashValue(result.CashOnCashReturn, false))
+	}
+
+	components := []DealScoreComponent{
+		{DealScoreCashFlow, scoredValue(result.MonthlyCashFlow), interpolateScore(cashFlowCurve, result.MonthlyCashFlow), weights.CashFlow},
+		{DealScoreCashOnCash, cashOnCash, interpolateScore(cashOnCashCurve, cashOnCashValue(result.CashOnCashReturn, result.IsInfiniteReturn)), weights.CashOnCash},
+		{DealScoreEquityCapture, scoredValue(roundCents(equityCapture)), interpolateScore(equityCaptureCurve, equityCapture), weights.EquityCapture},
+		{DealScoreDSCR, scoredValue(result.DSCR), dscrScore, weights.DSCR},
+		{DealScoreExpenseRatio, scoredValue(result.ExpenseRatio), interpolateScore(expenseRatioCurve, result.ExpenseRatio), weights.ExpenseRatio},
+	}
+
+	score := DealScore{Components: components}
+	totalWeight := 0.0
+	for i := range components {
+		components[i].Score = roundCents(components[i].Score)
+		score.Score += components[i].Score * components[i].Weight
+		totalWeight += components[i].Weight
+	}
+	if totalWeight > 0 {
+		score.Score = roundCents(score.Score / totalWeight)
+	} else {
+		score.Score = 0
+	}
+	score.Grade = dealGrade(score.Score)
+	return score
+}
+
+// scoredValue returns a pointer to a component's value
+func scoredValue(value float64) *float64 {
+	return &value
+}
+
+// interpolateScore reads value's score off curve
+func interpolateScore(curve []scorePoint, value float64) float64 {
+	if value <= curve[0].value {
+		return curve[0].score
+	}
+	for i := 1; i < len(curve); i++ {
+		if value <= curve[i].value {
+			from, to := curve[i-1], curve[i]
+			return from.score + (value-from.value)/(to.value-from.value)*(to.score-from.score)
+		}
+	}
+	return curve[len(curve)-1].score
+}
+
+// dealGrade turns a deal score into a letter grade
+func dealGrade(score float64) string {
+	switch {
+	case score >= 85:
+		return "A"
+	case score >= 70:
+		return "B"
+	case score >= 55:
+		return "C"
+	case score >= 40:
+		return "D"
+	}
+	return "F"
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func canonicalDeal(purchasePrice, monthlyRent float64) ArvRequest {
+	return ArvRequest{
+		PurchasePrice: purchasePrice,
+		RehabCost:     30000,
+		ClosingCosts:  3000,
+		ARV:           200000,
+		MonthlyRent:   monthlyRent,
+		VacancyRate:   5,
+		PropertyTaxes: 2400,
+		Insurance:     1000,
+		Maintenance:   1200,
+		CapEx:         1000,
+		InterestRate:  7,
+	}
+}
+
+func TestCalculateARV_DealScoreBands(t *testing.T) {
+	service := NewArvService()
+
+	for _, tc := range []struct {
+		name               string
+		req                ArvRequest
+		minScore, maxScore float64
+		grade              string
+	}{
+		// Bought well under the 70% rule with strong rent: all cash comes
+		// back out and it cash flows
+		{"home run BRRRR", canonicalDeal(90000, 2200), 85, 100, "A"},
+		// Meets the rule, but the rent falls just short of the debt
+		{"thin cash flow", canonicalDeal(105000, 1500), 40, 55, "D"},
+		// Paid retail, and the rent doesn't cover the expenses and debt
+		{"retail loser", canonicalDeal(170000, 1100), 0, 25, "F"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result := service.CalculateARV(tc.req)
+
+			score := result.DealScore
+			assert.GreaterOrEqual(t, score.Score, tc.minScore)
+			assert.LessOrEqual(t, score.Score, tc.maxScore)
+			assert.Equal(t, tc.grade, score.Grade)
+			require.Len(t, score.Components, 5)
+		})
+	}
+}
+
+func TestCalculateARV_DealScoreShowsWhyADealScoredPoorly(t *testing.T) {
+	service := NewArvService()
+
+	score := service.CalculateARV(canonicalDeal(105000, 1500)).DealScore
+
+	components := map[string]DealScoreComponent{}
+	for _, component := range score.Components {
+		components[component.Name] = component
+	}
+	// Equity is fine, but cash flow and DSCR drag it down
+	assert.Equal(t, -39.62, *components[DealScoreCashFlow].Value)
+	assert.Equal(t, 32.08, components[DealScoreCashFlow].Score) // 40 less 39.62/200 of 40
+	assert.Equal(t, 0.96, *components[DealScoreDSCR].Value)
+	assert.Greater(t, components[DealScoreEquityCapture].Score, 90.0)
+}
+
+func TestScoreDeal_Weights(t *testing.T) {
+	result := ArvResult{
+		ARV:                200000,
+		TotalInvestment:    140000, // exactly 30% equity under the 70% rule
+		RulePercentage:     70,
+		MonthlyCashFlow:    400,
+		CashOnCashReturn:   new(float64),
+		MonthlyDebtService: 1000,
+		DSCR:               1.5,
+		ExpenseRatio:       35,
+	}
+
+	score := ScoreDeal(result, DefaultDealScoreWeights)
+	// (25 x 100 + 25 x 0 + 20 x 90 + 20 x 100 + 10 x 100) / 100
+	assert.Equal(t, 73.0, score.Score)
+	assert.Equal(t, "B", score.Grade)
+
+	onlyCashOnCash := ScoreDeal(result, DealScoreWeights{CashOnCash: 1})
+	assert.Equal(t, 0.0, onlyCashOnCash.Score)
+	assert.Equal(t, "F", onlyCashOnCash.Grade)
+}
+
+func TestScoreDeal_NoDebtScoresFullDSCR(t *testing.T) {
+	score := ScoreDeal(ArvResult{ARV: 200000, TotalInvestment: 150000}, DefaultDealScoreWeights)
+
+	assert.Equal(t, DealScoreDSCR, score.Components[3].Name)
+	assert.Equal(t, 100.0, score.Components[3].Score)
+}
+
+func TestInterpolateScore(t *testing.T) {
+	assert.Equal(t, 0.0, interpolateScore(cashOnCashCurve, -5))
+	assert.Equal(t, 30.0, interpolateScore(cashOnCashCurve, 4))
+	assert.Equal(t, 70.0, interpolateScore(cashOnCashCurve, 10))
+	assert.Equal(t, 100.0, interpolateScore(cashOnCashCurve, 999.99))
+	assert.Equal(t, 100.0, interpolateScore(expenseRatioCurve, 20))
+	assert.Equal(t, 0.0, interpolateScore(expenseRatioCurve, 90))
+}
@@ -0,0 +1,163 @@
+package services
+
+// DraftArvRequest is the relaxed input for draft-mode calculations. The core
+// inputs are pointers so an absent value can be told apart from a zero.
+type DraftArvRequest struct {
+	PurchasePrice *float64 `json:"purchase_price" binding:"omitempty,min=1"`
+	ARV           *float64 `json:"arv" binding:"omitempty,min=1"`
+	MonthlyRent   *float64 `json:"monthly_rent" binding:"omitempty,min=0"`
+	RentSource    string   `json:"rent_source" binding:"omitempty,oneof=provided rent_comps"`
+
+	RehabCost           float64 `json:"rehab_cost" binding:"min=0"`
+	HoldingCosts        float64 `json:"holding_costs" binding:"min=0"`
+	ClosingCosts        float64 `json:"closing_costs" binding:"min=0"`
+	FinancingCosts      float64 `json:"financing_costs" binding:"min=0"`
+	SellingCosts        float64 `json:"selling_costs" binding:"min=0"`
+	VacancyRate         float64 `json:"vacancy_rate" binding:"min=0,max=100"`
+	CreditLossRate      float64 `json:"credit_loss_rate" binding:"min=0,max=100"`
+	PropertyTaxes       float64 `json:"property_taxes" binding:"min=0"`
+	Insurance           float64 `json:"insurance" binding:"min=0"`
+	Maintenance         float64 `json:"maintenance" binding:"min=0"`
+	PropertyMgmt        float64 `json:"property_mgmt" binding:"min=0"` // deprecated
+	PropertyMgmtPercent float64 `json:"property_mgmt_percent" binding:"min=0,max=20"`
+	PropertyMgmtAnnual  float64 `json:"property_mgmt_annual" binding:"min=0"`
+	CapEx               float64 `json:"capex" binding:"min=0"`
+	OtherExpenses       float64 `json:"other_expenses" binding:"min=0"`
+	RefinanceLTV        float64 `json:"refinance_ltv" binding:"min=0,max=100"`
+	InterestRate        float64 `json:"interest_rate" binding:"min=0,max=30"`
+	LoanTerm            int     `json:"loan_term" binding:"min=0,max=50"`
+}
+
+// MissingInputAnnotation explains why a draft metric could not be derived
+type MissingInputAnnotation struct {
+	Metric       string   `json:"metric"`
+	MissingInput []string `json:"missing_input"`
+}
+
+// DraftArvResult holds whatever could be derived from a partial request.
+// Metrics that need absent inputs are present with a null value.
+type DraftArvResult struct {
+	Mode          string                   `json:"mode"`
+	Complete      bool                     `json:"complete"`
+	Metrics       map[string]interface{}   `json:"metrics"`
+	MissingInputs []MissingInputAnnotation `json:"missing_inputs"`
+	Unlocks       map[string][]string      `json:"unlocks"` // field -> metrics it alone would unlock
+	Result        *ArvResult               `json:"result,omitempty"`
+}
+
+// draftMetric maps an output metric to the core inputs it depends on
+type draftMetric struct {
+	name   string
+	inputs []string
+	value  func(r ArvResult) interface{}
+}
+
+var draftMetrics = []draftMetric{
+	{"max_offer_70", []string{"arv"}, func(r ArvResult) interface{} { return r.MaxOffer70 }},
+	{"brrrr_max_offer", []string{"arv"}, func(r ArvResult) interface{} { return r.BrrrrMaxOffer }},
+	{"refinance_amount", []string{"arv"}, func(r ArvResult) interface{} { return r.RefinanceAmount }},
+	{"monthly_debt_service", []string{"arv"}, func(r ArvResult) interface{} { return r.MonthlyDebtService }},
+	{"total_investment", []string{"purchase_price"}, func(r ArvResult) interface{} { return r.TotalInvestment }},
+	{"is_70_rule_good", []string{"purchase_price", "arv"}, func(r ArvResult) interface{} { return r.Is70RuleGood }},
+	{"equity", []string{"purchase_price", "arv"}, func(r ArvResult) interface{} { return roundCents(r.ARV - r.TotalInvestment) }},
+	{"potential_profit", []string{"purchase_price", "arv"}, func(r ArvResult) interface{} { return r.PotentialProfit }},
+	{"profit_margin", []string{"purchase_price", "arv"}, func(r ArvResult) interface{} { return r.ProfitMargin }},
+	{"roi", []string{"purchase_price", "arv"}, func(r ArvResult) interface{} { return r.ROI }},
+	{"cash_recovered", []string{"purchase_price", "arv"}, func(r ArvResult) interface{} { return r.CashRecovered }},
+	{"cash_left_in", []string{"purchase_price", "arv"}, func(r ArvResult) interface{} { return r.CashLeftIn }},
+	{"risk_level", []string{"purchase_price", "arv"}, func(r ArvResult) interface{} { return r.RiskLevel }},
+	{"annual_gross_income", []string{"monthly_rent"}, func(r ArvResult) interface{} { return r.AnnualGrossIncome }},
+	{"effective_income", []string{"monthly_rent"}, func(r ArvResult) interface{} { return r.EffectiveIncome }},
+	{"annual_expenses", []string{"monthly_rent", "arv"}, func(r ArvResult) interface{} { return r.AnnualExpenses }},
+	{"expense_ratio", []string{"monthly_rent", "arv"}, func(r ArvResult) interface{} { return r.ExpenseRatio }},
+	{"noi", []string{"monthly_rent", "arv"}, func(r ArvResult) interface{} { return r.NOI }},
+	{"cap_rate", []string{"monthly_rent", "arv"}, func(r ArvResult) interface{} { return r.CapRate }},
+	{"dscr", []string{"monthly_rent", "arv"}, func(r ArvResult) interface{} { return r.DSCR }},
+	{"monthly_cash_flow", []string{"monthly_rent", "arv"}, func(r ArvResult) interface{} { return r.MonthlyCashFlow }},
+	{"annual_cash_flow", []string{"monthly_rent", "arv"}, func(r ArvResult) interface{} { return r.AnnualCashFlow }},
+	{"cash_on_cash_return", []string{"monthly_rent", "purchase_price", "arv"}, func(r ArvResult) interface{} { return r.CashOnCashReturn }},
+}
+
+// CalculateDraft computes every metric derivable from a partially-complete
+// request. Draft results are informational only and must never be persisted
+// as an authoritative calculation.
+func (s *ArvService) CalculateDraft(req DraftArvRequest) DraftArvResult {
+	provided := map[string]bool{
+		"purchase_price": req.PurchasePrice != nil,
+		"arv":            req.ARV != nil,
+		"monthly_rent":   req.MonthlyRent != nil,
+	}
+
+	full := ArvRequest{
+		RehabCost:           req.RehabCost,
+		HoldingCosts:        req.HoldingCosts,
+		ClosingCosts:        req.ClosingCosts,
+		FinancingCosts:      req.FinancingCosts,
+		SellingCosts:        req.SellingCosts,
+		VacancyRate:         req.VacancyRate,
+		CreditLossRate:      req.CreditLossRate,
+		PropertyTaxes:       req.PropertyTaxes,
+		Insurance:           req.Insurance,
+		Maintenance:         req.Maintenance,
+		PropertyMgmt:        req.PropertyMgmt,
+		PropertyMgmtPercent: req.PropertyMgmtPercent,
+		PropertyMgmtAnnual:  req.PropertyMgmtAnnual,
+		CapEx:               req.CapEx,
+		OtherExpenses:       req.OtherExpenses,
+		RefinanceLTV:        req.RefinanceLTV,
+		InterestRate:        req.InterestRate,
+		LoanTerm:            req.LoanTerm,
+		RentSource:          req.RentSource,
+	}
+	// Missing core inputs get placeholders; every metric that depends on a
+	// placeholder is nulled out below, so the values never leak.
+	if req.PurchasePrice != nil {
+		full.PurchasePrice = *req.PurchasePrice
+	}
+	full.ARV = 1
+	if req.ARV != nil {
+		full.ARV = *req.ARV
+	}
+	if req.MonthlyRent != nil {
+		full.MonthlyRent = *req.MonthlyRent
+	}
+
+	calculated := s.CalculateARV(full)
+
+	result := DraftArvResult{
+		Mode:          "draft",
+		Metrics:       make(map[string]interface{}, len(draftMetrics)),
+		MissingInputs: []MissingInputAnnotation{},
+		Unlocks:       map[string][]string{},
+	}
+
+	for _, metric := range draftMetrics {
+		var missing []string
+		for _, input := range metric.inputs {
+			if !provided[input] {
+				missing = append(missing, input)
+			}
+		}
+
+		if len(missing) == 0 {
+			result.Metrics[metric.name] = metric.value(calculated)
+			continue
+		}
+
+		result.Metrics[metric.name] = nil
+		result.MissingInputs = append(result.MissingInputs, MissingInputAnnotation{
+			Metric:       metric.name,
+			MissingInput: missing,
+		})
+		if len(missing) == 1 {
+			result.Unlocks[missing[0]] = append(result.Unlocks[missing[0]], metric.name)
+		}
+	}
+
+	result.Complete = len(result.MissingInputs) == 0
+	if result.Complete {
+		result.Result = &calculated
+	}
+
+	return result
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateDraft_PriceAndArvOnly(t *testing.T) {
+	service := NewArvService()
+	price, arv := 70000.0, 120000.0
+
+	result := service.CalculateDraft(DraftArvRequest{
+		PurchasePrice: &price,
+		ARV:           &arv,
+		RehabCost:     15000,
+	})
+
+	assert.Equal(t, "draft", result.Mode)
+	assert.False(t, result.Complete)
+	assert.Nil(t, result.Result)
+	assert.Equal(t, 69000.0, result.Metrics["max_offer_70"])
+	assert.Equal(t, 35000.0, result.Metrics["equity"])
+	assert.Equal(t, false, result.Metrics["is_70_rule_good"])
+
+	assert.Contains(t, result.Metrics, "noi")
+	assert.Nil(t, result.Metrics["noi"])
+	assert.Nil(t, result.Metrics["monthly_cash_flow"])
+	assert.ElementsMatch(t, []string{
+		"annual_gross_income", "effective_income", "annual_expenses", "expense_ratio", "noi",
+		"cap_rate", "dscr", "monthly_cash_flow", "annual_cash_flow", "cash_on_cash_return",
+	}, result.Unlocks["monthly_rent"])
+}
+
+func TestCalculateDraft_ArvOnly(t *testing.T) {
+	service := NewArvService()
+	arv := 100000.0
+
+	result := service.CalculateDraft(DraftArvRequest{ARV: &arv, RehabCost: 20000})
+
+	assert.Equal(t, 50000.0, result.Metrics["max_offer_70"])
+	assert.Nil(t, result.Metrics["equity"])
+	assert.Contains(t, result.Unlocks["purchase_price"], "equity")
+
+	// cash-on-cash needs two more inputs, so no single field unlocks it
+	for _, metrics := range result.Unlocks {
+		assert.NotContains(t, metrics, "cash_on_cash_return")
+	}
+	for _, annotation := range result.MissingInputs {
+		if annotation.Metric == "cash_on_cash_return" {
+			assert.Equal(t, []string{"monthly_rent", "purchase_price"}, annotation.MissingInput)
+		}
+	}
+}
+
+func TestCalculateDraft_CompleteMatchesFullCalculation(t *testing.T) {
+	service := NewArvService()
+	price, arv, rent := 60000.0, 120000.0, 1200.0
+
+	draft := service.CalculateDraft(DraftArvRequest{PurchasePrice: &price, ARV: &arv, MonthlyRent: &rent})
+	full := service.CalculateARV(ArvRequest{PurchasePrice: price, ARV: arv, MonthlyRent: rent})
+
+	assert.True(t, draft.Complete)
+	assert.Empty(t, draft.MissingInputs)
+	assert.Empty(t, draft.Unlocks)
+	assert.Equal(t, full, *draft.Result)
+	assert.Equal(t, full.MonthlyCashFlow, draft.Metrics["monthly_cash_flow"])
+}
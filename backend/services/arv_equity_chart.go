@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"math"
+
+	"arvfinder-backend/models"
+)
+
+// ErrMonthlyEquityTermTooLong is returned when monthly equity chart data is
+// asked for over a loan term longer than maxMonthlyEquityYears
+var ErrMonthlyEquityTermTooLong = errors.New("monthly equity chart data is limited to a 10-year term")
+
+// maxMonthlyEquityYears caps the term monthly chart data covers, keeping
+// the payload to 120 points
+const maxMonthlyEquityYears = 10
+
+// Equity chart granularities
+const (
+	GranularityYearly  = "yearly"
+	GranularityMonthly = "monthly"
+)
+
+// EquityChartRequest is a refinance loan and the property it's secured by.
+// PropertyValue is the value when the loan starts, such as the ARV. Extra
+// principal is paid down like in an amortization schedule.
+type EquityChartRequest struct {
+	PropertyValue       float64 `json:"property_value" binding:"required,min=1"`
+	LoanAmount          float64 `json:"loan_amount" binding:"required,min=1"`
+	AnnualRate          float64 `json:"annual_rate" binding:"min=0,max=30"` // percentage
+	TermYears           int     `json:"term_years" binding:"required,min=1,max=50"`
+	AppreciationRate    float64 `json:"appreciation_rate" binding:"min=-20,max=20"` // annual percentage
+	ExtraMonthlyPayment float64 `json:"extra_monthly_payment" binding:"min=0"`
+	AnnualLumpSum       float64 `json:"annual_lump_sum" binding:"min=0"`
+}
+
+// EquityPoint is the loan and property at the end of a period, a year or a
+// month into the loan. Period 0 is when the loan starts.
+type EquityPoint struct {
+	Period              int     `json:"period"`
+	Balance             float64 `json:"balance"`
+	PropertyValue       float64 `json:"property_value"`
+	Equity              float64 `json:"equity"`
+	CumulativePrincipal float64 `json:"cumulative_principal"`
+	CumulativeInterest  float64 `json:"cumulative_interest"`
+}
+
+// EquityChart is how equity builds over a loan's life, from paying the
+// loan down and from appreciation
+type EquityChart struct {
+	Granularity    string        `json:"granularity"`
+	MonthlyPayment float64       `json:"monthly_payment"` // principal and interest
+	PayoffMonth    int           `json:"payoff_month"`
+	Points         []EquityPoint `json:"points"`
+}
+
+// CalculateEquityChart charts a loan's balance, the property's value and
+// the equity between them, a point a year or, for terms of up to 10 years,
+// a point a month. Appreciation compounds annually.
+func (s *ArvService) CalculateEquityChart(req EquityChartRequest, granularity string) (EquityChart, error) {
+	monthsPerPoint := 12
+	if granularity == GranularityMonthly {
+		if req.TermYears > maxMonthlyEquityYears {
+			return EquityChart{}, ErrMonthlyEquityTermTooLong
+		}
+		monthsPerPoint = 1
+	} else {
+		granularity = GranularityYearly
+	}
+
+	months := req.TermYears * 12
+	payment := roundCents(monthlyPayment(req.LoanAmount, req.AnnualRate, months))
+	extra := prepayments{monthly: req.ExtraMonthlyPayment, annual: req.AnnualLumpSum}
+	schedule, _ := amortizeWithPrepayments(req.LoanAmount, req.AnnualRate, months, payment, extra)
+
+	chart := EquityChart{
+		Granularity:    granularity,
+		MonthlyPayment: payment,
+		PayoffMonth:    len(schedule),
+		Points:         make([]EquityPoint, 0, months/monthsPerPoint+1),
+	}
+	balance := models.MoneyFromFloat(req.LoanAmount)
+	var cumulativePrincipal, cumulativeInterest models.Money
+	for month := 0; month <= months; month++ {
+		if month > 0 && month <= len(schedule) {
+			row := schedule[month-1]
+			balance = row.Balance
+			cumulativePrincipal += row.Principal
+			cumulativeInterest += row.Interest
+		}
+		if month%monthsPerPoint != 0 {
+			continue
+		}
+		propertyValue := models.MoneyFromFloat(req.PropertyValue * math.Pow(1+req.AppreciationRate/100, float64(month/12)))
+		chart.Points = append(chart.Points, EquityPoint{
+			Period:              month / monthsPerPoint,
+			Balance:             balance.Float64(),
+			PropertyValue:       propertyValue.Float64(),
+			Equity:              (propertyValue - balance).Float64(),
+			CumulativePrincipal: cumulativePrincipal.Float64(),
+			CumulativeInterest:  cumulativeInterest.Float64(),
+		})
+	}
+	return chart, nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateEquityChart_Yearly(t *testing.T) {
+	service := NewArvService()
+	req := EquityChartRequest{
+		PropertyValue:    200000,
+		LoanAmount:       150000,
+		AnnualRate:       7,
+		TermYears:        30,
+		AppreciationRate: 3,
+	}
+
+	chart, err := service.CalculateEquityChart(req, "")
+
+	require.NoError(t, err)
+	assert.Equal(t, GranularityYearly, chart.Granularity)
+	assert.Equal(t, 997.95, chart.MonthlyPayment)
+	require.Len(t, chart.Points, 31)
+
+	start := chart.Points[0]
+	assert.Equal(t, EquityPoint{Balance: 150000, PropertyValue: 200000, Equity: 50000}, start)
+	assert.Equal(t, 206000.0, chart.Points[1].PropertyValue)
+
+	// The loan is paid off in its last year, leaving the whole value as
+	// equity
+	last := chart.Points[30]
+	assert.Equal(t, 30, last.Period)
+	assert.Zero(t, last.Balance)
+	assert.Equal(t, last.PropertyValue, last.Equity)
+	assert.Equal(t, 150000.0, last.CumulativePrincipal)
+
+	_, totalInterest := amortize(150000, 7, 360, 997.95)
+	assert.Equal(t, totalInterest.Float64(), last.CumulativeInterest)
+
+	for i := 1; i < len(chart.Points); i++ {
+		assert.Less(t, chart.Points[i].Balance, chart.Points[i-1].Balance, "year %d", i)
+		assert.Equal(t, chart.Points[i].Equity, roundCents(chart.Points[i].PropertyValue-chart.Points[i].Balance))
+	}
+}
+
+func TestCalculateEquityChart_Monthly(t *testing.T) {
+	service := NewArvService()
+	req := EquityChartRequest{PropertyValue: 100000, LoanAmount: 60000, AnnualRate: 0, TermYears: 10}
+
+	chart, err := service.CalculateEquityChart(req, GranularityMonthly)
+
+	require.NoError(t, err)
+	assert.Equal(t, GranularityMonthly, chart.Granularity)
+	require.Len(t, chart.Points, 121)
+	assert.Equal(t, 59500.0, chart.Points[1].Balance)
+	assert.Equal(t, 500.0, chart.Points[1].CumulativePrincipal)
+	assert.Zero(t, chart.Points[120].Balance)
+	assert.Equal(t, 100000.0, chart.Points[120].Equity)
+	assert.Zero(t, chart.Points[120].CumulativeInterest)
+}
+
+func TestCalculateEquityChart_MonthlyTermCapped(t *testing.T) {
+	service := NewArvService()
+	req := EquityChartRequest{PropertyValue: 100000, LoanAmount: 60000, AnnualRate: 6, TermYears: 30}
+
+	_, err := service.CalculateEquityChart(req, GranularityMonthly)
+
+	assert.ErrorIs(t, err, ErrMonthlyEquityTermTooLong)
+}
+
+func TestCalculateEquityChart_ExtraPrincipal(t *testing.T) {
+	service := NewArvService()
+	req := EquityChartRequest{
+		PropertyValue:       200000,
+		LoanAmount:          150000,
+		AnnualRate:          7,
+		TermYears:           30,
+		ExtraMonthlyPayment: 200,
+	}
+
+	chart, err := service.CalculateEquityChart(req, GranularityYearly)
+	baseline, _ := service.CalculateEquityChart(EquityChartRequest{
+		PropertyValue: 200000, LoanAmount: 150000, AnnualRate: 7, TermYears: 30,
+	}, GranularityYearly)
+
+	require.NoError(t, err)
+	assert.Less(t, chart.PayoffMonth, 360)
+	assert.Less(t, chart.Points[5].Balance, baseline.Points[5].Balance)
+
+	// Once the loan is paid off the balance stays at zero
+	paidOff := chart.Points[chart.PayoffMonth/12+1]
+	assert.Zero(t, paidOff.Balance)
+	assert.Equal(t, paidOff.PropertyValue, paidOff.Equity)
+	assert.Equal(t, 150000.0, paidOff.CumulativePrincipal)
+	assert.Equal(t, chart.Points[30].CumulativeInterest, paidOff.CumulativeInterest)
+}
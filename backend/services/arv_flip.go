@@ -0,0 +1,101 @@
+package services
+
+import (
+	"math"
+)
+
+// FlipRequest is the input for a fix & flip analysis. Holding costs come
+// from the timeline: every month of rehab and listing carries the monthly
+// taxes, insurance, utilities and interest on an interest-only loan.
+type FlipRequest struct {
+	PurchasePrice float64 `json:"purchase_price" binding:"required,min=1"`
+	RehabCost     float64 `json:"rehab_cost" binding:"min=0"`
+	ClosingCosts  float64 `json:"closing_costs" binding:"min=0"` // buying side
+	ARV           float64 `json:"arv" binding:"required,min=1"`  // expected sale price
+	SellingCosts  float64 `json:"selling_costs" binding:"min=0"` // seller closing costs besides commission
+
+	RehabMonths   int `json:"rehab_months" binding:"min=0,max=60"`
+	ListingMonths int `json:"listing_months" binding:"min=0,max=60"`
+
+	MonthlyTaxes     float64 `json:"monthly_taxes" binding:"min=0"`
+	MonthlyInsurance float64 `json:"monthly_insurance" binding:"min=0"`
+	MonthlyUtilities float64 `json:"monthly_utilities" binding:"min=0"`
+	LoanAmount       float64 `json:"loan_amount" binding:"min=0"`
+	LoanInterestRate float64 `json:"loan_interest_rate" binding:"min=0,max=30"` // annual percentage, interest-only
+
+	AgentCommission float64 `json:"agent_commission" binding:"min=0,max=20"` // percentage of the sale price
+}
+
+// FlipResult holds the results of a fix & flip analysis
+type FlipResult struct {
+	HoldingMonths       int     `json:"holding_months"`
+	MonthlyLoanInterest float64 `json:"monthly_loan_interest"`
+	MonthlyCarryingCost float64 `json:"monthly_carrying_cost"`
+	TotalHoldingCost    float64 `json:"total_holding_cost"`
+	TotalProjectCost    float64 `json:"total_project_cost"` // purchase, closing, rehab and holding
+
+	AgentCommission float64 `json:"agent_commission"`
+	NetSaleProceeds float64 `json:"net_sale_proceeds"`
+	Profit          float64 `json:"profit"`
+	ROI             float64 `json:"roi"`
+	AnnualizedROI   float64 `json:"annualized_roi"`
+	BreakEvenPrice  float64 `json:"break_even_sale_price"`
+
+	Warnings []string `json:"warnings"`
+}
+
+// CalculateFlip analyzes a fix & flip, deriving holding costs from how long
+// the rehab and the listing take
+func (s *ArvService) CalculateFlip(req FlipRequest) FlipResult {
+	result := FlipResult{
+		HoldingMonths: req.RehabMonths + req.ListingMonths,
+		Warnings:      []string{},
+	}
+
+	result.MonthlyLoanInterest = req.LoanAmount * req.LoanInterestRate / 100 / 12
+	result.MonthlyCarryingCost = req.MonthlyTaxes + req.MonthlyInsurance + req.MonthlyUtilities +
+		result.MonthlyLoanInterest
+	result.TotalHoldingCost = result.MonthlyCarryingCost * float64(result.HoldingMonths)
+	result.TotalProjectCost = req.PurchasePrice + req.ClosingCosts + req.RehabCost + result.TotalHoldingCost
+
+	commissionRate := req.AgentCommission / 100
+	result.AgentCommission = req.ARV * commissionRate
+	result.NetSaleProceeds = req.ARV - result.AgentCommission - req.SellingCosts
+	result.Profit = result.NetSaleProceeds - result.TotalProjectCost
+	result.ROI = result.Profit / result.TotalProjectCost * 100
+
+	// Compound the return over the holding period. A deal bought and sold
+	// in the same month has no period to annualize over.
+	if result.HoldingMonths > 0 {
+		growth := 1 + result.ROI/100
+		if growth > 0 {
+			result.AnnualizedROI = (math.Pow(growth, 12/float64(result.HoldingMonths)) - 1) * 100
+		} else {
+			result.AnnualizedROI = -100
+		}
+	} else {
+		result.Warnings = append(result.Warnings, "No holding period - annualized ROI is not meaningful")
+	}
+
+	// The sale price at which the net proceeds just cover the project
+	result.BreakEvenPrice = (result.TotalProjectCost + req.SellingCosts) / (1 - commissionRate)
+
+	if req.LoanAmount > 0 && req.LoanInterestRate == 0 {
+		result.Warnings = append(result.Warnings, "Loan amount given without an interest rate - no loan interest included")
+	}
+	if result.Profit < 0 {
+		result.Warnings = append(result.Warnings, "WARNING: Flip loses money at the expected sale price")
+	}
+
+	result.MonthlyLoanInterest = roundCents(result.MonthlyLoanInterest)
+	result.MonthlyCarryingCost = roundCents(result.MonthlyCarryingCost)
+	result.TotalHoldingCost = roundCents(result.TotalHoldingCost)
+	result.TotalProjectCost = roundCents(result.TotalProjectCost)
+	result.AgentCommission = roundCents(result.AgentCommission)
+	result.NetSaleProceeds = roundCents(result.NetSaleProceeds)
+	result.Profit = roundCents(result.Profit)
+	result.ROI = roundCents(result.ROI)
+	result.AnnualizedROI = roundCents(result.AnnualizedROI)
+	result.BreakEvenPrice = roundCents(result.BreakEvenPrice)
+	return result
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateFlip_HandComputedExample(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateFlip(FlipRequest{
+		PurchasePrice:    150000,
+		RehabCost:        40000,
+		ClosingCosts:     3000,
+		ARV:              260000,
+		SellingCosts:     2000,
+		RehabMonths:      4,
+		ListingMonths:    2,
+		MonthlyTaxes:     250,
+		MonthlyInsurance: 100,
+		MonthlyUtilities: 150,
+		LoanAmount:       150000,
+		LoanInterestRate: 12,
+		AgentCommission:  6,
+	})
+
+	// 150,000 at 12% interest-only is 1,500 a month, plus 500 of taxes,
+	// insurance and utilities, for 6 months
+	assert.Equal(t, 6, result.HoldingMonths)
+	assert.Equal(t, 1500.0, result.MonthlyLoanInterest)
+	assert.Equal(t, 2000.0, result.MonthlyCarryingCost)
+	assert.Equal(t, 12000.0, result.TotalHoldingCost)
+	assert.Equal(t, 205000.0, result.TotalProjectCost)
+
+	// 260,000 less 6% commission (15,600) and 2,000 of selling costs
+	assert.Equal(t, 15600.0, result.AgentCommission)
+	assert.Equal(t, 242400.0, result.NetSaleProceeds)
+	assert.Equal(t, 37400.0, result.Profit)
+	assert.Equal(t, 18.24, result.ROI)                // 37,400 / 205,000
+	assert.Equal(t, 39.82, result.AnnualizedROI)      // 1.18244^2 - 1
+	assert.Equal(t, 220212.77, result.BreakEvenPrice) // 207,000 / 0.94
+	assert.Empty(t, result.Warnings)
+}
+
+func TestCalculateFlip_ZeroMonthRehab(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateFlip(FlipRequest{
+		PurchasePrice:    100000,
+		ARV:              110000,
+		ListingMonths:    3,
+		MonthlyTaxes:     200,
+		MonthlyUtilities: 100,
+	})
+
+	assert.Equal(t, 3, result.HoldingMonths)
+	assert.Equal(t, 900.0, result.TotalHoldingCost)
+	assert.Equal(t, 9100.0, result.Profit)
+	assert.Equal(t, 9.02, result.ROI)
+	assert.Equal(t, 41.26, result.AnnualizedROI)
+	assert.Equal(t, 100900.0, result.BreakEvenPrice)
+}
+
+func TestCalculateFlip_NoHoldingPeriod(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateFlip(FlipRequest{
+		PurchasePrice:    100000,
+		ARV:              105000,
+		MonthlyTaxes:     200,
+		LoanAmount:       80000,
+		LoanInterestRate: 10,
+	})
+
+	assert.Equal(t, 0, result.HoldingMonths)
+	assert.Equal(t, 666.67, result.MonthlyLoanInterest)
+	assert.Equal(t, 0.0, result.TotalHoldingCost)
+	assert.Equal(t, 5.0, result.ROI)
+	assert.Equal(t, 0.0, result.AnnualizedROI)
+	assert.Contains(t, result.Warnings, "No holding period - annualized ROI is not meaningful")
+}
+
+func TestCalculateFlip_Loss(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateFlip(FlipRequest{
+		PurchasePrice:   200000,
+		RehabCost:       50000,
+		ARV:             240000,
+		RehabMonths:     6,
+		AgentCommission: 5,
+	})
+
+	assert.Equal(t, -22000.0, result.Profit)
+	assert.Equal(t, -8.8, result.ROI)
+	assert.Equal(t, -16.83, result.AnnualizedROI)
+	assert.Equal(t, 263157.89, result.BreakEvenPrice)
+	assert.Contains(t, result.Warnings, "WARNING: Flip loses money at the expected sale price")
+}
@@ -0,0 +1,77 @@
+package services
+
+// defaultHardMoneyTermMonths is assumed when a hard money loan is given
+// without a term
+const defaultHardMoneyTermMonths = 6
+
+// HardMoneyBreakdown splits a hard money loan's cost into points and
+// interest. Payoff is what the refinance has to pay back: the full loan
+// when it's interest-only, or the balloon left after a term of payments on
+// a 30-year schedule.
+type HardMoneyBreakdown struct {
+	LoanAmount     float64 `json:"loan_amount"`
+	OriginationFee float64 `json:"origination_fee"` // points
+	Interest       float64 `json:"interest"`        // over the whole term
+	MonthlyPayment float64 `json:"monthly_payment"`
+	TermMonths     int     `json:"term_months"`
+	InterestOnly   bool    `json:"interest_only"`
+	Payoff         float64 `json:"payoff"`
+	TotalCost      float64 `json:"total_cost"`    // origination fee plus interest
+	CashToClose    float64 `json:"cash_to_close"` // down payment, closing costs and points
+}
+
+// calculateHardMoney models the hard money loan in req, which lends
+// HardMoneyLTC percent of the purchase price and rehab cost. It returns nil
+// when req has no hard money loan.
+func (s *ArvService) calculateHardMoney(req ArvRequest, result *ArvResult) *HardMoneyBreakdown {
+	if req.HardMoneyLTC == 0 {
+		return nil
+	}
+
+	termMonths := req.HardMoneyTermMonths
+	if termMonths == 0 {
+		termMonths = defaultHardMoneyTermMonths
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnHardMoneyTermDefaulted, Severity: SeverityInfo, Field: "hard_money_term_months",
+			Message: "Using default hard money term of 6 months",
+		})
+		result.assume("hard_money_term_months", float64(termMonths), "Typical 6-month hard money term")
+	}
+
+	loan := &HardMoneyBreakdown{
+		LoanAmount:   (req.PurchasePrice + req.RehabCost) * req.HardMoneyLTC / 100,
+		TermMonths:   termMonths,
+		InterestOnly: req.HardMoneyInterestOnly,
+	}
+	loan.OriginationFee = loan.LoanAmount * req.HardMoneyPoints / 100
+
+	if req.HardMoneyInterestOnly {
+		loan.MonthlyPayment = loan.LoanAmount * req.HardMoneyRate / 100 / 12
+		loan.Interest = loan.MonthlyPayment * float64(termMonths)
+		loan.Payoff = loan.LoanAmount
+	} else {
+		loan.MonthlyPayment = s.calculateMonthlyPayment(loan.LoanAmount, req.HardMoneyRate, 30)
+		monthlyRate := req.HardMoneyRate / 100 / 12
+		loan.Payoff = loan.LoanAmount
+		for month := 0; month < termMonths; month++ {
+			interest := loan.Payoff * monthlyRate
+			loan.Interest += interest
+			loan.Payoff -= loan.MonthlyPayment - interest
+		}
+	}
+
+	loan.TotalCost = loan.OriginationFee + loan.Interest
+	loan.CashToClose = req.PurchasePrice + req.RehabCost - loan.LoanAmount + req.ClosingCosts + loan.OriginationFee
+	return loan
+}
+
+// roundHardMoney rounds a hard money breakdown's amounts to the cent
+func roundHardMoney(loan *HardMoneyBreakdown) {
+	loan.LoanAmount = roundCents(loan.LoanAmount)
+	loan.OriginationFee = roundCents(loan.OriginationFee)
+	loan.Interest = roundCents(loan.Interest)
+	loan.MonthlyPayment = roundCents(loan.MonthlyPayment)
+	loan.Payoff = roundCents(loan.Payoff)
+	loan.TotalCost = roundCents(loan.TotalCost)
+	loan.CashToClose = roundCents(loan.CashToClose)
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"math"
+)
+
+// IRR outcomes reported with a projection
+const (
+	IRRConverged    = "converged"
+	IRRNoInvestment = "no_investment" // nothing is left in the deal, so the return is unbounded
+	IRRNotConverged = "not_converged"
+)
+
+// netPresentValue discounts flows, one a year starting now, at rate (a
+// fraction, not a percentage)
+func netPresentValue(rate float64, flows []float64) float64 {
+	total := 0.0
+	for year, flow := range flows {
+		total += flow / math.Pow(1+rate, float64(year))
+	}
+	return total
+}
+
+// internalRateOfReturn finds the rate (a fraction) at which flows have a net
+// present value of zero. It tries Newton-Raphson first and falls back to
+// bisection, and reports false when neither finds a rate.
+func internalRateOfReturn(flows []float64) (float64, bool) {
+	rate := 0.1
+	for i := 0; i < 100; i++ {
+		value, slope := 0.0, 0.0
+		for year, flow := range flows {
+			discount := math.Pow(1+rate, float64(year))
+			value += flow / discount
+			slope -= float64(year) * flow / (discount * (1 + rate))
+		}
+		if math.Abs(value) < 1e-7 {
+			return rate, true
+		}
+		if slope == 0 || math.IsNaN(slope) {
+			break
+		}
+		rate -= value / slope
+		if rate <= -1 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			break
+		}
+	}
+
+	// Bisection needs the net present value to change sign across the
+	// bracket
+	lo, hi := -0.9999, 10.0
+	valueLo, valueHi := netPresentValue(lo, flows), netPresentValue(hi, flows)
+	if math.IsNaN(valueLo) || math.IsNaN(valueHi) || valueLo*valueHi > 0 {
+		return 0, false
+	}
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		value := netPresentValue(mid, flows)
+		if math.Abs(value) < 1e-7 || hi-lo < 1e-12 {
+			return mid, true
+		}
+		if value*valueLo < 0 {
+			hi = mid
+		} else {
+			lo, valueLo = mid, value
+		}
+	}
+	return (lo + hi) / 2, true
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternalRateOfReturn_KnownAnswers(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		flows []float64
+		irr   float64
+	}{
+		{"one year", []float64{-100, 110}, 0.10},
+		{"bond at par", []float64{-1000, 100, 100, 1100}, 0.10},
+		{"single payoff", []float64{-100, 0, 0, 133.1}, 0.10},
+		{"annuity", []float64{-1000, 300, 400, 500}, 0.0889633947},
+		{"loss", []float64{-1000, 100, 100, 500}, -0.1279085433},
+		{"high return", []float64{-100, 300}, 2.0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rate, ok := internalRateOfReturn(tc.flows)
+			require.True(t, ok)
+			assert.InDelta(t, tc.irr, rate, 1e-6)
+			assert.InDelta(t, 0, netPresentValue(rate, tc.flows), 1e-5)
+		})
+	}
+}
+
+func TestInternalRateOfReturn_NoSignChange(t *testing.T) {
+	_, ok := internalRateOfReturn([]float64{-100, -50, -10})
+	assert.False(t, ok)
+
+	_, ok = internalRateOfReturn([]float64{100, 50})
+	assert.False(t, ok)
+}
+
+func TestNetPresentValue_KnownAnswer(t *testing.T) {
+	assert.InDelta(t, 243.43, netPresentValue(0.10, []float64{-1000, 500, 500, 500}), 0.005)
+	assert.Equal(t, 500.0, netPresentValue(0, []float64{-1000, 500, 500, 500}))
+}
+
+func TestProjectCashFlows_IRRAndNPV(t *testing.T) {
+	service := NewArvService()
+
+	projection := service.ProjectCashFlows(ProjectionRequest{
+		ArvRequest:      projectionDeal(),
+		Years:           5,
+		RentGrowth:      3,
+		Appreciation:    3,
+		SaleCostPercent: 6,
+		DiscountRate:    10,
+	})
+
+	last := projection.Years[4]
+	proceeds := roundCents(last.PropertyValue*0.94 - last.LoanBalance)
+	assert.Equal(t, proceeds, projection.SaleProceeds)
+
+	flows := []float64{-14000}
+	for _, year := range projection.Years {
+		flows = append(flows, year.CashFlow)
+	}
+	flows[5] += proceeds
+
+	assert.Equal(t, 10.0, projection.DiscountRate)
+	assert.Equal(t, roundCents(netPresentValue(0.10, flows)), projection.NPV)
+	assert.Equal(t, IRRConverged, projection.IRRStatus)
+	require.NotNil(t, projection.IRR)
+	assert.InDelta(t, 0, netPresentValue(*projection.IRR/100, flows), 5, "IRR is rounded to a hundredth of a percent")
+	assert.Greater(t, *projection.IRR, 10.0)
+}
+
+func TestProjectCashFlows_NothingLeftIn(t *testing.T) {
+	service := NewArvService()
+	deal := projectionDeal()
+	deal.RehabCost = 20000 // a 150,000 refinance recovers all 144,000
+
+	projection := service.ProjectCashFlows(ProjectionRequest{ArvRequest: deal})
+
+	assert.Equal(t, 0.0, projection.CashLeftIn)
+	assert.Equal(t, IRRNoInvestment, projection.IRRStatus)
+	assert.Nil(t, projection.IRR)
+	assert.Equal(t, defaultDiscountRate, projection.DiscountRate)
+	assert.Greater(t, projection.NPV, 0.0)
+}
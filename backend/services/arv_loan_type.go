@@ -0,0 +1,192 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"arvfinder-backend/models"
+)
+
+// Refinance loan types
+const (
+	LoanTypeFixed        = "fixed"
+	LoanTypeInterestOnly = "interest_only"
+	LoanTypeARM          = "arm"
+)
+
+// Loan type terms assumed when a request doesn't give its own
+const (
+	defaultInterestOnlyYears     = 10
+	defaultARMInitialYears       = 5
+	defaultARMFirstAdjustmentCap = 2.0 // percentage points
+	defaultARMLifetimeCap        = 5.0 // percentage points
+)
+
+// LoanReset is the payment change when a refinance loan's interest-only
+// period ends or its adjustable rate resets. The cash flow and DSCR after
+// it hold the first year's income and expenses constant.
+type LoanReset struct {
+	Month           int     `json:"month"`   // first payment at the new amount
+	Rate            float64 `json:"rate"`    // percentage, from the reset on
+	Balance         float64 `json:"balance"` // when the loan resets
+	PaymentBefore   float64 `json:"payment_before"`
+	PaymentAfter    float64 `json:"payment_after"`     // principal and interest
+	MonthlyCashFlow float64 `json:"monthly_cash_flow"` // after the reset
+	AnnualCashFlow  float64 `json:"annual_cash_flow"`
+	DSCR            float64 `json:"dscr"`
+}
+
+// loanTerms are how a refinance loan is paid: at rate, interest only when
+// interestOnly, until resetMonth, then amortizing at resetRate over what's
+// left of the term. A loan that doesn't reset has no resetMonth.
+type loanTerms struct {
+	months       int
+	rate         float64
+	interestOnly bool
+	resetMonth   int
+	resetRate    float64
+}
+
+// setLoanTypeDefaults fills in the interest-only period or ARM terms req's
+// loan type needs, after the loan term's been defaulted
+func setLoanTypeDefaults(req *ArvRequest, result *ArvResult) {
+	switch req.LoanType {
+	case LoanTypeInterestOnly:
+		if req.InterestOnlyYears == 0 {
+			req.InterestOnlyYears = defaultInterestOnlyYears
+			result.assume("interest_only_years", float64(req.InterestOnlyYears), "Typical 10-year interest-only period")
+		}
+	case LoanTypeARM:
+		if req.ARMInitialYears == 0 {
+			req.ARMInitialYears = defaultARMInitialYears
+			result.assume("arm_initial_years", float64(req.ARMInitialYears), "5/1 ARM: 5 years at the initial rate")
+		}
+		if req.ARMFirstAdjustmentCap == 0 {
+			req.ARMFirstAdjustmentCap = defaultARMFirstAdjustmentCap
+			result.assume("arm_first_adjustment_cap", req.ARMFirstAdjustmentCap, "2-point first adjustment cap")
+		}
+		if req.ARMLifetimeCap == 0 {
+			req.ARMLifetimeCap = defaultARMLifetimeCap
+			result.assume("arm_lifetime_cap", req.ARMLifetimeCap, "5-point lifetime cap")
+		}
+		if req.ARMAdjustedRate == 0 {
+			req.ARMAdjustedRate = req.InterestRate + math.Min(req.ARMFirstAdjustmentCap, req.ARMLifetimeCap)
+			result.assume("arm_adjusted_rate", req.ARMAdjustedRate, "Worst case: the initial rate plus its cap")
+		}
+	default:
+		req.LoanType = LoanTypeFixed
+	}
+}
+
+// refinanceTerms returns how req's refinance loan is paid. A reset at or
+// after the end of the term leaves the loan fixed.
+func (req ArvRequest) refinanceTerms() loanTerms {
+	terms := loanTerms{months: req.LoanTerm * 12, rate: req.InterestRate}
+	switch req.LoanType {
+	case LoanTypeInterestOnly:
+		if req.InterestOnlyYears > 0 && req.InterestOnlyYears < req.LoanTerm {
+			terms.interestOnly = true
+			terms.resetMonth = req.InterestOnlyYears*12 + 1
+			terms.resetRate = req.InterestRate
+		}
+	case LoanTypeARM:
+		if req.ARMInitialYears > 0 && req.ARMInitialYears < req.LoanTerm {
+			terms.resetMonth = req.ARMInitialYears*12 + 1
+			terms.resetRate = armResetRate(req)
+		}
+	}
+	return terms
+}
+
+// armResetRate is the rate req's ARM resets to: its assumed adjusted rate,
+// moved at most the first adjustment cap from the initial rate and never
+// more than the lifetime cap above it
+func armResetRate(req ArvRequest) float64 {
+	rate := math.Max(req.ARMAdjustedRate, req.InterestRate-req.ARMFirstAdjustmentCap)
+	rate = math.Min(rate, req.InterestRate+req.ARMFirstAdjustmentCap)
+	rate = math.Min(rate, req.InterestRate+req.ARMLifetimeCap)
+	return math.Max(rate, 0)
+}
+
+// resets reports whether the loan's payment changes partway through
+func (t loanTerms) resets() bool {
+	return t.resetMonth > 0
+}
+
+// initialPayment is the monthly principal and interest on principal until
+// any reset
+func (t loanTerms) initialPayment(principal float64) float64 {
+	if t.interestOnly {
+		return principal * t.rate / 100 / 12
+	}
+	return monthlyPayment(principal, t.rate, t.months)
+}
+
+// schedule amortizes principal at payment a month until the reset, then
+// works out the payment that pays off what's left by the end of the term
+func (t loanTerms) schedule(principal, payment float64, extra prepayments) ([]AmortizationPayment, models.Money) {
+	schedule, totalInterest := amortizeWithPrepayments(principal, t.rate, t.months, payment, extra)
+	if !t.resets() || len(schedule) < t.resetMonth {
+		return schedule, totalInterest
+	}
+
+	schedule = schedule[:t.resetMonth-1]
+	balance := models.MoneyFromFloat(principal)
+	totalInterest = 0
+	for _, row := range schedule {
+		totalInterest += row.Interest
+		balance = row.Balance
+	}
+	remaining := t.months - len(schedule)
+	after, afterInterest := amortizeWithPrepayments(balance.Float64(), t.resetRate, remaining,
+		monthlyPayment(balance.Float64(), t.resetRate, remaining), extra)
+	for _, row := range after {
+		row.PaymentNumber += len(schedule)
+		schedule = append(schedule, row)
+	}
+	return schedule, totalInterest + afterInterest
+}
+
+// calculateLoanReset works out the payment change at the reset of the
+// refinance loan in result, and warns when it turns the cash flow negative.
+// It returns nil for a loan that doesn't reset.
+func (s *ArvService) calculateLoanReset(terms loanTerms, result *ArvResult) *LoanReset {
+	if !terms.resets() || result.MonthlyDebtService == 0 {
+		return nil
+	}
+	principal, payment, mortgageInsurance := result.RefinanceAmount, result.MonthlyDebtService, 0.0
+	if result.Mortgage != nil {
+		principal, payment = result.Mortgage.FinancedLoanAmount, result.Mortgage.PrincipalAndInterest
+		mortgageInsurance = result.Mortgage.MortgageInsurance
+	}
+	schedule, _ := terms.schedule(principal, roundCents(payment), prepayments{})
+	if len(schedule) < terms.resetMonth {
+		return nil
+	}
+
+	reset := &LoanReset{
+		Month:         terms.resetMonth,
+		Rate:          roundCents(terms.resetRate),
+		Balance:       roundCents(principal),
+		PaymentBefore: roundCents(payment),
+		PaymentAfter:  schedule[terms.resetMonth-1].Payment.Float64(),
+	}
+	if terms.resetMonth > 1 {
+		reset.Balance = schedule[terms.resetMonth-2].Balance.Float64()
+	}
+	debtService := reset.PaymentAfter + mortgageInsurance
+	reset.MonthlyCashFlow = roundCents(result.NOI/12 - debtService)
+	reset.AnnualCashFlow = roundCents(reset.MonthlyCashFlow * 12)
+	if debtService > 0 {
+		reset.DSCR = roundCents(result.NOI / (debtService * 12))
+	}
+
+	if reset.MonthlyCashFlow < 0 {
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnCashFlowNegativeAfterReset, Severity: SeverityWarning, Field: "loan_type",
+			Message: fmt.Sprintf("Cash flow turns negative at $%.2f a month when the loan payment rises to $%.2f in month %d",
+				reset.MonthlyCashFlow, reset.PaymentAfter, reset.Month),
+		})
+	}
+	return reset
+}
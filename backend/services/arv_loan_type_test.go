@@ -0,0 +1,130 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loanTypeDeal refinances $150,000 at 6% over 30 years, with $925 a month
+// of NOI
+func loanTypeDeal() ArvRequest {
+	return ArvRequest{
+		PurchasePrice: 120000, RehabCost: 20000, ARV: 200000,
+		MonthlyRent: 1500, VacancyRate: 5,
+		PropertyTaxes: 2400, Insurance: 1200, Maintenance: 1200, CapEx: 1200,
+		InterestRate: 6, LoanTerm: 30,
+	}
+}
+
+func TestRefinanceTerms_InterestOnlyBoundary(t *testing.T) {
+	req := loanTypeDeal()
+	req.LoanType = LoanTypeInterestOnly
+	req.InterestOnlyYears = 10
+	terms := req.refinanceTerms()
+
+	schedule, _ := terms.schedule(150000, terms.initialPayment(150000), prepayments{})
+
+	require.Len(t, schedule, 360)
+	lastIO, firstAmortizing := schedule[119], schedule[120]
+	assert.Equal(t, 120, lastIO.PaymentNumber)
+	assert.Equal(t, dollars(750), lastIO.Payment)
+	assert.Zero(t, lastIO.Principal)
+	assert.Equal(t, dollars(150000), lastIO.Balance)
+
+	// Month 121 amortizes the untouched balance over the 20 years left
+	assert.Equal(t, 121, firstAmortizing.PaymentNumber)
+	assert.Equal(t, dollars(1074.65), firstAmortizing.Payment)
+	assert.Equal(t, dollars(750), firstAmortizing.Interest)
+	assert.Equal(t, dollars(324.65), firstAmortizing.Principal)
+	assert.Zero(t, schedule[359].Balance)
+}
+
+func TestCalculateARV_InterestOnlyReset(t *testing.T) {
+	service := NewArvService()
+	req := loanTypeDeal()
+	req.LoanType = LoanTypeInterestOnly
+
+	result := service.CalculateARV(req)
+
+	assert.Equal(t, LoanTypeInterestOnly, result.LoanType)
+	assert.Equal(t, 750.0, result.MonthlyDebtService)
+	assert.Equal(t, 175.0, result.MonthlyCashFlow)
+	assert.Equal(t, 1.23, result.DSCR)
+	assert.Contains(t, result.Assumptions, Assumption{
+		Field: "interest_only_years", Value: 10, Heuristic: "Typical 10-year interest-only period",
+	})
+
+	require.NotNil(t, result.LoanReset)
+	assert.Equal(t, LoanReset{
+		Month: 121, Rate: 6, Balance: 150000,
+		PaymentBefore: 750, PaymentAfter: 1074.65,
+		MonthlyCashFlow: -149.65, AnnualCashFlow: -1795.8, DSCR: 0.86,
+	}, *result.LoanReset)
+	warning := findNotice(t, result.Warnings, WarnCashFlowNegativeAfterReset)
+	assert.Equal(t, "loan_type", warning.Field)
+}
+
+func TestProjectCashFlows_InterestOnlyReset(t *testing.T) {
+	service := NewArvService()
+	req := ProjectionRequest{ArvRequest: loanTypeDeal(), Years: 11}
+	req.LoanType = LoanTypeInterestOnly
+
+	projection := service.ProjectCashFlows(req)
+
+	year10, year11 := projection.Years[9], projection.Years[10]
+	assert.Equal(t, 9000.0, year10.DebtService)
+	assert.Equal(t, 150000.0, year10.LoanBalance)
+	assert.Equal(t, 12895.8, year11.DebtService)
+	assert.Less(t, year11.LoanBalance, 150000.0)
+	assert.Negative(t, year11.CashFlow)
+}
+
+func TestCalculateARV_ARMReset(t *testing.T) {
+	service := NewArvService()
+	req := loanTypeDeal()
+	req.LoanType = LoanTypeARM
+
+	result := service.CalculateARV(req)
+
+	// A 5/1 ARM pays the fixed payment at 6% until it resets to 8%
+	assert.Equal(t, 899.33, result.MonthlyDebtService)
+	require.NotNil(t, result.LoanReset)
+	assert.Equal(t, 61, result.LoanReset.Month)
+	assert.Equal(t, 8.0, result.LoanReset.Rate)
+	assert.Equal(t, 899.33, result.LoanReset.PaymentBefore)
+	assert.Greater(t, result.LoanReset.PaymentAfter, result.LoanReset.PaymentBefore)
+	assert.Less(t, result.LoanReset.Balance, 150000.0)
+	findNotice(t, result.Warnings, WarnCashFlowNegativeAfterReset)
+}
+
+func TestArmResetRate_Caps(t *testing.T) {
+	req := ArvRequest{InterestRate: 6, ARMFirstAdjustmentCap: 2, ARMLifetimeCap: 5}
+
+	req.ARMAdjustedRate = 7
+	assert.Equal(t, 7.0, armResetRate(req))
+	req.ARMAdjustedRate = 12
+	assert.Equal(t, 8.0, armResetRate(req)) // first adjustment cap
+	req.ARMAdjustedRate = 2
+	assert.Equal(t, 4.0, armResetRate(req))
+
+	req.ARMFirstAdjustmentCap, req.ARMLifetimeCap = 5, 3
+	req.ARMAdjustedRate = 12
+	assert.Equal(t, 9.0, armResetRate(req)) // lifetime cap
+}
+
+func TestCalculateARV_FixedLoanHasNoReset(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(loanTypeDeal())
+
+	assert.Equal(t, LoanTypeFixed, result.LoanType)
+	assert.Nil(t, result.LoanReset)
+
+	// An interest-only period as long as the loan never resets
+	req := loanTypeDeal()
+	req.LoanType, req.InterestOnlyYears = LoanTypeInterestOnly, 30
+	result = service.CalculateARV(req)
+	assert.Nil(t, result.LoanReset)
+}
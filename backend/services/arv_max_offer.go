@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+)
+
+// ErrMaxOfferTarget is returned unless exactly one of a target profit and a
+// target margin is given
+var ErrMaxOfferTarget = errors.New("give either target_profit or target_margin")
+
+// MaxOfferRequest is a deal without a purchase price and the profit it has
+// to make. Closing and financing costs can be fixed amounts, percentages of
+// the purchase price, or both. TargetMargin is profit as a percentage of
+// the total investment, like ArvResult.ProfitMargin.
+type MaxOfferRequest struct {
+	ARV                  float64 `json:"arv" binding:"required,min=1"`
+	RehabCost            float64 `json:"rehab_cost" binding:"min=0"`
+	HoldingCosts         float64 `json:"holding_costs" binding:"min=0"`
+	ClosingCosts         float64 `json:"closing_costs" binding:"min=0"`
+	ClosingCostPercent   float64 `json:"closing_cost_percent" binding:"min=0,max=20"`
+	FinancingCosts       float64 `json:"financing_costs" binding:"min=0"`
+	FinancingCostPercent float64 `json:"financing_cost_percent" binding:"min=0,max=20"`
+	SellingCosts         float64 `json:"selling_costs" binding:"min=0"`
+	MonthlyRent          float64 `json:"monthly_rent" binding:"min=0"` // for the rental figures in the result
+
+	TargetProfit *float64 `json:"target_profit" binding:"omitempty,min=0"`
+	TargetMargin *float64 `json:"target_margin" binding:"omitempty,min=0,max=1000"`
+}
+
+// MaxOfferResult is the highest purchase price that makes the target, and
+// the full analysis at that price. Result is nil when no positive price
+// does.
+type MaxOfferResult struct {
+	MaxOffer       float64    `json:"max_offer"`
+	ClosingCosts   float64    `json:"closing_costs"`
+	FinancingCosts float64    `json:"financing_costs"`
+	Feasible       bool       `json:"feasible"`
+	Result         *ArvResult `json:"result"`
+	Warnings       []string   `json:"warnings"`
+}
+
+// SolveMaxOffer finds the most that can be paid for a property while still
+// making a target profit or margin
+func (s *ArvService) SolveMaxOffer(req MaxOfferRequest) (*MaxOfferResult, error) {
+	if (req.TargetProfit == nil) == (req.TargetMargin == nil) {
+		return nil, ErrMaxOfferTarget
+	}
+
+	// Everything but the purchase price and the costs that scale with it
+	fixedCosts := req.RehabCost + req.HoldingCosts + req.ClosingCosts + req.FinancingCosts
+	scale := 1 + (req.ClosingCostPercent+req.FinancingCostPercent)/100
+
+	// Profit is ARV - selling costs - total investment, so a target fixes
+	// the total investment and the price follows from it
+	var totalInvestment float64
+	if req.TargetProfit != nil {
+		totalInvestment = req.ARV - req.SellingCosts - *req.TargetProfit
+	} else {
+		totalInvestment = (req.ARV - req.SellingCosts) / (1 + *req.TargetMargin/100)
+	}
+	offer := (totalInvestment - fixedCosts) / scale
+
+	result := &MaxOfferResult{Warnings: []string{}}
+	if offer <= 0 {
+		result.Warnings = append(result.Warnings, "WARNING: No positive offer makes the target profit")
+		return result, nil
+	}
+
+	result.MaxOffer = roundCents(offer)
+	result.ClosingCosts = roundCents(req.ClosingCosts + result.MaxOffer*req.ClosingCostPercent/100)
+	result.FinancingCosts = roundCents(req.FinancingCosts + result.MaxOffer*req.FinancingCostPercent/100)
+	result.Feasible = true
+
+	calc := s.CalculateARV(ArvRequest{
+		PurchasePrice:  result.MaxOffer,
+		RehabCost:      req.RehabCost,
+		HoldingCosts:   req.HoldingCosts,
+		ClosingCosts:   result.ClosingCosts,
+		ARV:            req.ARV,
+		FinancingCosts: result.FinancingCosts,
+		SellingCosts:   req.SellingCosts,
+		MonthlyRent:    req.MonthlyRent,
+	})
+	result.Result = &calc
+	return result, nil
+}
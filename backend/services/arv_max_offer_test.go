@@ -0,0 +1,88 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSolveMaxOffer_TargetProfit(t *testing.T) {
+	service := NewArvService()
+	target := 30000.0
+
+	result, err := service.SolveMaxOffer(MaxOfferRequest{
+		ARV:                  250000,
+		RehabCost:            40000,
+		HoldingCosts:         6000,
+		ClosingCosts:         1000,
+		ClosingCostPercent:   2,
+		FinancingCostPercent: 3,
+		SellingCosts:         15000,
+		TargetProfit:         &target,
+	})
+	require.NoError(t, err)
+
+	// 250,000 - 15,000 - 30,000 leaves 205,000 to invest. Less 47,000 of
+	// fixed costs, that's 158,000 for the price and its 5% of costs.
+	assert.True(t, result.Feasible)
+	assert.Equal(t, 150476.19, result.MaxOffer)
+	assert.Equal(t, 4009.52, result.ClosingCosts)
+	assert.Equal(t, 4514.29, result.FinancingCosts)
+	require.NotNil(t, result.Result)
+	assert.Equal(t, 150476.19, result.Result.PurchasePrice)
+	assert.InDelta(t, target, result.Result.PotentialProfit, 0.01)
+	assert.Empty(t, result.Warnings)
+}
+
+func TestSolveMaxOffer_TargetMargin(t *testing.T) {
+	service := NewArvService()
+	margin := 20.0
+
+	result, err := service.SolveMaxOffer(MaxOfferRequest{
+		ARV:                200000,
+		RehabCost:          30000,
+		ClosingCostPercent: 3,
+		SellingCosts:       14000,
+		TargetMargin:       &margin,
+	})
+	require.NoError(t, err)
+
+	// 186,000 / 1.2 = 155,000 invested, 125,000 of it on the price and its
+	// 3% closing costs
+	assert.True(t, result.Feasible)
+	assert.Equal(t, 121359.22, result.MaxOffer)
+	assert.Equal(t, 3640.78, result.ClosingCosts)
+	require.NotNil(t, result.Result)
+	assert.Equal(t, 155000.0, result.Result.TotalInvestment)
+	assert.Equal(t, 20.0, result.Result.ProfitMargin)
+}
+
+func TestSolveMaxOffer_Infeasible(t *testing.T) {
+	service := NewArvService()
+	target := 50000.0
+
+	result, err := service.SolveMaxOffer(MaxOfferRequest{
+		ARV:          120000,
+		RehabCost:    60000,
+		SellingCosts: 10000,
+		TargetProfit: &target,
+	})
+	require.NoError(t, err)
+
+	assert.False(t, result.Feasible)
+	assert.Equal(t, 0.0, result.MaxOffer)
+	assert.Nil(t, result.Result)
+	assert.Contains(t, result.Warnings, "WARNING: No positive offer makes the target profit")
+}
+
+func TestSolveMaxOffer_NeedsOneTarget(t *testing.T) {
+	service := NewArvService()
+	target, margin := 30000.0, 15.0
+
+	_, err := service.SolveMaxOffer(MaxOfferRequest{ARV: 200000})
+	assert.ErrorIs(t, err, ErrMaxOfferTarget)
+
+	_, err = service.SolveMaxOffer(MaxOfferRequest{ARV: 200000, TargetProfit: &target, TargetMargin: &margin})
+	assert.ErrorIs(t, err, ErrMaxOfferTarget)
+}
@@ -0,0 +1,110 @@
+package services
+
+// Mortgage insurance assumed when a request doesn't give its own rates
+const (
+	defaultPMIRate       = 0.5  // annual percentage of the loan
+	defaultFHAUpfrontMIP = 1.75 // percentage of the loan, financed into it
+	defaultFHAAnnualMIP  = 0.55 // annual percentage of the loan
+)
+
+// pmiMaxLTV is the loan-to-value at or below which a conventional loan
+// doesn't need private mortgage insurance
+const pmiMaxLTV = 80.0
+
+// Kinds of mortgage insurance a loan can carry
+const (
+	MortgageInsuranceNone = "none"
+	MortgageInsurancePMI  = "pmi"
+	MortgageInsuranceMIP  = "fha_mip"
+)
+
+// MortgagePaymentRequest is the input for a PITI payment. A conventional
+// loan pays PMI at PMIRate (0.5% by default) while its LTV is over 80%; an
+// FHA loan always pays an upfront MIP, financed into the loan, and an
+// annual MIP (1.75% and 0.55% by default).
+type MortgagePaymentRequest struct {
+	PropertyValue float64 `json:"property_value" binding:"required,min=1"`
+	LoanAmount    float64 `json:"loan_amount" binding:"required,min=1"`
+	InterestRate  float64 `json:"interest_rate" binding:"min=0,max=30"`       // annual percentage
+	LoanTerm      int     `json:"loan_term" binding:"omitempty,min=1,max=50"` // years, default 30
+	PropertyTaxes float64 `json:"property_taxes" binding:"min=0"`             // annual
+	Insurance     float64 `json:"insurance" binding:"min=0"`                  // annual
+	HOADues       float64 `json:"hoa_dues" binding:"min=0"`                   // monthly
+	PMIRate       float64 `json:"pmi_rate" binding:"min=0,max=5"`             // annual percentage
+	FHA           bool    `json:"fha"`
+	FHAUpfrontMIP float64 `json:"fha_upfront_mip" binding:"min=0,max=5"` // percentage
+	FHAAnnualMIP  float64 `json:"fha_annual_mip" binding:"min=0,max=5"`  // annual percentage
+}
+
+// MortgagePayment breaks a monthly mortgage payment into principal and
+// interest, taxes, insurance, HOA dues and mortgage insurance.
+// FinancedLoanAmount is the loan with any upfront MIP rolled in.
+type MortgagePayment struct {
+	LoanAmount            float64 `json:"loan_amount"`
+	UpfrontMIP            float64 `json:"upfront_mip"`
+	FinancedLoanAmount    float64 `json:"financed_loan_amount"`
+	LTV                   float64 `json:"ltv"` // percentage, before any upfront MIP
+	PrincipalAndInterest  float64 `json:"principal_and_interest"`
+	PropertyTax           float64 `json:"property_tax"`
+	Insurance             float64 `json:"insurance"`
+	HOADues               float64 `json:"hoa_dues"`
+	MortgageInsurance     float64 `json:"mortgage_insurance"`
+	MortgageInsuranceType string  `json:"mortgage_insurance_type"`
+	Total                 float64 `json:"total"`
+}
+
+// CalculateMortgagePayment works out a loan's monthly PITI payment
+func (s *ArvService) CalculateMortgagePayment(req MortgagePaymentRequest) MortgagePayment {
+	term := req.LoanTerm
+	if term == 0 {
+		term = 30
+	}
+
+	payment := MortgagePayment{
+		LoanAmount:            req.LoanAmount,
+		FinancedLoanAmount:    req.LoanAmount,
+		PropertyTax:           req.PropertyTaxes / 12,
+		Insurance:             req.Insurance / 12,
+		HOADues:               req.HOADues,
+		MortgageInsuranceType: MortgageInsuranceNone,
+	}
+	if req.PropertyValue > 0 {
+		payment.LTV = req.LoanAmount / req.PropertyValue * 100
+	}
+
+	switch {
+	case req.FHA:
+		upfrontRate, annualRate := req.FHAUpfrontMIP, req.FHAAnnualMIP
+		if upfrontRate == 0 {
+			upfrontRate = defaultFHAUpfrontMIP
+		}
+		if annualRate == 0 {
+			annualRate = defaultFHAAnnualMIP
+		}
+		payment.UpfrontMIP = req.LoanAmount * upfrontRate / 100
+		payment.FinancedLoanAmount += payment.UpfrontMIP
+		payment.MortgageInsurance = req.LoanAmount * annualRate / 100 / 12
+		payment.MortgageInsuranceType = MortgageInsuranceMIP
+	case payment.LTV > pmiMaxLTV:
+		rate := req.PMIRate
+		if rate == 0 {
+			rate = defaultPMIRate
+		}
+		payment.MortgageInsurance = req.LoanAmount * rate / 100 / 12
+		payment.MortgageInsuranceType = MortgageInsurancePMI
+	}
+
+	payment.PrincipalAndInterest = s.calculateMonthlyPayment(payment.FinancedLoanAmount, req.InterestRate, term)
+
+	payment.UpfrontMIP = roundCents(payment.UpfrontMIP)
+	payment.FinancedLoanAmount = roundCents(payment.FinancedLoanAmount)
+	payment.LTV = roundCents(payment.LTV)
+	payment.PrincipalAndInterest = roundCents(payment.PrincipalAndInterest)
+	payment.PropertyTax = roundCents(payment.PropertyTax)
+	payment.Insurance = roundCents(payment.Insurance)
+	payment.HOADues = roundCents(payment.HOADues)
+	payment.MortgageInsurance = roundCents(payment.MortgageInsurance)
+	payment.Total = roundCents(payment.PrincipalAndInterest + payment.PropertyTax + payment.Insurance +
+		payment.HOADues + payment.MortgageInsurance)
+	return payment
+}
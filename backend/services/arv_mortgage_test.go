@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateMortgagePayment_PMIDropsOffAtExactly80LTV(t *testing.T) {
+	service := NewArvService()
+	req := MortgagePaymentRequest{
+		PropertyValue: 300000,
+		LoanAmount:    240000,
+		InterestRate:  6.5,
+		PropertyTaxes: 3600,
+		Insurance:     1200,
+		HOADues:       50,
+	}
+
+	// 240,000 is exactly 80% of 300,000, so no PMI
+	atLimit := service.CalculateMortgagePayment(req)
+	assert.Equal(t, 80.0, atLimit.LTV)
+	assert.Equal(t, MortgageInsuranceNone, atLimit.MortgageInsuranceType)
+	assert.Equal(t, 0.0, atLimit.MortgageInsurance)
+	assert.Equal(t, 1516.96, atLimit.PrincipalAndInterest)
+	assert.Equal(t, 300.0, atLimit.PropertyTax)
+	assert.Equal(t, 100.0, atLimit.Insurance)
+	assert.Equal(t, 50.0, atLimit.HOADues)
+	assert.Equal(t, 1966.96, atLimit.Total)
+
+	// Thirty dollars more tips it over 80% and PMI at the default 0.5% kicks in
+	req.LoanAmount = 240030
+	overLimit := service.CalculateMortgagePayment(req)
+	assert.Equal(t, 80.01, overLimit.LTV)
+	assert.Equal(t, MortgageInsurancePMI, overLimit.MortgageInsuranceType)
+	assert.Equal(t, 100.01, overLimit.MortgageInsurance)
+	assert.Equal(t, 1517.15, overLimit.PrincipalAndInterest)
+	assert.Equal(t, 2067.16, overLimit.Total)
+
+	req.PMIRate = 1
+	assert.Equal(t, 200.03, service.CalculateMortgagePayment(req).MortgageInsurance)
+}
+
+func TestCalculateMortgagePayment_FHAMortgageInsurance(t *testing.T) {
+	service := NewArvService()
+
+	payment := service.CalculateMortgagePayment(MortgagePaymentRequest{
+		PropertyValue: 300000,
+		LoanAmount:    285000,
+		InterestRate:  6.5,
+		FHA:           true,
+	})
+
+	// 1.75% upfront is financed into the loan, and 0.55% a year is paid
+	// monthly on the base loan
+	assert.Equal(t, 95.0, payment.LTV)
+	assert.Equal(t, MortgageInsuranceMIP, payment.MortgageInsuranceType)
+	assert.Equal(t, 4987.5, payment.UpfrontMIP)
+	assert.Equal(t, 289987.5, payment.FinancedLoanAmount)
+	assert.Equal(t, 1832.92, payment.PrincipalAndInterest)
+	assert.InDelta(t, 130.63, payment.MortgageInsurance, 0.01)
+	assert.InDelta(t, 1963.55, payment.Total, 0.01)
+}
+
+func TestCalculateARV_MortgageInsuranceInDebtService(t *testing.T) {
+	service := NewArvService()
+	req := ArvRequest{
+		PurchasePrice: 150000,
+		RehabCost:     30000,
+		ARV:           250000,
+		MonthlyRent:   2000,
+		VacancyRate:   5,
+		PropertyTaxes: 3000,
+		Insurance:     1200,
+		Maintenance:   1500,
+		CapEx:         1000,
+		InterestRate:  7,
+	}
+	without := service.CalculateARV(req)
+	assert.Nil(t, without.Mortgage)
+	assert.Equal(t, 1247.44, without.MonthlyDebtService)
+
+	req.FHA = true
+	req.HOADues = 40
+	result := service.CalculateARV(req)
+
+	// The 187,500 refinance plus upfront MIP, and MIP on top of the P&I
+	require.NotNil(t, result.Mortgage)
+	assert.Equal(t, 190781.25, result.Mortgage.FinancedLoanAmount)
+	assert.Equal(t, 1269.27, result.Mortgage.PrincipalAndInterest)
+	assert.Equal(t, 85.94, result.Mortgage.MortgageInsurance)
+	assert.Equal(t, 1355.21, result.MonthlyDebtService)
+
+	// HOA dues are an expense, not debt service
+	assert.Equal(t, without.AnnualExpenses+480, result.AnnualExpenses)
+	assert.InDelta(t, without.MonthlyCashFlow-40-(1355.21-1247.44), result.MonthlyCashFlow, 0.01)
+}
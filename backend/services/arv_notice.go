@@ -0,0 +1,107 @@
+package services
+
+// Notice severities, from least to most serious
+const (
+	SeverityPositive = "positive"
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Codes of the warnings an ARV analysis can raise about its inputs
+const (
+	WarnVacancyDefaulted           = "VACANCY_DEFAULTED"
+	WarnRentEstimated              = "RENT_ESTIMATED_1PCT"
+	WarnTaxesEstimated             = "TAXES_ESTIMATED"
+	WarnInsuranceEstimated         = "INSURANCE_ESTIMATED"
+	WarnMaintenanceEstimated       = "MAINTENANCE_ESTIMATED"
+	WarnCapExEstimated             = "CAPEX_ESTIMATED"
+	WarnInterestRateDefaulted      = "INTEREST_RATE_DEFAULTED"
+	WarnARVBelowCosts              = "ARV_BELOW_COSTS"
+	WarnVacancyHigh                = "VACANCY_HIGH"
+	WarnClosingCostsEstimated      = "CLOSING_COSTS_ESTIMATED"
+	WarnHardMoneyTermDefaulted     = "HARD_MONEY_TERM_DEFAULTED"
+	WarnSTRNoOccupancy             = "STR_NO_OCCUPANCY"
+	WarnSTRAverageStayDefaulted    = "STR_AVERAGE_STAY_DEFAULTED"
+	WarnLandValueEstimated         = "LAND_VALUE_ESTIMATED"
+	WarnPropertyMgmtDeprecated     = "PROPERTY_MGMT_DEPRECATED"
+	WarnCashFlowNegativeAfterReset = "CASH_FLOW_NEGATIVE_AFTER_RESET"
+)
+
+// Codes of the recommendations an ARV analysis can make about a deal
+const (
+	RecRuleNotMet             = "RULE_NOT_MET"
+	RecLowProfitMargin        = "LOW_PROFIT_MARGIN"
+	RecRehabOver30PctARV      = "REHAB_OVER_30PCT_ARV"
+	RecHoldingCostsHigh       = "HOLDING_COSTS_HIGH"
+	RecStrongProfit           = "STRONG_PROFIT"
+	RecHighEquity             = "HIGH_EQUITY"
+	RecModerateOpportunity    = "MODERATE_OPPORTUNITY"
+	RecNegativeCashFlow       = "NEGATIVE_CASH_FLOW"
+	RecLowCashFlow            = "LOW_CASH_FLOW"
+	RecDSCRBelow1             = "DSCR_BELOW_1"
+	RecDSCRBelow125           = "DSCR_BELOW_1_25"
+	RecStrongCashRecovery     = "STRONG_CASH_RECOVERY"
+	RecLimitedCashRecovery    = "LIMITED_CASH_RECOVERY"
+	RecLowCapRate             = "LOW_CAP_RATE"
+	RecHighCapRate            = "HIGH_CAP_RATE"
+	RecHighExpenseRatio       = "HIGH_EXPENSE_RATIO"
+	RecLowExpenseRatio        = "LOW_EXPENSE_RATIO"
+	RecBreakEvenImpossible    = "BREAK_EVEN_IMPOSSIBLE"
+	RecBreakEvenOccupancyHigh = "BREAK_EVEN_OCCUPANCY_HIGH"
+	RecSTROutperformsLTR      = "STR_OUTPERFORMS_LTR"
+	RecLTROutperformsSTR      = "LTR_OUTPERFORMS_STR"
+	RecInfiniteReturn         = "INFINITE_RETURN"
+	RecStrongBRRRR            = "STRONG_BRRRR"
+	RecModerateBRRRR          = "MODERATE_BRRRR"
+)
+
+// Notice is a warning or recommendation from an ARV analysis. Code is
+// stable for the frontend to translate or link to help, and Field names the
+// request field it's about, if any.
+type Notice struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Field    string `json:"field,omitempty"`
+}
+
+// NoticeMessages returns just the messages of notices, as response version
+// 1 reported them
+func NoticeMessages(notices []Notice) []string {
+	messages := make([]string, len(notices))
+	for i, notice := range notices {
+		messages[i] = notice.Message
+	}
+	return messages
+}
+
+// LegacyArvResult is an ArvResult with its warnings and recommendations as
+// plain messages, for clients asking for response version 1
+type LegacyArvResult struct {
+	ArvResult
+	Warnings        []string `json:"warnings"`
+	Recommendations []string `json:"recommendations"`
+}
+
+// Legacy returns the response version 1 form of r
+func (r ArvResult) Legacy() LegacyArvResult {
+	return LegacyArvResult{
+		ArvResult:       r,
+		Warnings:        NoticeMessages(r.Warnings),
+		Recommendations: NoticeMessages(r.Recommendations),
+	}
+}
+
+// Assumption is a value an analysis used in place of an input the request
+// left out, and the heuristic it came from
+type Assumption struct {
+	Field     string  `json:"field"`
+	Value     float64 `json:"value"`
+	Heuristic string  `json:"heuristic"`
+}
+
+// assume records that value was used for field
+func (r *ArvResult) assume(field string, value float64, heuristic string) {
+	r.Assumptions = append(r.Assumptions, Assumption{Field: field, Value: roundCents(value), Heuristic: heuristic})
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findNotice returns the notice with code, failing the test if there isn't one
+func findNotice(t *testing.T, notices []Notice, code string) Notice {
+	t.Helper()
+	for _, notice := range notices {
+		if notice.Code == code {
+			return notice
+		}
+	}
+	require.Failf(t, "notice not found", "no %s in %v", code, notices)
+	return Notice{}
+}
+
+func TestCalculateARV_NoticeCodes(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice: 60000,
+		RehabCost:     45000,
+		HoldingCosts:  5000,
+		ClosingCosts:  3000,
+		ARV:           120000,
+	})
+
+	rent := findNotice(t, result.Warnings, WarnRentEstimated)
+	assert.Equal(t, SeverityWarning, rent.Severity)
+	assert.Equal(t, "monthly_rent", rent.Field)
+	assert.Equal(t, "Monthly rent estimated using 1% rule - verify with market data", rent.Message)
+
+	vacancy := findNotice(t, result.Warnings, WarnVacancyDefaulted)
+	assert.Equal(t, SeverityInfo, vacancy.Severity)
+	assert.Equal(t, "vacancy_rate", vacancy.Field)
+
+	rehab := findNotice(t, result.Recommendations, RecRehabOver30PctARV)
+	assert.Equal(t, SeverityWarning, rehab.Severity)
+	assert.Equal(t, "rehab_cost", rehab.Field)
+}
+
+func TestCalculateEnhancedBRRRR_DSCRBelowOne(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateEnhancedBRRRR(ArvRequest{
+		PurchasePrice: 100000,
+		RehabCost:     20000,
+		ARV:           150000,
+		MonthlyRent:   600,
+		InterestRate:  7,
+		LoanTerm:      30,
+	})
+
+	require.Less(t, result.DSCR, 1.0)
+	dscr := findNotice(t, result.Recommendations, RecDSCRBelow1)
+	assert.Equal(t, SeverityCritical, dscr.Severity)
+}
+
+func TestArvResult_Legacy(t *testing.T) {
+	result := ArvResult{
+		ARV:      150000,
+		Warnings: []Notice{{Code: WarnVacancyDefaulted, Severity: SeverityInfo, Message: "Using default vacancy rate of 8%", Field: "vacancy_rate"}},
+		Recommendations: []Notice{
+			{Code: RecStrongProfit, Severity: SeverityPositive, Message: "Strong profit potential"},
+		},
+	}
+
+	data, err := json.Marshal(result.Legacy())
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, []interface{}{"Using default vacancy rate of 8%"}, decoded["warnings"])
+	assert.Equal(t, []interface{}{"Strong profit potential"}, decoded["recommendations"])
+	assert.Equal(t, 150000.0, decoded["arv"])
+}
+
+func TestCalculateARV_Assumptions(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{PurchasePrice: 100000, ARV: 150000})
+
+	assert.Equal(t, []Assumption{
+		{Field: "refinance_ltv", Value: 75, Heuristic: "Typical cash-out refinance LTV of 75%"},
+		{Field: "rule_percentage", Value: 70, Heuristic: "Classic 70% rule"},
+		{Field: "loan_term", Value: 30, Heuristic: "Standard 30-year mortgage"},
+		{Field: "vacancy_rate", Value: 8, Heuristic: "Market average vacancy of 8%"},
+		{Field: "monthly_rent", Value: 1500, Heuristic: "1% rule: 1% of ARV a month"},
+		{Field: "property_taxes", Value: 2250, Heuristic: "1.5% of ARV a year"},
+		{Field: "insurance", Value: 750, Heuristic: "0.5% of ARV a year"},
+		{Field: "maintenance", Value: 1800, Heuristic: "10% of gross rent"},
+		{Field: "capex", Value: 900, Heuristic: "5% of gross rent"},
+		{Field: "interest_rate", Value: 7, Heuristic: "Current market rate of 7%"},
+	}, result.Assumptions)
+
+	// The echoed inputs are the values the analysis used
+	assert.Equal(t, 1500.0, result.MonthlyRent)
+	assert.Equal(t, 8.0, result.VacancyRate)
+	assert.Equal(t, 2250.0, result.PropertyTaxes)
+	assert.Equal(t, 750.0, result.Insurance)
+	assert.Equal(t, 1800.0, result.Maintenance)
+	assert.Equal(t, 900.0, result.CapEx)
+	assert.Equal(t, 75.0, result.RefinanceLTV)
+	assert.Equal(t, 7.0, result.InterestRate)
+	assert.Equal(t, 30, result.LoanTerm)
+	assert.Equal(t, 70.0, result.RulePercentage)
+}
+
+func TestCalculateARV_NoAssumptionsForGivenInputs(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice:  100000,
+		ARV:            150000,
+		RulePercentage: 75,
+		MonthlyRent:    1400,
+		VacancyRate:    5,
+		PropertyTaxes:  2000,
+		Insurance:      900,
+		Maintenance:    1200,
+		CapEx:          600,
+		RefinanceLTV:   70,
+		InterestRate:   6.5,
+		LoanTerm:       25,
+	})
+
+	assert.Empty(t, result.Assumptions)
+	assert.Equal(t, 2000.0, result.PropertyTaxes)
+	assert.Equal(t, 25, result.LoanTerm)
+}
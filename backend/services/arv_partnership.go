@@ -0,0 +1,133 @@
+package services
+
+import (
+	"errors"
+	"math"
+)
+
+// Partnership split errors
+var (
+	ErrPartnershipEquity    = errors.New("partners' equity percentages must add up to 100")
+	ErrPartnershipNoCapital = errors.New("at least one partner must contribute capital")
+)
+
+// How a cash-out refinance's proceeds are split between partners
+const (
+	RefiProceedsCapital = "capital" // returned pro rata to capital first, the rest by equity
+	RefiProceedsEquity  = "equity"  // all by equity percentage
+)
+
+// Partner is one party to a deal: the cash they put in and their share of
+// the deal
+type Partner struct {
+	Name          string  `json:"name" binding:"required,max=100"`
+	Capital       float64 `json:"capital" binding:"min=0"`
+	EquityPercent float64 `json:"equity_percent" binding:"min=0,max=100"`
+}
+
+// PartnershipRequest is a deal and how its partners split it. The
+// preferred return is paid each year on the capital a partner still has in
+// the deal after the refinance, out of the cash flow, before the rest is
+// split by equity. A pref the cash flow can't cover isn't carried forward.
+type PartnershipRequest struct {
+	ArvRequest
+	Partners          []Partner `json:"partners" binding:"required,min=2,max=4,dive"`
+	PreferredReturn   float64   `json:"preferred_return" binding:"min=0,max=30"` // annual percentage
+	RefinanceProceeds string    `json:"refinance_proceeds" binding:"omitempty,oneof=capital equity"`
+}
+
+// PartnerReturn is what one partner puts into a deal and gets back
+type PartnerReturn struct {
+	Name               string   `json:"name"`
+	EquityPercent      float64  `json:"equity_percent"`
+	CashInvested       float64  `json:"cash_invested"`
+	CashReturnedAtRefi float64  `json:"cash_returned_at_refi"`
+	CapitalLeftIn      float64  `json:"capital_left_in"`
+	PreferredReturn    float64  `json:"preferred_return"` // annual, paid
+	AnnualCashFlow     float64  `json:"annual_cash_flow"` // preferred return plus equity share
+	MonthlyCashFlow    float64  `json:"monthly_cash_flow"`
+	CashOnCashReturn   *float64 `json:"cash_on_cash_return"` // on capital left in, null when infinite
+}
+
+// PartnershipSplit is how a deal's refinance proceeds and cash flow are
+// shared out between its partners
+type PartnershipSplit struct {
+	Partners                 []PartnerReturn `json:"partners"`
+	RefinanceProceeds        float64         `json:"refinance_proceeds"`
+	AnnualCashFlow           float64         `json:"annual_cash_flow"`
+	PreferredReturnShortfall float64         `json:"preferred_return_shortfall"` // annual pref the cash flow doesn't cover
+}
+
+// CalculatePartnershipSplit runs the deal in req and splits its cash-out
+// refinance proceeds and ongoing cash flow between the partners
+func (s *ArvService) CalculatePartnershipSplit(req PartnershipRequest) (*PartnershipSplit, error) {
+	totalEquity, totalCapital := 0.0, 0.0
+	for _, partner := range req.Partners {
+		totalEquity += partner.EquityPercent
+		totalCapital += partner.Capital
+	}
+	if math.Abs(totalEquity-100) > 0.01 {
+		return nil, ErrPartnershipEquity
+	}
+	if totalCapital == 0 {
+		return nil, ErrPartnershipNoCapital
+	}
+
+	result := s.CalculateARV(req.ArvRequest)
+	split := &PartnershipSplit{
+		Partners:          make([]PartnerReturn, len(req.Partners)),
+		RefinanceProceeds: result.CashRecovered,
+		AnnualCashFlow:    result.AnnualCashFlow,
+	}
+
+	// Refinance proceeds go back towards each partner's capital unless
+	// they're split by equity, and whatever's left over by equity
+	remaining := result.CashRecovered
+	for i, partner := range req.Partners {
+		returned := 0.0
+		if req.RefinanceProceeds != RefiProceedsEquity {
+			returned = math.Min(partner.Capital, result.CashRecovered*partner.Capital/totalCapital)
+		}
+		split.Partners[i] = PartnerReturn{
+			Name:               partner.Name,
+			EquityPercent:      partner.EquityPercent,
+			CashInvested:       partner.Capital,
+			CashReturnedAtRefi: returned,
+		}
+		remaining -= returned
+	}
+
+	prefDue := make([]float64, len(req.Partners))
+	totalPrefDue := 0.0
+	for i, partner := range req.Partners {
+		returned := &split.Partners[i]
+		returned.CashReturnedAtRefi += remaining * partner.EquityPercent / 100
+		returned.CapitalLeftIn = math.Max(0, partner.Capital-returned.CashReturnedAtRefi)
+		prefDue[i] = returned.CapitalLeftIn * req.PreferredReturn / 100
+		totalPrefDue += prefDue[i]
+	}
+
+	// The pref is paid out of positive cash flow, short pro rata when there
+	// isn't enough; the rest, or a loss, is split by equity
+	prefPaid := math.Min(math.Max(0, result.AnnualCashFlow), totalPrefDue)
+	split.PreferredReturnShortfall = roundCents(totalPrefDue - prefPaid)
+	for i, partner := range req.Partners {
+		returned := &split.Partners[i]
+		if totalPrefDue > 0 {
+			returned.PreferredReturn = prefPaid * prefDue[i] / totalPrefDue
+		}
+		returned.AnnualCashFlow = returned.PreferredReturn + (result.AnnualCashFlow-prefPaid)*partner.EquityPercent/100
+		returned.MonthlyCashFlow = returned.AnnualCashFlow / 12
+		returned.CashOnCashReturn = cashOnCashReturn(returned.AnnualCashFlow, roundCents(returned.CapitalLeftIn))
+
+		returned.CashReturnedAtRefi = roundCents(returned.CashReturnedAtRefi)
+		returned.CapitalLeftIn = roundCents(returned.CapitalLeftIn)
+		returned.PreferredReturn = roundCents(returned.PreferredReturn)
+		returned.AnnualCashFlow = roundCents(returned.AnnualCashFlow)
+		returned.MonthlyCashFlow = roundCents(returned.MonthlyCashFlow)
+		if returned.CashOnCashReturn != nil {
+			*returned.CashOnCashReturn = roundCents(*returned.CashOnCashReturn)
+		}
+	}
+	return split, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculatePartnershipSplit_FiftyFiftyWithPref(t *testing.T) {
+	service := NewArvService()
+
+	// The deal costs $164,000 and the refinance pulls out $150,000, leaving
+	// $14,000 in and $2,242.78 a year of cash flow
+	split, err := service.CalculatePartnershipSplit(PartnershipRequest{
+		ArvRequest:      projectionDeal(),
+		PreferredReturn: 8,
+		Partners: []Partner{
+			{Name: "Money", Capital: 164000, EquityPercent: 50},
+			{Name: "Operator", EquityPercent: 50},
+		},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 150000.0, split.RefinanceProceeds)
+	assert.Equal(t, 2242.78, split.AnnualCashFlow)
+	assert.Zero(t, split.PreferredReturnShortfall)
+
+	// The money partner gets their capital back first, then an 8% pref on
+	// the $14,000 left in, then half of what's left of the cash flow
+	money := split.Partners[0]
+	assert.Equal(t, 150000.0, money.CashReturnedAtRefi)
+	assert.Equal(t, 14000.0, money.CapitalLeftIn)
+	assert.Equal(t, 1120.0, money.PreferredReturn)
+	assert.Equal(t, 1681.39, money.AnnualCashFlow) // 1120 + (2242.78 - 1120) / 2
+	assert.Equal(t, 140.12, money.MonthlyCashFlow)
+	require.NotNil(t, money.CashOnCashReturn)
+	assert.Equal(t, 12.01, *money.CashOnCashReturn)
+
+	operator := split.Partners[1]
+	assert.Zero(t, operator.CashReturnedAtRefi)
+	assert.Zero(t, operator.PreferredReturn)
+	assert.Equal(t, 561.39, operator.AnnualCashFlow)
+	assert.Nil(t, operator.CashOnCashReturn, "no cash in is an infinite return")
+}
+
+func TestCalculatePartnershipSplit_EqualCapital(t *testing.T) {
+	service := NewArvService()
+
+	split, err := service.CalculatePartnershipSplit(PartnershipRequest{
+		ArvRequest:      projectionDeal(),
+		PreferredReturn: 8,
+		Partners: []Partner{
+			{Name: "A", Capital: 82000, EquityPercent: 50},
+			{Name: "B", Capital: 82000, EquityPercent: 50},
+		},
+	})
+
+	require.NoError(t, err)
+	for _, partner := range split.Partners {
+		assert.Equal(t, 75000.0, partner.CashReturnedAtRefi)
+		assert.Equal(t, 7000.0, partner.CapitalLeftIn)
+		assert.Equal(t, 560.0, partner.PreferredReturn)
+		assert.Equal(t, 1121.39, partner.AnnualCashFlow)
+		assert.Equal(t, 16.02, *partner.CashOnCashReturn)
+	}
+}
+
+func TestCalculatePartnershipSplit_ProceedsByEquity(t *testing.T) {
+	service := NewArvService()
+
+	split, err := service.CalculatePartnershipSplit(PartnershipRequest{
+		ArvRequest:        projectionDeal(),
+		PreferredReturn:   8,
+		RefinanceProceeds: RefiProceedsEquity,
+		Partners: []Partner{
+			{Name: "Money", Capital: 164000, EquityPercent: 50},
+			{Name: "Operator", EquityPercent: 50},
+		},
+	})
+
+	require.NoError(t, err)
+	money, operator := split.Partners[0], split.Partners[1]
+	assert.Equal(t, 75000.0, money.CashReturnedAtRefi)
+	assert.Equal(t, 89000.0, money.CapitalLeftIn)
+	assert.Equal(t, 75000.0, operator.CashReturnedAtRefi)
+
+	// The $7,120 pref is more than the whole cash flow
+	assert.Equal(t, 2242.78, money.PreferredReturn)
+	assert.Equal(t, 2242.78, money.AnnualCashFlow)
+	assert.Equal(t, 4877.22, split.PreferredReturnShortfall)
+	assert.Zero(t, operator.AnnualCashFlow)
+}
+
+func TestCalculatePartnershipSplit_Validation(t *testing.T) {
+	service := NewArvService()
+
+	_, err := service.CalculatePartnershipSplit(PartnershipRequest{
+		ArvRequest: projectionDeal(),
+		Partners:   []Partner{{Capital: 100000, EquityPercent: 60}, {EquityPercent: 30}},
+	})
+	assert.ErrorIs(t, err, ErrPartnershipEquity)
+
+	_, err = service.CalculatePartnershipSplit(PartnershipRequest{
+		ArvRequest: projectionDeal(),
+		Partners:   []Partner{{EquityPercent: 50}, {EquityPercent: 50}},
+	})
+	assert.ErrorIs(t, err, ErrPartnershipNoCapital)
+}
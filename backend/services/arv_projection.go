@@ -0,0 +1,192 @@
+package services
+
+import (
+	"math"
+
+	"arvfinder-backend/models"
+)
+
+// defaultProjectionYears is how far ahead a projection looks when the
+// request doesn't say
+const defaultProjectionYears = 5
+
+// defaultDiscountRate is the NPV discount rate used when the request
+// doesn't give one
+const defaultDiscountRate = 8.0
+
+// ProjectionRequest is a deal and the rates it's projected forward at. Rent
+// and expenses grow from their first-year values, and the property
+// appreciates from its ARV. The property is sold at the end of the last
+// year for its projected value, less SaleCostPercent of it or, when that's
+// left out, the deal's selling costs. Extra principal paid on the loan comes
+// out of the cash flow and goes into the equity.
+type ProjectionRequest struct {
+	ArvRequest
+	Years               int     `json:"years" binding:"min=0,max=30"`
+	RentGrowth          float64 `json:"rent_growth" binding:"min=-50,max=50"`       // annual percentage
+	ExpenseInflation    float64 `json:"expense_inflation" binding:"min=-50,max=50"` // annual percentage
+	Appreciation        float64 `json:"appreciation" binding:"min=-50,max=50"`      // annual percentage
+	SaleCostPercent     float64 `json:"sale_cost_percent" binding:"min=0,max=20"`
+	DiscountRate        float64 `json:"discount_rate" binding:"min=0,max=50"` // annual percentage for NPV, default 8%
+	ExtraMonthlyPayment float64 `json:"extra_monthly_payment" binding:"min=0"`
+	AnnualLumpSum       float64 `json:"annual_lump_sum" binding:"min=0"` // paid with every twelfth payment
+}
+
+// ProjectionYear is one year of a projection. Property value, loan balance
+// and equity are as of the end of the year.
+type ProjectionYear struct {
+	Year           int     `json:"year"`
+	GrossRent      float64 `json:"gross_rent"`
+	Expenses       float64 `json:"expenses"`
+	NOI            float64 `json:"noi"`
+	DebtService    float64 `json:"debt_service"` // including extra principal
+	ExtraPrincipal float64 `json:"extra_principal"`
+	CashFlow       float64 `json:"cash_flow"`
+	CumulativeCash float64 `json:"cumulative_cash_flow"`
+	PropertyValue  float64 `json:"property_value"`
+	LoanBalance    float64 `json:"loan_balance"`
+	Equity         float64 `json:"equity"`
+}
+
+// Projection is a deal's year-by-year performance after the refinance.
+// TotalReturn is the cash flow plus the equity at the end, less the cash
+// left in the deal.
+//
+// IRR and NPV treat the cash left in as spent now, then take each year's
+// cash flow, with the sale proceeds (the projected value less selling costs
+// and the loan balance) added to the last. IRR is nil unless IRRStatus is
+// converged.
+type Projection struct {
+	Years              []ProjectionYear `json:"years"`
+	CumulativeCashFlow float64          `json:"cumulative_cash_flow"`
+	EndingEquity       float64          `json:"ending_equity"`
+	CashLeftIn         float64          `json:"cash_left_in"`
+	TotalReturn        float64          `json:"total_return"`
+	TotalReturnPercent float64          `json:"total_return_percent"` // of cash left in, 0 when none is
+	SaleProceeds       float64          `json:"sale_proceeds"`
+	DiscountRate       float64          `json:"discount_rate"`
+	NPV                float64          `json:"npv"`
+	IRR                *float64         `json:"irr"` // percentage
+	IRRStatus          string           `json:"irr_status"`
+	Warnings           []string         `json:"warnings"`
+}
+
+// ProjectCashFlows projects a deal's rent, expenses, cash flow and equity
+// forward year by year, starting from the first year CalculateARV works out
+func (s *ArvService) ProjectCashFlows(req ProjectionRequest) Projection {
+	years := req.Years
+	if years == 0 {
+		years = defaultProjectionYears
+	}
+
+	first := s.CalculateARV(req.ArvRequest)
+	loanReq := req.ArvRequest
+	s.setDefaultsAndValidate(&loanReq, &ArvResult{})
+	// Mortgage insurance is paid on top of the loan's schedule
+	principal, payment, mortgageInsurance := first.RefinanceAmount, first.MonthlyDebtService, 0.0
+	if first.Mortgage != nil {
+		principal, payment = first.Mortgage.FinancedLoanAmount, first.Mortgage.PrincipalAndInterest
+		mortgageInsurance = first.Mortgage.MortgageInsurance
+	}
+	extra := prepayments{monthly: req.ExtraMonthlyPayment, annual: req.AnnualLumpSum}
+	schedule, _ := loanReq.refinanceTerms().schedule(principal, payment, extra)
+
+	// Vacancy is a share of the rent, so it grows with it
+	occupancy := 1.0
+	if first.AnnualGrossIncome > 0 {
+		occupancy = first.EffectiveIncome / first.AnnualGrossIncome
+	}
+
+	projection := Projection{
+		Years:      []ProjectionYear{},
+		CashLeftIn: first.CashLeftIn,
+		Warnings:   NoticeMessages(first.Warnings),
+	}
+	for n := 1; n <= years; n++ {
+		year := ProjectionYear{
+			Year:          n,
+			GrossRent:     first.AnnualGrossIncome * math.Pow(1+req.RentGrowth/100, float64(n-1)),
+			Expenses:      first.AnnualExpenses * math.Pow(1+req.ExpenseInflation/100, float64(n-1)),
+			PropertyValue: first.ARV * math.Pow(1+req.Appreciation/100, float64(n)),
+			LoanBalance:   principal,
+		}
+		year.NOI = year.GrossRent*occupancy - year.Expenses
+
+		var loanPayments, extraPrincipal models.Money
+		for month := (n - 1) * 12; month < n*12 && month < len(schedule); month++ {
+			loanPayments += schedule[month].Payment
+			extraPrincipal += schedule[month].ExtraPrincipal
+			year.DebtService += mortgageInsurance
+		}
+		year.DebtService += loanPayments.Float64()
+		year.ExtraPrincipal = extraPrincipal.Float64()
+		if end := n*12 - 1; end < len(schedule) {
+			year.LoanBalance = schedule[end].Balance.Float64()
+		} else if len(schedule) > 0 {
+			year.LoanBalance = 0
+		}
+
+		year.CashFlow = year.NOI - year.DebtService
+		projection.CumulativeCashFlow += year.CashFlow
+		year.CumulativeCash = projection.CumulativeCashFlow
+		year.Equity = year.PropertyValue - year.LoanBalance
+
+		year.GrossRent = roundCents(year.GrossRent)
+		year.Expenses = roundCents(year.Expenses)
+		year.NOI = roundCents(year.NOI)
+		year.DebtService = roundCents(year.DebtService)
+		year.ExtraPrincipal = roundCents(year.ExtraPrincipal)
+		year.CashFlow = roundCents(year.CashFlow)
+		year.CumulativeCash = roundCents(year.CumulativeCash)
+		year.PropertyValue = roundCents(year.PropertyValue)
+		year.Equity = roundCents(year.Equity)
+		projection.Years = append(projection.Years, year)
+	}
+
+	projection.EndingEquity = projection.Years[len(projection.Years)-1].Equity
+	projection.CumulativeCashFlow = roundCents(projection.CumulativeCashFlow)
+	projection.TotalReturn = roundCents(projection.CumulativeCashFlow + projection.EndingEquity - projection.CashLeftIn)
+	if projection.CashLeftIn > 0 {
+		projection.TotalReturnPercent = roundCents(projection.TotalReturn / projection.CashLeftIn * 100)
+	}
+
+	s.discountProjection(req, &projection)
+	return projection
+}
+
+// discountProjection works out the NPV and IRR of selling at the end of a
+// projection
+func (s *ArvService) discountProjection(req ProjectionRequest, projection *Projection) {
+	last := projection.Years[len(projection.Years)-1]
+	sellingCosts := req.SellingCosts
+	if req.SaleCostPercent > 0 {
+		sellingCosts = last.PropertyValue * req.SaleCostPercent / 100
+	}
+	projection.SaleProceeds = roundCents(last.PropertyValue - sellingCosts - last.LoanBalance)
+
+	flows := []float64{-projection.CashLeftIn}
+	for _, year := range projection.Years {
+		flows = append(flows, year.CashFlow)
+	}
+	flows[len(flows)-1] += projection.SaleProceeds
+
+	projection.DiscountRate = req.DiscountRate
+	if projection.DiscountRate == 0 {
+		projection.DiscountRate = defaultDiscountRate
+	}
+	projection.NPV = roundCents(netPresentValue(projection.DiscountRate/100, flows))
+
+	if projection.CashLeftIn == 0 {
+		projection.IRRStatus = IRRNoInvestment
+		return
+	}
+	rate, ok := internalRateOfReturn(flows)
+	if !ok {
+		projection.IRRStatus = IRRNotConverged
+		projection.Warnings = append(projection.Warnings, "IRR could not be calculated for these cash flows")
+		return
+	}
+	irr := roundCents(rate * 100)
+	projection.IRR = &irr
+	projection.IRRStatus = IRRConverged
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func projectionDeal() ArvRequest {
+	return ArvRequest{
+		PurchasePrice: 120000,
+		RehabCost:     40000,
+		ClosingCosts:  4000,
+		ARV:           200000,
+		MonthlyRent:   1800,
+		VacancyRate:   5,
+		PropertyTaxes: 3000,
+		Insurance:     1200,
+		Maintenance:   1800,
+		CapEx:         900,
+		InterestRate:  6.5,
+		LoanTerm:      30,
+	}
+}
+
+func TestProjectCashFlows_FirstYearMatchesCalculation(t *testing.T) {
+	service := NewArvService()
+	deal := projectionDeal()
+
+	projection := service.ProjectCashFlows(ProjectionRequest{
+		ArvRequest:       deal,
+		RentGrowth:       3,
+		ExpenseInflation: 2,
+		Appreciation:     4,
+	})
+	single := service.CalculateARV(deal)
+
+	require.Len(t, projection.Years, 5, "defaults to five years")
+	first := projection.Years[0]
+	assert.Equal(t, single.AnnualGrossIncome, first.GrossRent)
+	assert.Equal(t, single.AnnualExpenses, first.Expenses)
+	assert.Equal(t, single.NOI, first.NOI)
+	assert.InDelta(t, single.MonthlyDebtService*12, first.DebtService, 0.01)
+	assert.InDelta(t, single.AnnualCashFlow, first.CashFlow, 0.12, "annual cash flow is twelve rounded months")
+	assert.Equal(t, 208000.0, first.PropertyValue)
+
+	// Year two's rent and expenses grow at their own rates
+	second := projection.Years[1]
+	assert.Equal(t, roundCents(single.AnnualGrossIncome*1.03), second.GrossRent)
+	assert.Equal(t, roundCents(single.AnnualExpenses*1.02), second.Expenses)
+	assert.Equal(t, 216320.0, second.PropertyValue)
+	assert.Equal(t, roundCents(first.CashFlow+second.CashFlow), second.CumulativeCash)
+}
+
+func TestProjectCashFlows_LoanBalanceFollowsAmortization(t *testing.T) {
+	service := NewArvService()
+	deal := projectionDeal()
+
+	projection := service.ProjectCashFlows(ProjectionRequest{ArvRequest: deal, Years: 10})
+	schedule := service.CalculateAmortization(AmortizationRequest{
+		Principal:  150000, // 75% of the ARV
+		AnnualRate: 6.5,
+		TermYears:  30,
+	})
+
+	require.Len(t, projection.Years, 10)
+	for _, year := range projection.Years {
+		balance := schedule.Schedule[year.Year*12-1].Balance.Float64()
+		assert.Equal(t, balance, year.LoanBalance, "year %d", year.Year)
+		assert.Equal(t, roundCents(year.PropertyValue-balance), year.Equity, "year %d", year.Year)
+		assert.Less(t, year.LoanBalance, 150000.0)
+	}
+	assert.Greater(t, projection.Years[0].LoanBalance, projection.Years[9].LoanBalance)
+}
+
+func TestProjectCashFlows_Totals(t *testing.T) {
+	service := NewArvService()
+
+	projection := service.ProjectCashFlows(ProjectionRequest{
+		ArvRequest:   projectionDeal(),
+		Years:        3,
+		RentGrowth:   3,
+		Appreciation: 3,
+	})
+
+	require.Len(t, projection.Years, 3)
+	last := projection.Years[2]
+	assert.Equal(t, last.CumulativeCash, projection.CumulativeCashFlow)
+	assert.Equal(t, last.Equity, projection.EndingEquity)
+	assert.Equal(t, 14000.0, projection.CashLeftIn) // 164,000 invested, 150,000 refinanced
+	assert.Equal(t, roundCents(projection.CumulativeCashFlow+projection.EndingEquity-14000), projection.TotalReturn)
+	assert.Equal(t, roundCents(projection.TotalReturn/14000*100), projection.TotalReturnPercent)
+}
+
+func TestProjectCashFlows_LoanPaidOff(t *testing.T) {
+	service := NewArvService()
+	deal := projectionDeal()
+	deal.LoanTerm = 10
+
+	projection := service.ProjectCashFlows(ProjectionRequest{ArvRequest: deal, Years: 12})
+
+	require.Len(t, projection.Years, 12)
+	assert.Equal(t, 0.0, projection.Years[9].LoanBalance)
+	assert.Equal(t, 0.0, projection.Years[10].DebtService)
+	assert.Equal(t, projection.Years[10].NOI, projection.Years[10].CashFlow)
+	assert.Equal(t, projection.Years[11].PropertyValue, projection.Years[11].Equity)
+}
+
+func TestProjectCashFlows_ExtraPrincipal(t *testing.T) {
+	service := NewArvService()
+	deal := projectionDeal()
+
+	base := service.ProjectCashFlows(ProjectionRequest{ArvRequest: deal})
+	prepaid := service.ProjectCashFlows(ProjectionRequest{
+		ArvRequest:          deal,
+		ExtraMonthlyPayment: 100,
+		AnnualLumpSum:       2000,
+	})
+
+	// The $3,200 a year prepaid comes out of the cash flow and, with the
+	// interest it saves, goes into the equity
+	first, baseFirst := prepaid.Years[0], base.Years[0]
+	assert.Equal(t, 3200.0, first.ExtraPrincipal)
+	assert.Equal(t, roundCents(baseFirst.CashFlow-3200), first.CashFlow)
+	assert.Less(t, first.LoanBalance, roundCents(baseFirst.LoanBalance-3200), "the interest saved pays down more")
+
+	last, baseLast := prepaid.Years[4], base.Years[4]
+	assert.Greater(t, roundCents(last.Equity-baseLast.Equity), 5*3200.0)
+	assert.Less(t, last.CumulativeCash, baseLast.CumulativeCash)
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"math"
+)
+
+// QuickScreenRequest is the input for screening a deal before a full
+// analysis
+type QuickScreenRequest struct {
+	PurchasePrice float64 `json:"purchase_price" binding:"required,min=1"`
+	RehabCost     float64 `json:"rehab_cost" binding:"min=0"`
+	MonthlyRent   float64 `json:"monthly_rent" binding:"min=0"`
+	SquareFeet    int     `json:"square_feet" binding:"min=0"`
+}
+
+// QuickScreen holds rule-of-thumb metrics on the all-in price, the purchase
+// price plus rehab. GRM and the rent-to-price ratio are nil without rent,
+// and price per square foot is nil without square footage.
+type QuickScreen struct {
+	AllInPrice          float64  `json:"all_in_price"`
+	GrossRentMultiplier *float64 `json:"gross_rent_multiplier"` // all-in price / annual rent
+	RentToPriceRatio    *float64 `json:"rent_to_price_ratio"`   // monthly rent as a percentage of the all-in price
+	Meets1PercentRule   bool     `json:"meets_1_percent_rule"`
+	Meets2PercentRule   bool     `json:"meets_2_percent_rule"`
+	PricePerSquareFoot  *float64 `json:"price_per_square_foot"`
+}
+
+// QuickScreen works out the gross rent multiplier, the 1% and 2% rules and
+// price per square foot
+func (s *ArvService) QuickScreen(req QuickScreenRequest) QuickScreen {
+	screen := QuickScreen{AllInPrice: roundCents(req.PurchasePrice + req.RehabCost)}
+
+	if req.MonthlyRent > 0 && screen.AllInPrice > 0 {
+		grm := roundCents(screen.AllInPrice / (req.MonthlyRent * 12))
+		ratio := req.MonthlyRent / screen.AllInPrice * 100
+		screen.GrossRentMultiplier = &grm
+		screen.Meets1PercentRule = ratio >= 1
+		screen.Meets2PercentRule = ratio >= 2
+		ratio = math.Round(ratio*1000) / 1000
+		screen.RentToPriceRatio = &ratio
+	}
+	if req.SquareFeet > 0 {
+		perSquareFoot := roundCents(screen.AllInPrice / float64(req.SquareFeet))
+		screen.PricePerSquareFoot = &perSquareFoot
+	}
+	return screen
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuickScreen_Ratios(t *testing.T) {
+	service := NewArvService()
+
+	screen := service.QuickScreen(QuickScreenRequest{
+		PurchasePrice: 85000,
+		RehabCost:     15000,
+		MonthlyRent:   1200,
+		SquareFeet:    1250,
+	})
+
+	assert.Equal(t, 100000.0, screen.AllInPrice)
+	require.NotNil(t, screen.GrossRentMultiplier)
+	assert.Equal(t, 6.94, *screen.GrossRentMultiplier) // 100,000 / 14,400
+	require.NotNil(t, screen.RentToPriceRatio)
+	assert.Equal(t, 1.2, *screen.RentToPriceRatio)
+	assert.True(t, screen.Meets1PercentRule)
+	assert.False(t, screen.Meets2PercentRule)
+	require.NotNil(t, screen.PricePerSquareFoot)
+	assert.Equal(t, 80.0, *screen.PricePerSquareFoot)
+}
+
+func TestQuickScreen_TwoPercentRule(t *testing.T) {
+	service := NewArvService()
+
+	screen := service.QuickScreen(QuickScreenRequest{PurchasePrice: 40000, MonthlyRent: 800})
+
+	assert.Equal(t, 2.0, *screen.RentToPriceRatio)
+	assert.True(t, screen.Meets1PercentRule)
+	assert.True(t, screen.Meets2PercentRule)
+	assert.Equal(t, 4.17, *screen.GrossRentMultiplier)
+}
+
+func TestQuickScreen_MissingRentAndSquareFeet(t *testing.T) {
+	service := NewArvService()
+
+	screen := service.QuickScreen(QuickScreenRequest{PurchasePrice: 150000})
+
+	assert.Equal(t, 150000.0, screen.AllInPrice)
+	assert.Nil(t, screen.GrossRentMultiplier)
+	assert.Nil(t, screen.RentToPriceRatio)
+	assert.Nil(t, screen.PricePerSquareFoot)
+	assert.False(t, screen.Meets1PercentRule)
+	assert.False(t, screen.Meets2PercentRule)
+}
+
+func TestCalculateARV_QuickScreenOnlyWithEnteredRent(t *testing.T) {
+	service := NewArvService()
+	req := ArvRequest{PurchasePrice: 90000, RehabCost: 10000, ARV: 150000}
+
+	// Rent estimated from the ARV would just restate the 1% rule
+	assert.Nil(t, service.CalculateARV(req).QuickScreen)
+
+	req.MonthlyRent = 1100
+	result := service.CalculateARV(req)
+	require.NotNil(t, result.QuickScreen)
+	assert.Equal(t, 7.58, *result.QuickScreen.GrossRentMultiplier)
+	assert.Equal(t, 1.1, *result.QuickScreen.RentToPriceRatio)
+	assert.True(t, result.QuickScreen.Meets1PercentRule)
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"fmt"
+	"math"
+)
+
+// RefinanceRequest compares keeping a current loan with a rate-and-term or
+// cash-out refinance. CurrentPayment is worked out from the balance, rate
+// and remaining term when it's left out. Closing costs are paid in cash
+// unless RollInCosts adds them to the new loan.
+type RefinanceRequest struct {
+	CurrentBalance         float64 `json:"current_balance" binding:"required,min=1"`
+	CurrentRate            float64 `json:"current_rate" binding:"min=0,max=30"` // annual percentage
+	CurrentRemainingMonths int     `json:"current_remaining_months" binding:"required,min=1,max=600"`
+	CurrentPayment         float64 `json:"current_payment" binding:"min=0"` // monthly principal and interest
+
+	NewRate      float64 `json:"new_rate" binding:"min=0,max=30"` // annual percentage
+	NewTermYears int     `json:"new_term_years" binding:"required,min=1,max=50"`
+	ClosingCosts float64 `json:"closing_costs" binding:"min=0"`
+	RollInCosts  bool    `json:"roll_in_costs"`
+	CashOut      float64 `json:"cash_out" binding:"min=0"`
+}
+
+// RefinanceAnalysis is what a refinance saves each month and over the life
+// of the loans. CashOutPayment is the part of the new payment that goes to
+// the cash taken out, which is why a cash-out refinance saves less.
+// BreakEvenMonth is when the savings have paid back the closing costs, and
+// is nil when the new payment isn't lower.
+type RefinanceAnalysis struct {
+	NewLoanAmount              float64  `json:"new_loan_amount"`
+	CurrentPayment             float64  `json:"current_payment"`
+	NewPayment                 float64  `json:"new_payment"`
+	CashOutPayment             float64  `json:"cash_out_payment"`
+	MonthlySavings             float64  `json:"monthly_savings"`
+	CashToClose                float64  `json:"cash_to_close"`
+	BreakEvenMonth             *int     `json:"break_even_month"`
+	CurrentTotalInterest       float64  `json:"current_total_interest"` // over the remaining term
+	NewTotalInterest           float64  `json:"new_total_interest"`
+	LifetimeInterestDifference float64  `json:"lifetime_interest_difference"` // new less current
+	Warnings                   []string `json:"warnings"`
+}
+
+// AnalyzeRefinance works out the new payment, savings and break-even month
+// of refinancing a loan
+func (s *ArvService) AnalyzeRefinance(req RefinanceRequest) RefinanceAnalysis {
+	result := RefinanceAnalysis{
+		CurrentPayment: req.CurrentPayment,
+		NewLoanAmount:  req.CurrentBalance + req.CashOut,
+		Warnings:       []string{},
+	}
+	if result.CurrentPayment == 0 {
+		result.CurrentPayment = roundCents(monthlyPayment(req.CurrentBalance, req.CurrentRate, req.CurrentRemainingMonths))
+	}
+	if result.CurrentPayment <= req.CurrentBalance*req.CurrentRate/100/12 {
+		result.Warnings = append(result.Warnings, "WARNING: Current payment doesn't cover the loan's interest")
+	}
+
+	if req.RollInCosts {
+		result.NewLoanAmount += req.ClosingCosts
+	} else {
+		result.CashToClose = req.ClosingCosts
+	}
+
+	newMonths := req.NewTermYears * 12
+	result.NewPayment = roundCents(s.calculateMonthlyPayment(result.NewLoanAmount, req.NewRate, req.NewTermYears))
+	if result.NewLoanAmount > 0 {
+		result.CashOutPayment = roundCents(result.NewPayment * req.CashOut / result.NewLoanAmount)
+	}
+	result.MonthlySavings = result.CurrentPayment - result.NewPayment
+
+	// Closing costs are paid back by the savings whether they were paid in
+	// cash or added to the loan
+	if result.MonthlySavings > 0 {
+		month := int(math.Ceil(req.ClosingCosts / result.MonthlySavings))
+		if month < 1 {
+			month = 1
+		}
+		result.BreakEvenMonth = &month
+		if month > newMonths {
+			result.Warnings = append(result.Warnings, "WARNING: Savings don't pay back the closing costs within the new loan's term")
+		}
+	} else {
+		result.Warnings = append(result.Warnings, "WARNING: New payment isn't lower - the refinance never breaks even on savings")
+	}
+
+	_, currentInterest := amortize(req.CurrentBalance, req.CurrentRate, req.CurrentRemainingMonths, result.CurrentPayment)
+	_, newInterest := amortize(result.NewLoanAmount, req.NewRate, newMonths, result.NewPayment)
+	result.CurrentTotalInterest, result.NewTotalInterest = currentInterest.Float64(), newInterest.Float64()
+	result.LifetimeInterestDifference = result.NewTotalInterest - result.CurrentTotalInterest
+
+	if newMonths > req.CurrentRemainingMonths && result.MonthlySavings > 0 && result.LifetimeInterestDifference > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"WARNING: Extending the term to %d months costs $%.2f more interest despite the lower payment",
+			newMonths, result.LifetimeInterestDifference))
+	}
+	if req.CashOut > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"Savings are after $%.2f a month of payment on the $%.2f cash out", result.CashOutPayment, req.CashOut))
+	}
+
+	result.NewLoanAmount = roundCents(result.NewLoanAmount)
+	result.MonthlySavings = roundCents(result.MonthlySavings)
+	result.LifetimeInterestDifference = roundCents(result.LifetimeInterestDifference)
+	return result
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func refinanceRequest() RefinanceRequest {
+	return RefinanceRequest{
+		CurrentBalance:         200000,
+		CurrentRate:            6.5,
+		CurrentRemainingMonths: 300,
+		NewRate:                5.5,
+		NewTermYears:           30,
+		ClosingCosts:           4000,
+	}
+}
+
+func TestAnalyzeRefinance_WorkedExample(t *testing.T) {
+	service := NewArvService()
+
+	result := service.AnalyzeRefinance(refinanceRequest())
+
+	// 200,000 with 25 years left at 6.5% is 1,350.41 a month; over a new
+	// 30 years at 5.5% it's 1,135.58, so 4,000 of closing costs take 19
+	// months of 214.83 savings to pay back
+	assert.Equal(t, 200000.0, result.NewLoanAmount)
+	assert.Equal(t, 1350.41, result.CurrentPayment)
+	assert.Equal(t, 1135.58, result.NewPayment)
+	assert.Equal(t, 214.83, result.MonthlySavings)
+	assert.Equal(t, 4000.0, result.CashToClose)
+	require.NotNil(t, result.BreakEvenMonth)
+	assert.Equal(t, 19, *result.BreakEvenMonth)
+
+	// The extra 5 years cost more interest despite the lower payment
+	assert.Equal(t, 205126.29, result.CurrentTotalInterest)
+	assert.Equal(t, 208806.9, result.NewTotalInterest)
+	assert.Equal(t, 3680.61, result.LifetimeInterestDifference)
+	assert.Contains(t, result.Warnings,
+		"WARNING: Extending the term to 360 months costs $3680.61 more interest despite the lower payment")
+}
+
+func TestAnalyzeRefinance_ShorterTermWithCostsRolledIn(t *testing.T) {
+	service := NewArvService()
+	req := refinanceRequest()
+	req.NewTermYears = 20
+	req.RollInCosts = true
+
+	result := service.AnalyzeRefinance(req)
+
+	// The payment goes up, so there's no break-even, but a lot less
+	// interest is paid
+	assert.Equal(t, 204000.0, result.NewLoanAmount)
+	assert.Equal(t, 0.0, result.CashToClose)
+	assert.Equal(t, 1403.29, result.NewPayment)
+	assert.Equal(t, -52.88, result.MonthlySavings)
+	assert.Nil(t, result.BreakEvenMonth)
+	assert.Equal(t, -72336.68, result.LifetimeInterestDifference)
+	assert.Contains(t, result.Warnings, "WARNING: New payment isn't lower - the refinance never breaks even on savings")
+}
+
+func TestAnalyzeRefinance_CashOutReducesSavings(t *testing.T) {
+	service := NewArvService()
+	req := refinanceRequest()
+	req.CashOut = 30000
+
+	result := service.AnalyzeRefinance(req)
+
+	// 170.34 of the 1,305.91 payment is for the 30,000 taken out, which
+	// leaves 44.50 of savings to pay back the closing costs
+	assert.Equal(t, 230000.0, result.NewLoanAmount)
+	assert.Equal(t, 1305.91, result.NewPayment)
+	assert.Equal(t, 170.34, result.CashOutPayment)
+	assert.Equal(t, 44.5, result.MonthlySavings)
+	require.NotNil(t, result.BreakEvenMonth)
+	assert.Equal(t, 90, *result.BreakEvenMonth)
+}
+
+func TestAnalyzeRefinance_GivenCurrentPayment(t *testing.T) {
+	service := NewArvService()
+	req := refinanceRequest()
+	req.CurrentPayment = 1400
+
+	result := service.AnalyzeRefinance(req)
+
+	assert.Equal(t, 1400.0, result.CurrentPayment)
+	assert.Equal(t, 264.42, result.MonthlySavings)
+	require.NotNil(t, result.BreakEvenMonth)
+	assert.Equal(t, 16, *result.BreakEvenMonth)
+}
@@ -0,0 +1,124 @@
+package services
+
+import "math"
+
+// Rental comp adjustments to monthly rent for each difference between a
+// comp and the subject property
+const (
+	rentAdjustmentPerBedroom  = 150.0
+	rentAdjustmentPerBathroom = 75.0
+	rentAdjustmentPerSqFt     = 0.50
+)
+
+// Where the rent an ARV calculation used came from
+const (
+	RentSourceProvided       = "provided"
+	RentSourceRentComps      = "rent_comps"
+	RentSourceOnePercentRule = "one_percent_rule"
+)
+
+// RentComp is a nearby property's current rent, for estimating the
+// subject property's
+type RentComp struct {
+	Address     string  `json:"address"`
+	MonthlyRent float64 `json:"monthly_rent" binding:"required,gt=0"`
+	Bedrooms    int     `json:"bedrooms" binding:"min=0"`
+	Bathrooms   float64 `json:"bathrooms" binding:"min=0"`
+	SquareFeet  int     `json:"square_feet" binding:"min=0"`
+	Distance    float64 `json:"distance" binding:"min=0"` // miles from subject
+}
+
+// RentAdjustments itemizes how a rental comp's monthly rent is adjusted
+// towards the subject property
+type RentAdjustments struct {
+	Bedrooms   float64 `json:"bedrooms"`
+	Bathrooms  float64 `json:"bathrooms"`
+	SquareFeet float64 `json:"square_feet"`
+	Total      float64 `json:"total"`
+}
+
+// RentCompBreakdown is one rental comp's part in a rent estimate. Weight
+// is its share of the estimate as a percentage; closer comps count for
+// more.
+type RentCompBreakdown struct {
+	Address      string          `json:"address"`
+	MonthlyRent  float64         `json:"monthly_rent"`
+	Distance     float64         `json:"distance"`
+	RentPerSqFt  float64         `json:"rent_per_sqft"`
+	Adjustments  RentAdjustments `json:"adjustments"`
+	AdjustedRent float64         `json:"adjusted_rent"`
+	Weight       float64         `json:"weight"`
+}
+
+// RentEstimate is a monthly rent estimated from rental comps, with how each
+// comp contributed and how far the comps agree
+type RentEstimate struct {
+	EstimatedRent      float64             `json:"estimated_rent"` // distance-weighted
+	MedianAdjustedRent float64             `json:"median_adjusted_rent"`
+	StandardDeviation  float64             `json:"standard_deviation"` // of the adjusted rents
+	Confidence         string              `json:"confidence"`
+	Comparables        []RentCompBreakdown `json:"comparables"`
+}
+
+// EstimateRentFromComps estimates a property's monthly rent from rental
+// comps the way sold comps estimate its ARV: each comp's rent is adjusted
+// for bedrooms, bathrooms and square footage, then weighted by distance
+func (s *ArvService) EstimateRentFromComps(comps []RentComp, subjectBedrooms int, subjectBathrooms float64, subjectSquareFeet int) RentEstimate {
+	estimate := RentEstimate{
+		Confidence:  ConfidenceLow,
+		Comparables: make([]RentCompBreakdown, 0, len(comps)),
+	}
+	if len(comps) == 0 {
+		return estimate
+	}
+
+	weights := make([]float64, len(comps))
+	adjustedRents := make([]float64, len(comps))
+	totalWeight, weightedTotal, maxDistance := 0.0, 0.0, 0.0
+	for i, comp := range comps {
+		breakdown := RentCompBreakdown{
+			Address:     comp.Address,
+			MonthlyRent: comp.MonthlyRent,
+			Distance:    comp.Distance,
+			Adjustments: itemizeRentAdjustments(comp, subjectBedrooms, subjectBathrooms, float64(subjectSquareFeet)),
+		}
+		if comp.SquareFeet > 0 {
+			breakdown.RentPerSqFt = roundCents(comp.MonthlyRent / float64(comp.SquareFeet))
+		}
+		breakdown.AdjustedRent = roundCents(comp.MonthlyRent + breakdown.Adjustments.Total)
+		estimate.Comparables = append(estimate.Comparables, breakdown)
+
+		// Weight by distance (closer properties have more weight)
+		weights[i] = 1.0 / (1.0 + comp.Distance)
+		adjustedRents[i] = breakdown.AdjustedRent
+		totalWeight += weights[i]
+		weightedTotal += breakdown.AdjustedRent * weights[i]
+		maxDistance = math.Max(maxDistance, comp.Distance)
+	}
+
+	for i := range estimate.Comparables {
+		estimate.Comparables[i].Weight = roundCents(weights[i] / totalWeight * 100)
+	}
+	estimate.EstimatedRent = roundCents(weightedTotal / totalWeight)
+	estimate.MedianAdjustedRent = roundCents(median(adjustedRents))
+	estimate.StandardDeviation = roundCents(standardDeviation(adjustedRents))
+
+	spread := 0.0
+	if estimate.EstimatedRent > 0 {
+		spread = estimate.StandardDeviation / estimate.EstimatedRent * 100
+	}
+	estimate.Confidence = compConfidence(len(comps), spread, maxDistance)
+	return estimate
+}
+
+// itemizeRentAdjustments works out each adjustment to a rental comp's
+// monthly rent
+func itemizeRentAdjustments(comp RentComp, subjectBeds int, subjectBaths, subjectSqFt float64) RentAdjustments {
+	adjustments := RentAdjustments{
+		Bedrooms:   float64(subjectBeds-comp.Bedrooms) * rentAdjustmentPerBedroom,
+		Bathrooms:  roundCents((subjectBaths - comp.Bathrooms) * rentAdjustmentPerBathroom),
+		SquareFeet: roundCents((subjectSqFt - float64(comp.SquareFeet)) * rentAdjustmentPerSqFt),
+	}
+	adjustments.Total = roundCents(adjustments.Bedrooms + adjustments.Bathrooms + adjustments.SquareFeet)
+	return adjustments
+}
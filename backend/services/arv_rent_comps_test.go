@@ -0,0 +1,86 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateRentFromComps(t *testing.T) {
+	service := NewArvService()
+	comps := []RentComp{
+		{Address: "1 Oak St", MonthlyRent: 1500, Distance: 0.5, Bedrooms: 3, Bathrooms: 2.0, SquareFeet: 1200},
+		{Address: "2 Oak St", MonthlyRent: 1400, Distance: 1.0, Bedrooms: 3, Bathrooms: 1.5, SquareFeet: 1100},
+		{Address: "3 Oak St", MonthlyRent: 1650, Distance: 0.8, Bedrooms: 4, Bathrooms: 2.0, SquareFeet: 1300},
+	}
+
+	estimate := service.EstimateRentFromComps(comps, 3, 2.0, 1200)
+
+	assert.Equal(t, 1480.24, estimate.EstimatedRent)
+	assert.Equal(t, 1487.5, estimate.MedianAdjustedRent)
+	assert.Equal(t, 21.25, estimate.StandardDeviation)
+	assert.Equal(t, ConfidenceHigh, estimate.Confidence) // 3 comps within a mile, 1.4% spread
+
+	require.Len(t, estimate.Comparables, 3)
+	second := estimate.Comparables[1]
+	assert.Equal(t, RentAdjustments{Bathrooms: 37.5, SquareFeet: 50, Total: 87.5}, second.Adjustments)
+	assert.Equal(t, 1487.5, second.AdjustedRent)
+	assert.Equal(t, 1.27, second.RentPerSqFt)
+
+	third := estimate.Comparables[2]
+	assert.Equal(t, RentAdjustments{Bedrooms: -150, SquareFeet: -50, Total: -200}, third.Adjustments)
+	assert.Equal(t, 1450.0, third.AdjustedRent)
+
+	assert.Equal(t, []float64{38.71, 29.03, 32.26}, []float64{
+		estimate.Comparables[0].Weight, estimate.Comparables[1].Weight, estimate.Comparables[2].Weight,
+	})
+}
+
+func TestEstimateRentFromComps_NoComps(t *testing.T) {
+	service := NewArvService()
+
+	estimate := service.EstimateRentFromComps(nil, 3, 2.0, 1200)
+
+	assert.Zero(t, estimate.EstimatedRent)
+	assert.Equal(t, ConfidenceLow, estimate.Confidence)
+	assert.NotNil(t, estimate.Comparables)
+}
+
+func TestEstimateRentFromComps_FarAndScatteredIsLowConfidence(t *testing.T) {
+	service := NewArvService()
+	comps := []RentComp{
+		{MonthlyRent: 1200, Distance: 4, Bedrooms: 3, Bathrooms: 2, SquareFeet: 1200},
+		{MonthlyRent: 1800, Distance: 5, Bedrooms: 3, Bathrooms: 2, SquareFeet: 1200},
+	}
+
+	estimate := service.EstimateRentFromComps(comps, 3, 2.0, 1200)
+
+	assert.Equal(t, ConfidenceLow, estimate.Confidence)
+}
+
+func TestCalculateARV_RentSource(t *testing.T) {
+	service := NewArvService()
+	req := ArvRequest{PurchasePrice: 100000, RehabCost: 20000, ARV: 160000}
+
+	result := service.CalculateARV(req)
+	assert.Equal(t, RentSourceOnePercentRule, result.RentSource)
+	findNotice(t, result.Warnings, WarnRentEstimated)
+
+	req.MonthlyRent = 1480.24
+	result = service.CalculateARV(req)
+	assert.Equal(t, RentSourceProvided, result.RentSource)
+
+	// Rent estimated from rental comps keeps its provenance instead of
+	// being flagged as a 1% rule guess
+	req.RentSource = RentSourceRentComps
+	result = service.CalculateARV(req)
+	assert.Equal(t, RentSourceRentComps, result.RentSource)
+	assert.Equal(t, 1480.24, result.MonthlyRent)
+	for _, warning := range result.Warnings {
+		assert.NotEqual(t, WarnRentEstimated, warning.Code)
+	}
+	for _, assumption := range result.Assumptions {
+		assert.NotEqual(t, "monthly_rent", assumption.Field)
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import "math"
+
+// longTermHoldMonths is the hold after which a gain is taxed as long-term
+// rather than as ordinary income
+const longTermHoldMonths = 12
+
+// maxRecaptureRate caps the rate depreciation recapture is taxed at
+const maxRecaptureRate = 25.0
+
+// saleTaxDisclaimer is attached to every sale proceeds estimate
+const saleTaxDisclaimer = "Estimate only, not tax advice. State taxes, installment sales, 1031 exchanges and the exclusion for a primary residence aren't considered - consult a tax professional."
+
+// SaleProceedsRequest is the input for selling a flip or a rental. The cost
+// basis is the purchase price, capitalized rehab and purchase closing
+// costs. DepreciationTaken is what a rental has deducted while it was held;
+// it lowers the basis and is recaptured at RecaptureRate, which defaults to
+// the ordinary rate capped at 25%.
+type SaleProceedsRequest struct {
+	SalePrice         float64 `json:"sale_price" binding:"required,min=1"`
+	PurchasePrice     float64 `json:"purchase_price" binding:"min=0"`
+	RehabCost         float64 `json:"rehab_cost" binding:"min=0"`
+	ClosingCosts      float64 `json:"closing_costs" binding:"min=0"` // when bought
+	HoldMonths        int     `json:"hold_months" binding:"min=0,max=600"`
+	AgentCommission   float64 `json:"agent_commission" binding:"min=0,max=10"` // percentage of the sale price
+	TransferTax       float64 `json:"transfer_tax" binding:"min=0,max=5"`      // percentage of the sale price
+	OtherSellingCosts float64 `json:"other_selling_costs" binding:"min=0"`
+	LoanPayoff        float64 `json:"loan_payoff" binding:"min=0"`
+	OrdinaryRate      float64 `json:"ordinary_rate" binding:"min=0,max=60"`  // marginal percentage
+	LongTermRate      float64 `json:"long_term_rate" binding:"min=0,max=40"` // capital gains percentage
+	DepreciationTaken float64 `json:"depreciation_taken" binding:"min=0"`
+	RecaptureRate     float64 `json:"recapture_rate" binding:"min=0,max=40"` // percentage
+}
+
+// SaleProceeds is what a sale leaves the seller after selling costs, the
+// loan payoff and taxes. A loss has no tax and a negative taxable gain.
+type SaleProceeds struct {
+	GrossProceeds   float64    `json:"gross_proceeds"`
+	AgentCommission float64    `json:"agent_commission"`
+	TransferTaxes   float64    `json:"transfer_taxes"`
+	SellingCosts    float64    `json:"selling_costs"`
+	LoanPayoff      float64    `json:"loan_payoff"`
+	CostBasis       float64    `json:"cost_basis"`
+	AdjustedBasis   float64    `json:"adjusted_basis"` // less depreciation taken
+	TaxableGain     float64    `json:"taxable_gain"`
+	IsLongTerm      bool       `json:"is_long_term"`
+	RecapturedGain  float64    `json:"recaptured_gain"`
+	CapitalGain     float64    `json:"capital_gain"`
+	RecaptureTax    float64    `json:"recapture_tax"`
+	CapitalGainsTax float64    `json:"capital_gains_tax"`
+	EstimatedTax    float64    `json:"estimated_tax"`
+	NetCashToSeller float64    `json:"net_cash_to_seller"`
+	AfterTaxProfit  float64    `json:"after_tax_profit"` // net of the cost basis and tax
+	Disclaimer      Disclaimer `json:"disclaimer"`
+	Warnings        []string   `json:"warnings"`
+}
+
+// CalculateSaleProceeds estimates the net cash and taxes of selling a
+// property. A gain on a property held for 12 months or less is taxed at the
+// ordinary rate; after that it's taxed at the long-term rate, apart from
+// any depreciation recapture.
+func (s *ArvService) CalculateSaleProceeds(req SaleProceedsRequest) SaleProceeds {
+	result := SaleProceeds{
+		GrossProceeds:   req.SalePrice,
+		AgentCommission: req.SalePrice * req.AgentCommission / 100,
+		TransferTaxes:   req.SalePrice * req.TransferTax / 100,
+		LoanPayoff:      req.LoanPayoff,
+		CostBasis:       req.PurchasePrice + req.RehabCost + req.ClosingCosts,
+		IsLongTerm:      req.HoldMonths > longTermHoldMonths,
+		Disclaimer: Disclaimer{
+			Estimate:     true,
+			NotTaxAdvice: true,
+			Message:      saleTaxDisclaimer,
+		},
+		Warnings: []string{},
+	}
+	result.SellingCosts = result.AgentCommission + result.TransferTaxes + req.OtherSellingCosts
+	result.AdjustedBasis = result.CostBasis - req.DepreciationTaken
+	amountRealized := req.SalePrice - result.SellingCosts
+	result.TaxableGain = amountRealized - result.AdjustedBasis
+
+	if result.TaxableGain > 0 {
+		result.RecapturedGain = math.Min(req.DepreciationTaken, result.TaxableGain)
+		result.CapitalGain = result.TaxableGain - result.RecapturedGain
+
+		recaptureRate := req.RecaptureRate
+		if recaptureRate == 0 {
+			recaptureRate = math.Min(req.OrdinaryRate, maxRecaptureRate)
+		}
+		capitalRate := req.OrdinaryRate
+		if result.IsLongTerm {
+			capitalRate = req.LongTermRate
+		} else {
+			recaptureRate = req.OrdinaryRate
+		}
+		result.RecaptureTax = result.RecapturedGain * recaptureRate / 100
+		result.CapitalGainsTax = result.CapitalGain * capitalRate / 100
+		result.EstimatedTax = result.RecaptureTax + result.CapitalGainsTax
+	}
+
+	result.NetCashToSeller = amountRealized - req.LoanPayoff - result.EstimatedTax
+	result.AfterTaxProfit = amountRealized - result.CostBasis - result.EstimatedTax
+
+	if req.LoanPayoff > amountRealized {
+		result.Warnings = append(result.Warnings, "WARNING: Sale doesn't cover the loan payoff - cash is needed at closing")
+	}
+	if !result.IsLongTerm && req.HoldMonths > longTermHoldMonths-3 && result.TaxableGain > 0 {
+		result.Warnings = append(result.Warnings, "Holding for more than 12 months would have the gain taxed at the long-term rate")
+	}
+
+	result.AgentCommission = roundCents(result.AgentCommission)
+	result.TransferTaxes = roundCents(result.TransferTaxes)
+	result.SellingCosts = roundCents(result.SellingCosts)
+	result.AdjustedBasis = roundCents(result.AdjustedBasis)
+	result.TaxableGain = roundCents(result.TaxableGain)
+	result.RecapturedGain = roundCents(result.RecapturedGain)
+	result.CapitalGain = roundCents(result.CapitalGain)
+	result.RecaptureTax = roundCents(result.RecaptureTax)
+	result.CapitalGainsTax = roundCents(result.CapitalGainsTax)
+	result.EstimatedTax = roundCents(result.EstimatedTax)
+	result.NetCashToSeller = roundCents(result.NetCashToSeller)
+	result.AfterTaxProfit = roundCents(result.AfterTaxProfit)
+	return result
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateSaleProceeds_SixMonthFlip(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateSaleProceeds(SaleProceedsRequest{
+		SalePrice:         280000,
+		PurchasePrice:     170000,
+		RehabCost:         45000,
+		ClosingCosts:      4000,
+		HoldMonths:        6,
+		AgentCommission:   6,
+		TransferTax:       1,
+		OtherSellingCosts: 1500,
+		LoanPayoff:        180000,
+		OrdinaryRate:      32,
+		LongTermRate:      15,
+	})
+
+	// 6% commission, 1% transfer tax and 1,500 of other costs
+	assert.Equal(t, 16800.0, result.AgentCommission)
+	assert.Equal(t, 2800.0, result.TransferTaxes)
+	assert.Equal(t, 21100.0, result.SellingCosts)
+
+	// 258,900 realized on a 219,000 basis, held under a year so it's all
+	// taxed at the ordinary 32%
+	assert.Equal(t, 219000.0, result.CostBasis)
+	assert.Equal(t, 39900.0, result.TaxableGain)
+	assert.False(t, result.IsLongTerm)
+	assert.Equal(t, 12768.0, result.EstimatedTax)
+	assert.Equal(t, 66132.0, result.NetCashToSeller)
+	assert.Equal(t, 27132.0, result.AfterTaxProfit)
+	assert.True(t, result.Disclaimer.NotTaxAdvice)
+	assert.Empty(t, result.Warnings)
+}
+
+func TestCalculateSaleProceeds_ThreeYearRentalExit(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateSaleProceeds(SaleProceedsRequest{
+		SalePrice:         300000,
+		PurchasePrice:     180000,
+		RehabCost:         20000,
+		ClosingCosts:      3000,
+		HoldMonths:        36,
+		AgentCommission:   5,
+		TransferTax:       0.5,
+		LoanPayoff:        150000,
+		OrdinaryRate:      24,
+		LongTermRate:      15,
+		DepreciationTaken: 15000,
+	})
+
+	// Three years of depreciation lowers the 203,000 basis to 188,000
+	assert.Equal(t, 16500.0, result.SellingCosts)
+	assert.Equal(t, 188000.0, result.AdjustedBasis)
+	assert.Equal(t, 95500.0, result.TaxableGain)
+	assert.True(t, result.IsLongTerm)
+
+	// The 15,000 of depreciation is recaptured at 24%, and the rest is
+	// taxed at the long-term 15%
+	assert.Equal(t, 15000.0, result.RecapturedGain)
+	assert.Equal(t, 80500.0, result.CapitalGain)
+	assert.Equal(t, 3600.0, result.RecaptureTax)
+	assert.Equal(t, 12075.0, result.CapitalGainsTax)
+	assert.Equal(t, 15675.0, result.EstimatedTax)
+	assert.Equal(t, 117825.0, result.NetCashToSeller)
+	assert.Equal(t, 64825.0, result.AfterTaxProfit)
+}
+
+func TestCalculateSaleProceeds_LossHasNoTax(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateSaleProceeds(SaleProceedsRequest{
+		SalePrice:       200000,
+		PurchasePrice:   190000,
+		RehabCost:       20000,
+		HoldMonths:      11,
+		AgentCommission: 6,
+		LoanPayoff:      195000,
+		OrdinaryRate:    32,
+		LongTermRate:    15,
+	})
+
+	assert.Equal(t, -22000.0, result.TaxableGain)
+	assert.Equal(t, 0.0, result.EstimatedTax)
+	assert.Equal(t, -7000.0, result.NetCashToSeller)
+	assert.Contains(t, result.Warnings, "WARNING: Sale doesn't cover the loan payoff - cash is needed at closing")
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"errors"
+	"math"
+)
+
+// Sensitivity analysis errors
+var (
+	ErrInvalidSensitivityRange = errors.New("sensitivity range minimum is above its maximum")
+	ErrTooManyScenarios        = errors.New("sensitivity grid has too many scenarios")
+)
+
+// MaxSensitivityScenarios caps how many scenarios one analysis can run
+const MaxSensitivityScenarios = 500
+
+// defaultSensitivitySteps is how many values a range is split into when
+// the request doesn't say
+const defaultSensitivitySteps = 5
+
+// SensitivityRange varies an input from MinPercent to MaxPercent of its base
+// value in Steps evenly spaced values. A range that's left out keeps the
+// base value.
+type SensitivityRange struct {
+	MinPercent float64 `json:"min_percent" binding:"min=-90,max=200"`
+	MaxPercent float64 `json:"max_percent" binding:"min=-90,max=200"`
+	Steps      int     `json:"steps" binding:"min=0,max=21"`
+}
+
+// SensitivityRequest is a base deal and how far to vary its ARV, rent and
+// rehab cost
+type SensitivityRequest struct {
+	Base  ArvRequest       `json:"base" binding:"required"`
+	ARV   SensitivityRange `json:"arv"`
+	Rent  SensitivityRange `json:"rent"`
+	Rehab SensitivityRange `json:"rehab"`
+}
+
+// SensitivityScenario holds the key outputs of one combination of changes
+type SensitivityScenario struct {
+	ARVChange        float64  `json:"arv_change"` // percent of the base value
+	RentChange       float64  `json:"rent_change"`
+	RehabChange      float64  `json:"rehab_change"`
+	ARV              float64  `json:"arv"`
+	MonthlyRent      float64  `json:"monthly_rent"`
+	RehabCost        float64  `json:"rehab_cost"`
+	RefinanceAmount  float64  `json:"refinance_amount"`
+	MonthlyCashFlow  float64  `json:"monthly_cash_flow"`
+	CashOnCashReturn *float64 `json:"cash_on_cash_return"` // null when infinite
+	PotentialProfit  float64  `json:"potential_profit"`
+	DSCR             float64  `json:"dscr"`
+}
+
+// SensitivityBreakEven holds the value of each input, with the others left
+// at their base values, at which the deal stops working: the lowest ARV and
+// highest rehab cost that still break even on profit, and the lowest rent
+// that breaks even on cash flow. A value is nil when no break-even was found.
+type SensitivityBreakEven struct {
+	ARV         *float64 `json:"arv"`
+	MonthlyRent *float64 `json:"monthly_rent"`
+	RehabCost   *float64 `json:"rehab_cost"`
+}
+
+// SensitivityResult is the outcome of a sensitivity analysis
+type SensitivityResult struct {
+	Base      ArvResult             `json:"base"`
+	Scenarios []SensitivityScenario `json:"scenarios"`
+	BreakEven SensitivityBreakEven  `json:"break_even"`
+}
+
+// CalculateSensitivity runs CalculateARV across every combination of the
+// requested ARV, rent and rehab cost changes
+func (s *ArvService) CalculateSensitivity(req SensitivityRequest) (*SensitivityResult, error) {
+	arvChanges, err := sensitivitySteps(req.ARV)
+	if err != nil {
+		return nil, err
+	}
+	rentChanges, err := sensitivitySteps(req.Rent)
+	if err != nil {
+		return nil, err
+	}
+	rehabChanges, err := sensitivitySteps(req.Rehab)
+	if err != nil {
+		return nil, err
+	}
+	if len(arvChanges)*len(rentChanges)*len(rehabChanges) > MaxSensitivityScenarios {
+		return nil, ErrTooManyScenarios
+	}
+
+	// An absent rent is estimated from the ARV, so vary the estimate
+	base := req.Base
+	if base.MonthlyRent == 0 {
+		base.MonthlyRent = base.ARV * 0.01
+	}
+
+	result := &SensitivityResult{
+		Base:      s.CalculateARV(req.Base),
+		Scenarios: []SensitivityScenario{},
+	}
+	for _, arvChange := range arvChanges {
+		for _, rentChange := range rentChanges {
+			for _, rehabChange := range rehabChanges {
+				scenario := base
+				scenario.ARV = roundCents(base.ARV * (1 + arvChange/100))
+				scenario.MonthlyRent = roundCents(base.MonthlyRent * (1 + rentChange/100))
+				scenario.RehabCost = roundCents(base.RehabCost * (1 + rehabChange/100))
+				calc := s.CalculateARV(scenario)
+
+				result.Scenarios = append(result.Scenarios, SensitivityScenario{
+					ARVChange:        arvChange,
+					RentChange:       rentChange,
+					RehabChange:      rehabChange,
+					ARV:              scenario.ARV,
+					MonthlyRent:      scenario.MonthlyRent,
+					RehabCost:        scenario.RehabCost,
+					RefinanceAmount:  calc.RefinanceAmount,
+					MonthlyCashFlow:  calc.MonthlyCashFlow,
+					CashOnCashReturn: calc.CashOnCashReturn,
+					PotentialProfit:  calc.PotentialProfit,
+					DSCR:             calc.DSCR,
+				})
+			}
+		}
+	}
+
+	result.BreakEven = s.sensitivityBreakEven(base)
+	return result, nil
+}
+
+// sensitivityBreakEven solves for each input's break-even value in turn
+func (s *ArvService) sensitivityBreakEven(base ArvRequest) SensitivityBreakEven {
+	var breakEven SensitivityBreakEven
+
+	breakEven.ARV = solveBreakEven(1, base.ARV, func(arv float64) float64 {
+		req := base
+		req.ARV = arv
+		return s.CalculateARV(req).PotentialProfit
+	})
+	breakEven.MonthlyRent = solveBreakEven(0.01, base.MonthlyRent, func(rent float64) float64 {
+		req := base
+		req.MonthlyRent = rent
+		return s.CalculateARV(req).MonthlyCashFlow
+	})
+	// Profit falls as rehab cost rises, so solve on the loss instead
+	breakEven.RehabCost = solveBreakEven(0, base.RehabCost, func(rehab float64) float64 {
+		req := base
+		req.RehabCost = rehab
+		return -s.CalculateARV(req).PotentialProfit
+	})
+	return breakEven
+}
+
+// solveBreakEven finds where the increasing function f crosses zero, by
+// bisection between lo and an upper bound found by doubling guess. It
+// returns nil when f doesn't cross zero between lo and 100 times guess.
+func solveBreakEven(lo, guess float64, f func(float64) float64) *float64 {
+	switch start := f(lo); {
+	case start > 0:
+		return nil
+	case start == 0:
+		value := roundCents(lo)
+		return &value
+	}
+
+	hi := math.Max(guess, lo+1)
+	limit := 100 * hi
+	for f(hi) < 0 {
+		if hi >= limit {
+			return nil
+		}
+		lo, hi = hi, hi*2
+	}
+
+	for i := 0; i < 100 && hi-lo > 0.001; i++ {
+		mid := (lo + hi) / 2
+		if f(mid) < 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	value := roundCents(hi)
+	return &value
+}
+
+// sensitivitySteps lists the percentage changes a range covers
+func sensitivitySteps(r SensitivityRange) ([]float64, error) {
+	if r.MinPercent > r.MaxPercent {
+		return nil, ErrInvalidSensitivityRange
+	}
+	if r.MinPercent == r.MaxPercent {
+		return []float64{r.MinPercent}, nil
+	}
+
+	steps := r.Steps
+	if steps == 0 {
+		steps = defaultSensitivitySteps
+	}
+	if steps == 1 {
+		return []float64{r.MinPercent}, nil
+	}
+	changes := make([]float64, steps)
+	for i := range changes {
+		changes[i] = roundCents(r.MinPercent + (r.MaxPercent-r.MinPercent)*float64(i)/float64(steps-1))
+	}
+	return changes, nil
+}
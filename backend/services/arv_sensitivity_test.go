@@ -0,0 +1,141 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sensitivityBase is a fully specified BRRRR deal, so no input is defaulted
+func sensitivityBase() ArvRequest {
+	return ArvRequest{
+		PurchasePrice: 100000,
+		RehabCost:     30000,
+		ClosingCosts:  3000,
+		ARV:           180000,
+		SellingCosts:  10000,
+		MonthlyRent:   1600,
+		VacancyRate:   5,
+		PropertyTaxes: 2400,
+		Insurance:     1200,
+		Maintenance:   1200,
+		CapEx:         600,
+		InterestRate:  7,
+		LoanTerm:      30,
+	}
+}
+
+func TestCalculateSensitivity_Grid(t *testing.T) {
+	service := NewArvService()
+
+	result, err := service.CalculateSensitivity(SensitivityRequest{
+		Base:  sensitivityBase(),
+		ARV:   SensitivityRange{MinPercent: -10, MaxPercent: 10, Steps: 3},
+		Rent:  SensitivityRange{MinPercent: -15, MaxPercent: 15, Steps: 3},
+		Rehab: SensitivityRange{MinPercent: 0, MaxPercent: 25, Steps: 2},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, result.Scenarios, 18)
+	first := result.Scenarios[0]
+	assert.Equal(t, -10.0, first.ARVChange)
+	assert.Equal(t, -15.0, first.RentChange)
+	assert.Equal(t, 0.0, first.RehabChange)
+	assert.Equal(t, 162000.0, first.ARV)
+	assert.Equal(t, 1360.0, first.MonthlyRent)
+	assert.Equal(t, 30000.0, first.RehabCost)
+
+	last := result.Scenarios[17]
+	assert.Equal(t, 198000.0, last.ARV)
+	assert.Equal(t, 1840.0, last.MonthlyRent)
+	assert.Equal(t, 37500.0, last.RehabCost)
+
+	// The base scenario matches a plain calculation
+	base := result.Scenarios[8]
+	assert.Equal(t, 0.0, base.ARVChange+base.RentChange+base.RehabChange)
+	assert.Equal(t, result.Base.MonthlyCashFlow, base.MonthlyCashFlow)
+	assert.Equal(t, result.Base.PotentialProfit, base.PotentialProfit)
+}
+
+func TestCalculateSensitivity_Monotonic(t *testing.T) {
+	service := NewArvService()
+
+	result, err := service.CalculateSensitivity(SensitivityRequest{
+		Base: sensitivityBase(),
+		ARV:  SensitivityRange{MinPercent: -20, MaxPercent: 20, Steps: 9},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Scenarios, 9)
+
+	// A higher ARV refinances for more and profits more, but borrowing more
+	// costs cash flow
+	for i := 1; i < len(result.Scenarios); i++ {
+		prev, next := result.Scenarios[i-1], result.Scenarios[i]
+		assert.Greater(t, next.RefinanceAmount, prev.RefinanceAmount)
+		assert.Greater(t, next.PotentialProfit, prev.PotentialProfit)
+		assert.Less(t, next.MonthlyCashFlow, prev.MonthlyCashFlow)
+	}
+
+	result, err = service.CalculateSensitivity(SensitivityRequest{
+		Base: sensitivityBase(),
+		Rent: SensitivityRange{MinPercent: -30, MaxPercent: 30},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Scenarios, 5, "ranges default to 5 steps")
+	for i := 1; i < len(result.Scenarios); i++ {
+		assert.Greater(t, result.Scenarios[i].MonthlyCashFlow, result.Scenarios[i-1].MonthlyCashFlow)
+		assert.Greater(t, result.Scenarios[i].DSCR, result.Scenarios[i-1].DSCR)
+	}
+}
+
+func TestCalculateSensitivity_BreakEven(t *testing.T) {
+	service := NewArvService()
+	base := sensitivityBase()
+
+	result, err := service.CalculateSensitivity(SensitivityRequest{Base: base})
+	require.NoError(t, err)
+	require.Len(t, result.Scenarios, 1)
+
+	// Profit is ARV less the 133,000 invested and 10,000 of selling costs
+	require.NotNil(t, result.BreakEven.ARV)
+	assert.InDelta(t, 143000, *result.BreakEven.ARV, 0.01)
+	require.NotNil(t, result.BreakEven.RehabCost)
+	assert.InDelta(t, 67000, *result.BreakEven.RehabCost, 0.01)
+
+	// At the break-even rent, cash flow is zero
+	require.NotNil(t, result.BreakEven.MonthlyRent)
+	base.MonthlyRent = *result.BreakEven.MonthlyRent
+	assert.InDelta(t, 0, service.CalculateARV(base).MonthlyCashFlow, 0.01)
+}
+
+func TestCalculateSensitivity_NoBreakEven(t *testing.T) {
+	service := NewArvService()
+	base := sensitivityBase()
+	base.ARV = 110000 // loses money even with no rehab
+
+	result, err := service.CalculateSensitivity(SensitivityRequest{Base: base})
+	require.NoError(t, err)
+
+	assert.Nil(t, result.BreakEven.RehabCost)
+	require.NotNil(t, result.BreakEven.ARV)
+	assert.InDelta(t, 143000, *result.BreakEven.ARV, 0.01)
+}
+
+func TestCalculateSensitivity_InvalidRanges(t *testing.T) {
+	service := NewArvService()
+
+	_, err := service.CalculateSensitivity(SensitivityRequest{
+		Base: sensitivityBase(),
+		ARV:  SensitivityRange{MinPercent: 10, MaxPercent: -10},
+	})
+	assert.ErrorIs(t, err, ErrInvalidSensitivityRange)
+
+	_, err = service.CalculateSensitivity(SensitivityRequest{
+		Base:  sensitivityBase(),
+		ARV:   SensitivityRange{MinPercent: -10, MaxPercent: 10, Steps: 21},
+		Rent:  SensitivityRange{MinPercent: -10, MaxPercent: 10, Steps: 21},
+		Rehab: SensitivityRange{MinPercent: 0, MaxPercent: 10, Steps: 2},
+	})
+	assert.ErrorIs(t, err, ErrTooManyScenarios)
+}
@@ -0,0 +1,179 @@
+package services
+
+import "fmt"
+
+// defaultSTRAverageStay is the nights per booking assumed when working out
+// how many turnovers a short-term rental has
+const defaultSTRAverageStay = 3.0
+
+// daysInMonth is a non-leap year's days, January first
+var daysInMonth = [12]int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// STRInputs describe a property run as a short-term rental. MonthlyOccupancy,
+// when given, overrides OccupancyRate with a percentage for each month,
+// January first, to model seasonality. CleaningFee is charged to guests and
+// CleaningCost paid to cleaners, both per stay.
+type STRInputs struct {
+	NightlyRate      float64   `json:"nightly_rate" binding:"required,min=1"`
+	OccupancyRate    float64   `json:"occupancy_rate" binding:"min=0,max=100"` // percentage of nights booked
+	MonthlyOccupancy []float64 `json:"monthly_occupancy" binding:"omitempty,len=12,dive,min=0,max=100"`
+	AverageStay      float64   `json:"average_stay" binding:"min=0"` // nights, default 3
+	CleaningFee      float64   `json:"cleaning_fee" binding:"min=0"`
+	CleaningCost     float64   `json:"cleaning_cost" binding:"min=0"`
+	PlatformFee      float64   `json:"platform_fee" binding:"min=0,max=100"` // percentage of revenue
+	FurnishingBudget float64   `json:"furnishing_budget" binding:"min=0"`
+	Utilities        float64   `json:"utilities" binding:"min=0"`               // monthly
+	Supplies         float64   `json:"supplies" binding:"min=0"`                // monthly
+	ManagementRate   float64   `json:"management_rate" binding:"min=0,max=100"` // percentage of revenue
+}
+
+// STRResult is a property's returns as a short-term rental, on the same
+// refinance loan as the long-term rental numbers it sits alongside. Taxes,
+// insurance, maintenance, capex and other expenses are carried over from
+// the long-term rental; the rest of the operating costs are the STR's own.
+type STRResult struct {
+	BookedNights       float64  `json:"booked_nights"`
+	Occupancy          float64  `json:"occupancy"` // percentage, averaged over the year
+	Stays              float64  `json:"stays"`
+	RentalRevenue      float64  `json:"rental_revenue"`
+	CleaningFeeIncome  float64  `json:"cleaning_fee_income"`
+	GrossRevenue       float64  `json:"gross_revenue"`
+	PlatformFees       float64  `json:"platform_fees"`
+	CleaningCosts      float64  `json:"cleaning_costs"`
+	Utilities          float64  `json:"utilities"`
+	Supplies           float64  `json:"supplies"`
+	Management         float64  `json:"management"`
+	FixedExpenses      float64  `json:"fixed_expenses"`
+	OperatingExpenses  float64  `json:"operating_expenses"`
+	NOI                float64  `json:"noi"`
+	MonthlyCashFlow    float64  `json:"monthly_cash_flow"`
+	AnnualCashFlow     float64  `json:"annual_cash_flow"`
+	FurnishingBudget   float64  `json:"furnishing_budget"`
+	TotalInvestment    float64  `json:"total_investment"`
+	CashLeftIn         float64  `json:"cash_left_in"`
+	CashOnCashReturn   *float64 `json:"cash_on_cash_return"` // null when infinite
+	CapRate            float64  `json:"cap_rate"`
+	DSCR               float64  `json:"dscr"`
+	CashFlowVsLongTerm float64  `json:"cash_flow_vs_long_term"` // annual, STR less long-term rental
+}
+
+// calculateSTR models req's short-term rental inputs against the long-term
+// rental already worked out in result. It returns nil when req has none.
+func (s *ArvService) calculateSTR(req ArvRequest, result *ArvResult) *STRResult {
+	if req.STR == nil {
+		return nil
+	}
+	in := req.STR
+	str := &STRResult{FurnishingBudget: in.FurnishingBudget}
+
+	if len(in.MonthlyOccupancy) == len(daysInMonth) {
+		for month, days := range daysInMonth {
+			str.BookedNights += float64(days) * in.MonthlyOccupancy[month] / 100
+		}
+	} else {
+		str.BookedNights = 365 * in.OccupancyRate / 100
+	}
+	if str.BookedNights == 0 {
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnSTRNoOccupancy, Severity: SeverityWarning, Field: "str.occupancy_rate",
+			Message: "WARNING: STR occupancy is 0% - no nights are booked",
+		})
+	}
+	str.Occupancy = str.BookedNights / 365 * 100
+
+	averageStay := in.AverageStay
+	if averageStay == 0 {
+		averageStay = defaultSTRAverageStay
+		result.assume("str.average_stay", averageStay, "Average stay of 3 nights")
+		if in.CleaningFee > 0 || in.CleaningCost > 0 {
+			result.Warnings = append(result.Warnings, Notice{
+				Code: WarnSTRAverageStayDefaulted, Severity: SeverityInfo, Field: "str.average_stay",
+				Message: "Using default STR average stay of 3 nights",
+			})
+		}
+	}
+	str.Stays = str.BookedNights / averageStay
+
+	str.RentalRevenue = str.BookedNights * in.NightlyRate
+	str.CleaningFeeIncome = str.Stays * in.CleaningFee
+	str.GrossRevenue = str.RentalRevenue + str.CleaningFeeIncome
+
+	str.PlatformFees = str.GrossRevenue * in.PlatformFee / 100
+	str.CleaningCosts = str.Stays * in.CleaningCost
+	str.Utilities = in.Utilities * 12
+	str.Supplies = in.Supplies * 12
+	str.Management = str.GrossRevenue * in.ManagementRate / 100
+	str.FixedExpenses = req.PropertyTaxes + req.Insurance + req.Maintenance + req.CapEx + req.OtherExpenses + req.HOADues*12
+	str.OperatingExpenses = str.PlatformFees + str.CleaningCosts + str.Utilities + str.Supplies +
+		str.Management + str.FixedExpenses
+
+	str.NOI = str.GrossRevenue - str.OperatingExpenses
+	str.MonthlyCashFlow = str.NOI/12 - result.MonthlyDebtService
+	str.AnnualCashFlow = str.MonthlyCashFlow * 12
+
+	// Furnishing is paid in cash on top of everything the refinance covers
+	str.TotalInvestment = result.TotalInvestment + in.FurnishingBudget
+	str.CashLeftIn = str.TotalInvestment - result.RefinanceAmount
+	if str.CashLeftIn < 0 {
+		str.CashLeftIn = 0
+	}
+	str.CashOnCashReturn = cashOnCashReturn(str.AnnualCashFlow, str.CashLeftIn)
+	if req.ARV > 0 {
+		str.CapRate = str.NOI / req.ARV * 100
+	}
+	if result.MonthlyDebtService > 0 {
+		str.DSCR = str.NOI / (result.MonthlyDebtService * 12)
+	}
+	str.CashFlowVsLongTerm = str.AnnualCashFlow - result.AnnualCashFlow
+
+	roundSTR(str)
+	return str
+}
+
+// strRecommendation compares a short-term rental's cash flow with the
+// long-term rental's, and reports whether there was an STR to compare
+func strRecommendation(result ArvResult) (Notice, bool) {
+	if result.STR == nil {
+		return Notice{}, false
+	}
+	if result.STR.CashFlowVsLongTerm > 0 {
+		return Notice{
+			Code:     RecSTROutperformsLTR,
+			Severity: SeverityInfo,
+			Message:  fmt.Sprintf("Short-term rental cash flows $%.2f a year more than a long-term rental - check local STR regulations", result.STR.CashFlowVsLongTerm),
+		}, true
+	}
+	return Notice{
+		Code:     RecLTROutperformsSTR,
+		Severity: SeverityInfo,
+		Message:  fmt.Sprintf("Long-term rental cash flows $%.2f a year more than a short-term rental", -result.STR.CashFlowVsLongTerm),
+	}, true
+}
+
+// roundSTR rounds a short-term rental's amounts to the cent
+func roundSTR(str *STRResult) {
+	str.BookedNights = roundCents(str.BookedNights)
+	str.Occupancy = roundCents(str.Occupancy)
+	str.Stays = roundCents(str.Stays)
+	str.RentalRevenue = roundCents(str.RentalRevenue)
+	str.CleaningFeeIncome = roundCents(str.CleaningFeeIncome)
+	str.GrossRevenue = roundCents(str.GrossRevenue)
+	str.PlatformFees = roundCents(str.PlatformFees)
+	str.CleaningCosts = roundCents(str.CleaningCosts)
+	str.Utilities = roundCents(str.Utilities)
+	str.Supplies = roundCents(str.Supplies)
+	str.Management = roundCents(str.Management)
+	str.FixedExpenses = roundCents(str.FixedExpenses)
+	str.OperatingExpenses = roundCents(str.OperatingExpenses)
+	str.NOI = roundCents(str.NOI)
+	str.MonthlyCashFlow = roundCents(str.MonthlyCashFlow)
+	str.AnnualCashFlow = roundCents(str.AnnualCashFlow)
+	str.TotalInvestment = roundCents(str.TotalInvestment)
+	str.CashLeftIn = roundCents(str.CashLeftIn)
+	if str.CashOnCashReturn != nil {
+		*str.CashOnCashReturn = roundCents(*str.CashOnCashReturn)
+	}
+	str.CapRate = roundCents(str.CapRate)
+	str.DSCR = roundCents(str.DSCR)
+	str.CashFlowVsLongTerm = roundCents(str.CashFlowVsLongTerm)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func strRequest() ArvRequest {
+	return ArvRequest{
+		PurchasePrice: 150000,
+		RehabCost:     30000,
+		HoldingCosts:  2000,
+		ClosingCosts:  4000,
+		ARV:           250000,
+		MonthlyRent:   1800,
+		VacancyRate:   5,
+		PropertyTaxes: 3000,
+		Insurance:     1500,
+		Maintenance:   2000,
+		CapEx:         1000,
+		InterestRate:  7,
+		STR: &STRInputs{
+			NightlyRate:      180,
+			OccupancyRate:    65,
+			AverageStay:      3,
+			CleaningFee:      100,
+			CleaningCost:     80,
+			PlatformFee:      3,
+			FurnishingBudget: 15000,
+			Utilities:        300,
+			Supplies:         100,
+			ManagementRate:   20,
+		},
+	}
+}
+
+func TestCalculateARV_STRComparedWithLongTermRental(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(strRequest())
+
+	// The long-term rental: 1,800 less 5% vacancy and 7,500 of expenses,
+	// against 1,247.44 a month on the 187,500 refinance
+	assert.Equal(t, 13020.0, result.NOI)
+	assert.Equal(t, -1949.31, result.AnnualCashFlow)
+
+	require.NotNil(t, result.STR)
+	str := result.STR
+
+	// 65% of 365 nights at 180, with a 100 cleaning fee on each 3-night stay
+	assert.Equal(t, 237.25, str.BookedNights)
+	assert.Equal(t, 65.0, str.Occupancy)
+	assert.Equal(t, 79.08, str.Stays)
+	assert.Equal(t, 42705.0, str.RentalRevenue)
+	assert.Equal(t, 7908.33, str.CleaningFeeIncome)
+	assert.Equal(t, 50613.33, str.GrossRevenue)
+
+	// 3% platform and 20% management fees, 80 a clean, 400 a month of
+	// utilities and supplies, and the long-term rental's 7,500
+	assert.Equal(t, 1518.4, str.PlatformFees)
+	assert.Equal(t, 6326.67, str.CleaningCosts)
+	assert.Equal(t, 10122.67, str.Management)
+	assert.Equal(t, 7500.0, str.FixedExpenses)
+	assert.Equal(t, 30267.73, str.OperatingExpenses)
+	assert.Equal(t, 20345.6, str.NOI)
+
+	// Furnishing leaves 13,500 in the deal after the refinance
+	assert.Equal(t, 201000.0, str.TotalInvestment)
+	assert.Equal(t, 13500.0, str.CashLeftIn)
+	assert.Equal(t, 448.02, str.MonthlyCashFlow)
+	assert.Equal(t, 5376.29, str.AnnualCashFlow)
+	assert.Equal(t, 39.82, *str.CashOnCashReturn)
+	assert.Equal(t, 8.14, str.CapRate)
+	assert.Equal(t, 1.36, str.DSCR)
+	assert.Equal(t, 7325.6, str.CashFlowVsLongTerm)
+
+	assert.Contains(t, NoticeMessages(result.Recommendations),
+		"Short-term rental cash flows $7325.60 a year more than a long-term rental - check local STR regulations")
+}
+
+func TestCalculateARV_STRMonthlyOccupancy(t *testing.T) {
+	service := NewArvService()
+	req := strRequest()
+	req.STR.MonthlyOccupancy = []float64{40, 40, 50, 60, 75, 90, 95, 90, 70, 55, 45, 60}
+
+	result := service.CalculateARV(req)
+
+	// Each month's occupancy applies to its own days, overriding the flat 65%
+	require.NotNil(t, result.STR)
+	assert.Equal(t, 234.85, result.STR.BookedNights)
+	assert.Equal(t, 64.34, result.STR.Occupancy)
+	assert.Equal(t, 42273.0, result.STR.RentalRevenue)
+}
+
+func TestCalculateARV_STRLeftOut(t *testing.T) {
+	service := NewArvService()
+	req := strRequest()
+	req.STR = nil
+
+	result := service.CalculateARV(req)
+
+	assert.Nil(t, result.STR)
+	for _, recommendation := range result.Recommendations {
+		assert.NotContains(t, recommendation.Message, "short-term rental")
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"fmt"
+)
+
+// defaultDownPaymentPercent is the rental down payment when the request
+// doesn't give one
+const defaultDownPaymentPercent = 25.0
+
+// Strategies a comparison can recommend
+const (
+	StrategyFlip   = "flip"
+	StrategyBRRRR  = "brrrr"
+	StrategyRental = "rental"
+)
+
+// StrategyComparisonRequest is one property run through a flip, a BRRRR and
+// a conventional rental. The shared deal inputs are entered once; the flip
+// takes its monthly taxes and insurance from the annual figures and its
+// loan from any hard money loan, and the rental borrows at InterestRate over
+// LoanTerm.
+type StrategyComparisonRequest struct {
+	ArvRequest
+
+	// Flip
+	RehabMonths      int     `json:"rehab_months" binding:"min=0,max=60"`
+	ListingMonths    int     `json:"listing_months" binding:"min=0,max=60"`
+	MonthlyUtilities float64 `json:"monthly_utilities" binding:"min=0"`
+	AgentCommission  float64 `json:"agent_commission" binding:"min=0,max=20"` // percentage
+
+	// Conventional rental
+	DownPaymentPercent float64 `json:"down_payment_percent" binding:"min=0,max=100"` // default 25
+}
+
+// FlipStrategy summarizes selling the property after the rehab
+type FlipStrategy struct {
+	Profit           float64 `json:"profit"`
+	ROI              float64 `json:"roi"`
+	AnnualizedROI    float64 `json:"annualized_roi"`
+	TotalHoldingCost float64 `json:"total_holding_cost"`
+	BreakEvenPrice   float64 `json:"break_even_sale_price"`
+	RiskLevel        string  `json:"risk_level"`
+}
+
+// BrrrrStrategy summarizes refinancing the property after the rehab and
+// renting it out
+type BrrrrStrategy struct {
+	CashLeftIn       float64  `json:"cash_left_in"`
+	CashOnCashReturn *float64 `json:"cash_on_cash_return"` // null when infinite
+	IsInfiniteReturn bool     `json:"is_infinite_return"`
+	MonthlyCashFlow  float64  `json:"monthly_cash_flow"`
+	RiskLevel        string   `json:"risk_level"`
+}
+
+// RentalStrategy summarizes buying the property with a conventional loan,
+// paying for the rehab in cash and renting it out. Cap rate is on the
+// purchase price plus rehab.
+type RentalStrategy struct {
+	DownPayment        float64 `json:"down_payment"`
+	LoanAmount         float64 `json:"loan_amount"`
+	CashNeeded         float64 `json:"cash_needed"`
+	MonthlyDebtService float64 `json:"monthly_debt_service"`
+	MonthlyCashFlow    float64 `json:"monthly_cash_flow"`
+	CashOnCashReturn   float64 `json:"cash_on_cash_return"`
+	CapRate            float64 `json:"cap_rate"`
+	RiskLevel          string  `json:"risk_level"`
+}
+
+// StrategyComparison holds a property's results under each strategy and
+// which one looks best
+type StrategyComparison struct {
+	Flip           FlipStrategy   `json:"flip"`
+	BRRRR          BrrrrStrategy  `json:"brrrr"`
+	Rental         RentalStrategy `json:"rental"`
+	Recommended    string         `json:"recommended"`
+	Recommendation string         `json:"recommendation"`
+	Warnings       []string       `json:"warnings"`
+}
+
+// CompareStrategies runs one property through a flip, a BRRRR and a
+// conventional rental
+func (s *ArvService) CompareStrategies(req StrategyComparisonRequest) StrategyComparison {
+	brrrr := s.CalculateEnhancedBRRRR(req.ArvRequest)
+	comparison := StrategyComparison{
+		BRRRR: BrrrrStrategy{
+			CashLeftIn:       brrrr.CashLeftIn,
+			CashOnCashReturn: brrrr.CashOnCashReturn,
+			IsInfiniteReturn: brrrr.IsInfiniteReturn,
+			MonthlyCashFlow:  brrrr.MonthlyCashFlow,
+			RiskLevel:        brrrr.RiskLevel,
+		},
+		Warnings: NoticeMessages(brrrr.Warnings),
+	}
+
+	// The flip and the rental use the same defaulted inputs the BRRRR did
+	deal := req.ArvRequest
+	s.setDefaultsAndValidate(&deal, &ArvResult{})
+
+	flipReq := FlipRequest{
+		PurchasePrice:    deal.PurchasePrice,
+		RehabCost:        deal.RehabCost,
+		ClosingCosts:     deal.ClosingCosts + deal.HoldingCosts + deal.FinancingCosts,
+		ARV:              deal.ARV,
+		SellingCosts:     deal.SellingCosts,
+		RehabMonths:      req.RehabMonths,
+		ListingMonths:    req.ListingMonths,
+		MonthlyTaxes:     deal.PropertyTaxes / 12,
+		MonthlyInsurance: deal.Insurance / 12,
+		MonthlyUtilities: req.MonthlyUtilities,
+		AgentCommission:  req.AgentCommission,
+	}
+	if brrrr.HardMoney != nil {
+		flipReq.LoanAmount = brrrr.HardMoney.LoanAmount
+		flipReq.LoanInterestRate = deal.HardMoneyRate
+		flipReq.ClosingCosts += brrrr.HardMoney.OriginationFee
+	}
+	if deal.HoldingCosts > 0 && req.RehabMonths+req.ListingMonths > 0 {
+		comparison.Warnings = append(comparison.Warnings, "Flip counts holding costs on top of the timeline's carrying costs")
+	}
+	flip := s.CalculateFlip(flipReq)
+	comparison.Flip = FlipStrategy{
+		Profit:           flip.Profit,
+		ROI:              flip.ROI,
+		AnnualizedROI:    flip.AnnualizedROI,
+		TotalHoldingCost: flip.TotalHoldingCost,
+		BreakEvenPrice:   flip.BreakEvenPrice,
+		RiskLevel:        s.assessRisk(flip.ROI, brrrr.Is70RuleGood, deal.ARV, deal.PurchasePrice),
+	}
+
+	comparison.Rental = s.rentalStrategy(req, deal, brrrr)
+	comparison.Recommended, comparison.Recommendation = recommendStrategy(comparison)
+	return comparison
+}
+
+// rentalStrategy works out a conventional rental of the deal, which has the
+// same rent and expenses as the BRRRR
+func (s *ArvService) rentalStrategy(req StrategyComparisonRequest, deal ArvRequest, brrrr ArvResult) RentalStrategy {
+	downPercent := req.DownPaymentPercent
+	if downPercent == 0 {
+		downPercent = defaultDownPaymentPercent
+	}
+
+	rental := RentalStrategy{
+		DownPayment: deal.PurchasePrice * downPercent / 100,
+		LoanAmount:  deal.PurchasePrice * (1 - downPercent/100),
+	}
+	rental.CashNeeded = rental.DownPayment + deal.RehabCost + deal.HoldingCosts + deal.ClosingCosts + deal.FinancingCosts
+	if rental.LoanAmount > 0 {
+		rental.MonthlyDebtService = s.calculateMonthlyPayment(rental.LoanAmount, deal.InterestRate, deal.LoanTerm)
+	}
+	rental.MonthlyCashFlow = brrrr.NOI/12 - rental.MonthlyDebtService
+	// A deal needing no cash or costing nothing reports 0 for these
+	rental.CashOnCashReturn, _ = s.CalculateCashOnCashReturn(rental.MonthlyCashFlow*12, rental.CashNeeded)
+	rental.CapRate, _ = s.CalculateCapRate(brrrr.NOI, deal.PurchasePrice+deal.RehabCost)
+
+	risk := ArvResult{
+		MonthlyCashFlow:  rental.MonthlyCashFlow,
+		CapRate:          rental.CapRate,
+		CashOnCashReturn: &rental.CashOnCashReturn,
+		ExpenseRatio:     brrrr.ExpenseRatio,
+	}
+	if rental.MonthlyDebtService > 0 {
+		risk.DSCR = brrrr.NOI / (rental.MonthlyDebtService * 12)
+	} else {
+		risk.DSCR = 999.99
+	}
+	rental.RiskLevel = s.assessBRRRRisk(risk)
+
+	rental.DownPayment = roundCents(rental.DownPayment)
+	rental.LoanAmount = roundCents(rental.LoanAmount)
+	rental.CashNeeded = roundCents(rental.CashNeeded)
+	rental.MonthlyDebtService = roundCents(rental.MonthlyDebtService)
+	rental.MonthlyCashFlow = roundCents(rental.MonthlyCashFlow)
+	rental.CashOnCashReturn = roundCents(rental.CashOnCashReturn)
+	rental.CapRate = roundCents(rental.CapRate)
+	return rental
+}
+
+// riskRank orders risk levels from lowest to highest
+var riskRank = map[string]int{"Low": 0, "Medium": 1, "High": 2, "Very High": 3}
+
+// recommendStrategy picks the strategy with the lowest risk, breaking ties
+// on its annual return: annualized ROI for the flip, cash-on-cash for the
+// others
+func recommendStrategy(c StrategyComparison) (string, string) {
+	candidates := []struct {
+		strategy string
+		label    string
+		risk     string
+		ret      float64
+		metric   string
+	}{
+		{StrategyFlip, "Flip", c.Flip.RiskLevel, c.Flip.AnnualizedROI, "annualized ROI"},
+		{StrategyBRRRR, "BRRRR", c.BRRRR.RiskLevel, cashOnCashValue(c.BRRRR.CashOnCashReturn, c.BRRRR.IsInfiniteReturn), "cash-on-cash return"},
+		{StrategyRental, "Buy-and-hold rental", c.Rental.RiskLevel, c.Rental.CashOnCashReturn, "cash-on-cash return"},
+	}
+
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if riskRank[candidate.risk] < riskRank[best.risk] ||
+			(riskRank[candidate.risk] == riskRank[best.risk] && candidate.ret > best.ret) {
+			best = candidate
+		}
+	}
+
+	if best.strategy == StrategyBRRRR && c.BRRRR.IsInfiniteReturn {
+		return best.strategy, fmt.Sprintf("BRRRR looks strongest: %s risk, and the refinance recovers all the cash invested", best.risk)
+	}
+	return best.strategy, fmt.Sprintf("%s looks strongest: %s risk with a %.2f%% %s", best.label, best.risk, best.ret, best.metric)
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func strategyDeal() StrategyComparisonRequest {
+	return StrategyComparisonRequest{
+		ArvRequest: ArvRequest{
+			PurchasePrice: 110000,
+			RehabCost:     35000,
+			ClosingCosts:  3000,
+			ARV:           220000,
+			SellingCosts:  4000,
+			MonthlyRent:   1900,
+			VacancyRate:   5,
+			PropertyTaxes: 3000,
+			Insurance:     1200,
+			Maintenance:   1900,
+			CapEx:         1100,
+			InterestRate:  7,
+			LoanTerm:      30,
+		},
+		RehabMonths:      4,
+		ListingMonths:    2,
+		MonthlyUtilities: 150,
+		AgentCommission:  5,
+	}
+}
+
+func TestCompareStrategies_BRRRRMatchesEnhancedCalculation(t *testing.T) {
+	service := NewArvService()
+	req := strategyDeal()
+
+	comparison := service.CompareStrategies(req)
+	brrrr := service.CalculateEnhancedBRRRR(req.ArvRequest)
+
+	assert.Equal(t, brrrr.CashLeftIn, comparison.BRRRR.CashLeftIn)
+	assert.Equal(t, brrrr.CashOnCashReturn, comparison.BRRRR.CashOnCashReturn)
+	assert.Equal(t, brrrr.IsInfiniteReturn, comparison.BRRRR.IsInfiniteReturn)
+	assert.Equal(t, brrrr.MonthlyCashFlow, comparison.BRRRR.MonthlyCashFlow)
+	assert.Equal(t, brrrr.RiskLevel, comparison.BRRRR.RiskLevel)
+}
+
+func TestCompareStrategies_FlipUsesSharedInputs(t *testing.T) {
+	service := NewArvService()
+
+	comparison := service.CompareStrategies(strategyDeal())
+
+	// 250 of taxes, 100 of insurance and 150 of utilities for 6 months
+	assert.Equal(t, 3000.0, comparison.Flip.TotalHoldingCost)
+	// 220,000 less 11,000 commission and 4,000 selling costs, against
+	// 110,000 + 35,000 + 3,000 + 3,000
+	assert.Equal(t, 54000.0, comparison.Flip.Profit)
+	assert.Equal(t, 35.76, comparison.Flip.ROI)
+	assert.Equal(t, service.CalculateFlip(FlipRequest{
+		PurchasePrice:    110000,
+		RehabCost:        35000,
+		ClosingCosts:     3000,
+		ARV:              220000,
+		SellingCosts:     4000,
+		RehabMonths:      4,
+		ListingMonths:    2,
+		MonthlyTaxes:     250,
+		MonthlyInsurance: 100,
+		MonthlyUtilities: 150,
+		AgentCommission:  5,
+	}).AnnualizedROI, comparison.Flip.AnnualizedROI)
+	assert.Equal(t, "Low", comparison.Flip.RiskLevel)
+}
+
+func TestCompareStrategies_Rental(t *testing.T) {
+	service := NewArvService()
+	req := strategyDeal()
+
+	comparison := service.CompareStrategies(req)
+	brrrr := service.CalculateARV(req.ArvRequest)
+
+	// 25% down on 110,000, with the rehab and closing paid in cash
+	rental := comparison.Rental
+	assert.Equal(t, 27500.0, rental.DownPayment)
+	assert.Equal(t, 82500.0, rental.LoanAmount)
+	assert.Equal(t, 65500.0, rental.CashNeeded)
+	assert.Equal(t, 548.87, rental.MonthlyDebtService)
+	assert.InDelta(t, brrrr.NOI/12-548.87, rental.MonthlyCashFlow, 0.01)
+	assert.InDelta(t, rental.MonthlyCashFlow*12/65500*100, rental.CashOnCashReturn, 0.01)
+	assert.InDelta(t, brrrr.NOI/145000*100, rental.CapRate, 0.01)
+
+	req.DownPaymentPercent = 20
+	assert.Equal(t, 88000.0, service.CompareStrategies(req).Rental.LoanAmount)
+}
+
+func TestCompareStrategies_Recommendation(t *testing.T) {
+	service := NewArvService()
+
+	comparison := service.CompareStrategies(strategyDeal())
+
+	assert.Contains(t, []string{StrategyFlip, StrategyBRRRR, StrategyRental}, comparison.Recommended)
+	assert.NotEmpty(t, comparison.Recommendation)
+
+	recommended, text := recommendStrategy(StrategyComparison{
+		Flip:   FlipStrategy{RiskLevel: "Medium", AnnualizedROI: 40},
+		BRRRR:  BrrrrStrategy{RiskLevel: "Low", IsInfiniteReturn: true},
+		Rental: RentalStrategy{RiskLevel: "Low", CashOnCashReturn: 8},
+	})
+	assert.Equal(t, StrategyBRRRR, recommended)
+	assert.Equal(t, "BRRRR looks strongest: Low risk, and the refinance recovers all the cash invested", text)
+
+	recommended, text = recommendStrategy(StrategyComparison{
+		Flip:   FlipStrategy{RiskLevel: "Low", AnnualizedROI: 35.5},
+		BRRRR:  BrrrrStrategy{RiskLevel: "High", CashOnCashReturn: float64Ptr(12)},
+		Rental: RentalStrategy{RiskLevel: "Low", CashOnCashReturn: 9},
+	})
+	assert.Equal(t, StrategyFlip, recommended)
+	assert.Equal(t, "Flip looks strongest: Low risk with a 35.50% annualized ROI", text)
+}
+
+func float64Ptr(f float64) *float64 { return &f }
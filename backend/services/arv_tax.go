@@ -0,0 +1,104 @@
+package services
+
+import "arvfinder-backend/models"
+
+// residentialDepreciationYears is how long a residential rental building is
+// depreciated over
+const residentialDepreciationYears = 27.5
+
+// defaultLandValuePercent is the share of the depreciation basis assumed to
+// be land, which isn't depreciated, when a request doesn't say
+const defaultLandValuePercent = 20.0
+
+// taxDisclaimer is attached to every after-tax estimate
+const taxDisclaimer = "Estimate only, not tax advice. Depreciation is straight-line for a full year, and passive losses may be limited or carried forward - consult a tax professional."
+
+// Disclaimer labels a figure as an estimate the caller shouldn't rely on
+// as professional advice
+type Disclaimer struct {
+	Estimate     bool   `json:"estimate"`
+	NotTaxAdvice bool   `json:"not_tax_advice"`
+	Message      string `json:"message"`
+}
+
+// TaxAnalysis estimates a rental's first-year taxes. The depreciation basis
+// is the purchase price, rehab and closing costs, less the land. A negative
+// taxable income is a passive loss with no tax due; it isn't counted as a
+// tax saving since it may not be usable against other income.
+type TaxAnalysis struct {
+	DepreciationBasis  float64    `json:"depreciation_basis"`
+	AnnualDepreciation float64    `json:"annual_depreciation"`
+	MortgageInterest   float64    `json:"mortgage_interest"` // year one
+	TaxableIncome      float64    `json:"taxable_income"`
+	TaxDue             float64    `json:"tax_due"`
+	PassiveLoss        float64    `json:"passive_loss"`
+	IsPaperLoss        bool       `json:"is_paper_loss"` // positive cash flow, but a loss for taxes
+	AfterTaxCashFlow   float64    `json:"after_tax_cash_flow"`
+	AfterTaxCashOnCash *float64   `json:"after_tax_cash_on_cash"` // null when infinite
+	Disclaimer         Disclaimer `json:"disclaimer"`
+}
+
+// calculateTax estimates the first year's taxes on the rental in result at
+// req's marginal tax rate. It returns nil when req has no tax rate.
+func (s *ArvService) calculateTax(req ArvRequest, result *ArvResult) *TaxAnalysis {
+	if req.TaxRate == 0 {
+		return nil
+	}
+
+	landPercent := req.LandValuePercent
+	if landPercent == 0 {
+		landPercent = defaultLandValuePercent
+		result.Warnings = append(result.Warnings, Notice{
+			Code: WarnLandValueEstimated, Severity: SeverityInfo, Field: "land_value_percent",
+			Message: "Land value estimated at 20% of the depreciation basis",
+		})
+		result.assume("land_value_percent", landPercent, "Land at 20% of the depreciation basis")
+	}
+
+	tax := &TaxAnalysis{
+		DepreciationBasis: (req.PurchasePrice + req.RehabCost + req.ClosingCosts) * (1 - landPercent/100),
+		Disclaimer: Disclaimer{
+			Estimate:     true,
+			NotTaxAdvice: true,
+			Message:      taxDisclaimer,
+		},
+	}
+	tax.AnnualDepreciation = tax.DepreciationBasis / residentialDepreciationYears
+
+	// Year one's interest comes off the refinance loan's schedule
+	principal, payment := result.RefinanceAmount, result.MonthlyDebtService
+	if result.Mortgage != nil {
+		principal, payment = result.Mortgage.FinancedLoanAmount, result.Mortgage.PrincipalAndInterest
+	}
+	if payment > 0 {
+		schedule, _ := req.refinanceTerms().schedule(principal, roundCents(payment), prepayments{})
+		var interest models.Money
+		for month := 0; month < 12 && month < len(schedule); month++ {
+			interest += schedule[month].Interest
+		}
+		tax.MortgageInterest = interest.Float64()
+	}
+
+	tax.TaxableIncome = result.NOI - tax.MortgageInterest - tax.AnnualDepreciation
+	if tax.TaxableIncome > 0 {
+		tax.TaxDue = tax.TaxableIncome * req.TaxRate / 100
+	} else {
+		tax.PassiveLoss = -tax.TaxableIncome
+	}
+	tax.IsPaperLoss = result.AnnualCashFlow > 0 && tax.TaxableIncome < 0
+
+	tax.AfterTaxCashFlow = result.AnnualCashFlow - tax.TaxDue
+	tax.AfterTaxCashOnCash = cashOnCashReturn(tax.AfterTaxCashFlow, result.CashLeftIn)
+
+	tax.DepreciationBasis = roundCents(tax.DepreciationBasis)
+	tax.AnnualDepreciation = roundCents(tax.AnnualDepreciation)
+	tax.MortgageInterest = roundCents(tax.MortgageInterest)
+	tax.TaxableIncome = roundCents(tax.TaxableIncome)
+	tax.TaxDue = roundCents(tax.TaxDue)
+	tax.PassiveLoss = roundCents(tax.PassiveLoss)
+	tax.AfterTaxCashFlow = roundCents(tax.AfterTaxCashFlow)
+	if tax.AfterTaxCashOnCash != nil {
+		*tax.AfterTaxCashOnCash = roundCents(*tax.AfterTaxCashOnCash)
+	}
+	return tax
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func taxRequest(rent float64) ArvRequest {
+	return ArvRequest{
+		PurchasePrice: 140000,
+		RehabCost:     30000,
+		ClosingCosts:  3000,
+		ARV:           220000,
+		MonthlyRent:   rent,
+		VacancyRate:   5,
+		PropertyTaxes: 2400,
+		Insurance:     1200,
+		Maintenance:   1500,
+		CapEx:         1000,
+		InterestRate:  6.5,
+		TaxRate:       24,
+	}
+}
+
+func TestCalculateARV_DepreciationTurnsCashFlowIntoPaperLoss(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(taxRequest(1850))
+
+	// 14,990 of NOI less 12,514.95 of debt service on the 165,000 refinance
+	assert.Equal(t, 2475.05, result.AnnualCashFlow)
+	require.NotNil(t, result.Tax)
+	tax := result.Tax
+
+	// 173,000 less 20% land, over 27.5 years, plus the first year's
+	// interest, is more than the NOI
+	assert.Equal(t, 138400.0, tax.DepreciationBasis)
+	assert.Equal(t, 5032.73, tax.AnnualDepreciation)
+	assert.Equal(t, 10670.7, tax.MortgageInterest)
+	assert.Equal(t, -713.43, tax.TaxableIncome)
+	assert.Equal(t, 0.0, tax.TaxDue)
+	assert.Equal(t, 713.43, tax.PassiveLoss)
+	assert.True(t, tax.IsPaperLoss)
+	assert.Equal(t, 2475.05, tax.AfterTaxCashFlow)
+	assert.Equal(t, 30.94, *tax.AfterTaxCashOnCash) // on the 8,000 left in
+
+	assert.True(t, tax.Disclaimer.Estimate)
+	assert.True(t, tax.Disclaimer.NotTaxAdvice)
+	assert.Contains(t, tax.Disclaimer.Message, "not tax advice")
+	assert.Contains(t, NoticeMessages(result.Warnings), "Land value estimated at 20% of the depreciation basis")
+}
+
+func TestCalculateARV_TaxDueOnTaxableIncome(t *testing.T) {
+	service := NewArvService()
+	req := taxRequest(2600)
+	req.LandValuePercent = 20
+
+	result := service.CalculateARV(req)
+
+	require.NotNil(t, result.Tax)
+	assert.Equal(t, 7836.57, result.Tax.TaxableIncome)
+	assert.Equal(t, 1880.78, result.Tax.TaxDue) // 24%
+	assert.Equal(t, 0.0, result.Tax.PassiveLoss)
+	assert.False(t, result.Tax.IsPaperLoss)
+	assert.Equal(t, 9144.28, result.Tax.AfterTaxCashFlow)
+	assert.Equal(t, 114.3, *result.Tax.AfterTaxCashOnCash)
+	assert.NotContains(t, NoticeMessages(result.Warnings), "Land value estimated at 20% of the depreciation basis")
+}
+
+func TestCalculateARV_NoTaxRateSkipsTaxAnalysis(t *testing.T) {
+	service := NewArvService()
+	req := taxRequest(1850)
+	req.TaxRate = 0
+
+	assert.Nil(t, service.CalculateARV(req).Tax)
+}
@@ -1,9 +1,13 @@
 package services
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCalculateARV_Basic(t *testing.T) {
@@ -47,7 +51,7 @@ func TestCalculateARV_HighRehabCosts(t *testing.T) {
 	result := service.CalculateARV(req)
 
 	// Should flag high rehab costs in recommendations
-	assert.Contains(t, result.Recommendations, "Rehab costs are high (>30% of ARV) - verify estimates with contractors")
+	assert.Contains(t, NoticeMessages(result.Recommendations), "Rehab costs are high (>30% of ARV) - verify estimates with contractors")
 	assert.Equal(t, "Very High", result.RiskLevel)
 }
 
@@ -83,16 +87,26 @@ func TestCalculate70Rule(t *testing.T) {
 
 func TestCalculateROI_ZeroInvestment(t *testing.T) {
 	service := NewArvService()
-	roi := service.CalculateROI(10000, 0)
+	roi, err := service.CalculateROI(10000, 0)
+	assert.ErrorIs(t, err, ErrInvestmentNotPositive)
 	assert.Equal(t, 0.0, roi)
 }
 
 func TestCalculateROI_PositiveInvestment(t *testing.T) {
 	service := NewArvService()
-	roi := service.CalculateROI(25000, 100000)
+	roi, err := service.CalculateROI(25000, 100000)
+	require.NoError(t, err)
 	assert.Equal(t, 25.0, roi)
 }
 
+func TestCalculateROI_NotFinite(t *testing.T) {
+	service := NewArvService()
+	_, err := service.CalculateROI(math.NaN(), 100000)
+	assert.ErrorIs(t, err, ErrInputNotFinite)
+	_, err = service.CalculateROI(25000, math.Inf(1))
+	assert.ErrorIs(t, err, ErrInputNotFinite)
+}
+
 func TestEstimateARVFromComps(t *testing.T) {
 	service := NewArvService()
 	comps := []ComparableProperty{
@@ -145,32 +159,42 @@ func TestCalculateCashOnCashReturn(t *testing.T) {
 	service := NewArvService()
 
 	// Test normal case
-	cashReturn := service.CalculateCashOnCashReturn(12000, 100000)
+	cashReturn, err := service.CalculateCashOnCashReturn(12000, 100000)
+	require.NoError(t, err)
 	assert.Equal(t, 12.0, cashReturn)
 
 	// Test zero investment
-	cashReturn = service.CalculateCashOnCashReturn(12000, 0)
-	assert.Equal(t, 0.0, cashReturn)
+	_, err = service.CalculateCashOnCashReturn(12000, 0)
+	assert.ErrorIs(t, err, ErrInvestmentNotPositive)
 
 	// Test negative investment
-	cashReturn = service.CalculateCashOnCashReturn(12000, -100000)
-	assert.Equal(t, 0.0, cashReturn)
+	_, err = service.CalculateCashOnCashReturn(12000, -100000)
+	assert.ErrorIs(t, err, ErrInvestmentNotPositive)
+
+	// Test infinite cash flow
+	_, err = service.CalculateCashOnCashReturn(math.Inf(-1), 100000)
+	assert.ErrorIs(t, err, ErrInputNotFinite)
 }
 
 func TestCalculateCapRate(t *testing.T) {
 	service := NewArvService()
 
 	// Test normal case
-	capRate := service.CalculateCapRate(15000, 200000)
+	capRate, err := service.CalculateCapRate(15000, 200000)
+	require.NoError(t, err)
 	assert.Equal(t, 7.5, capRate)
 
 	// Test zero property value
-	capRate = service.CalculateCapRate(15000, 0)
-	assert.Equal(t, 0.0, capRate)
+	_, err = service.CalculateCapRate(15000, 0)
+	assert.ErrorIs(t, err, ErrPropertyValueNotPositive)
 
 	// Test negative property value
-	capRate = service.CalculateCapRate(15000, -200000)
-	assert.Equal(t, 0.0, capRate)
+	_, err = service.CalculateCapRate(15000, -200000)
+	assert.ErrorIs(t, err, ErrPropertyValueNotPositive)
+
+	// Test NaN NOI
+	_, err = service.CalculateCapRate(math.NaN(), 200000)
+	assert.ErrorIs(t, err, ErrInputNotFinite)
 }
 
 func TestCalculateARV_EdgeCases(t *testing.T) {
@@ -228,3 +252,352 @@ func TestBRRRRStrategy(t *testing.T) {
 	assert.Equal(t, 70000.0, result.BrrrrMaxOffer)
 	assert.Equal(t, 24000.0, result.BrrrrProfit) // Same as potential profit in this case
 }
+
+func TestCalculateARV_HardMoneyInterestOnly(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice:         100000,
+		RehabCost:             30000,
+		ClosingCosts:          3000,
+		ARV:                   200000,
+		HardMoneyLTC:          90,
+		HardMoneyPoints:       2,
+		HardMoneyRate:         12,
+		HardMoneyInterestOnly: true,
+		HardMoneyTermMonths:   6,
+	})
+
+	// 90% of 130,000 at 2 points and 1% a month for 6 months
+	loan := result.HardMoney
+	if assert.NotNil(t, loan) {
+		assert.Equal(t, 117000.0, loan.LoanAmount)
+		assert.Equal(t, 2340.0, loan.OriginationFee)
+		assert.Equal(t, 1170.0, loan.MonthlyPayment)
+		assert.Equal(t, 7020.0, loan.Interest)
+		assert.Equal(t, 9360.0, loan.TotalCost)
+		assert.Equal(t, 117000.0, loan.Payoff)
+		assert.Equal(t, 18340.0, loan.CashToClose) // 13,000 down, 3,000 closing, 2,340 points
+	}
+	assert.Equal(t, 142360.0, result.TotalInvestment)
+
+	// The 150,000 refinance pays off the 117,000 loan, returning all 25,360
+	// of the investor's cash
+	assert.Equal(t, 150000.0, result.RefinanceAmount)
+	assert.Equal(t, 25360.0, result.CashRecovered)
+	assert.Equal(t, 0.0, result.CashLeftIn)
+}
+
+func TestCalculateARV_HardMoneyAmortizing(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice:       100000,
+		ARV:                 150000,
+		HardMoneyLTC:        80,
+		HardMoneyRate:       12,
+		HardMoneyTermMonths: 12,
+	})
+
+	// 80,000 on a 30-year schedule pays 822.89 a month, paying the balance
+	// down to 79,684.19 after a year
+	loan := result.HardMoney
+	if assert.NotNil(t, loan) {
+		assert.Equal(t, 822.89, loan.MonthlyPayment)
+		assert.Equal(t, 79709.7, loan.Payoff)
+		assert.Equal(t, 9584.38, loan.Interest)
+		assert.Equal(t, 0.0, loan.OriginationFee)
+	}
+	assert.Equal(t, 109584.38, result.TotalInvestment)
+	assert.Equal(t, 0.0, result.CashLeftIn)
+	assert.Equal(t, 29874.68, result.CashRecovered)
+}
+
+func TestCalculateARV_HardMoneyDefaultTerm(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice:         100000,
+		ARV:                   150000,
+		HardMoneyLTC:          80,
+		HardMoneyRate:         12,
+		HardMoneyInterestOnly: true,
+	})
+
+	assert.Equal(t, 6, result.HardMoney.TermMonths)
+	assert.Equal(t, 4800.0, result.HardMoney.Interest)
+	assert.Contains(t, NoticeMessages(result.Warnings), "Using default hard money term of 6 months")
+}
+
+func TestCalculateARV_WithoutHardMoneyUnchanged(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice:  80000,
+		RehabCost:      30000,
+		ClosingCosts:   2000,
+		ARV:            130000,
+		FinancingCosts: 3000,
+	})
+
+	assert.Nil(t, result.HardMoney)
+	assert.Equal(t, 115000.0, result.TotalInvestment)
+	assert.Equal(t, 97500.0, result.CashRecovered)
+	assert.Equal(t, 17500.0, result.CashLeftIn)
+}
+
+func TestCalculateARV_RulePercentage(t *testing.T) {
+	service := NewArvService()
+	req := ArvRequest{
+		PurchasePrice: 100000,
+		RehabCost:     30000,
+		ClosingCosts:  3000,
+		ARV:           190000,
+	}
+
+	// 70% of 190,000 less 30,000 is 103,000, so the default rule passes
+	result := service.CalculateARV(req)
+	assert.Equal(t, 70.0, result.RulePercentage)
+	assert.Equal(t, 103000.0, result.MaxOffer70)
+	assert.True(t, result.Is70RuleGood)
+
+	// A rural 65% rule allows only 93,500
+	req.RulePercentage = 65
+	result = service.CalculateARV(req)
+	assert.Equal(t, 65.0, result.RulePercentage)
+	assert.Equal(t, 93500.0, result.MaxOffer70)
+	assert.False(t, result.Is70RuleGood)
+	assert.Contains(t, NoticeMessages(result.Recommendations), "Property does not meet the 65% rule - consider negotiating a lower purchase price")
+
+	enhanced := service.CalculateEnhancedBRRRR(req)
+	assert.Contains(t, NoticeMessages(enhanced.Recommendations), "Property fails 65% rule - higher risk flip/BRRRR deal")
+
+	// A hot market's 80% rule allows 122,000
+	req.RulePercentage = 80
+	req.PurchasePrice = 120000
+	result = service.CalculateARV(req)
+	assert.Equal(t, 122000.0, result.MaxOffer70)
+	assert.True(t, result.Is70RuleGood)
+	for _, recommendation := range result.Recommendations {
+		assert.NotContains(t, recommendation.Message, "% rule")
+	}
+}
+
+func TestCalculateRule(t *testing.T) {
+	service := NewArvService()
+
+	assert.Equal(t, 45000.0, service.CalculateRule(100000, 20000, 65))
+	assert.Equal(t, 60000.0, service.CalculateRule(100000, 20000, 80))
+	assert.Equal(t, service.Calculate70Rule(100000, 20000), service.CalculateRule(100000, 20000, 70))
+}
+
+func TestCalculateARV_BreakEvenTightDeal(t *testing.T) {
+	service := NewArvService()
+	req := ArvRequest{
+		PurchasePrice: 130000,
+		RehabCost:     20000,
+		ARV:           200000,
+		MonthlyRent:   1650,
+		VacancyRate:   5,
+		PropertyTaxes: 2400,
+		Insurance:     1200,
+		Maintenance:   1800,
+		CapEx:         900,
+		InterestRate:  7,
+		LoanTerm:      30,
+	}
+
+	result := service.CalculateARV(req)
+
+	// 6,300 of expenses and 11,975 of debt service against 19,800 of rent
+	assert.Equal(t, 997.95, result.MonthlyDebtService)
+	assert.Equal(t, 92.3, result.BreakEvenOccupancy)
+	assert.False(t, result.BreakEvenOccupancyImpossible)
+	assert.Equal(t, 1603.11, result.BreakEvenRent)
+	assert.False(t, result.BreakEvenRentImpossible)
+	assert.Contains(t, NoticeMessages(result.Recommendations),
+		"Break-even occupancy of 92.3% leaves little room for vacancy - lenders typically look for 85% or less")
+
+	enhanced := service.CalculateEnhancedBRRRR(req)
+	assert.Contains(t, NoticeMessages(enhanced.Recommendations),
+		"Break-even occupancy of 92.3% leaves little room for vacancy - lenders typically look for 85% or less")
+
+	// At the break-even rent, cash flow is zero
+	req.MonthlyRent = result.BreakEvenRent
+	assert.InDelta(t, 0, service.CalculateARV(req).MonthlyCashFlow, 0.01)
+}
+
+func TestCalculateARV_BreakEvenImpossible(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice: 130000,
+		ARV:           200000,
+		MonthlyRent:   900,
+		VacancyRate:   5,
+		PropertyTaxes: 6000,
+		Insurance:     2400,
+		Maintenance:   2400,
+		CapEx:         1200,
+	})
+
+	// Expenses alone exceed the 10,800 of gross rent
+	assert.Equal(t, 100.0, result.BreakEvenOccupancy)
+	assert.True(t, result.BreakEvenOccupancyImpossible)
+	assert.False(t, result.BreakEvenRentImpossible)
+	assert.Greater(t, result.BreakEvenRent, 900.0)
+	assert.Contains(t, NoticeMessages(result.Recommendations), "CRITICAL: Expenses and debt service exceed gross rent - no occupancy breaks even")
+}
+
+func TestCalculateARV_BreakEvenComfortable(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice: 60000,
+		RehabCost:     20000,
+		ARV:           100000,
+		MonthlyRent:   1500,
+		VacancyRate:   5,
+		PropertyTaxes: 1500,
+		Insurance:     600,
+		Maintenance:   1200,
+		CapEx:         600,
+		InterestRate:  7,
+	})
+
+	assert.Less(t, result.BreakEvenOccupancy, 85.0)
+	for _, recommendation := range result.Recommendations {
+		assert.NotContains(t, recommendation.Message, "Break-even occupancy")
+	}
+}
+
+func TestCalculateARV_InfiniteReturn(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice: 50000,
+		RehabCost:     10000,
+		ARV:           150000,
+		MonthlyRent:   2000,
+		VacancyRate:   8,
+		PropertyTaxes: 2250,
+		Insurance:     750,
+		Maintenance:   2400,
+		CapEx:         1200,
+	})
+
+	// The 112,500 refinance recovers all 60,000 and the rent covers the loan
+	assert.Zero(t, result.CashLeftIn)
+	assert.True(t, result.IsCashFlowPositive)
+	assert.True(t, result.IsInfiniteReturn)
+	assert.Nil(t, result.CashOnCashReturn)
+	assert.Equal(t, "∞", result.CashOnCashDisplay)
+
+	for _, component := range result.DealScore.Components {
+		if component.Name == DealScoreCashOnCash {
+			assert.Nil(t, component.Value)
+			assert.Equal(t, 100.0, component.Score)
+		}
+	}
+
+	data, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"cash_on_cash_return":null`)
+	assert.Contains(t, string(data), `"is_infinite_return":true`)
+}
+
+func TestCalculateARV_CashOnCashDisplay(t *testing.T) {
+	service := NewArvService()
+
+	result := service.CalculateARV(ArvRequest{
+		PurchasePrice: 100000,
+		RehabCost:     30000,
+		ARV:           150000,
+		MonthlyRent:   1800,
+	})
+
+	require.NotNil(t, result.CashOnCashReturn)
+	assert.False(t, result.IsInfiniteReturn)
+	assert.Equal(t, fmt.Sprintf("%.2f%%", *result.CashOnCashReturn), result.CashOnCashDisplay)
+}
+
+func propertyMgmtDeal() ArvRequest {
+	return ArvRequest{
+		PurchasePrice: 100000,
+		ARV:           160000,
+		MonthlyRent:   1500,
+		VacancyRate:   5,
+		PropertyTaxes: 1800,
+		Insurance:     900,
+		Maintenance:   1200,
+		CapEx:         600,
+	}
+}
+
+func TestCalculateARV_PropertyMgmt(t *testing.T) {
+	service := NewArvService()
+
+	// A $600 flat fee is $600, not 600% of rent
+	req := propertyMgmtDeal()
+	req.PropertyMgmtAnnual = 600
+	result := service.CalculateARV(req)
+	assert.Equal(t, 5100.0, result.AnnualExpenses)
+	assert.True(t, result.IsCashFlowPositive)
+
+	req = propertyMgmtDeal()
+	req.PropertyMgmtPercent = 8
+	assert.Equal(t, 5940.0, service.CalculateARV(req).AnnualExpenses) // 8% of 18,000 on top of 4,500
+
+	req.PropertyMgmtAnnual = 600
+	assert.Equal(t, 6540.0, service.CalculateARV(req).AnnualExpenses)
+}
+
+func TestCalculateARV_DeprecatedPropertyMgmt(t *testing.T) {
+	service := NewArvService()
+
+	req := propertyMgmtDeal()
+	req.PropertyMgmt = 600
+	result := service.CalculateARV(req)
+	assert.Equal(t, 5100.0, result.AnnualExpenses)
+	assert.Contains(t, NoticeMessages(result.Warnings), "property_mgmt is deprecated - read as a $600.00 annual fee; use property_mgmt_annual")
+
+	req.PropertyMgmt = 8
+	result = service.CalculateARV(req)
+	assert.Equal(t, 5940.0, result.AnnualExpenses)
+	assert.Contains(t, NoticeMessages(result.Warnings), "property_mgmt is deprecated - read as 8% of rent; use property_mgmt_percent")
+
+	// The explicit fields win
+	req.PropertyMgmtAnnual = 1200
+	result = service.CalculateARV(req)
+	assert.Equal(t, 5700.0, result.AnnualExpenses)
+	for _, warning := range result.Warnings {
+		if warning.Code == WarnPropertyMgmtDeprecated {
+			assert.Equal(t, "property_mgmt", warning.Field)
+		}
+	}
+}
+
+func TestCalculateARV_VacancyAndCreditLoss(t *testing.T) {
+	service := NewArvService()
+	req := ArvRequest{
+		PurchasePrice: 150000, RehabCost: 30000, ARV: 250000,
+		MonthlyRent: 2000, VacancyRate: 10,
+		PropertyTaxes: 3000, Insurance: 1200, Maintenance: 1200, CapEx: 1200,
+		InterestRate: 7,
+	}
+
+	// A lone vacancy rate works as it always has
+	result := service.CalculateARV(req)
+	assert.Equal(t, 2400.0, result.VacancyLoss)
+	assert.Zero(t, result.CreditLoss)
+	assert.Equal(t, 21600.0, result.EffectiveIncome)
+
+	// Credit loss comes off the rent billed to the tenants who are there
+	req.CreditLossRate = 2
+	result = service.CalculateARV(req)
+	assert.Equal(t, 2.0, result.CreditLossRate)
+	assert.Equal(t, 2400.0, result.VacancyLoss)
+	assert.Equal(t, 432.0, result.CreditLoss)
+	assert.Equal(t, 21168.0, result.EffectiveIncome)
+	assert.Equal(t, 14568.0, result.NOI)
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+)
+
+// Wholesale calculator errors
+var (
+	ErrInvalidWholesaleSolve = errors.New("solve_for must be offer or fee")
+	ErrSellerPriceRequired   = errors.New("seller_price is required to solve for the assignment fee")
+)
+
+// What a wholesale calculation solves for
+const (
+	WholesaleSolveOffer = "offer" // the most to offer the seller for a desired fee
+	WholesaleSolveFee   = "fee"   // the fee a fixed seller price leaves room for
+)
+
+// defaultBuyerRulePercent is the end buyer's rule when the request doesn't
+// give one: the 70% rule
+const defaultBuyerRulePercent = 70.0
+
+// WholesaleRequest is the input for a wholesale deal. The end buyer pays at
+// most BuyerRulePercent of the ARV less the rehab, and that has to cover
+// the seller's price, the assignment fee and any double-close costs.
+type WholesaleRequest struct {
+	ARV              float64 `json:"arv" binding:"required,min=1"`
+	RehabCost        float64 `json:"rehab_cost" binding:"min=0"`
+	BuyerRulePercent float64 `json:"buyer_rule_percent" binding:"min=0,max=100"` // default 70
+	AssignmentFee    float64 `json:"assignment_fee" binding:"min=0"`
+	DoubleCloseCosts float64 `json:"double_close_costs" binding:"min=0"`
+	SolveFor         string  `json:"solve_for"`                    // offer (default) or fee
+	SellerPrice      float64 `json:"seller_price" binding:"min=0"` // needed to solve for the fee
+}
+
+// WholesaleResult is a wholesale deal's prices. Spread is what the
+// wholesaler keeps between the buyer's and seller's prices before
+// double-close costs.
+type WholesaleResult struct {
+	SolveFor         string   `json:"solve_for"`
+	BuyerRulePercent float64  `json:"buyer_rule_percent"`
+	BuyerMaxPrice    float64  `json:"buyer_max_price"`
+	MaxOffer         float64  `json:"max_offer"` // to the seller
+	BuyerPrice       float64  `json:"buyer_price"`
+	AssignmentFee    float64  `json:"assignment_fee"`
+	DoubleCloseCosts float64  `json:"double_close_costs"`
+	Spread           float64  `json:"spread"`
+	BuyerMeetsRule   bool     `json:"buyer_meets_rule"`
+	Warnings         []string `json:"warnings"`
+}
+
+// CalculateWholesale works out either the most a wholesaler can offer the
+// seller for a desired assignment fee, or the fee a fixed seller price
+// leaves once the end buyer's rule is met
+func (s *ArvService) CalculateWholesale(req WholesaleRequest) (*WholesaleResult, error) {
+	if req.SolveFor == "" {
+		req.SolveFor = WholesaleSolveOffer
+	}
+	if req.SolveFor != WholesaleSolveOffer && req.SolveFor != WholesaleSolveFee {
+		return nil, ErrInvalidWholesaleSolve
+	}
+	if req.SolveFor == WholesaleSolveFee && req.SellerPrice == 0 {
+		return nil, ErrSellerPriceRequired
+	}
+	if req.BuyerRulePercent == 0 {
+		req.BuyerRulePercent = defaultBuyerRulePercent
+	}
+
+	result := &WholesaleResult{
+		SolveFor:         req.SolveFor,
+		BuyerRulePercent: req.BuyerRulePercent,
+		BuyerMaxPrice:    req.ARV*req.BuyerRulePercent/100 - req.RehabCost,
+		DoubleCloseCosts: req.DoubleCloseCosts,
+		Warnings:         []string{},
+	}
+
+	switch req.SolveFor {
+	case WholesaleSolveOffer:
+		result.AssignmentFee = req.AssignmentFee
+		result.MaxOffer = result.BuyerMaxPrice - req.AssignmentFee - req.DoubleCloseCosts
+		if result.MaxOffer <= 0 {
+			result.Warnings = append(result.Warnings, "WARNING: No positive offer leaves room for the assignment fee")
+		}
+	case WholesaleSolveFee:
+		result.MaxOffer = req.SellerPrice
+		result.AssignmentFee = result.BuyerMaxPrice - req.SellerPrice - req.DoubleCloseCosts
+		if result.AssignmentFee < 0 {
+			result.AssignmentFee = 0
+			result.Warnings = append(result.Warnings, "WARNING: Seller price leaves no assignment fee within the buyer's rule")
+		} else if req.AssignmentFee > 0 && result.AssignmentFee < req.AssignmentFee {
+			result.Warnings = append(result.Warnings, "Assignment fee is below the desired fee")
+		}
+	}
+
+	result.BuyerPrice = result.MaxOffer + result.AssignmentFee + req.DoubleCloseCosts
+	result.Spread = result.BuyerPrice - result.MaxOffer
+	result.BuyerMeetsRule = result.MaxOffer > 0 && result.BuyerPrice <= result.BuyerMaxPrice+0.005
+
+	result.BuyerMaxPrice = roundCents(result.BuyerMaxPrice)
+	result.MaxOffer = roundCents(result.MaxOffer)
+	result.BuyerPrice = roundCents(result.BuyerPrice)
+	result.AssignmentFee = roundCents(result.AssignmentFee)
+	result.Spread = roundCents(result.Spread)
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateWholesale_SolveForOffer(t *testing.T) {
+	service := NewArvService()
+
+	result, err := service.CalculateWholesale(WholesaleRequest{
+		ARV:              200000,
+		RehabCost:        40000,
+		AssignmentFee:    10000,
+		DoubleCloseCosts: 3000,
+	})
+	require.NoError(t, err)
+
+	// The 70% rule caps the buyer at 140,000 - 40,000
+	assert.Equal(t, WholesaleSolveOffer, result.SolveFor)
+	assert.Equal(t, 70.0, result.BuyerRulePercent)
+	assert.Equal(t, 100000.0, result.BuyerMaxPrice)
+	assert.Equal(t, 87000.0, result.MaxOffer)
+	assert.Equal(t, 100000.0, result.BuyerPrice)
+	assert.Equal(t, 13000.0, result.Spread)
+	assert.True(t, result.BuyerMeetsRule)
+	assert.Empty(t, result.Warnings)
+}
+
+func TestCalculateWholesale_SolveForOfferCustomRule(t *testing.T) {
+	service := NewArvService()
+
+	result, err := service.CalculateWholesale(WholesaleRequest{
+		ARV:              150000,
+		RehabCost:        25000,
+		BuyerRulePercent: 75,
+		AssignmentFee:    7500,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 87500.0, result.BuyerMaxPrice)
+	assert.Equal(t, 80000.0, result.MaxOffer)
+	assert.Equal(t, 7500.0, result.Spread)
+	assert.True(t, result.BuyerMeetsRule)
+}
+
+func TestCalculateWholesale_NoPositiveOffer(t *testing.T) {
+	service := NewArvService()
+
+	result, err := service.CalculateWholesale(WholesaleRequest{
+		ARV:           100000,
+		RehabCost:     65000,
+		AssignmentFee: 10000,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, -5000.0, result.MaxOffer)
+	assert.False(t, result.BuyerMeetsRule)
+	assert.Contains(t, result.Warnings, "WARNING: No positive offer leaves room for the assignment fee")
+}
+
+func TestCalculateWholesale_SolveForFee(t *testing.T) {
+	service := NewArvService()
+
+	result, err := service.CalculateWholesale(WholesaleRequest{
+		ARV:              200000,
+		RehabCost:        40000,
+		DoubleCloseCosts: 3000,
+		SolveFor:         WholesaleSolveFee,
+		SellerPrice:      85000,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 85000.0, result.MaxOffer)
+	assert.Equal(t, 12000.0, result.AssignmentFee)
+	assert.Equal(t, 100000.0, result.BuyerPrice)
+	assert.Equal(t, 15000.0, result.Spread)
+	assert.True(t, result.BuyerMeetsRule)
+
+	// Solving for the offer with that fee gets back to the seller's price
+	offer, err := service.CalculateWholesale(WholesaleRequest{
+		ARV:              200000,
+		RehabCost:        40000,
+		DoubleCloseCosts: 3000,
+		AssignmentFee:    result.AssignmentFee,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 85000.0, offer.MaxOffer)
+}
+
+func TestCalculateWholesale_SellerPriceTooHigh(t *testing.T) {
+	service := NewArvService()
+
+	result, err := service.CalculateWholesale(WholesaleRequest{
+		ARV:         200000,
+		RehabCost:   40000,
+		SolveFor:    WholesaleSolveFee,
+		SellerPrice: 105000,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 0.0, result.AssignmentFee)
+	assert.Equal(t, 105000.0, result.BuyerPrice)
+	assert.False(t, result.BuyerMeetsRule)
+	assert.Contains(t, result.Warnings, "WARNING: Seller price leaves no assignment fee within the buyer's rule")
+}
+
+func TestCalculateWholesale_FeeBelowDesired(t *testing.T) {
+	service := NewArvService()
+
+	result, err := service.CalculateWholesale(WholesaleRequest{
+		ARV:           200000,
+		RehabCost:     40000,
+		AssignmentFee: 15000,
+		SolveFor:      WholesaleSolveFee,
+		SellerPrice:   92000,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 8000.0, result.AssignmentFee)
+	assert.True(t, result.BuyerMeetsRule)
+	assert.Contains(t, result.Warnings, "Assignment fee is below the desired fee")
+}
+
+func TestCalculateWholesale_InvalidRequests(t *testing.T) {
+	service := NewArvService()
+
+	_, err := service.CalculateWholesale(WholesaleRequest{ARV: 200000, SolveFor: "profit"})
+	assert.ErrorIs(t, err, ErrInvalidWholesaleSolve)
+
+	_, err = service.CalculateWholesale(WholesaleRequest{ARV: 200000, SolveFor: WholesaleSolveFee})
+	assert.ErrorIs(t, err, ErrSellerPriceRequired)
+}
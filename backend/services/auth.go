@@ -2,11 +2,17 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,10 +24,16 @@ import (
 // AuthService handles user authentication with extreme security measures
 type AuthService struct {
 	db             *sql.DB
-	jwtSecret      []byte
+	jwtKeys        []JWTKey      // Newest first; see SetJWTKeys
 	argon2Params   *Argon2Params
 	tokenDuration  time.Duration
 	refreshDuration time.Duration
+	rememberMeDuration time.Duration // Refresh token lifetime when the user asks to stay signed in
+	challengeDuration time.Duration
+	maxSessions    int           // Per user, unless the tenant's plan allows more
+	passwordHistory int          // Recent passwords, including the current one, that can't be reused
+	sessionCache   *sessionCache // nil unless EnableSessionCache is called
+	loginAlerts    *loginAlerter // nil unless EnableLoginAlerts is called
 }
 
 // Argon2Params defines parameters for Argon2 password hashing
@@ -46,6 +58,7 @@ type User struct {
 	Role                  string     `json:"role"`
 	IsActive              bool       `json:"is_active"`
 	TwoFactorEnabled      bool       `json:"two_factor_enabled"`
+	TwoFactorMethod       string     `json:"two_factor_method,omitempty"` // "sms" or "totp"
 	LastLoginAt           *time.Time `json:"last_login_at,omitempty"`
 	FailedLoginAttempts   int        `json:"failed_login_attempts"`
 	LockedUntil           *time.Time `json:"locked_until,omitempty"`
@@ -61,23 +74,39 @@ type TokenPair struct {
 	TokenType    string    `json:"token_type"`
 }
 
+// Session is an active login as shown to its owner
+type Session struct {
+	ID                string    `json:"id"`
+	DeviceInfo        string    `json:"device_info"`
+	DeviceFingerprint string    `json:"device_fingerprint"`
+	IPAddress         string    `json:"ip_address"`
+	CreatedAt         time.Time `json:"created_at"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	RememberMe        bool      `json:"remember_me"`
+	Current           bool      `json:"current"`
+}
+
 // LoginRequest represents a login request
 type LoginRequest struct {
-	Email       string `json:"email" binding:"required,email"`
-	Password    string `json:"password" binding:"required,min=8"`
-	RememberMe  bool   `json:"remember_me"`
-	DeviceInfo  string `json:"device_info,omitempty"`
-	IPAddress   string `json:"ip_address,omitempty"`
+	Email              string `json:"email" binding:"required,email"`
+	Password           string `json:"password" binding:"required,min=8"`
+	RememberMe         bool   `json:"remember_me"`
+	TrustedDeviceToken string `json:"trusted_device_token,omitempty"` // From a previous Verify2FA
+	Method             string `json:"method,omitempty"`               // 2FA code delivery: "sms" (default) or "email"
+	CaptchaToken       string `json:"captcha_token,omitempty"`        // Required after repeated failures when CAPTCHA is configured
+	DeviceInfo         string `json:"device_info,omitempty"`
+	IPAddress          string `json:"ip_address,omitempty"`
 }
 
 // RegisterRequest represents a registration request
 type RegisterRequest struct {
-	Email       string `json:"email" binding:"required,email"`
-	Password    string `json:"password" binding:"required,min=8"`
-	FirstName   string `json:"first_name" binding:"required,min=1,max=100"`
-	LastName    string `json:"last_name" binding:"required,min=1,max=100"`
-	PhoneNumber string `json:"phone_number,omitempty"`
-	TenantName  string `json:"tenant_name,omitempty"`
+	Email        string `json:"email" binding:"required,email"`
+	Password     string `json:"password" binding:"required,min=8"`
+	FirstName    string `json:"first_name" binding:"required,min=1,max=100"`
+	LastName     string `json:"last_name" binding:"required,min=1,max=100"`
+	PhoneNumber  string `json:"phone_number,omitempty"`
+	TenantName   string `json:"tenant_name,omitempty"`
+	CaptchaToken string `json:"captcha_token,omitempty"` // Required when CAPTCHA is configured
 }
 
 // JWTClaims represents JWT token claims
@@ -88,27 +117,88 @@ type JWTClaims struct {
 	Role         string `json:"role"`
 	SessionID    string `json:"session_id"`
 	DeviceFingerprint string `json:"device_fingerprint,omitempty"`
+	// Set on tokens a support user gets by impersonating UserID
+	Impersonation  bool   `json:"impersonation,omitempty"`
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// JWTSecretFromEnv returns JWT_SECRET, falling back to a development default
+func JWTSecretFromEnv() string {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		jwtSecret = "your-super-secret-jwt-key-change-in-production" // Default for development
+	}
+	return jwtSecret
+}
+
 // NewAuthService creates a new authentication service
 func NewAuthService(db *sql.DB, jwtSecret string) *AuthService {
-	// Production-grade Argon2 parameters
-	argon2Params := &Argon2Params{
+	return NewAuthServiceWithArgon2(db, jwtSecret, DefaultArgon2Params())
+}
+
+// NewAuthServiceWithArgon2 creates an auth service that hashes new passwords
+// with params. Existing hashes keep verifying with the params encoded in them
+// and are upgraded on the user's next login.
+func NewAuthServiceWithArgon2(db *sql.DB, jwtSecret string, params *Argon2Params) *AuthService {
+	return &AuthService{
+		db:             db,
+		jwtKeys:        []JWTKey{{Secret: []byte(jwtSecret)}},
+		argon2Params:   params,
+		tokenDuration:  15 * time.Minute,  // Access token: 15 minutes
+		refreshDuration: 7 * 24 * time.Hour, // Refresh token: 7 days
+		rememberMeDuration: 30 * 24 * time.Hour, // Refresh token with remember me: 30 days
+		challengeDuration: 10 * time.Minute, // 2FA temp token: 10 minutes
+		maxSessions:    5,
+		passwordHistory: defaultPasswordHistory,
+	}
+}
+
+// SetMaxSessionsPerUser changes how many active sessions a user may hold
+// before the oldest is signed out. Plans with their own MaxSessions keep it.
+func (a *AuthService) SetMaxSessionsPerUser(max int) {
+	a.maxSessions = max
+}
+
+// DefaultArgon2Params returns the production-grade Argon2 parameters
+func DefaultArgon2Params() *Argon2Params {
+	return &Argon2Params{
 		Memory:      128 * 1024, // 128 MB
 		Iterations:  4,          // 4 iterations
 		Parallelism: 4,          // 4 threads
 		SaltLength:  32,         // 32 bytes salt
 		KeyLength:   64,         // 64 bytes key
 	}
+}
 
-	return &AuthService{
-		db:             db,
-		jwtSecret:      []byte(jwtSecret),
-		argon2Params:   argon2Params,
-		tokenDuration:  15 * time.Minute,  // Access token: 15 minutes
-		refreshDuration: 7 * 24 * time.Hour, // Refresh token: 7 days
+// Argon2ParamsFromEnv starts from DefaultArgon2Params and applies
+// ARGON2_MEMORY_KB, ARGON2_ITERATIONS and ARGON2_PARALLELISM when set
+func Argon2ParamsFromEnv() (*Argon2Params, error) {
+	params := DefaultArgon2Params()
+
+	if value := os.Getenv("ARGON2_MEMORY_KB"); value != "" {
+		memory, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || memory < 8*1024 {
+			return nil, fmt.Errorf("ARGON2_MEMORY_KB must be a number of at least 8192, got %q", value)
+		}
+		params.Memory = uint32(memory)
+	}
+	if value := os.Getenv("ARGON2_ITERATIONS"); value != "" {
+		iterations, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || iterations < 1 {
+			return nil, fmt.Errorf("ARGON2_ITERATIONS must be a positive number, got %q", value)
+		}
+		params.Iterations = uint32(iterations)
+	}
+	if value := os.Getenv("ARGON2_PARALLELISM"); value != "" {
+		parallelism, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || parallelism < 1 {
+			return nil, fmt.Errorf("ARGON2_PARALLELISM must be between 1 and 255, got %q", value)
+		}
+		params.Parallelism = uint8(parallelism)
 	}
+
+	return params, nil
 }
 
 // GenerateSecureSalt generates a cryptographically secure random salt
@@ -141,39 +231,200 @@ func (a *AuthService) HashPassword(password string, salt []byte) string {
 
 // VerifyPassword verifies a password against its hash using constant-time comparison
 func (a *AuthService) VerifyPassword(password, hashedPassword string) bool {
-	// Parse the hash format
-	parts := strings.Split(hashedPassword, "$")
-	if len(parts) != 6 || parts[1] != "argon2id" {
-		return false
-	}
-
-	// Extract salt and hash
-	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
-	if err != nil {
-		return false
-	}
-	
-	expectedHash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	params, salt, expectedHash, err := parseArgon2Hash(hashedPassword)
 	if err != nil {
 		return false
 	}
 
-	// Compute hash of provided password
+	// Compute hash of provided password with the params it was stored with,
+	// so raising the target params doesn't lock anyone out
 	actualHash := argon2.IDKey(
 		[]byte(password),
 		salt,
-		a.argon2Params.Iterations,
-		a.argon2Params.Memory,
-		a.argon2Params.Parallelism,
-		a.argon2Params.KeyLength,
+		params.Iterations,
+		params.Memory,
+		params.Parallelism,
+		params.KeyLength,
 	)
 
 	// Use constant-time comparison to prevent timing attacks
 	return subtle.ConstantTimeCompare(expectedHash, actualHash) == 1
 }
 
-// GenerateTokenPair creates a new access/refresh token pair
-func (a *AuthService) GenerateTokenPair(user *User, deviceInfo, ipAddress string) (*TokenPair, error) {
+// NeedsRehash reports whether hashedPassword was made with params other than
+// the current target and should be replaced after a successful login
+func (a *AuthService) NeedsRehash(hashedPassword string) bool {
+	params, _, _, err := parseArgon2Hash(hashedPassword)
+	if err != nil {
+		return true
+	}
+	return *params != *a.argon2Params
+}
+
+// RehashPassword stores a fresh hash of password made with the current params.
+// It only replaces oldHash, so a password changed in the meantime is kept.
+func (a *AuthService) RehashPassword(userID, password, oldHash string) error {
+	salt, err := a.GenerateSecureSalt()
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec(`
+		UPDATE users SET password_hash = $2, updated_at = NOW()
+		WHERE id = $1 AND password_hash = $3
+	`, userID, a.HashPassword(password, salt), oldHash)
+	if err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
+	}
+	return nil
+}
+
+// parseArgon2Hash splits an encoded hash into its params, salt and key
+func parseArgon2Hash(encoded string) (*Argon2Params, []byte, []byte, error) {
+	// Format: $argon2id$v=19$m=...,t=...,p=...$salt$hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != "v=19" {
+		return nil, nil, nil, errors.New("unsupported password hash format")
+	}
+
+	params := &Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid password hash params: %w", err)
+	}
+	if params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, nil, nil, errors.New("invalid password hash params")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid password hash salt: %w", err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(hash) == 0 {
+		return nil, nil, nil, errors.New("invalid password hash key")
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(hash))
+	return params, salt, hash, nil
+}
+
+// Refresh token errors returned by RefreshTokens
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+)
+
+// ErrSessionNotFound is returned when a session doesn't exist or belongs to
+// another user
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrInvalidTwoFactorChallenge is returned when a 2FA temp token is unknown,
+// expired, already used, or issued to a different user
+var ErrInvalidTwoFactorChallenge = errors.New("invalid or expired 2FA challenge")
+
+// MaxTwoFactorAttempts is how many wrong codes a 2FA challenge takes before
+// it's revoked and the user has to sign in again
+const MaxTwoFactorAttempts = 5
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// GenerateTokenPair creates a new access/refresh token pair. rememberMe
+// stretches the refresh token from 7 to 30 days.
+func (a *AuthService) GenerateTokenPair(user *User, deviceInfo, ipAddress string, rememberMe bool) (*TokenPair, error) {
+	tokens, err := a.createSession(a.db, user, deviceInfo, ipAddress, rememberMe)
+	if err != nil {
+		return nil, err
+	}
+
+	// The login itself has succeeded; a failed cleanup is retried next login
+	if err := a.enforceSessionLimit(user, hashToken(tokens.RefreshToken), deviceInfo, ipAddress); err != nil {
+		fmt.Printf("Failed to enforce session limit for user %s: %v\n", user.ID, err)
+	}
+
+	if a.loginAlerts != nil {
+		a.loginAlerts.enqueue(loginEvent{
+			userID:           user.ID,
+			fingerprint:      a.createDeviceFingerprint(deviceInfo, ipAddress),
+			refreshTokenHash: hashToken(tokens.RefreshToken),
+			deviceInfo:       deviceInfo,
+			ipAddress:        ipAddress,
+			at:               time.Now(),
+		})
+	}
+
+	return tokens, nil
+}
+
+// enforceSessionLimit revokes the user's oldest sessions beyond the limit for
+// their tenant's plan, never the one just created
+func (a *AuthService) enforceSessionLimit(user *User, currentRefreshHash, deviceInfo, ipAddress string) error {
+	limit := a.sessionLimit(user.TenantID)
+	if limit <= 0 {
+		return nil
+	}
+
+	rows, err := a.db.Query(`
+		UPDATE user_sessions SET revoked = TRUE
+		WHERE id IN (
+			SELECT id FROM user_sessions
+			WHERE user_id = $1 AND revoked = FALSE AND expires_at > NOW() AND refresh_token_hash <> $2
+			ORDER BY created_at DESC
+			OFFSET $3
+		)
+		RETURNING id
+	`, user.ID, currentRefreshHash, limit-1)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var revoked []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return err
+		}
+		revoked = append(revoked, sessionID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(revoked) == 0 {
+		return nil
+	}
+
+	// The revoked sessions' access tokens must stop validating
+	a.forgetSessions(user.ID)
+	for _, sessionID := range revoked {
+		a.LogSecurityEvent(user.ID, "session_limit_revoked", "Oldest session signed out: session limit reached", ipAddress, deviceInfo, map[string]interface{}{
+			"session_id": sessionID,
+			"limit":      limit,
+		})
+	}
+	return nil
+}
+
+// sessionLimit returns the per-user session limit for a tenant's plan,
+// falling back to the server default
+func (a *AuthService) sessionLimit(tenantID string) int {
+	var tier string
+	err := a.db.QueryRow(`SELECT subscription_tier FROM tenants WHERE id = $1`, tenantID).Scan(&tier)
+	if err != nil {
+		return a.maxSessions
+	}
+	if plan, ok := subscriptionPlans()[SubscriptionTier(tier)]; ok && plan.MaxSessions > 0 {
+		return plan.MaxSessions
+	}
+	return a.maxSessions
+}
+
+// createSession signs a new token pair and stores its session row using exec
+func (a *AuthService) createSession(exec sqlExecer, user *User, deviceInfo, ipAddress string, rememberMe bool) (*TokenPair, error) {
 	// Generate session ID
 	sessionID := uuid.New().String()
 	
@@ -199,8 +450,7 @@ func (a *AuthService) GenerateTokenPair(user *User, deviceInfo, ipAddress string
 	}
 
 	// Generate access token
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString(a.jwtSecret)
+	accessTokenString, err := a.signAccessToken(accessClaims)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
@@ -212,23 +462,22 @@ func (a *AuthService) GenerateTokenPair(user *User, deviceInfo, ipAddress string
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 	refreshToken := base64.URLEncoding.EncodeToString(refreshTokenBytes)
-	
-	// Hash refresh token for storage
-	refreshSalt, err := a.GenerateSecureSalt()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token salt: %w", err)
-	}
-	refreshTokenHash := a.HashPassword(refreshToken, refreshSalt)
 
-	// Store session in database
-	expiresAt := time.Now().Add(a.refreshDuration)
-	_, err = a.db.Exec(`
+	// Store session in database. Only the refresh token's SHA-256 is kept; the
+	// token is 256 random bits, so a fast hash is enough and lets us look it up.
+	// Remember me only stretches the refresh token; access tokens stay short
+	refreshDuration := a.refreshDuration
+	if rememberMe {
+		refreshDuration = a.rememberMeDuration
+	}
+	expiresAt := time.Now().Add(refreshDuration)
+	_, err = exec.Exec(`
 		INSERT INTO user_sessions (
-			user_id, refresh_token, refresh_token_hash, access_token_jti,
-			device_fingerprint, user_agent, ip_address, expires_at
+			user_id, refresh_token_hash, access_token_jti,
+			device_fingerprint, user_agent, ip_address, expires_at, remember_me
 		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		user.ID, refreshToken, refreshTokenHash, accessClaims.ID,
-		deviceFingerprint, deviceInfo, ipAddress, expiresAt,
+		user.ID, hashToken(refreshToken), accessClaims.ID,
+		deviceFingerprint, deviceInfo, ipAddress, expiresAt, rememberMe,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to store session: %w", err)
@@ -242,13 +491,109 @@ func (a *AuthService) GenerateTokenPair(user *User, deviceInfo, ipAddress string
 	}, nil
 }
 
+// RefreshTokens exchanges a valid refresh token for a new token pair. The old
+// session is revoked in the same transaction that creates the new one, so a
+// refresh token can only ever be used once.
+func (a *AuthService) RefreshTokens(refreshToken, deviceInfo, ipAddress string) (*TokenPair, *User, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sessionID string
+	var expiresAt time.Time
+	var revoked, rememberMe bool
+	var user User
+	err = tx.QueryRow(`
+		SELECT s.id, s.expires_at, s.revoked, s.remember_me,
+		       u.id, u.tenant_id, u.email, u.role, u.is_active
+		FROM user_sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.refresh_token_hash = $1
+		FOR UPDATE OF s
+	`, hashToken(refreshToken)).Scan(
+		&sessionID, &expiresAt, &revoked, &rememberMe,
+		&user.ID, &user.TenantID, &user.Email, &user.Role, &user.IsActive,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	if revoked {
+		return nil, &user, ErrRefreshTokenRevoked
+	}
+
+	if time.Now().After(expiresAt) {
+		return nil, &user, ErrRefreshTokenExpired
+	}
+
+	if !user.IsActive {
+		return nil, &user, ErrInvalidRefreshToken
+	}
+
+	// Revoke the old session; a concurrent refresh will find zero rows
+	res, err := tx.Exec(`
+		UPDATE user_sessions 
+		SET revoked = TRUE 
+		WHERE id = $1 AND revoked = FALSE
+	`, sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows != 1 {
+		return nil, &user, ErrRefreshTokenRevoked
+	}
+
+	// The rotated session keeps the lifetime the user chose at login
+	tokens, err := a.createSession(tx, &user, deviceInfo, ipAddress, rememberMe)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit session rotation: %w", err)
+	}
+	// The rotated-out session's access token must stop validating
+	a.forgetSessions(user.ID)
+
+	return tokens, &user, nil
+}
+
+// EnableSessionCache lets ValidateToken trust a session it confirmed within
+// the last ttl instead of querying user_sessions on every request
+func (a *AuthService) EnableSessionCache(ttl time.Duration) {
+	if ttl <= 0 {
+		a.sessionCache = nil
+		return
+	}
+	a.sessionCache = newSessionCache(ttl)
+}
+
+// EnableLoginAlerts notifies users through notifier when they sign in from a
+// device they haven't used before. Checks run in the background so they never
+// delay a login. locator may be nil.
+func (a *AuthService) EnableLoginAlerts(notifier LoginNotifier, locator IPLocator) {
+	a.loginAlerts = newLoginAlerter(a.db, notifier, locator)
+}
+
+// forgetSessions evicts a user's cached sessions after a revocation
+func (a *AuthService) forgetSessions(userID string) {
+	if a.sessionCache != nil {
+		a.sessionCache.evictUser(userID)
+	}
+}
+
 // ValidateToken validates and parses a JWT token
 func (a *AuthService) ValidateToken(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return a.jwtSecret, nil
+		return a.verificationKey(token)
 	})
 
 	if err != nil {
@@ -256,17 +601,29 @@ func (a *AuthService) ValidateToken(tokenString string) (*JWTClaims, error) {
 	}
 
 	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
+		if a.sessionCache != nil && a.sessionCache.valid(claims.ID) {
+			return claims, nil
+		}
+
 		// Check if session is still valid
-		var sessionExists bool
-		err = a.db.QueryRow(`
+		query := `
 			SELECT EXISTS(
 				SELECT 1 FROM user_sessions 
 				WHERE access_token_jti = $1 AND expires_at > NOW() AND revoked = FALSE
-			)`, claims.ID).Scan(&sessionExists)
+			)`
+		if claims.Impersonation {
+			query = impersonationSessionQuery
+		}
+		var sessionExists bool
+		err = a.db.QueryRow(query, claims.ID).Scan(&sessionExists)
 		
 		if err != nil || !sessionExists {
 			return nil, fmt.Errorf("session invalid or expired")
 		}
+
+		if a.sessionCache != nil {
+			a.sessionCache.store(claims.ID, claims.UserID)
+		}
 		
 		return claims, nil
 	}
@@ -281,6 +638,23 @@ func (a *AuthService) createDeviceFingerprint(deviceInfo, ipAddress string) stri
 	return base64.StdEncoding.EncodeToString([]byte(fingerprint))
 }
 
+// generateOpaqueToken returns a URL-safe random token with 256 bits of entropy
+func generateOpaqueToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+// hashToken returns the SHA-256 hex digest used to store and look up opaque
+// tokens. Unlike passwords these are high-entropy, so a fast unsalted hash is
+// sufficient and keeps the column indexable.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // ValidateIPAddress validates an IP address format
 func (a *AuthService) ValidateIPAddress(ip string) bool {
 	return net.ParseIP(ip) != nil
@@ -333,8 +707,12 @@ func (a *AuthService) ResetFailedAttempts(userID string) error {
 func (a *AuthService) LogSecurityEvent(userID, eventType, description, ipAddress, userAgent string, additionalData map[string]interface{}) error {
 	var jsonData interface{}
 	if additionalData != nil {
-		// Convert map to JSON (simplified - in production use proper JSON handling)
-		jsonData = additionalData
+		// The driver can't encode a map, so store it as JSON text
+		encoded, err := json.Marshal(additionalData)
+		if err != nil {
+			return err
+		}
+		jsonData = string(encoded)
 	}
 	
 	_, err := a.db.Exec(`
@@ -351,11 +729,82 @@ func (a *AuthService) RevokeSession(refreshToken string) error {
 	_, err := a.db.Exec(`
 		UPDATE user_sessions 
 		SET revoked = TRUE 
-		WHERE refresh_token = $1
-	`, refreshToken)
+		WHERE refresh_token_hash = $1
+	`, hashToken(refreshToken))
+	return err
+}
+
+// RevokeSessionByJTI revokes the session that issued the given access token
+func (a *AuthService) RevokeSessionByJTI(userID, jti string) error {
+	_, err := a.db.Exec(`
+		UPDATE user_sessions 
+		SET revoked = TRUE 
+		WHERE user_id = $1 AND access_token_jti = $2
+	`, userID, jti)
+	if a.sessionCache != nil {
+		a.sessionCache.evict(jti)
+	}
 	return err
 }
 
+// ListActiveSessions returns the user's unrevoked, unexpired sessions, newest
+// first. currentJTI marks the session the caller is using.
+func (a *AuthService) ListActiveSessions(userID, currentJTI string) ([]Session, error) {
+	rows, err := a.db.Query(`
+		SELECT id, COALESCE(user_agent, ''), COALESCE(device_fingerprint, ''),
+		       COALESCE(host(ip_address), ''), created_at, expires_at, remember_me, access_token_jti
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked = FALSE AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var jti string
+		if err := rows.Scan(
+			&session.ID, &session.DeviceInfo, &session.DeviceFingerprint,
+			&session.IPAddress, &session.CreatedAt, &session.ExpiresAt, &session.RememberMe, &jti,
+		); err != nil {
+			return nil, err
+		}
+		session.Current = jti == currentJTI
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeUserSession revokes one of the user's own sessions by ID. Its access
+// token stops working immediately because ValidateToken checks the session.
+func (a *AuthService) RevokeUserSession(userID, sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+
+	result, err := a.db.Exec(`
+		UPDATE user_sessions 
+		SET revoked = TRUE 
+		WHERE id = $1 AND user_id = $2 AND revoked = FALSE
+	`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+	a.forgetSessions(userID)
+	return nil
+}
+
 // RevokeAllUserSessions revokes all sessions for a user
 func (a *AuthService) RevokeAllUserSessions(userID string) error {
 	_, err := a.db.Exec(`
@@ -363,6 +812,7 @@ func (a *AuthService) RevokeAllUserSessions(userID string) error {
 		SET revoked = TRUE 
 		WHERE user_id = $1
 	`, userID)
+	a.forgetSessions(userID)
 	return err
 }
 
@@ -370,4 +820,86 @@ func (a *AuthService) RevokeAllUserSessions(userID string) error {
 func (a *AuthService) CleanupExpiredSessions() error {
 	_, err := a.db.Exec(`DELETE FROM user_sessions WHERE expires_at < NOW()`)
 	return err
-}
\ No newline at end of file
+}
+// CreateTwoFactorChallenge issues the temp token returned by login when a 2FA
+// code is still required. Only its hash is stored.
+func (a *AuthService) CreateTwoFactorChallenge(userID string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = a.db.Exec(`
+		INSERT INTO two_factor_challenges (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)`,
+		userID, hashToken(token), time.Now().Add(a.challengeDuration),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store 2FA challenge: %w", err)
+	}
+
+	return token, nil
+}
+
+// ValidateTwoFactorChallenge checks a temp token without using it up, so a
+// mistyped code doesn't force the user to log in again
+func (a *AuthService) ValidateTwoFactorChallenge(token, userID string) error {
+	var valid bool
+	err := a.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM two_factor_challenges
+			WHERE token_hash = $1 AND user_id = $2 AND used = FALSE AND expires_at > NOW()
+		)`, hashToken(token), userID,
+	).Scan(&valid)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidTwoFactorChallenge
+	}
+	return nil
+}
+
+// RecordTwoFactorFailure counts a wrong code against a temp token and
+// revokes it once MaxTwoFactorAttempts have been made, returning whether it's
+// been revoked. A token already used up counts as revoked.
+func (a *AuthService) RecordTwoFactorFailure(token, userID string) (bool, error) {
+	var revoked bool
+	err := a.db.QueryRow(`
+		UPDATE two_factor_challenges
+		SET failed_attempts = failed_attempts + 1, used = failed_attempts + 1 >= $3
+		WHERE token_hash = $1 AND user_id = $2 AND used = FALSE
+		RETURNING used`,
+		hashToken(token), userID, MaxTwoFactorAttempts,
+	).Scan(&revoked)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record 2FA attempt: %w", err)
+	}
+	return revoked, nil
+}
+
+// ConsumeTwoFactorChallenge marks a temp token as used. The conditional
+// update makes it single-use even under concurrent requests.
+func (a *AuthService) ConsumeTwoFactorChallenge(token, userID string) error {
+	result, err := a.db.Exec(`
+		UPDATE two_factor_challenges
+		SET used = TRUE
+		WHERE token_hash = $1 AND user_id = $2 AND used = FALSE AND expires_at > NOW()`,
+		hashToken(token), userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return ErrInvalidTwoFactorChallenge
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package services
+
+import (
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAuthService returns an AuthService backed by sqlmock with cheap
+// Argon2 parameters so tests don't burn 128MB per hash
+func newTestAuthService(t *testing.T) (*AuthService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return NewAuthServiceWithArgon2(db, "test-secret", testArgon2Params()), mock
+}
+
+func testArgon2Params() *Argon2Params {
+	return &Argon2Params{
+		Memory:      1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func hashForTest(t *testing.T, service *AuthService, value string) string {
+	salt, err := service.GenerateSecureSalt()
+	require.NoError(t, err)
+	return service.HashPassword(value, salt)
+}
+
+func TestVerifyPassword_OldRowsVerifyFromHashAlone(t *testing.T) {
+	service, _ := newTestAuthService(t)
+
+	// Rows written before password_salt was dropped stored string(salt),
+	// which isn't valid UTF-8; the encoded hash carries the real salt.
+	salt := []byte{0xff, 0xfe, 0x00, 0x80, 0x81, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89, 0x8a, 0x8b, 0x8c}
+	assert.False(t, utf8.ValidString(string(salt)))
+	stored := service.HashPassword("Sup3r$ecretPass", salt)
+
+	assert.True(t, service.VerifyPassword("Sup3r$ecretPass", stored))
+	assert.False(t, service.VerifyPassword("wrong-password", stored))
+}
+
+func TestVerifyPassword_HashesMigrateForwardAfterParamsBump(t *testing.T) {
+	oldService, mock := newTestAuthService(t)
+	oldHash := hashForTest(t, oldService, "Sup3r$ecretPass")
+
+	bumped := testArgon2Params()
+	bumped.Iterations = 2
+	bumped.Memory = 2048
+	service := NewAuthServiceWithArgon2(oldService.db, "test-secret", bumped)
+
+	// The old hash still verifies with the params encoded in it
+	assert.True(t, service.VerifyPassword("Sup3r$ecretPass", oldHash))
+	assert.False(t, service.VerifyPassword("wrong-password", oldHash))
+	assert.True(t, service.NeedsRehash(oldHash))
+	assert.False(t, oldService.NeedsRehash(oldHash))
+
+	newHash := &capturedArg{}
+	mock.ExpectExec(`UPDATE users SET password_hash = \$2`).
+		WithArgs("user-1", newHash, oldHash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, service.RehashPassword("user-1", "Sup3r$ecretPass", oldHash))
+
+	upgraded := newHash.value.(string)
+	assert.Contains(t, upgraded, "$m=2048,t=2,p=1$")
+	assert.True(t, service.VerifyPassword("Sup3r$ecretPass", upgraded))
+	assert.False(t, service.NeedsRehash(upgraded))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNeedsRehash_UnparseableHash(t *testing.T) {
+	service, _ := newTestAuthService(t)
+
+	assert.True(t, service.NeedsRehash("not-a-hash"))
+	assert.True(t, service.NeedsRehash("$argon2id$v=19$m=x,t=1,p=1$c2FsdA$aGFzaA"))
+	assert.False(t, service.VerifyPassword("anything", "not-a-hash"))
+}
+
+func TestArgon2ParamsFromEnv(t *testing.T) {
+	t.Setenv("ARGON2_MEMORY_KB", "19456")
+	t.Setenv("ARGON2_ITERATIONS", "2")
+	t.Setenv("ARGON2_PARALLELISM", "1")
+
+	params, err := Argon2ParamsFromEnv()
+
+	require.NoError(t, err)
+	assert.Equal(t, uint32(19456), params.Memory)
+	assert.Equal(t, uint32(2), params.Iterations)
+	assert.Equal(t, uint8(1), params.Parallelism)
+	assert.Equal(t, DefaultArgon2Params().KeyLength, params.KeyLength)
+
+	t.Setenv("ARGON2_MEMORY_KB", "64")
+	_, err = Argon2ParamsFromEnv()
+	assert.Error(t, err)
+}
+
+func TestGenerateTokenPair_StoresOnlyRefreshTokenHash(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	storedHash := &capturedArg{}
+	mock.ExpectExec(`INSERT INTO user_sessions`).
+		WithArgs("user-1", storedHash, sqlmock.AnyArg(), sqlmock.AnyArg(), "agent", "127.0.0.1", sqlmock.AnyArg(), false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	tokens, err := service.GenerateTokenPair(&User{ID: "user-1", TenantID: "tenant-1", Role: "user"}, "agent", "127.0.0.1", false)
+
+	require.NoError(t, err)
+	assert.Equal(t, hashToken(tokens.RefreshToken), storedHash.value)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGenerateTokenPair_RememberMeExtendsRefreshOnly(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	user := &User{ID: "user-1", TenantID: "tenant-1", Role: "user"}
+
+	expiries := map[bool]*capturedArg{false: {}, true: {}}
+	accessExpiry := map[bool]time.Time{}
+	for _, rememberMe := range []bool{false, true} {
+		mock.ExpectExec(`INSERT INTO user_sessions`).
+			WithArgs("user-1", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "agent", "127.0.0.1", expiries[rememberMe], rememberMe).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		tokens, err := service.GenerateTokenPair(user, "agent", "127.0.0.1", rememberMe)
+		require.NoError(t, err)
+		assert.Equal(t, expiries[rememberMe].value, tokens.ExpiresAt)
+
+		mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		claims, err := service.ValidateToken(tokens.AccessToken)
+		require.NoError(t, err)
+		accessExpiry[rememberMe] = claims.ExpiresAt.Time
+	}
+
+	assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), expiries[false].value.(time.Time), time.Minute)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), expiries[true].value.(time.Time), time.Minute)
+	// Access tokens last 15 minutes either way
+	assert.WithinDuration(t, accessExpiry[false], accessExpiry[true], 2*time.Second)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), accessExpiry[true], 2*time.Second)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefreshTokens_KeepsRememberMe(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	expiresAt := &capturedArg{}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_sessions s`).
+		WillReturnRows(sqlmock.NewRows(refreshSessionColumns).AddRow(
+			"session-1", time.Now().Add(time.Hour), false, true,
+			"user-1", "tenant-1", "user@example.com", "user", true,
+		))
+	mock.ExpectExec(`UPDATE user_sessions`).
+		WithArgs("session-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO user_sessions`).
+		WithArgs("user-1", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "agent", "127.0.0.1", expiresAt, true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, _, err := service.RefreshTokens("remembered-refresh-token", "agent", "127.0.0.1")
+
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), expiresAt.value.(time.Time), time.Minute)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHashToken_MatchesMigrationBackfill(t *testing.T) {
+	// Migration 003 backfills with encode(sha256(convert_to(token, 'UTF8')), 'hex');
+	// this is Postgres' output for 'abc', so pre-migration tokens still match.
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", hashToken("abc"))
+}
+
+var refreshSessionColumns = []string{
+	"id", "expires_at", "revoked", "remember_me",
+	"id", "tenant_id", "email", "role", "is_active",
+}
+
+func TestRefreshTokens_Valid(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	refreshToken := "valid-refresh-token"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_sessions s`).
+		WithArgs(hashToken(refreshToken)).
+		WillReturnRows(sqlmock.NewRows(refreshSessionColumns).AddRow(
+			"session-1", time.Now().Add(time.Hour), false, false,
+			"user-1", "tenant-1", "user@example.com", "user", true,
+		))
+	mock.ExpectExec(`UPDATE user_sessions`).
+		WithArgs("session-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO user_sessions`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	tokens, user, err := service.RefreshTokens(refreshToken, "agent", "127.0.0.1")
+
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", user.ID)
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEqual(t, refreshToken, tokens.RefreshToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefreshTokens_Expired(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	refreshToken := "expired-refresh-token"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_sessions s`).
+		WithArgs(hashToken(refreshToken)).
+		WillReturnRows(sqlmock.NewRows(refreshSessionColumns).AddRow(
+			"session-1", time.Now().Add(-time.Minute), false, false,
+			"user-1", "tenant-1", "user@example.com", "user", true,
+		))
+	mock.ExpectRollback()
+
+	tokens, _, err := service.RefreshTokens(refreshToken, "agent", "127.0.0.1")
+
+	assert.Nil(t, tokens)
+	assert.ErrorIs(t, err, ErrRefreshTokenExpired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefreshTokens_Revoked(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	refreshToken := "revoked-refresh-token"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_sessions s`).
+		WithArgs(hashToken(refreshToken)).
+		WillReturnRows(sqlmock.NewRows(refreshSessionColumns).AddRow(
+			"session-1", time.Now().Add(time.Hour), true, false,
+			"user-1", "tenant-1", "user@example.com", "user", true,
+		))
+	mock.ExpectRollback()
+
+	tokens, _, err := service.RefreshTokens(refreshToken, "agent", "127.0.0.1")
+
+	assert.Nil(t, tokens)
+	assert.ErrorIs(t, err, ErrRefreshTokenRevoked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefreshTokens_ConcurrentReplayLosesRace(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	refreshToken := "raced-refresh-token"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_sessions s`).
+		WithArgs(hashToken(refreshToken)).
+		WillReturnRows(sqlmock.NewRows(refreshSessionColumns).AddRow(
+			"session-1", time.Now().Add(time.Hour), false, false,
+			"user-1", "tenant-1", "user@example.com", "user", true,
+		))
+	mock.ExpectExec(`UPDATE user_sessions`).
+		WithArgs("session-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	_, _, err := service.RefreshTokens(refreshToken, "agent", "127.0.0.1")
+
+	assert.ErrorIs(t, err, ErrRefreshTokenRevoked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefreshTokens_UnknownToken(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM user_sessions s`).
+		WithArgs(hashToken("unknown")).
+		WillReturnRows(sqlmock.NewRows(refreshSessionColumns))
+	mock.ExpectRollback()
+
+	_, _, err := service.RefreshTokens("unknown", "agent", "127.0.0.1")
+
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidateToken_RejectsRevokedSession(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	tokens, err := service.GenerateTokenPair(&User{ID: "user-1", TenantID: "tenant-1", Role: "user"}, "agent", "127.0.0.1", false)
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	claims, err := service.ValidateToken(tokens.AccessToken)
+	require.NoError(t, err)
+
+	mock.ExpectExec(`UPDATE user_sessions`).
+		WithArgs("user-1", claims.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, service.RevokeSessionByJTI("user-1", claims.ID))
+
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs(claims.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	_, err = service.ValidateToken(tokens.AccessToken)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTwoFactorChallenge_StoresHashOnly(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	var storedHash capturedArg
+	mock.ExpectExec(`INSERT INTO two_factor_challenges`).
+		WithArgs("user-1", &storedHash, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	token, err := service.CreateTwoFactorChallenge("user-1")
+
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, hashToken(token), storedHash.value)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTwoFactorChallenge_SingleUse(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	token := "temp-token"
+
+	mock.ExpectExec(`UPDATE two_factor_challenges`).
+		WithArgs(hashToken(token), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE two_factor_challenges`).
+		WithArgs(hashToken(token), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	require.NoError(t, service.ConsumeTwoFactorChallenge(token, "user-1"))
+	assert.ErrorIs(t, service.ConsumeTwoFactorChallenge(token, "user-1"), ErrInvalidTwoFactorChallenge)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTwoFactorChallenge_RevokedAfterMaxAttempts(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	token := "temp-token"
+
+	for attempt := 1; attempt <= MaxTwoFactorAttempts; attempt++ {
+		mock.ExpectQuery(`UPDATE two_factor_challenges\s+SET failed_attempts = failed_attempts \+ 1, used = failed_attempts \+ 1 >= \$3`).
+			WithArgs(hashToken(token), "user-1", MaxTwoFactorAttempts).
+			WillReturnRows(sqlmock.NewRows([]string{"used"}).AddRow(attempt == MaxTwoFactorAttempts))
+	}
+	// Once revoked there's nothing left to count against
+	mock.ExpectQuery(`UPDATE two_factor_challenges`).
+		WithArgs(hashToken(token), "user-1", MaxTwoFactorAttempts).
+		WillReturnRows(sqlmock.NewRows([]string{"used"}))
+
+	for attempt := 1; attempt < MaxTwoFactorAttempts; attempt++ {
+		revoked, err := service.RecordTwoFactorFailure(token, "user-1")
+		require.NoError(t, err)
+		assert.False(t, revoked, "attempt %d", attempt)
+	}
+	for i := 0; i < 2; i++ {
+		revoked, err := service.RecordTwoFactorFailure(token, "user-1")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTwoFactorChallenge_RejectsOtherUsersToken(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	mock.ExpectQuery(`FROM two_factor_challenges`).
+		WithArgs(hashToken("temp-token"), "user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	err := service.ValidateTwoFactorChallenge("temp-token", "user-2")
+
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorChallenge)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectSessionLimitCheck mocks the plan lookup and revocation that follow
+// each new session; revoked lists the session IDs signed out
+func expectSessionLimitCheck(mock sqlmock.Sqlmock, tier string, limit int, revoked ...string) {
+	mock.ExpectQuery(`SELECT subscription_tier FROM tenants`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow(tier))
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range revoked {
+		rows.AddRow(id)
+	}
+	mock.ExpectQuery(`UPDATE user_sessions SET revoked = TRUE\s+WHERE id IN`).
+		WithArgs("user-1", sqlmock.AnyArg(), limit-1).
+		WillReturnRows(rows)
+}
+
+func TestGenerateTokenPair_OldestSessionRevokedOverLimit(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	service.EnableSessionCache(time.Minute)
+	user := &User{ID: "user-1", TenantID: "tenant-1", Role: "user"}
+
+	// Five sessions fit within the default limit
+	var tokens []*TokenPair
+	for i := 0; i < 5; i++ {
+		mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectSessionLimitCheck(mock, "starter", 5)
+		pair, err := service.GenerateTokenPair(user, "agent", "127.0.0.1", false)
+		require.NoError(t, err)
+		tokens = append(tokens, pair)
+	}
+
+	// The oldest session is in use and cached
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	oldest, err := service.ValidateToken(tokens[0].AccessToken)
+	require.NoError(t, err)
+
+	// The sixth pushes the oldest out
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSessionLimitCheck(mock, "starter", 5, "session-1")
+	mock.ExpectExec(`INSERT INTO security_audit_log`).
+		WithArgs("user-1", "session_limit_revoked", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err = service.GenerateTokenPair(user, "agent", "127.0.0.1", false)
+	require.NoError(t, err)
+
+	// The cache was dropped, so the revoked row is seen at once
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs(oldest.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	_, err = service.ValidateToken(tokens[0].AccessToken)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGenerateTokenPair_EnterprisePlanAllowsMoreSessions(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSessionLimitCheck(mock, "enterprise", 25)
+
+	_, err := service.GenerateTokenPair(&User{ID: "user-1", TenantID: "tenant-1", Role: "user"}, "agent", "127.0.0.1", false)
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeSiteVerify serves the siteverify API shared by hCaptcha and
+// Turnstile, accepting only "good-token" from 203.0.113.7
+func newFakeSiteVerify(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "site-secret", r.PostForm.Get("secret"))
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.PostForm.Get("response") == "outage":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.PostForm.Get("response") == "good-token" && r.PostForm.Get("remoteip") == "203.0.113.7":
+			w.Write([]byte(`{"success": true}`))
+		default:
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCaptchaVerifiers(t *testing.T) {
+	for name, newVerifier := range map[string]func(string) CaptchaVerifier{
+		"hcaptcha":  NewHCaptchaVerifier,
+		"turnstile": NewTurnstileVerifier,
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := newFakeSiteVerify(t)
+			verifier := newVerifier("site-secret").(*siteVerifyCaptcha)
+			verifier.verifyURL = server.URL
+			ctx := context.Background()
+
+			assert.NoError(t, verifier.Verify(ctx, "good-token", "203.0.113.7"))
+
+			err := verifier.Verify(ctx, "bad-token", "203.0.113.7")
+			assert.ErrorIs(t, err, ErrCaptchaFailed)
+			assert.ErrorContains(t, err, "invalid-input-response")
+
+			assert.ErrorIs(t, verifier.Verify(ctx, "", "203.0.113.7"), ErrCaptchaFailed)
+
+			err = verifier.Verify(ctx, "outage", "203.0.113.7")
+			assert.Error(t, err)
+			assert.NotErrorIs(t, err, ErrCaptchaFailed, "an outage isn't the user's fault")
+		})
+	}
+}
+
+func TestNewCaptchaVerifierFromEnv(t *testing.T) {
+	t.Setenv("CAPTCHA_PROVIDER", "")
+	t.Setenv("CAPTCHA_SECRET", "site-secret")
+	assert.Nil(t, NewCaptchaVerifierFromEnv(), "no provider turns CAPTCHA off")
+
+	t.Setenv("CAPTCHA_PROVIDER", "Turnstile")
+	assert.NotNil(t, NewCaptchaVerifierFromEnv())
+
+	t.Setenv("CAPTCHA_SECRET", "")
+	assert.Nil(t, NewCaptchaVerifierFromEnv())
+}
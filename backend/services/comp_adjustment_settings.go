@@ -0,0 +1,60 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// CompAdjustmentSettingsRepository stores the adjustment schedule each
+// tenant's comps are adjusted by, for tenants who've changed it from the
+// built-in one
+type CompAdjustmentSettingsRepository struct {
+	db *sql.DB
+}
+
+// NewCompAdjustmentSettingsRepository creates a new comp adjustment
+// settings repository
+func NewCompAdjustmentSettingsRepository(db *sql.DB) *CompAdjustmentSettingsRepository {
+	return &CompAdjustmentSettingsRepository{db: db}
+}
+
+// Get returns tenantID's adjustment schedule, or the built-in one when the
+// tenant hasn't set its own
+func (r *CompAdjustmentSettingsRepository) Get(tenantID string) (CompAdjustmentSchedule, error) {
+	var schedule CompAdjustmentSchedule
+	err := r.db.QueryRow(`
+		SELECT per_bedroom, per_bathroom, per_sqft, derive_per_sqft
+		FROM comp_adjustment_settings
+		WHERE tenant_id = $1 AND market = ''
+	`, tenantID).Scan(&schedule.PerBedroom, &schedule.PerBathroom, &schedule.PerSqFt, &schedule.DerivePerSqFt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultCompAdjustmentSchedule(), nil
+	}
+	if err != nil {
+		return CompAdjustmentSchedule{}, fmt.Errorf("failed to load comp adjustment settings: %w", err)
+	}
+	return schedule, nil
+}
+
+// Update changes tenantID's adjustment schedule. Fields left out of
+// overrides keep their current value.
+func (r *CompAdjustmentSettingsRepository) Update(tenantID string, overrides CompAdjustmentOverrides) (CompAdjustmentSchedule, error) {
+	current, err := r.Get(tenantID)
+	if err != nil {
+		return CompAdjustmentSchedule{}, err
+	}
+	schedule := current.override(&overrides)
+
+	_, err = r.db.Exec(`
+		INSERT INTO comp_adjustment_settings (tenant_id, per_bedroom, per_bathroom, per_sqft, derive_per_sqft)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant_id, market) DO UPDATE
+		SET per_bedroom = EXCLUDED.per_bedroom, per_bathroom = EXCLUDED.per_bathroom,
+			per_sqft = EXCLUDED.per_sqft, derive_per_sqft = EXCLUDED.derive_per_sqft, updated_at = NOW()
+	`, tenantID, schedule.PerBedroom, schedule.PerBathroom, schedule.PerSqFt, schedule.DerivePerSqFt)
+	if err != nil {
+		return CompAdjustmentSchedule{}, fmt.Errorf("failed to save comp adjustment settings: %w", err)
+	}
+	return schedule, nil
+}
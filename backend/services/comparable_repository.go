@@ -0,0 +1,280 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// Comparable errors
+var (
+	ErrComparableNotFound = errors.New("comparable not found")
+	ErrInvalidSaleDate    = errors.New("sale date must be formatted YYYY-MM-DD")
+	ErrNoComparables      = errors.New("property has no comparables")
+)
+
+// comparableColumns are selected, in this order, by scanComparable.
+// price_per_sq_ft and adjusted_value are generated from the sale price,
+// square footage and adjustments.
+const comparableColumns = `id, property_id, address, sale_price, sale_date, COALESCE(distance, 0),
+	COALESCE(bedrooms, 0), COALESCE(bathrooms, 0), COALESCE(square_feet, 0),
+	COALESCE(price_per_sq_ft, 0), COALESCE(adjustments, 0), COALESCE(adjusted_value, 0), created_at`
+
+// ComparableRequest holds the fields of a comparable sale. SaleDate is
+// formatted YYYY-MM-DD.
+type ComparableRequest struct {
+	Address    string  `json:"address" binding:"required,max=500"`
+	SalePrice  float64 `json:"sale_price" binding:"required,gt=0"`
+	SaleDate   string  `json:"sale_date" binding:"required"`
+	Distance   float64 `json:"distance" binding:"min=0"`
+	Bedrooms   int     `json:"bedrooms" binding:"min=0"`
+	Bathrooms  float64 `json:"bathrooms" binding:"min=0,max=99"`
+	SquareFeet int     `json:"square_feet" binding:"min=0"`
+}
+
+// ARVEstimate is an ARV estimated from a property's saved comparables
+type ARVEstimate struct {
+	CompsEstimate
+	ComparablesUsed int     `json:"comparables_used"`
+	Bedrooms        int     `json:"bedrooms"`
+	Bathrooms       float64 `json:"bathrooms"`
+	SquareFeet      int     `json:"square_feet"`
+}
+
+// ComparableRepository stores the comparable sales saved against a
+// tenant's properties, adjusted by the tenant's adjustment schedule
+type ComparableRepository struct {
+	db         *sql.DB
+	properties *PropertyRepository
+	settings   *CompAdjustmentSettingsRepository
+	arvService *ArvService
+}
+
+// NewComparableRepository creates a new comparable repository
+func NewComparableRepository(db *sql.DB) *ComparableRepository {
+	return &ComparableRepository{
+		db:         db,
+		properties: NewPropertyRepository(db),
+		settings:   NewCompAdjustmentSettingsRepository(db),
+		arvService: NewArvService(),
+	}
+}
+
+// Create saves a comparable sale for one of tenantID's properties, adjusted
+// for the differences between the comparable and the property
+func (r *ComparableRepository) Create(tenantID, propertyID string, req ComparableRequest) (*models.Comparable, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	comp, err := r.adjust(tenantID, property, req)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := scanComparable(r.db.QueryRow(`
+		INSERT INTO comparables (
+			property_id, address, sale_price, sale_date, distance, bedrooms, bathrooms, square_feet, adjustments
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+comparableColumns,
+		property.ID, comp.Address, comp.SalePrice, comp.SaleDate, comp.Distance,
+		comp.Bedrooms, comp.Bathrooms, comp.SquareFeet, comp.Adjustments,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create comparable: %w", err)
+	}
+	return created, nil
+}
+
+// List returns the comparables saved for one of tenantID's properties,
+// most recent sale first
+func (r *ComparableRepository) List(tenantID, propertyID string) ([]models.Comparable, error) {
+	if _, err := r.properties.Get(tenantID, propertyID); err != nil {
+		return nil, err
+	}
+	return r.listForProperty(propertyID)
+}
+
+// Update replaces a comparable saved for one of tenantID's properties and
+// recalculates its adjustments
+func (r *ComparableRepository) Update(tenantID, propertyID, id string, req ComparableRequest) (*models.Comparable, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrComparableNotFound
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	comp, err := r.adjust(tenantID, property, req)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := scanComparable(r.db.QueryRow(`
+		UPDATE comparables
+		SET address = $3, sale_price = $4, sale_date = $5, distance = $6, bedrooms = $7,
+			bathrooms = $8, square_feet = $9, adjustments = $10
+		WHERE id = $1 AND property_id = $2
+		RETURNING `+comparableColumns,
+		id, property.ID, comp.Address, comp.SalePrice, comp.SaleDate, comp.Distance,
+		comp.Bedrooms, comp.Bathrooms, comp.SquareFeet, comp.Adjustments,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrComparableNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update comparable: %w", err)
+	}
+	return updated, nil
+}
+
+// Delete removes a comparable saved for one of tenantID's properties
+func (r *ComparableRepository) Delete(tenantID, propertyID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrComparableNotFound
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.Exec(`DELETE FROM comparables WHERE id = $1 AND property_id = $2`, id, property.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete comparable: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete comparable: %w", err)
+	}
+	if rows == 0 {
+		return ErrComparableNotFound
+	}
+	return nil
+}
+
+// EstimateARV estimates one of tenantID's properties' ARV from its saved
+// comparables and its own bedrooms, bathrooms and square footage. The
+// comps are adjusted by the tenant's schedule, with overrides in place of
+// any of its fields.
+func (r *ComparableRepository) EstimateARV(tenantID, propertyID string, overrides *CompAdjustmentOverrides) (*ARVEstimate, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := r.listForProperty(property.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(saved) == 0 {
+		return nil, ErrNoComparables
+	}
+	schedule, err := r.settings.Get(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	comps := make([]ComparableProperty, len(saved))
+	for i, comp := range saved {
+		comps[i] = ComparableProperty{
+			Address:       comp.Address,
+			SalePrice:     comp.SalePrice,
+			SaleDate:      comp.SaleDate.Format("2006-01-02"),
+			Bedrooms:      comp.Bedrooms,
+			Bathrooms:     comp.Bathrooms,
+			SquareFeet:    comp.SquareFeet,
+			Distance:      comp.Distance,
+			Adjustments:   comp.Adjustments,
+			AdjustedValue: comp.AdjustedValue,
+		}
+	}
+
+	options := CompEstimateOptions{Adjustments: overrides, Schedule: &schedule}
+	return &ARVEstimate{
+		CompsEstimate:   r.arvService.EstimateARVFromCompsBreakdown(comps, property.Bedrooms, property.Bathrooms, property.SquareFeet, options),
+		ComparablesUsed: len(comps),
+		Bedrooms:        property.Bedrooms,
+		Bathrooms:       property.Bathrooms,
+		SquareFeet:      property.SquareFeet,
+	}, nil
+}
+
+// listForProperty returns a property's comparables, most recent sale first
+func (r *ComparableRepository) listForProperty(propertyID string) ([]models.Comparable, error) {
+	rows, err := r.db.Query(`
+		SELECT `+comparableColumns+` FROM comparables
+		WHERE property_id = $1
+		ORDER BY sale_date DESC, id
+	`, propertyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list comparables: %w", err)
+	}
+	defer rows.Close()
+
+	comps := []models.Comparable{}
+	for rows.Next() {
+		comp, err := scanComparable(rows)
+		if err != nil {
+			return nil, err
+		}
+		comps = append(comps, *comp)
+	}
+	return comps, rows.Err()
+}
+
+// adjust validates req and works out its adjustments against property by
+// tenantID's adjustment schedule
+func (r *ComparableRepository) adjust(tenantID string, property *models.Property, req ComparableRequest) (*models.Comparable, error) {
+	address := strings.TrimSpace(req.Address)
+	if address == "" {
+		return nil, ErrPropertyFieldBlank
+	}
+	saleDate, err := time.Parse("2006-01-02", strings.TrimSpace(req.SaleDate))
+	if err != nil {
+		return nil, ErrInvalidSaleDate
+	}
+
+	comp := ComparableProperty{
+		Address:    address,
+		SalePrice:  req.SalePrice,
+		Bedrooms:   req.Bedrooms,
+		Bathrooms:  req.Bathrooms,
+		SquareFeet: req.SquareFeet,
+		Distance:   req.Distance,
+	}
+	schedule, err := r.settings.Get(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	schedule = schedule.forComps([]ComparableProperty{comp})
+	return &models.Comparable{
+		PropertyID:  property.ID,
+		Address:     address,
+		SalePrice:   req.SalePrice,
+		SaleDate:    saleDate,
+		Distance:    req.Distance,
+		Bedrooms:    req.Bedrooms,
+		Bathrooms:   req.Bathrooms,
+		SquareFeet:  req.SquareFeet,
+		Adjustments: r.arvService.itemizeComparableAdjustments(comp, property.Bedrooms, property.Bathrooms, float64(property.SquareFeet), schedule).Total,
+	}, nil
+}
+
+// scanComparable reads a row selected with comparableColumns
+func scanComparable(row interface{ Scan(...interface{}) error }) (*models.Comparable, error) {
+	var c models.Comparable
+	err := row.Scan(
+		&c.ID, &c.PropertyID, &c.Address, &c.SalePrice, &c.SaleDate, &c.Distance,
+		&c.Bedrooms, &c.Bathrooms, &c.SquareFeet,
+		&c.PricePerSqFt, &c.Adjustments, &c.AdjustedValue, &c.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// EmailSender delivers transactional email. Implementations must be safe for
+// concurrent use.
+type EmailSender interface {
+	Send(to, subject, body string) error
+}
+
+// LogEmailSender prints emails to stdout instead of sending them. It is used
+// when no SMTP server is configured, mirroring the SMS test mode.
+type LogEmailSender struct{}
+
+// Send logs the email
+func (LogEmailSender) Send(to, subject, body string) error {
+	fmt.Printf("TEST MODE: email to %s\nSubject: %s\n%s\n", to, subject, body)
+	return nil
+}
+
+// SMTPEmailSender sends email through an SMTP relay
+type SMTPEmailSender struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPEmailSender creates a new SMTP email sender
+func NewSMTPEmailSender(host, port, username, password, from string) *SMTPEmailSender {
+	return &SMTPEmailSender{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Send delivers a plain-text email
+func (s *SMTPEmailSender) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(s.host+":"+s.port, auth, s.from, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// NewEmailSenderFromEnv returns an SMTP sender when SMTP_HOST is set and a
+// logging sender otherwise
+func NewEmailSenderFromEnv() EmailSender {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogEmailSender{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@arvfinder.com"
+	}
+
+	return NewSMTPEmailSender(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+}
+
+// appBaseURL returns the frontend URL used to build links in emails
+func appBaseURL() string {
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:5173"
+	}
+	return strings.TrimRight(baseURL, "/")
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Email change errors
+var (
+	ErrIncorrectPassword       = errors.New("incorrect password")
+	ErrEmailInUse              = errors.New("email address already in use")
+	ErrSameEmail               = errors.New("new email matches current email")
+	ErrInvalidEmailChangeToken = errors.New("invalid email change token")
+	ErrEmailChangeTokenExpired = errors.New("email change token expired")
+)
+
+// EmailChangeService moves an account to a new email address once the new
+// address has been confirmed
+type EmailChangeService struct {
+	db          *sql.DB
+	authService *AuthService
+	emailSender EmailSender
+	tokenTTL    time.Duration
+}
+
+// NewEmailChangeService creates a new email change service
+func NewEmailChangeService(db *sql.DB, authService *AuthService, emailSender EmailSender) *EmailChangeService {
+	return &EmailChangeService{
+		db:          db,
+		authService: authService,
+		emailSender: emailSender,
+		tokenTTL:    24 * time.Hour,
+	}
+}
+
+// RequestChange stores newEmail as pending and emails a confirmation link to
+// it. The current address is told about the request so a hijacked session
+// can't move the account silently.
+func (e *EmailChangeService) RequestChange(userID, currentPassword, newEmail string) error {
+	newEmail = strings.ToLower(strings.TrimSpace(newEmail))
+
+	var currentEmail, passwordHash string
+	err := e.db.QueryRow(`
+		SELECT email, password_hash FROM users WHERE id = $1 AND is_active = TRUE
+	`, userID).Scan(&currentEmail, &passwordHash)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	if !e.authService.VerifyPassword(currentPassword, passwordHash) {
+		return ErrIncorrectPassword
+	}
+
+	if strings.EqualFold(currentEmail, newEmail) {
+		return ErrSameEmail
+	}
+
+	var taken bool
+	err = e.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = $1)`, newEmail).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to check email: %w", err)
+	}
+	if taken {
+		return ErrEmailInUse
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = e.db.Exec(`
+		UPDATE users 
+		SET pending_email = $1, email_change_token = $2, email_change_expires_at = $3, updated_at = NOW()
+		WHERE id = $4
+	`, newEmail, hashToken(token), time.Now().Add(e.tokenTTL), userID)
+	if err != nil {
+		return fmt.Errorf("failed to store pending email: %w", err)
+	}
+
+	confirmBody := fmt.Sprintf(
+		"Someone asked to use this address for their ArvFinder account.\n\n"+
+			"Confirm the change: %s/confirm-email-change?token=%s\n\n"+
+			"This link expires in 24 hours. If this wasn't you, ignore this email.",
+		appBaseURL(), token,
+	)
+	if err := e.emailSender.Send(newEmail, "Confirm your new ArvFinder email address", confirmBody); err != nil {
+		return fmt.Errorf("failed to send confirmation email: %w", err)
+	}
+
+	noticeBody := fmt.Sprintf(
+		"A request was made to change your ArvFinder email address to %s.\n\n"+
+			"Nothing changes until the new address is confirmed. If this wasn't you, "+
+			"reset your password: %s/forgot-password",
+		newEmail, appBaseURL(),
+	)
+	if err := e.emailSender.Send(currentEmail, "Your ArvFinder email address is being changed", noticeBody); err != nil {
+		return fmt.Errorf("failed to send notice email: %w", err)
+	}
+
+	return nil
+}
+
+// ConfirmChange swaps in the pending email for the account owning token and
+// returns its user ID. If another account claimed the address in the
+// meantime, the pending change is discarded and ErrEmailInUse is returned.
+func (e *EmailChangeService) ConfirmChange(token string) (string, error) {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID, oldEmail string
+	var pendingEmail sql.NullString
+	var expiresAt *time.Time
+	err = tx.QueryRow(`
+		SELECT id, email, pending_email, email_change_expires_at 
+		FROM users WHERE email_change_token = $1
+		FOR UPDATE
+	`, hashToken(token)).Scan(&userID, &oldEmail, &pendingEmail, &expiresAt)
+	if err == sql.ErrNoRows || (err == nil && !pendingEmail.Valid) {
+		return "", ErrInvalidEmailChangeToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up email change token: %w", err)
+	}
+
+	if expiresAt == nil || time.Now().After(*expiresAt) {
+		return userID, ErrEmailChangeTokenExpired
+	}
+
+	// Someone may have registered the address after the request was made
+	var taken bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id <> $2)
+	`, pendingEmail.String, userID).Scan(&taken)
+	if err != nil {
+		return "", fmt.Errorf("failed to check email: %w", err)
+	}
+	if taken {
+		if err := clearPendingEmail(tx, userID); err != nil {
+			return "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", fmt.Errorf("failed to commit email change: %w", err)
+		}
+		return userID, ErrEmailInUse
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users 
+		SET email = pending_email, email_verified = TRUE,
+		    pending_email = NULL, email_change_token = NULL, email_change_expires_at = NULL,
+		    updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		// Lost a race with a registration between the check and the update
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			tx.Rollback()
+			if err := clearPendingEmail(e.db, userID); err != nil {
+				return "", err
+			}
+			return userID, ErrEmailInUse
+		}
+		return "", fmt.Errorf("failed to update email: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit email change: %w", err)
+	}
+
+	body := fmt.Sprintf(
+		"The email address on your ArvFinder account was changed from %s to %s.\n\n"+
+			"If this wasn't you, contact support immediately.",
+		oldEmail, pendingEmail.String,
+	)
+	// The change is committed; a failed notice shouldn't undo it
+	e.emailSender.Send(oldEmail, "Your ArvFinder email address was changed", body)
+	e.emailSender.Send(pendingEmail.String, "Your ArvFinder email address was changed", body)
+
+	return userID, nil
+}
+
+// clearPendingEmail discards a pending email change
+func clearPendingEmail(exec sqlExecer, userID string) error {
+	_, err := exec.Exec(`
+		UPDATE users 
+		SET pending_email = NULL, email_change_token = NULL, email_change_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear pending email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var emailChangeColumns = []string{"id", "email", "pending_email", "email_change_expires_at"}
+
+func TestRequestEmailChange_StoresPendingAndNotifiesBoth(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	sender := &recordingEmailSender{}
+	service := NewEmailChangeService(authService.db, authService, sender)
+	storedHash := &capturedArg{}
+
+	mock.ExpectQuery(`SELECT email, password_hash FROM users`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "password_hash"}).
+			AddRow("old@example.com", hashForTest(t, authService, "Sup3r$ecretPass")))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs("new@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`SET pending_email = \$1`).
+		WithArgs("new@example.com", storedHash, sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := service.RequestChange("user-1", "Sup3r$ecretPass", " New@Example.com ")
+
+	require.NoError(t, err)
+	require.Len(t, sender.sent, 2)
+	assert.Equal(t, "new@example.com", sender.sent[0].To)
+	assert.Contains(t, sender.sent[0].Body, "/confirm-email-change?token=")
+	assert.Equal(t, "old@example.com", sender.sent[1].To)
+	assert.NotContains(t, sender.sent[1].Body, "token=")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequestEmailChange_WrongPassword(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	sender := &recordingEmailSender{}
+	service := NewEmailChangeService(authService.db, authService, sender)
+
+	mock.ExpectQuery(`SELECT email, password_hash FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "password_hash"}).
+			AddRow("old@example.com", hashForTest(t, authService, "Sup3r$ecretPass")))
+
+	err := service.RequestChange("user-1", "wrong", "new@example.com")
+
+	assert.ErrorIs(t, err, ErrIncorrectPassword)
+	assert.Empty(t, sender.sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequestEmailChange_AddressTaken(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewEmailChangeService(authService.db, authService, &recordingEmailSender{})
+
+	mock.ExpectQuery(`SELECT email, password_hash FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"email", "password_hash"}).
+			AddRow("old@example.com", hashForTest(t, authService, "Sup3r$ecretPass")))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	err := service.RequestChange("user-1", "Sup3r$ecretPass", "taken@example.com")
+
+	assert.ErrorIs(t, err, ErrEmailInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfirmEmailChange_SwapsEmail(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	sender := &recordingEmailSender{}
+	service := NewEmailChangeService(authService.db, authService, sender)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE email_change_token = \$1`).
+		WithArgs(hashToken("change-token")).
+		WillReturnRows(sqlmock.NewRows(emailChangeColumns).
+			AddRow("user-1", "old@example.com", "new@example.com", time.Now().Add(time.Hour)))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WithArgs("new@example.com", "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`SET email = pending_email`).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	userID, err := service.ConfirmChange("change-token")
+
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	require.Len(t, sender.sent, 2)
+	assert.Equal(t, "old@example.com", sender.sent[0].To)
+	assert.Equal(t, "new@example.com", sender.sent[1].To)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfirmEmailChange_AddressRegisteredMeanwhile(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	sender := &recordingEmailSender{}
+	service := NewEmailChangeService(authService.db, authService, sender)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE email_change_token = \$1`).
+		WillReturnRows(sqlmock.NewRows(emailChangeColumns).
+			AddRow("user-1", "old@example.com", "new@example.com", time.Now().Add(time.Hour)))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec(`SET pending_email = NULL`).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err := service.ConfirmChange("change-token")
+
+	assert.ErrorIs(t, err, ErrEmailInUse)
+	assert.Empty(t, sender.sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfirmEmailChange_LosesRaceToRegistration(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewEmailChangeService(authService.db, authService, &recordingEmailSender{})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE email_change_token = \$1`).
+		WillReturnRows(sqlmock.NewRows(emailChangeColumns).
+			AddRow("user-1", "old@example.com", "new@example.com", time.Now().Add(time.Hour)))
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`SET email = pending_email`).
+		WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+	mock.ExpectExec(`SET pending_email = NULL`).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := service.ConfirmChange("change-token")
+
+	assert.ErrorIs(t, err, ErrEmailInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfirmEmailChange_Expired(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewEmailChangeService(authService.db, authService, &recordingEmailSender{})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE email_change_token = \$1`).
+		WillReturnRows(sqlmock.NewRows(emailChangeColumns).
+			AddRow("user-1", "old@example.com", "new@example.com", time.Now().Add(-time.Minute)))
+	mock.ExpectRollback()
+
+	_, err := service.ConfirmChange("change-token")
+
+	assert.ErrorIs(t, err, ErrEmailChangeTokenExpired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Email domain errors
+var (
+	ErrDisposableEmail = errors.New("disposable email addresses are not allowed")
+	ErrEmailDomainNoMX = errors.New("email domain does not accept mail")
+)
+
+// Bundled disposable-domain list, extended with DISPOSABLE_EMAIL_DOMAINS
+//
+//go:embed disposable_domains.txt
+var bundledDisposableDomains string
+
+// MXResolver looks up mail exchangers; *net.Resolver satisfies it
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// EmailDomainValidator rejects registrations from disposable providers and
+// from domains that can't receive mail
+type EmailDomainValidator struct {
+	resolver   MXResolver
+	disposable map[string]bool
+	timeout    time.Duration
+	cacheTTL   time.Duration
+	now        func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]mxCacheEntry
+}
+
+type mxCacheEntry struct {
+	acceptsMail bool
+	expiresAt   time.Time
+}
+
+// NewEmailDomainValidator creates a validator using resolver for MX lookups.
+// extraDisposable is added to the bundled disposable-domain list.
+func NewEmailDomainValidator(resolver MXResolver, extraDisposable []string) *EmailDomainValidator {
+	disposable := make(map[string]bool)
+	for _, line := range strings.Split(bundledDisposableDomains, "\n") {
+		if domain := normalizeDomain(line); domain != "" && !strings.HasPrefix(domain, "#") {
+			disposable[domain] = true
+		}
+	}
+	for _, domain := range extraDisposable {
+		if domain = normalizeDomain(domain); domain != "" {
+			disposable[domain] = true
+		}
+	}
+
+	return &EmailDomainValidator{
+		resolver:   resolver,
+		disposable: disposable,
+		timeout:    3 * time.Second,
+		cacheTTL:   time.Hour,
+		now:        time.Now,
+		cache:      make(map[string]mxCacheEntry),
+	}
+}
+
+// NewEmailDomainValidatorFromEnv uses the system resolver and adds the
+// comma-separated DISPOSABLE_EMAIL_DOMAINS to the bundled list
+func NewEmailDomainValidatorFromEnv() *EmailDomainValidator {
+	var extra []string
+	if value := os.Getenv("DISPOSABLE_EMAIL_DOMAINS"); value != "" {
+		extra = strings.Split(value, ",")
+	}
+	return NewEmailDomainValidator(net.DefaultResolver, extra)
+}
+
+// Validate checks the domain of email. DNS timeouts and other temporary
+// failures let the address through so an outage can't block signups.
+func (v *EmailDomainValidator) Validate(email string) error {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ErrEmailDomainNoMX
+	}
+	domain := normalizeDomain(email[at+1:])
+
+	// Providers hand out subdomains too, e.g. abc.mailinator.com
+	for d := domain; d != ""; {
+		if v.disposable[d] {
+			return ErrDisposableEmail
+		}
+		dot := strings.Index(d, ".")
+		if dot < 0 {
+			break
+		}
+		d = d[dot+1:]
+	}
+
+	acceptsMail, ok := v.lookupMX(domain)
+	if ok && !acceptsMail {
+		return ErrEmailDomainNoMX
+	}
+	return nil
+}
+
+// lookupMX reports whether domain publishes a usable MX record. ok is false
+// when the lookup failed without a definite answer.
+func (v *EmailDomainValidator) lookupMX(domain string) (acceptsMail, ok bool) {
+	v.mu.Lock()
+	entry, cached := v.cache[domain]
+	v.mu.Unlock()
+	if cached && v.now().Before(entry.expiresAt) {
+		return entry.acceptsMail, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+
+	records, err := v.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return false, false
+		}
+	}
+
+	// A single "." exchanger is a null MX (RFC 7505): the domain takes no mail
+	acceptsMail = false
+	for _, record := range records {
+		if host := strings.TrimSuffix(record.Host, "."); host != "" {
+			acceptsMail = true
+			break
+		}
+	}
+
+	v.mu.Lock()
+	v.cache[domain] = mxCacheEntry{acceptsMail: acceptsMail, expiresAt: v.now().Add(v.cacheTTL)}
+	v.mu.Unlock()
+	return acceptsMail, true
+}
+
+// normalizeDomain lowercases a domain and strips whitespace and a trailing dot
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
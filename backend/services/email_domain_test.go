@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver answers MX lookups from a map and counts calls
+type fakeResolver struct {
+	records map[string][]*net.MX
+	errs    map[string]error
+	calls   int
+}
+
+func (f *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	f.calls++
+	if err, ok := f.errs[name]; ok {
+		return nil, err
+	}
+	if records, ok := f.records[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{
+		records: map[string][]*net.MX{
+			"example.com": {{Host: "mx.example.com.", Pref: 10}},
+			"nomail.com":  {{Host: ".", Pref: 0}},
+		},
+		errs: map[string]error{
+			"slow.com": &net.DNSError{Err: "i/o timeout", Name: "slow.com", IsTimeout: true},
+		},
+	}
+}
+
+func TestEmailDomainValidator_AcceptsDomainWithMX(t *testing.T) {
+	validator := NewEmailDomainValidator(newFakeResolver(), nil)
+
+	assert.NoError(t, validator.Validate("user@Example.com"))
+}
+
+func TestEmailDomainValidator_RejectsDisposable(t *testing.T) {
+	resolver := newFakeResolver()
+	validator := NewEmailDomainValidator(resolver, []string{" Throwaway.Test "})
+
+	assert.ErrorIs(t, validator.Validate("user@mailinator.com"), ErrDisposableEmail)
+	assert.ErrorIs(t, validator.Validate("user@inbox.yopmail.com"), ErrDisposableEmail)
+	assert.ErrorIs(t, validator.Validate("user@throwaway.test"), ErrDisposableEmail)
+	assert.Zero(t, resolver.calls, "disposable domains are rejected without DNS")
+}
+
+func TestEmailDomainValidator_RejectsDomainWithoutMail(t *testing.T) {
+	validator := NewEmailDomainValidator(newFakeResolver(), nil)
+
+	assert.ErrorIs(t, validator.Validate("user@doesnotexist.com"), ErrEmailDomainNoMX)
+	assert.ErrorIs(t, validator.Validate("user@nomail.com"), ErrEmailDomainNoMX)
+}
+
+func TestEmailDomainValidator_TimeoutFailsOpen(t *testing.T) {
+	resolver := newFakeResolver()
+	validator := NewEmailDomainValidator(resolver, nil)
+
+	assert.NoError(t, validator.Validate("user@slow.com"))
+	assert.NoError(t, validator.Validate("user@slow.com"))
+	assert.Equal(t, 2, resolver.calls, "failed lookups aren't cached")
+}
+
+func TestEmailDomainValidator_CachesLookups(t *testing.T) {
+	resolver := newFakeResolver()
+	validator := NewEmailDomainValidator(resolver, nil)
+	now := time.Now()
+	validator.now = func() time.Time { return now }
+
+	assert.NoError(t, validator.Validate("a@example.com"))
+	assert.NoError(t, validator.Validate("b@example.com"))
+	assert.Equal(t, 1, resolver.calls)
+
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, validator.Validate("c@example.com"))
+	assert.Equal(t, 2, resolver.calls)
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Email verification errors
+var (
+	ErrInvalidVerificationToken = errors.New("invalid email verification token")
+	ErrVerificationTokenExpired = errors.New("email verification token expired")
+	ErrEmailAlreadyVerified     = errors.New("email already verified")
+)
+
+// EmailVerificationService issues and checks email verification tokens
+type EmailVerificationService struct {
+	db          *sql.DB
+	emailSender EmailSender
+	tokenTTL    time.Duration
+}
+
+// NewEmailVerificationService creates a new email verification service
+func NewEmailVerificationService(db *sql.DB, emailSender EmailSender) *EmailVerificationService {
+	return &EmailVerificationService{
+		db:          db,
+		emailSender: emailSender,
+		tokenTTL:    24 * time.Hour,
+	}
+}
+
+// SendVerification replaces the user's verification token with a fresh one
+// and emails the verification link
+func (e *EmailVerificationService) SendVerification(userID, email string) error {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(e.tokenTTL)
+	_, err = e.db.Exec(`
+		UPDATE users 
+		SET email_verification_token = $1, email_verification_expires_at = $2, updated_at = NOW()
+		WHERE id = $3
+	`, hashToken(token), expiresAt, userID)
+	if err != nil {
+		return fmt.Errorf("failed to store verification token: %w", err)
+	}
+
+	body := fmt.Sprintf(
+		"Welcome to ArvFinder!\n\n"+
+			"Please verify your email address: %s/verify-email?token=%s\n\n"+
+			"This link expires in 24 hours.",
+		appBaseURL(), token,
+	)
+	if err := e.emailSender.Send(email, "Verify your ArvFinder email address", body); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the account owning token as verified and returns its user
+// ID. The token is kept after use so a second click on the same link reports
+// ErrEmailAlreadyVerified instead of an invalid token.
+func (e *EmailVerificationService) VerifyEmail(token string) (string, error) {
+	var userID string
+	var verified bool
+	var expiresAt *time.Time
+	err := e.db.QueryRow(`
+		SELECT id, email_verified, email_verification_expires_at 
+		FROM users WHERE email_verification_token = $1
+	`, hashToken(token)).Scan(&userID, &verified, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up verification token: %w", err)
+	}
+
+	if verified {
+		return userID, ErrEmailAlreadyVerified
+	}
+
+	if expiresAt == nil || time.Now().After(*expiresAt) {
+		return userID, ErrVerificationTokenExpired
+	}
+
+	_, err = e.db.Exec(`
+		UPDATE users 
+		SET email_verified = TRUE, updated_at = NOW()
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to mark email verified: %w", err)
+	}
+
+	return userID, nil
+}
+
+// ResendVerification issues a new verification email for an unverified
+// account. Unknown emails are ignored silently; verified accounts return
+// ErrEmailAlreadyVerified.
+func (e *EmailVerificationService) ResendVerification(email string) (string, error) {
+	var userID string
+	var verified bool
+	err := e.db.QueryRow(`
+		SELECT id, email_verified FROM users WHERE email = $1 AND is_active = TRUE
+	`, email).Scan(&userID, &verified)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	if verified {
+		return userID, ErrEmailAlreadyVerified
+	}
+
+	return userID, e.SendVerification(userID, email)
+}
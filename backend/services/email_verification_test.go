@@ -0,0 +1,91 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var verificationColumns = []string{"id", "email_verified", "email_verification_expires_at"}
+
+func TestVerifyEmail_Valid(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewEmailVerificationService(authService.db, &recordingEmailSender{})
+
+	mock.ExpectQuery(`WHERE email_verification_token = \$1`).
+		WithArgs(hashToken("verify-token")).
+		WillReturnRows(sqlmock.NewRows(verificationColumns).AddRow("user-1", false, time.Now().Add(time.Hour)))
+	mock.ExpectExec(`SET email_verified = TRUE`).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	userID, err := service.VerifyEmail("verify-token")
+
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyEmail_Expired(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewEmailVerificationService(authService.db, &recordingEmailSender{})
+
+	mock.ExpectQuery(`WHERE email_verification_token = \$1`).
+		WillReturnRows(sqlmock.NewRows(verificationColumns).AddRow("user-1", false, time.Now().Add(-time.Hour)))
+
+	_, err := service.VerifyEmail("verify-token")
+
+	assert.ErrorIs(t, err, ErrVerificationTokenExpired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyEmail_AlreadyVerifiedIgnoresExpiry(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewEmailVerificationService(authService.db, &recordingEmailSender{})
+
+	mock.ExpectQuery(`WHERE email_verification_token = \$1`).
+		WillReturnRows(sqlmock.NewRows(verificationColumns).AddRow("user-1", true, time.Now().Add(-time.Hour)))
+
+	_, err := service.VerifyEmail("verify-token")
+
+	assert.ErrorIs(t, err, ErrEmailAlreadyVerified)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResendVerification_UnverifiedSendsNewToken(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	sender := &recordingEmailSender{}
+	service := NewEmailVerificationService(authService.db, sender)
+
+	mock.ExpectQuery(`SELECT id, email_verified FROM users`).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email_verified"}).AddRow("user-1", false))
+	mock.ExpectExec(`SET email_verification_token = \$1`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	userID, err := service.ResendVerification("user@example.com")
+
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	require.Len(t, sender.sent, 1)
+	assert.Contains(t, sender.sent[0].Body, "/verify-email?token=")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResendVerification_AlreadyVerified(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	sender := &recordingEmailSender{}
+	service := NewEmailVerificationService(authService.db, sender)
+
+	mock.ExpectQuery(`SELECT id, email_verified FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email_verified"}).AddRow("user-1", true))
+
+	_, err := service.ResendVerification("user@example.com")
+
+	assert.ErrorIs(t, err, ErrEmailAlreadyVerified)
+	assert.Empty(t, sender.sent)
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// idempotencyTTL is how long a response is replayed, matching how long
+// Stripe remembers an idempotency key
+const idempotencyTTL = 24 * time.Hour
+
+// IdempotencyKey derives the key a payment request is remembered by from
+// the tenant, the operation, the key or request ID the client sent and the
+// request body. Including the body means only an exact retry is replayed,
+// and a key guessed by someone else is no use without the request it came
+// with.
+func IdempotencyKey(tenantID, operation, clientKey string, body []byte) string {
+	bodySum := sha256.Sum256(body)
+	sum := sha256.Sum256([]byte(tenantID + "\x00" + operation + "\x00" + clientKey + "\x00" + hex.EncodeToString(bodySum[:])))
+	return hex.EncodeToString(sum[:])
+}
+
+// derivedKeyWindow is how long an identical request without a client key
+// is taken for a double submit of the first. Past it, buying the same thing
+// again is a new request.
+const derivedKeyWindow = 10 * time.Minute
+
+// IdempotencyStore remembers the responses to payment requests made with an
+// idempotency key for a day, so a retry gets the original response without
+// calling Stripe again
+type IdempotencyStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewIdempotencyStore creates a new idempotency store
+func NewIdempotencyStore(db *sql.DB) *IdempotencyStore {
+	return &IdempotencyStore{db: db, now: time.Now}
+}
+
+// DerivedKey returns the key a tenant's request without a client key is
+// remembered by: identical requests in the same ten minutes share one
+func (s *IdempotencyStore) DerivedKey(tenantID, operation string, body []byte) string {
+	window := s.now().UTC().Truncate(derivedKeyWindow)
+	return IdempotencyKey(tenantID, operation, "derived:"+window.Format(time.RFC3339), body)
+}
+
+// Lookup returns the response saved for key in the last day, or nil
+func (s *IdempotencyStore) Lookup(key string) (json.RawMessage, error) {
+	var response []byte
+	err := s.db.QueryRow(`
+		SELECT response FROM idempotency_keys WHERE key = $1 AND created_at > $2
+	`, key, s.now().Add(-idempotencyTTL)).Scan(&response)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up idempotency key: %w", err)
+	}
+	return response, nil
+}
+
+// Save remembers the response to the request made with key, replacing an
+// expired one
+func (s *IdempotencyStore) Save(key, operation string, response interface{}) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO idempotency_keys (key, operation, response, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE
+		SET operation = EXCLUDED.operation, response = EXCLUDED.response, created_at = EXCLUDED.created_at
+	`, key, operation, string(body), s.now())
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency key: %w", err)
+	}
+	return nil
+}
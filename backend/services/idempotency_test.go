@@ -0,0 +1,25 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDerivedKey_SharedOnlyWithinWindow(t *testing.T) {
+	now := time.Date(2026, time.October, 16, 12, 1, 0, 0, time.UTC)
+	store := NewIdempotencyStore(nil)
+	store.now = func() time.Time { return now }
+	body := []byte(`{"amount": 2900, "currency": "usd"}`)
+
+	first := store.DerivedKey("tenant-1", "create-payment-intent", body)
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, first, store.DerivedKey("tenant-1", "create-payment-intent", body), "a double submit shares the key")
+	assert.NotEqual(t, first, store.DerivedKey("tenant-2", "create-payment-intent", body))
+	assert.NotEqual(t, first, store.DerivedKey("tenant-1", "create-payment-intent", []byte(`{"amount": 9900}`)))
+
+	// Buying the same thing again later is a new request
+	now = now.Add(10 * time.Minute)
+	assert.NotEqual(t, first, store.DerivedKey("tenant-1", "create-payment-intent", body))
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ErrCannotImpersonate is returned when the target is another support user
+var ErrCannotImpersonate = errors.New("user cannot be impersonated")
+
+// impersonationDuration is how long an impersonation token lasts. It can't be
+// refreshed; support starts a new impersonation instead.
+const impersonationDuration = 15 * time.Minute
+
+// impersonationSessionQuery checks an impersonation token is live. It is used
+// by ValidateToken in place of the user_sessions check.
+const impersonationSessionQuery = `
+	SELECT EXISTS(
+		SELECT 1 FROM impersonation_sessions
+		WHERE access_token_jti = $1 AND expires_at > NOW() AND ended_at IS NULL
+	)`
+
+// ImpersonationToken is the access token a support user acts with. There is
+// no refresh token.
+type ImpersonationToken struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	TokenType   string    `json:"token_type"`
+}
+
+// Impersonate issues supportUserID a short-lived token that acts as
+// targetUserID. The token's claims name both users and are flagged
+// Impersonation, so requests made with it can be audited and restricted.
+func (a *AuthService) Impersonate(supportUserID, targetUserID, ipAddress, userAgent string) (*ImpersonationToken, error) {
+	if _, err := uuid.Parse(targetUserID); err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	var target User
+	err := a.db.QueryRow(`
+		SELECT id, tenant_id, email, role FROM users
+		WHERE id = $1 AND is_active = TRUE AND deleted_at IS NULL
+	`, targetUserID).Scan(&target.ID, &target.TenantID, &target.Email, &target.Role)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	if target.Role == "support" {
+		return nil, ErrCannotImpersonate
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(impersonationDuration)
+	claims := &JWTClaims{
+		UserID:         target.ID,
+		TenantID:       target.TenantID,
+		Email:          target.Email,
+		Role:           target.Role,
+		Impersonation:  true,
+		ImpersonatorID: supportUserID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   target.ID,
+			Issuer:    "arvfinder",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	err = a.db.QueryRow(`
+		INSERT INTO impersonation_sessions (
+			support_user_id, target_user_id, access_token_jti, ip_address, user_agent, expires_at
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		supportUserID, target.ID, claims.ID, ipAddress, userAgent, expiresAt,
+	).Scan(&claims.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store impersonation session: %w", err)
+	}
+
+	accessToken, err := a.signAccessToken(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	return &ImpersonationToken{
+		AccessToken: accessToken,
+		ExpiresAt:   expiresAt,
+		TokenType:   "Bearer",
+	}, nil
+}
+
+// EndImpersonation ends the impersonation session that issued the token with
+// jti, so the token stops working before it expires
+func (a *AuthService) EndImpersonation(jti string) error {
+	_, err := a.db.Exec(`
+		UPDATE impersonation_sessions SET ended_at = NOW()
+		WHERE access_token_jti = $1 AND ended_at IS NULL
+	`, jti)
+	if a.sessionCache != nil {
+		a.sessionCache.evict(jti)
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const impersonatedUserID = "33333333-3333-3333-3333-333333333333"
+
+func expectImpersonationTarget(mock sqlmock.Sqlmock, role string) {
+	mock.ExpectQuery(`SELECT id, tenant_id, email, role FROM users`).
+		WithArgs(impersonatedUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email", "role"}).
+			AddRow(impersonatedUserID, "tenant-2", "customer@example.com", role))
+}
+
+func TestImpersonate_TokenNamesBothUsersUntilEnded(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	jti := &capturedArg{}
+
+	expectImpersonationTarget(mock, "admin")
+	mock.ExpectQuery(`INSERT INTO impersonation_sessions`).
+		WithArgs("support-1", impersonatedUserID, jti, "10.0.0.1", "agent", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("imp-session-1"))
+
+	token, err := service.Impersonate("support-1", impersonatedUserID, "10.0.0.1", "agent")
+	require.NoError(t, err)
+
+	// Checked against impersonation_sessions, not the customer's sessions
+	mock.ExpectQuery(`FROM impersonation_sessions`).
+		WithArgs(jti.value).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	claims, err := service.ValidateToken(token.AccessToken)
+	require.NoError(t, err)
+	assert.True(t, claims.Impersonation)
+	assert.Equal(t, "support-1", claims.ImpersonatorID)
+	assert.Equal(t, impersonatedUserID, claims.UserID)
+	assert.Equal(t, "tenant-2", claims.TenantID)
+	assert.Equal(t, "admin", claims.Role)
+	assert.Equal(t, "imp-session-1", claims.SessionID)
+	assert.WithinDuration(t, token.ExpiresAt, claims.ExpiresAt.Time, time.Second)
+
+	mock.ExpectExec(`UPDATE impersonation_sessions SET ended_at = NOW\(\)`).
+		WithArgs(claims.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, service.EndImpersonation(claims.ID))
+
+	mock.ExpectQuery(`FROM impersonation_sessions`).
+		WithArgs(claims.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	_, err = service.ValidateToken(token.AccessToken)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImpersonate_SupportUsersRefused(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	expectImpersonationTarget(mock, "support")
+
+	_, err := service.Impersonate("support-1", impersonatedUserID, "10.0.0.1", "agent")
+
+	assert.ErrorIs(t, err, ErrCannotImpersonate)
+	assert.NoError(t, mock.ExpectationsWereMet(), "no session was created")
+}
+
+func TestImpersonate_UnknownOrInactiveUser(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	mock.ExpectQuery(`SELECT id, tenant_id, email, role FROM users`).
+		WithArgs(impersonatedUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email", "role"}))
+
+	_, err := service.Impersonate("support-1", impersonatedUserID, "10.0.0.1", "agent")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	_, err = service.Impersonate("support-1", "not-a-uuid", "10.0.0.1", "agent")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
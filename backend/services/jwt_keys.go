@@ -0,0 +1,140 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTKey is one HMAC key in the access token keyring. Tokens signed with it
+// carry ID in their kid header.
+type JWTKey struct {
+	ID     string
+	Secret []byte
+}
+
+// SetJWTKeys replaces the keyring. keys are newest first: the first signs new
+// tokens, the rest still verify tokens they signed until they're removed, so
+// a key can be rotated without signing everyone out.
+func (a *AuthService) SetJWTKeys(keys []JWTKey) {
+	a.jwtKeys = keys
+}
+
+// signingKey returns the key new tokens are signed with
+func (a *AuthService) signingKey() JWTKey {
+	return a.jwtKeys[0]
+}
+
+// signAccessToken signs claims with the signing key, naming it in the kid
+// header
+func (a *AuthService) signAccessToken(claims *JWTClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	key := a.signingKey()
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.Secret)
+}
+
+// verificationKey picks the key for a token by its kid header. Tokens without
+// one were signed before key IDs were used, so every key is tried.
+func (a *AuthService) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		set := jwt.VerificationKeySet{}
+		for _, key := range a.jwtKeys {
+			set.Keys = append(set.Keys, key.Secret)
+		}
+		return set, nil
+	}
+
+	for _, key := range a.jwtKeys {
+		if key.ID == kid {
+			return key.Secret, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// JWTKeysFromEnv reads the keyring from JWT_KEYS, newest first, either as a
+// JSON object ({"2025-06":"secret","2025-01":"older"}) or a comma-separated
+// list of id:secret pairs. It returns nil if JWT_KEYS is unset, in which case
+// JWT_SECRET is the only key.
+func JWTKeysFromEnv() ([]JWTKey, error) {
+	value := strings.TrimSpace(os.Getenv("JWT_KEYS"))
+	if value == "" {
+		return nil, nil
+	}
+
+	var keys []JWTKey
+	var err error
+	if strings.HasPrefix(value, "{") {
+		keys, err = parseJWTKeysJSON(value)
+	} else {
+		keys, err = parseJWTKeysList(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no keys given")
+	}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if key.ID == "" || len(key.Secret) == 0 {
+			return nil, errors.New("every key needs an ID and a secret")
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		seen[key.ID] = true
+	}
+	return keys, nil
+}
+
+// parseJWTKeysJSON decodes a JSON object keeping its key order, which a map
+// would lose
+func parseJWTKeysJSON(value string) ([]JWTKey, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	var keys []JWTKey
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		id, _ := token.(string)
+
+		var secret string
+		if err := decoder.Decode(&secret); err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		keys = append(keys, JWTKey{ID: id, Secret: []byte(secret)})
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// parseJWTKeysList parses id:secret pairs separated by commas
+func parseJWTKeysList(value string) ([]JWTKey, error) {
+	var keys []JWTKey
+	for _, pair := range strings.Split(value, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("expected id:secret, got %q", pair)
+		}
+		keys = append(keys, JWTKey{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func issueTestToken(t *testing.T, service *AuthService, mock sqlmock.Sqlmock) string {
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	tokens, err := service.GenerateTokenPair(&User{ID: "user-1", TenantID: "tenant-1", Role: "user"}, "agent", "127.0.0.1", false)
+	require.NoError(t, err)
+	return tokens.AccessToken
+}
+
+func tokenKID(t *testing.T, tokenString string) interface{} {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &JWTClaims{})
+	require.NoError(t, err)
+	return token.Header["kid"]
+}
+
+func expectLiveSession(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+}
+
+func TestValidateToken_OldKeyValidUntilRemoved(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	oldKey := JWTKey{ID: "2025-01", Secret: []byte("old-secret")}
+	newKey := JWTKey{ID: "2025-06", Secret: []byte("new-secret")}
+
+	service.SetJWTKeys([]JWTKey{oldKey})
+	oldToken := issueTestToken(t, service, mock)
+	assert.Equal(t, "2025-01", tokenKID(t, oldToken))
+
+	// Rotate: new tokens use the new key, the old key still verifies
+	service.SetJWTKeys([]JWTKey{newKey, oldKey})
+	newToken := issueTestToken(t, service, mock)
+	assert.Equal(t, "2025-06", tokenKID(t, newToken))
+
+	expectLiveSession(mock)
+	_, err := service.ValidateToken(oldToken)
+	assert.NoError(t, err)
+	expectLiveSession(mock)
+	_, err = service.ValidateToken(newToken)
+	assert.NoError(t, err)
+
+	// Once the old key is removed its tokens stop validating
+	service.SetJWTKeys([]JWTKey{newKey})
+	_, err = service.ValidateToken(oldToken)
+	assert.Error(t, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidateToken_KidSelectsKey(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	// A token claiming another key's ID doesn't verify against the others
+	service.SetJWTKeys([]JWTKey{{ID: "a", Secret: []byte("secret-a")}})
+	token := issueTestToken(t, service, mock)
+	service.SetJWTKeys([]JWTKey{{ID: "b", Secret: []byte("secret-b")}, {ID: "c", Secret: []byte("secret-a")}})
+
+	_, err := service.ValidateToken(token)
+	assert.ErrorContains(t, err, `unknown signing key "a"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidateToken_TokenWithoutKidTriesEveryKey(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	// Signed with JWT_SECRET before a keyring was configured
+	legacyToken := issueTestToken(t, service, mock)
+	assert.Nil(t, tokenKID(t, legacyToken))
+
+	service.SetJWTKeys([]JWTKey{
+		{ID: "2025-06", Secret: []byte("new-secret")},
+		{ID: "legacy", Secret: []byte("test-secret")},
+	})
+	expectLiveSession(mock)
+	_, err := service.ValidateToken(legacyToken)
+	assert.NoError(t, err)
+
+	service.SetJWTKeys([]JWTKey{{ID: "2025-06", Secret: []byte("new-secret")}})
+	_, err = service.ValidateToken(legacyToken)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestJWTKeysFromEnv(t *testing.T) {
+	t.Setenv("JWT_KEYS", "")
+	keys, err := JWTKeysFromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, keys)
+
+	// JSON keeps the order it was written in, newest first
+	t.Setenv("JWT_KEYS", `{"2025-06": "new", "2025-01": "old"}`)
+	keys, err = JWTKeysFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, []JWTKey{{ID: "2025-06", Secret: []byte("new")}, {ID: "2025-01", Secret: []byte("old")}}, keys)
+
+	t.Setenv("JWT_KEYS", "2025-06:new, 2025-01:old:with:colons")
+	keys, err = JWTKeysFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, []JWTKey{{ID: "2025-06", Secret: []byte("new")}, {ID: "2025-01", Secret: []byte("old:with:colons")}}, keys)
+
+	for _, invalid := range []string{"no-secret", "a:1,a:2", ":secret", "a:", `{"a": 1}`, `{"a": "x"`} {
+		t.Setenv("JWT_KEYS", invalid)
+		_, err = JWTKeysFromEnv()
+		assert.Error(t, err, invalid)
+	}
+}
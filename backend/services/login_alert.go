@@ -0,0 +1,282 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Alerts are sent by a small fixed pool so a burst of logins can't spawn
+// unbounded goroutines; when the queue is full the alert is dropped rather
+// than delaying the login.
+const (
+	loginAlertWorkers   = 2
+	loginAlertQueueSize = 100
+)
+
+// LoginAlert describes a sign-in from a device the user hasn't used before
+type LoginAlert struct {
+	UserID      string
+	Email       string
+	PhoneNumber string // empty unless the user opted into SMS alerts with a verified phone
+	SendEmail   bool
+	DeviceInfo  string
+	IPAddress   string
+	Location    string
+	Time        time.Time
+}
+
+// LoginNotifier tells a user about a sign-in from a new device
+type LoginNotifier interface {
+	NotifyNewLogin(alert LoginAlert) error
+}
+
+// SMSSender delivers a plain-text SMS
+type SMSSender interface {
+	SendMessage(phoneNumber, message string) error
+}
+
+// IPLocator turns an IP address into an approximate, human-readable location
+type IPLocator interface {
+	Locate(ipAddress string) string
+}
+
+// MessageLoginNotifier sends new-login alerts by email and, when the alert
+// carries a phone number, SMS
+type MessageLoginNotifier struct {
+	email EmailSender
+	sms   SMSSender
+}
+
+// NewLoginNotifier creates a notifier; sms may be nil to disable SMS alerts
+func NewLoginNotifier(email EmailSender, sms SMSSender) *MessageLoginNotifier {
+	return &MessageLoginNotifier{email: email, sms: sms}
+}
+
+// NotifyNewLogin sends the alert on every channel the user enabled
+func (n *MessageLoginNotifier) NotifyNewLogin(alert LoginAlert) error {
+	var errs []error
+
+	if alert.SendEmail && n.email != nil {
+		body := fmt.Sprintf(
+			"We noticed a new sign-in to your ArvFinder account.\n\n"+
+				"Time: %s\nDevice: %s\nIP address: %s\nApproximate location: %s\n\n"+
+				"If this was you, no action is needed. If not, change your password "+
+				"and sign out of your other sessions from %s/settings/security.",
+			alert.Time.UTC().Format(time.RFC1123), alert.DeviceInfo, alert.IPAddress, alert.Location, appBaseURL(),
+		)
+		if err := n.email.Send(alert.Email, "New sign-in to your ArvFinder account", body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if alert.PhoneNumber != "" && n.sms != nil {
+		message := fmt.Sprintf("ArvFinder: new sign-in to your account from %s (%s). Not you? Change your password now.",
+			alert.Location, alert.IPAddress)
+		if err := n.sms.SendMessage(alert.PhoneNumber, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// HTTPIPLocator looks up IP locations with a JSON geolocation API that
+// returns city, region and country_name fields (e.g. ipapi.co)
+type HTTPIPLocator struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+// NewHTTPIPLocator creates a locator; urlTemplate contains one %s for the IP
+func NewHTTPIPLocator(urlTemplate string) *HTTPIPLocator {
+	return &HTTPIPLocator{
+		urlTemplate: urlTemplate,
+		client:      &http.Client{Timeout: 3 * time.Second},
+	}
+}
+
+// Locate returns "City, Region, Country", or "Unknown location" when the
+// lookup fails
+func (l *HTTPIPLocator) Locate(ipAddress string) string {
+	if local := localIPLocation(ipAddress); local != "" {
+		return local
+	}
+
+	resp, err := l.client.Get(fmt.Sprintf(l.urlTemplate, url.PathEscape(ipAddress)))
+	if err != nil {
+		return "Unknown location"
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "Unknown location"
+	}
+
+	var result struct {
+		City    string `json:"city"`
+		Region  string `json:"region"`
+		Country string `json:"country_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "Unknown location"
+	}
+
+	var parts []string
+	for _, part := range []string{result.City, result.Region, result.Country} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return "Unknown location"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// NewIPLocatorFromEnv returns an HTTP locator when GEOIP_LOOKUP_URL is set
+// and nil otherwise, in which case only local addresses are described
+func NewIPLocatorFromEnv() IPLocator {
+	urlTemplate := os.Getenv("GEOIP_LOOKUP_URL")
+	if urlTemplate == "" {
+		return nil
+	}
+	return NewHTTPIPLocator(urlTemplate)
+}
+
+// localIPLocation describes addresses that have no public location
+func localIPLocation(ipAddress string) string {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return "Unknown location"
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() {
+		return "Local network"
+	}
+	return ""
+}
+
+// loginEvent is a newly created session waiting to be checked
+type loginEvent struct {
+	userID           string
+	fingerprint      string
+	refreshTokenHash string
+	deviceInfo       string
+	ipAddress        string
+	at               time.Time
+}
+
+// loginAlerter checks new sessions against the user's earlier ones in the
+// background and notifies them about unfamiliar devices
+type loginAlerter struct {
+	db       *sql.DB
+	notifier LoginNotifier
+	locator  IPLocator
+	jobs     chan loginEvent
+	done     chan struct{}
+}
+
+func newLoginAlerter(db *sql.DB, notifier LoginNotifier, locator IPLocator) *loginAlerter {
+	l := &loginAlerter{
+		db:       db,
+		notifier: notifier,
+		locator:  locator,
+		jobs:     make(chan loginEvent, loginAlertQueueSize),
+		done:     make(chan struct{}),
+	}
+
+	finished := make(chan struct{}, loginAlertWorkers)
+	for i := 0; i < loginAlertWorkers; i++ {
+		go func() {
+			for event := range l.jobs {
+				if err := l.process(event); err != nil {
+					fmt.Printf("Failed to send new login alert for user %s: %v\n", event.userID, err)
+				}
+			}
+			finished <- struct{}{}
+		}()
+	}
+	go func() {
+		for i := 0; i < loginAlertWorkers; i++ {
+			<-finished
+		}
+		close(l.done)
+	}()
+
+	return l
+}
+
+// enqueue schedules a check without ever blocking the caller
+func (l *loginAlerter) enqueue(event loginEvent) {
+	select {
+	case l.jobs <- event:
+	default:
+		fmt.Printf("Login alert queue full, dropping alert for user %s\n", event.userID)
+	}
+}
+
+// stop waits for queued alerts to be sent. enqueue must not be called after.
+func (l *loginAlerter) stop() {
+	close(l.jobs)
+	<-l.done
+}
+
+// process notifies the user if the session's device fingerprint (which
+// includes the IP) hasn't been seen on any of their other sessions. A user's
+// very first session is never reported.
+func (l *loginAlerter) process(event loginEvent) error {
+	var (
+		alert                      LoginAlert
+		phone                      string
+		phoneVerified, smsEnabled  bool
+		hasOtherSessions, seenHere bool
+	)
+	err := l.db.QueryRow(`
+		SELECT u.email, COALESCE(u.phone_number, ''), u.phone_verified,
+		       u.login_alert_email, u.login_alert_sms,
+		       EXISTS(SELECT 1 FROM user_sessions s
+		              WHERE s.user_id = u.id AND s.refresh_token_hash <> $2),
+		       EXISTS(SELECT 1 FROM user_sessions s
+		              WHERE s.user_id = u.id AND s.refresh_token_hash <> $2 AND s.device_fingerprint = $3)
+		FROM users u WHERE u.id = $1
+	`, event.userID, event.refreshTokenHash, event.fingerprint).Scan(
+		&alert.Email, &phone, &phoneVerified, &alert.SendEmail, &smsEnabled,
+		&hasOtherSessions, &seenHere,
+	)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check login history: %w", err)
+	}
+
+	if !hasOtherSessions || seenHere {
+		return nil
+	}
+	if smsEnabled && phoneVerified {
+		alert.PhoneNumber = phone
+	}
+	if !alert.SendEmail && alert.PhoneNumber == "" {
+		return nil
+	}
+
+	alert.UserID = event.userID
+	alert.DeviceInfo = event.deviceInfo
+	alert.IPAddress = event.ipAddress
+	alert.Time = event.at
+	alert.Location = localIPLocation(event.ipAddress)
+	if alert.Location == "" {
+		alert.Location = "Unknown location"
+		if l.locator != nil {
+			alert.Location = l.locator.Locate(event.ipAddress)
+		}
+	}
+
+	return l.notifier.NotifyNewLogin(alert)
+}
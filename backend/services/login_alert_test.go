@@ -0,0 +1,162 @@
+package services
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLoginNotifier struct {
+	mu      sync.Mutex
+	alerts  []LoginAlert
+	release chan struct{} // when set, NotifyNewLogin blocks until closed
+}
+
+func (r *recordingLoginNotifier) NotifyNewLogin(alert LoginAlert) error {
+	if r.release != nil {
+		<-r.release
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+type fixedLocator string
+
+func (f fixedLocator) Locate(string) string { return string(f) }
+
+type recordingSMSSender struct {
+	to, message string
+}
+
+func (r *recordingSMSSender) SendMessage(phoneNumber, message string) error {
+	r.to, r.message = phoneNumber, message
+	return nil
+}
+
+var loginHistoryColumns = []string{
+	"email", "phone_number", "phone_verified", "login_alert_email", "login_alert_sms",
+	"has_other_sessions", "seen_here",
+}
+
+func TestLoginAlerts_NewDeviceNotifiesWithoutBlockingLogin(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	notifier := &recordingLoginNotifier{release: make(chan struct{})}
+	service.EnableLoginAlerts(notifier, fixedLocator("Austin, Texas, United States"))
+
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users u WHERE u.id = \$1`).
+		WithArgs("user-1", sqlmock.AnyArg(), service.createDeviceFingerprint("agent", "203.0.113.7")).
+		WillReturnRows(sqlmock.NewRows(loginHistoryColumns).
+			AddRow("user@example.com", "+15555550100", true, true, false, true, false))
+
+	// The notifier is stuck, yet the login still completes
+	_, err := service.GenerateTokenPair(&User{ID: "user-1", TenantID: "tenant-1", Role: "user"}, "agent", "203.0.113.7", false)
+	require.NoError(t, err)
+
+	close(notifier.release)
+	service.loginAlerts.stop()
+
+	require.Len(t, notifier.alerts, 1)
+	alert := notifier.alerts[0]
+	assert.Equal(t, "user@example.com", alert.Email)
+	assert.True(t, alert.SendEmail)
+	assert.Empty(t, alert.PhoneNumber, "SMS alerts are opt-in")
+	assert.Equal(t, "203.0.113.7", alert.IPAddress)
+	assert.Equal(t, "Austin, Texas, United States", alert.Location)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginAlerts_KnownDeviceIsQuiet(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	notifier := &recordingLoginNotifier{}
+	service.EnableLoginAlerts(notifier, nil)
+
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users u WHERE u.id = \$1`).
+		WillReturnRows(sqlmock.NewRows(loginHistoryColumns).
+			AddRow("user@example.com", "", false, true, false, true, true))
+
+	_, err := service.GenerateTokenPair(&User{ID: "user-1"}, "agent", "203.0.113.7", false)
+	require.NoError(t, err)
+	service.loginAlerts.stop()
+
+	assert.Empty(t, notifier.alerts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginAlerts_FirstSessionIsQuiet(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	notifier := &recordingLoginNotifier{}
+	service.EnableLoginAlerts(notifier, nil)
+
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users u WHERE u.id = \$1`).
+		WillReturnRows(sqlmock.NewRows(loginHistoryColumns).
+			AddRow("user@example.com", "", false, true, false, false, false))
+
+	_, err := service.GenerateTokenPair(&User{ID: "user-1"}, "agent", "203.0.113.7", false)
+	require.NoError(t, err)
+	service.loginAlerts.stop()
+
+	assert.Empty(t, notifier.alerts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginAlerts_RespectsUserSettings(t *testing.T) {
+	service, mock := newTestAuthService(t)
+	notifier := &recordingLoginNotifier{}
+	service.EnableLoginAlerts(notifier, nil)
+	// The second login's INSERT can race the first alert's history check
+	mock.MatchExpectationsInOrder(false)
+
+	// Email off, SMS on with a verified phone
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users u WHERE u.id = \$1`).
+		WillReturnRows(sqlmock.NewRows(loginHistoryColumns).
+			AddRow("user@example.com", "+15555550100", true, false, true, true, false))
+	// Both off
+	mock.ExpectExec(`INSERT INTO user_sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users u WHERE u.id = \$1`).
+		WillReturnRows(sqlmock.NewRows(loginHistoryColumns).
+			AddRow("user@example.com", "+15555550100", true, false, false, true, false))
+
+	_, err := service.GenerateTokenPair(&User{ID: "user-1"}, "agent", "10.0.0.5", false)
+	require.NoError(t, err)
+	_, err = service.GenerateTokenPair(&User{ID: "user-1"}, "agent", "10.0.0.6", false)
+	require.NoError(t, err)
+	service.loginAlerts.stop()
+
+	require.Len(t, notifier.alerts, 1)
+	assert.False(t, notifier.alerts[0].SendEmail)
+	assert.Equal(t, "+15555550100", notifier.alerts[0].PhoneNumber)
+	assert.Equal(t, "Local network", notifier.alerts[0].Location)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageLoginNotifier_SendsEnabledChannels(t *testing.T) {
+	email := &recordingEmailSender{}
+	sms := &recordingSMSSender{}
+	notifier := NewLoginNotifier(email, sms)
+
+	err := notifier.NotifyNewLogin(LoginAlert{
+		Email:       "user@example.com",
+		PhoneNumber: "+15555550100",
+		SendEmail:   true,
+		DeviceInfo:  "Firefox on Linux",
+		IPAddress:   "203.0.113.7",
+		Location:    "Austin, Texas, United States",
+	})
+
+	require.NoError(t, err)
+	require.Len(t, email.sent, 1)
+	assert.Equal(t, "user@example.com", email.sent[0].To)
+	assert.Contains(t, email.sent[0].Body, "Austin, Texas, United States")
+	assert.Contains(t, email.sent[0].Body, "Firefox on Linux")
+	assert.Equal(t, "+15555550100", sms.to)
+	assert.Contains(t, sms.message, "203.0.113.7")
+}
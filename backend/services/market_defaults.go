@@ -0,0 +1,183 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Market defaults errors
+var (
+	ErrMarketDefaultsNotOnPlan = errors.New("market defaults aren't included in this plan")
+	ErrInvalidMarket           = errors.New("market must be a two-letter state or a zip code prefix")
+)
+
+var (
+	stateMarketPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	zipMarketPattern   = regexp.MustCompile(`^[0-9]{1,5}$`)
+)
+
+// MarketDefaults are the vacancy and credit loss a tenant's deals in a
+// market assume when a calculation leaves them out. Market is empty for
+// every deal, a state such as "OH", or a zip code prefix such as "441".
+type MarketDefaults struct {
+	Market         string   `json:"market" binding:"max=5"`
+	VacancyRate    *float64 `json:"vacancy_rate" binding:"omitempty,min=0,max=100"`     // percentage
+	CreditLossRate *float64 `json:"credit_loss_rate" binding:"omitempty,min=0,max=100"` // percentage
+}
+
+// normalizeMarket returns market upper-cased, or ErrInvalidMarket when it's
+// neither empty, a state nor a zip code prefix
+func normalizeMarket(market string) (string, error) {
+	market = strings.ToUpper(strings.TrimSpace(market))
+	if market != "" && !stateMarketPattern.MatchString(market) && !zipMarketPattern.MatchString(market) {
+		return "", ErrInvalidMarket
+	}
+	return market, nil
+}
+
+// ResolveMarketDefaults picks the defaults for a deal in state and zipCode
+// out of a tenant's. Each rate comes from the most specific market that
+// sets it: the longest matching zip prefix, then the state, then the
+// tenant-wide defaults.
+func ResolveMarketDefaults(defaults []MarketDefaults, state, zipCode string) MarketDefaults {
+	state = strings.ToUpper(strings.TrimSpace(state))
+	zipCode = strings.TrimSpace(zipCode)
+
+	resolved := MarketDefaults{}
+	vacancyRank, creditLossRank := -1, -1
+	for _, d := range defaults {
+		rank := marketRank(d.Market, state, zipCode)
+		if rank < 0 {
+			continue
+		}
+		if d.VacancyRate != nil && rank > vacancyRank {
+			resolved.VacancyRate, vacancyRank = d.VacancyRate, rank
+		}
+		if d.CreditLossRate != nil && rank > creditLossRank {
+			resolved.CreditLossRate, creditLossRank = d.CreditLossRate, rank
+		}
+	}
+	return resolved
+}
+
+// marketRank is how specifically market matches a deal in state and
+// zipCode, or -1 when it doesn't: 0 for every deal, 1 for the state and
+// more for longer zip prefixes
+func marketRank(market, state, zipCode string) int {
+	switch {
+	case market == "":
+		return 0
+	case stateMarketPattern.MatchString(market):
+		if market == state {
+			return 1
+		}
+	case zipCode != "" && strings.HasPrefix(zipCode, market):
+		return 1 + len(market)
+	}
+	return -1
+}
+
+// setMarketDefaults fills in the vacancy and credit loss req left out from
+// the caller's market defaults, if it has any
+func setMarketDefaults(req *ArvRequest, result *ArvResult) {
+	if req.MarketDefaults == nil {
+		return
+	}
+	if req.VacancyRate == 0 && req.MarketDefaults.VacancyRate != nil {
+		req.VacancyRate = *req.MarketDefaults.VacancyRate
+		result.assume("vacancy_rate", req.VacancyRate, "Your default vacancy for this market")
+	}
+	if req.CreditLossRate == 0 && req.MarketDefaults.CreditLossRate != nil {
+		req.CreditLossRate = *req.MarketDefaults.CreditLossRate
+		result.assume("credit_loss_rate", req.CreditLossRate, "Your default credit loss for this market")
+	}
+}
+
+// MarketDefaultsRepository stores tenants' market defaults, alongside their
+// comp adjustment settings
+type MarketDefaultsRepository struct {
+	db *sql.DB
+}
+
+// NewMarketDefaultsRepository creates a new market defaults repository
+func NewMarketDefaultsRepository(db *sql.DB) *MarketDefaultsRepository {
+	return &MarketDefaultsRepository{db: db}
+}
+
+// List returns every market tenantID has set defaults for
+func (r *MarketDefaultsRepository) List(tenantID string) ([]MarketDefaults, error) {
+	rows, err := r.db.Query(`
+		SELECT market, vacancy_rate, credit_loss_rate
+		FROM comp_adjustment_settings
+		WHERE tenant_id = $1 AND (vacancy_rate IS NOT NULL OR credit_loss_rate IS NOT NULL)
+		ORDER BY market
+	`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load market defaults: %w", err)
+	}
+	defer rows.Close()
+
+	defaults := []MarketDefaults{}
+	for rows.Next() {
+		var d MarketDefaults
+		var vacancy, creditLoss sql.NullFloat64
+		if err := rows.Scan(&d.Market, &vacancy, &creditLoss); err != nil {
+			return nil, fmt.Errorf("failed to scan market defaults: %w", err)
+		}
+		if vacancy.Valid {
+			d.VacancyRate = &vacancy.Float64
+		}
+		if creditLoss.Valid {
+			d.CreditLossRate = &creditLoss.Float64
+		}
+		defaults = append(defaults, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load market defaults: %w", err)
+	}
+	return defaults, nil
+}
+
+// Resolve returns the defaults tenantID's deals in state and zipCode
+// assume
+func (r *MarketDefaultsRepository) Resolve(tenantID, state, zipCode string) (MarketDefaults, error) {
+	defaults, err := r.List(tenantID)
+	if err != nil {
+		return MarketDefaults{}, err
+	}
+	return ResolveMarketDefaults(defaults, state, zipCode), nil
+}
+
+// Set replaces tenantID's defaults for a market, on plans that include
+// market defaults. A rate left out clears it, so the market falls back to a
+// broader one.
+func (r *MarketDefaultsRepository) Set(tenantID string, defaults MarketDefaults) (MarketDefaults, error) {
+	market, err := normalizeMarket(defaults.Market)
+	if err != nil {
+		return MarketDefaults{}, err
+	}
+	defaults.Market = market
+
+	var tier string
+	err = r.db.QueryRow(`SELECT subscription_tier FROM tenants WHERE id = $1`, tenantID).Scan(&tier)
+	if err != nil {
+		return MarketDefaults{}, fmt.Errorf("failed to load tenant plan: %w", err)
+	}
+	if !subscriptionPlans()[SubscriptionTier(tier)].MarketDefaults {
+		return MarketDefaults{}, ErrMarketDefaultsNotOnPlan
+	}
+
+	_, err = r.db.Exec(`
+		INSERT INTO comp_adjustment_settings (tenant_id, market, vacancy_rate, credit_loss_rate)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, market) DO UPDATE
+		SET vacancy_rate = EXCLUDED.vacancy_rate, credit_loss_rate = EXCLUDED.credit_loss_rate, updated_at = NOW()
+	`, tenantID, defaults.Market, defaults.VacancyRate, defaults.CreditLossRate)
+	if err != nil {
+		return MarketDefaults{}, fmt.Errorf("failed to save market defaults: %w", err)
+	}
+	return defaults, nil
+}
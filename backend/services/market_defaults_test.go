@@ -0,0 +1,76 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rate(r float64) *float64 {
+	return &r
+}
+
+func TestResolveMarketDefaults(t *testing.T) {
+	defaults := []MarketDefaults{
+		{Market: "", VacancyRate: rate(6), CreditLossRate: rate(1)},
+		{Market: "OH", VacancyRate: rate(9)},
+		{Market: "441", VacancyRate: rate(10), CreditLossRate: rate(2)},
+		{Market: "4410", CreditLossRate: rate(3)},
+	}
+
+	// Each rate comes from the most specific market that sets it
+	resolved := ResolveMarketDefaults(defaults, "OH", "44102")
+	assert.Equal(t, 10.0, *resolved.VacancyRate)
+	assert.Equal(t, 3.0, *resolved.CreditLossRate)
+
+	resolved = ResolveMarketDefaults(defaults, "oh", "43215")
+	assert.Equal(t, 9.0, *resolved.VacancyRate)
+	assert.Equal(t, 1.0, *resolved.CreditLossRate)
+
+	resolved = ResolveMarketDefaults(defaults, "PA", "")
+	assert.Equal(t, 6.0, *resolved.VacancyRate)
+	assert.Equal(t, 1.0, *resolved.CreditLossRate)
+
+	resolved = ResolveMarketDefaults(nil, "OH", "44102")
+	assert.Nil(t, resolved.VacancyRate)
+	assert.Nil(t, resolved.CreditLossRate)
+}
+
+func TestNormalizeMarket(t *testing.T) {
+	for market, want := range map[string]string{"": "", " oh ": "OH", "441": "441", "44102": "44102"} {
+		got, err := normalizeMarket(market)
+		require.NoError(t, err, market)
+		assert.Equal(t, want, got)
+	}
+	for _, market := range []string{"OHI", "4410a", "441022"} {
+		_, err := normalizeMarket(market)
+		assert.ErrorIs(t, err, ErrInvalidMarket, market)
+	}
+}
+
+func TestCalculateARV_MarketDefaults(t *testing.T) {
+	service := NewArvService()
+	req := ArvRequest{PurchasePrice: 150000, RehabCost: 30000, ARV: 250000, MonthlyRent: 2000}
+	req.MarketDefaults = &MarketDefaults{VacancyRate: rate(10), CreditLossRate: rate(2)}
+
+	result := service.CalculateARV(req)
+
+	assert.Equal(t, 10.0, result.VacancyRate)
+	assert.Equal(t, 2.0, result.CreditLossRate)
+	assert.Equal(t, 21168.0, result.EffectiveIncome)
+	assert.Contains(t, result.Assumptions, Assumption{Field: "vacancy_rate", Value: 10, Heuristic: "Your default vacancy for this market"})
+	for _, warning := range result.Warnings {
+		assert.NotEqual(t, WarnVacancyDefaulted, warning.Code)
+	}
+
+	// What the request gives wins, and the built-in vacancy is the last
+	// resort
+	req.VacancyRate = 5
+	assert.Equal(t, 5.0, service.CalculateARV(req).VacancyRate)
+	req.VacancyRate = 0
+	req.MarketDefaults = &MarketDefaults{CreditLossRate: rate(2)}
+	result = service.CalculateARV(req)
+	assert.Equal(t, 8.0, result.VacancyRate)
+	findNotice(t, result.Warnings, WarnVacancyDefaulted)
+}
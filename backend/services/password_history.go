@@ -0,0 +1,88 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrPasswordReused is returned when a new password matches one of the user's
+// recent passwords
+var ErrPasswordReused = errors.New("password was used recently")
+
+// defaultPasswordHistory is how many recent passwords, including the current
+// one, can't be reused
+const defaultPasswordHistory = 5
+
+// SetPasswordHistory changes how many recent passwords, including the current
+// one, a user can't reuse. 1 only blocks keeping the current password.
+func (a *AuthService) SetPasswordHistory(n int) {
+	a.passwordHistory = n
+}
+
+// checkPasswordReuse returns ErrPasswordReused if password matches the
+// user's current hash or one of their previous ones still in history
+func (a *AuthService) checkPasswordReuse(tx *sql.Tx, userID, currentHash, password string) error {
+	if a.VerifyPassword(password, currentHash) {
+		return ErrPasswordReused
+	}
+
+	rows, err := tx.Query(`
+		SELECT password_hash FROM password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, a.previousPasswordsKept())
+	if err != nil {
+		return fmt.Errorf("failed to load password history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return err
+		}
+		if a.VerifyPassword(password, hash) {
+			return ErrPasswordReused
+		}
+	}
+	return rows.Err()
+}
+
+// recordPasswordHistory stores the hash being replaced and prunes entries
+// that have rotated out of the history
+func (a *AuthService) recordPasswordHistory(tx *sql.Tx, userID, oldHash string) error {
+	kept := a.previousPasswordsKept()
+	if kept > 0 {
+		_, err := tx.Exec(`
+			INSERT INTO password_history (user_id, password_hash) VALUES ($1, $2)
+		`, userID, oldHash)
+		if err != nil {
+			return fmt.Errorf("failed to record password history: %w", err)
+		}
+	}
+
+	_, err := tx.Exec(`
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC
+			LIMIT $2
+		)
+	`, userID, kept)
+	if err != nil {
+		return fmt.Errorf("failed to prune password history: %w", err)
+	}
+	return nil
+}
+
+// previousPasswordsKept is the history size excluding the current password,
+// which lives on the user row
+func (a *AuthService) previousPasswordsKept() int {
+	if a.passwordHistory < 1 {
+		return 0
+	}
+	return a.passwordHistory - 1
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectPasswordHistory mocks the reuse check's history query returning
+// hashes, newest first
+func expectPasswordHistory(mock sqlmock.Sqlmock, hashes ...string) {
+	rows := sqlmock.NewRows([]string{"password_hash"})
+	for _, hash := range hashes {
+		rows.AddRow(hash)
+	}
+	mock.ExpectQuery(`SELECT password_hash FROM password_history`).
+		WithArgs("user-1", defaultPasswordHistory-1).
+		WillReturnRows(rows)
+}
+
+// expectHistoryRecorded mocks storing the replaced hash and pruning the rest
+func expectHistoryRecorded(mock sqlmock.Sqlmock, oldHash driver.Value) {
+	mock.ExpectExec(`INSERT INTO password_history`).
+		WithArgs("user-1", oldHash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM password_history`).
+		WithArgs("user-1", defaultPasswordHistory-1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// passwordHistoryFixture has the user's last five passwords, oldest first;
+// the newest is the current one
+func passwordHistoryFixture(t *testing.T, service *AuthService) ([]string, []string) {
+	passwords := []string{"Passw0rd!1", "Passw0rd!2", "Passw0rd!3", "Passw0rd!4", "Passw0rd!5"}
+	hashes := make([]string, len(passwords))
+	for i, password := range passwords {
+		hashes[i] = hashForTest(t, service, password)
+	}
+	return passwords, hashes
+}
+
+func expectCurrentPassword(mock sqlmock.Sqlmock, hash string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT password_hash FROM users`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(hash))
+}
+
+func TestChangePassword_RecentPasswordRejected(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewPasswordResetService(authService.db, authService, &recordingEmailSender{})
+	passwords, hashes := passwordHistoryFixture(t, authService)
+
+	expectCurrentPassword(mock, hashes[4])
+	expectPasswordHistory(mock, hashes[3], hashes[2], hashes[1], hashes[0])
+	mock.ExpectRollback()
+
+	err := service.ChangePassword("user-1", "jti-1", passwords[4], passwords[2])
+
+	assert.ErrorIs(t, err, ErrPasswordReused)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing was written")
+}
+
+func TestChangePassword_CurrentPasswordRejected(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewPasswordResetService(authService.db, authService, &recordingEmailSender{})
+	passwords, hashes := passwordHistoryFixture(t, authService)
+
+	expectCurrentPassword(mock, hashes[4])
+	mock.ExpectRollback()
+
+	err := service.ChangePassword("user-1", "jti-1", passwords[4], passwords[4])
+
+	assert.ErrorIs(t, err, ErrPasswordReused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangePassword_PasswordAllowedOnceRotatedOut(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewPasswordResetService(authService.db, authService, &recordingEmailSender{})
+	passwords, hashes := passwordHistoryFixture(t, authService)
+	sixth := hashForTest(t, authService, "Passw0rd!6")
+
+	// After a sixth password the oldest has been pruned from history, so
+	// only #2-#5 and the current one are checked
+	expectCurrentPassword(mock, sixth)
+	expectPasswordHistory(mock, hashes[4], hashes[3], hashes[2], hashes[1])
+	mock.ExpectExec(`UPDATE users SET password_hash`).
+		WithArgs(sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistoryRecorded(mock, sixth)
+	mock.ExpectExec(`UPDATE user_sessions SET revoked = TRUE`).
+		WithArgs("user-1", "jti-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	require.NoError(t, service.ChangePassword("user-1", "jti-1", "Passw0rd!6", passwords[0]))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangePassword_WrongCurrentPassword(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewPasswordResetService(authService.db, authService, &recordingEmailSender{})
+	_, hashes := passwordHistoryFixture(t, authService)
+
+	expectCurrentPassword(mock, hashes[4])
+	mock.ExpectRollback()
+
+	err := service.ChangePassword("user-1", "jti-1", "Wr0ng!Password", "N3w!Password")
+
+	assert.ErrorIs(t, err, ErrIncorrectPassword)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetPassword_RecentPasswordRejected(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewPasswordResetService(authService.db, authService, &recordingEmailSender{})
+	passwords, hashes := passwordHistoryFixture(t, authService)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE password_reset_token = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password_reset_expires_at", "password_hash"}).
+			AddRow("user-1", time.Now().Add(30*time.Minute), hashes[4]))
+	expectPasswordHistory(mock, hashes[3], hashes[2], hashes[1], hashes[0])
+	mock.ExpectRollback()
+
+	_, err := service.ResetPassword("reset-token", passwords[2])
+
+	assert.ErrorIs(t, err, ErrPasswordReused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Password reset errors
+var (
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	ErrResetTokenExpired = errors.New("password reset token expired")
+)
+
+// PasswordResetService handles the forgot-password and reset-password flow,
+// and password changes by signed-in users
+type PasswordResetService struct {
+	db          *sql.DB
+	authService *AuthService
+	emailSender EmailSender
+	tokenTTL    time.Duration
+}
+
+// NewPasswordResetService creates a new password reset service
+func NewPasswordResetService(db *sql.DB, authService *AuthService, emailSender EmailSender) *PasswordResetService {
+	return &PasswordResetService{
+		db:          db,
+		authService: authService,
+		emailSender: emailSender,
+		tokenTTL:    time.Hour,
+	}
+}
+
+// RequestReset issues a reset token for the account with the given email and
+// emails it. Unknown or inactive accounts are ignored silently so callers can
+// always answer the same way and not leak which emails are registered.
+func (p *PasswordResetService) RequestReset(email string) error {
+	var userID string
+	err := p.db.QueryRow(`
+		SELECT id FROM users WHERE email = $1 AND is_active = TRUE
+	`, email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(p.tokenTTL)
+	_, err = p.db.Exec(`
+		UPDATE users 
+		SET password_reset_token = $1, password_reset_expires_at = $2, updated_at = NOW()
+		WHERE id = $3
+	`, hashToken(token), expiresAt, userID)
+	if err != nil {
+		return fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	body := fmt.Sprintf(
+		"We received a request to reset your ArvFinder password.\n\n"+
+			"Reset it here: %s/reset-password?token=%s\n\n"+
+			"This link expires in 1 hour. If you didn't request this, you can ignore this email.",
+		appBaseURL(), token,
+	)
+	if err := p.emailSender.Send(email, "Reset your ArvFinder password", body); err != nil {
+		return fmt.Errorf("failed to send reset email: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password for the account owning token, consumes
+// the token and revokes every existing session. It returns the user ID, or
+// ErrPasswordReused if the password is one of the user's recent ones.
+func (p *PasswordResetService) ResetPassword(token, newPassword string) (string, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID, currentHash string
+	var expiresAt *time.Time
+	err = tx.QueryRow(`
+		SELECT id, password_reset_expires_at, password_hash FROM users 
+		WHERE password_reset_token = $1
+		FOR UPDATE
+	`, hashToken(token)).Scan(&userID, &expiresAt, &currentHash)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up reset token: %w", err)
+	}
+
+	if expiresAt == nil || time.Now().After(*expiresAt) {
+		return "", ErrResetTokenExpired
+	}
+
+	if err := p.authService.checkPasswordReuse(tx, userID, currentHash, newPassword); err != nil {
+		return "", err
+	}
+
+	salt, err := p.authService.GenerateSecureSalt()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	passwordHash := p.authService.HashPassword(newPassword, salt)
+
+	_, err = tx.Exec(`
+		UPDATE users 
+		SET password_hash = $1,
+		    password_reset_token = NULL, password_reset_expires_at = NULL,
+		    failed_login_attempts = 0, locked_until = NULL, updated_at = NOW()
+		WHERE id = $2
+	`, passwordHash, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to update password: %w", err)
+	}
+	if err := p.authService.recordPasswordHistory(tx, userID, currentHash); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit password reset: %w", err)
+	}
+
+	if err := p.authService.RevokeAllUserSessions(userID); err != nil {
+		return userID, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return userID, nil
+}
+
+// ChangePassword replaces a signed-in user's password after confirming their
+// current one. Recent passwords are refused with ErrPasswordReused. Every
+// session except the one making the change (currentJTI) is revoked.
+func (p *PasswordResetService) ChangePassword(userID, currentJTI, currentPassword, newPassword string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var currentHash string
+	err = tx.QueryRow(`
+		SELECT password_hash FROM users
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, userID).Scan(&currentHash)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	if !p.authService.VerifyPassword(currentPassword, currentHash) {
+		return ErrIncorrectPassword
+	}
+	if err := p.authService.checkPasswordReuse(tx, userID, currentHash, newPassword); err != nil {
+		return err
+	}
+
+	salt, err := p.authService.GenerateSecureSalt()
+	if err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2
+	`, p.authService.HashPassword(newPassword, salt), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if err := p.authService.recordPasswordHistory(tx, userID, currentHash); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_sessions SET revoked = TRUE
+		WHERE user_id = $1 AND access_token_jti <> $2 AND revoked = FALSE
+	`, userID, currentJTI)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit password change: %w", err)
+	}
+	p.authService.forgetSessions(userID)
+	return nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingEmailSender captures outgoing email for assertions
+type recordingEmailSender struct {
+	mu   sync.Mutex
+	sent []sentEmail
+}
+
+type sentEmail struct {
+	To, Subject, Body string
+}
+
+func (r *recordingEmailSender) Send(to, subject, body string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, sentEmail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// capturedArg is a sqlmock argument that records the value it was matched against
+type capturedArg struct {
+	value driver.Value
+}
+
+func (c *capturedArg) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func TestRequestReset_SendsTokenAndStoresHash(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	sender := &recordingEmailSender{}
+	service := NewPasswordResetService(authService.db, authService, sender)
+	storedHash := &capturedArg{}
+
+	mock.ExpectQuery(`SELECT id FROM users`).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-1"))
+	mock.ExpectExec(`UPDATE users`).
+		WithArgs(storedHash, sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, service.RequestReset("user@example.com"))
+	require.Len(t, sender.sent, 1)
+	assert.Equal(t, "user@example.com", sender.sent[0].To)
+
+	body := sender.sent[0].Body
+	token := body[strings.Index(body, "token=")+len("token="):]
+	token = strings.Fields(token)[0]
+	assert.Equal(t, hashToken(token), storedHash.value)
+	assert.NotContains(t, storedHash.value, token)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequestReset_UnknownEmailSendsNothing(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	sender := &recordingEmailSender{}
+	service := NewPasswordResetService(authService.db, authService, sender)
+
+	mock.ExpectQuery(`SELECT id FROM users`).
+		WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	assert.NoError(t, service.RequestReset("nobody@example.com"))
+	assert.Empty(t, sender.sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetPassword_ValidTokenRevokesSessions(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewPasswordResetService(authService.db, authService, &recordingEmailSender{})
+	newHash := &capturedArg{}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE password_reset_token = \$1`).
+		WithArgs(hashToken("reset-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password_reset_expires_at", "password_hash"}).
+			AddRow("user-1", time.Now().Add(30*time.Minute), hashForTest(t, authService, "0ld!Password")))
+	expectPasswordHistory(mock)
+	mock.ExpectExec(`password_reset_token = NULL`).
+		WithArgs(newHash, "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectHistoryRecorded(mock, sqlmock.AnyArg())
+	mock.ExpectCommit()
+	mock.ExpectExec(`UPDATE user_sessions`).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	userID, err := service.ResetPassword("reset-token", "N3w!Password")
+
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	assert.True(t, authService.VerifyPassword("N3w!Password", newHash.value.(string)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetPassword_ExpiredToken(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewPasswordResetService(authService.db, authService, &recordingEmailSender{})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE password_reset_token = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password_reset_expires_at", "password_hash"}).
+			AddRow("user-1", time.Now().Add(-time.Minute), hashForTest(t, authService, "0ld!Password")))
+	mock.ExpectRollback()
+
+	_, err := service.ResetPassword("reset-token", "N3w!Password")
+
+	assert.ErrorIs(t, err, ErrResetTokenExpired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
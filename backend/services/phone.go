@@ -0,0 +1,88 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// invalidPhoneNumberMessage tells users which formats are accepted
+const invalidPhoneNumberMessage = "Enter a valid phone number, e.g. (303) 555-0147, or +44 20 7946 0958 outside North America"
+
+// defaultCountryCode is assumed for numbers entered without one. The
+// default is the North American Numbering Plan, which most users are on.
+var defaultCountryCode = "1"
+
+// SetDefaultPhoneCountryCode sets the country calling code assumed for
+// numbers entered without one, e.g. "1" or "+44"
+func SetDefaultPhoneCountryCode(code string) error {
+	code = strings.TrimPrefix(strings.TrimSpace(code), "+")
+	if code == "" || len(code) > 3 || code[0] == '0' || !allDigits(code) {
+		return fmt.Errorf("invalid country calling code %q", code)
+	}
+	defaultCountryCode = code
+	return nil
+}
+
+// NormalizePhoneNumber converts a phone number as users type it, such as
+// "(303) 555-0147", "303.555.0147" or "+44 20 7946 0958", to E.164, e.g.
+// "+13035550147". Numbers without a "+" or international "00" prefix are
+// taken to be national numbers in the default country. It returns
+// ErrInvalidPhoneNumber if the result can't be a real number.
+func NormalizePhoneNumber(phone string) (string, error) {
+	cleaned := stripPhoneFormatting(phone)
+
+	var digits string
+	switch {
+	case strings.HasPrefix(cleaned, "+"):
+		digits = cleaned[1:]
+	case strings.HasPrefix(cleaned, "00"):
+		digits = cleaned[2:]
+	case defaultCountryCode == "1" && strings.HasPrefix(cleaned, "011"):
+		// The international prefix dialed from North America
+		digits = cleaned[3:]
+	case defaultCountryCode == "1" && len(cleaned) == 11 && cleaned[0] == '1':
+		// National number with the leading 1 included
+		digits = cleaned
+	default:
+		// Drop the trunk prefix many countries write national numbers with
+		national := cleaned
+		if defaultCountryCode != "1" {
+			national = strings.TrimPrefix(national, "0")
+		}
+		digits = defaultCountryCode + national
+	}
+
+	if !allDigits(digits) || len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+	if digits[0] == '1' && !isNANPNumber(digits[1:]) {
+		return "", ErrInvalidPhoneNumber
+	}
+	return "+" + digits, nil
+}
+
+// stripPhoneFormatting removes the separators people put in phone numbers
+func stripPhoneFormatting(phone string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.', '/', '\t':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(phone))
+}
+
+// isNANPNumber reports whether national is a 10-digit North American number.
+// Neither the area code nor the exchange can start with 0 or 1.
+func isNANPNumber(national string) bool {
+	return len(national) == 10 && national[0] >= '2' && national[3] >= '2'
+}
+
+func allDigits(s string) bool {
+	for _, char := range s {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
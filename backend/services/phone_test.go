@@ -0,0 +1,88 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePhoneNumber_USFormats(t *testing.T) {
+	for _, input := range []string{
+		"(303) 555-0147",
+		"303-555-0147",
+		"303.555.0147",
+		"303 555 0147",
+		"3035550147",
+		"1-303-555-0147",
+		"1 (303) 555-0147",
+		"13035550147",
+		"+1 303 555 0147",
+		"+1 (303) 555-0147",
+		"+13035550147",
+		"  (303)555-0147 ",
+		"011 1 303 555 0147",
+	} {
+		phone, err := NormalizePhoneNumber(input)
+
+		require.NoError(t, err, input)
+		assert.Equal(t, "+13035550147", phone, input)
+	}
+}
+
+func TestNormalizePhoneNumber_International(t *testing.T) {
+	cases := map[string]string{
+		"+44 20 7946 0958":    "+442079460958",
+		"0044 20 7946 0958":   "+442079460958",
+		"011 44 20 7946 0958": "+442079460958",
+		"+49 30 901820":       "+4930901820",
+		"+61 4 1234 5678":     "+61412345678",
+		"+91 98765 43210":     "+919876543210",
+		"+52 55 1234 5678":    "+525512345678",
+	}
+	for input, want := range cases {
+		phone, err := NormalizePhoneNumber(input)
+
+		require.NoError(t, err, input)
+		assert.Equal(t, want, phone, input)
+	}
+}
+
+func TestNormalizePhoneNumber_RejectsInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"555-0199",              // No area code
+		"(303) 555-01",          // Too short
+		"(303) 555-01477",       // Too long for a US number
+		"(103) 555-0147",        // Area codes don't start with 1
+		"(303) 055-0147",        // Nor do exchanges
+		"+1 303 555 0147 9",     // Too long for +1
+		"+0 303 555 0147",       // No country code starts with 0
+		"+44 20 7946 0958 1234", // Over 15 digits
+		"303-555-CALL",
+		"+44 20 7946 0958 ext 2",
+	} {
+		_, err := NormalizePhoneNumber(input)
+
+		assert.ErrorIs(t, err, ErrInvalidPhoneNumber, input)
+	}
+}
+
+func TestNormalizePhoneNumber_DefaultCountryCode(t *testing.T) {
+	t.Cleanup(func() { defaultCountryCode = "1" })
+	require.NoError(t, SetDefaultPhoneCountryCode("+44"))
+
+	// The trunk 0 of a national number is dropped
+	phone, err := NormalizePhoneNumber("020 7946 0958")
+	require.NoError(t, err)
+	assert.Equal(t, "+442079460958", phone)
+
+	// Numbers with a country code are unaffected
+	phone, err = NormalizePhoneNumber("+1 (303) 555-0147")
+	require.NoError(t, err)
+	assert.Equal(t, "+13035550147", phone)
+
+	assert.Error(t, SetDefaultPhoneCountryCode("044"))
+	assert.Error(t, SetDefaultPhoneCountryCode("uk"))
+	assert.Equal(t, "44", defaultCountryCode)
+}
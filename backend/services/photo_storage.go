@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrPhotoFileNotFound is returned by storage backends for a key they don't
+// hold
+var ErrPhotoFileNotFound = errors.New("photo file not found")
+
+// PhotoStorage keeps the files behind property photos. Keys are relative
+// slash-separated paths. Implementations must be safe for concurrent use.
+type PhotoStorage interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// PhotoURLSigner is implemented by storage backends that can hand out
+// time-limited URLs, so downloads skip the API server
+type PhotoURLSigner interface {
+	SignedURL(key string, expires time.Duration) (string, error)
+}
+
+// LocalPhotoStorage stores photos as files under a directory
+type LocalPhotoStorage struct {
+	dir string
+}
+
+// NewLocalPhotoStorage creates a storage rooted at dir
+func NewLocalPhotoStorage(dir string) *LocalPhotoStorage {
+	return &LocalPhotoStorage{dir: dir}
+}
+
+// Put writes body to the file for key, replacing any existing one
+func (s *LocalPhotoStorage) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create photo directory: %w", err)
+	}
+
+	// Write to a temporary file first so a failed upload never leaves a
+	// partial photo behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create photo file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write photo file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write photo file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save photo file: %w", err)
+	}
+	return nil
+}
+
+// Get opens the file for key
+func (s *LocalPhotoStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrPhotoFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open photo file: %w", err)
+	}
+	return file, nil
+}
+
+// Delete removes the file for key. A missing file is not an error.
+func (s *LocalPhotoStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete photo file: %w", err)
+	}
+	return nil
+}
+
+// path maps key to a file under the storage directory, refusing keys that
+// would escape it
+func (s *LocalPhotoStorage) path(key string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid photo key %q", key)
+	}
+	return filepath.Join(s.dir, cleaned), nil
+}
+
+// S3PhotoStorage stores photos in a bucket of an S3-compatible object
+// store (AWS S3, MinIO, R2...). Objects are addressed path-style so custom
+// endpoints work, and requests are signed with AWS Signature Version 4.
+type S3PhotoStorage struct {
+	endpoint        string
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	client          *http.Client
+	now             func() time.Time
+}
+
+// NewS3PhotoStorage creates a storage for bucket at endpoint, e.g.
+// https://s3.us-east-1.amazonaws.com. sessionToken is only needed for
+// temporary credentials.
+func NewS3PhotoStorage(endpoint, bucket, region, accessKeyID, secretAccessKey, sessionToken string) *S3PhotoStorage {
+	return &S3PhotoStorage{
+		endpoint:        strings.TrimSuffix(endpoint, "/"),
+		bucket:          bucket,
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		client:          &http.Client{Timeout: 60 * time.Second},
+		now:             time.Now,
+	}
+}
+
+// Put uploads body as the object for key
+func (s *S3PhotoStorage) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload photo: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return s.apiError(resp)
+}
+
+// Get downloads the object for key
+func (s *S3PhotoStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	s.sign(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download photo: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrPhotoFileNotFound
+	}
+	return nil, s.apiError(resp)
+}
+
+// Delete removes the object for key. S3 treats deleting a missing object
+// as success.
+func (s *S3PhotoStorage) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	s.sign(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete photo: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 || resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return s.apiError(resp)
+}
+
+// SignedURL returns a presigned GET URL for key valid for expires
+func (s *S3PhotoStorage) SignedURL(key string, expires time.Duration) (string, error) {
+	u, err := url.Parse(s.objectURL(key))
+	if err != nil {
+		return "", fmt.Errorf("invalid photo URL: %w", err)
+	}
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", fmt.Sprint(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.sessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature := s.signature(now, scope, canonicalRequest)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// objectURL is the path-style URL of the object for key
+func (s *S3PhotoStorage) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return s.endpoint + "/" + url.PathEscape(s.bucket) + "/" + strings.Join(segments, "/")
+}
+
+// sign adds SigV4 authentication headers. The payload isn't hashed, which
+// S3 allows over TLS and which lets uploads stream.
+func (s *S3PhotoStorage) sign(req *http.Request) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		headers = append([]string{"content-type"}, headers...)
+	}
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+		headers = append(headers, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature := s.signature(now, scope, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+// signature signs canonicalRequest with a key derived for scope
+func (s *S3PhotoStorage) signature(now time.Time, scope, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := []byte("AWS4" + s.secretAccessKey)
+	for _, part := range []string{now.Format("20060102"), s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// apiError describes an S3 error response
+func (s *S3PhotoStorage) apiError(resp *http.Response) error {
+	var apiErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr); err != nil || apiErr.Code == "" {
+		return fmt.Errorf("S3 API returned status: %d", resp.StatusCode)
+	}
+	return fmt.Errorf("S3 API error %s (status %d): %s", apiErr.Code, resp.StatusCode, apiErr.Message)
+}
+
+// NewPhotoStorageFromEnv picks the storage named by PHOTO_STORAGE ("local"
+// or "s3"). Local storage keeps files under PHOTO_STORAGE_DIR, ./uploads/photos
+// by default. S3 needs S3_BUCKET, AWS_REGION and AWS credentials, and
+// S3_ENDPOINT for stores other than AWS.
+func NewPhotoStorageFromEnv() PhotoStorage {
+	dir := os.Getenv("PHOTO_STORAGE_DIR")
+	if dir == "" {
+		dir = "./uploads/photos"
+	}
+
+	switch storage := strings.ToLower(os.Getenv("PHOTO_STORAGE")); storage {
+	case "s3":
+		bucket, region := os.Getenv("S3_BUCKET"), os.Getenv("AWS_REGION")
+		keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if bucket != "" && region != "" && keyID != "" && secret != "" {
+			endpoint := os.Getenv("S3_ENDPOINT")
+			if endpoint == "" {
+				endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+			}
+			return NewS3PhotoStorage(endpoint, bucket, region, keyID, secret, os.Getenv("AWS_SESSION_TOKEN"))
+		}
+		fmt.Println("S3_BUCKET/AWS_REGION/AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY are incomplete; photos will be stored on local disk")
+	case "", "local":
+	default:
+		fmt.Printf("Unknown PHOTO_STORAGE %q; photos will be stored on local disk\n", storage)
+	}
+	return NewLocalPhotoStorage(dir)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+
+	"arvfinder-backend/models"
+)
+
+// recentDealsLimit is how many recently updated deals the summary lists
+const recentDealsLimit = 5
+
+// PortfolioSummary is the dashboard overview of a tenant's properties.
+// Archived properties are left out entirely. Dead deals are counted by
+// status but left out of the money totals, and the rental figures come from
+// each property's latest saved ARV calculation.
+type PortfolioSummary struct {
+	PropertyCount        int               `json:"property_count"`
+	StatusCounts         map[string]int    `json:"status_counts"`
+	TotalInvestedCapital float64           `json:"total_invested_capital"`
+	TotalEstimatedARV    float64           `json:"total_estimated_arv"`
+	PropertiesAnalyzed   int               `json:"properties_analyzed"`
+	MonthlyCashFlow      float64           `json:"monthly_cash_flow"`
+	AverageCapRate       float64           `json:"average_cap_rate"`
+	RecentlyUpdatedDeals []models.Property `json:"recently_updated_deals"`
+}
+
+// PortfolioService summarizes a tenant's properties for the dashboard
+type PortfolioService struct {
+	db         *sql.DB
+	properties *PropertyRepository
+}
+
+// NewPortfolioService creates a new portfolio service
+func NewPortfolioService(db *sql.DB) *PortfolioService {
+	return &PortfolioService{db: db, properties: NewPropertyRepository(db)}
+}
+
+// Summary aggregates tenantID's portfolio. An empty portfolio gives zero
+// totals and no recent deals.
+func (s *PortfolioService) Summary(tenantID string) (*PortfolioSummary, error) {
+	counts, err := s.properties.CountByStatus(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	summary := &PortfolioSummary{StatusCounts: counts, RecentlyUpdatedDeals: []models.Property{}}
+	for _, count := range counts {
+		summary.PropertyCount += count
+	}
+	if summary.PropertyCount == 0 {
+		return summary, nil
+	}
+
+	err = s.db.QueryRow(`
+		SELECT COALESCE(SUM(COALESCE(price, 0) + COALESCE(rehab_cost, 0) + COALESCE(holding_costs, 0) + COALESCE(closing_costs, 0)), 0),
+			COALESCE(SUM(arv), 0)
+		FROM properties
+		WHERE tenant_id = $1 AND deleted_at IS NULL AND archived_at IS NULL AND status <> $2
+	`, tenantID, StatusDead).Scan(&summary.TotalInvestedCapital, &summary.TotalEstimatedARV)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total portfolio: %w", err)
+	}
+
+	err = s.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(monthly_cash_flow), 0), COALESCE(AVG(cap_rate), 0)
+		FROM (
+			SELECT DISTINCT ON (c.property_id) c.monthly_cash_flow, c.cap_rate
+			FROM arv_calculations c
+			JOIN properties p ON p.id = c.property_id
+			WHERE p.tenant_id = $1 AND p.deleted_at IS NULL AND p.archived_at IS NULL AND p.status <> $2
+			ORDER BY c.property_id, c.created_at DESC, c.id DESC
+		) latest
+	`, tenantID, StatusDead).Scan(&summary.PropertiesAnalyzed, &summary.MonthlyCashFlow, &summary.AverageCapRate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total portfolio returns: %w", err)
+	}
+	summary.AverageCapRate = math.Round(summary.AverageCapRate*100) / 100
+
+	rows, err := s.db.Query(`
+		SELECT `+propertyColumns+` FROM properties
+		WHERE tenant_id = $1 AND deleted_at IS NULL AND archived_at IS NULL
+		ORDER BY updated_at DESC, id
+		LIMIT $2
+	`, tenantID, recentDealsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recent deals: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		property, err := scanProperty(rows)
+		if err != nil {
+			return nil, err
+		}
+		summary.RecentlyUpdatedDeals = append(summary.RecentlyUpdatedDeals, *property)
+	}
+	return summary, rows.Err()
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// Archive takes one of tenantID's properties off the active list and out of
+// the portfolio totals. Its calculations and comparables are kept, and
+// archiving an archived property leaves it as it was.
+func (r *PropertyRepository) Archive(tenantID, id string) (*models.Property, error) {
+	return r.setArchived(tenantID, id, `
+		archived_at = COALESCE(archived_at, NOW()),
+		updated_at = CASE WHEN archived_at IS NULL THEN NOW() ELSE updated_at END`)
+}
+
+// Unarchive puts one of tenantID's archived properties back on the active
+// list. Unarchiving an active property leaves it as it was.
+func (r *PropertyRepository) Unarchive(tenantID, id string) (*models.Property, error) {
+	return r.setArchived(tenantID, id, `
+		archived_at = NULL,
+		updated_at = CASE WHEN archived_at IS NOT NULL THEN NOW() ELSE updated_at END`)
+}
+
+// setArchived applies assignments to one of tenantID's properties
+func (r *PropertyRepository) setArchived(tenantID, id, assignments string) (*models.Property, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrPropertyNotFound
+	}
+
+	property, err := scanProperty(r.db.QueryRow(`
+		UPDATE properties SET `+assignments+`
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+		RETURNING `+propertyColumns, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, ErrPropertyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update property: %w", err)
+	}
+	return property, nil
+}
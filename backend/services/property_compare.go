@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Deal comparison errors
+var (
+	ErrInvalidComparison = errors.New("compare 2 to 5 different properties")
+	ErrInvalidRankBy     = errors.New("rank_by must be cash_flow, coc or profit")
+)
+
+// Limits on how many properties can be compared at once
+const (
+	minComparedProperties = 2
+	maxComparedProperties = 5
+)
+
+// Criteria deals can be ranked by. Higher is better for each.
+const (
+	RankByCashFlow = "cash_flow"
+	RankByCoC      = "coc"
+	RankByProfit   = "profit"
+)
+
+// comparisonRankings reads the figure each rank_by criterion ranks on
+var comparisonRankings = map[string]func(m *ComparisonMetrics) float64{
+	RankByCashFlow: func(m *ComparisonMetrics) float64 { return m.MonthlyCashFlow.Float64() },
+	RankByCoC:      func(m *ComparisonMetrics) float64 { return cashOnCashValue(m.CashOnCashReturn, m.IsInfiniteReturn) },
+	RankByProfit:   func(m *ComparisonMetrics) float64 { return m.PotentialProfit.Float64() },
+}
+
+// CompareRequest lists the properties to compare
+type CompareRequest struct {
+	PropertyIDs []string `json:"property_ids" binding:"required"`
+}
+
+// UnknownPropertiesError lists compared property IDs that aren't among the
+// tenant's properties
+type UnknownPropertiesError struct {
+	PropertyIDs []string
+}
+
+func (e *UnknownPropertiesError) Error() string {
+	return "unknown properties: " + strings.Join(e.PropertyIDs, ", ")
+}
+
+// Is lets callers treat unknown properties like any missing property
+func (e *UnknownPropertiesError) Is(target error) bool {
+	return target == ErrPropertyNotFound
+}
+
+// DealComparison sets properties side by side in the order they were asked
+// for, each ranked by RankBy
+type DealComparison struct {
+	RankBy string         `json:"rank_by"`
+	Deals  []ComparedDeal `json:"deals"`
+}
+
+// ComparedDeal is one property in a comparison. Properties without a saved
+// ARV calculation have no metrics and no rank.
+type ComparedDeal struct {
+	PropertyID         string             `json:"property_id"`
+	Address            string             `json:"address"`
+	City               string             `json:"city"`
+	State              string             `json:"state"`
+	Status             string             `json:"status"`
+	Metrics            *ComparisonMetrics `json:"metrics"`
+	MissingCalculation bool               `json:"missing_calculation"`
+	Rank               int                `json:"rank,omitempty"`
+}
+
+// ComparisonMetrics are the key figures of a property's latest ARV
+// calculation
+type ComparisonMetrics struct {
+	MaxOffer         models.Money `json:"max_offer"`
+	TotalInvestment  models.Money `json:"total_investment"`
+	PotentialProfit  models.Money `json:"potential_profit"`
+	MonthlyCashFlow  models.Money `json:"monthly_cash_flow"`
+	CashOnCashReturn *float64     `json:"cash_on_cash_return"` // null when infinite
+	IsInfiniteReturn bool         `json:"is_infinite_return"`
+	CapRate          float64      `json:"cap_rate"`
+	DSCR             float64      `json:"dscr"`
+	RiskLevel        string       `json:"risk_level"`
+	CalculatedAt     time.Time    `json:"calculated_at"`
+}
+
+// Compare sets 2 to 5 of tenantID's properties side by side using their
+// latest ARV calculations, ranked by rankBy (cash_flow when empty). Tied
+// deals share a rank and the next rank is skipped.
+func (r *ArvCalculationRepository) Compare(tenantID string, propertyIDs []string, rankBy string) (*DealComparison, error) {
+	if rankBy == "" {
+		rankBy = RankByCashFlow
+	}
+	rankValue, ok := comparisonRankings[rankBy]
+	if !ok {
+		return nil, ErrInvalidRankBy
+	}
+
+	ids := make([]string, 0, len(propertyIDs))
+	seen := map[string]bool{}
+	for _, id := range propertyIDs {
+		id = strings.TrimSpace(id)
+		if parsed, err := uuid.Parse(id); err == nil {
+			id = parsed.String()
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < minComparedProperties || len(ids) > maxComparedProperties {
+		return nil, ErrInvalidComparison
+	}
+
+	properties, err := r.properties.getMany(tenantID, ids)
+	if err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for _, id := range ids {
+		if _, ok := properties[id]; !ok {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, &UnknownPropertiesError{PropertyIDs: unknown}
+	}
+
+	calcs, err := r.latestFor(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &DealComparison{RankBy: rankBy, Deals: make([]ComparedDeal, len(ids))}
+	var ranked []*ComparedDeal
+	for i, id := range ids {
+		property := properties[id]
+		deal := ComparedDeal{
+			PropertyID: property.ID,
+			Address:    property.Address,
+			City:       property.City,
+			State:      property.State,
+			Status:     property.Status,
+		}
+		if calc, ok := calcs[id]; ok {
+			deal.Metrics = &ComparisonMetrics{
+				MaxOffer:         calc.MaxOffer,
+				TotalInvestment:  calc.TotalInvestment,
+				PotentialProfit:  calc.PotentialProfit,
+				MonthlyCashFlow:  calc.MonthlyCashFlow,
+				CashOnCashReturn: calc.CashOnCashReturn,
+				IsInfiniteReturn: calc.IsInfiniteReturn,
+				CapRate:          calc.CapRate,
+				DSCR:             calc.DSCR,
+				RiskLevel:        calc.RiskLevel,
+				CalculatedAt:     calc.CreatedAt,
+			}
+		} else {
+			deal.MissingCalculation = true
+		}
+		comparison.Deals[i] = deal
+		if deal.Metrics != nil {
+			ranked = append(ranked, &comparison.Deals[i])
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return rankValue(ranked[i].Metrics) > rankValue(ranked[j].Metrics)
+	})
+	for i, deal := range ranked {
+		if i > 0 && rankValue(deal.Metrics) == rankValue(ranked[i-1].Metrics) {
+			deal.Rank = ranked[i-1].Rank
+		} else {
+			deal.Rank = i + 1
+		}
+	}
+	return comparison, nil
+}
+
+// latestFor returns the calculation most recently saved for each of
+// propertyIDs, keyed by property ID. Properties without one are left out.
+func (r *ArvCalculationRepository) latestFor(propertyIDs []string) (map[string]*models.ArvCalculation, error) {
+	rows, err := r.db.Query(`
+		SELECT DISTINCT ON (property_id) `+arvCalculationColumns+`
+		FROM arv_calculations
+		WHERE property_id = ANY($1::uuid[])
+		ORDER BY property_id, created_at DESC, id
+	`, pq.Array(propertyIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load latest ARV calculations: %w", err)
+	}
+	defer rows.Close()
+
+	calcs := make(map[string]*models.ArvCalculation, len(propertyIDs))
+	for rows.Next() {
+		calc, err := scanArvCalculation(rows)
+		if err != nil {
+			return nil, err
+		}
+		calcs[calc.PropertyID] = calc
+	}
+	return calcs, rows.Err()
+}
+
+// getMany returns those of ids that are tenantID's properties, keyed by ID.
+// Malformed IDs are skipped since they can't match.
+func (r *PropertyRepository) getMany(tenantID string, ids []string) (map[string]*models.Property, error) {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err == nil {
+			valid = append(valid, id)
+		}
+	}
+	properties := make(map[string]*models.Property, len(valid))
+	if len(valid) == 0 {
+		return properties, nil
+	}
+
+	rows, err := r.db.Query(`
+		SELECT `+propertyColumns+` FROM properties
+		WHERE tenant_id = $1 AND deleted_at IS NULL AND id = ANY($2::uuid[])
+	`, tenantID, pq.Array(valid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load properties: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		property, err := scanProperty(rows)
+		if err != nil {
+			return nil, err
+		}
+		properties[property.ID] = property
+	}
+	return properties, rows.Err()
+}
@@ -0,0 +1,36 @@
+package services
+
+import "fmt"
+
+// Favorite stars one of tenantID's properties for userID. Starring a
+// property twice is not an error.
+func (r *PropertyRepository) Favorite(tenantID, userID, id string) error {
+	property, err := r.Get(tenantID, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(`
+		INSERT INTO property_favorites (user_id, property_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, userID, property.ID)
+	if err != nil {
+		return fmt.Errorf("failed to favorite property: %w", err)
+	}
+	return nil
+}
+
+// Unfavorite removes the star userID put on one of tenantID's properties.
+// Other users' favorites are left alone.
+func (r *PropertyRepository) Unfavorite(tenantID, userID, id string) error {
+	property, err := r.Get(tenantID, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(`DELETE FROM property_favorites WHERE user_id = $1 AND property_id = $2`, userID, property.ID)
+	if err != nil {
+		return fmt.Errorf("failed to unfavorite property: %w", err)
+	}
+	return nil
+}
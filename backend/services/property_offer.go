@@ -0,0 +1,481 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// Offer errors
+var (
+	ErrOfferNotFound          = errors.New("offer not found")
+	ErrInvalidOfferStatus     = errors.New("invalid offer status")
+	ErrInvalidOfferTransition = errors.New("offer can't move to that status")
+	ErrCounterAmountRequired  = errors.New("a counter-offer needs the seller's amount")
+	ErrOfferAlreadyAccepted   = errors.New("property already has an accepted offer")
+	ErrOfferNotEditable       = errors.New("only draft offers can be changed or deleted")
+	ErrInvalidFinancingType   = errors.New("invalid financing type")
+	ErrInvalidOfferDate       = errors.New("offer date must be formatted YYYY-MM-DD")
+)
+
+// Offer statuses
+const (
+	OfferStatusDraft     = "draft"
+	OfferStatusSubmitted = "submitted"
+	OfferStatusCountered = "countered"
+	OfferStatusAccepted  = "accepted"
+	OfferStatusRejected  = "rejected"
+)
+
+// OfferStatuses lists every offer status
+var OfferStatuses = []string{
+	OfferStatusDraft, OfferStatusSubmitted, OfferStatusCountered, OfferStatusAccepted, OfferStatusRejected,
+}
+
+// FinancingTypes lists the ways an offer can be financed
+var FinancingTypes = []string{
+	"cash", "conventional", "fha", "va", "hard_money", "private_money", "seller_financing", "other",
+}
+
+// offerTransitions lists the statuses each status may move to. Accepted
+// and rejected offers are final.
+var offerTransitions = map[string][]string{
+	OfferStatusDraft:     {OfferStatusSubmitted},
+	OfferStatusSubmitted: {OfferStatusCountered, OfferStatusAccepted, OfferStatusRejected},
+	OfferStatusCountered: {OfferStatusSubmitted, OfferStatusAccepted, OfferStatusRejected},
+}
+
+// maxExpiringWithin is the furthest ahead Outstanding looks for expiring
+// offers
+const maxExpiringWithin = 30 * 24 * time.Hour
+
+// offerColumns are selected, in this order, by scanOffer
+const offerColumns = `id, property_id, amount, counter_amount, offer_date, expires_at, financing_type, status,
+	COALESCE(notes, ''), created_at, updated_at`
+
+// OfferRequest holds the fields of an offer. OfferDate is formatted
+// YYYY-MM-DD and defaults to today.
+type OfferRequest struct {
+	Amount        float64    `json:"amount" binding:"required,gt=0"`
+	OfferDate     string     `json:"offer_date"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	FinancingType string     `json:"financing_type" binding:"required"`
+	Notes         string     `json:"notes" binding:"max=5000"`
+}
+
+// ChangeOfferStatusRequest moves an offer to another status. Amount is the
+// seller's counter when countering, a revised offer when submitting, and
+// the agreed price when accepting; an accepted offer without one closes at
+// the seller's counter, or the offer's amount if there wasn't one.
+// UpdateProperty on acceptance sets the property's price to the agreed
+// price and moves it under contract.
+type ChangeOfferStatusRequest struct {
+	Status         string   `json:"status" binding:"required"`
+	Amount         *float64 `json:"amount" binding:"omitempty,gt=0"`
+	Note           string   `json:"note" binding:"max=1000"`
+	UpdateProperty bool     `json:"update_property"`
+}
+
+// OfferStatusChange is the result of moving an offer to another status.
+// Property is set when the property was updated along with it.
+type OfferStatusChange struct {
+	Offer    *models.PropertyOffer `json:"offer"`
+	Property *models.Property      `json:"property,omitempty"`
+}
+
+// OfferDetail is an offer with its status history, oldest first
+type OfferDetail struct {
+	*models.PropertyOffer
+	History []models.PropertyOfferChange `json:"history"`
+}
+
+// OutstandingOffer is a submitted or countered offer with the property it
+// was made on
+type OutstandingOffer struct {
+	models.PropertyOffer
+	Address string `json:"address"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+}
+
+// OfferRepository stores the offers made on a tenant's properties
+type OfferRepository struct {
+	db         *sql.DB
+	properties *PropertyRepository
+}
+
+// NewOfferRepository creates a new offer repository
+func NewOfferRepository(db *sql.DB) *OfferRepository {
+	return &OfferRepository{
+		db:         db,
+		properties: NewPropertyRepository(db),
+	}
+}
+
+// checkOfferTransition decides whether an offer may move from one status to
+// another
+func checkOfferTransition(from, to string, amount *float64) error {
+	valid := false
+	for _, status := range OfferStatuses {
+		valid = valid || status == to
+	}
+	if !valid {
+		return ErrInvalidOfferStatus
+	}
+	allowed := false
+	for _, status := range offerTransitions[from] {
+		allowed = allowed || status == to
+	}
+	if !allowed {
+		return ErrInvalidOfferTransition
+	}
+	if to == OfferStatusCountered && amount == nil {
+		return ErrCounterAmountRequired
+	}
+	return nil
+}
+
+// Create saves a draft offer on one of tenantID's properties
+func (r *OfferRepository) Create(tenantID, propertyID, userID string, req OfferRequest) (*models.PropertyOffer, error) {
+	offerDate, err := parseOfferRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	offer, err := scanOffer(r.db.QueryRow(`
+		INSERT INTO property_offers (property_id, tenant_id, amount, offer_date, expires_at, financing_type, notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		RETURNING `+offerColumns,
+		property.ID, tenantID, req.Amount, offerDate, req.ExpiresAt, req.FinancingType, strings.TrimSpace(req.Notes), userID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offer: %w", err)
+	}
+	return offer, nil
+}
+
+// List returns the offers on one of tenantID's properties, newest first
+func (r *OfferRepository) List(tenantID, propertyID string) ([]models.PropertyOffer, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`
+		SELECT `+offerColumns+` FROM property_offers
+		WHERE property_id = $1
+		ORDER BY offer_date DESC, created_at DESC
+	`, property.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offers: %w", err)
+	}
+	defer rows.Close()
+
+	offers := []models.PropertyOffer{}
+	for rows.Next() {
+		offer, err := scanOffer(rows)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, *offer)
+	}
+	return offers, rows.Err()
+}
+
+// Get returns an offer on one of tenantID's properties with its history
+func (r *OfferRepository) Get(tenantID, propertyID, offerID string) (*OfferDetail, error) {
+	offer, err := r.get(tenantID, propertyID, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, offer_id, from_status, to_status, amount, COALESCE(note, ''), COALESCE(changed_by::text, ''), created_at
+		FROM property_offer_history
+		WHERE offer_id = $1
+		ORDER BY created_at, id
+	`, offer.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load offer history: %w", err)
+	}
+	defer rows.Close()
+
+	detail := &OfferDetail{PropertyOffer: offer, History: []models.PropertyOfferChange{}}
+	for rows.Next() {
+		var change models.PropertyOfferChange
+		err := rows.Scan(&change.ID, &change.OfferID, &change.FromStatus, &change.ToStatus, &change.Amount,
+			&change.Note, &change.ChangedBy, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		detail.History = append(detail.History, change)
+	}
+	return detail, rows.Err()
+}
+
+// Update replaces the fields of a draft offer on one of tenantID's
+// properties. Offers that have been submitted change through ChangeStatus.
+func (r *OfferRepository) Update(tenantID, propertyID, offerID string, req OfferRequest) (*models.PropertyOffer, error) {
+	offerDate, err := parseOfferRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	offer, err := r.get(tenantID, propertyID, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := scanOffer(r.db.QueryRow(`
+		UPDATE property_offers
+		SET amount = $2, offer_date = $3, expires_at = $4, financing_type = $5, notes = NULLIF($6, ''), updated_at = NOW()
+		WHERE id = $1 AND status = 'draft'
+		RETURNING `+offerColumns,
+		offer.ID, req.Amount, offerDate, req.ExpiresAt, req.FinancingType, strings.TrimSpace(req.Notes),
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrOfferNotEditable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update offer: %w", err)
+	}
+	return updated, nil
+}
+
+// Delete removes a draft offer from one of tenantID's properties. Offers
+// that have been submitted are kept for the record.
+func (r *OfferRepository) Delete(tenantID, propertyID, offerID string) error {
+	offer, err := r.get(tenantID, propertyID, offerID)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.Exec(`DELETE FROM property_offers WHERE id = $1 AND status = 'draft'`, offer.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete offer: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete offer: %w", err)
+	}
+	if affected == 0 {
+		return ErrOfferNotEditable
+	}
+	return nil
+}
+
+// ChangeStatus moves an offer on one of tenantID's properties to another
+// status and records the change in its history
+func (r *OfferRepository) ChangeStatus(tenantID, propertyID, offerID, userID string, req ChangeOfferStatusRequest) (*OfferStatusChange, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := uuid.Parse(offerID); err != nil {
+		return nil, ErrOfferNotFound
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	offer, err := scanOffer(tx.QueryRow(`
+		SELECT `+offerColumns+` FROM property_offers
+		WHERE id = $1 AND property_id = $2
+		FOR UPDATE
+	`, offerID, property.ID))
+	if err == sql.ErrNoRows {
+		return nil, ErrOfferNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offer: %w", err)
+	}
+	if err := checkOfferTransition(offer.Status, req.Status, req.Amount); err != nil {
+		return nil, err
+	}
+
+	from := offer.Status
+	var historyAmount *float64
+	switch req.Status {
+	case OfferStatusCountered:
+		offer.CounterAmount = req.Amount
+		historyAmount = req.Amount
+	case OfferStatusSubmitted:
+		if req.Amount != nil {
+			offer.Amount = *req.Amount
+		}
+		historyAmount = &offer.Amount
+	case OfferStatusAccepted:
+		switch {
+		case req.Amount != nil:
+			offer.Amount = *req.Amount
+		case from == OfferStatusCountered && offer.CounterAmount != nil:
+			offer.Amount = *offer.CounterAmount
+		}
+		historyAmount = &offer.Amount
+
+		var accepted bool
+		err := tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM property_offers WHERE property_id = $1 AND status = 'accepted' AND id <> $2)
+		`, property.ID, offer.ID).Scan(&accepted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check accepted offers: %w", err)
+		}
+		if accepted {
+			return nil, ErrOfferAlreadyAccepted
+		}
+	}
+
+	updated, err := scanOffer(tx.QueryRow(`
+		UPDATE property_offers SET status = $2, amount = $3, counter_amount = $4, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+offerColumns, offer.ID, req.Status, offer.Amount, offer.CounterAmount))
+	if isUniqueViolation(err) {
+		return nil, ErrOfferAlreadyAccepted
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update offer status: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO property_offer_history (offer_id, from_status, to_status, amount, note, changed_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+	`, offer.ID, from, req.Status, historyAmount, strings.TrimSpace(req.Note), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record offer status change: %w", err)
+	}
+
+	result := &OfferStatusChange{Offer: updated}
+	if req.Status == OfferStatusAccepted && req.UpdateProperty {
+		if result.Property, err = acceptOfferOnProperty(tx, tenantID, property, userID, updated.Amount); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit offer status change: %w", err)
+	}
+	return result, nil
+}
+
+// acceptOfferOnProperty sets a property's price to an accepted offer's
+// amount and moves it under contract within tx
+func acceptOfferOnProperty(tx *sql.Tx, tenantID string, property *models.Property, userID string, amount float64) (*models.Property, error) {
+	updated, err := scanProperty(tx.QueryRow(`
+		UPDATE properties SET price = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+propertyColumns, property.ID, amount))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update property price: %w", err)
+	}
+	if updated.Status == StatusUnderContract {
+		return updated, nil
+	}
+	return changeStatus(tx, tenantID, property.ID, userID, ChangeStatusRequest{
+		Status: StatusUnderContract,
+		Reason: "Offer accepted",
+	})
+}
+
+// Outstanding returns tenantID's submitted and countered offers across all
+// its properties, soonest to expire first. A positive expiringWithin limits
+// them to offers that haven't expired yet but will within that time.
+func (r *OfferRepository) Outstanding(tenantID string, expiringWithin time.Duration) ([]OutstandingOffer, error) {
+	if expiringWithin > maxExpiringWithin {
+		expiringWithin = maxExpiringWithin
+	}
+	query := `
+		SELECT o.id, o.property_id, o.amount, o.counter_amount, o.offer_date, o.expires_at, o.financing_type,
+			o.status, COALESCE(o.notes, ''), o.created_at, o.updated_at,
+			p.address, COALESCE(p.city, ''), COALESCE(p.state, '')
+		FROM property_offers o
+		JOIN properties p ON p.id = o.property_id
+		WHERE o.tenant_id = $1 AND p.deleted_at IS NULL AND o.status IN ('submitted', 'countered')`
+	args := []interface{}{tenantID}
+	if expiringWithin > 0 {
+		query += ` AND o.expires_at > NOW() AND o.expires_at <= NOW() + make_interval(secs => $2)`
+		args = append(args, expiringWithin.Seconds())
+	}
+	query += ` ORDER BY o.expires_at ASC NULLS LAST, o.created_at`
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outstanding offers: %w", err)
+	}
+	defer rows.Close()
+
+	offers := []OutstandingOffer{}
+	for rows.Next() {
+		var o OutstandingOffer
+		err := rows.Scan(&o.ID, &o.PropertyID, &o.Amount, &o.CounterAmount, &o.OfferDate, &o.ExpiresAt,
+			&o.FinancingType, &o.Status, &o.Notes, &o.CreatedAt, &o.UpdatedAt, &o.Address, &o.City, &o.State)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+// get loads an offer on one of tenantID's properties
+func (r *OfferRepository) get(tenantID, propertyID, offerID string) (*models.PropertyOffer, error) {
+	if _, err := uuid.Parse(offerID); err != nil {
+		return nil, ErrOfferNotFound
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	offer, err := scanOffer(r.db.QueryRow(`
+		SELECT `+offerColumns+` FROM property_offers
+		WHERE id = $1 AND property_id = $2
+	`, offerID, property.ID))
+	if err == sql.ErrNoRows {
+		return nil, ErrOfferNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offer: %w", err)
+	}
+	return offer, nil
+}
+
+// parseOfferRequest checks an offer's financing type and returns its date,
+// today if none was given
+func parseOfferRequest(req OfferRequest) (time.Time, error) {
+	valid := false
+	for _, financing := range FinancingTypes {
+		valid = valid || financing == req.FinancingType
+	}
+	if !valid {
+		return time.Time{}, ErrInvalidFinancingType
+	}
+	if req.OfferDate == "" {
+		return time.Now().UTC().Truncate(24 * time.Hour), nil
+	}
+	offerDate, err := time.Parse("2006-01-02", req.OfferDate)
+	if err != nil {
+		return time.Time{}, ErrInvalidOfferDate
+	}
+	return offerDate, nil
+}
+
+// scanOffer reads a row selected with offerColumns
+func scanOffer(row interface{ Scan(...interface{}) error }) (*models.PropertyOffer, error) {
+	var o models.PropertyOffer
+	err := row.Scan(&o.ID, &o.PropertyID, &o.Amount, &o.CounterAmount, &o.OfferDate, &o.ExpiresAt,
+		&o.FinancingType, &o.Status, &o.Notes, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
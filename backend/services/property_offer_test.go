@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOfferTransition(t *testing.T) {
+	counter := 195000.0
+	cases := []struct {
+		from, to string
+		amount   *float64
+		want     error
+	}{
+		{OfferStatusDraft, OfferStatusSubmitted, nil, nil},
+		{OfferStatusSubmitted, OfferStatusCountered, &counter, nil},
+		{OfferStatusSubmitted, OfferStatusAccepted, nil, nil},
+		{OfferStatusSubmitted, OfferStatusRejected, nil, nil},
+		{OfferStatusCountered, OfferStatusSubmitted, &counter, nil},
+		{OfferStatusCountered, OfferStatusSubmitted, nil, nil},
+		{OfferStatusCountered, OfferStatusAccepted, nil, nil},
+		{OfferStatusCountered, OfferStatusRejected, nil, nil},
+		{OfferStatusSubmitted, OfferStatusCountered, nil, ErrCounterAmountRequired},
+		{OfferStatusDraft, OfferStatusAccepted, nil, ErrInvalidOfferTransition},
+		{OfferStatusDraft, OfferStatusCountered, &counter, ErrInvalidOfferTransition},
+		{OfferStatusSubmitted, OfferStatusDraft, nil, ErrInvalidOfferTransition},
+		{OfferStatusSubmitted, OfferStatusSubmitted, nil, ErrInvalidOfferTransition},
+		{OfferStatusAccepted, OfferStatusRejected, nil, ErrInvalidOfferTransition},
+		{OfferStatusRejected, OfferStatusSubmitted, nil, ErrInvalidOfferTransition},
+		{OfferStatusSubmitted, "withdrawn", nil, ErrInvalidOfferStatus},
+		{OfferStatusSubmitted, "", nil, ErrInvalidOfferStatus},
+	}
+	for _, tc := range cases {
+		err := checkOfferTransition(tc.from, tc.to, tc.amount)
+		assert.Equal(t, tc.want, err, "%s -> %s", tc.from, tc.to)
+	}
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// Property photo errors
+var (
+	ErrPhotoNotFound     = errors.New("photo not found")
+	ErrPhotoTooLarge     = errors.New("photo is larger than 10MB")
+	ErrUnsupportedPhoto  = errors.New("photo must be a JPEG, PNG or WebP image")
+	ErrPhotoLimitReached = errors.New("property has reached its photo limit")
+	ErrInvalidPhotoOrder = errors.New("photo order must list each of the property's photos once")
+)
+
+const (
+	// MaxPhotoSize is the largest photo that may be uploaded
+	MaxPhotoSize = 10 << 20
+	// photoURLTTL is how long a signed download URL stays valid
+	photoURLTTL = 15 * time.Minute
+	// defaultMaxPhotos applies to tenants whose plan can't be determined
+	defaultMaxPhotos = 10
+)
+
+// photoColumns are selected, in this order, by scanPhoto
+const photoColumns = `id, property_id, storage_key, filename, content_type, size_bytes, position, created_at`
+
+// photoTypes maps the magic bytes of accepted images to their content type
+// and file extension
+var photoTypes = []struct {
+	contentType string
+	extension   string
+	matches     func(header []byte) bool
+}{
+	{"image/jpeg", ".jpg", func(h []byte) bool { return bytes.HasPrefix(h, []byte{0xFF, 0xD8, 0xFF}) }},
+	{"image/png", ".png", func(h []byte) bool { return bytes.HasPrefix(h, []byte("\x89PNG\r\n\x1a\n")) }},
+	{"image/webp", ".webp", func(h []byte) bool {
+		return len(h) >= 12 && bytes.Equal(h[:4], []byte("RIFF")) && bytes.Equal(h[8:12], []byte("WEBP"))
+	}},
+}
+
+// PropertyPhotoService stores photos of a tenant's properties: the files in
+// a PhotoStorage and their details in property_photos
+type PropertyPhotoService struct {
+	db         *sql.DB
+	storage    PhotoStorage
+	properties *PropertyRepository
+}
+
+// NewPropertyPhotoService creates a new property photo service
+func NewPropertyPhotoService(db *sql.DB, storage PhotoStorage) *PropertyPhotoService {
+	return &PropertyPhotoService{
+		db:         db,
+		storage:    storage,
+		properties: NewPropertyRepository(db),
+	}
+}
+
+// Upload stores a photo of one of tenantID's properties after the gallery's
+// other photos. The type is detected from the file's contents; the filename
+// is only kept for display.
+func (s *PropertyPhotoService) Upload(ctx context.Context, tenantID, propertyID, filename string, file io.Reader, size int64) (*models.PropertyPhoto, error) {
+	if size > MaxPhotoSize {
+		return nil, ErrPhotoTooLarge
+	}
+	property, err := s.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 12)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("failed to read photo: %w", err)
+	}
+	header = header[:n]
+	contentType, extension := "", ""
+	for _, photoType := range photoTypes {
+		if photoType.matches(header) {
+			contentType, extension = photoType.contentType, photoType.extension
+			break
+		}
+	}
+	if contentType == "" {
+		return nil, ErrUnsupportedPhoto
+	}
+
+	var count int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM property_photos WHERE property_id = $1`, property.ID).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count photos: %w", err)
+	}
+	if count >= s.photoLimit(tenantID) {
+		return nil, ErrPhotoLimitReached
+	}
+
+	photo := models.PropertyPhoto{
+		ID:          uuid.New().String(),
+		PropertyID:  property.ID,
+		Filename:    displayFilename(filename, extension),
+		ContentType: contentType,
+		SizeBytes:   size,
+	}
+	photo.StorageKey = fmt.Sprintf("%s/%s/%s%s", tenantID, property.ID, photo.ID, extension)
+
+	body := io.LimitReader(io.MultiReader(bytes.NewReader(header), file), MaxPhotoSize)
+	if err := s.storage.Put(ctx, photo.StorageKey, body, size, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store photo: %w", err)
+	}
+
+	err = s.db.QueryRow(`
+		INSERT INTO property_photos (id, property_id, storage_key, filename, content_type, size_bytes, position)
+		VALUES ($1, $2, $3, $4, $5, $6,
+			(SELECT COALESCE(MAX(position) + 1, 0) FROM property_photos WHERE property_id = $2))
+		RETURNING position, created_at
+	`, photo.ID, photo.PropertyID, photo.StorageKey, photo.Filename, photo.ContentType, photo.SizeBytes,
+	).Scan(&photo.Position, &photo.CreatedAt)
+	if err != nil {
+		s.deleteFile(ctx, photo.StorageKey)
+		return nil, fmt.Errorf("failed to save photo: %w", err)
+	}
+	return &photo, nil
+}
+
+// List returns the photos of one of tenantID's properties in gallery order
+func (s *PropertyPhotoService) List(tenantID, propertyID string) ([]models.PropertyPhoto, error) {
+	property, err := s.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+photoColumns+` FROM property_photos
+		WHERE property_id = $1
+		ORDER BY position, created_at
+	`, property.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list photos: %w", err)
+	}
+	defer rows.Close()
+
+	photos := []models.PropertyPhoto{}
+	for rows.Next() {
+		photo, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, *photo)
+	}
+	return photos, rows.Err()
+}
+
+// Reorder puts the photos of one of tenantID's properties in the order of
+// photoIDs, which must list every photo exactly once
+func (s *PropertyPhotoService) Reorder(tenantID, propertyID string, photoIDs []string) ([]models.PropertyPhoto, error) {
+	photos, err := s.List(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(photoIDs) != len(photos) {
+		return nil, ErrInvalidPhotoOrder
+	}
+	remaining := make(map[string]bool, len(photos))
+	for _, photo := range photos {
+		remaining[photo.ID] = true
+	}
+	for _, id := range photoIDs {
+		if !remaining[id] {
+			return nil, ErrInvalidPhotoOrder
+		}
+		delete(remaining, id)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for position, id := range photoIDs {
+		_, err := tx.Exec(`UPDATE property_photos SET position = $1 WHERE id = $2 AND property_id = $3`,
+			position, id, photos[0].PropertyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reorder photos: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to reorder photos: %w", err)
+	}
+
+	byID := make(map[string]models.PropertyPhoto, len(photos))
+	for _, photo := range photos {
+		byID[photo.ID] = photo
+	}
+	ordered := make([]models.PropertyPhoto, len(photoIDs))
+	for position, id := range photoIDs {
+		ordered[position] = byID[id]
+		ordered[position].Position = position
+	}
+	return ordered, nil
+}
+
+// Delete removes a photo of one of tenantID's properties and its file
+func (s *PropertyPhotoService) Delete(ctx context.Context, tenantID, propertyID, photoID string) error {
+	photo, err := s.get(tenantID, propertyID, photoID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(`DELETE FROM property_photos WHERE id = $1`, photo.ID); err != nil {
+		return fmt.Errorf("failed to delete photo: %w", err)
+	}
+	s.deleteFile(ctx, photo.StorageKey)
+	return nil
+}
+
+// Open returns a photo of one of tenantID's properties and its contents.
+// The caller must close the contents.
+func (s *PropertyPhotoService) Open(ctx context.Context, tenantID, propertyID, photoID string) (*models.PropertyPhoto, io.ReadCloser, error) {
+	photo, err := s.get(tenantID, propertyID, photoID)
+	if err != nil {
+		return nil, nil, err
+	}
+	contents, err := s.storage.Get(ctx, photo.StorageKey)
+	if errors.Is(err, ErrPhotoFileNotFound) {
+		return nil, nil, ErrPhotoNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return photo, contents, nil
+}
+
+// SignedURL returns a time-limited download URL for a photo of one of
+// tenantID's properties. It returns "" when the storage can't sign URLs and
+// downloads must go through the API.
+func (s *PropertyPhotoService) SignedURL(tenantID, propertyID, photoID string) (string, time.Time, error) {
+	photo, err := s.get(tenantID, propertyID, photoID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signer, ok := s.storage.(PhotoURLSigner)
+	if !ok {
+		return "", time.Time{}, nil
+	}
+	url, err := signer.SignedURL(photo.StorageKey, photoURLTTL)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign photo URL: %w", err)
+	}
+	return url, time.Now().Add(photoURLTTL), nil
+}
+
+// get loads a photo of one of tenantID's properties
+func (s *PropertyPhotoService) get(tenantID, propertyID, photoID string) (*models.PropertyPhoto, error) {
+	if _, err := uuid.Parse(photoID); err != nil {
+		return nil, ErrPhotoNotFound
+	}
+	property, err := s.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	photo, err := scanPhoto(s.db.QueryRow(`
+		SELECT `+photoColumns+` FROM property_photos
+		WHERE id = $1 AND property_id = $2
+	`, photoID, property.ID))
+	if err == sql.ErrNoRows {
+		return nil, ErrPhotoNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get photo: %w", err)
+	}
+	return photo, nil
+}
+
+// photoLimit returns how many photos each property may have on the
+// tenant's plan
+func (s *PropertyPhotoService) photoLimit(tenantID string) int {
+	var tier string
+	err := s.db.QueryRow(`SELECT subscription_tier FROM tenants WHERE id = $1`, tenantID).Scan(&tier)
+	if err != nil {
+		return defaultMaxPhotos
+	}
+	if plan, ok := subscriptionPlans()[SubscriptionTier(tier)]; ok && plan.MaxPhotos > 0 {
+		return plan.MaxPhotos
+	}
+	return defaultMaxPhotos
+}
+
+// deleteFile removes a stored photo, logging failures since the database
+// no longer references it either way
+func (s *PropertyPhotoService) deleteFile(ctx context.Context, key string) {
+	if err := s.storage.Delete(ctx, key); err != nil {
+		log.Printf("Failed to delete photo file %s: %v", key, err)
+	}
+}
+
+// displayFilename keeps the base name of an uploaded file, falling back to
+// a generic name with the detected extension
+func displayFilename(filename, extension string) string {
+	name := strings.TrimSpace(filepath.Base(strings.ReplaceAll(filename, "\\", "/")))
+	if name == "" || name == "." || name == "/" {
+		return "photo" + extension
+	}
+	if len(name) > 255 {
+		name = name[len(name)-255:]
+	}
+	return name
+}
+
+// scanPhoto reads a row selected with photoColumns
+func scanPhoto(row interface{ Scan(...interface{}) error }) (*models.PropertyPhoto, error) {
+	var p models.PropertyPhoto
+	err := row.Scan(&p.ID, &p.PropertyID, &p.StorageKey, &p.Filename, &p.ContentType, &p.SizeBytes, &p.Position, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPhotoPropertyID = "4f8e2c1a-9b3d-4e5f-8a7b-6c5d4e3f2a1b"
+	testPhotoID         = "9d8c7b6a-5f4e-4d3c-8b2a-1f0e9d8c7b6a"
+)
+
+var (
+	jpegHeader = []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00, 0x01}
+	webpHeader = []byte("RIFF\x24\x00\x00\x00WEBPVP8 ")
+)
+
+func newTestPhotoService(t *testing.T) (*PropertyPhotoService, sqlmock.Sqlmock, string) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	dir := t.TempDir()
+	return NewPropertyPhotoService(db, NewLocalPhotoStorage(dir)), mock, dir
+}
+
+// expectPhotoProperty expects the tenant's property to be looked up
+func expectPhotoProperty(mock sqlmock.Sqlmock, tenantID string, found bool) {
+	rows := sqlmock.NewRows([]string{"id", "tenant_id", "address", "city", "state", "zip_code", "price", "arv",
+		"rehab_cost", "holding_costs", "closing_costs", "bedrooms", "bathrooms", "square_feet", "lot_size",
+		"year_built", "property_type", "notes", "status", "archived_at", "created_at", "updated_at"})
+	if found {
+		now := time.Now()
+		rows.AddRow(testPhotoPropertyID, tenantID, "123 Main St", "Denver", "CO", "80202", 180000.0, 250000.0,
+			0.0, 0.0, 0.0, 3, 2.0, 1400, 0.0, 1990, "", "", "lead", nil, now, now)
+	}
+	mock.ExpectQuery(`FROM properties\s+WHERE id = \$1 AND tenant_id = \$2`).
+		WithArgs(testPhotoPropertyID, tenantID).
+		WillReturnRows(rows)
+}
+
+func photoRow(id string, position int) []driver.Value {
+	return []driver.Value{id, testPhotoPropertyID, "tenant-1/" + testPhotoPropertyID + "/" + id + ".jpg",
+		"kitchen.jpg", "image/jpeg", int64(100), position, time.Now()}
+}
+
+var photoRowColumns = []string{"id", "property_id", "storage_key", "filename", "content_type", "size_bytes", "position", "created_at"}
+
+func TestPhotoUpload_StoresFileAndMetadata(t *testing.T) {
+	service, mock, dir := newTestPhotoService(t)
+
+	contents := append(append([]byte{}, jpegHeader...), bytes.Repeat([]byte{0x42}, 88)...)
+	expectPhotoProperty(mock, "tenant-1", true)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM property_photos WHERE property_id = \$1`).
+		WithArgs(testPhotoPropertyID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`SELECT subscription_tier FROM tenants`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow("starter"))
+	mock.ExpectQuery(`INSERT INTO property_photos`).
+		WithArgs(sqlmock.AnyArg(), testPhotoPropertyID, sqlmock.AnyArg(), "kitchen.png", "image/jpeg", int64(len(contents))).
+		WillReturnRows(sqlmock.NewRows([]string{"position", "created_at"}).AddRow(3, time.Now()))
+
+	// The extension is wrong; the contents decide the type
+	photo, err := service.Upload(context.Background(), "tenant-1", testPhotoPropertyID, "C:\\photos\\kitchen.png",
+		bytes.NewReader(contents), int64(len(contents)))
+
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", photo.ContentType)
+	assert.Equal(t, 3, photo.Position)
+	assert.True(t, strings.HasSuffix(photo.StorageKey, ".jpg"))
+	stored, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(photo.StorageKey)))
+	require.NoError(t, err)
+	assert.Equal(t, contents, stored)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPhotoUpload_RejectsUnsupportedContents(t *testing.T) {
+	for name, contents := range map[string][]byte{
+		"gif":   []byte("GIF89a\x01\x00\x01\x00\x00\x00"),
+		"text":  []byte("<html>not a photo</html>"),
+		"short": {0xFF, 0xD8},
+		"empty": {},
+	} {
+		service, mock, dir := newTestPhotoService(t)
+		expectPhotoProperty(mock, "tenant-1", true)
+
+		_, err := service.Upload(context.Background(), "tenant-1", testPhotoPropertyID, "photo.jpg",
+			bytes.NewReader(contents), int64(len(contents)))
+
+		assert.ErrorIs(t, err, ErrUnsupportedPhoto, name)
+		entries, _ := os.ReadDir(dir)
+		assert.Empty(t, entries, name)
+		assert.NoError(t, mock.ExpectationsWereMet(), name)
+	}
+}
+
+func TestPhotoUpload_TooLarge(t *testing.T) {
+	service, mock, _ := newTestPhotoService(t)
+
+	_, err := service.Upload(context.Background(), "tenant-1", testPhotoPropertyID, "photo.jpg",
+		bytes.NewReader(jpegHeader), MaxPhotoSize+1)
+
+	assert.ErrorIs(t, err, ErrPhotoTooLarge)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPhotoUpload_LimitByTier(t *testing.T) {
+	for tier, limit := range map[string]int{"starter": 10, "professional": 50, "enterprise": 100} {
+		service, mock, _ := newTestPhotoService(t)
+		expectPhotoProperty(mock, "tenant-1", true)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM property_photos`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(limit))
+		mock.ExpectQuery(`SELECT subscription_tier FROM tenants`).
+			WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow(tier))
+
+		_, err := service.Upload(context.Background(), "tenant-1", testPhotoPropertyID, "photo.webp",
+			bytes.NewReader(webpHeader), int64(len(webpHeader)))
+
+		assert.ErrorIs(t, err, ErrPhotoLimitReached, tier)
+		assert.NoError(t, mock.ExpectationsWereMet(), tier)
+	}
+}
+
+func TestPhotoUpload_OtherTenantsProperty(t *testing.T) {
+	service, mock, _ := newTestPhotoService(t)
+	expectPhotoProperty(mock, "tenant-2", false)
+
+	_, err := service.Upload(context.Background(), "tenant-2", testPhotoPropertyID, "photo.jpg",
+		bytes.NewReader(jpegHeader), int64(len(jpegHeader)))
+
+	assert.ErrorIs(t, err, ErrPropertyNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPhotoReorder(t *testing.T) {
+	service, mock, _ := newTestPhotoService(t)
+	first, second := testPhotoID, "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"
+
+	expectPhotoProperty(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_photos\s+WHERE property_id = \$1\s+ORDER BY position`).
+		WillReturnRows(sqlmock.NewRows(photoRowColumns).AddRow(photoRow(first, 0)...).AddRow(photoRow(second, 1)...))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE property_photos SET position = \$1`).
+		WithArgs(0, second, testPhotoPropertyID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE property_photos SET position = \$1`).
+		WithArgs(1, first, testPhotoPropertyID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	photos, err := service.Reorder("tenant-1", testPhotoPropertyID, []string{second, first})
+
+	require.NoError(t, err)
+	assert.Equal(t, second, photos[0].ID)
+	assert.Equal(t, 1, photos[1].Position)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPhotoReorder_MustListEachPhotoOnce(t *testing.T) {
+	for name, order := range map[string][]string{
+		"missing":   {testPhotoID},
+		"duplicate": {testPhotoID, testPhotoID},
+		"unknown":   {testPhotoID, "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"},
+	} {
+		service, mock, _ := newTestPhotoService(t)
+		expectPhotoProperty(mock, "tenant-1", true)
+		mock.ExpectQuery(`FROM property_photos`).WillReturnRows(sqlmock.NewRows(photoRowColumns).
+			AddRow(photoRow(testPhotoID, 0)...).
+			AddRow(photoRow("00000000-0000-4000-8000-000000000000", 1)...))
+
+		_, err := service.Reorder("tenant-1", testPhotoPropertyID, order)
+
+		assert.ErrorIs(t, err, ErrInvalidPhotoOrder, name)
+		assert.NoError(t, mock.ExpectationsWereMet(), name)
+	}
+}
+
+func TestPhotoDelete_RemovesFile(t *testing.T) {
+	service, mock, dir := newTestPhotoService(t)
+	row := photoRow(testPhotoID, 0)
+	key := row[2].(string)
+	require.NoError(t, service.storage.Put(context.Background(), key, bytes.NewReader(jpegHeader), 12, "image/jpeg"))
+
+	expectPhotoProperty(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_photos\s+WHERE id = \$1 AND property_id = \$2`).
+		WithArgs(testPhotoID, testPhotoPropertyID).
+		WillReturnRows(sqlmock.NewRows(photoRowColumns).AddRow(row...))
+	mock.ExpectExec(`DELETE FROM property_photos WHERE id = \$1`).
+		WithArgs(testPhotoID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, service.Delete(context.Background(), "tenant-1", testPhotoPropertyID, testPhotoID))
+
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(key)))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPhotoOpen_ReadsStoredFile(t *testing.T) {
+	service, mock, _ := newTestPhotoService(t)
+	row := photoRow(testPhotoID, 0)
+	require.NoError(t, service.storage.Put(context.Background(), row[2].(string), bytes.NewReader(jpegHeader), 12, "image/jpeg"))
+
+	expectPhotoProperty(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_photos`).WillReturnRows(sqlmock.NewRows(photoRowColumns).AddRow(row...))
+
+	photo, contents, err := service.Open(context.Background(), "tenant-1", testPhotoPropertyID, testPhotoID)
+	require.NoError(t, err)
+	defer contents.Close()
+	data, err := io.ReadAll(contents)
+	require.NoError(t, err)
+	assert.Equal(t, jpegHeader, data)
+	assert.Equal(t, "image/jpeg", photo.ContentType)
+
+	// Local storage can't sign URLs, so downloads are proxied
+	expectPhotoProperty(mock, "tenant-1", true)
+	mock.ExpectQuery(`FROM property_photos`).WillReturnRows(sqlmock.NewRows(photoRowColumns).AddRow(row...))
+	url, _, err := service.SignedURL("tenant-1", testPhotoPropertyID, testPhotoID)
+	require.NoError(t, err)
+	assert.Empty(t, url)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLocalPhotoStorage_RejectsEscapingKeys(t *testing.T) {
+	storage := NewLocalPhotoStorage(t.TempDir())
+	for _, key := range []string{"", "../outside.jpg", "a/../../outside.jpg", "/etc/passwd"} {
+		err := storage.Put(context.Background(), key, bytes.NewReader(jpegHeader), 12, "image/jpeg")
+		assert.Error(t, err, key)
+	}
+}
+
+func TestS3PhotoStorage_SignsRequests(t *testing.T) {
+	var uploaded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/20260301/us-east-1/s3/aws4_request") ||
+			r.Header.Get("X-Amz-Content-Sha256") != "UNSIGNED-PAYLOAD" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/photos/tenant-1/photo.jpg":
+			uploaded, _ = io.ReadAll(r.Body)
+		case r.Method == http.MethodGet && r.URL.Path == "/photos/tenant-1/photo.jpg":
+			w.Write(uploaded)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	storage := NewS3PhotoStorage(server.URL+"/", "photos", "us-east-1", "AKID", "secret", "")
+	storage.now = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	require.NoError(t, storage.Put(context.Background(), "tenant-1/photo.jpg", bytes.NewReader(jpegHeader), 12, "image/jpeg"))
+	assert.Equal(t, jpegHeader, uploaded)
+
+	contents, err := storage.Get(context.Background(), "tenant-1/photo.jpg")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(contents)
+	contents.Close()
+	assert.Equal(t, jpegHeader, data)
+
+	_, err = storage.Get(context.Background(), "tenant-1/missing.jpg")
+	assert.ErrorIs(t, err, ErrPhotoFileNotFound)
+
+	url, err := storage.SignedURL("tenant-1/photo.jpg", 15*time.Minute)
+	require.NoError(t, err)
+	assert.Contains(t, url, server.URL+"/photos/tenant-1/photo.jpg?")
+	assert.Contains(t, url, "X-Amz-Expires=900")
+	assert.Contains(t, url, "X-Amz-Signature=")
+}
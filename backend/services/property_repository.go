@@ -0,0 +1,496 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Property errors
+var (
+	ErrPropertyFieldBlank = errors.New("address, city, state and zip code cannot be blank")
+	ErrInvalidSort        = errors.New("invalid sort field")
+	ErrPropertyNotFound   = errors.New("property not found")
+	ErrInvalidArchived    = errors.New("invalid archived filter")
+)
+
+// Page sizes for property lists
+const (
+	defaultPropertyPageSize = 20
+	maxPropertyPageSize     = 100
+)
+
+// propertySortColumns maps the sort fields clients may use to SQL. Only
+// these expressions ever reach ORDER BY.
+var propertySortColumns = map[string]string{
+	"created_at": "created_at",
+	"price":      "price",
+	"arv":        "arv",
+	"roi": `(COALESCE(arv, 0) - COALESCE(price, 0) - COALESCE(rehab_cost, 0) - COALESCE(holding_costs, 0) - COALESCE(closing_costs, 0))
+		/ NULLIF(COALESCE(price, 0) + COALESCE(rehab_cost, 0) + COALESCE(holding_costs, 0) + COALESCE(closing_costs, 0), 0)`,
+}
+
+// propertyColumns are selected, in this order, by scanProperty
+const propertyColumns = `id, tenant_id, address, COALESCE(city, ''), COALESCE(state, ''), COALESCE(zip_code, ''),
+	COALESCE(price, 0), COALESCE(arv, 0), COALESCE(rehab_cost, 0), COALESCE(holding_costs, 0),
+	COALESCE(closing_costs, 0), COALESCE(bedrooms, 0), COALESCE(bathrooms, 0), COALESCE(square_feet, 0),
+	COALESCE(lot_size, 0), COALESCE(year_built, 0), COALESCE(property_type, ''), COALESCE(notes, ''),
+	status, archived_at, created_at, updated_at`
+
+// CreatePropertyRequest holds the fields a user may set on a new property.
+// The tenant always comes from the caller's token; TenantID is only bound so
+// a request naming a different tenant can be refused.
+type CreatePropertyRequest struct {
+	TenantID     string   `json:"tenant_id"`
+	Address      string   `json:"address" binding:"required,max=500"`
+	City         string   `json:"city" binding:"required,max=100"`
+	State        string   `json:"state" binding:"required,max=50"`
+	ZipCode      string   `json:"zip_code" binding:"required,max=20"`
+	Price        *float64 `json:"price" binding:"required,min=0"`
+	ARV          float64  `json:"arv" binding:"min=0"`
+	RehabCost    float64  `json:"rehab_cost" binding:"min=0"`
+	HoldingCosts float64  `json:"holding_costs" binding:"min=0"`
+	ClosingCosts float64  `json:"closing_costs" binding:"min=0"`
+	Bedrooms     int      `json:"bedrooms" binding:"min=0"`
+	Bathrooms    float64  `json:"bathrooms" binding:"min=0,max=99"`
+	SquareFeet   int      `json:"square_feet" binding:"min=0"`
+	LotSize      float64  `json:"lot_size" binding:"min=0"`
+	YearBuilt    int      `json:"year_built" binding:"min=0"`
+	PropertyType string   `json:"property_type" binding:"max=100"`
+	Notes        string   `json:"notes"`
+	Status       string   `json:"status"`
+}
+
+// UpdatePropertyRequest holds the fields a user may change on a property.
+// Fields left out of the request keep their current value. Status changes
+// go through ChangeStatus so they are recorded.
+type UpdatePropertyRequest struct {
+	Address      *string  `json:"address" binding:"omitempty,max=500"`
+	City         *string  `json:"city" binding:"omitempty,max=100"`
+	State        *string  `json:"state" binding:"omitempty,max=50"`
+	ZipCode      *string  `json:"zip_code" binding:"omitempty,max=20"`
+	Price        *float64 `json:"price" binding:"omitempty,min=0"`
+	ARV          *float64 `json:"arv" binding:"omitempty,min=0"`
+	RehabCost    *float64 `json:"rehab_cost" binding:"omitempty,min=0"`
+	HoldingCosts *float64 `json:"holding_costs" binding:"omitempty,min=0"`
+	ClosingCosts *float64 `json:"closing_costs" binding:"omitempty,min=0"`
+	Bedrooms     *int     `json:"bedrooms" binding:"omitempty,min=0"`
+	Bathrooms    *float64 `json:"bathrooms" binding:"omitempty,min=0,max=99"`
+	SquareFeet   *int     `json:"square_feet" binding:"omitempty,min=0"`
+	LotSize      *float64 `json:"lot_size" binding:"omitempty,min=0"`
+	YearBuilt    *int     `json:"year_built" binding:"omitempty,min=0"`
+	PropertyType *string  `json:"property_type" binding:"omitempty,max=100"`
+	Notes        *string  `json:"notes"`
+}
+
+// PropertyListOptions filters, sorts and pages a property list. Sort is one
+// of created_at, price, arv or roi, prefixed with "-" for descending order.
+// Tags is a comma-separated list of tag names; TagMatch decides whether a
+// property needs any of them (the default) or all of them. Favorites limits
+// the list to the properties the caller has starred. Archived properties are
+// left out unless Archived is true (only archived ones) or all.
+type PropertyListOptions struct {
+	Page         int      `form:"page" binding:"min=0"`
+	PageSize     int      `form:"page_size" binding:"min=0"`
+	Sort         string   `form:"sort"`
+	City         string   `form:"city"`
+	State        string   `form:"state"`
+	MinPrice     *float64 `form:"min_price" binding:"omitempty,min=0"`
+	MaxPrice     *float64 `form:"max_price" binding:"omitempty,min=0"`
+	Bedrooms     *int     `form:"bedrooms" binding:"omitempty,min=0"`
+	PropertyType string   `form:"property_type"`
+	Status       string   `form:"status"`
+	Tags         string   `form:"tags"`
+	TagMatch     string   `form:"tag_match"`
+	Favorites    bool     `form:"favorites"`
+	Archived     string   `form:"archived"`
+}
+
+// PropertyPage is one page of a property list
+type PropertyPage struct {
+	Properties []models.Property `json:"properties"`
+	Total      int               `json:"total"`
+	Page       int               `json:"page"`
+	PageSize   int               `json:"page_size"`
+	TotalPages int               `json:"total_pages"`
+}
+
+// PropertyRepository stores a tenant's saved properties
+type PropertyRepository struct {
+	db *sql.DB
+}
+
+// NewPropertyRepository creates a new property repository
+func NewPropertyRepository(db *sql.DB) *PropertyRepository {
+	return &PropertyRepository{db: db}
+}
+
+// Create saves a new property for tenantID and returns it with its
+// generated ID and timestamps
+func (r *PropertyRepository) Create(tenantID string, req CreatePropertyRequest) (*models.Property, error) {
+	property := models.Property{
+		TenantID:     tenantID,
+		Address:      strings.TrimSpace(req.Address),
+		City:         strings.TrimSpace(req.City),
+		State:        strings.TrimSpace(req.State),
+		ZipCode:      strings.TrimSpace(req.ZipCode),
+		ARV:          req.ARV,
+		RehabCost:    req.RehabCost,
+		HoldingCosts: req.HoldingCosts,
+		ClosingCosts: req.ClosingCosts,
+		Bedrooms:     req.Bedrooms,
+		Bathrooms:    req.Bathrooms,
+		SquareFeet:   req.SquareFeet,
+		LotSize:      req.LotSize,
+		YearBuilt:    req.YearBuilt,
+		PropertyType: strings.TrimSpace(req.PropertyType),
+		Notes:        req.Notes,
+		Status:       req.Status,
+	}
+	if property.Address == "" || property.City == "" || property.State == "" || property.ZipCode == "" {
+		return nil, ErrPropertyFieldBlank
+	}
+	if property.Status == "" {
+		property.Status = StatusLead
+	}
+	if !ValidPropertyStatus(property.Status) {
+		return nil, ErrInvalidStatus
+	}
+	if req.Price != nil {
+		property.Price = *req.Price
+	}
+
+	err := r.db.QueryRow(`
+		INSERT INTO properties (
+			tenant_id, address, city, state, zip_code, price, arv, rehab_cost, holding_costs,
+			closing_costs, bedrooms, bathrooms, square_feet, lot_size, year_built, property_type, notes, status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		RETURNING id, created_at, updated_at
+	`, property.TenantID, property.Address, property.City, property.State, property.ZipCode,
+		property.Price, property.ARV, property.RehabCost, property.HoldingCosts, property.ClosingCosts,
+		property.Bedrooms, property.Bathrooms, property.SquareFeet, property.LotSize, property.YearBuilt,
+		property.PropertyType, property.Notes, property.Status,
+	).Scan(&property.ID, &property.CreatedAt, &property.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create property: %w", err)
+	}
+	return &property, nil
+}
+
+// List returns a page of tenantID's properties matching opts, each marked
+// with whether userID has starred it. Pages start at 1 and are at most 100
+// properties long.
+func (r *PropertyRepository) List(tenantID, userID string, opts PropertyListOptions) (*PropertyPage, error) {
+	orderBy, err := propertyOrderBy(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Status != "" && !ValidPropertyStatus(opts.Status) {
+		return nil, ErrInvalidStatus
+	}
+	if opts.TagMatch != "" && opts.TagMatch != TagMatchAny && opts.TagMatch != TagMatchAll {
+		return nil, ErrInvalidTagFilter
+	}
+	conditions := []string{"tenant_id = $1", "deleted_at IS NULL"}
+	switch opts.Archived {
+	case "", "false":
+		conditions = append(conditions, "archived_at IS NULL")
+	case "true":
+		conditions = append(conditions, "archived_at IS NOT NULL")
+	case "all":
+	default:
+		return nil, ErrInvalidArchived
+	}
+
+	page, pageSize := opts.Page, opts.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPropertyPageSize
+	}
+	if pageSize > maxPropertyPageSize {
+		pageSize = maxPropertyPageSize
+	}
+
+	args := []interface{}{tenantID}
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if city := strings.TrimSpace(opts.City); city != "" {
+		where("LOWER(city) = LOWER($%d)", city)
+	}
+	if state := strings.TrimSpace(opts.State); state != "" {
+		where("LOWER(state) = LOWER($%d)", state)
+	}
+	if opts.MinPrice != nil {
+		where("price >= $%d", *opts.MinPrice)
+	}
+	if opts.MaxPrice != nil {
+		where("price <= $%d", *opts.MaxPrice)
+	}
+	if opts.Bedrooms != nil {
+		where("bedrooms = $%d", *opts.Bedrooms)
+	}
+	if propertyType := strings.TrimSpace(opts.PropertyType); propertyType != "" {
+		where("LOWER(property_type) = LOWER($%d)", propertyType)
+	}
+	if opts.Status != "" {
+		where("status = $%d", opts.Status)
+	}
+	if names := tagFilterNames(opts.Tags); len(names) > 0 {
+		tagged := `id IN (SELECT a.property_id FROM property_tag_assignments a
+			JOIN property_tags t ON t.id = a.tag_id
+			WHERE t.tenant_id = $1 AND LOWER(t.name) = ANY($%[1]d)`
+		if opts.TagMatch == TagMatchAll {
+			// Names are unique per tenant, so a property with every tag
+			// has one matching assignment per name
+			tagged += ` GROUP BY a.property_id HAVING COUNT(*) = cardinality($%[1]d::text[])`
+		}
+		where(tagged+")", pq.Array(names))
+	}
+	if opts.Favorites {
+		where("id IN (SELECT property_id FROM property_favorites WHERE user_id = $%d)", userID)
+	}
+	filter := strings.Join(conditions, " AND ")
+
+	result := &PropertyPage{Properties: []models.Property{}, Page: page, PageSize: pageSize}
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM properties WHERE `+filter, args...).Scan(&result.Total); err != nil {
+		return nil, fmt.Errorf("failed to count properties: %w", err)
+	}
+	result.TotalPages = (result.Total + pageSize - 1) / pageSize
+	if result.Total == 0 {
+		return result, nil
+	}
+
+	args = append(args, pageSize, (page-1)*pageSize, userID)
+	rows, err := r.db.Query(fmt.Sprintf(`
+		SELECT %s,
+			EXISTS(SELECT 1 FROM property_favorites f WHERE f.property_id = properties.id AND f.user_id = $%d)
+		FROM properties
+		WHERE %s
+		ORDER BY %s, id
+		LIMIT $%d OFFSET $%d
+	`, propertyColumns, len(args), filter, orderBy, len(args)-2, len(args)-1), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list properties: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var property models.Property
+		if err := rows.Scan(append(propertyScanDest(&property), &property.IsFavorite)...); err != nil {
+			return nil, err
+		}
+		result.Properties = append(result.Properties, property)
+	}
+	return result, rows.Err()
+}
+
+// Get returns one of tenantID's properties. Properties of other tenants and
+// deleted properties are reported as not found.
+func (r *PropertyRepository) Get(tenantID, id string) (*models.Property, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrPropertyNotFound
+	}
+
+	property, err := scanProperty(r.db.QueryRow(`
+		SELECT `+propertyColumns+` FROM properties
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+	`, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, ErrPropertyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get property: %w", err)
+	}
+	return property, nil
+}
+
+// Update changes the fields set in req on one of tenantID's properties and
+// returns the property as stored
+func (r *PropertyRepository) Update(tenantID, id string, req UpdatePropertyRequest) (*models.Property, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrPropertyNotFound
+	}
+
+	assignments := []string{"updated_at = NOW()"}
+	args := []interface{}{id, tenantID}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"address", req.Address},
+		{"city", req.City},
+		{"state", req.State},
+		{"zip_code", req.ZipCode},
+	} {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if value == "" {
+			return nil, ErrPropertyFieldBlank
+		}
+		set(field.column, value)
+	}
+	for _, field := range []struct {
+		column string
+		value  *float64
+	}{
+		{"price", req.Price},
+		{"arv", req.ARV},
+		{"rehab_cost", req.RehabCost},
+		{"holding_costs", req.HoldingCosts},
+		{"closing_costs", req.ClosingCosts},
+		{"bathrooms", req.Bathrooms},
+		{"lot_size", req.LotSize},
+	} {
+		if field.value != nil {
+			set(field.column, *field.value)
+		}
+	}
+	for _, field := range []struct {
+		column string
+		value  *int
+	}{
+		{"bedrooms", req.Bedrooms},
+		{"square_feet", req.SquareFeet},
+		{"year_built", req.YearBuilt},
+	} {
+		if field.value != nil {
+			set(field.column, *field.value)
+		}
+	}
+	if req.PropertyType != nil {
+		set("property_type", strings.TrimSpace(*req.PropertyType))
+	}
+	if req.Notes != nil {
+		set("notes", *req.Notes)
+	}
+
+	property, err := scanProperty(r.db.QueryRow(`
+		UPDATE properties
+		SET `+strings.Join(assignments, ", ")+`
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+		RETURNING `+propertyColumns, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrPropertyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update property: %w", err)
+	}
+	return property, nil
+}
+
+// Delete hides one of tenantID's properties. The row is kept so ARV
+// calculations that reference it stay intact and it can be restored, but
+// it is taken off every user's favorites.
+func (r *PropertyRepository) Delete(tenantID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrPropertyNotFound
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setDeleted(tx, tenantID, id, true); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM property_favorites WHERE property_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to remove favorites: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit property deletion: %w", err)
+	}
+	return nil
+}
+
+// Restore brings back one of tenantID's deleted properties
+func (r *PropertyRepository) Restore(tenantID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrPropertyNotFound
+	}
+	return setDeleted(r.db, tenantID, id, false)
+}
+
+// setDeleted marks a property deleted or restores it. A property already in
+// the requested state is reported as not found.
+func setDeleted(db interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, tenantID, id string, deleted bool) error {
+	query := `
+		UPDATE properties
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`
+	if !deleted {
+		query = `
+		UPDATE properties
+		SET deleted_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NOT NULL`
+	}
+	result, err := db.Exec(query, id, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to update property: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update property: %w", err)
+	}
+	if rows == 0 {
+		return ErrPropertyNotFound
+	}
+	return nil
+}
+
+// propertyOrderBy turns a sort field into an ORDER BY expression, newest
+// first by default
+func propertyOrderBy(sort string) (string, error) {
+	if sort == "" {
+		sort = "-created_at"
+	}
+	direction := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		sort, direction = sort[1:], "DESC"
+	}
+	column, ok := propertySortColumns[sort]
+	if !ok {
+		return "", ErrInvalidSort
+	}
+	return column + " " + direction + " NULLS LAST", nil
+}
+
+// scanProperty reads a row selected with propertyColumns
+func scanProperty(row interface{ Scan(...interface{}) error }) (*models.Property, error) {
+	var p models.Property
+	if err := row.Scan(propertyScanDest(&p)...); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// propertyScanDest returns the fields of p that propertyColumns are read
+// into, in order
+func propertyScanDest(p *models.Property) []interface{} {
+	return []interface{}{
+		&p.ID, &p.TenantID, &p.Address, &p.City, &p.State, &p.ZipCode,
+		&p.Price, &p.ARV, &p.RehabCost, &p.HoldingCosts,
+		&p.ClosingCosts, &p.Bedrooms, &p.Bathrooms, &p.SquareFeet,
+		&p.LotSize, &p.YearBuilt, &p.PropertyType, &p.Notes,
+		&p.Status, &p.ArchivedAt, &p.CreatedAt, &p.UpdatedAt,
+	}
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// Share link errors
+var (
+	ErrShareLinkNotFound  = errors.New("share link not found")
+	ErrShareLinkExpired   = errors.New("share link has expired")
+	ErrShareLinkRevoked   = errors.New("share link has been revoked")
+	ErrInvalidShareExpiry = errors.New("share link expiry must be in the future")
+)
+
+// shareLinkColumns are selected, in this order, by scanShareLink
+const shareLinkColumns = `id, property_id, expires_at, revoked_at, view_count, last_viewed_at, created_at`
+
+// CreateShareLinkRequest sets when a new share link stops working. Links
+// without an expiry work until they are revoked.
+type CreateShareLinkRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedShareLink is a new share link with its token. The token is only
+// available here; afterwards just its hash is kept.
+type CreatedShareLink struct {
+	models.PropertyShareLink
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// SharedProperty is what a share link shows: the deal's figures, without
+// notes or anything identifying the tenant or its users
+type SharedProperty struct {
+	Address           string             `json:"address"`
+	City              string             `json:"city"`
+	State             string             `json:"state"`
+	ZipCode           string             `json:"zip_code"`
+	Price             float64            `json:"price"`
+	ARV               float64            `json:"arv"`
+	RehabCost         float64            `json:"rehab_cost"`
+	HoldingCosts      float64            `json:"holding_costs"`
+	ClosingCosts      float64            `json:"closing_costs"`
+	Bedrooms          int                `json:"bedrooms"`
+	Bathrooms         float64            `json:"bathrooms"`
+	SquareFeet        int                `json:"square_feet"`
+	LotSize           float64            `json:"lot_size"`
+	YearBuilt         int                `json:"year_built"`
+	PropertyType      string             `json:"property_type"`
+	Status            string             `json:"status"`
+	LatestCalculation *SharedCalculation `json:"latest_calculation"`
+	Comparables       []SharedComparable `json:"comparables"`
+	ExpiresAt         *time.Time         `json:"expires_at,omitempty"`
+}
+
+// SharedCalculation is the shared view of a property's latest ARV analysis
+type SharedCalculation struct {
+	PurchasePrice    models.Money `json:"purchase_price"`
+	RehabCost        models.Money `json:"rehab_cost"`
+	HoldingCosts     models.Money `json:"holding_costs"`
+	ClosingCosts     models.Money `json:"closing_costs"`
+	ARV              models.Money `json:"arv"`
+	TotalInvestment  models.Money `json:"total_investment"`
+	MonthlyCashFlow  models.Money `json:"monthly_cash_flow"`
+	CashOnCashReturn *float64     `json:"cash_on_cash_return"` // null when infinite
+	IsInfiniteReturn bool         `json:"is_infinite_return"`
+	CapRate          float64      `json:"cap_rate"`
+	DSCR             float64      `json:"dscr"`
+	RiskLevel        string       `json:"risk_level"`
+	CalculatedAt     time.Time    `json:"calculated_at"`
+}
+
+// SharedComparable is the shared view of a comparable sale
+type SharedComparable struct {
+	Address       string    `json:"address"`
+	SalePrice     float64   `json:"sale_price"`
+	SaleDate      time.Time `json:"sale_date"`
+	Distance      float64   `json:"distance"`
+	Bedrooms      int       `json:"bedrooms"`
+	Bathrooms     float64   `json:"bathrooms"`
+	SquareFeet    int       `json:"square_feet"`
+	PricePerSqFt  float64   `json:"price_per_sq_ft"`
+	AdjustedValue float64   `json:"adjusted_value"`
+}
+
+// PropertyShareService issues and resolves read-only links to a tenant's
+// properties
+type PropertyShareService struct {
+	db           *sql.DB
+	properties   *PropertyRepository
+	comparables  *ComparableRepository
+	calculations *ArvCalculationRepository
+}
+
+// NewPropertyShareService creates a new property share service
+func NewPropertyShareService(db *sql.DB) *PropertyShareService {
+	return &PropertyShareService{
+		db:           db,
+		properties:   NewPropertyRepository(db),
+		comparables:  NewComparableRepository(db),
+		calculations: NewArvCalculationRepository(db),
+	}
+}
+
+// Create issues a share link for one of tenantID's properties
+func (s *PropertyShareService) Create(tenantID, propertyID, userID string, req CreateShareLinkRequest) (*CreatedShareLink, error) {
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidShareExpiry
+	}
+	property, err := s.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	link, err := scanShareLink(s.db.QueryRow(`
+		INSERT INTO property_share_links (property_id, tenant_id, token_hash, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+shareLinkColumns,
+		property.ID, tenantID, hashToken(token), userID, req.ExpiresAt,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share link: %w", err)
+	}
+	return &CreatedShareLink{
+		PropertyShareLink: *link,
+		Token:             token,
+		URL:               appBaseURL() + "/shared/" + token,
+	}, nil
+}
+
+// List returns the share links of one of tenantID's properties, newest
+// first, including expired and revoked ones
+func (s *PropertyShareService) List(tenantID, propertyID string) ([]models.PropertyShareLink, error) {
+	property, err := s.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+shareLinkColumns+` FROM property_share_links
+		WHERE property_id = $1
+		ORDER BY created_at DESC, id
+	`, property.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list share links: %w", err)
+	}
+	defer rows.Close()
+
+	links := []models.PropertyShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *link)
+	}
+	return links, rows.Err()
+}
+
+// Revoke stops a share link of one of tenantID's properties from working.
+// Revoking a link twice keeps the first revocation time.
+func (s *PropertyShareService) Revoke(tenantID, propertyID, linkID string) error {
+	if _, err := uuid.Parse(linkID); err != nil {
+		return ErrShareLinkNotFound
+	}
+	property, err := s.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE property_share_links SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1 AND property_id = $2
+	`, linkID, property.ID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+	if affected == 0 {
+		return ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// View resolves a share link token to the shared view of its property.
+// The link is checked on every view, so revoking it takes effect at once.
+func (s *PropertyShareService) View(token string) (*SharedProperty, error) {
+	if token == "" {
+		return nil, ErrShareLinkNotFound
+	}
+
+	var linkID, propertyID, tenantID string
+	var expiresAt, revokedAt *time.Time
+	err := s.db.QueryRow(`
+		SELECT id, property_id, tenant_id, expires_at, revoked_at
+		FROM property_share_links
+		WHERE token_hash = $1
+	`, hashToken(token)).Scan(&linkID, &propertyID, &tenantID, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up share link: %w", err)
+	}
+	if revokedAt != nil {
+		return nil, ErrShareLinkRevoked
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, ErrShareLinkExpired
+	}
+
+	// A deleted property's links stop working with it
+	property, err := s.properties.Get(tenantID, propertyID)
+	if errors.Is(err, ErrPropertyNotFound) {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	calc, err := s.calculations.latest(property.ID)
+	if err != nil {
+		return nil, err
+	}
+	comparables, err := s.comparables.listForProperty(property.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The view count is only informational, so a failure to bump it
+	// shouldn't hide the deal
+	_, err = s.db.Exec(`
+		UPDATE property_share_links SET view_count = view_count + 1, last_viewed_at = NOW()
+		WHERE id = $1
+	`, linkID)
+	if err != nil {
+		log.Printf("Failed to record view of share link %s: %v", linkID, err)
+	}
+
+	shared := &SharedProperty{
+		Address:      property.Address,
+		City:         property.City,
+		State:        property.State,
+		ZipCode:      property.ZipCode,
+		Price:        property.Price,
+		ARV:          property.ARV,
+		RehabCost:    property.RehabCost,
+		HoldingCosts: property.HoldingCosts,
+		ClosingCosts: property.ClosingCosts,
+		Bedrooms:     property.Bedrooms,
+		Bathrooms:    property.Bathrooms,
+		SquareFeet:   property.SquareFeet,
+		LotSize:      property.LotSize,
+		YearBuilt:    property.YearBuilt,
+		PropertyType: property.PropertyType,
+		Status:       property.Status,
+		Comparables:  make([]SharedComparable, 0, len(comparables)),
+		ExpiresAt:    expiresAt,
+	}
+	if calc != nil {
+		shared.LatestCalculation = &SharedCalculation{
+			PurchasePrice:    calc.PurchasePrice,
+			RehabCost:        calc.RehabCost,
+			HoldingCosts:     calc.HoldingCosts,
+			ClosingCosts:     calc.ClosingCosts,
+			ARV:              calc.ARV,
+			TotalInvestment:  calc.TotalInvestment,
+			MonthlyCashFlow:  calc.MonthlyCashFlow,
+			CashOnCashReturn: calc.CashOnCashReturn,
+			IsInfiniteReturn: calc.IsInfiniteReturn,
+			CapRate:          calc.CapRate,
+			DSCR:             calc.DSCR,
+			RiskLevel:        calc.RiskLevel,
+			CalculatedAt:     calc.CreatedAt,
+		}
+	}
+	for _, comp := range comparables {
+		shared.Comparables = append(shared.Comparables, SharedComparable{
+			Address:       comp.Address,
+			SalePrice:     comp.SalePrice,
+			SaleDate:      comp.SaleDate,
+			Distance:      comp.Distance,
+			Bedrooms:      comp.Bedrooms,
+			Bathrooms:     comp.Bathrooms,
+			SquareFeet:    comp.SquareFeet,
+			PricePerSqFt:  comp.PricePerSqFt,
+			AdjustedValue: comp.AdjustedValue,
+		})
+	}
+	return shared, nil
+}
+
+// scanShareLink reads a row selected with shareLinkColumns
+func scanShareLink(row interface{ Scan(...interface{}) error }) (*models.PropertyShareLink, error) {
+	var l models.PropertyShareLink
+	err := row.Scan(&l.ID, &l.PropertyID, &l.ExpiresAt, &l.RevokedAt, &l.ViewCount, &l.LastViewedAt, &l.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
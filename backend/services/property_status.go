@@ -0,0 +1,202 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// Property status errors
+var (
+	ErrInvalidStatus           = errors.New("invalid property status")
+	ErrInvalidStatusTransition = errors.New("property can't move to that status")
+	ErrStatusReasonRequired    = errors.New("a reason is required for this status change")
+)
+
+// Deal pipeline stages, in the order a deal normally moves through them
+const (
+	StatusLead          = "lead"
+	StatusAnalyzing     = "analyzing"
+	StatusOfferMade     = "offer_made"
+	StatusUnderContract = "under_contract"
+	StatusRehabbing     = "rehabbing"
+	StatusRented        = "rented"
+	StatusSold          = "sold"
+	StatusDead          = "dead"
+)
+
+// PropertyStatuses lists every stage in pipeline order, dead last
+var PropertyStatuses = []string{
+	StatusLead, StatusAnalyzing, StatusOfferMade, StatusUnderContract,
+	StatusRehabbing, StatusRented, StatusSold, StatusDead,
+}
+
+// ChangeStatusRequest moves a property to another pipeline stage
+type ChangeStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason" binding:"max=1000"`
+}
+
+// ValidPropertyStatus reports whether status is a pipeline stage
+func ValidPropertyStatus(status string) bool {
+	return statusRank(status) >= 0
+}
+
+// statusRank is a stage's position in the pipeline, or -1 for an unknown
+// status
+func statusRank(status string) int {
+	for i, s := range PropertyStatuses {
+		if s == status {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkStatusTransition decides whether a property may move from one stage
+// to another. Deals move forward freely and can die at any point. Moving
+// backwards or reviving a dead deal needs a reason, and a sold property's
+// pipeline is over.
+func checkStatusTransition(from, to, reason string) error {
+	if !ValidPropertyStatus(to) {
+		return ErrInvalidStatus
+	}
+	if from == to || from == StatusSold {
+		return ErrInvalidStatusTransition
+	}
+	if to == StatusDead {
+		return nil
+	}
+	if (from == StatusDead || statusRank(to) < statusRank(from)) && strings.TrimSpace(reason) == "" {
+		return ErrStatusReasonRequired
+	}
+	return nil
+}
+
+// ChangeStatus moves one of tenantID's properties to another pipeline stage
+// and records the change in its history
+func (r *PropertyRepository) ChangeStatus(tenantID, id, userID string, req ChangeStatusRequest) (*models.Property, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrPropertyNotFound
+	}
+	if !ValidPropertyStatus(req.Status) {
+		return nil, ErrInvalidStatus
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	property, err := changeStatus(tx, tenantID, id, userID, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit status change: %w", err)
+	}
+	return property, nil
+}
+
+// changeStatus moves a property to another pipeline stage within tx and
+// records the change in its history
+func changeStatus(tx *sql.Tx, tenantID, id, userID string, req ChangeStatusRequest) (*models.Property, error) {
+	var from string
+	err := tx.QueryRow(`
+		SELECT status FROM properties
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+		FOR UPDATE
+	`, id, tenantID).Scan(&from)
+	if err == sql.ErrNoRows {
+		return nil, ErrPropertyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get property status: %w", err)
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if err := checkStatusTransition(from, req.Status, reason); err != nil {
+		return nil, err
+	}
+
+	property, err := scanProperty(tx.QueryRow(`
+		UPDATE properties SET status = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+propertyColumns, id, req.Status))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update property status: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO property_status_history (property_id, from_status, to_status, reason, changed_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	`, id, from, req.Status, reason, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record status change: %w", err)
+	}
+	return property, nil
+}
+
+// StatusHistory returns the status changes of one of tenantID's properties,
+// oldest first
+func (r *PropertyRepository) StatusHistory(tenantID, id string) ([]models.PropertyStatusChange, error) {
+	property, err := r.Get(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, property_id, from_status, to_status, COALESCE(reason, ''), COALESCE(changed_by::text, ''), created_at
+		FROM property_status_history
+		WHERE property_id = $1
+		ORDER BY created_at, id
+	`, property.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load status history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []models.PropertyStatusChange{}
+	for rows.Next() {
+		var change models.PropertyStatusChange
+		err := rows.Scan(&change.ID, &change.PropertyID, &change.FromStatus, &change.ToStatus,
+			&change.Reason, &change.ChangedBy, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+// CountByStatus returns how many of tenantID's unarchived properties are at
+// each pipeline stage, including stages with none
+func (r *PropertyRepository) CountByStatus(tenantID string) (map[string]int, error) {
+	rows, err := r.db.Query(`
+		SELECT status, COUNT(*) FROM properties
+		WHERE tenant_id = $1 AND deleted_at IS NULL AND archived_at IS NULL
+		GROUP BY status
+	`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count properties by status: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(PropertyStatuses))
+	for _, status := range PropertyStatuses {
+		counts[status] = 0
+	}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
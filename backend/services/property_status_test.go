@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckStatusTransition(t *testing.T) {
+	cases := []struct {
+		from, to, reason string
+		want             error
+	}{
+		{StatusLead, StatusAnalyzing, "", nil},
+		{StatusAnalyzing, StatusUnderContract, "", nil},
+		{StatusRehabbing, StatusRented, "", nil},
+		{StatusRented, StatusSold, "", nil},
+		{StatusOfferMade, StatusDead, "", nil},
+		{StatusUnderContract, StatusAnalyzing, "", ErrStatusReasonRequired},
+		{StatusUnderContract, StatusAnalyzing, "Inspection found foundation issues", nil},
+		{StatusDead, StatusUnderContract, "", ErrStatusReasonRequired},
+		{StatusDead, StatusUnderContract, "   ", ErrStatusReasonRequired},
+		{StatusDead, StatusUnderContract, "Seller came back and accepted", nil},
+		{StatusLead, StatusLead, "", ErrInvalidStatusTransition},
+		{StatusSold, StatusRented, "Bought it back", ErrInvalidStatusTransition},
+		{StatusLead, "closed", "", ErrInvalidStatus},
+		{StatusLead, "LEAD", "", ErrInvalidStatus},
+		{StatusLead, "", "", ErrInvalidStatus},
+	}
+	for _, tc := range cases {
+		err := checkStatusTransition(tc.from, tc.to, tc.reason)
+		assert.Equal(t, tc.want, err, "%s -> %s (%q)", tc.from, tc.to, tc.reason)
+	}
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Property tag errors
+var (
+	ErrTagNotFound      = errors.New("tag not found")
+	ErrTagExists        = errors.New("a tag with that name already exists")
+	ErrTagLimitReached  = errors.New("tenant has reached its tag limit")
+	ErrInvalidTagName   = errors.New("tag name can't be blank or contain commas")
+	ErrInvalidTagColor  = errors.New("tag color must be a hex color like #1A2B3C")
+	ErrInvalidTagFilter = errors.New("tag_match must be any or all")
+)
+
+const (
+	// MaxTagsPerTenant is how many tags a tenant may define
+	MaxTagsPerTenant = 50
+	// defaultTagColor is used when a tag is created without a color
+	defaultTagColor = "#6B7280"
+)
+
+// Ways a property list can match the tags it's filtered by
+const (
+	TagMatchAny = "any"
+	TagMatchAll = "all"
+)
+
+// tagColorPattern matches the #RRGGBB colors tags may use
+var tagColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// tagColumns are selected, in this order, by scanTag
+const tagColumns = `id, tenant_id, name, color, created_at, updated_at`
+
+// CreateTagRequest holds a new tag's name and optional color
+type CreateTagRequest struct {
+	Name  string `json:"name" binding:"required,max=50"`
+	Color string `json:"color"`
+}
+
+// UpdateTagRequest renames or recolors a tag. Fields left out keep their
+// current value.
+type UpdateTagRequest struct {
+	Name  *string `json:"name" binding:"omitempty,max=50"`
+	Color *string `json:"color"`
+}
+
+// TagRepository stores the tags a tenant labels its properties with
+type TagRepository struct {
+	db         *sql.DB
+	properties *PropertyRepository
+}
+
+// NewTagRepository creates a new tag repository
+func NewTagRepository(db *sql.DB) *TagRepository {
+	return &TagRepository{
+		db:         db,
+		properties: NewPropertyRepository(db),
+	}
+}
+
+// List returns tenantID's tags in name order
+func (r *TagRepository) List(tenantID string) ([]models.PropertyTag, error) {
+	rows, err := r.db.Query(`
+		SELECT `+tagColumns+` FROM property_tags
+		WHERE tenant_id = $1
+		ORDER BY LOWER(name)
+	`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	return scanTags(rows)
+}
+
+// Create adds a tag to tenantID. Names are unique per tenant regardless of
+// case, and a tenant may have at most MaxTagsPerTenant tags.
+func (r *TagRepository) Create(tenantID string, req CreateTagRequest) (*models.PropertyTag, error) {
+	name, err := normalizeTagName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	color := defaultTagColor
+	if req.Color != "" {
+		if color, err = normalizeTagColor(req.Color); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the tenant so concurrent creates can't both slip under the limit
+	if _, err := tx.Exec(`SELECT id FROM tenants WHERE id = $1 FOR UPDATE`, tenantID); err != nil {
+		return nil, fmt.Errorf("failed to lock tenant: %w", err)
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM property_tags WHERE tenant_id = $1`, tenantID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to count tags: %w", err)
+	}
+	if count >= MaxTagsPerTenant {
+		return nil, ErrTagLimitReached
+	}
+
+	tag, err := scanTag(tx.QueryRow(`
+		INSERT INTO property_tags (tenant_id, name, color)
+		VALUES ($1, $2, $3)
+		RETURNING `+tagColumns, tenantID, name, color))
+	if isUniqueViolation(err) {
+		return nil, ErrTagExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tag: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit tag: %w", err)
+	}
+	return tag, nil
+}
+
+// Update renames or recolors one of tenantID's tags
+func (r *TagRepository) Update(tenantID, id string, req UpdateTagRequest) (*models.PropertyTag, error) {
+	tag, err := r.Get(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name != nil {
+		if tag.Name, err = normalizeTagName(*req.Name); err != nil {
+			return nil, err
+		}
+	}
+	if req.Color != nil {
+		if tag.Color, err = normalizeTagColor(*req.Color); err != nil {
+			return nil, err
+		}
+	}
+
+	tag, err = scanTag(r.db.QueryRow(`
+		UPDATE property_tags SET name = $3, color = $4, updated_at = NOW()
+		WHERE id = $1 AND tenant_id = $2
+		RETURNING `+tagColumns, id, tenantID, tag.Name, tag.Color))
+	if err == sql.ErrNoRows {
+		return nil, ErrTagNotFound
+	}
+	if isUniqueViolation(err) {
+		return nil, ErrTagExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update tag: %w", err)
+	}
+	return tag, nil
+}
+
+// Delete removes one of tenantID's tags from every property and then the
+// tag itself. The properties are kept.
+func (r *TagRepository) Delete(tenantID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrTagNotFound
+	}
+	result, err := r.db.Exec(`DELETE FROM property_tags WHERE id = $1 AND tenant_id = $2`, id, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to delete tag: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete tag: %w", err)
+	}
+	if affected == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// Get returns one of tenantID's tags
+func (r *TagRepository) Get(tenantID, id string) (*models.PropertyTag, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrTagNotFound
+	}
+	tag, err := scanTag(r.db.QueryRow(`
+		SELECT `+tagColumns+` FROM property_tags
+		WHERE id = $1 AND tenant_id = $2
+	`, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTagNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag: %w", err)
+	}
+	return tag, nil
+}
+
+// ForProperty returns the tags on one of tenantID's properties in name order
+func (r *TagRepository) ForProperty(tenantID, propertyID string) ([]models.PropertyTag, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`
+		SELECT t.id, t.tenant_id, t.name, t.color, t.created_at, t.updated_at
+		FROM property_tags t
+		JOIN property_tag_assignments a ON a.tag_id = t.id
+		WHERE a.property_id = $1
+		ORDER BY LOWER(t.name)
+	`, property.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list property tags: %w", err)
+	}
+	return scanTags(rows)
+}
+
+// Attach puts one of tenantID's tags on one of its properties and returns
+// the property's tags. Attaching a tag twice is not an error.
+func (r *TagRepository) Attach(tenantID, propertyID, tagID string) ([]models.PropertyTag, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := r.Get(tenantID, tagID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.db.Exec(`
+		INSERT INTO property_tag_assignments (property_id, tag_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, property.ID, tag.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to tag property: %w", err)
+	}
+	return r.ForProperty(tenantID, propertyID)
+}
+
+// Detach takes one of tenantID's tags off one of its properties and returns
+// the property's remaining tags
+func (r *TagRepository) Detach(tenantID, propertyID, tagID string) ([]models.PropertyTag, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := r.Get(tenantID, tagID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.db.Exec(`DELETE FROM property_tag_assignments WHERE property_id = $1 AND tag_id = $2`, property.ID, tag.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to untag property: %w", err)
+	}
+	return r.ForProperty(tenantID, propertyID)
+}
+
+// tagFilterNames splits a comma-separated tags filter into distinct,
+// lower-cased tag names
+func tagFilterNames(tags string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(tags, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// normalizeTagName trims a tag name. Commas are refused since the property
+// list's tags filter is comma-separated.
+func normalizeTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.Contains(name, ",") {
+		return "", ErrInvalidTagName
+	}
+	return name, nil
+}
+
+// normalizeTagColor checks a #RRGGBB color and upper-cases it
+func normalizeTagColor(color string) (string, error) {
+	color = strings.TrimSpace(color)
+	if !tagColorPattern.MatchString(color) {
+		return "", ErrInvalidTagColor
+	}
+	return strings.ToUpper(color), nil
+}
+
+// isUniqueViolation reports whether err is Postgres refusing a duplicate
+// key
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// scanTags reads and closes rows selected with tagColumns
+func scanTags(rows *sql.Rows) ([]models.PropertyTag, error) {
+	defer rows.Close()
+	tags := []models.PropertyTag{}
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, *tag)
+	}
+	return tags, rows.Err()
+}
+
+// scanTag reads a row selected with tagColumns
+func scanTag(row interface{ Scan(...interface{}) error }) (*models.PropertyTag, error) {
+	var t models.PropertyTag
+	err := row.Scan(&t.ID, &t.TenantID, &t.Name, &t.Color, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// Unit errors
+var (
+	ErrUnitNotFound        = errors.New("unit not found")
+	ErrUnitLabelBlank      = errors.New("unit label cannot be blank")
+	ErrUnitLabelTaken      = errors.New("property already has a unit with that label")
+	ErrInvalidLeaseEndDate = errors.New("lease end date must be formatted YYYY-MM-DD")
+	ErrNoUnits             = errors.New("property has no units")
+)
+
+// unitColumns are selected, in this order, by scanUnit
+const unitColumns = `id, property_id, label, COALESCE(bedrooms, 0), COALESCE(bathrooms, 0), COALESCE(square_feet, 0),
+	current_rent, market_rent, occupied, lease_end_date, created_at, updated_at`
+
+// UnitRequest holds the fields of a rentable unit. LeaseEndDate is
+// formatted YYYY-MM-DD and may be left out for vacant or month-to-month
+// units.
+type UnitRequest struct {
+	Label        string  `json:"label" binding:"required,max=50"`
+	Bedrooms     int     `json:"bedrooms" binding:"min=0"`
+	Bathrooms    float64 `json:"bathrooms" binding:"min=0,max=99"`
+	SquareFeet   int     `json:"square_feet" binding:"min=0"`
+	CurrentRent  float64 `json:"current_rent" binding:"min=0"`
+	MarketRent   float64 `json:"market_rent" binding:"min=0"`
+	Occupied     bool    `json:"occupied"`
+	LeaseEndDate string  `json:"lease_end_date"`
+}
+
+// RentRollSummary totals a property's units. Current rent only counts
+// occupied units, since vacant ones aren't bringing any in.
+type RentRollSummary struct {
+	UnitCount        int     `json:"unit_count"`
+	OccupiedUnits    int     `json:"occupied_units"`
+	TotalCurrentRent float64 `json:"total_current_rent"`
+	TotalMarketRent  float64 `json:"total_market_rent"`
+	OccupancyPercent float64 `json:"occupancy_percent"`
+}
+
+// UnitRepository stores the rent rolls of a tenant's multi-unit properties
+type UnitRepository struct {
+	db         *sql.DB
+	properties *PropertyRepository
+}
+
+// NewUnitRepository creates a new unit repository
+func NewUnitRepository(db *sql.DB) *UnitRepository {
+	return &UnitRepository{
+		db:         db,
+		properties: NewPropertyRepository(db),
+	}
+}
+
+// List returns the units of one of tenantID's properties, ordered by label
+func (r *UnitRepository) List(tenantID, propertyID string) ([]models.PropertyUnit, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	return listUnits(r.db, property.ID)
+}
+
+// Create adds a unit to one of tenantID's properties
+func (r *UnitRepository) Create(tenantID, propertyID string, req UnitRequest) (*models.PropertyUnit, error) {
+	leaseEnd, err := normalizeUnitRequest(&req)
+	if err != nil {
+		return nil, err
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	unit, err := scanUnit(r.db.QueryRow(`
+		INSERT INTO property_units (
+			property_id, tenant_id, label, bedrooms, bathrooms, square_feet, current_rent, market_rent, occupied, lease_end_date
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+unitColumns,
+		property.ID, tenantID, req.Label, req.Bedrooms, req.Bathrooms, req.SquareFeet,
+		req.CurrentRent, req.MarketRent, req.Occupied, leaseEnd,
+	))
+	if isUniqueViolation(err) {
+		return nil, ErrUnitLabelTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create unit: %w", err)
+	}
+	return unit, nil
+}
+
+// Update replaces a unit of one of tenantID's properties
+func (r *UnitRepository) Update(tenantID, propertyID, id string, req UnitRequest) (*models.PropertyUnit, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrUnitNotFound
+	}
+	leaseEnd, err := normalizeUnitRequest(&req)
+	if err != nil {
+		return nil, err
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	unit, err := scanUnit(r.db.QueryRow(`
+		UPDATE property_units
+		SET label = $3, bedrooms = $4, bathrooms = $5, square_feet = $6, current_rent = $7, market_rent = $8,
+			occupied = $9, lease_end_date = $10, updated_at = NOW()
+		WHERE id = $1 AND property_id = $2
+		RETURNING `+unitColumns,
+		id, property.ID, req.Label, req.Bedrooms, req.Bathrooms, req.SquareFeet,
+		req.CurrentRent, req.MarketRent, req.Occupied, leaseEnd,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrUnitNotFound
+	}
+	if isUniqueViolation(err) {
+		return nil, ErrUnitLabelTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update unit: %w", err)
+	}
+	return unit, nil
+}
+
+// Delete removes a unit from one of tenantID's properties
+func (r *UnitRepository) Delete(tenantID, propertyID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrUnitNotFound
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.Exec(`DELETE FROM property_units WHERE id = $1 AND property_id = $2`, id, property.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete unit: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete unit: %w", err)
+	}
+	if rows == 0 {
+		return ErrUnitNotFound
+	}
+	return nil
+}
+
+// Summary totals the units of a property the caller has already looked up
+func (r *UnitRepository) Summary(property *models.Property) (*RentRollSummary, error) {
+	units, err := listUnits(r.db, property.ID)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeRentRoll(units), nil
+}
+
+// summarizeRentRoll totals units' rents and occupancy
+func summarizeRentRoll(units []models.PropertyUnit) *RentRollSummary {
+	summary := &RentRollSummary{UnitCount: len(units)}
+	for _, unit := range units {
+		summary.TotalMarketRent += unit.MarketRent
+		if unit.Occupied {
+			summary.OccupiedUnits++
+			summary.TotalCurrentRent += unit.CurrentRent
+		}
+	}
+	if summary.UnitCount > 0 {
+		summary.OccupancyPercent = roundCents(float64(summary.OccupiedUnits) / float64(summary.UnitCount) * 100)
+	}
+	summary.TotalCurrentRent = roundCents(summary.TotalCurrentRent)
+	summary.TotalMarketRent = roundCents(summary.TotalMarketRent)
+	return summary
+}
+
+// marketRentTotal sums the market rents of a property's units
+func marketRentTotal(db *sql.DB, propertyID string) (float64, error) {
+	var total float64
+	var count int
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(market_rent), 0), COUNT(*) FROM property_units WHERE property_id = $1
+	`, propertyID).Scan(&total, &count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to total unit rents: %w", err)
+	}
+	if count == 0 {
+		return 0, ErrNoUnits
+	}
+	return total, nil
+}
+
+// listUnits returns a property's units, ordered by label
+func listUnits(db *sql.DB, propertyID string) ([]models.PropertyUnit, error) {
+	rows, err := db.Query(`
+		SELECT `+unitColumns+` FROM property_units
+		WHERE property_id = $1
+		ORDER BY label, id
+	`, propertyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list units: %w", err)
+	}
+	defer rows.Close()
+
+	units := []models.PropertyUnit{}
+	for rows.Next() {
+		unit, err := scanUnit(rows)
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, *unit)
+	}
+	return units, rows.Err()
+}
+
+// normalizeUnitRequest trims a unit's label and parses its lease end date
+func normalizeUnitRequest(req *UnitRequest) (*time.Time, error) {
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Label == "" {
+		return nil, ErrUnitLabelBlank
+	}
+	if req.LeaseEndDate == "" {
+		return nil, nil
+	}
+	leaseEnd, err := time.Parse("2006-01-02", req.LeaseEndDate)
+	if err != nil {
+		return nil, ErrInvalidLeaseEndDate
+	}
+	return &leaseEnd, nil
+}
+
+// scanUnit reads a row selected with unitColumns
+func scanUnit(row interface{ Scan(...interface{}) error }) (*models.PropertyUnit, error) {
+	var u models.PropertyUnit
+	err := row.Scan(&u.ID, &u.PropertyID, &u.Label, &u.Bedrooms, &u.Bathrooms, &u.SquareFeet,
+		&u.CurrentRent, &u.MarketRent, &u.Occupied, &u.LeaseEndDate, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
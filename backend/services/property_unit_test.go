@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+
+	"arvfinder-backend/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeRentRoll_FourplexWithTwoVacancies(t *testing.T) {
+	summary := summarizeRentRoll([]models.PropertyUnit{
+		{Label: "A", CurrentRent: 1200, MarketRent: 1300, Occupied: true},
+		{Label: "B", CurrentRent: 1150.50, MarketRent: 1300, Occupied: true},
+		// A vacant unit's last rent isn't coming in
+		{Label: "C", CurrentRent: 1100, MarketRent: 1350},
+		{Label: "D", MarketRent: 1350},
+	})
+
+	assert.Equal(t, &RentRollSummary{
+		UnitCount:        4,
+		OccupiedUnits:    2,
+		TotalCurrentRent: 2350.50,
+		TotalMarketRent:  5300,
+		OccupancyPercent: 50,
+	}, summary)
+}
+
+func TestSummarizeRentRoll(t *testing.T) {
+	assert.Equal(t, &RentRollSummary{}, summarizeRentRoll(nil), "a single dwelling has an empty rent roll")
+
+	summary := summarizeRentRoll([]models.PropertyUnit{{Occupied: true}, {}, {}})
+	assert.Equal(t, 33.33, summary.OccupancyPercent)
+}
@@ -8,7 +8,8 @@ import (
 
 // RateLimiter handles rate limiting and brute force protection
 type RateLimiter struct {
-	db *sql.DB
+	db     *sql.DB
+	limits map[string]RateLimit // overrides defaultRateLimits, set with SetLimit
 }
 
 // RateLimit represents a rate limit configuration
@@ -45,6 +46,36 @@ var defaultRateLimits = map[string]RateLimit{
 		Window:      time.Hour,
 		BlockTime:   time.Hour,
 	},
+	// Per destination number, so rotating IPs can't pump SMS to one phone
+	"sms_send_phone_hourly": {
+		MaxAttempts: 3,
+		Window:      time.Hour,
+		BlockTime:   time.Hour,
+	},
+	"sms_send_phone_daily": {
+		MaxAttempts: 10,
+		Window:      24 * time.Hour,
+		BlockTime:   24 * time.Hour,
+	},
+	// Failed logins per IP. Being "blocked" means the next login must pass a
+	// CAPTCHA, not that it is refused.
+	"login_captcha": {
+		MaxAttempts: 2,
+		Window:      time.Hour,
+		BlockTime:   time.Hour,
+	},
+	// Every SMS we send, across all users
+	"sms_daily_budget": {
+		MaxAttempts: 1000,
+		Window:      24 * time.Hour,
+		BlockTime:   time.Hour,
+	},
+	// Views of public share links per IP, so tokens can't be brute-forced
+	"shared_property_view": {
+		MaxAttempts: 60,
+		Window:      time.Minute,
+		BlockTime:   5 * time.Minute,
+	},
 }
 
 // NewRateLimiter creates a new rate limiter instance
@@ -52,9 +83,26 @@ func NewRateLimiter(db *sql.DB) *RateLimiter {
 	return &RateLimiter{db: db}
 }
 
+// SetLimit overrides the default limit for action on this rate limiter
+func (r *RateLimiter) SetLimit(action string, limit RateLimit) {
+	if r.limits == nil {
+		r.limits = make(map[string]RateLimit)
+	}
+	r.limits[action] = limit
+}
+
+// limitFor returns the limit for action, preferring overrides
+func (r *RateLimiter) limitFor(action string) (RateLimit, bool) {
+	if limit, ok := r.limits[action]; ok {
+		return limit, true
+	}
+	limit, ok := defaultRateLimits[action]
+	return limit, ok
+}
+
 // IsAllowed checks if an action is allowed for the given identifier
 func (r *RateLimiter) IsAllowed(identifier, action string) (bool, time.Duration, error) {
-	limit, exists := defaultRateLimits[action]
+	limit, exists := r.limitFor(action)
 	if !exists {
 		// If no rate limit is defined, allow the action
 		return true, 0, nil
@@ -125,7 +173,7 @@ func (r *RateLimiter) IsAllowed(identifier, action string) (bool, time.Duration,
 
 // RecordAttempt records an attempt for the given identifier and action
 func (r *RateLimiter) RecordAttempt(identifier, action string) error {
-	limit, exists := defaultRateLimits[action]
+	limit, exists := r.limitFor(action)
 	if !exists {
 		// If no rate limit is defined, don't record anything
 		return nil
@@ -193,7 +241,7 @@ func (r *RateLimiter) ResetAttempts(identifier, action string) error {
 
 // GetRemainingAttempts returns the number of remaining attempts for an identifier/action
 func (r *RateLimiter) GetRemainingAttempts(identifier, action string) (int, error) {
-	limit, exists := defaultRateLimits[action]
+	limit, exists := r.limitFor(action)
 	if !exists {
 		return 0, fmt.Errorf("no rate limit defined for action: %s", action)
 	}
@@ -278,7 +326,7 @@ type RateLimitInfo struct {
 
 // GetRateLimitInfo returns detailed rate limit information for an identifier/action
 func (r *RateLimiter) GetRateLimitInfo(identifier, action string) (*RateLimitInfo, error) {
-	limit, exists := defaultRateLimits[action]
+	limit, exists := r.limitFor(action)
 	if !exists {
 		return nil, fmt.Errorf("no rate limit defined for action: %s", action)
 	}
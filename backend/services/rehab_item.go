@@ -0,0 +1,306 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"arvfinder-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// Rehab item errors
+var (
+	ErrRehabItemNotFound      = errors.New("rehab item not found")
+	ErrInvalidRehabCategory   = errors.New("invalid rehab category")
+	ErrInvalidRehabItemStatus = errors.New("invalid rehab item status")
+	ErrRehabDescriptionBlank  = errors.New("rehab item description cannot be blank")
+	ErrNoRehabItems           = errors.New("property has no rehab items")
+)
+
+// Where a saved ARV calculation's rehab cost came from
+const (
+	RehabCostSourceManual     = "manual"
+	RehabCostSourceRehabItems = "rehab_items"
+)
+
+// RehabCategories lists the kinds of work a rehab item can be for, in the
+// order the roll-up reports them
+var RehabCategories = []string{
+	"roof", "kitchen", "bathroom", "hvac", "plumbing", "electrical", "flooring", "paint",
+	"windows_doors", "foundation", "exterior", "landscaping", "permits", "other",
+}
+
+// RehabItemStatuses lists the stages a rehab item goes through
+var RehabItemStatuses = []string{"planned", "in_progress", "completed"}
+
+// rehabItemColumns are selected, in this order, by scanRehabItem
+const rehabItemColumns = `id, property_id, category, description, estimated_cost, actual_cost, status,
+	created_at, updated_at`
+
+// RehabItemRequest holds the fields of a rehab item. Status defaults to
+// planned, and ActualCost is left out until the work has been paid for.
+type RehabItemRequest struct {
+	Category      string   `json:"category" binding:"required"`
+	Description   string   `json:"description" binding:"required,max=500"`
+	EstimatedCost float64  `json:"estimated_cost" binding:"min=0"`
+	ActualCost    *float64 `json:"actual_cost" binding:"omitempty,min=0"`
+	Status        string   `json:"status"`
+}
+
+// RehabCategoryTotal is the estimated and actual cost of a property's rehab
+// items in one category. Actual only counts items with an actual cost.
+type RehabCategoryTotal struct {
+	Category  string  `json:"category"`
+	Items     int     `json:"items"`
+	Estimated float64 `json:"estimated"`
+	Actual    float64 `json:"actual"`
+	Variance  float64 `json:"variance"`
+}
+
+// RehabRollup totals a property's rehab items by category. Variance is
+// actual minus estimated for the items that have an actual cost, so work
+// still to be paid for doesn't show as under budget.
+type RehabRollup struct {
+	Categories      []RehabCategoryTotal `json:"categories"`
+	EstimatedTotal  float64              `json:"estimated_total"`
+	ActualTotal     float64              `json:"actual_total"`
+	Variance        float64              `json:"variance"`
+	Items           int                  `json:"items"`
+	ItemsWithActual int                  `json:"items_with_actual"`
+}
+
+// RehabItemRepository stores the rehab budget line items of a tenant's
+// properties
+type RehabItemRepository struct {
+	db         *sql.DB
+	properties *PropertyRepository
+}
+
+// NewRehabItemRepository creates a new rehab item repository
+func NewRehabItemRepository(db *sql.DB) *RehabItemRepository {
+	return &RehabItemRepository{
+		db:         db,
+		properties: NewPropertyRepository(db),
+	}
+}
+
+// List returns the rehab items of one of tenantID's properties, oldest
+// first
+func (r *RehabItemRepository) List(tenantID, propertyID string) ([]models.RehabItem, error) {
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	return listRehabItems(r.db, property.ID)
+}
+
+// Create adds a rehab item to one of tenantID's properties
+func (r *RehabItemRepository) Create(tenantID, propertyID string, req RehabItemRequest) (*models.RehabItem, error) {
+	req, err := normalizeRehabItemRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := scanRehabItem(r.db.QueryRow(`
+		INSERT INTO rehab_items (property_id, tenant_id, category, description, estimated_cost, actual_cost, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+rehabItemColumns,
+		property.ID, tenantID, req.Category, req.Description, req.EstimatedCost, req.ActualCost, req.Status,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rehab item: %w", err)
+	}
+	return item, nil
+}
+
+// Update replaces a rehab item of one of tenantID's properties
+func (r *RehabItemRepository) Update(tenantID, propertyID, id string, req RehabItemRequest) (*models.RehabItem, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrRehabItemNotFound
+	}
+	req, err := normalizeRehabItemRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := scanRehabItem(r.db.QueryRow(`
+		UPDATE rehab_items
+		SET category = $3, description = $4, estimated_cost = $5, actual_cost = $6, status = $7, updated_at = NOW()
+		WHERE id = $1 AND property_id = $2
+		RETURNING `+rehabItemColumns,
+		id, property.ID, req.Category, req.Description, req.EstimatedCost, req.ActualCost, req.Status,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrRehabItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update rehab item: %w", err)
+	}
+	return item, nil
+}
+
+// Delete removes a rehab item from one of tenantID's properties
+func (r *RehabItemRepository) Delete(tenantID, propertyID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrRehabItemNotFound
+	}
+	property, err := r.properties.Get(tenantID, propertyID)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.Exec(`DELETE FROM rehab_items WHERE id = $1 AND property_id = $2`, id, property.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete rehab item: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete rehab item: %w", err)
+	}
+	if rows == 0 {
+		return ErrRehabItemNotFound
+	}
+	return nil
+}
+
+// Rollup totals the estimated and actual costs of one of tenantID's
+// properties' rehab items by category
+func (r *RehabItemRepository) Rollup(tenantID, propertyID string) (*RehabRollup, error) {
+	items, err := r.List(tenantID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	return rollUpRehabItems(items), nil
+}
+
+// rollUpRehabItems totals items by category, in RehabCategories order
+func rollUpRehabItems(items []models.RehabItem) *RehabRollup {
+	totals := map[string]*RehabCategoryTotal{}
+	rollup := &RehabRollup{Categories: []RehabCategoryTotal{}, Items: len(items)}
+	var budgeted float64
+	for _, item := range items {
+		total, ok := totals[item.Category]
+		if !ok {
+			total = &RehabCategoryTotal{Category: item.Category}
+			totals[item.Category] = total
+		}
+		total.Items++
+		total.Estimated += item.EstimatedCost
+		rollup.EstimatedTotal += item.EstimatedCost
+		if item.ActualCost != nil {
+			total.Actual += *item.ActualCost
+			total.Variance += *item.ActualCost - item.EstimatedCost
+			rollup.ActualTotal += *item.ActualCost
+			budgeted += item.EstimatedCost
+			rollup.ItemsWithActual++
+		}
+	}
+	rollup.Variance = rollup.ActualTotal - budgeted
+
+	for _, category := range RehabCategories {
+		if total, ok := totals[category]; ok {
+			total.Estimated = roundCents(total.Estimated)
+			total.Actual = roundCents(total.Actual)
+			total.Variance = roundCents(total.Variance)
+			rollup.Categories = append(rollup.Categories, *total)
+		}
+	}
+	rollup.EstimatedTotal = roundCents(rollup.EstimatedTotal)
+	rollup.ActualTotal = roundCents(rollup.ActualTotal)
+	rollup.Variance = roundCents(rollup.Variance)
+	return rollup
+}
+
+// estimatedRehabCost sums the estimated costs of a property's rehab items
+func estimatedRehabCost(db *sql.DB, propertyID string) (float64, error) {
+	var total float64
+	var count int
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(estimated_cost), 0), COUNT(*) FROM rehab_items WHERE property_id = $1
+	`, propertyID).Scan(&total, &count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to total rehab items: %w", err)
+	}
+	if count == 0 {
+		return 0, ErrNoRehabItems
+	}
+	return total, nil
+}
+
+// listRehabItems returns a property's rehab items, oldest first
+func listRehabItems(db *sql.DB, propertyID string) ([]models.RehabItem, error) {
+	rows, err := db.Query(`
+		SELECT `+rehabItemColumns+` FROM rehab_items
+		WHERE property_id = $1
+		ORDER BY created_at, id
+	`, propertyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rehab items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []models.RehabItem{}
+	for rows.Next() {
+		item, err := scanRehabItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return items, rows.Err()
+}
+
+// normalizeRehabItemRequest trims a rehab item's description, defaults its
+// status and checks its category and status
+func normalizeRehabItemRequest(req RehabItemRequest) (RehabItemRequest, error) {
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Description == "" {
+		return req, ErrRehabDescriptionBlank
+	}
+	req.Category = strings.ToLower(strings.TrimSpace(req.Category))
+	valid := false
+	for _, category := range RehabCategories {
+		valid = valid || category == req.Category
+	}
+	if !valid {
+		return req, ErrInvalidRehabCategory
+	}
+	if req.Status == "" {
+		req.Status = "planned"
+	}
+	valid = false
+	for _, status := range RehabItemStatuses {
+		valid = valid || status == req.Status
+	}
+	if !valid {
+		return req, ErrInvalidRehabItemStatus
+	}
+	return req, nil
+}
+
+// roundCents rounds an amount to the nearest cent, as Money does
+func roundCents(amount float64) float64 {
+	return models.MoneyFromFloat(amount).Float64()
+}
+
+// scanRehabItem reads a row selected with rehabItemColumns
+func scanRehabItem(row interface{ Scan(...interface{}) error }) (*models.RehabItem, error) {
+	var item models.RehabItem
+	err := row.Scan(&item.ID, &item.PropertyID, &item.Category, &item.Description, &item.EstimatedCost,
+		&item.ActualCost, &item.Status, &item.CreatedAt, &item.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
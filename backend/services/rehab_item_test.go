@@ -0,0 +1,72 @@
+package services
+
+import (
+	"testing"
+
+	"arvfinder-backend/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rehabItem(category string, estimated float64, actual *float64) models.RehabItem {
+	return models.RehabItem{Category: category, EstimatedCost: estimated, ActualCost: actual}
+}
+
+func cost(amount float64) *float64 {
+	return &amount
+}
+
+func TestRollUpRehabItems(t *testing.T) {
+	rollup := rollUpRehabItems([]models.RehabItem{
+		rehabItem("kitchen", 18000, cost(21500.25)),
+		rehabItem("roof", 9500, cost(9000)),
+		rehabItem("kitchen", 4200.10, nil),
+		rehabItem("paint", 3100.20, nil),
+		rehabItem("kitchen", 1500, cost(1350)),
+	})
+
+	require.Len(t, rollup.Categories, 3)
+	// Categories come back in RehabCategories order, not insertion order
+	assert.Equal(t, []string{"roof", "kitchen", "paint"},
+		[]string{rollup.Categories[0].Category, rollup.Categories[1].Category, rollup.Categories[2].Category})
+
+	kitchen := rollup.Categories[1]
+	assert.Equal(t, 3, kitchen.Items)
+	assert.Equal(t, 23700.10, kitchen.Estimated)
+	assert.Equal(t, 22850.25, kitchen.Actual)
+	// Only the two paid items count towards the variance: 22850.25 - 19500
+	assert.Equal(t, 3350.25, kitchen.Variance)
+
+	assert.Equal(t, RehabCategoryTotal{Category: "paint", Items: 1, Estimated: 3100.20}, rollup.Categories[2])
+
+	assert.Equal(t, 36300.30, rollup.EstimatedTotal)
+	assert.Equal(t, 31850.25, rollup.ActualTotal)
+	assert.Equal(t, 2850.25, rollup.Variance, "31850.25 actual against 29000 estimated for the paid items")
+	assert.Equal(t, 5, rollup.Items)
+	assert.Equal(t, 3, rollup.ItemsWithActual)
+}
+
+func TestRollUpRehabItems_Empty(t *testing.T) {
+	rollup := rollUpRehabItems(nil)
+
+	assert.NotNil(t, rollup.Categories)
+	assert.Empty(t, rollup.Categories)
+	assert.Zero(t, rollup.EstimatedTotal)
+	assert.Zero(t, rollup.Variance)
+}
+
+func TestNormalizeRehabItemRequest(t *testing.T) {
+	req, err := normalizeRehabItemRequest(RehabItemRequest{Category: " HVAC ", Description: " New furnace "})
+	require.NoError(t, err)
+	assert.Equal(t, "hvac", req.Category)
+	assert.Equal(t, "New furnace", req.Description)
+	assert.Equal(t, "planned", req.Status)
+
+	_, err = normalizeRehabItemRequest(RehabItemRequest{Category: "pool", Description: "Resurface"})
+	assert.ErrorIs(t, err, ErrInvalidRehabCategory)
+	_, err = normalizeRehabItemRequest(RehabItemRequest{Category: "roof", Description: "Shingles", Status: "done"})
+	assert.ErrorIs(t, err, ErrInvalidRehabItemStatus)
+	_, err = normalizeRehabItemRequest(RehabItemRequest{Category: "roof", Description: "   "})
+	assert.ErrorIs(t, err, ErrRehabDescriptionBlank)
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrReportNotPaid is returned when there's no unused purchase to generate
+// a report with
+var ErrReportNotPaid = errors.New("report has not been paid for")
+
+// ReportPurchaseRepository spends the report purchases the billing webhook
+// records, one per report
+type ReportPurchaseRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewReportPurchaseRepository creates a new report purchase repository
+func NewReportPurchaseRepository(db *sql.DB) *ReportPurchaseRepository {
+	return &ReportPurchaseRepository{db: db, now: time.Now}
+}
+
+// ConsumePurchase uses up tenantID's oldest unused purchase of a report on
+// propertyID, returning its payment intent ID, or ErrReportNotPaid when
+// there isn't one. Finding and consuming the purchase is one statement, so
+// two reports at once can't both spend it.
+func (r *ReportPurchaseRepository) ConsumePurchase(tenantID, propertyID string) (string, error) {
+	var paymentIntentID string
+	err := r.db.QueryRow(`
+		UPDATE report_purchases SET consumed_at = $3
+		WHERE payment_intent_id = (
+			SELECT payment_intent_id FROM report_purchases
+			WHERE tenant_id = $1 AND property_id = $2 AND consumed_at IS NULL
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		) AND consumed_at IS NULL
+		RETURNING payment_intent_id
+	`, tenantID, propertyID, r.now()).Scan(&paymentIntentID)
+	if err == sql.ErrNoRows {
+		return "", ErrReportNotPaid
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to use report purchase: %w", err)
+	}
+	return paymentIntentID, nil
+}
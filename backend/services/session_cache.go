@@ -0,0 +1,77 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// sessionCache remembers recently validated sessions so ValidateToken can
+// skip the user_sessions lookup on every request. Only positive results are
+// cached, and revocations made through AuthService evict entries at once;
+// revocations from another process are seen after at most ttl.
+type sessionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]sessionCacheEntry // keyed by access token JTI
+}
+
+type sessionCacheEntry struct {
+	userID    string
+	expiresAt time.Time
+}
+
+func newSessionCache(ttl time.Duration) *sessionCache {
+	return &sessionCache{
+		ttl:     ttl,
+		entries: make(map[string]sessionCacheEntry),
+	}
+}
+
+// valid reports whether jti was confirmed active within the last ttl
+func (c *sessionCache) valid(jti string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[jti]
+	if !ok {
+		return false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, jti)
+		return false
+	}
+	return true
+}
+
+func (c *sessionCache) store(jti, userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop stale entries opportunistically so the map can't grow unbounded
+	now := time.Now()
+	if len(c.entries) > 10000 {
+		for key, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+	}
+
+	c.entries[jti] = sessionCacheEntry{userID: userID, expiresAt: now.Add(c.ttl)}
+}
+
+func (c *sessionCache) evict(jti string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, jti)
+}
+
+func (c *sessionCache) evictUser(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.userID == userID {
+			delete(c.entries, key)
+		}
+	}
+}
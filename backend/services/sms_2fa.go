@@ -1,13 +1,17 @@
 package services
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
-	"net/http"
-	"net/url"
-	"strings"
+	"os"
+	"strconv"
 	"time"
 )
 
@@ -15,33 +19,48 @@ import (
 type SMS2FAService struct {
 	db           *sql.DB
 	authService  *AuthService
-	twilioSID    string
-	twilioToken  string
-	twilioPhone  string
-	testMode     bool // For testing without actual SMS
+	provider     SMSProvider
+	emailSender  EmailSender // Fallback channel; nil disables email codes
+	disabled     bool   // Circuit breaker: refuse to send any SMS
+	codeKey      []byte // HMAC key for stored verification codes
+	rateLimiter  *RateLimiter
 }
 
+// SMS sending errors
+var (
+	ErrSMSDisabled        = errors.New("SMS sending is disabled")
+	ErrSMSBudgetExhausted = errors.New("daily SMS budget exhausted")
+)
+
 // SMSVerificationRequest represents an SMS verification request
 type SMSVerificationRequest struct {
 	PhoneNumber string `json:"phone_number" binding:"required"`
 	Purpose     string `json:"purpose" binding:"required"` // 'login', 'register', 'password_reset'
 	UserID      string `json:"user_id,omitempty"`
+	Method      string `json:"method,omitempty"` // "sms" (default) or "email"
+	Email       string `json:"-"`                // Where email codes go; fallback is only possible when set
+	ClientIP    string `json:"-"`                // Rate limited per IP when set
 }
 
 // SMSVerificationResponse represents the response to SMS verification request
 type SMSVerificationResponse struct {
-	Success   bool   `json:"success"`
-	Message   string `json:"message"`
-	CodeSent  bool   `json:"code_sent"`
-	ExpiresAt int64  `json:"expires_at"`
+	Success        bool   `json:"success"`
+	Message        string `json:"message"`
+	CodeSent       bool   `json:"code_sent"`
+	DeliveryMethod string `json:"delivery_method,omitempty"` // "sms" or "email"
+	ExpiresAt      int64  `json:"expires_at"`
+	RetryAfter     int    `json:"retry_after,omitempty"` // Seconds, when rate limited
 }
 
 // VerifyCodeRequest represents a code verification request
 type VerifyCodeRequest struct {
-	PhoneNumber string `json:"phone_number" binding:"required"`
+	PhoneNumber string `json:"phone_number,omitempty"` // Login fills this from the user record
 	Code        string `json:"code" binding:"required,len=6"`
-	Purpose     string `json:"purpose" binding:"required"`
-	UserID      string `json:"user_id,omitempty"`
+	Purpose     string `json:"purpose,omitempty"`
+	UserID      string `json:"user_id" binding:"required"`
+	TempToken   string `json:"temp_token" binding:"required"` // Issued by login when 2FA is required
+	TrustDevice bool   `json:"trust_device,omitempty"`       // Skip 2FA on this device for 30 days
+	RememberMe  bool   `json:"remember_me,omitempty"`        // Carried over from the login request
 }
 
 // VerifyCodeResponse represents the response to code verification
@@ -51,24 +70,70 @@ type VerifyCodeResponse struct {
 	Verified bool   `json:"verified"`
 }
 
-// NewSMS2FAService creates a new SMS 2FA service
-// For Twilio integration, you'll need to provide:
-// - Twilio Account SID
-// - Twilio Auth Token  
-// - Twilio Phone Number
-func NewSMS2FAService(db *sql.DB, authService *AuthService, twilioSID, twilioToken, twilioPhone string) *SMS2FAService {
-	testMode := twilioSID == "" || twilioToken == "" || twilioPhone == ""
-	
+// NewSMS2FAService creates a new SMS 2FA service sending through provider.
+// A nil provider logs messages instead of sending them.
+func NewSMS2FAService(db *sql.DB, authService *AuthService, provider SMSProvider) *SMS2FAService {
+	if provider == nil {
+		provider = LogSMSProvider{}
+	}
+
 	return &SMS2FAService{
 		db:          db,
 		authService: authService,
-		twilioSID:   twilioSID,
-		twilioToken: twilioToken,
-		twilioPhone: twilioPhone,
-		testMode:    testMode,
+		provider:    provider,
+		rateLimiter: NewRateLimiter(db),
+		codeKey:     smsCodeKey(authService),
 	}
 }
 
+// smsCodeKey derives the code HMAC key from the JWT signing key, so no extra
+// configuration is needed. Rotating the key invalidates codes still pending,
+// which expire within minutes anyway.
+func smsCodeKey(authService *AuthService) []byte {
+	key := sha256.Sum256(append([]byte("sms-verification-code:"), authService.signingKey().Secret...))
+	return key[:]
+}
+
+// NewSMS2FAServiceFromEnv creates an SMS 2FA service using the provider from
+// NewSMSProviderFromEnv, falling back to NewEmailSenderFromEnv for codes SMS
+// can't deliver. SMS_DAILY_BUDGET caps sends across all users per day and
+// SMS_SENDING_DISABLED=true switches sending off entirely.
+func NewSMS2FAServiceFromEnv(db *sql.DB, authService *AuthService) *SMS2FAService {
+	s := NewSMS2FAService(db, authService, NewSMSProviderFromEnv())
+	s.SetEmailSender(NewEmailSenderFromEnv())
+
+	if value := os.Getenv("SMS_DAILY_BUDGET"); value != "" {
+		if budget, err := strconv.Atoi(value); err == nil && budget > 0 {
+			s.SetDailyBudget(budget)
+		} else {
+			fmt.Printf("Ignoring invalid SMS_DAILY_BUDGET %q\n", value)
+		}
+	}
+	if disabled, _ := strconv.ParseBool(os.Getenv("SMS_SENDING_DISABLED")); disabled {
+		s.SetSendingDisabled(true)
+	}
+	return s
+}
+
+// SetEmailSender enables emailing verification codes, on request or when SMS
+// delivery fails
+func (s *SMS2FAService) SetEmailSender(sender EmailSender) {
+	s.emailSender = sender
+}
+
+// SetDailyBudget caps the number of SMS sent per day across all users
+func (s *SMS2FAService) SetDailyBudget(budget int) {
+	limit, _ := s.rateLimiter.limitFor("sms_daily_budget")
+	limit.MaxAttempts = budget
+	s.rateLimiter.SetLimit("sms_daily_budget", limit)
+}
+
+// SetSendingDisabled turns all SMS sending off, e.g. during a toll fraud
+// attack, without a redeploy of the 2FA configuration
+func (s *SMS2FAService) SetSendingDisabled(disabled bool) {
+	s.disabled = disabled
+}
+
 // GenerateVerificationCode generates a secure 6-digit verification code
 func (s *SMS2FAService) GenerateVerificationCode() (string, error) {
 	// Generate a secure random 6-digit code
@@ -84,13 +149,64 @@ func (s *SMS2FAService) GenerateVerificationCode() (string, error) {
 	return code, nil
 }
 
-// SendVerificationCode sends a verification code via SMS
+// SendVerificationCode sends a verification code via SMS, or by email when
+// requested or when SMS can't get through and request.Email is set
 func (s *SMS2FAService) SendVerificationCode(request *SMSVerificationRequest) (*SMSVerificationResponse, error) {
-	// Validate phone number format (basic validation)
-	if !s.isValidPhoneNumber(request.PhoneNumber) {
+	// Codes are stored and rate limited under the E.164 form, however the
+	// number was typed
+	phoneNumber, err := NormalizePhoneNumber(request.PhoneNumber)
+	if err != nil {
+		return &SMSVerificationResponse{
+			Success: false,
+			Message: invalidPhoneNumberMessage,
+		}, nil
+	}
+	request.PhoneNumber = phoneNumber
+
+	// Email can't stand in for proving the user owns the phone
+	canEmail := s.emailSender != nil && request.Email != "" && request.Purpose != "phone_verification"
+	method := request.Method
+	switch method {
+	case "", "sms":
+		method = "sms"
+	case "email":
+		if !canEmail {
+			return &SMSVerificationResponse{
+				Success: false,
+				Message: "Email verification codes are not available",
+			}, nil
+		}
+	default:
 		return &SMSVerificationResponse{
 			Success: false,
-			Message: "Invalid phone number format",
+			Message: "Invalid delivery method",
+		}, nil
+	}
+
+	if method == "sms" && s.disabled {
+		if !canEmail {
+			return &SMSVerificationResponse{
+				Success: false,
+				Message: "SMS verification is temporarily unavailable",
+			}, nil
+		}
+		method = "email"
+	}
+
+	retryAfter, blockedBy, err := s.checkSendLimits(request, method == "sms")
+	if err == nil && blockedBy == "sms_daily_budget" && canEmail {
+		// The budget only covers SMS; email is still subject to the rest
+		method = "email"
+		retryAfter, _, err = s.checkSendLimits(request, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if retryAfter > 0 {
+		return &SMSVerificationResponse{
+			Success:    false,
+			Message:    "Too many verification codes requested. Please try again later.",
+			RetryAfter: int(retryAfter.Seconds()),
 		}, nil
 	}
 
@@ -100,19 +216,17 @@ func (s *SMS2FAService) SendVerificationCode(request *SMSVerificationRequest) (*
 		return nil, fmt.Errorf("failed to generate verification code: %w", err)
 	}
 
-	// Hash the code for storage
-	salt, err := s.authService.GenerateSecureSalt()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate salt: %w", err)
-	}
-	codeHash := s.authService.HashPassword(code, salt)
+	// Only an HMAC of the code is stored, so DB read access can't be used to
+	// pass 2FA. Email codes are keyed to the phone number too, so Verify2FA
+	// checks them the same way.
+	codeHash := s.hashCode(request.PhoneNumber, request.Purpose, code)
 
 	// Set expiration (5 minutes from now)
 	expiresAt := time.Now().Add(5 * time.Minute)
 
 	// Clean up any existing unexpired codes for this phone/purpose
 	_, err = s.db.Exec(`
-		DELETE FROM sms_verification_codes 
+		DELETE FROM verification_codes 
 		WHERE phone_number = $1 AND purpose = $2 AND expires_at > NOW()
 	`, request.PhoneNumber, request.Purpose)
 	if err != nil {
@@ -121,51 +235,110 @@ func (s *SMS2FAService) SendVerificationCode(request *SMSVerificationRequest) (*
 
 	// Store the verification code
 	_, err = s.db.Exec(`
-		INSERT INTO sms_verification_codes (
-			user_id, phone_number, code, code_hash, purpose, expires_at
+		INSERT INTO verification_codes (
+			user_id, phone_number, code_hash, purpose, delivery_method, expires_at
 		) VALUES ($1, $2, $3, $4, $5, $6)
-	`, request.UserID, request.PhoneNumber, code, codeHash, request.Purpose, expiresAt)
+	`, request.UserID, request.PhoneNumber, codeHash, request.Purpose, method, expiresAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to store verification code: %w", err)
 	}
 
-	// Send SMS
-	var smsSent bool
-	if s.testMode {
-		// In test mode, log the code instead of sending SMS
-		fmt.Printf("TEST MODE: SMS verification code for %s: %s\n", request.PhoneNumber, code)
-		smsSent = true
-	} else {
-		smsSent, err = s.sendSMSViaTwilio(request.PhoneNumber, code, request.Purpose)
-		if err != nil {
+	if method == "sms" {
+		err = s.sendVerificationSMS(request.PhoneNumber, code, request.Purpose)
+		if err != nil && canEmail {
+			// Provider outage or a carrier block: the user can still sign in
+			fmt.Printf("SMS delivery failed, emailing code instead: %v\n", err)
+			method = "email"
+			_, err = s.db.Exec(`
+				UPDATE verification_codes SET delivery_method = 'email'
+				WHERE phone_number = $1 AND purpose = $2 AND code_hash = $3
+			`, request.PhoneNumber, request.Purpose, codeHash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update delivery method: %w", err)
+			}
+		} else if err != nil {
 			return nil, fmt.Errorf("failed to send SMS: %w", err)
 		}
 	}
+	if method == "email" {
+		if err := s.emailSender.Send(request.Email, "Your ArvFinder verification code", verificationMessage(code, request.Purpose)); err != nil {
+			return nil, fmt.Errorf("failed to send verification email: %w", err)
+		}
+	}
 
 	return &SMSVerificationResponse{
-		Success:   true,
-		Message:   "Verification code sent successfully",
-		CodeSent:  smsSent,
-		ExpiresAt: expiresAt.Unix(),
+		Success:        true,
+		Message:        "Verification code sent successfully",
+		CodeSent:       true,
+		DeliveryMethod: method,
+		ExpiresAt:      expiresAt.Unix(),
 	}, nil
 }
 
+// hashCode returns the hex HMAC-SHA256 of a code, bound to the number and
+// purpose it was sent for. A 6-digit code expires in minutes and allows 3
+// guesses, so a keyed fast hash is enough; Argon2 here cost 128MB per SMS.
+func (s *SMS2FAService) hashCode(phoneNumber, purpose, code string) string {
+	mac := hmac.New(sha256.New, s.codeKey)
+	mac.Write([]byte(phoneNumber + "|" + purpose + "|" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSendLimits applies the per-IP and per-number limits, plus the global
+// SMS budget when smsBudget is set, and records the send against each. The
+// per-number limits are shared by both channels, since every code for a user
+// is keyed to their phone number. It returns how long to wait and the action
+// that blocked when any limit is exceeded.
+func (s *SMS2FAService) checkSendLimits(request *SMSVerificationRequest, smsBudget bool) (time.Duration, string, error) {
+	phoneKey := "phone:" + request.PhoneNumber
+	limits := [][2]string{
+		{phoneKey, "sms_send_phone_hourly"},
+		{phoneKey, "sms_send_phone_daily"},
+	}
+	if smsBudget {
+		limits = append(limits, [2]string{"global", "sms_daily_budget"})
+	}
+	if request.ClientIP != "" {
+		limits = append([][2]string{{request.ClientIP, "sms_send"}}, limits...)
+	}
+
+	for _, limit := range limits {
+		allowed, retryAfter, err := s.rateLimiter.IsAllowed(limit[0], limit[1])
+		if err != nil {
+			return 0, "", err
+		}
+		if !allowed {
+			return retryAfter, limit[1], nil
+		}
+	}
+	for _, limit := range limits {
+		if err := s.rateLimiter.RecordAttempt(limit[0], limit[1]); err != nil {
+			return 0, "", fmt.Errorf("failed to record SMS send: %w", err)
+		}
+	}
+	return 0, "", nil
+}
+
 // VerifyCode verifies a submitted verification code
 func (s *SMS2FAService) VerifyCode(request *VerifyCodeRequest) (*VerifyCodeResponse, error) {
+	if phoneNumber, err := NormalizePhoneNumber(request.PhoneNumber); err == nil {
+		request.PhoneNumber = phoneNumber
+	}
+
 	// Get the stored verification record
-	var storedCode, codeHash string
+	var codeHash string
 	var attempts, maxAttempts int
 	var expiresAt time.Time
 	var verified bool
 
 	err := s.db.QueryRow(`
-		SELECT code, code_hash, attempts, max_attempts, expires_at, verified
-		FROM sms_verification_codes 
+		SELECT code_hash, attempts, max_attempts, expires_at, verified
+		FROM verification_codes 
 		WHERE phone_number = $1 AND purpose = $2 
 		ORDER BY created_at DESC 
 		LIMIT 1
 	`, request.PhoneNumber, request.Purpose).Scan(
-		&storedCode, &codeHash, &attempts, &maxAttempts, &expiresAt, &verified,
+		&codeHash, &attempts, &maxAttempts, &expiresAt, &verified,
 	)
 
 	if err == sql.ErrNoRows {
@@ -208,7 +381,7 @@ func (s *SMS2FAService) VerifyCode(request *VerifyCodeRequest) (*VerifyCodeRespo
 
 	// Increment attempts
 	_, err = s.db.Exec(`
-		UPDATE sms_verification_codes 
+		UPDATE verification_codes 
 		SET attempts = attempts + 1 
 		WHERE phone_number = $1 AND purpose = $2 AND expires_at = $3
 	`, request.PhoneNumber, request.Purpose, expiresAt)
@@ -217,7 +390,7 @@ func (s *SMS2FAService) VerifyCode(request *VerifyCodeRequest) (*VerifyCodeRespo
 	}
 
 	// Verify the code using constant-time comparison
-	isValid := s.authService.VerifyPassword(request.Code, codeHash)
+	isValid := hmac.Equal([]byte(s.hashCode(request.PhoneNumber, request.Purpose, request.Code)), []byte(codeHash))
 
 	if !isValid {
 		remainingAttempts := maxAttempts - (attempts + 1)
@@ -235,7 +408,7 @@ func (s *SMS2FAService) VerifyCode(request *VerifyCodeRequest) (*VerifyCodeRespo
 
 	// Mark as verified
 	_, err = s.db.Exec(`
-		UPDATE sms_verification_codes 
+		UPDATE verification_codes 
 		SET verified = TRUE 
 		WHERE phone_number = $1 AND purpose = $2 AND expires_at = $3
 	`, request.PhoneNumber, request.Purpose, expiresAt)
@@ -262,13 +435,13 @@ func (s *SMS2FAService) VerifyCode(request *VerifyCodeRequest) (*VerifyCodeRespo
 	}, nil
 }
 
-// sendSMSViaTwilio sends SMS using Twilio API
-func (s *SMS2FAService) sendSMSViaTwilio(phoneNumber, code, purpose string) (bool, error) {
-	if s.testMode {
-		return false, fmt.Errorf("Twilio not configured - running in test mode")
-	}
+// sendVerificationSMS sends a verification code through the provider
+func (s *SMS2FAService) sendVerificationSMS(phoneNumber, code, purpose string) error {
+	return s.deliver(phoneNumber, verificationMessage(code, purpose))
+}
 
-	// Format the message based on purpose
+// verificationMessage formats a code for the purpose it was requested for
+func verificationMessage(code, purpose string) string {
 	var message string
 	switch purpose {
 	case "login":
@@ -283,69 +456,43 @@ func (s *SMS2FAService) sendSMSViaTwilio(phoneNumber, code, purpose string) (boo
 		message = fmt.Sprintf("Your ArvFinder verification code is: %s. This code expires in 5 minutes.", code)
 	}
 
-	// Prepare Twilio API request
-	apiURL := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", s.twilioSID)
-	
-	data := url.Values{}
-	data.Set("From", s.twilioPhone)
-	data.Set("To", phoneNumber)
-	data.Set("Body", message)
+	return message
+}
 
-	req, err := http.NewRequest("POST", apiURL, strings.NewReader(data.Encode()))
-	if err != nil {
-		return false, fmt.Errorf("failed to create request: %w", err)
+// SendMessage delivers a plain-text SMS outside the verification flow. It
+// honours the disabled switch and counts against the daily budget.
+func (s *SMS2FAService) SendMessage(phoneNumber, message string) error {
+	if s.disabled {
+		return ErrSMSDisabled
 	}
-
-	req.SetBasicAuth(s.twilioSID, s.twilioToken)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	allowed, _, err := s.rateLimiter.IsAllowed("global", "sms_daily_budget")
 	if err != nil {
-		return false, fmt.Errorf("failed to send request: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return true, nil
+	if !allowed {
+		return ErrSMSBudgetExhausted
 	}
-
-	return false, fmt.Errorf("Twilio API returned status: %d", resp.StatusCode)
-}
-
-// isValidPhoneNumber performs basic phone number validation
-func (s *SMS2FAService) isValidPhoneNumber(phone string) bool {
-	// Remove common formatting characters
-	cleaned := strings.ReplaceAll(phone, " ", "")
-	cleaned = strings.ReplaceAll(cleaned, "-", "")
-	cleaned = strings.ReplaceAll(cleaned, "(", "")
-	cleaned = strings.ReplaceAll(cleaned, ")", "")
-	cleaned = strings.ReplaceAll(cleaned, ".", "")
-
-	// Should start with + and have 10-15 digits
-	if !strings.HasPrefix(cleaned, "+") {
-		return false
-	}
-
-	digits := cleaned[1:] // Remove the +
-	if len(digits) < 10 || len(digits) > 15 {
-		return false
+	if err := s.rateLimiter.RecordAttempt("global", "sms_daily_budget"); err != nil {
+		return fmt.Errorf("failed to record SMS send: %w", err)
 	}
+	return s.deliver(phoneNumber, message)
+}
 
-	// Check if all characters after + are digits
-	for _, char := range digits {
-		if char < '0' || char > '9' {
-			return false
-		}
+// deliver hands a message to the provider
+func (s *SMS2FAService) deliver(phoneNumber, message string) error {
+	phoneNumber, err := NormalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return err
 	}
-
-	return true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.provider.Send(ctx, phoneNumber, message)
 }
 
 // CleanupExpiredCodes removes expired verification codes
 func (s *SMS2FAService) CleanupExpiredCodes() error {
 	_, err := s.db.Exec(`
-		DELETE FROM sms_verification_codes 
+		DELETE FROM verification_codes 
 		WHERE expires_at < NOW() - INTERVAL '1 day'
 	`)
 	return err
@@ -359,7 +506,7 @@ func (s *SMS2FAService) GetVerificationStatus(phoneNumber, purpose string) (bool
 
 	err := s.db.QueryRow(`
 		SELECT verified, expires_at, attempts
-		FROM sms_verification_codes 
+		FROM verification_codes 
 		WHERE phone_number = $1 AND purpose = $2 
 		ORDER BY created_at DESC 
 		LIMIT 1
@@ -378,7 +525,7 @@ func (s *SMS2FAService) GetVerificationStatus(phoneNumber, purpose string) (bool
 // RevokeVerificationCode revokes an unused verification code
 func (s *SMS2FAService) RevokeVerificationCode(phoneNumber, purpose string) error {
 	_, err := s.db.Exec(`
-		DELETE FROM sms_verification_codes 
+		DELETE FROM verification_codes 
 		WHERE phone_number = $1 AND purpose = $2 AND verified = FALSE
 	`, phoneNumber, purpose)
 	return err
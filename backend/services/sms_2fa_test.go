@@ -0,0 +1,397 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSMSService(t *testing.T) (*SMS2FAService, sqlmock.Sqlmock) {
+	authService, mock := newTestAuthService(t)
+	return NewSMS2FAService(authService.db, authService, nil), mock
+}
+
+// rateLimitRow simulates one rate_limits row across IsAllowed/RecordAttempt
+type rateLimitRow struct {
+	identifier, action string
+	attempts           int
+	blockedUntil       capturedArg
+}
+
+func (r *rateLimitRow) expectAllowed(mock sqlmock.Sqlmock) {
+	blocked := sqlmock.NewRows([]string{"blocked_until"})
+	until, isBlocked := r.blockedUntil.value.(time.Time)
+	if isBlocked {
+		blocked.AddRow(until)
+	}
+	mock.ExpectQuery(`SELECT blocked_until`).WithArgs(r.identifier, r.action).WillReturnRows(blocked)
+	if isBlocked {
+		return
+	}
+	mock.ExpectQuery(`SELECT attempts, EXISTS`).
+		WithArgs(r.identifier, r.action, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts", "exists"}).AddRow(r.attempts, true))
+}
+
+func (r *rateLimitRow) expectRecorded(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT COALESCE\(attempts, 0\)`).
+		WithArgs(r.identifier, r.action, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(r.attempts))
+	r.attempts++
+	mock.ExpectExec(`INSERT INTO rate_limits`).
+		WithArgs(r.identifier, r.action, r.attempts, sqlmock.AnyArg(), &r.blockedUntil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestSendVerificationCode_FourthSendToSameNumberWithinHourIsRejected(t *testing.T) {
+	service, mock := newTestSMSService(t)
+
+	rows := []*rateLimitRow{
+		{identifier: "phone:+15555550100", action: "sms_send_phone_hourly"},
+		{identifier: "phone:+15555550100", action: "sms_send_phone_daily"},
+		{identifier: "global", action: "sms_daily_budget"},
+	}
+
+	// Each send comes from a fresh IP, so only the per-number limit can stop it
+	for i, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		ipRow := &rateLimitRow{identifier: ip, action: "sms_send"}
+		ipRow.expectAllowed(mock)
+		for _, row := range rows {
+			row.expectAllowed(mock)
+		}
+		ipRow.expectRecorded(mock)
+		for _, row := range rows {
+			row.expectRecorded(mock)
+		}
+		mock.ExpectExec(`DELETE FROM verification_codes`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO verification_codes`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		resp, err := service.SendVerificationCode(&SMSVerificationRequest{
+			PhoneNumber: "+1 555-555-0100",
+			Purpose:     "login",
+			ClientIP:    ip,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.Success, "send %d", i+1)
+	}
+
+	// The third send used up the hourly allowance and blocked the number
+	require.IsType(t, time.Time{}, rows[0].blockedUntil.value)
+
+	ipRow := &rateLimitRow{identifier: "198.51.100.4", action: "sms_send"}
+	ipRow.expectAllowed(mock)
+	rows[0].expectAllowed(mock)
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{
+		PhoneNumber: "+15555550100",
+		Purpose:     "login",
+		ClientIP:    "198.51.100.4",
+	})
+
+	require.NoError(t, err)
+	assert.False(t, resp.Success)
+	assert.InDelta(t, time.Hour.Seconds(), resp.RetryAfter, 5)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendVerificationCode_DisabledSwitch(t *testing.T) {
+	service, mock := newTestSMSService(t)
+	service.SetSendingDisabled(true)
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{PhoneNumber: "+15555550100", Purpose: "login"})
+
+	require.NoError(t, err)
+	assert.False(t, resp.Success)
+	assert.ErrorIs(t, service.SendMessage("+15555550100", "hello"), ErrSMSDisabled)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is sent or recorded")
+}
+
+func TestSendMessage_DailyBudgetExhausted(t *testing.T) {
+	service, mock := newTestSMSService(t)
+	service.SetDailyBudget(50)
+
+	mock.ExpectQuery(`SELECT blocked_until`).
+		WithArgs("global", "sms_daily_budget").
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}))
+	mock.ExpectQuery(`SELECT attempts, EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts", "exists"}).AddRow(50, true))
+	mock.ExpectExec(`INSERT INTO rate_limits`).
+		WithArgs("global", "sms_daily_budget", 51, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := service.SendMessage("+15555550100", "hello")
+
+	assert.ErrorIs(t, err, ErrSMSBudgetExhausted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendVerificationCode_StoresOnlyHMAC(t *testing.T) {
+	service, mock := newTestSMSService(t)
+	rows := []*rateLimitRow{
+		{identifier: "phone:+15555550100", action: "sms_send_phone_hourly"},
+		{identifier: "phone:+15555550100", action: "sms_send_phone_daily"},
+		{identifier: "global", action: "sms_daily_budget"},
+	}
+	for _, row := range rows {
+		row.expectAllowed(mock)
+	}
+	for _, row := range rows {
+		row.expectRecorded(mock)
+	}
+
+	storedHash := &capturedArg{}
+	mock.ExpectExec(`DELETE FROM verification_codes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO verification_codes \(\s*user_id, phone_number, code_hash, purpose, delivery_method, expires_at\s*\)`).
+		WithArgs("user-1", "+15555550100", storedHash, "login", "sms", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{PhoneNumber: "+15555550100", Purpose: "login", UserID: "user-1"})
+
+	require.NoError(t, err)
+	require.True(t, resp.Success)
+	assert.Regexp(t, `^[0-9a-f]{64}$`, storedHash.value)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type recordingSMSProvider struct{ to []string }
+
+func (r *recordingSMSProvider) Send(ctx context.Context, to, body string) error {
+	r.to = append(r.to, to)
+	return nil
+}
+
+func TestSendVerificationCode_StoresAndSendsE164(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	provider := &recordingSMSProvider{}
+	service := NewSMS2FAService(authService.db, authService, provider)
+
+	expectSendLimits(mock, true)
+	mock.ExpectExec(`DELETE FROM verification_codes`).
+		WithArgs("+15555550100", "phone_verification").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO verification_codes`).
+		WithArgs("user-1", "+15555550100", sqlmock.AnyArg(), "phone_verification", "sms", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{
+		PhoneNumber: "(555) 555-0100",
+		Purpose:     "phone_verification",
+		UserID:      "user-1",
+	})
+
+	require.NoError(t, err)
+	require.True(t, resp.Success)
+	assert.Equal(t, []string{"+15555550100"}, provider.to)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendVerificationCode_RejectsInvalidNumber(t *testing.T) {
+	service, mock := newTestSMSService(t)
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{PhoneNumber: "555-0100", Purpose: "login"})
+
+	require.NoError(t, err)
+	assert.False(t, resp.Success)
+	assert.Contains(t, resp.Message, "(303) 555-0147")
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is sent or recorded")
+}
+
+func TestVerifyCode_ChecksHMAC(t *testing.T) {
+	service, mock := newTestSMSService(t)
+	codeHash := service.hashCode("+15555550100", "login", "123456")
+	columns := []string{"code_hash", "attempts", "max_attempts", "expires_at", "verified"}
+	expiresAt := time.Now().Add(time.Minute)
+
+	mock.ExpectQuery(`SELECT code_hash, attempts`).
+		WithArgs("+15555550100", "login").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(codeHash, 0, 3, expiresAt, false))
+	mock.ExpectExec(`SET attempts = attempts \+ 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	wrong, err := service.VerifyCode(&VerifyCodeRequest{PhoneNumber: "+15555550100", Purpose: "login", Code: "654321"})
+	require.NoError(t, err)
+	assert.False(t, wrong.Verified)
+
+	mock.ExpectQuery(`SELECT code_hash, attempts`).
+		WithArgs("+15555550100", "login").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(codeHash, 1, 3, expiresAt, false))
+	mock.ExpectExec(`SET attempts = attempts \+ 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SET verified = TRUE`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	right, err := service.VerifyCode(&VerifyCodeRequest{PhoneNumber: "+15555550100", Purpose: "login", Code: "123456"})
+	require.NoError(t, err)
+	assert.True(t, right.Verified)
+
+	// A code is only good for the number and purpose it was sent for
+	assert.NotEqual(t, codeHash, service.hashCode("+15555550100", "password_reset", "123456"))
+	assert.NotEqual(t, codeHash, service.hashCode("+15555550199", "login", "123456"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type failingSMSProvider struct{ calls int }
+
+func (f *failingSMSProvider) Send(ctx context.Context, to, body string) error {
+	f.calls++
+	return fmt.Errorf("%w: carrier rejected message", ErrUndeliverablePhoneNumber)
+}
+
+// expectSendLimits mocks the per-number limits and, for SMS, the daily budget
+func expectSendLimits(mock sqlmock.Sqlmock, smsBudget bool) {
+	rows := []*rateLimitRow{
+		{identifier: "phone:+15555550100", action: "sms_send_phone_hourly"},
+		{identifier: "phone:+15555550100", action: "sms_send_phone_daily"},
+	}
+	if smsBudget {
+		rows = append(rows, &rateLimitRow{identifier: "global", action: "sms_daily_budget"})
+	}
+	for _, row := range rows {
+		row.expectAllowed(mock)
+	}
+	for _, row := range rows {
+		row.expectRecorded(mock)
+	}
+}
+
+func TestSendVerificationCode_FallsBackToEmailWhenSMSFails(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	provider := &failingSMSProvider{}
+	email := &recordingEmailSender{}
+	service := NewSMS2FAService(authService.db, authService, provider)
+	service.SetEmailSender(email)
+
+	expectSendLimits(mock, true)
+	mock.ExpectExec(`DELETE FROM verification_codes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	storedHash := &capturedArg{}
+	mock.ExpectExec(`INSERT INTO verification_codes`).
+		WithArgs("user-1", "+15555550100", storedHash, "login", "sms", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE verification_codes SET delivery_method = 'email'`).
+		WithArgs("+15555550100", "login", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{
+		PhoneNumber: "+15555550100", Purpose: "login", UserID: "user-1", Email: "user@example.com",
+	})
+
+	require.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, "email", resp.DeliveryMethod)
+	assert.Equal(t, 1, provider.calls)
+	require.Len(t, email.sent, 1)
+	assert.Equal(t, "user@example.com", email.sent[0].To)
+
+	// The emailed code is the stored one, so Verify2FA accepts it unchanged
+	code := regexp.MustCompile(`\d{6}`).FindString(email.sent[0].Body)
+	assert.Equal(t, service.hashCode("+15555550100", "login", code), storedHash.value)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendVerificationCode_SMSFailureWithoutEmailIsAnError(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	service := NewSMS2FAService(authService.db, authService, &failingSMSProvider{})
+	service.SetEmailSender(&recordingEmailSender{})
+
+	expectSendLimits(mock, true)
+	mock.ExpectExec(`DELETE FROM verification_codes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO verification_codes`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := service.SendVerificationCode(&SMSVerificationRequest{PhoneNumber: "+15555550100", Purpose: "login"})
+
+	assert.ErrorIs(t, err, ErrUndeliverablePhoneNumber)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendVerificationCode_EmailOnRequestSkipsSMSBudget(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	provider := &failingSMSProvider{}
+	email := &recordingEmailSender{}
+	service := NewSMS2FAService(authService.db, authService, provider)
+	service.SetEmailSender(email)
+
+	expectSendLimits(mock, false)
+	mock.ExpectExec(`DELETE FROM verification_codes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO verification_codes`).
+		WithArgs("user-1", "+15555550100", sqlmock.AnyArg(), "login", "email", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{
+		PhoneNumber: "+15555550100", Purpose: "login", UserID: "user-1", Email: "user@example.com", Method: "email",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "email", resp.DeliveryMethod)
+	assert.Zero(t, provider.calls)
+	assert.Len(t, email.sent, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendVerificationCode_BudgetExhaustedFallsBackToEmail(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	email := &recordingEmailSender{}
+	service := NewSMS2FAService(authService.db, authService, &failingSMSProvider{})
+	service.SetEmailSender(email)
+
+	budget := &rateLimitRow{identifier: "global", action: "sms_daily_budget", blockedUntil: capturedArg{value: time.Now().Add(time.Hour)}}
+	(&rateLimitRow{identifier: "phone:+15555550100", action: "sms_send_phone_hourly"}).expectAllowed(mock)
+	(&rateLimitRow{identifier: "phone:+15555550100", action: "sms_send_phone_daily"}).expectAllowed(mock)
+	budget.expectAllowed(mock)
+	expectSendLimits(mock, false)
+	mock.ExpectExec(`DELETE FROM verification_codes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO verification_codes`).
+		WithArgs("user-1", "+15555550100", sqlmock.AnyArg(), "login", "email", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{
+		PhoneNumber: "+15555550100", Purpose: "login", UserID: "user-1", Email: "user@example.com",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "email", resp.DeliveryMethod)
+	assert.Len(t, email.sent, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendVerificationCode_PerNumberLimitCoversEmail(t *testing.T) {
+	authService, mock := newTestAuthService(t)
+	email := &recordingEmailSender{}
+	service := NewSMS2FAService(authService.db, authService, nil)
+	service.SetEmailSender(email)
+
+	// Three SMS used up the hour; switching channel doesn't reset it
+	hourly := &rateLimitRow{identifier: "phone:+15555550100", action: "sms_send_phone_hourly", blockedUntil: capturedArg{value: time.Now().Add(time.Hour)}}
+	hourly.expectAllowed(mock)
+
+	resp, err := service.SendVerificationCode(&SMSVerificationRequest{
+		PhoneNumber: "+15555550100", Purpose: "login", UserID: "user-1", Email: "user@example.com", Method: "email",
+	})
+
+	require.NoError(t, err)
+	assert.False(t, resp.Success)
+	assert.Positive(t, resp.RetryAfter)
+	assert.Empty(t, email.sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Compare with: go test ./services -bench SMSCodeHash -benchmem
+func BenchmarkSMSCodeHash_HMAC(b *testing.B) {
+	service := NewSMS2FAService(nil, NewAuthService(nil, "bench-secret"), nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		service.hashCode("+15555550100", "login", "123456")
+	}
+}
+
+// The previous per-code hashing: Argon2 with the default 128MB params
+func BenchmarkSMSCodeHash_Argon2(b *testing.B) {
+	authService := NewAuthService(nil, "bench-secret")
+	salt, _ := authService.GenerateSecureSalt()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		authService.HashPassword("123456", salt)
+	}
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrUndeliverablePhoneNumber is returned by providers when the destination
+// can't receive SMS, as opposed to a provider outage
+var ErrUndeliverablePhoneNumber = errors.New("phone number cannot receive SMS")
+
+// SMSProvider delivers a text message to a phone number. Implementations must
+// be safe for concurrent use.
+type SMSProvider interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// LogSMSProvider prints messages to stdout instead of sending them. It is used
+// when no provider is configured.
+type LogSMSProvider struct{}
+
+// Send logs the message
+func (LogSMSProvider) Send(ctx context.Context, to, body string) error {
+	fmt.Printf("TEST MODE: SMS to %s: %s\n", to, body)
+	return nil
+}
+
+// TwilioSMSProvider sends SMS through the Twilio Messages API
+type TwilioSMSProvider struct {
+	accountSID string
+	authToken  string
+	from       string
+	baseURL    string
+	client     *http.Client
+}
+
+// NewTwilioSMSProvider creates a Twilio provider sending from the given number
+func NewTwilioSMSProvider(accountSID, authToken, from string) *TwilioSMSProvider {
+	return &TwilioSMSProvider{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		baseURL:    "https://api.twilio.com",
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Twilio error codes meaning the destination number is the problem
+// https://www.twilio.com/docs/api/errors
+var twilioInvalidNumberCodes = map[int]bool{
+	21211: true, // Invalid 'To' phone number
+	21612: true, // Unable to route to this number
+	21614: true, // 'To' number is not a valid mobile number
+}
+
+// Send delivers body to the given number
+func (p *TwilioSMSProvider) Send(ctx context.Context, to, body string) error {
+	apiURL := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", p.baseURL, p.accountSID)
+
+	data := url.Values{}
+	data.Set("From", p.from)
+	data.Set("To", to)
+	data.Set("Body", body)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(p.accountSID, p.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	var apiErr struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr); err != nil || apiErr.Code == 0 {
+		return fmt.Errorf("Twilio API returned status: %d", resp.StatusCode)
+	}
+	if twilioInvalidNumberCodes[apiErr.Code] {
+		return fmt.Errorf("%w: %s", ErrUndeliverablePhoneNumber, apiErr.Message)
+	}
+	return fmt.Errorf("Twilio API error %d (status %d): %s", apiErr.Code, resp.StatusCode, apiErr.Message)
+}
+
+// SNSSMSProvider sends SMS through AWS SNS Publish, for regions Twilio
+// doesn't cover. Requests are signed with AWS Signature Version 4.
+type SNSSMSProvider struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+	endpoint        string
+	client          *http.Client
+	now             func() time.Time
+}
+
+// NewSNSSMSProvider creates an SNS provider for the given region. sessionToken
+// is only needed for temporary credentials.
+func NewSNSSMSProvider(region, accessKeyID, secretAccessKey, sessionToken string) *SNSSMSProvider {
+	return &SNSSMSProvider{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		region:          region,
+		endpoint:        fmt.Sprintf("https://sns.%s.amazonaws.com/", region),
+		client:          &http.Client{Timeout: 10 * time.Second},
+		now:             time.Now,
+	}
+}
+
+// Send delivers body to the given number as a transactional SMS
+func (p *SNSSMSProvider) Send(ctx context.Context, to, body string) error {
+	data := url.Values{}
+	data.Set("Action", "Publish")
+	data.Set("Version", "2010-03-31")
+	data.Set("PhoneNumber", to)
+	data.Set("Message", body)
+	// Transactional messages get higher delivery priority than promotional
+	data.Set("MessageAttributes.entry.1.Name", "AWS.SNS.SMS.SMSType")
+	data.Set("MessageAttributes.entry.1.Value.DataType", "String")
+	data.Set("MessageAttributes.entry.1.Value.StringValue", "Transactional")
+	payload := data.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoint, strings.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	p.sign(req, payload)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	var apiErr struct {
+		Code    string `xml:"Error>Code"`
+		Message string `xml:"Error>Message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr); err != nil || apiErr.Code == "" {
+		return fmt.Errorf("SNS API returned status: %d", resp.StatusCode)
+	}
+	if apiErr.Code == "InvalidParameter" && strings.Contains(apiErr.Message, "PhoneNumber") {
+		return fmt.Errorf("%w: %s", ErrUndeliverablePhoneNumber, apiErr.Message)
+	}
+	return fmt.Errorf("SNS API error %s (status %d): %s", apiErr.Code, resp.StatusCode, apiErr.Message)
+}
+
+// sign adds SigV4 authentication headers for a POST with the given payload
+func (p *SNSSMSProvider) sign(req *http.Request, payload string) {
+	now := p.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+		headers = append(headers, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(payload),
+	}, "\n")
+
+	scope := date + "/" + p.region + "/sns/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := []byte("AWS4" + p.secretAccessKey)
+	for _, part := range []string{date, p.region, "sns", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// NewSMSProviderFromEnv picks the provider named by SMS_PROVIDER ("twilio" or
+// "sns"). Unset means Twilio when TWILIO_* is configured. A provider with
+// missing credentials falls back to logging messages.
+func NewSMSProviderFromEnv() SMSProvider {
+	provider := strings.ToLower(os.Getenv("SMS_PROVIDER"))
+	if provider == "" && os.Getenv("TWILIO_ACCOUNT_SID") != "" {
+		provider = "twilio"
+	}
+
+	switch provider {
+	case "twilio":
+		sid, token, from := os.Getenv("TWILIO_ACCOUNT_SID"), os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_PHONE_NUMBER")
+		if sid != "" && token != "" && from != "" {
+			return NewTwilioSMSProvider(sid, token, from)
+		}
+		fmt.Println("TWILIO_* is incomplete; SMS will be logged instead of sent")
+	case "sns":
+		region, keyID, secret := os.Getenv("AWS_REGION"), os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if region != "" && keyID != "" && secret != "" {
+			return NewSNSSMSProvider(region, keyID, secret, os.Getenv("AWS_SESSION_TOKEN"))
+		}
+		fmt.Println("AWS_REGION/AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY are incomplete; SMS will be logged instead of sent")
+	case "":
+	default:
+		fmt.Printf("Unknown SMS_PROVIDER %q; SMS will be logged instead of sent\n", provider)
+	}
+	return LogSMSProvider{}
+}
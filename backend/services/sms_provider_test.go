@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Numbers the fake providers treat specially, after Twilio's test numbers
+const (
+	fakeInvalidNumber = "+15005550001"
+	fakeOutageNumber  = "+15005550009"
+)
+
+type fakeSMS struct {
+	to, body string
+}
+
+// fakeSMSAPI records messages accepted by a fake provider endpoint
+type fakeSMSAPI struct {
+	mu   sync.Mutex
+	sent []fakeSMS
+}
+
+func (f *fakeSMSAPI) record(to, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, fakeSMS{to, body})
+}
+
+func newFakeTwilio(t *testing.T) (*TwilioSMSProvider, *fakeSMSAPI) {
+	api := &fakeSMSAPI{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid, token, ok := r.BasicAuth()
+		if !ok || sid != "AC123" || token != "secret" || r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch to := r.PostFormValue("To"); to {
+		case fakeInvalidNumber:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code": 21211, "message": "The 'To' number +15005550001 is not a valid phone number.", "status": 400}`))
+		case fakeOutageNumber:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"code": 20500, "message": "Internal Server Error", "status": 500}`))
+		default:
+			api.record(to, r.PostFormValue("Body"))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"sid": "SM123", "status": "queued"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewTwilioSMSProvider("AC123", "secret", "+15005550006")
+	provider.baseURL = server.URL
+	return provider, api
+}
+
+func newFakeSNS(t *testing.T) (*SNSSMSProvider, *fakeSMSAPI) {
+	api := &fakeSMSAPI{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/20261016/us-east-1/sns/aws4_request, ") ||
+			r.Header.Get("X-Amz-Date") != "20261016T120000Z" || r.PostFormValue("Action") != "Publish" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		switch to := r.PostFormValue("PhoneNumber"); to {
+		case fakeInvalidNumber:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>InvalidParameter</Code>` +
+				`<Message>Invalid parameter: PhoneNumber Reason: +15005550001 is not valid to publish to</Message></Error></ErrorResponse>`))
+		case fakeOutageNumber:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<ErrorResponse><Error><Type>Receiver</Type><Code>InternalError</Code>` +
+				`<Message>Internal error</Message></Error></ErrorResponse>`))
+		default:
+			api.record(to, r.PostFormValue("Message"))
+			w.Write([]byte(`<PublishResponse><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewSNSSMSProvider("us-east-1", "AKID", "secret", "")
+	provider.endpoint = server.URL + "/"
+	provider.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+	return provider, api
+}
+
+// TestSMSProviderContract runs the same behaviour checks against every
+// provider, each talking to a fake of its API
+func TestSMSProviderContract(t *testing.T) {
+	providers := map[string]func(t *testing.T) (SMSProvider, *fakeSMSAPI){
+		"twilio": func(t *testing.T) (SMSProvider, *fakeSMSAPI) { return newFakeTwilio(t) },
+		"sns":    func(t *testing.T) (SMSProvider, *fakeSMSAPI) { return newFakeSNS(t) },
+	}
+
+	for name, newProvider := range providers {
+		t.Run(name, func(t *testing.T) {
+			t.Run("delivers the message", func(t *testing.T) {
+				provider, api := newProvider(t)
+
+				err := provider.Send(context.Background(), "+15555550100", "Your code is 123456")
+
+				require.NoError(t, err)
+				assert.Equal(t, []fakeSMS{{"+15555550100", "Your code is 123456"}}, api.sent)
+			})
+
+			t.Run("invalid number is a distinct error", func(t *testing.T) {
+				provider, api := newProvider(t)
+
+				err := provider.Send(context.Background(), fakeInvalidNumber, "hello")
+
+				assert.ErrorIs(t, err, ErrUndeliverablePhoneNumber)
+				assert.Contains(t, err.Error(), "+15005550001", "provider detail is kept")
+				assert.Empty(t, api.sent)
+			})
+
+			t.Run("outage is not reported as an invalid number", func(t *testing.T) {
+				provider, _ := newProvider(t)
+
+				err := provider.Send(context.Background(), fakeOutageNumber, "hello")
+
+				require.Error(t, err)
+				assert.False(t, errors.Is(err, ErrUndeliverablePhoneNumber))
+			})
+
+			t.Run("honours context cancellation", func(t *testing.T) {
+				provider, api := newProvider(t)
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				err := provider.Send(ctx, "+15555550100", "hello")
+
+				assert.ErrorIs(t, err, context.Canceled)
+				assert.Empty(t, api.sent)
+			})
+		})
+	}
+}
+
+func TestSendVerificationCode_UndeliverableNumber(t *testing.T) {
+	provider, _ := newFakeTwilio(t)
+	authService, mock := newTestAuthService(t)
+	service := NewSMS2FAService(authService.db, authService, provider)
+
+	rows := []*rateLimitRow{
+		{identifier: "phone:" + fakeInvalidNumber, action: "sms_send_phone_hourly"},
+		{identifier: "phone:" + fakeInvalidNumber, action: "sms_send_phone_daily"},
+		{identifier: "global", action: "sms_daily_budget"},
+	}
+	for _, row := range rows {
+		row.expectAllowed(mock)
+	}
+	for _, row := range rows {
+		row.expectRecorded(mock)
+	}
+	mock.ExpectExec(`DELETE FROM verification_codes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO verification_codes`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := service.SendVerificationCode(&SMSVerificationRequest{PhoneNumber: fakeInvalidNumber, Purpose: "login"})
+
+	assert.ErrorIs(t, err, ErrUndeliverablePhoneNumber)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
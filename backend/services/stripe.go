@@ -1,31 +1,84 @@
 package services
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"sync"
+	"time"
 
 	"github.com/stripe/stripe-go/v79"
 	"github.com/stripe/stripe-go/v79/customer"
 	"github.com/stripe/stripe-go/v79/paymentintent"
-	"github.com/stripe/stripe-go/v79/price"
-	"github.com/stripe/stripe-go/v79/product"
 	"github.com/stripe/stripe-go/v79/subscription"
 	"github.com/stripe/stripe-go/v79/webhook"
 )
 
 // StripeService handles all Stripe-related operations
 type StripeService struct {
-	secretKey string
+	secretKey      string
+	subscriptions  StripeSubscriptionAPI
+	paymentIntents StripePaymentIntentAPI
+	prices         StripePriceLister
+	checkout       StripeCheckoutAPI
+	catalog        StripeCatalogAPI
+	tax            StripeTaxAPI
+	invoices       StripeInvoiceAPI
+	usageRecords   StripeUsageRecordAPI
+	now            func() time.Time
+
+	automaticTax bool // set by EnableAutomaticTax
+
+	checkoutSuccessURL string
+	checkoutCancelURL  string
+
+	priceMu          sync.RWMutex
+	priceIDs         map[string]string // by lookup key, as RefreshPrices found them
+	configuredPrices map[string]string // by lookup key, set by SetPriceID
+}
+
+// StripePaymentIntentAPI creates Stripe payment intents. Implementations
+// must be safe for concurrent use.
+type StripePaymentIntentAPI interface {
+	CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)
+}
+
+// stripePaymentIntentAPI calls Stripe with the key set by NewStripeService
+type stripePaymentIntentAPI struct{}
+
+func (stripePaymentIntentAPI) CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	return paymentintent.New(params)
 }
 
 // NewStripeService creates a new Stripe service instance
 func NewStripeService(secretKey string) *StripeService {
 	stripe.Key = secretKey
 	return &StripeService{
-		secretKey: secretKey,
+		secretKey:      secretKey,
+		subscriptions:  stripeSubscriptionAPI{},
+		paymentIntents: stripePaymentIntentAPI{},
+		prices:         stripePriceLister{},
+		checkout:       stripeCheckoutAPI{},
+		catalog:        stripeCatalogAPI{},
+		tax:            stripeTaxAPI{},
+		invoices:       stripeInvoiceAPI{},
+		usageRecords:   stripeUsageRecordAPI{},
+		now:            time.Now,
 	}
 }
 
+// SetSubscriptionAPI replaces the Stripe API subscriptions are created and
+// changed through, e.g. with a stub in tests
+func (s *StripeService) SetSubscriptionAPI(api StripeSubscriptionAPI) {
+	s.subscriptions = api
+}
+
+// SetPaymentIntentAPI replaces the Stripe API payment intents are created
+// through, e.g. with a stub in tests
+func (s *StripeService) SetPaymentIntentAPI(api StripePaymentIntentAPI) {
+	s.paymentIntents = api
+}
+
 // SubscriptionTier represents a subscription plan
 type SubscriptionTier string
 
@@ -39,20 +92,48 @@ const (
 type SubscriptionPlan struct {
 	Name        string  `json:"name"`
 	Price       int64   `json:"price"`        // Price in cents
-	PriceID     string  `json:"price_id"`     // Stripe Price ID
+	PriceID     string  `json:"price_id"`     // Stripe Price ID, found by lookup key; empty until it is
+	AnnualPrice int64   `json:"annual_price"` // Price in cents for a year; 0 without annual billing
+	AnnualPriceID string `json:"annual_price_id"`
+	Prices      []PlanPrice `json:"prices"`   // Each way to pay for the plan, filled in by GetSubscriptionPlans
 	Features    []string `json:"features"`
 	ArvLimit    int     `json:"arv_limit"`    // -1 for unlimited
+	MaxSessions int     `json:"max_sessions"` // Per user; 0 for the server default
+	MaxPhotos   int     `json:"max_photos"`   // Per property
+	MarketDefaults bool `json:"market_defaults"` // Per-market vacancy and credit loss defaults
+	TrialDays   int     `json:"trial_days"`   // Free trial for a tenant's first subscription; 0 for none
+	APICallsIncluded int `json:"api_calls_included,omitempty"` // A month, with API access
+	APIOverageRate int64 `json:"api_overage_rate,omitempty"`   // Cents per 1,000 API calls beyond those included; 0 without API access
 	Popular     bool    `json:"popular"`
 }
 
-// GetSubscriptionPlans returns all available subscription plans
+// GetSubscriptionPlans returns all available subscription plans, each with
+// its monthly and yearly prices. Their Stripe price IDs are the ones
+// RefreshPrices found; a price it didn't find is marked unavailable.
 func (s *StripeService) GetSubscriptionPlans() map[SubscriptionTier]SubscriptionPlan {
+	plans := subscriptionPlans()
+	for tier, plan := range plans {
+		if plan.Price > 0 {
+			plan.PriceID = s.priceID(planLookupKey(tier, BillingIntervalMonth))
+		}
+		if plan.AnnualPrice > 0 {
+			plan.AnnualPriceID = s.priceID(planLookupKey(tier, BillingIntervalYear))
+		}
+		plan.Prices = planPrices(tier, plan)
+		plans[tier] = plan
+	}
+	return plans
+}
+
+// subscriptionPlans is the plan configuration, also used outside billing
+func subscriptionPlans() map[SubscriptionTier]SubscriptionPlan {
 	return map[SubscriptionTier]SubscriptionPlan{
 		TierStarter: {
 			Name:     "Starter",
 			Price:    0, // Free
 			PriceID:  "", // No Stripe price for free tier
 			ArvLimit: 10,
+			MaxPhotos: 10,
 			Features: []string{
 				"10 ARV calculations per month",
 				"Basic property analysis",
@@ -64,8 +145,11 @@ func (s *StripeService) GetSubscriptionPlans() map[SubscriptionTier]Subscription
 		TierProfessional: {
 			Name:     "Professional",
 			Price:    2900, // $29.00
-			PriceID:  "price_professional_monthly", // Will be created in Stripe
+			AnnualPrice: 29000, // $290.00, two months free
 			ArvLimit: -1, // Unlimited
+			MaxPhotos: 50,
+			MarketDefaults: true,
+			TrialDays: 14,
 			Features: []string{
 				"Unlimited ARV calculations",
 				"Advanced property analysis",
@@ -75,18 +159,24 @@ func (s *StripeService) GetSubscriptionPlans() map[SubscriptionTier]Subscription
 				"Priority support",
 				"BRRRR strategy analysis",
 				"Portfolio dashboard",
+				"Per-market vacancy and credit loss defaults",
 			},
 			Popular: true,
 		},
 		TierEnterprise: {
 			Name:     "Enterprise",
 			Price:    5900, // $59.00
-			PriceID:  "price_enterprise_monthly", // Will be created in Stripe
+			AnnualPrice: 59000, // $590.00, two months free
 			ArvLimit: -1, // Unlimited
+			MaxSessions: 25, // Teams share logins across devices
+			MaxPhotos: 100,
+			MarketDefaults: true,
+			APICallsIncluded: 10000,
+			APIOverageRate: 200, // $2.00 per 1,000 calls
 			Features: []string{
 				"Everything in Professional",
 				"FREE report generation",
-				"API access",
+				"API access: 10,000 calls a month, then $2 per 1,000",
 				"Batch property processing",
 				"White-label reports",
 				"Dedicated support",
@@ -115,18 +205,22 @@ func (s *StripeService) GetReportPaymentInfo() ReportPaymentInfo {
 	}
 }
 
-// CreateCustomer creates a new Stripe customer
-func (s *StripeService) CreateCustomer(email, name string) (*stripe.Customer, error) {
-	params := &stripe.CustomerParams{
-		Email: stripe.String(email),
-		Name:  stripe.String(name),
+// TrialDays returns the free trial a subscription to priceID starts with,
+// or 0 for a price without one
+func (s *StripeService) TrialDays(priceID string) int {
+	tier := s.priceTier(priceID)
+	if tier == "" {
+		return 0
 	}
-
-	return customer.New(params)
+	return subscriptionPlans()[tier].TrialDays
 }
 
-// CreateSubscription creates a new subscription for a customer
-func (s *StripeService) CreateSubscription(customerID, priceID string) (*stripe.Subscription, error) {
+// CreateSubscription creates a new subscription for a customer, with the
+// discount from ResolvePromotionCode when it isn't nil. With trialDays it
+// starts with a free trial, which cancels the subscription if it ends
+// without a payment method. A retry with the same idempotencyKey gets the
+// subscription the first try created.
+func (s *StripeService) CreateSubscription(customerID, priceID string, discount *SubscriptionDiscount, trialDays int, idempotencyKey string) (*stripe.Subscription, error) {
 	params := &stripe.SubscriptionParams{
 		Customer: stripe.String(customerID),
 		Items: []*stripe.SubscriptionItemsParams{
@@ -147,14 +241,38 @@ func (s *StripeService) CreateSubscription(customerID, priceID string) (*stripe.
 		CollectionMethod: stripe.String("charge_automatically"),
 	}
 
+	if s.automaticTax {
+		params.AutomaticTax = &stripe.SubscriptionAutomaticTaxParams{Enabled: stripe.Bool(true)}
+	}
+	if trialDays > 0 {
+		params.TrialPeriodDays = stripe.Int64(int64(trialDays))
+		params.TrialSettings = &stripe.SubscriptionTrialSettingsParams{
+			EndBehavior: &stripe.SubscriptionTrialSettingsEndBehaviorParams{
+				MissingPaymentMethod: stripe.String("cancel"),
+			},
+		}
+		// A trial has no first payment, so the card is saved with a setup intent
+		params.AddExpand("pending_setup_intent")
+	}
+	if discount != nil {
+		params.Discounts = []*stripe.SubscriptionDiscountParams{
+			{PromotionCode: stripe.String(discount.PromotionCodeID)},
+		}
+	}
+
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+
 	params.AddExpand("latest_invoice.payment_intent")
 	params.AddExpand("customer")
 
-	return subscription.New(params)
+	return s.subscriptions.CreateSubscription(params)
 }
 
-// CreatePaymentIntent creates a payment intent for one-time payments
-func (s *StripeService) CreatePaymentIntent(amount int64, currency, customerID string) (*stripe.PaymentIntent, error) {
+// CreatePaymentIntent creates a payment intent for one-time payments. A retry
+// with the same idempotencyKey gets the payment intent the first try created.
+func (s *StripeService) CreatePaymentIntent(amount int64, currency, customerID, idempotencyKey string) (*stripe.PaymentIntent, error) {
 	params := &stripe.PaymentIntentParams{
 		Amount:   stripe.Int64(amount),
 		Currency: stripe.String(currency),
@@ -163,12 +281,16 @@ func (s *StripeService) CreatePaymentIntent(amount int64, currency, customerID s
 			Enabled: stripe.Bool(true),
 		},
 	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
 
-	return paymentintent.New(params)
+	return s.paymentIntents.CreatePaymentIntent(params)
 }
 
-// CreateReportPaymentIntent creates a payment intent specifically for report generation
-func (s *StripeService) CreateReportPaymentIntent(customerID, propertyID string) (*stripe.PaymentIntent, error) {
+// CreateReportPaymentIntent creates a payment intent specifically for report generation,
+// idempotently like CreatePaymentIntent
+func (s *StripeService) CreateReportPaymentIntent(customerID, propertyID, idempotencyKey string) (*stripe.PaymentIntent, error) {
 	reportInfo := s.GetReportPaymentInfo()
 	
 	params := &stripe.PaymentIntentParams{
@@ -184,38 +306,20 @@ func (s *StripeService) CreateReportPaymentIntent(customerID, propertyID string)
 			"property_id": propertyID,
 		},
 	}
-
-	return paymentintent.New(params)
-}
-
-// CancelSubscription cancels a subscription
-func (s *StripeService) CancelSubscription(subscriptionID string) (*stripe.Subscription, error) {
-	params := &stripe.SubscriptionCancelParams{}
-	return subscription.Cancel(subscriptionID, params)
-}
-
-// UpdateSubscription updates a subscription to a new price
-func (s *StripeService) UpdateSubscription(subscriptionID, newPriceID string) (*stripe.Subscription, error) {
-	// Get current subscription to get the subscription item ID
-	sub, err := subscription.Get(subscriptionID, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(sub.Items.Data) == 0 {
-		return nil, fmt.Errorf("subscription has no items")
+	// With tax the payment is for the report and the tax on it
+	if s.automaticTax {
+		calc, err := s.reportTax(customerID, reportInfo)
+		if err != nil {
+			return nil, err
+		}
+		params.Amount = stripe.Int64(calc.AmountTotal)
+		reportTaxMetadata(params.Metadata, calc)
 	}
-
-	params := &stripe.SubscriptionParams{
-		Items: []*stripe.SubscriptionItemsParams{
-			{
-				ID:    stripe.String(sub.Items.Data[0].ID),
-				Price: stripe.String(newPriceID),
-			},
-		},
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
 	}
 
-	return subscription.Update(subscriptionID, params)
+	return s.paymentIntents.CreatePaymentIntent(params)
 }
 
 // GetSubscription retrieves subscription details
@@ -233,73 +337,17 @@ func (s *StripeService) ValidateWebhookSignature(payload []byte, signature, endp
 	return webhook.ConstructEvent(payload, signature, endpointSecret)
 }
 
-// CreatePrices creates subscription prices in Stripe (run this once during setup)
-func (s *StripeService) CreatePrices() error {
-	plans := s.GetSubscriptionPlans()
-
-	// Create Professional plan price
-	if professionalPlan, exists := plans[TierProfessional]; exists && professionalPlan.Price > 0 {
-		// First create the product
-		productParams := &stripe.ProductParams{
-			Name:        stripe.String("ArvFinder Professional"),
-			Description: stripe.String("Professional plan with unlimited ARV calculations and advanced features"),
-		}
-		prod, err := product.New(productParams)
-		if err != nil {
-			log.Printf("Error creating professional product: %v", err)
-			return err
-		}
-
-		// Then create the price
-		params := &stripe.PriceParams{
-			UnitAmount: stripe.Int64(professionalPlan.Price),
-			Currency:   stripe.String("usd"),
-			Recurring: &stripe.PriceRecurringParams{
-				Interval: stripe.String("month"),
-			},
-			Product: stripe.String(prod.ID),
-		}
-
-		professionalPrice, err := price.New(params)
-		if err != nil {
-			log.Printf("Error creating professional price: %v", err)
-			return err
-		}
-		log.Printf("Created professional price: %s", professionalPrice.ID)
+// ParseUnsignedWebhook parses a Stripe webhook without checking where it
+// came from. It's only for local development.
+func (s *StripeService) ParseUnsignedWebhook(payload []byte) (stripe.Event, error) {
+	var event stripe.Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return stripe.Event{}, fmt.Errorf("failed to parse webhook: %w", err)
 	}
-
-	// Create Enterprise plan price
-	if enterprisePlan, exists := plans[TierEnterprise]; exists && enterprisePlan.Price > 0 {
-		// First create the product
-		productParams := &stripe.ProductParams{
-			Name:        stripe.String("ArvFinder Enterprise"),
-			Description: stripe.String("Enterprise plan with API access, white-label reports, and team features"),
-		}
-		prod, err := product.New(productParams)
-		if err != nil {
-			log.Printf("Error creating enterprise product: %v", err)
-			return err
-		}
-
-		// Then create the price
-		params := &stripe.PriceParams{
-			UnitAmount: stripe.Int64(enterprisePlan.Price),
-			Currency:   stripe.String("usd"),
-			Recurring: &stripe.PriceRecurringParams{
-				Interval: stripe.String("month"),
-			},
-			Product: stripe.String(prod.ID),
-		}
-
-		enterprisePrice, err := price.New(params)
-		if err != nil {
-			log.Printf("Error creating enterprise price: %v", err)
-			return err
-		}
-		log.Printf("Created enterprise price: %s", enterprisePrice.ID)
+	if event.ID == "" || event.Type == "" || event.Data == nil {
+		return stripe.Event{}, errors.New("failed to parse webhook: not a Stripe event")
 	}
-
-	return nil
+	return event, nil
 }
 
 // Usage tracking for subscription limits
@@ -326,6 +374,16 @@ type SubscriptionStatus struct {
 	ArvUsed            int             `json:"arv_used"`
 	IsActive           bool            `json:"is_active"`
 	NextBilling        string          `json:"next_billing,omitempty"`
+	Status             string          `json:"status,omitempty"` // Stripe's, e.g. trialing or past_due
+	TrialEnd           *time.Time      `json:"trial_end,omitempty"`
+	TrialDaysRemaining int             `json:"trial_days_remaining,omitempty"`
+	CancelAt           *time.Time      `json:"cancel_at,omitempty"`           // while a cancellation is pending
+	CancellationNotice string          `json:"cancellation_notice,omitempty"` // e.g. "Cancels on March 3"
+	PaymentFailed      bool            `json:"payment_failed,omitempty"`      // a payment's outstanding; update the card
+	GracePeriodEnd     *time.Time      `json:"grace_period_end,omitempty"`    // when the plan drops to Starter without it
+	SeatsUsed          int             `json:"seats_used,omitempty"`          // Enterprise, billed per seat
+	SeatsPurchased     int             `json:"seats_purchased,omitempty"`
+	APIUsage           *APIUsage       `json:"api_usage,omitempty"` // this month's API calls, with API access
 	FreeReports        bool            `json:"free_reports"`
 	ReportPrice        int64           `json:"report_price,omitempty"` // in cents
 }
@@ -356,6 +414,38 @@ func (s *StripeService) GetSubscriptionStatus(tier SubscriptionTier, currentUsag
 	}
 }
 
+// WithSubscription fills in the billing details of the tenant's Stripe
+// subscription, if it has one, as of now
+func (st SubscriptionStatus) WithSubscription(sub *BillingSubscription, now time.Time) SubscriptionStatus {
+	if sub == nil {
+		return st
+	}
+	st.Status = string(sub.Status)
+	if sub.CurrentPeriodEnd != nil {
+		st.NextBilling = sub.CurrentPeriodEnd.Format(time.RFC3339)
+	}
+	if sub.Status == stripe.SubscriptionStatusTrialing && sub.TrialEnd != nil {
+		st.TrialEnd = sub.TrialEnd
+		// A trial ending later today still has a day left
+		if remaining := sub.TrialEnd.Sub(now); remaining > 0 {
+			st.TrialDaysRemaining = int((remaining + 24*time.Hour - 1) / (24 * time.Hour))
+		}
+	}
+	if sub.CancelAt != nil {
+		st.CancelAt = sub.CancelAt
+		st.CancellationNotice = "Cancels on " + sub.CancelAt.Format("January 2")
+	}
+	if sub.GracePeriodEnd != nil {
+		st.PaymentFailed = true
+		st.GracePeriodEnd = sub.GracePeriodEnd
+	}
+	if seatBilled(sub.Tier) {
+		st.SeatsUsed = sub.SeatsUsed
+		st.SeatsPurchased = sub.Seats
+	}
+	return st
+}
+
 // CanGenerateReportForFree checks if user can generate reports without payment
 func (s *StripeService) CanGenerateReportForFree(tier SubscriptionTier) bool {
 	return tier == TierProfessional || tier == TierEnterprise
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/usagerecord"
+)
+
+// StripeUsageRecordAPI reports metered usage to Stripe. Implementations
+// must be safe for concurrent use.
+type StripeUsageRecordAPI interface {
+	CreateUsageRecord(params *stripe.UsageRecordParams) (*stripe.UsageRecord, error)
+}
+
+// stripeUsageRecordAPI calls Stripe with the key set by NewStripeService
+type stripeUsageRecordAPI struct{}
+
+func (stripeUsageRecordAPI) CreateUsageRecord(params *stripe.UsageRecordParams) (*stripe.UsageRecord, error) {
+	return usagerecord.New(params)
+}
+
+// SetUsageRecordAPI replaces the Stripe API metered usage is reported
+// through, e.g. with a stub in tests
+func (s *StripeService) SetUsageRecordAPI(api StripeUsageRecordAPI) {
+	s.usageRecords = api
+}
+
+// apiMetered reports whether plan bills API calls beyond its included ones
+func apiMetered(plan SubscriptionPlan) bool {
+	return plan.APIOverageRate > 0
+}
+
+// apiUsageLookupKey is the Stripe lookup key of tier's metered price for API
+// calls, on a subscription billed every interval, e.g.
+// enterprise_api_calls_monthly
+func apiUsageLookupKey(tier SubscriptionTier, interval string) string {
+	if interval == BillingIntervalYear {
+		return string(tier) + "_api_calls_yearly"
+	}
+	return string(tier) + "_api_calls_monthly"
+}
+
+// apiUsagePrices returns the metered prices plan's API calls are billed
+// with, one for each interval the plan can be paid. Stripe won't mix
+// intervals on a subscription, so a yearly plan's calls are billed yearly.
+// Amount is per 1,000 calls.
+func apiUsagePrices(tier SubscriptionTier, plan SubscriptionPlan) []PlanPrice {
+	if !apiMetered(plan) {
+		return nil
+	}
+	var prices []PlanPrice
+	for _, p := range planPrices(tier, plan) {
+		prices = append(prices, PlanPrice{
+			Interval:  p.Interval,
+			Amount:    plan.APIOverageRate,
+			LookupKey: apiUsageLookupKey(tier, p.Interval),
+		})
+	}
+	return prices
+}
+
+// isMetered reports whether price bills reported usage rather than a
+// quantity
+func isMetered(price *stripe.Price) bool {
+	return price != nil && price.Recurring != nil && price.Recurring.UsageType == stripe.PriceRecurringUsageTypeMetered
+}
+
+// planItem returns the item sub's plan is billed with, passing over a
+// metered API usage item, or nil when there's none
+func planItem(sub *stripe.Subscription) *stripe.SubscriptionItem {
+	if sub == nil || sub.Items == nil {
+		return nil
+	}
+	for _, item := range sub.Items.Data {
+		if !isMetered(item.Price) {
+			return item
+		}
+	}
+	return nil
+}
+
+// meteredItem returns sub's metered API usage item, or nil when it hasn't
+// got one yet
+func meteredItem(sub *stripe.Subscription) *stripe.SubscriptionItem {
+	if sub == nil || sub.Items == nil {
+		return nil
+	}
+	for _, item := range sub.Items.Data {
+		if isMetered(item.Price) {
+			return item
+		}
+	}
+	return nil
+}
+
+// ReportAPIUsage adds calls to the API usage subscriptionID is billed for
+// this period, first adding its plan's metered price to it if it hasn't
+// got it. A retry with the same idempotencyKey isn't counted again.
+func (s *StripeService) ReportAPIUsage(subscriptionID string, calls int64, idempotencyKey string) error {
+	sub, err := s.subscriptions.GetSubscription(subscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to load subscription: %w", err)
+	}
+	item := meteredItem(sub)
+	if item == nil {
+		if item, err = s.addMeteredItem(sub); err != nil {
+			return err
+		}
+	}
+
+	params := &stripe.UsageRecordParams{
+		SubscriptionItem: stripe.String(item.ID),
+		Quantity:         stripe.Int64(calls),
+		Action:           stripe.String("increment"),
+		TimestampNow:     stripe.Bool(true),
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+	if _, err := s.usageRecords.CreateUsageRecord(params); err != nil {
+		return fmt.Errorf("failed to report API usage: %w", err)
+	}
+	return nil
+}
+
+// addMeteredItem adds the metered price of sub's plan, billed as often as
+// the plan, to sub and returns its item
+func (s *StripeService) addMeteredItem(sub *stripe.Subscription) (*stripe.SubscriptionItem, error) {
+	plan := planItem(sub)
+	if plan == nil || plan.Price == nil {
+		return nil, ErrSubscriptionHasNoItems
+	}
+	interval := BillingIntervalMonth
+	if plan.Price.Recurring != nil && plan.Price.Recurring.Interval == stripe.PriceRecurringIntervalYear {
+		interval = BillingIntervalYear
+	}
+	priceID := s.priceID(apiUsageLookupKey(tierForPrice(plan.Price), interval))
+	if priceID == "" {
+		return nil, ErrPlanPriceMissing
+	}
+
+	updated, err := s.subscriptions.UpdateSubscription(sub.ID, &stripe.SubscriptionParams{
+		Items:             []*stripe.SubscriptionItemsParams{{Price: stripe.String(priceID)}},
+		ProrationBehavior: stripe.String(ProrationNone),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add metered price: %w", err)
+	}
+	if updated.Items != nil {
+		for _, item := range updated.Items.Data {
+			if item.Price != nil && item.Price.ID == priceID {
+				return item, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("failed to add metered price: %s isn't on subscription %s", priceID, sub.ID)
+}
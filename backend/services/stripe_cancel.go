@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/stripe/stripe-go/v79"
+)
+
+var (
+	// ErrSubscriptionNotCanceling is returned when resuming a subscription
+	// that has no cancellation pending
+	ErrSubscriptionNotCanceling = errors.New("subscription is not set to cancel")
+	// ErrSubscriptionEnded is returned when resuming a subscription that's
+	// already been canceled
+	ErrSubscriptionEnded = errors.New("subscription has already ended")
+)
+
+// CancelSubscription cancels a subscription. At the period end, the
+// customer keeps the plan for the time they've paid for and the
+// subscription can be resumed until then; otherwise it ends straight away.
+func (s *StripeService) CancelSubscription(subscriptionID string, atPeriodEnd bool) (*stripe.Subscription, error) {
+	if atPeriodEnd {
+		return s.subscriptions.UpdateSubscription(subscriptionID, &stripe.SubscriptionParams{
+			CancelAtPeriodEnd: stripe.Bool(true),
+		})
+	}
+	return s.subscriptions.CancelSubscription(subscriptionID, &stripe.SubscriptionCancelParams{})
+}
+
+// ResumeSubscription takes back a cancellation at the period end before the
+// period's over. It returns ErrSubscriptionNotCanceling when none is
+// pending and ErrSubscriptionEnded once the subscription's been canceled.
+func (s *StripeService) ResumeSubscription(subscriptionID string) (*stripe.Subscription, error) {
+	sub, err := s.subscriptions.GetSubscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Status == stripe.SubscriptionStatusCanceled {
+		return nil, ErrSubscriptionEnded
+	}
+	if PendingCancellation(sub) == nil {
+		return nil, ErrSubscriptionNotCanceling
+	}
+	return s.subscriptions.UpdateSubscription(subscriptionID, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(false),
+	})
+}
+
+// PendingCancellation is when sub is set to cancel, or nil when it isn't
+func PendingCancellation(sub *stripe.Subscription) *time.Time {
+	if sub.Status == stripe.SubscriptionStatusCanceled {
+		return nil
+	}
+	if sub.CancelAt > 0 {
+		return unixTime(sub.CancelAt)
+	}
+	if sub.CancelAtPeriodEnd {
+		return unixTime(sub.CurrentPeriodEnd)
+	}
+	return nil
+}
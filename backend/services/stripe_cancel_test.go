@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+)
+
+func TestCancelSubscription_AtPeriodEnd(t *testing.T) {
+	s, api := newTestSubscriptionService()
+
+	_, err := s.CancelSubscription("sub_1", true)
+
+	require.NoError(t, err)
+	assert.False(t, api.canceled, "the paid-for period is kept")
+	require.NotNil(t, api.updated)
+	assert.True(t, *api.updated.CancelAtPeriodEnd)
+}
+
+func TestCancelSubscription_Immediately(t *testing.T) {
+	s, api := newTestSubscriptionService()
+
+	sub, err := s.CancelSubscription("sub_1", false)
+
+	require.NoError(t, err)
+	assert.True(t, api.canceled)
+	assert.Nil(t, api.updated)
+	assert.Nil(t, PendingCancellation(sub), "nothing's pending once it's canceled")
+}
+
+func TestResumeSubscription(t *testing.T) {
+	s, api := newTestSubscriptionService()
+
+	// Nothing to take back yet
+	_, err := s.ResumeSubscription("sub_1")
+	assert.ErrorIs(t, err, ErrSubscriptionNotCanceling)
+
+	api.sub.CancelAtPeriodEnd = true
+	assert.Equal(t, testPeriodEnd, *PendingCancellation(api.sub))
+	_, err = s.ResumeSubscription("sub_1")
+	require.NoError(t, err)
+	assert.False(t, *api.updated.CancelAtPeriodEnd)
+
+	// Too late once the period's over
+	api.sub.Status = stripe.SubscriptionStatusCanceled
+	_, err = s.ResumeSubscription("sub_1")
+	assert.ErrorIs(t, err, ErrSubscriptionEnded)
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"strconv"
+
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/checkout/session"
+)
+
+// StripeCheckoutAPI creates Stripe Checkout sessions. Implementations must
+// be safe for concurrent use.
+type StripeCheckoutAPI interface {
+	CreateCheckoutSession(params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error)
+}
+
+// stripeCheckoutAPI calls Stripe with the key set by NewStripeService
+type stripeCheckoutAPI struct{}
+
+func (stripeCheckoutAPI) CreateCheckoutSession(params *stripe.CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	return session.New(params)
+}
+
+// SetCheckoutAPI replaces the Stripe API Checkout sessions are created
+// through, e.g. with a stub in tests
+func (s *StripeService) SetCheckoutAPI(api StripeCheckoutAPI) {
+	s.checkout = api
+}
+
+// SetCheckoutURLs sets where Checkout sends the customer after paying and
+// after giving up. Stripe replaces {CHECKOUT_SESSION_ID} in successURL with
+// the session's ID.
+func (s *StripeService) SetCheckoutURLs(successURL, cancelURL string) {
+	s.checkoutSuccessURL = successURL
+	s.checkoutCancelURL = cancelURL
+}
+
+// checkoutURLs returns the URLs set by SetCheckoutURLs, or else pages of the
+// frontend at APP_BASE_URL
+func (s *StripeService) checkoutURLs() (string, string) {
+	successURL, cancelURL := s.checkoutSuccessURL, s.checkoutCancelURL
+	if successURL == "" {
+		successURL = appBaseURL() + "/checkout/success?session_id={CHECKOUT_SESSION_ID}"
+	}
+	if cancelURL == "" {
+		cancelURL = appBaseURL() + "/pricing"
+	}
+	return successURL, cancelURL
+}
+
+// CreateSubscriptionCheckout creates a Checkout session subscribing
+// tenantID's customer to priceID, with a free trial of trialDays when it
+// isn't 0. The subscription is recorded when the webhook hears the session
+// completed.
+func (s *StripeService) CreateSubscriptionCheckout(tenantID, customerID, priceID string, trialDays int, idempotencyKey string) (*stripe.CheckoutSession, error) {
+	params := s.checkoutParams(stripe.CheckoutSessionModeSubscription, tenantID, customerID)
+	params.LineItems = []*stripe.CheckoutSessionLineItemParams{
+		{Price: stripe.String(priceID), Quantity: stripe.Int64(1)},
+	}
+	params.AllowPromotionCodes = stripe.Bool(true)
+	params.SubscriptionData = &stripe.CheckoutSessionSubscriptionDataParams{}
+	params.SubscriptionData.AddMetadata("tenant_id", tenantID)
+	if tier := s.priceTier(priceID); tier != "" {
+		params.AddMetadata("tier", string(tier))
+	}
+	if trialDays > 0 {
+		params.SubscriptionData.TrialPeriodDays = stripe.Int64(int64(trialDays))
+		params.SubscriptionData.TrialSettings = &stripe.CheckoutSessionSubscriptionDataTrialSettingsParams{
+			EndBehavior: &stripe.CheckoutSessionSubscriptionDataTrialSettingsEndBehaviorParams{
+				MissingPaymentMethod: stripe.String("cancel"),
+			},
+		}
+		// Given back if the session expires unpaid
+		params.AddMetadata("trial_days", strconv.Itoa(trialDays))
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+	return s.checkout.CreateCheckoutSession(params)
+}
+
+// CreateReportCheckout creates a Checkout session paying for a report on
+// propertyID. The purchase is recorded when the webhook hears it's paid,
+// the same as a report paid for with CreateReportPaymentIntent.
+func (s *StripeService) CreateReportCheckout(tenantID, customerID, propertyID, idempotencyKey string) (*stripe.CheckoutSession, error) {
+	reportInfo := s.GetReportPaymentInfo()
+
+	params := s.checkoutParams(stripe.CheckoutSessionModePayment, tenantID, customerID)
+	params.LineItems = []*stripe.CheckoutSessionLineItemParams{
+		{
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				Currency:   stripe.String(reportInfo.Currency),
+				UnitAmount: stripe.Int64(reportInfo.Price),
+				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+					Name: stripe.String(reportInfo.Description),
+				},
+			},
+			Quantity: stripe.Int64(1),
+		},
+	}
+	params.AddMetadata("type", "report_generation")
+	params.AddMetadata("property_id", propertyID)
+	params.PaymentIntentData = &stripe.CheckoutSessionPaymentIntentDataParams{
+		Description: stripe.String(reportInfo.Description),
+		Metadata: map[string]string{
+			"type":        "report_generation",
+			"property_id": propertyID,
+			"tenant_id":   tenantID,
+		},
+	}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+	return s.checkout.CreateCheckoutSession(params)
+}
+
+// checkoutParams starts a Checkout session in mode for tenantID's customer
+func (s *StripeService) checkoutParams(mode stripe.CheckoutSessionMode, tenantID, customerID string) *stripe.CheckoutSessionParams {
+	successURL, cancelURL := s.checkoutURLs()
+	params := &stripe.CheckoutSessionParams{
+		Mode:              stripe.String(string(mode)),
+		Customer:          stripe.String(customerID),
+		ClientReferenceID: stripe.String(tenantID),
+		SuccessURL:        stripe.String(successURL),
+		CancelURL:         stripe.String(cancelURL),
+	}
+	params.AddMetadata("tenant_id", tenantID)
+	// Checkout asks for the address tax is worked out from
+	if s.automaticTax {
+		params.AutomaticTax = &stripe.CheckoutSessionAutomaticTaxParams{Enabled: stripe.Bool(true)}
+		params.BillingAddressCollection = stripe.String("required")
+		params.CustomerUpdate = &stripe.CheckoutSessionCustomerUpdateParams{
+			Address: stripe.String("auto"),
+			Name:    stripe.String("auto"),
+		}
+	}
+	return params
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/customer"
+)
+
+// StripeCustomerAPI is the part of Stripe's customer API that finding or
+// creating a customer needs. Implementations must be safe for concurrent use.
+type StripeCustomerAPI interface {
+	// FindByEmail returns the customer with email, or nil when there's none
+	FindByEmail(email string) (*stripe.Customer, error)
+	Create(params *stripe.CustomerParams) (*stripe.Customer, error)
+	Update(id string, params *stripe.CustomerParams) (*stripe.Customer, error)
+}
+
+// stripeCustomerAPI calls Stripe with the key set by NewStripeService
+type stripeCustomerAPI struct{}
+
+// FindByEmail lists customers filtered by email. Unlike Stripe's search API
+// the list sees a customer created a moment ago.
+func (stripeCustomerAPI) FindByEmail(email string) (*stripe.Customer, error) {
+	params := &stripe.CustomerListParams{Email: stripe.String(email)}
+	params.Limit = stripe.Int64(1)
+	iter := customer.List(params)
+	if iter.Next() {
+		return iter.Customer(), nil
+	}
+	return nil, iter.Err()
+}
+
+// Create creates a customer
+func (stripeCustomerAPI) Create(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return customer.New(params)
+}
+
+// Update changes a customer
+func (stripeCustomerAPI) Update(id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return customer.Update(id, params)
+}
+
+// StripeCustomerRepository keeps one Stripe customer per tenant, so every
+// subscription and report a tenant pays for shares one payment history
+type StripeCustomerRepository struct {
+	db  *sql.DB
+	api StripeCustomerAPI
+}
+
+// NewStripeCustomerRepository creates a new Stripe customer repository
+func NewStripeCustomerRepository(db *sql.DB) *StripeCustomerRepository {
+	return &StripeCustomerRepository{db: db, api: stripeCustomerAPI{}}
+}
+
+// SetAPI replaces the Stripe API customers are found and created through,
+// e.g. with a stub in tests
+func (r *StripeCustomerRepository) SetAPI(api StripeCustomerAPI) {
+	r.api = api
+}
+
+// GetOrCreateCustomer returns the ID of tenantID's Stripe customer: the one
+// stored for the tenant, or else an existing Stripe customer with email that
+// no other tenant owns, or else a new one. The customer found or created is
+// stored for next time, and one adopted is marked as the tenant's. Without a
+// tenant, for a purchase before signing in, nothing is stored. A retry with
+// the same idempotencyKey doesn't create a second customer.
+func (r *StripeCustomerRepository) GetOrCreateCustomer(tenantID, email, name, idempotencyKey string) (string, error) {
+	if tenantID != "" {
+		var customerID string
+		err := r.db.QueryRow(`SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = $1`, tenantID).Scan(&customerID)
+		if err == nil {
+			return customerID, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", fmt.Errorf("failed to load Stripe customer: %w", err)
+		}
+	}
+
+	existing, err := r.api.FindByEmail(email)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up Stripe customer: %w", err)
+	}
+	// Another tenant's customer isn't taken over, whatever email was given
+	if existing != nil && !customerOwnedBy(existing, tenantID) {
+		existing = nil
+	}
+	adopted := existing != nil
+	if !adopted {
+		params := &stripe.CustomerParams{
+			Email: stripe.String(email),
+			Name:  stripe.String(name),
+		}
+		if tenantID != "" {
+			params.AddMetadata("tenant_id", tenantID)
+		}
+		if idempotencyKey != "" {
+			params.SetIdempotencyKey(idempotencyKey)
+		}
+		existing, err = r.api.Create(params)
+		if err != nil {
+			return "", fmt.Errorf("failed to create Stripe customer: %w", err)
+		}
+	}
+	if tenantID == "" {
+		return existing.ID, nil
+	}
+	if adopted && existing.Metadata["tenant_id"] == "" {
+		params := &stripe.CustomerParams{}
+		params.AddMetadata("tenant_id", tenantID)
+		if _, err := r.api.Update(existing.ID, params); err != nil {
+			return "", fmt.Errorf("failed to claim Stripe customer: %w", err)
+		}
+	}
+
+	// If another purchase stored a customer first, that one's kept
+	var customerID string
+	err = r.db.QueryRow(`
+		INSERT INTO stripe_customers (tenant_id, stripe_customer_id, email)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id) DO UPDATE SET tenant_id = EXCLUDED.tenant_id
+		RETURNING stripe_customer_id
+	`, tenantID, existing.ID, email).Scan(&customerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to save Stripe customer: %w", err)
+	}
+	return customerID, nil
+}
+
+// customerOwnedBy reports whether customer can be used for tenantID: it's
+// marked as the tenant's or as no tenant's
+func customerOwnedBy(customer *stripe.Customer, tenantID string) bool {
+	owner := customer.Metadata["tenant_id"]
+	return owner == "" || owner == tenantID
+}
+
+// Customer returns the ID of tenantID's Stripe customer, or "" for a tenant
+// that's never paid for anything
+func (r *StripeCustomerRepository) Customer(tenantID string) (string, error) {
+	var customerID string
+	err := r.db.QueryRow(`SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = $1`, tenantID).Scan(&customerID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load Stripe customer: %w", err)
+	}
+	return customerID, nil
+}
+
+// SaveBillingAddress sets customerID's billing address in Stripe, where tax
+// is worked out from it, and keeps it for tenantID so it's asked for once.
+// Without a tenant it's only set in Stripe.
+func (r *StripeCustomerRepository) SaveBillingAddress(tenantID, customerID string, address BillingAddress) error {
+	if _, err := r.api.Update(customerID, &stripe.CustomerParams{Address: address.params()}); err != nil {
+		return fmt.Errorf("failed to save billing address: %w", err)
+	}
+	if tenantID == "" {
+		return nil
+	}
+
+	encoded, err := json.Marshal(address)
+	if err != nil {
+		return fmt.Errorf("failed to encode billing address: %w", err)
+	}
+	_, err = r.db.Exec(`UPDATE stripe_customers SET billing_address = $2 WHERE tenant_id = $1`, tenantID, string(encoded))
+	if err != nil {
+		return fmt.Errorf("failed to save billing address: %w", err)
+	}
+	return nil
+}
+
+// BillingAddress returns the billing address kept for tenantID, or nil
+// without one
+func (r *StripeCustomerRepository) BillingAddress(tenantID string) (*BillingAddress, error) {
+	var encoded sql.NullString
+	err := r.db.QueryRow(`SELECT billing_address FROM stripe_customers WHERE tenant_id = $1`, tenantID).Scan(&encoded)
+	if err == sql.ErrNoRows || (err == nil && !encoded.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load billing address: %w", err)
+	}
+	var address BillingAddress
+	if err := json.Unmarshal([]byte(encoded.String), &address); err != nil {
+		return nil, fmt.Errorf("failed to decode billing address: %w", err)
+	}
+	return &address, nil
+}
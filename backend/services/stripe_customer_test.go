@@ -0,0 +1,153 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+)
+
+// fakeStripeCustomers stands in for Stripe, counting the customers created
+// and updated
+type fakeStripeCustomers struct {
+	byEmail map[string]*stripe.Customer
+	created []*stripe.CustomerParams
+	updated []*stripe.CustomerParams
+}
+
+func (f *fakeStripeCustomers) FindByEmail(email string) (*stripe.Customer, error) {
+	return f.byEmail[email], nil
+}
+
+func (f *fakeStripeCustomers) Create(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	f.created = append(f.created, params)
+	return &stripe.Customer{ID: "cus_new", Email: *params.Email}, nil
+}
+
+func (f *fakeStripeCustomers) Update(id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	f.updated = append(f.updated, params)
+	return &stripe.Customer{ID: id}, nil
+}
+
+func newTestStripeCustomerRepository(t *testing.T) (*StripeCustomerRepository, *fakeStripeCustomers, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	api := &fakeStripeCustomers{byEmail: map[string]*stripe.Customer{}}
+	return &StripeCustomerRepository{db: db, api: api}, api, mock
+}
+
+const storedCustomerQuery = `SELECT stripe_customer_id FROM stripe_customers WHERE tenant_id = \$1`
+const saveCustomerQuery = `INSERT INTO stripe_customers .* ON CONFLICT \(tenant_id\) DO UPDATE .* RETURNING stripe_customer_id`
+
+func TestGetOrCreateCustomer_SecondPurchaseReusesStoredCustomer(t *testing.T) {
+	repo, api, mock := newTestStripeCustomerRepository(t)
+
+	// The first purchase creates the customer and stores it...
+	mock.ExpectQuery(storedCustomerQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}))
+	mock.ExpectQuery(saveCustomerQuery).
+		WithArgs("tenant-1", "cus_new", "jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_new"))
+
+	customerID, err := repo.GetOrCreateCustomer("tenant-1", "jane@example.com", "Jane Doe", "")
+	require.NoError(t, err)
+	assert.Equal(t, "cus_new", customerID)
+	require.Len(t, api.created, 1)
+	assert.Equal(t, "tenant-1", api.created[0].Metadata["tenant_id"])
+
+	// ...and the second finds it without going to Stripe
+	mock.ExpectQuery(storedCustomerQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_new"))
+
+	customerID, err = repo.GetOrCreateCustomer("tenant-1", "jane@example.com", "Jane Doe", "")
+	require.NoError(t, err)
+	assert.Equal(t, "cus_new", customerID)
+	assert.Len(t, api.created, 1, "no second customer is created")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrCreateCustomer_AdoptsCustomerWithSameEmail(t *testing.T) {
+	repo, api, mock := newTestStripeCustomerRepository(t)
+	api.byEmail["jane@example.com"] = &stripe.Customer{ID: "cus_existing"}
+	mock.ExpectQuery(storedCustomerQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}))
+	mock.ExpectQuery(saveCustomerQuery).
+		WithArgs("tenant-1", "cus_existing", "jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_existing"))
+
+	customerID, err := repo.GetOrCreateCustomer("tenant-1", "jane@example.com", "Jane Doe", "")
+
+	require.NoError(t, err)
+	assert.Equal(t, "cus_existing", customerID)
+	assert.Empty(t, api.created)
+	require.Len(t, api.updated, 1)
+	assert.Equal(t, "tenant-1", api.updated[0].Metadata["tenant_id"], "it's marked as the tenant's")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrCreateCustomer_LeavesAnotherTenantsCustomer(t *testing.T) {
+	repo, api, mock := newTestStripeCustomerRepository(t)
+	// tenant-2 got to jane@example.com first, giving it as its own
+	api.byEmail["jane@example.com"] = &stripe.Customer{ID: "cus_tenant_2", Metadata: map[string]string{"tenant_id": "tenant-2"}}
+	mock.ExpectQuery(storedCustomerQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}))
+	mock.ExpectQuery(saveCustomerQuery).
+		WithArgs("tenant-1", "cus_new", "jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_new"))
+
+	customerID, err := repo.GetOrCreateCustomer("tenant-1", "jane@example.com", "Jane Doe", "")
+
+	require.NoError(t, err)
+	assert.Equal(t, "cus_new", customerID)
+	require.Len(t, api.created, 1)
+	assert.Equal(t, "tenant-1", api.created[0].Metadata["tenant_id"])
+	assert.Empty(t, api.updated, "tenant-2's customer is left alone")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrCreateCustomer_WithoutTenantLeavesTenantsCustomer(t *testing.T) {
+	repo, api, mock := newTestStripeCustomerRepository(t)
+	api.byEmail["jane@example.com"] = &stripe.Customer{ID: "cus_tenant_1", Metadata: map[string]string{"tenant_id": "tenant-1"}}
+
+	customerID, err := repo.GetOrCreateCustomer("", "jane@example.com", "Jane Doe", "")
+
+	require.NoError(t, err)
+	assert.Equal(t, "cus_new", customerID, "a tenant's customer ID isn't handed out")
+	require.Len(t, api.created, 1)
+	assert.Empty(t, api.created[0].Metadata)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrCreateCustomer_KeepsCustomerStoredByConcurrentPurchase(t *testing.T) {
+	repo, _, mock := newTestStripeCustomerRepository(t)
+	mock.ExpectQuery(storedCustomerQuery).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}))
+	mock.ExpectQuery(saveCustomerQuery).
+		WithArgs("tenant-1", "cus_new", "jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_customer_id"}).AddRow("cus_first"))
+
+	customerID, err := repo.GetOrCreateCustomer("tenant-1", "jane@example.com", "Jane Doe", "")
+
+	require.NoError(t, err)
+	assert.Equal(t, "cus_first", customerID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOrCreateCustomer_WithoutTenantStoresNothing(t *testing.T) {
+	repo, api, mock := newTestStripeCustomerRepository(t)
+	api.byEmail["jane@example.com"] = &stripe.Customer{ID: "cus_existing"}
+
+	customerID, err := repo.GetOrCreateCustomer("", "jane@example.com", "Jane Doe", "")
+
+	require.NoError(t, err)
+	assert.Equal(t, "cus_existing", customerID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/invoice"
+)
+
+// StripeInvoiceAPI lists a customer's Stripe invoices. Implementations must
+// be safe for concurrent use.
+type StripeInvoiceAPI interface {
+	ListInvoices(customerID string, limit int64) ([]*stripe.Invoice, error)
+}
+
+// stripeInvoiceAPI calls Stripe with the key set by NewStripeService
+type stripeInvoiceAPI struct{}
+
+func (stripeInvoiceAPI) ListInvoices(customerID string, limit int64) ([]*stripe.Invoice, error) {
+	params := &stripe.InvoiceListParams{Customer: stripe.String(customerID)}
+	params.Limit = stripe.Int64(limit)
+	params.Single = true
+	var invoices []*stripe.Invoice
+	iter := invoice.List(params)
+	for iter.Next() {
+		invoices = append(invoices, iter.Invoice())
+	}
+	return invoices, iter.Err()
+}
+
+// SetInvoiceAPI replaces the Stripe API invoices are listed through, e.g.
+// with a stub in tests
+func (s *StripeService) SetInvoiceAPI(api StripeInvoiceAPI) {
+	s.invoices = api
+}
+
+// InvoiceSummary is one of a customer's invoices, with the tax charged on it
+type InvoiceSummary struct {
+	ID               string    `json:"id"`
+	Number           string    `json:"number"`
+	Status           string    `json:"status"`
+	Created          time.Time `json:"created"`
+	Currency         string    `json:"currency"`
+	Subtotal         int64     `json:"subtotal"` // cents, before tax
+	Tax              int64     `json:"tax"`      // cents
+	Total            int64     `json:"total"`
+	AmountPaid       int64     `json:"amount_paid"`
+	HostedInvoiceURL string    `json:"hosted_invoice_url"`
+}
+
+// Invoices returns customerID's most recent invoices, newest first
+func (s *StripeService) Invoices(customerID string, limit int64) ([]InvoiceSummary, error) {
+	invoices, err := s.invoices.ListInvoices(customerID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invoices: %w", err)
+	}
+	summaries := make([]InvoiceSummary, 0, len(invoices))
+	for _, inv := range invoices {
+		summaries = append(summaries, InvoiceSummary{
+			ID:               inv.ID,
+			Number:           inv.Number,
+			Status:           string(inv.Status),
+			Created:          time.Unix(inv.Created, 0).UTC(),
+			Currency:         string(inv.Currency),
+			Subtotal:         inv.Subtotal,
+			Tax:              inv.Tax,
+			Total:            inv.Total,
+			AmountPaid:       inv.AmountPaid,
+			HostedInvoiceURL: inv.HostedInvoiceURL,
+		})
+	}
+	return summaries, nil
+}
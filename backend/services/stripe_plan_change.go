@@ -0,0 +1,334 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/invoice"
+	"github.com/stripe/stripe-go/v79/price"
+	"github.com/stripe/stripe-go/v79/promotioncode"
+	"github.com/stripe/stripe-go/v79/subscription"
+	"github.com/stripe/stripe-go/v79/subscriptionschedule"
+)
+
+// Proration behaviors a plan change can ask for. create_prorations credits
+// or charges the difference on the next invoice, always_invoice charges it
+// now and none bills the new price from the next period.
+const (
+	ProrationCreateProrations = "create_prorations"
+	ProrationNone             = "none"
+	ProrationAlwaysInvoice    = "always_invoice"
+)
+
+// ErrSubscriptionHasNoItems is returned for a subscription without a price
+// to change
+var ErrSubscriptionHasNoItems = errors.New("subscription has no items")
+
+// StripeSubscriptionAPI is the part of Stripe's API that creating and
+// changing subscriptions needs. Implementations must be safe for concurrent
+// use.
+type StripeSubscriptionAPI interface {
+	CreateSubscription(params *stripe.SubscriptionParams) (*stripe.Subscription, error)
+	GetSubscription(id string) (*stripe.Subscription, error)
+	UpdateSubscription(id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error)
+	CancelSubscription(id string, params *stripe.SubscriptionCancelParams) (*stripe.Subscription, error)
+	GetPrice(id string) (*stripe.Price, error)
+	UpcomingInvoice(params *stripe.InvoiceUpcomingParams) (*stripe.Invoice, error)
+	// ScheduleSubscription puts an existing subscription on a schedule,
+	// starting with a phase matching it as it is
+	ScheduleSubscription(subscriptionID string) (*stripe.SubscriptionSchedule, error)
+	UpdateSchedule(id string, params *stripe.SubscriptionScheduleParams) (*stripe.SubscriptionSchedule, error)
+	// FindPromotionCode returns the promotion code a customer types in, with
+	// its coupon, or nil when there's none
+	FindPromotionCode(code string) (*stripe.PromotionCode, error)
+}
+
+// stripeSubscriptionAPI calls Stripe with the key set by NewStripeService
+type stripeSubscriptionAPI struct{}
+
+func (stripeSubscriptionAPI) CreateSubscription(params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	return subscription.New(params)
+}
+
+func (stripeSubscriptionAPI) CancelSubscription(id string, params *stripe.SubscriptionCancelParams) (*stripe.Subscription, error) {
+	return subscription.Cancel(id, params)
+}
+
+func (stripeSubscriptionAPI) GetSubscription(id string) (*stripe.Subscription, error) {
+	return subscription.Get(id, nil)
+}
+
+func (stripeSubscriptionAPI) UpdateSubscription(id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	return subscription.Update(id, params)
+}
+
+func (stripeSubscriptionAPI) GetPrice(id string) (*stripe.Price, error) {
+	return price.Get(id, nil)
+}
+
+func (stripeSubscriptionAPI) UpcomingInvoice(params *stripe.InvoiceUpcomingParams) (*stripe.Invoice, error) {
+	return invoice.Upcoming(params)
+}
+
+func (stripeSubscriptionAPI) ScheduleSubscription(subscriptionID string) (*stripe.SubscriptionSchedule, error) {
+	return subscriptionschedule.New(&stripe.SubscriptionScheduleParams{
+		FromSubscription: stripe.String(subscriptionID),
+	})
+}
+
+func (stripeSubscriptionAPI) UpdateSchedule(id string, params *stripe.SubscriptionScheduleParams) (*stripe.SubscriptionSchedule, error) {
+	return subscriptionschedule.Update(id, params)
+}
+
+func (stripeSubscriptionAPI) FindPromotionCode(code string) (*stripe.PromotionCode, error) {
+	params := &stripe.PromotionCodeListParams{Code: stripe.String(code)}
+	params.Limit = stripe.Int64(1)
+	params.AddExpand("data.coupon.applies_to")
+	iter := promotioncode.List(params)
+	if iter.Next() {
+		return iter.PromotionCode(), nil
+	}
+	return nil, iter.Err()
+}
+
+// PlanChange is a subscription's move to a new price. A downgrade without
+// an explicit proration behavior waits for the end of the current period.
+type PlanChange struct {
+	SubscriptionID    string    `json:"subscription_id"`
+	Status            string    `json:"status"`
+	Downgrade         bool      `json:"downgrade"`
+	ProrationBehavior string    `json:"proration_behavior,omitempty"` // empty when scheduled
+	EffectiveAt       time.Time `json:"effective_at"`
+}
+
+// PlanChangePreview is what a plan change would cost, from Stripe's
+// upcoming invoice
+type PlanChangePreview struct {
+	Currency          string    `json:"currency"`
+	ImmediateAmount   int64     `json:"immediate_amount"` // cents; prorations, charged now with always_invoice or an interval change and on the next invoice otherwise
+	RecurringAmount   int64     `json:"recurring_amount"` // cents, each period on the new price
+	NextInvoiceAmount int64     `json:"next_invoice_amount"`
+	Tax               int64     `json:"tax"` // cents, on the next invoice; included in its amount
+	Downgrade         bool      `json:"downgrade"`
+	IntervalChange    bool      `json:"interval_change"` // e.g. monthly to yearly, invoiced right away
+	ProrationBehavior string    `json:"proration_behavior"`
+	EffectiveAt       time.Time `json:"effective_at"`
+}
+
+// planChange is a plan change worked out but not yet made
+type planChange struct {
+	sub       *stripe.Subscription
+	itemID    string
+	metered   *stripe.SubscriptionItem // the API usage item, if there's one
+	downgrade bool
+	interval  bool   // changes the billing interval
+	proration string // empty for a downgrade at period end
+	effective time.Time
+}
+
+// resolvePlanChange works out how subscriptionID would move to newPriceID
+// with prorationBehavior, which may be empty for the default
+func (s *StripeService) resolvePlanChange(subscriptionID, newPriceID, prorationBehavior string) (planChange, error) {
+	sub, err := s.subscriptions.GetSubscription(subscriptionID)
+	if err != nil {
+		return planChange{}, err
+	}
+	item := planItem(sub)
+	if item == nil {
+		return planChange{}, ErrSubscriptionHasNoItems
+	}
+	newPrice, err := s.subscriptions.GetPrice(newPriceID)
+	if err != nil {
+		return planChange{}, err
+	}
+
+	change := planChange{
+		sub:       sub,
+		itemID:    item.ID,
+		downgrade: isDowngrade(item.Price, newPrice),
+		interval:  isIntervalChange(item.Price, newPrice),
+		proration: prorationBehavior,
+		effective: s.now(),
+		metered:   meteredItem(sub),
+	}
+	if change.downgrade && prorationBehavior == "" {
+		change.effective = time.Unix(sub.CurrentPeriodEnd, 0).UTC()
+	} else if prorationBehavior == "" {
+		change.proration = ProrationCreateProrations
+	}
+	return change, nil
+}
+
+// items are the subscription items changed to move to newPriceID. An API
+// usage item is dropped, its usage so far still billed, as the new plan may
+// be billed at another interval or have no API access; ReportAPIUsage adds
+// the new plan's when it's used.
+func (c planChange) items(newPriceID string) []*stripe.SubscriptionItemsParams {
+	items := []*stripe.SubscriptionItemsParams{
+		{
+			ID:    stripe.String(c.itemID),
+			Price: stripe.String(newPriceID),
+		},
+	}
+	if c.metered != nil {
+		items = append(items, &stripe.SubscriptionItemsParams{
+			ID:      stripe.String(c.metered.ID),
+			Deleted: stripe.Bool(true),
+		})
+	}
+	return items
+}
+
+// UpdateSubscription moves a subscription to a new price. Upgrades, and
+// downgrades given a proration behavior, take effect now; other downgrades
+// are scheduled for the end of the current period, so the tenant keeps
+// what it's paid for until then.
+func (s *StripeService) UpdateSubscription(subscriptionID, newPriceID, prorationBehavior string) (PlanChange, error) {
+	change, err := s.resolvePlanChange(subscriptionID, newPriceID, prorationBehavior)
+	if err != nil {
+		return PlanChange{}, err
+	}
+	result := PlanChange{
+		SubscriptionID:    subscriptionID,
+		Downgrade:         change.downgrade,
+		ProrationBehavior: change.proration,
+		EffectiveAt:       change.effective,
+	}
+
+	if change.proration == "" {
+		if err := s.scheduleDowngrade(change, newPriceID); err != nil {
+			return PlanChange{}, err
+		}
+		result.Status = string(change.sub.Status)
+		return result, nil
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items:             change.items(newPriceID),
+		ProrationBehavior: stripe.String(change.proration),
+	}
+	sub, err := s.subscriptions.UpdateSubscription(subscriptionID, params)
+	if err != nil {
+		return PlanChange{}, err
+	}
+	result.Status = string(sub.Status)
+	return result, nil
+}
+
+// scheduleDowngrade keeps the current price until the period ends and
+// switches to newPriceID after it
+func (s *StripeService) scheduleDowngrade(change planChange, newPriceID string) error {
+	schedule, err := s.subscriptions.ScheduleSubscription(change.sub.ID)
+	if err != nil {
+		return fmt.Errorf("failed to schedule plan change: %w", err)
+	}
+	if len(schedule.Phases) == 0 {
+		return fmt.Errorf("failed to schedule plan change: schedule has no phases")
+	}
+	current := schedule.Phases[0]
+
+	var currentItems []*stripe.SubscriptionSchedulePhaseItemParams
+	for _, item := range current.Items {
+		phaseItem := &stripe.SubscriptionSchedulePhaseItemParams{Price: stripe.String(item.Price.ID)}
+		// A metered price has no quantity
+		if change.metered == nil || change.metered.Price == nil || item.Price.ID != change.metered.Price.ID {
+			phaseItem.Quantity = stripe.Int64(item.Quantity)
+		}
+		currentItems = append(currentItems, phaseItem)
+	}
+	_, err = s.subscriptions.UpdateSchedule(schedule.ID, &stripe.SubscriptionScheduleParams{
+		EndBehavior: stripe.String(string(stripe.SubscriptionScheduleEndBehaviorRelease)),
+		Phases: []*stripe.SubscriptionSchedulePhaseParams{
+			{
+				Items:     currentItems,
+				StartDate: stripe.Int64(current.StartDate),
+				EndDate:   stripe.Int64(change.sub.CurrentPeriodEnd),
+			},
+			{
+				Items:      []*stripe.SubscriptionSchedulePhaseItemParams{{Price: stripe.String(newPriceID)}},
+				Iterations: stripe.Int64(1),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule plan change: %w", err)
+	}
+	return nil
+}
+
+// PreviewPlanChange returns what UpdateSubscription with the same arguments
+// would charge, without changing anything
+func (s *StripeService) PreviewPlanChange(subscriptionID, newPriceID, prorationBehavior string) (PlanChangePreview, error) {
+	change, err := s.resolvePlanChange(subscriptionID, newPriceID, prorationBehavior)
+	if err != nil {
+		return PlanChangePreview{}, err
+	}
+	// A scheduled downgrade prorates nothing
+	proration := change.proration
+	if proration == "" {
+		proration = ProrationNone
+	}
+
+	params := &stripe.InvoiceUpcomingParams{
+		Customer:                      stripe.String(customerID(change.sub.Customer)),
+		Subscription:                  stripe.String(subscriptionID),
+		SubscriptionItems:             change.items(newPriceID),
+		SubscriptionProrationBehavior: stripe.String(proration),
+		SubscriptionProrationDate:     stripe.Int64(change.effective.Unix()),
+	}
+	upcoming, err := s.subscriptions.UpcomingInvoice(params)
+	if err != nil {
+		return PlanChangePreview{}, fmt.Errorf("failed to preview invoice: %w", err)
+	}
+
+	preview := PlanChangePreview{
+		Currency:          string(upcoming.Currency),
+		NextInvoiceAmount: upcoming.AmountDue,
+		Tax:               upcoming.Tax,
+		Downgrade:         change.downgrade,
+		IntervalChange:    change.interval,
+		ProrationBehavior: change.proration,
+		EffectiveAt:       change.effective,
+	}
+	if upcoming.Lines != nil {
+		for _, line := range upcoming.Lines.Data {
+			if line.Proration {
+				preview.ImmediateAmount += line.Amount
+			} else {
+				preview.RecurringAmount += line.Amount
+			}
+		}
+	}
+	// Stripe bills a new interval straight away, first period and all, unless
+	// it waits for a scheduled downgrade
+	if change.interval && change.proration != "" {
+		preview.ImmediateAmount = upcoming.AmountDue
+	}
+	return preview, nil
+}
+
+// monthlyAmount is what a recurring price costs a month, in cents, for
+// comparing prices billed over different intervals
+func monthlyAmount(p *stripe.Price) int64 {
+	if p == nil {
+		return 0
+	}
+	if p.Recurring == nil {
+		return p.UnitAmount
+	}
+	count := p.Recurring.IntervalCount
+	if count < 1 {
+		count = 1
+	}
+	switch p.Recurring.Interval {
+	case stripe.PriceRecurringIntervalYear:
+		return p.UnitAmount / (12 * count)
+	case stripe.PriceRecurringIntervalWeek:
+		return p.UnitAmount * 52 / (12 * count)
+	case stripe.PriceRecurringIntervalDay:
+		return p.UnitAmount * 365 / (12 * count)
+	}
+	return p.UnitAmount / count
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+)
+
+// fakeStripeSubscriptions stands in for Stripe with one subscription on the
+// Professional price, recording the calls that would change it
+type fakeStripeSubscriptions struct {
+	sub      *stripe.Subscription
+	prices   map[string]*stripe.Price
+	promos   map[string]*stripe.PromotionCode
+	upcoming *stripe.Invoice
+
+	created   *stripe.SubscriptionParams
+	previewed *stripe.InvoiceUpcomingParams
+	updated   *stripe.SubscriptionParams
+	canceled  bool
+	scheduled *stripe.SubscriptionScheduleParams
+}
+
+func (f *fakeStripeSubscriptions) CreateSubscription(params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	f.created = params
+	return f.sub, nil
+}
+
+func (f *fakeStripeSubscriptions) GetSubscription(id string) (*stripe.Subscription, error) {
+	return f.sub, nil
+}
+
+func (f *fakeStripeSubscriptions) UpdateSubscription(id string, params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	f.updated = params
+	return f.sub, nil
+}
+
+func (f *fakeStripeSubscriptions) CancelSubscription(id string, params *stripe.SubscriptionCancelParams) (*stripe.Subscription, error) {
+	f.canceled = true
+	f.sub.Status = stripe.SubscriptionStatusCanceled
+	return f.sub, nil
+}
+
+func (f *fakeStripeSubscriptions) GetPrice(id string) (*stripe.Price, error) {
+	return f.prices[id], nil
+}
+
+func (f *fakeStripeSubscriptions) UpcomingInvoice(params *stripe.InvoiceUpcomingParams) (*stripe.Invoice, error) {
+	f.previewed = params
+	return f.upcoming, nil
+}
+
+func (f *fakeStripeSubscriptions) ScheduleSubscription(subscriptionID string) (*stripe.SubscriptionSchedule, error) {
+	return &stripe.SubscriptionSchedule{
+		ID: "sub_sched_1",
+		Phases: []*stripe.SubscriptionSchedulePhase{{
+			StartDate: f.sub.CurrentPeriodStart,
+			EndDate:   f.sub.CurrentPeriodEnd,
+			Items:     []*stripe.SubscriptionSchedulePhaseItem{{Price: f.sub.Items.Data[0].Price, Quantity: 1}},
+		}},
+	}, nil
+}
+
+func (f *fakeStripeSubscriptions) UpdateSchedule(id string, params *stripe.SubscriptionScheduleParams) (*stripe.SubscriptionSchedule, error) {
+	f.scheduled = params
+	return &stripe.SubscriptionSchedule{ID: id}, nil
+}
+
+func (f *fakeStripeSubscriptions) FindPromotionCode(code string) (*stripe.PromotionCode, error) {
+	return f.promos[code], nil
+}
+
+var (
+	testPeriodStart = time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	testPeriodEnd   = time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func monthlyPrice(id string, amount int64) *stripe.Price {
+	return &stripe.Price{
+		ID:         id,
+		LookupKey:  strings.TrimPrefix(id, "price_"),
+		UnitAmount: amount,
+		Currency:   stripe.CurrencyUSD,
+		Product:    &stripe.Product{ID: "prod_" + id},
+		Recurring:  &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth, IntervalCount: 1},
+	}
+}
+
+func newTestSubscriptionService(upcoming ...*stripe.InvoiceLineItem) (*StripeService, *fakeStripeSubscriptions) {
+	professional := monthlyPrice("price_professional_monthly", 2900)
+	api := &fakeStripeSubscriptions{
+		sub: &stripe.Subscription{
+			ID:                 "sub_1",
+			Customer:           &stripe.Customer{ID: "cus_1"},
+			Status:             stripe.SubscriptionStatusActive,
+			CurrentPeriodStart: testPeriodStart.Unix(),
+			CurrentPeriodEnd:   testPeriodEnd.Unix(),
+			Items: &stripe.SubscriptionItemList{Data: []*stripe.SubscriptionItem{
+				{ID: "si_1", Price: professional, Quantity: 1},
+			}},
+		},
+		promos: map[string]*stripe.PromotionCode{},
+		prices: map[string]*stripe.Price{
+			"price_professional_monthly": professional,
+			"price_enterprise_monthly":   monthlyPrice("price_enterprise_monthly", 5900),
+			"price_professional_yearly": {
+				ID:         "price_professional_yearly",
+				LookupKey:  "professional_yearly",
+				UnitAmount: 29000,
+				Recurring:  &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalYear, IntervalCount: 1},
+			},
+		},
+		upcoming: &stripe.Invoice{Currency: stripe.CurrencyUSD, Lines: &stripe.InvoiceLineItemList{Data: upcoming}},
+	}
+	for _, line := range upcoming {
+		api.upcoming.AmountDue += line.Amount
+	}
+
+	s := NewStripeService("")
+	s.subscriptions = api
+	s.now = func() time.Time { return time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC) }
+	return s, api
+}
+
+func TestPreviewPlanChange_Upgrade(t *testing.T) {
+	// Half a month left: $14.50 back for Professional, $29.50 for Enterprise
+	s, api := newTestSubscriptionService(
+		&stripe.InvoiceLineItem{Amount: -1450, Proration: true},
+		&stripe.InvoiceLineItem{Amount: 2950, Proration: true},
+		&stripe.InvoiceLineItem{Amount: 5900},
+	)
+
+	preview, err := s.PreviewPlanChange("sub_1", "price_enterprise_monthly", ProrationAlwaysInvoice)
+
+	require.NoError(t, err)
+	assert.Equal(t, PlanChangePreview{
+		Currency:          "usd",
+		ImmediateAmount:   1500,
+		RecurringAmount:   5900,
+		NextInvoiceAmount: 7400,
+		ProrationBehavior: ProrationAlwaysInvoice,
+		EffectiveAt:       s.now(),
+	}, preview)
+	assert.Equal(t, "si_1", *api.previewed.SubscriptionItems[0].ID)
+	assert.Equal(t, "price_enterprise_monthly", *api.previewed.SubscriptionItems[0].Price)
+	assert.Equal(t, ProrationAlwaysInvoice, *api.previewed.SubscriptionProrationBehavior)
+	assert.Nil(t, api.updated, "a preview changes nothing")
+}
+
+// onEnterprise moves the fake subscription to the Enterprise price, to
+// downgrade from
+func onEnterprise(api *fakeStripeSubscriptions) {
+	api.sub.Items.Data[0].Price = api.prices["price_enterprise_monthly"]
+}
+
+func TestPreviewPlanChange_DowngradeWaitsForPeriodEnd(t *testing.T) {
+	s, api := newTestSubscriptionService(&stripe.InvoiceLineItem{Amount: 2900})
+	onEnterprise(api)
+
+	preview, err := s.PreviewPlanChange("sub_1", "price_professional_monthly", "")
+
+	require.NoError(t, err)
+	assert.True(t, preview.Downgrade)
+	assert.Zero(t, preview.ImmediateAmount)
+	assert.Equal(t, int64(2900), preview.RecurringAmount)
+	assert.Equal(t, testPeriodEnd, preview.EffectiveAt)
+	assert.Equal(t, ProrationNone, *api.previewed.SubscriptionProrationBehavior)
+}
+
+func TestPreviewPlanChange_MonthlyToYearly(t *testing.T) {
+	// Half of October back, and the year charged straight away
+	s, api := newTestSubscriptionService(
+		&stripe.InvoiceLineItem{Amount: -1450, Proration: true},
+		&stripe.InvoiceLineItem{Amount: 29000},
+	)
+
+	preview, err := s.PreviewPlanChange("sub_1", "price_professional_yearly", "")
+
+	require.NoError(t, err)
+	assert.False(t, preview.Downgrade, "paying yearly is cheaper a month but the same plan")
+	assert.True(t, preview.IntervalChange)
+	assert.Equal(t, int64(27550), preview.ImmediateAmount)
+	assert.Equal(t, int64(29000), preview.RecurringAmount)
+	assert.Equal(t, ProrationCreateProrations, *api.previewed.SubscriptionProrationBehavior)
+}
+
+func TestUpdateSubscription_UpgradeDefaultsToProrations(t *testing.T) {
+	s, api := newTestSubscriptionService()
+
+	change, err := s.UpdateSubscription("sub_1", "price_enterprise_monthly", "")
+
+	require.NoError(t, err)
+	assert.False(t, change.Downgrade)
+	assert.Equal(t, ProrationCreateProrations, change.ProrationBehavior)
+	require.NotNil(t, api.updated)
+	assert.Equal(t, ProrationCreateProrations, *api.updated.ProrationBehavior)
+	assert.Nil(t, api.scheduled)
+}
+
+func TestUpdateSubscription_DowngradeIsScheduled(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	onEnterprise(api)
+
+	change, err := s.UpdateSubscription("sub_1", "price_professional_monthly", "")
+
+	require.NoError(t, err)
+	assert.True(t, change.Downgrade)
+	assert.Empty(t, change.ProrationBehavior)
+	assert.Equal(t, testPeriodEnd, change.EffectiveAt)
+	assert.Nil(t, api.updated, "the subscription isn't changed until the period ends")
+
+	require.NotNil(t, api.scheduled)
+	require.Len(t, api.scheduled.Phases, 2)
+	assert.Equal(t, "price_enterprise_monthly", *api.scheduled.Phases[0].Items[0].Price)
+	assert.Equal(t, testPeriodEnd.Unix(), *api.scheduled.Phases[0].EndDate)
+	assert.Equal(t, "price_professional_monthly", *api.scheduled.Phases[1].Items[0].Price)
+}
+
+func TestUpdateSubscription_DowngradeWithProrationIsImmediate(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	onEnterprise(api)
+
+	change, err := s.UpdateSubscription("sub_1", "price_professional_monthly", ProrationNone)
+
+	require.NoError(t, err)
+	assert.True(t, change.Downgrade)
+	assert.Equal(t, s.now(), change.EffectiveAt)
+	require.NotNil(t, api.updated)
+	assert.Nil(t, api.scheduled)
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/price"
+)
+
+// Billing intervals a plan can be paid for
+const (
+	BillingIntervalMonth = "month"
+	BillingIntervalYear  = "year"
+)
+
+// ErrPlanNotAvailable is returned for a plan, or a billing interval of one,
+// that can't be subscribed to
+var ErrPlanNotAvailable = errors.New("plan isn't available with this billing interval")
+
+// ErrPlanPriceMissing is returned for a plan whose Stripe price wasn't
+// found by its lookup key, e.g. before CreatePrices has been run
+var ErrPlanPriceMissing = errors.New("plan has no Stripe price")
+
+// PlanPrice is one way to pay for a plan
+type PlanPrice struct {
+	Interval       string  `json:"interval"` // month or year
+	Amount         int64   `json:"amount"`   // cents each interval
+	PriceID        string  `json:"price_id"`
+	LookupKey      string  `json:"lookup_key"`
+	Available      bool    `json:"available"`       // false when no Stripe price has LookupKey
+	MonthlyAmount  int64   `json:"monthly_amount"`  // cents a month, for comparing intervals
+	Savings        int64   `json:"savings"`         // cents a year saved over paying monthly
+	SavingsPercent float64 `json:"savings_percent"` // of a year paid monthly
+}
+
+// planLookupKey is the Stripe lookup key of tier's price for interval, e.g.
+// professional_yearly
+func planLookupKey(tier SubscriptionTier, interval string) string {
+	if interval == BillingIntervalYear {
+		return string(tier) + "_yearly"
+	}
+	return string(tier) + "_monthly"
+}
+
+// planPrices returns the prices plan can be paid with: monthly, and yearly
+// where it's offered. A free plan has none.
+func planPrices(tier SubscriptionTier, plan SubscriptionPlan) []PlanPrice {
+	if plan.Price <= 0 {
+		return nil
+	}
+	prices := []PlanPrice{{
+		Interval:      BillingIntervalMonth,
+		Amount:        plan.Price,
+		PriceID:       plan.PriceID,
+		LookupKey:     planLookupKey(tier, BillingIntervalMonth),
+		Available:     plan.PriceID != "",
+		MonthlyAmount: plan.Price,
+	}}
+	if plan.AnnualPrice > 0 {
+		savings := plan.Price*12 - plan.AnnualPrice
+		prices = append(prices, PlanPrice{
+			Interval:       BillingIntervalYear,
+			Amount:         plan.AnnualPrice,
+			PriceID:        plan.AnnualPriceID,
+			LookupKey:      planLookupKey(tier, BillingIntervalYear),
+			Available:      plan.AnnualPriceID != "",
+			MonthlyAmount:  int64(math.Round(float64(plan.AnnualPrice) / 12)),
+			Savings:        savings,
+			SavingsPercent: math.Round(float64(savings)/float64(plan.Price*12)*1000) / 10,
+		})
+	}
+	return prices
+}
+
+// PlanLookupKeys returns the lookup keys of every plan's Stripe prices,
+// metered API usage prices included
+func PlanLookupKeys() []string {
+	var keys []string
+	for tier, plan := range subscriptionPlans() {
+		for _, p := range planPrices(tier, plan) {
+			keys = append(keys, p.LookupKey)
+		}
+		for _, p := range apiUsagePrices(tier, plan) {
+			keys = append(keys, p.LookupKey)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// StripePriceLister finds Stripe prices by lookup key. Implementations must
+// be safe for concurrent use.
+type StripePriceLister interface {
+	ListPricesByLookupKey(lookupKeys []string) ([]*stripe.Price, error)
+}
+
+// stripePriceLister calls Stripe with the key set by NewStripeService
+type stripePriceLister struct{}
+
+func (stripePriceLister) ListPricesByLookupKey(lookupKeys []string) ([]*stripe.Price, error) {
+	params := &stripe.PriceListParams{
+		Active:     stripe.Bool(true),
+		LookupKeys: stripe.StringSlice(lookupKeys),
+	}
+	var prices []*stripe.Price
+	iter := price.List(params)
+	for iter.Next() {
+		prices = append(prices, iter.Price())
+	}
+	return prices, iter.Err()
+}
+
+// SetPriceLister replaces the Stripe API prices are found through, e.g.
+// with a stub in tests
+func (s *StripeService) SetPriceLister(lister StripePriceLister) {
+	s.prices = lister
+}
+
+// SetPriceID sets the Stripe price for a plan's lookup key, e.g. from
+// configuration, so RefreshPrices doesn't look it up
+func (s *StripeService) SetPriceID(lookupKey, priceID string) {
+	s.priceMu.Lock()
+	defer s.priceMu.Unlock()
+	if s.configuredPrices == nil {
+		s.configuredPrices = map[string]string{}
+	}
+	s.configuredPrices[lookupKey] = priceID
+	if s.priceIDs == nil {
+		s.priceIDs = map[string]string{}
+	}
+	s.priceIDs[lookupKey] = priceID
+}
+
+// RefreshPrices finds the Stripe price of each plan by its lookup key and
+// keeps them for GetSubscriptionPlans and PriceFor. It returns the lookup
+// keys no active price has.
+func (s *StripeService) RefreshPrices() ([]string, error) {
+	resolved := map[string]string{}
+	s.priceMu.RLock()
+	for key, id := range s.configuredPrices {
+		resolved[key] = id
+	}
+	s.priceMu.RUnlock()
+
+	var lookup []string
+	for _, key := range PlanLookupKeys() {
+		if _, ok := resolved[key]; !ok {
+			lookup = append(lookup, key)
+		}
+	}
+	if len(lookup) > 0 {
+		prices, err := s.prices.ListPricesByLookupKey(lookup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prices: %w", err)
+		}
+		for _, p := range prices {
+			if p.LookupKey != "" {
+				resolved[p.LookupKey] = p.ID
+			}
+		}
+	}
+
+	var missing []string
+	for _, key := range PlanLookupKeys() {
+		if resolved[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+
+	s.priceMu.Lock()
+	s.priceIDs = resolved
+	s.priceMu.Unlock()
+	return missing, nil
+}
+
+// priceID returns the Stripe price found for lookupKey, or "" when there
+// isn't one
+func (s *StripeService) priceID(lookupKey string) string {
+	s.priceMu.RLock()
+	defer s.priceMu.RUnlock()
+	return s.priceIDs[lookupKey]
+}
+
+// priceTier returns the plan priceID is one of the prices of, or "" when it
+// isn't one
+func (s *StripeService) priceTier(priceID string) SubscriptionTier {
+	s.priceMu.RLock()
+	defer s.priceMu.RUnlock()
+	for key, id := range s.priceIDs {
+		if id == priceID {
+			return tierForPrice(&stripe.Price{ID: id, LookupKey: key})
+		}
+	}
+	return ""
+}
+
+// PriceFor returns the Stripe price to subscribe to tier billed every
+// interval, month when it's empty. It returns ErrPlanPriceMissing when the
+// price wasn't found in Stripe.
+func (s *StripeService) PriceFor(tier SubscriptionTier, interval string) (string, error) {
+	if interval == "" {
+		interval = BillingIntervalMonth
+	}
+	plan, ok := s.GetSubscriptionPlans()[tier]
+	if !ok {
+		return "", ErrPlanNotAvailable
+	}
+	for _, p := range plan.Prices {
+		if p.Interval != interval {
+			continue
+		}
+		if !p.Available {
+			return "", ErrPlanPriceMissing
+		}
+		return p.PriceID, nil
+	}
+	return "", ErrPlanNotAvailable
+}
+
+// tierRank orders the plans from cheapest to dearest
+func tierRank(tier SubscriptionTier) int {
+	switch tier {
+	case TierProfessional:
+		return 1
+	case TierEnterprise:
+		return 2
+	}
+	return 0
+}
+
+// isDowngrade reports whether moving from one price to another is a
+// downgrade. Between plans it's the plan that counts, so paying yearly for
+// the same plan isn't a downgrade though it costs less a month; other
+// prices are compared by what they cost a month.
+func isDowngrade(from, to *stripe.Price) bool {
+	fromTier, toTier := tierForPrice(from), tierForPrice(to)
+	if fromTier != "" && toTier != "" {
+		return tierRank(toTier) < tierRank(fromTier)
+	}
+	return monthlyAmount(to) < monthlyAmount(from)
+}
+
+// isIntervalChange reports whether moving between the prices changes how
+// often the subscription is billed, which Stripe invoices right away
+func isIntervalChange(from, to *stripe.Price) bool {
+	if from == nil || to == nil || from.Recurring == nil || to.Recurring == nil {
+		return false
+	}
+	return from.Recurring.Interval != to.Recurring.Interval ||
+		from.Recurring.IntervalCount != to.Recurring.IntervalCount
+}
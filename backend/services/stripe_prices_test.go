@@ -0,0 +1,141 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+)
+
+// stubPriceLister has a price for each plan lookup key but the missing ones
+type stubPriceLister struct {
+	missing map[string]bool
+	listed  [][]string
+}
+
+func (l *stubPriceLister) ListPricesByLookupKey(lookupKeys []string) ([]*stripe.Price, error) {
+	l.listed = append(l.listed, lookupKeys)
+	var prices []*stripe.Price
+	for _, key := range lookupKeys {
+		if !l.missing[key] {
+			prices = append(prices, &stripe.Price{ID: "price_" + key, LookupKey: key})
+		}
+	}
+	return prices, nil
+}
+
+// newTestPricedService returns a service that's found every plan's price
+// but the missing ones
+func newTestPricedService(t *testing.T, missing ...string) (*StripeService, *stubPriceLister) {
+	lister := &stubPriceLister{missing: map[string]bool{}}
+	for _, key := range missing {
+		lister.missing[key] = true
+	}
+	s := NewStripeService("")
+	s.SetPriceLister(lister)
+	_, err := s.RefreshPrices()
+	require.NoError(t, err)
+	return s, lister
+}
+
+func TestPriceFor(t *testing.T) {
+	s, _ := newTestPricedService(t, "enterprise_monthly")
+
+	tests := []struct {
+		tier     SubscriptionTier
+		interval string
+		want     string
+		err      error
+	}{
+		{TierProfessional, "", "price_professional_monthly", nil},
+		{TierProfessional, BillingIntervalMonth, "price_professional_monthly", nil},
+		{TierProfessional, BillingIntervalYear, "price_professional_yearly", nil},
+		{TierEnterprise, BillingIntervalYear, "price_enterprise_yearly", nil},
+		{TierEnterprise, BillingIntervalMonth, "", ErrPlanPriceMissing},
+		{TierStarter, BillingIntervalMonth, "", ErrPlanNotAvailable},
+		{TierProfessional, "week", "", ErrPlanNotAvailable},
+		{"platinum", BillingIntervalMonth, "", ErrPlanNotAvailable},
+	}
+	for _, tt := range tests {
+		priceID, err := s.PriceFor(tt.tier, tt.interval)
+		assert.ErrorIs(t, err, tt.err, "%s %s", tt.tier, tt.interval)
+		assert.Equal(t, tt.want, priceID, "%s %s", tt.tier, tt.interval)
+	}
+}
+
+func TestGetSubscriptionPlans_MonthlyAndYearlyPrices(t *testing.T) {
+	s, _ := newTestPricedService(t)
+	plans := s.GetSubscriptionPlans()
+
+	assert.Empty(t, plans[TierStarter].Prices, "the free plan has nothing to pay")
+
+	prices := plans[TierProfessional].Prices
+	require.Len(t, prices, 2)
+	assert.Equal(t, PlanPrice{
+		Interval:      BillingIntervalMonth,
+		Amount:        2900,
+		PriceID:       "price_professional_monthly",
+		LookupKey:     "professional_monthly",
+		Available:     true,
+		MonthlyAmount: 2900,
+	}, prices[0])
+	// Two months free: $348 paid monthly against $290 up front
+	assert.Equal(t, PlanPrice{
+		Interval:       BillingIntervalYear,
+		Amount:         29000,
+		PriceID:        "price_professional_yearly",
+		LookupKey:      "professional_yearly",
+		Available:      true,
+		MonthlyAmount:  2417,
+		Savings:        5800,
+		SavingsPercent: 16.7,
+	}, prices[1])
+}
+
+func TestRefreshPrices_FlagsMissingPrices(t *testing.T) {
+	s, lister := newTestPricedService(t, "professional_yearly")
+
+	require.Len(t, lister.listed, 1)
+	assert.Equal(t, PlanLookupKeys(), lister.listed[0])
+
+	plan := s.GetSubscriptionPlans()[TierProfessional]
+	assert.Equal(t, "price_professional_monthly", plan.PriceID)
+	assert.Empty(t, plan.AnnualPriceID, "no made-up ID for a missing price")
+	require.Len(t, plan.Prices, 2)
+	assert.True(t, plan.Prices[0].Available)
+	assert.False(t, plan.Prices[1].Available)
+	assert.Empty(t, plan.Prices[1].PriceID)
+
+	// Once it's created a refresh picks it up
+	delete(lister.missing, "professional_yearly")
+	missing, err := s.RefreshPrices()
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+	assert.Equal(t, "price_professional_yearly", s.GetSubscriptionPlans()[TierProfessional].AnnualPriceID)
+}
+
+func TestRefreshPrices_ConfiguredPriceIsntLookedUp(t *testing.T) {
+	lister := &stubPriceLister{missing: map[string]bool{}}
+	s := NewStripeService("")
+	s.SetPriceLister(lister)
+	s.SetPriceID("enterprise_monthly", "price_configured")
+
+	missing, err := s.RefreshPrices()
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+	require.Len(t, lister.listed, 1)
+	assert.NotContains(t, lister.listed[0], "enterprise_monthly")
+
+	priceID, err := s.PriceFor(TierEnterprise, BillingIntervalMonth)
+	require.NoError(t, err)
+	assert.Equal(t, "price_configured", priceID)
+	assert.Equal(t, 0, s.TrialDays("price_configured"))
+	assert.Equal(t, 14, s.TrialDays("price_professional_monthly"))
+}
+
+func TestTierForPrice_Yearly(t *testing.T) {
+	assert.Equal(t, TierEnterprise, tierForPrice(&stripe.Price{ID: "price_1", LookupKey: "enterprise_yearly"}))
+	assert.Equal(t, TierProfessional, tierForPrice(&stripe.Price{ID: "price_2", Metadata: map[string]string{"tier": "professional"}}))
+	assert.Equal(t, SubscriptionTier(""), tierForPrice(&stripe.Price{ID: "price_professional_yearly"}))
+}
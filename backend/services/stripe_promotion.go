@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"math"
+
+	"github.com/stripe/stripe-go/v79"
+)
+
+var (
+	// ErrPromotionCodeInvalid is returned for a promotion code Stripe doesn't know
+	ErrPromotionCodeInvalid = errors.New("promotion code not found")
+	// ErrPromotionCodeExpired is returned for a promotion code that's been
+	// switched off, has expired or has been redeemed as often as it can be
+	ErrPromotionCodeExpired = errors.New("promotion code has expired")
+	// ErrPromotionCodeNotApplicable is returned for a promotion code that's
+	// valid but not for this price or customer
+	ErrPromotionCodeNotApplicable = errors.New("promotion code doesn't apply to this plan")
+)
+
+// SubscriptionDiscount is a promotion code checked against the price it's
+// for, and what it takes off the first invoice
+type SubscriptionDiscount struct {
+	PromotionCodeID    string  `json:"-"`
+	Code               string  `json:"code"`
+	PercentOff         float64 `json:"percent_off,omitempty"`
+	AmountOff          int64   `json:"amount_off,omitempty"` // cents
+	Duration           string  `json:"duration"`             // once, repeating or forever
+	DurationInMonths   int64   `json:"duration_in_months,omitempty"`
+	FirstInvoiceAmount int64   `json:"first_invoice_amount"` // cents, before tax
+}
+
+// ResolvePromotionCode looks up the promotion code a customer typed in and
+// checks it can be used on a subscription to priceID by customerID. It
+// returns ErrPromotionCodeInvalid, ErrPromotionCodeExpired or
+// ErrPromotionCodeNotApplicable when it can't.
+func (s *StripeService) ResolvePromotionCode(code, priceID, customerID string) (*SubscriptionDiscount, error) {
+	promo, err := s.subscriptions.FindPromotionCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if promo == nil {
+		return nil, ErrPromotionCodeInvalid
+	}
+
+	coupon := promo.Coupon
+	switch {
+	case !promo.Active,
+		promo.ExpiresAt > 0 && s.now().Unix() >= promo.ExpiresAt,
+		promo.MaxRedemptions > 0 && promo.TimesRedeemed >= promo.MaxRedemptions,
+		coupon == nil || !coupon.Valid:
+		return nil, ErrPromotionCodeExpired
+	}
+	if promo.Customer != nil && promo.Customer.ID != customerID {
+		return nil, ErrPromotionCodeNotApplicable
+	}
+
+	p, err := s.subscriptions.GetPrice(priceID)
+	if err != nil {
+		return nil, err
+	}
+	if !couponAppliesTo(coupon, p) {
+		return nil, ErrPromotionCodeNotApplicable
+	}
+	if r := promo.Restrictions; r != nil && r.MinimumAmount > 0 && p.UnitAmount < r.MinimumAmount {
+		return nil, ErrPromotionCodeNotApplicable
+	}
+
+	return &SubscriptionDiscount{
+		PromotionCodeID:    promo.ID,
+		Code:               promo.Code,
+		PercentOff:         coupon.PercentOff,
+		AmountOff:          coupon.AmountOff,
+		Duration:           string(coupon.Duration),
+		DurationInMonths:   coupon.DurationInMonths,
+		FirstInvoiceAmount: discountedAmount(p.UnitAmount, coupon),
+	}, nil
+}
+
+// couponAppliesTo reports whether coupon can discount p: it's limited to
+// none or p's product, and an amount off is in p's currency
+func couponAppliesTo(coupon *stripe.Coupon, p *stripe.Price) bool {
+	if coupon.AmountOff > 0 && coupon.Currency != p.Currency {
+		return false
+	}
+	if coupon.AppliesTo == nil || len(coupon.AppliesTo.Products) == 0 {
+		return true
+	}
+	if p.Product == nil {
+		return false
+	}
+	for _, product := range coupon.AppliesTo.Products {
+		if product == p.Product.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// discountedAmount is amount, in cents, with coupon taken off. It's never
+// below zero.
+func discountedAmount(amount int64, coupon *stripe.Coupon) int64 {
+	if coupon.PercentOff > 0 {
+		amount -= int64(math.Round(float64(amount) * coupon.PercentOff / 100))
+	}
+	amount -= coupon.AmountOff
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
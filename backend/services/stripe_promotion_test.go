@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+)
+
+func TestResolvePromotionCode_PercentOff(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	api.promos["FIRSTMONTH50"] = &stripe.PromotionCode{
+		ID:     "promo_1",
+		Code:   "FIRSTMONTH50",
+		Active: true,
+		Coupon: &stripe.Coupon{Valid: true, PercentOff: 50, Duration: stripe.CouponDurationOnce},
+	}
+
+	discount, err := s.ResolvePromotionCode("FIRSTMONTH50", "price_professional_monthly", "cus_1")
+
+	require.NoError(t, err)
+	assert.Equal(t, &SubscriptionDiscount{
+		PromotionCodeID:    "promo_1",
+		Code:               "FIRSTMONTH50",
+		PercentOff:         50,
+		Duration:           "once",
+		FirstInvoiceAmount: 1450,
+	}, discount)
+
+	_, err = s.CreateSubscription("cus_1", "price_professional_monthly", discount, 0, "")
+	require.NoError(t, err)
+	require.Len(t, api.created.Discounts, 1)
+	assert.Equal(t, "promo_1", *api.created.Discounts[0].PromotionCode)
+}
+
+func TestResolvePromotionCode_AmountOff(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	api.promos["TENOFF"] = &stripe.PromotionCode{
+		ID:     "promo_2",
+		Code:   "TENOFF",
+		Active: true,
+		Coupon: &stripe.Coupon{
+			Valid:            true,
+			AmountOff:        1000,
+			Currency:         stripe.CurrencyUSD,
+			Duration:         stripe.CouponDurationRepeating,
+			DurationInMonths: 3,
+			AppliesTo:        &stripe.CouponAppliesTo{Products: []string{"prod_price_professional_monthly"}},
+		},
+	}
+
+	discount, err := s.ResolvePromotionCode("TENOFF", "price_professional_monthly", "cus_1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), discount.AmountOff)
+	assert.Equal(t, int64(1900), discount.FirstInvoiceAmount)
+
+	// It's only for Professional
+	_, err = s.ResolvePromotionCode("TENOFF", "price_enterprise_monthly", "cus_1")
+	assert.ErrorIs(t, err, ErrPromotionCodeNotApplicable)
+}
+
+func TestResolvePromotionCode_Expired(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	api.promos["SUMMER25"] = &stripe.PromotionCode{
+		ID:        "promo_3",
+		Code:      "SUMMER25",
+		Active:    true,
+		ExpiresAt: time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		Coupon:    &stripe.Coupon{Valid: true, PercentOff: 25, Duration: stripe.CouponDurationOnce},
+	}
+
+	_, err := s.ResolvePromotionCode("SUMMER25", "price_professional_monthly", "cus_1")
+	assert.ErrorIs(t, err, ErrPromotionCodeExpired)
+
+	_, err = s.ResolvePromotionCode("NOSUCHCODE", "price_professional_monthly", "cus_1")
+	assert.ErrorIs(t, err, ErrPromotionCodeInvalid)
+}
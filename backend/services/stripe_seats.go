@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/stripe/stripe-go/v79"
+)
+
+var (
+	// ErrSeatsBelowMembers is returned for fewer seats than active members
+	ErrSeatsBelowMembers = errors.New("fewer seats than active members")
+	// ErrNotSeatBilled is returned when changing seats on a plan that isn't
+	// billed per seat
+	ErrNotSeatBilled = errors.New("plan isn't billed per seat")
+)
+
+// seatBilled reports whether tier is billed per seat
+func seatBilled(tier SubscriptionTier) bool {
+	return tier == TierEnterprise
+}
+
+// UpdateSeats sets the number of seats, the quantity, a subscription bills
+// for. Adding seats defaults to create_prorations; removing them to none,
+// so the seats already paid for last until the period ends.
+func (s *StripeService) UpdateSeats(subscriptionID string, seats int64, prorationBehavior string) (*stripe.Subscription, error) {
+	change, err := s.seatChange(subscriptionID, seats, prorationBehavior)
+	if err != nil {
+		return nil, err
+	}
+	return s.subscriptions.UpdateSubscription(subscriptionID, &stripe.SubscriptionParams{
+		Items:             []*stripe.SubscriptionItemsParams{change.item},
+		ProrationBehavior: stripe.String(change.proration),
+	})
+}
+
+// PreviewSeats shows what changing a subscription's seats would charge now
+// and each period after, without changing them
+func (s *StripeService) PreviewSeats(subscriptionID string, seats int64, prorationBehavior string) (PlanChangePreview, error) {
+	change, err := s.seatChange(subscriptionID, seats, prorationBehavior)
+	if err != nil {
+		return PlanChangePreview{}, err
+	}
+
+	upcoming, err := s.subscriptions.UpcomingInvoice(&stripe.InvoiceUpcomingParams{
+		Customer:                      stripe.String(customerID(change.sub.Customer)),
+		Subscription:                  stripe.String(subscriptionID),
+		SubscriptionItems:             []*stripe.SubscriptionItemsParams{change.item},
+		SubscriptionProrationBehavior: stripe.String(change.proration),
+		SubscriptionProrationDate:     stripe.Int64(s.now().Unix()),
+	})
+	if err != nil {
+		return PlanChangePreview{}, fmt.Errorf("failed to preview invoice: %w", err)
+	}
+
+	preview := PlanChangePreview{
+		Currency:          string(upcoming.Currency),
+		NextInvoiceAmount: upcoming.AmountDue,
+		Tax:               upcoming.Tax,
+		Downgrade:         change.removing,
+		ProrationBehavior: change.proration,
+		EffectiveAt:       s.now(),
+	}
+	if upcoming.Lines != nil {
+		for _, line := range upcoming.Lines.Data {
+			if line.Proration {
+				preview.ImmediateAmount += line.Amount
+			} else {
+				preview.RecurringAmount += line.Amount
+			}
+		}
+	}
+	return preview, nil
+}
+
+// seatChangeParams is a seat change worked out but not yet made
+type seatChangeParams struct {
+	sub       *stripe.Subscription
+	item      *stripe.SubscriptionItemsParams
+	removing  bool
+	proration string
+}
+
+func (s *StripeService) seatChange(subscriptionID string, seats int64, prorationBehavior string) (seatChangeParams, error) {
+	sub, err := s.subscriptions.GetSubscription(subscriptionID)
+	if err != nil {
+		return seatChangeParams{}, err
+	}
+	item := planItem(sub)
+	if item == nil {
+		return seatChangeParams{}, ErrSubscriptionHasNoItems
+	}
+
+	change := seatChangeParams{
+		sub:       sub,
+		item:      &stripe.SubscriptionItemsParams{ID: stripe.String(item.ID), Quantity: stripe.Int64(seats)},
+		removing:  seats < item.Quantity,
+		proration: prorationBehavior,
+	}
+	if change.proration == "" {
+		change.proration = ProrationCreateProrations
+		if change.removing {
+			change.proration = ProrationNone
+		}
+	}
+	return change, nil
+}
+
+// SeatManager keeps an Enterprise tenant's seats, what its subscription
+// bills for, in step with its active members. Members only join a tenant
+// through registration, which always creates a new one, so there's nothing
+// yet to check a joining member against the seats bought.
+type SeatManager struct {
+	billing *BillingRepository
+	stripe  *StripeService
+}
+
+// NewSeatManager creates a new seat manager
+func NewSeatManager(billing *BillingRepository, stripe *StripeService) *SeatManager {
+	return &SeatManager{billing: billing, stripe: stripe}
+}
+
+// ReleaseSeat gives back the seat of a member who's left tenantID. The
+// seat's paid for until the period ends, so it's billed for no longer than
+// that.
+func (m *SeatManager) ReleaseSeat(tenantID string) error {
+	sub, err := m.billing.Subscription(tenantID)
+	if err != nil {
+		return err
+	}
+	if sub == nil || !seatBilled(sub.Tier) || sub.Seats <= 1 || sub.Seats <= sub.SeatsUsed {
+		return nil
+	}
+
+	seats := int64(sub.Seats - 1)
+	if _, err := m.stripe.UpdateSeats(sub.SubscriptionID, seats, ProrationNone); err != nil {
+		return fmt.Errorf("failed to release a seat: %w", err)
+	}
+	return m.billing.SaveSeats(tenantID, seats)
+}
+
+// ChangeSeats sets how many seats tenantID pays for. There must be at least
+// one for each active member.
+func (m *SeatManager) ChangeSeats(tenantID string, seats int64, prorationBehavior string) (*BillingSubscription, error) {
+	sub, err := m.seatSubscription(tenantID, seats)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.stripe.UpdateSeats(sub.SubscriptionID, seats, prorationBehavior); err != nil {
+		return nil, err
+	}
+	if err := m.billing.SaveSeats(tenantID, seats); err != nil {
+		return nil, err
+	}
+	sub.Seats = int(seats)
+	return sub, nil
+}
+
+// PreviewSeats shows what ChangeSeats would charge, without changing them
+func (m *SeatManager) PreviewSeats(tenantID string, seats int64, prorationBehavior string) (PlanChangePreview, error) {
+	sub, err := m.seatSubscription(tenantID, seats)
+	if err != nil {
+		return PlanChangePreview{}, err
+	}
+	return m.stripe.PreviewSeats(sub.SubscriptionID, seats, prorationBehavior)
+}
+
+// seatSubscription loads tenantID's subscription to change to seats
+func (m *SeatManager) seatSubscription(tenantID string, seats int64) (*BillingSubscription, error) {
+	sub, err := m.billing.Subscription(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil || sub.SubscriptionID == "" || !seatBilled(sub.Tier) {
+		return nil, ErrNotSeatBilled
+	}
+	if seats < 1 || seats < int64(sub.SeatsUsed) {
+		return nil, ErrSeatsBelowMembers
+	}
+	return sub, nil
+}
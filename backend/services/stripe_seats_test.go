@@ -0,0 +1,55 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSeatManager manages the fake subscription's seats for an
+// Enterprise tenant-1 with purchased seats and used of them taken
+func newTestSeatManager(t *testing.T, purchased, used int) (*SeatManager, *fakeStripeSubscriptions, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	s, api := newTestSubscriptionService()
+	onEnterprise(api)
+	api.sub.Items.Data[0].Quantity = int64(purchased)
+	mock.ExpectQuery(`SELECT stripe_subscription_id, tier, status, .*, seats,`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_subscription_id", "tier", "status", "current_period_end", "trial_end", "cancel_at", "grace_period_ends_at", "seats", "seats_used"}).
+			AddRow("sub_1", "enterprise", "active", testPeriodEnd, nil, nil, nil, purchased, used))
+	return NewSeatManager(NewBillingRepository(db, LogEmailSender{}), s), api, mock
+}
+
+func expectSeatsSaved(mock sqlmock.Sqlmock, seats int64) {
+	mock.ExpectExec(`UPDATE subscriptions SET seats = \$2`).
+		WithArgs("tenant-1", seats).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestReleaseSeat_KeepsPaidSeatUntilPeriodEnd(t *testing.T) {
+	// A member's just been deactivated, leaving 2 of 3 seats used
+	seats, api, mock := newTestSeatManager(t, 3, 2)
+	expectSeatsSaved(mock, 2)
+
+	require.NoError(t, seats.ReleaseSeat("tenant-1"))
+
+	require.NotNil(t, api.updated)
+	assert.Equal(t, int64(2), *api.updated.Items[0].Quantity)
+	assert.Equal(t, ProrationNone, *api.updated.ProrationBehavior)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangeSeats_NotBelowMembers(t *testing.T) {
+	seats, api, mock := newTestSeatManager(t, 5, 4)
+
+	_, err := seats.ChangeSeats("tenant-1", 3, "")
+
+	assert.ErrorIs(t, err, ErrSeatsBelowMembers)
+	assert.Nil(t, api.updated)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
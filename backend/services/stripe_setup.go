@@ -0,0 +1,202 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/price"
+	"github.com/stripe/stripe-go/v79/product"
+)
+
+// StripeCatalogAPI is the part of Stripe's product and price APIs setting
+// up the plans needs. Implementations must be safe for concurrent use.
+type StripeCatalogAPI interface {
+	// ListProducts returns the active products
+	ListProducts() ([]*stripe.Product, error)
+	CreateProduct(params *stripe.ProductParams) (*stripe.Product, error)
+	CreatePrice(params *stripe.PriceParams) (*stripe.Price, error)
+}
+
+// stripeCatalogAPI calls Stripe with the key set by NewStripeService
+type stripeCatalogAPI struct{}
+
+func (stripeCatalogAPI) ListProducts() ([]*stripe.Product, error) {
+	var products []*stripe.Product
+	iter := product.List(&stripe.ProductListParams{Active: stripe.Bool(true)})
+	for iter.Next() {
+		products = append(products, iter.Product())
+	}
+	return products, iter.Err()
+}
+
+func (stripeCatalogAPI) CreateProduct(params *stripe.ProductParams) (*stripe.Product, error) {
+	return product.New(params)
+}
+
+func (stripeCatalogAPI) CreatePrice(params *stripe.PriceParams) (*stripe.Price, error) {
+	return price.New(params)
+}
+
+// SetCatalogAPI replaces the Stripe API plans' products and prices are set
+// up through, e.g. with a stub in tests
+func (s *StripeService) SetCatalogAPI(api StripeCatalogAPI) {
+	s.catalog = api
+}
+
+// CatalogObject is a Stripe product or price a plan is sold as
+type CatalogObject struct {
+	Type string           `json:"type"` // product or price
+	Tier SubscriptionTier `json:"tier"`
+	Name string           `json:"name"`         // a product's name, a price's lookup key
+	ID   string           `json:"id,omitempty"` // empty for one a dry run would create
+}
+
+// PriceSetup is what CreatePrices found already in Stripe and what it
+// created, or on a dry run would create
+type PriceSetup struct {
+	DryRun   bool            `json:"dry_run"`
+	Created  []CatalogObject `json:"created"`
+	Existing []CatalogObject `json:"existing"`
+}
+
+// planProducts are the products the paid plans are sold as
+var planProducts = []struct {
+	tier        SubscriptionTier
+	name        string
+	description string
+}{
+	{TierProfessional, "ArvFinder Professional", "Professional plan with unlimited ARV calculations and advanced features"},
+	{TierEnterprise, "ArvFinder Enterprise", "Enterprise plan with API access, white-label reports, and team features"},
+}
+
+// CreatePrices sets up the plans in Stripe: each paid plan gets a product
+// with a monthly and a yearly price, found again by their lookup keys, and
+// a plan with API access a metered price per 1,000 calls for each. It
+// can be run again safely, creating only what's missing; a product is
+// recognized by the tier in its metadata or its name, a price by its lookup
+// key. With dryRun nothing is created, and the result says what would be.
+func (s *StripeService) CreatePrices(dryRun bool) (PriceSetup, error) {
+	setup := PriceSetup{DryRun: dryRun}
+
+	prices, err := s.prices.ListPricesByLookupKey(PlanLookupKeys())
+	if err != nil {
+		return setup, fmt.Errorf("failed to list prices: %w", err)
+	}
+	existingPrices := map[string]*stripe.Price{}
+	for _, p := range prices {
+		existingPrices[p.LookupKey] = p
+	}
+	products, err := s.catalog.ListProducts()
+	if err != nil {
+		return setup, fmt.Errorf("failed to list products: %w", err)
+	}
+
+	plans := s.GetSubscriptionPlans()
+	for _, p := range planProducts {
+		plan, exists := plans[p.tier]
+		if !exists || plan.Price <= 0 {
+			continue
+		}
+
+		usagePrices := apiUsagePrices(p.tier, plan)
+		metered := map[string]bool{}
+		for _, usagePrice := range usagePrices {
+			metered[usagePrice.LookupKey] = true
+		}
+
+		var missing []PlanPrice
+		productID := ""
+		for _, planPrice := range append(append([]PlanPrice{}, plan.Prices...), usagePrices...) {
+			existing, ok := existingPrices[planPrice.LookupKey]
+			if !ok {
+				missing = append(missing, planPrice)
+				continue
+			}
+			setup.Existing = append(setup.Existing, CatalogObject{Type: "price", Tier: p.tier, Name: planPrice.LookupKey, ID: existing.ID})
+			if existing.Product != nil && productID == "" {
+				productID = existing.Product.ID
+			}
+		}
+
+		prod := planProduct(products, p.tier, p.name)
+		switch {
+		case prod != nil:
+			productID = prod.ID
+			setup.Existing = append(setup.Existing, CatalogObject{Type: "product", Tier: p.tier, Name: prod.Name, ID: prod.ID})
+		case productID != "":
+			// The prices are on a product named otherwise; it's kept
+		case dryRun:
+			setup.Created = append(setup.Created, CatalogObject{Type: "product", Tier: p.tier, Name: p.name})
+		default:
+			params := &stripe.ProductParams{
+				Name:        stripe.String(p.name),
+				Description: stripe.String(p.description),
+			}
+			params.AddMetadata("tier", string(p.tier))
+			created, err := s.catalog.CreateProduct(params)
+			if err != nil {
+				log.Printf("Error creating %s product: %v", p.tier, err)
+				return setup, err
+			}
+			productID = created.ID
+			setup.Created = append(setup.Created, CatalogObject{Type: "product", Tier: p.tier, Name: created.Name, ID: created.ID})
+		}
+
+		for _, planPrice := range missing {
+			if dryRun {
+				setup.Created = append(setup.Created, CatalogObject{Type: "price", Tier: p.tier, Name: planPrice.LookupKey})
+				continue
+			}
+			params := &stripe.PriceParams{
+				UnitAmount: stripe.Int64(planPrice.Amount),
+				Currency:   stripe.String("usd"),
+				Recurring: &stripe.PriceRecurringParams{
+					Interval: stripe.String(planPrice.Interval),
+				},
+				Product:           stripe.String(productID),
+				LookupKey:         stripe.String(planPrice.LookupKey),
+				TransferLookupKey: stripe.Bool(true),
+			}
+			if metered[planPrice.LookupKey] {
+				// Billed on the calls reported each period, rounded up to the 1,000
+				params.Recurring.UsageType = stripe.String(string(stripe.PriceRecurringUsageTypeMetered))
+				params.Recurring.AggregateUsage = stripe.String(string(stripe.PriceRecurringAggregateUsageSum))
+				params.TransformQuantity = &stripe.PriceTransformQuantityParams{
+					DivideBy: stripe.Int64(1000),
+					Round:    stripe.String(string(stripe.PriceTransformQuantityRoundUp)),
+				}
+			}
+			params.AddMetadata("tier", string(p.tier))
+			created, err := s.catalog.CreatePrice(params)
+			if err != nil {
+				log.Printf("Error creating %s price: %v", planPrice.LookupKey, err)
+				return setup, err
+			}
+			log.Printf("Created %s price: %s", planPrice.LookupKey, created.ID)
+			setup.Created = append(setup.Created, CatalogObject{Type: "price", Tier: p.tier, Name: planPrice.LookupKey, ID: created.ID})
+		}
+	}
+
+	if dryRun || len(setup.Created) == 0 {
+		return setup, nil
+	}
+	_, err = s.RefreshPrices()
+	return setup, err
+}
+
+// planProduct finds tier's product among products, by the tier in its
+// metadata or else by name
+func planProduct(products []*stripe.Product, tier SubscriptionTier, name string) *stripe.Product {
+	for _, p := range products {
+		if p.Metadata["tier"] == string(tier) {
+			return p
+		}
+	}
+	for _, p := range products {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+)
+
+// fakeStripeCatalog keeps the products and prices created, and lists them
+// back like Stripe
+type fakeStripeCatalog struct {
+	products []*stripe.Product
+	prices   []*stripe.Price
+}
+
+func (f *fakeStripeCatalog) ListProducts() ([]*stripe.Product, error) {
+	return f.products, nil
+}
+
+func (f *fakeStripeCatalog) CreateProduct(params *stripe.ProductParams) (*stripe.Product, error) {
+	prod := &stripe.Product{ID: fmt.Sprintf("prod_%d", len(f.products)+1), Name: *params.Name, Metadata: params.Metadata}
+	f.products = append(f.products, prod)
+	return prod, nil
+}
+
+func (f *fakeStripeCatalog) CreatePrice(params *stripe.PriceParams) (*stripe.Price, error) {
+	p := &stripe.Price{
+		ID:        fmt.Sprintf("price_%d", len(f.prices)+1),
+		LookupKey: *params.LookupKey,
+		Product:   &stripe.Product{ID: *params.Product},
+	}
+	if params.Recurring != nil && params.Recurring.UsageType != nil {
+		p.Recurring = &stripe.PriceRecurring{UsageType: stripe.PriceRecurringUsageType(*params.Recurring.UsageType)}
+	}
+	f.prices = append(f.prices, p)
+	return p, nil
+}
+
+func (f *fakeStripeCatalog) ListPricesByLookupKey(lookupKeys []string) ([]*stripe.Price, error) {
+	var prices []*stripe.Price
+	for _, p := range f.prices {
+		for _, key := range lookupKeys {
+			if p.LookupKey == key {
+				prices = append(prices, p)
+			}
+		}
+	}
+	return prices, nil
+}
+
+func newTestCatalogService() (*StripeService, *fakeStripeCatalog) {
+	catalog := &fakeStripeCatalog{}
+	s := NewStripeService("")
+	s.SetCatalogAPI(catalog)
+	s.SetPriceLister(catalog)
+	return s, catalog
+}
+
+func TestCreatePrices_SecondRunCreatesNothing(t *testing.T) {
+	s, catalog := newTestCatalogService()
+
+	setup, err := s.CreatePrices(false)
+	require.NoError(t, err)
+	assert.Len(t, setup.Created, 8, "two products with a monthly and a yearly price each, and Enterprise's API usage prices")
+	assert.Empty(t, setup.Existing)
+	require.Len(t, catalog.prices, 6)
+	assert.Equal(t, "prod_1", catalog.prices[0].Product.ID)
+	assert.Equal(t, "professional", catalog.products[0].Metadata["tier"])
+
+	// The prices created are offered straight away
+	priceID, err := s.PriceFor(TierEnterprise, BillingIntervalYear)
+	require.NoError(t, err)
+	assert.Equal(t, "price_4", priceID)
+
+	setup, err = s.CreatePrices(false)
+	require.NoError(t, err)
+	assert.Empty(t, setup.Created)
+	assert.Len(t, setup.Existing, 8)
+	assert.Len(t, catalog.products, 2)
+	assert.Len(t, catalog.prices, 6)
+}
+
+func TestCreatePrices_CreatesOnlyWhatsMissing(t *testing.T) {
+	s, catalog := newTestCatalogService()
+	// Made in the dashboard before the metadata was set
+	catalog.products = []*stripe.Product{{ID: "prod_existing", Name: "ArvFinder Professional"}}
+	catalog.prices = []*stripe.Price{{ID: "price_existing", LookupKey: "professional_monthly", Product: &stripe.Product{ID: "prod_existing"}}}
+
+	setup, err := s.CreatePrices(false)
+	require.NoError(t, err)
+
+	assert.Equal(t, []CatalogObject{
+		{Type: "price", Tier: TierProfessional, Name: "professional_monthly", ID: "price_existing"},
+		{Type: "product", Tier: TierProfessional, Name: "ArvFinder Professional", ID: "prod_existing"},
+	}, setup.Existing)
+	require.Len(t, setup.Created, 6)
+	assert.Equal(t, CatalogObject{Type: "price", Tier: TierProfessional, Name: "professional_yearly", ID: "price_2"}, setup.Created[0])
+	assert.Equal(t, "prod_existing", catalog.prices[1].Product.ID, "the yearly price joins the existing product")
+}
+
+func TestCreatePrices_DryRun(t *testing.T) {
+	s, catalog := newTestCatalogService()
+
+	setup, err := s.CreatePrices(true)
+	require.NoError(t, err)
+
+	assert.True(t, setup.DryRun)
+	require.Len(t, setup.Created, 8)
+	assert.Equal(t, CatalogObject{Type: "product", Tier: TierProfessional, Name: "ArvFinder Professional"}, setup.Created[0])
+	assert.Empty(t, catalog.products)
+	assert.Empty(t, catalog.prices)
+}
+
+func TestCreatePrices_MetersEnterpriseAPICalls(t *testing.T) {
+	s, catalog := newTestCatalogService()
+
+	_, err := s.CreatePrices(false)
+	require.NoError(t, err)
+
+	var metered []string
+	for _, p := range catalog.prices {
+		if isMetered(p) {
+			metered = append(metered, p.LookupKey)
+			assert.Equal(t, "prod_2", p.Product.ID, "on the Enterprise product")
+		}
+	}
+	assert.Equal(t, []string{"enterprise_api_calls_monthly", "enterprise_api_calls_yearly"}, metered)
+}
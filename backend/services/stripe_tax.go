@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/tax/calculation"
+	"github.com/stripe/stripe-go/v79/tax/registration"
+)
+
+// ErrBillingAddressRequired is returned when tax is collected and there's
+// no billing address to work it out from
+var ErrBillingAddressRequired = errors.New("billing address is required to calculate tax")
+
+// BillingAddress is where a customer is billed, which decides the sales tax
+// they pay
+type BillingAddress struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postal_code" binding:"required"`
+	Country    string `json:"country" binding:"required,len=2"` // ISO 3166-1 alpha-2, e.g. US
+}
+
+// params returns the address as Stripe takes it
+func (a BillingAddress) params() *stripe.AddressParams {
+	return &stripe.AddressParams{
+		Line1:      stripe.String(a.Line1),
+		Line2:      stripe.String(a.Line2),
+		City:       stripe.String(a.City),
+		State:      stripe.String(a.State),
+		PostalCode: stripe.String(a.PostalCode),
+		Country:    stripe.String(a.Country),
+	}
+}
+
+// StripeTaxAPI is the part of Stripe Tax collecting tax needs.
+// Implementations must be safe for concurrent use.
+type StripeTaxAPI interface {
+	// ActiveRegistrations returns how many places the account is
+	// registered to collect tax in
+	ActiveRegistrations() (int, error)
+	CalculateTax(params *stripe.TaxCalculationParams) (*stripe.TaxCalculation, error)
+}
+
+// stripeTaxAPI calls Stripe with the key set by NewStripeService
+type stripeTaxAPI struct{}
+
+func (stripeTaxAPI) ActiveRegistrations() (int, error) {
+	count := 0
+	iter := registration.List(&stripe.TaxRegistrationListParams{Status: stripe.String("active")})
+	for iter.Next() {
+		count++
+	}
+	return count, iter.Err()
+}
+
+func (stripeTaxAPI) CalculateTax(params *stripe.TaxCalculationParams) (*stripe.TaxCalculation, error) {
+	return calculation.New(params)
+}
+
+// SetTaxAPI replaces the Stripe API tax is worked out through, e.g. with a
+// stub in tests
+func (s *StripeService) SetTaxAPI(api StripeTaxAPI) {
+	s.tax = api
+}
+
+// EnableAutomaticTax has Stripe Tax add sales tax to new subscriptions,
+// report payments and Checkout sessions. An account that isn't registered
+// to collect tax anywhere has none to add, so tax stays off and it returns
+// false.
+func (s *StripeService) EnableAutomaticTax() (bool, error) {
+	registrations, err := s.tax.ActiveRegistrations()
+	if err != nil {
+		return false, fmt.Errorf("failed to list tax registrations: %w", err)
+	}
+	s.automaticTax = registrations > 0
+	return s.automaticTax, nil
+}
+
+// AutomaticTax reports whether sales tax is collected
+func (s *StripeService) AutomaticTax() bool {
+	return s.automaticTax
+}
+
+// reportTax works out the tax on a report bought by customerID, returning
+// the calculation the payment is for
+func (s *StripeService) reportTax(customerID string, reportInfo ReportPaymentInfo) (*stripe.TaxCalculation, error) {
+	calc, err := s.tax.CalculateTax(&stripe.TaxCalculationParams{
+		Currency: stripe.String(reportInfo.Currency),
+		Customer: stripe.String(customerID),
+		LineItems: []*stripe.TaxCalculationLineItemParams{
+			{
+				Amount:      stripe.Int64(reportInfo.Price),
+				Reference:   stripe.String("report_generation"),
+				TaxBehavior: stripe.String("exclusive"),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate tax: %w", err)
+	}
+	return calc, nil
+}
+
+// reportTaxMetadata records the tax calculation a report payment is for
+func reportTaxMetadata(metadata map[string]string, calc *stripe.TaxCalculation) {
+	metadata["tax_calculation"] = calc.ID
+	metadata["tax"] = strconv.FormatInt(calc.TaxAmountExclusive, 10)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v79"
+)
+
+// stubStripeTax is registered to collect tax in registrations places and
+// charges tax on every calculation
+type stubStripeTax struct {
+	registrations int
+	tax           int64
+	calculated    []*stripe.TaxCalculationParams
+}
+
+func (s *stubStripeTax) ActiveRegistrations() (int, error) {
+	return s.registrations, nil
+}
+
+func (s *stubStripeTax) CalculateTax(params *stripe.TaxCalculationParams) (*stripe.TaxCalculation, error) {
+	s.calculated = append(s.calculated, params)
+	amount := *params.LineItems[0].Amount
+	return &stripe.TaxCalculation{
+		ID:                 "taxcalc_1",
+		AmountTotal:        amount + s.tax,
+		TaxAmountExclusive: s.tax,
+	}, nil
+}
+
+// recordingPaymentIntents keeps the payment intents created
+type recordingPaymentIntents struct {
+	created []*stripe.PaymentIntentParams
+}
+
+func (r *recordingPaymentIntents) CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	r.created = append(r.created, params)
+	return &stripe.PaymentIntent{ID: "pi_1", Amount: *params.Amount}, nil
+}
+
+func TestEnableAutomaticTax_WithoutRegistrationsIsANoOp(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	tax := &stubStripeTax{}
+	s.SetTaxAPI(tax)
+	intents := &recordingPaymentIntents{}
+	s.SetPaymentIntentAPI(intents)
+
+	enabled, err := s.EnableAutomaticTax()
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	_, err = s.CreateSubscription("cus_1", "price_professional_monthly", nil, 0, "")
+	require.NoError(t, err)
+	assert.Nil(t, api.created.AutomaticTax)
+
+	_, err = s.CreateReportPaymentIntent("cus_1", "property-1", "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(999), *intents.created[0].Amount)
+	assert.Empty(t, tax.calculated)
+}
+
+func TestEnableAutomaticTax_Subscription(t *testing.T) {
+	s, api := newTestSubscriptionService()
+	s.SetTaxAPI(&stubStripeTax{registrations: 1})
+
+	enabled, err := s.EnableAutomaticTax()
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	_, err = s.CreateSubscription("cus_1", "price_professional_monthly", nil, 0, "")
+	require.NoError(t, err)
+	require.NotNil(t, api.created.AutomaticTax)
+	assert.True(t, *api.created.AutomaticTax.Enabled)
+}
+
+func TestCreateReportPaymentIntent_ChargesTax(t *testing.T) {
+	s := NewStripeService("")
+	tax := &stubStripeTax{registrations: 1, tax: 82}
+	s.SetTaxAPI(tax)
+	intents := &recordingPaymentIntents{}
+	s.SetPaymentIntentAPI(intents)
+	_, err := s.EnableAutomaticTax()
+	require.NoError(t, err)
+
+	intent, err := s.CreateReportPaymentIntent("cus_1", "property-1", "")
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(1081), intent.Amount)
+	require.Len(t, tax.calculated, 1)
+	assert.Equal(t, "cus_1", *tax.calculated[0].Customer)
+	assert.Equal(t, "exclusive", *tax.calculated[0].LineItems[0].TaxBehavior)
+	assert.Equal(t, "taxcalc_1", intents.created[0].Metadata["tax_calculation"])
+	assert.Equal(t, "82", intents.created[0].Metadata["tax"])
+}
+
+func TestPreviewPlanChange_IncludesTax(t *testing.T) {
+	s, api := newTestSubscriptionService(
+		&stripe.InvoiceLineItem{Amount: 1500, Proration: true, TaxAmounts: []*stripe.InvoiceTotalTaxAmount{{Amount: 124}}},
+		&stripe.InvoiceLineItem{Amount: 5900, TaxAmounts: []*stripe.InvoiceTotalTaxAmount{{Amount: 487}}},
+	)
+	api.upcoming.Tax = 611
+	api.upcoming.AmountDue += 611
+
+	preview, err := s.PreviewPlanChange("sub_1", "price_enterprise_monthly", ProrationAlwaysInvoice)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(611), preview.Tax)
+	assert.Equal(t, int64(8011), preview.NextInvoiceAmount)
+	assert.Equal(t, int64(5900), preview.RecurringAmount, "before tax")
+}
+
+// stubStripeInvoices has the same invoices for every customer
+type stubStripeInvoices struct {
+	invoices []*stripe.Invoice
+}
+
+func (s *stubStripeInvoices) ListInvoices(customerID string, limit int64) ([]*stripe.Invoice, error) {
+	return s.invoices, nil
+}
+
+func TestInvoices_IncludeTax(t *testing.T) {
+	s := NewStripeService("")
+	s.SetInvoiceAPI(&stubStripeInvoices{invoices: []*stripe.Invoice{{
+		ID:         "in_1",
+		Number:     "ARV-0001",
+		Status:     stripe.InvoiceStatusPaid,
+		Created:    1790000000,
+		Currency:   stripe.CurrencyUSD,
+		Subtotal:   2900,
+		Tax:        239,
+		Total:      3139,
+		AmountPaid: 3139,
+		TotalTaxAmounts: []*stripe.InvoiceTotalTaxAmount{
+			{Amount: 239, Inclusive: false},
+		},
+	}}})
+
+	invoices, err := s.Invoices("cus_1", 24)
+
+	require.NoError(t, err)
+	require.Len(t, invoices, 1)
+	assert.Equal(t, int64(2900), invoices[0].Subtotal)
+	assert.Equal(t, int64(239), invoices[0].Tax)
+	assert.Equal(t, int64(3139), invoices[0].Total)
+	assert.Equal(t, "paid", invoices[0].Status)
+}
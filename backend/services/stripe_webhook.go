@@ -0,0 +1,667 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/stripe/stripe-go/v79"
+)
+
+// Stripe webhook events the billing webhook handles
+const (
+	EventInvoicePaymentSucceeded  = "invoice.payment_succeeded"
+	EventInvoicePaymentFailed     = "invoice.payment_failed"
+	EventSubscriptionCreated      = "customer.subscription.created"
+	EventSubscriptionUpdated      = "customer.subscription.updated"
+	EventSubscriptionDeleted      = "customer.subscription.deleted"
+	EventSubscriptionTrialWillEnd = "customer.subscription.trial_will_end"
+	EventPaymentIntentSucceeded   = "payment_intent.succeeded"
+	EventCheckoutSessionCompleted = "checkout.session.completed"
+	EventCheckoutSessionExpired   = "checkout.session.expired"
+)
+
+// DefaultPaymentGracePeriod is how long a tenant whose payment failed keeps
+// its plan while the card's sorted out
+const DefaultPaymentGracePeriod = 7 * 24 * time.Hour
+
+// errBillingTenantUnknown is returned for a Stripe object that can't be
+// traced back to a tenant
+var errBillingTenantUnknown = errors.New("no tenant for this Stripe customer")
+
+// ErrWebhookEventNotFound is returned when replaying a Stripe event that
+// hasn't been handled
+var ErrWebhookEventNotFound = errors.New("Stripe event not found")
+
+// BillingRepository keeps tenants' Stripe subscriptions and report
+// purchases in step with Stripe's webhook events
+type BillingRepository struct {
+	db          *sql.DB
+	email       EmailSender
+	gracePeriod time.Duration
+	now         func() time.Time
+}
+
+// NewBillingRepository creates a new billing repository. Billing notices,
+// such as a trial ending, go out through email.
+func NewBillingRepository(db *sql.DB, email EmailSender) *BillingRepository {
+	return &BillingRepository{db: db, email: email, gracePeriod: DefaultPaymentGracePeriod, now: time.Now}
+}
+
+// SetGracePeriod sets how long a tenant keeps its plan after a payment
+// fails, DefaultPaymentGracePeriod unless set
+func (r *BillingRepository) SetGracePeriod(gracePeriod time.Duration) {
+	r.gracePeriod = gracePeriod
+}
+
+// BillingSubscription is a tenant's Stripe subscription as the webhooks last
+// left it
+type BillingSubscription struct {
+	SubscriptionID   string
+	Tier             SubscriptionTier
+	Status           stripe.SubscriptionStatus
+	CurrentPeriodEnd *time.Time
+	TrialEnd         *time.Time
+	CancelAt         *time.Time // set while a cancellation is pending
+	GracePeriodEnd   *time.Time // set while a failed payment is outstanding
+	Seats            int        // bought; only Enterprise is billed per seat
+	SeatsUsed        int        // the tenant's active members
+}
+
+// Subscription returns tenantID's subscription, or nil for a tenant that's
+// never subscribed
+func (r *BillingRepository) Subscription(tenantID string) (*BillingSubscription, error) {
+	var sub BillingSubscription
+	var subscriptionID sql.NullString
+	var periodEnd, trialEnd, cancelAt, graceEnd sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT stripe_subscription_id, tier, status, current_period_end, trial_end, cancel_at, grace_period_ends_at, seats,
+			(SELECT COUNT(*) FROM users WHERE users.tenant_id = subscriptions.tenant_id AND is_active AND deleted_at IS NULL)
+		FROM subscriptions WHERE tenant_id = $1
+	`, tenantID).Scan(&subscriptionID, &sub.Tier, &sub.Status, &periodEnd, &trialEnd, &cancelAt, &graceEnd, &sub.Seats, &sub.SeatsUsed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subscription: %w", err)
+	}
+	if periodEnd.Valid {
+		sub.CurrentPeriodEnd = &periodEnd.Time
+	}
+	if trialEnd.Valid {
+		sub.TrialEnd = &trialEnd.Time
+	}
+	if cancelAt.Valid {
+		sub.CancelAt = &cancelAt.Time
+	}
+	if graceEnd.Valid {
+		sub.GracePeriodEnd = &graceEnd.Time
+	}
+	sub.SubscriptionID = subscriptionID.String
+	return &sub, nil
+}
+
+// SaveSeats records how many seats tenantID's subscription bills for. The
+// webhook keeps it in sync too; this shows a change straight away.
+func (r *BillingRepository) SaveSeats(tenantID string, seats int64) error {
+	_, err := r.db.Exec(`UPDATE subscriptions SET seats = $2, updated_at = NOW() WHERE tenant_id = $1`, tenantID, seats)
+	if err != nil {
+		return fmt.Errorf("failed to save seats: %w", err)
+	}
+	return nil
+}
+
+// SavePendingCancellation records that tenantID's subscription cancels at
+// cancelAt, or with nil that it no longer does. The webhook keeps it in
+// sync too; this shows a change straight away.
+func (r *BillingRepository) SavePendingCancellation(tenantID string, cancelAt *time.Time) error {
+	return savePendingCancellation(r.db, tenantID, cancelAt)
+}
+
+// ClaimTrial takes tenantID's one free trial, returning false when it's
+// been used already. The check and the claim are one statement, so two
+// subscriptions at once can't both get a trial.
+func (r *BillingRepository) ClaimTrial(tenantID string) (bool, error) {
+	result, err := r.db.Exec(`
+		UPDATE tenants SET trial_started_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND trial_started_at IS NULL
+	`, tenantID)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim trial: %w", err)
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim trial: %w", err)
+	}
+	return claimed == 1, nil
+}
+
+// ReleaseTrial gives back a trial claimed for a subscription that couldn't
+// be created
+func (r *BillingRepository) ReleaseTrial(tenantID string) error {
+	_, err := r.db.Exec(`UPDATE tenants SET trial_started_at = NULL, updated_at = NOW() WHERE id = $1`, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to release trial: %w", err)
+	}
+	return nil
+}
+
+// HandleWebhookEvent applies a verified Stripe event, once. An event that's
+// already been handled, because Stripe retried its delivery, is skipped and
+// reported as not processed. If applying it fails nothing is kept, so
+// Stripe's next retry tries again.
+func (r *BillingRepository) HandleWebhookEvent(event stripe.Event) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO stripe_events (id, type, payload) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING
+	`, event.ID, string(event.Type), string(event.Data.Raw))
+	if err != nil {
+		return false, fmt.Errorf("failed to record Stripe event: %w", err)
+	}
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record Stripe event: %w", err)
+	}
+	if recorded == 0 {
+		return false, nil
+	}
+	if err := r.dispatchWebhookEvent(tx, event); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit Stripe event: %w", err)
+	}
+	return true, nil
+}
+
+// ReplayWebhookEvent applies a Stripe event that's been handled again, from
+// the payload kept for it, e.g. to debug it or after fixing how it's
+// handled. Unlike a delivery from Stripe it's applied though it's been
+// handled before. It returns the event's type.
+func (r *BillingRepository) ReplayWebhookEvent(eventID string) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var eventType string
+	var payload []byte
+	err = tx.QueryRow(`
+		SELECT type, payload FROM stripe_events WHERE id = $1 FOR UPDATE
+	`, eventID).Scan(&eventType, &payload)
+	if err == sql.ErrNoRows {
+		return "", ErrWebhookEventNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load Stripe event: %w", err)
+	}
+
+	event := stripe.Event{ID: eventID, Type: stripe.EventType(eventType), Data: &stripe.EventData{Raw: payload}}
+	if err := r.dispatchWebhookEvent(tx, event); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`UPDATE stripe_events SET processed_at = NOW() WHERE id = $1`, eventID); err != nil {
+		return "", fmt.Errorf("failed to record Stripe event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit Stripe event: %w", err)
+	}
+	return eventType, nil
+}
+
+// dispatchWebhookEvent applies event in tx by its type. Types the billing
+// webhook doesn't handle are ignored.
+func (r *BillingRepository) dispatchWebhookEvent(tx *sql.Tx, event stripe.Event) error {
+	var err error
+	switch event.Type {
+	case EventInvoicePaymentSucceeded:
+		err = r.invoicePaid(tx, event)
+	case EventInvoicePaymentFailed:
+		err = r.invoicePaymentFailed(tx, event)
+	case EventSubscriptionCreated, EventSubscriptionUpdated:
+		err = r.subscriptionUpdated(tx, event)
+	case EventSubscriptionDeleted:
+		err = r.subscriptionDeleted(tx, event)
+	case EventSubscriptionTrialWillEnd:
+		err = r.trialWillEnd(tx, event)
+	case EventPaymentIntentSucceeded:
+		err = r.paymentSucceeded(tx, event)
+	case EventCheckoutSessionCompleted:
+		err = r.checkoutCompleted(tx, event)
+	case EventCheckoutSessionExpired:
+		err = r.checkoutExpired(tx, event)
+	}
+	// Retrying an event that names no tenant won't find one, so it's
+	// recorded as handled
+	if errors.Is(err, errBillingTenantUnknown) {
+		log.Printf("Ignoring Stripe event %s (%s): %v", event.ID, event.Type, err)
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to handle Stripe event %s: %w", event.ID, err)
+	}
+	return nil
+}
+
+// invoicePaid activates or extends the subscription an invoice paid for,
+// and starts the tenant's usage afresh. Invoices outside a subscription are
+// left alone, and the $0 first invoice of a trial leaves the status as the
+// subscription's own events set it. Paying ends any grace period, restoring
+// the plan of a tenant downgraded for not paying.
+func (r *BillingRepository) invoicePaid(tx *sql.Tx, event stripe.Event) error {
+	var invoice stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+		return fmt.Errorf("failed to parse invoice: %w", err)
+	}
+	if invoice.Subscription == nil || invoice.Subscription.ID == "" {
+		return nil
+	}
+
+	var metadata map[string]string
+	if invoice.SubscriptionDetails != nil {
+		metadata = invoice.SubscriptionDetails.Metadata
+	}
+	tenantID, err := billingTenant(tx, metadata, customerID(invoice.Customer))
+	if err != nil {
+		return err
+	}
+
+	var tier SubscriptionTier
+	var periodEnd int64
+	if invoice.Lines != nil {
+		for _, line := range invoice.Lines.Data {
+			if tier == "" {
+				tier = tierForPrice(line.Price)
+			}
+			if line.Period != nil && line.Period.End > periodEnd {
+				periodEnd = line.Period.End
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE subscriptions SET grace_period_ends_at = NULL, updated_at = NOW() WHERE tenant_id = $1
+	`, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to end grace period: %w", err)
+	}
+
+	status := stripe.SubscriptionStatusActive
+	if invoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionCreate && invoice.AmountPaid == 0 {
+		status = ""
+	}
+	if err := saveSubscription(tx, tenantID, customerID(invoice.Customer), invoice.Subscription.ID,
+		tier, status, periodEnd, 0, 0); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE usage_records SET arv_calculations = 0, updated_at = NOW()
+		WHERE tenant_id = $1 AND month = $2
+	`, tenantID, usageMonth(r.now()))
+	if err != nil {
+		return fmt.Errorf("failed to reset usage: %w", err)
+	}
+	return nil
+}
+
+// invoicePaymentFailed marks the subscription an invoice couldn't be paid
+// for past due. The first failure starts the grace period; Stripe's retries
+// don't extend it.
+func (r *BillingRepository) invoicePaymentFailed(tx *sql.Tx, event stripe.Event) error {
+	var invoice stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+		return fmt.Errorf("failed to parse invoice: %w", err)
+	}
+	if invoice.Subscription == nil || invoice.Subscription.ID == "" {
+		return nil
+	}
+
+	var metadata map[string]string
+	if invoice.SubscriptionDetails != nil {
+		metadata = invoice.SubscriptionDetails.Metadata
+	}
+	tenantID, err := billingTenant(tx, metadata, customerID(invoice.Customer))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE subscriptions
+		SET status = $2, grace_period_ends_at = COALESCE(grace_period_ends_at, $3), updated_at = NOW()
+		WHERE tenant_id = $1
+	`, tenantID, string(stripe.SubscriptionStatusPastDue), r.now().Add(r.gracePeriod))
+	if err != nil {
+		return fmt.Errorf("failed to mark subscription past due: %w", err)
+	}
+	return nil
+}
+
+// DowngradeLapsedSubscriptions moves tenants whose grace period has ended
+// without a payment to Starter's limits, returning how many. The
+// subscription keeps its plan, so paying restores it.
+func (r *BillingRepository) DowngradeLapsedSubscriptions() (int64, error) {
+	result, err := r.db.Exec(`
+		UPDATE tenants SET subscription_tier = $2, updated_at = NOW()
+		FROM subscriptions
+		WHERE subscriptions.tenant_id = tenants.id
+			AND subscriptions.grace_period_ends_at <= $1
+			AND tenants.subscription_tier <> $2
+	`, r.now(), string(TierStarter))
+	if err != nil {
+		return 0, fmt.Errorf("failed to downgrade lapsed subscriptions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// subscriptionUpdated syncs a new or changed subscription's tier, status,
+// period end and trial end
+func (r *BillingRepository) subscriptionUpdated(tx *sql.Tx, event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return fmt.Errorf("failed to parse subscription: %w", err)
+	}
+	tenantID, err := billingTenant(tx, sub.Metadata, customerID(sub.Customer))
+	if err != nil {
+		return err
+	}
+
+	var tier SubscriptionTier
+	var seats int64
+	if item := planItem(&sub); item != nil {
+		tier = tierForPrice(item.Price)
+		seats = item.Quantity
+	}
+	if err := saveSubscription(tx, tenantID, customerID(sub.Customer), sub.ID, tier, sub.Status, sub.CurrentPeriodEnd, sub.TrialEnd, seats); err != nil {
+		return err
+	}
+	return savePendingCancellation(tx, tenantID, PendingCancellation(&sub))
+}
+
+// subscriptionDeleted moves the tenant whose subscription ended back to
+// Starter. That includes a trial that ended without a payment method, which
+// Stripe cancels; the tenant's properties and calculations are kept.
+func (r *BillingRepository) subscriptionDeleted(tx *sql.Tx, event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return fmt.Errorf("failed to parse subscription: %w", err)
+	}
+	tenantID, err := billingTenant(tx, sub.Metadata, customerID(sub.Customer))
+	if err != nil {
+		return err
+	}
+	if err := saveSubscription(tx, tenantID, customerID(sub.Customer), sub.ID, TierStarter, stripe.SubscriptionStatusCanceled, sub.CurrentPeriodEnd, sub.TrialEnd, 0); err != nil {
+		return err
+	}
+	return savePendingCancellation(tx, tenantID, nil)
+}
+
+// trialWillEnd emails the tenant's admins three days before its trial ends.
+// Email failures are logged rather than retried, so nobody gets it twice.
+func (r *BillingRepository) trialWillEnd(tx *sql.Tx, event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return fmt.Errorf("failed to parse subscription: %w", err)
+	}
+	tenantID, err := billingTenant(tx, sub.Metadata, customerID(sub.Customer))
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		SELECT email FROM users
+		WHERE tenant_id = $1 AND role = 'admin' AND is_active = TRUE AND deleted_at IS NULL
+	`, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to load tenant admins: %w", err)
+	}
+	defer rows.Close()
+	var emails []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return fmt.Errorf("failed to load tenant admins: %w", err)
+		}
+		emails = append(emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load tenant admins: %w", err)
+	}
+
+	ends := time.Unix(sub.TrialEnd, 0).UTC().Format("January 2, 2006")
+	body := fmt.Sprintf("Your ArvFinder trial ends on %s.\n\n"+
+		"Add a payment method before then to keep your plan. Otherwise your account moves to the free Starter plan; "+
+		"your properties and saved calculations are kept.", ends)
+	for _, email := range emails {
+		if err := r.email.Send(email, "Your ArvFinder trial ends on "+ends, body); err != nil {
+			log.Printf("Failed to send trial ending email to %s: %v", email, err)
+		}
+	}
+	return nil
+}
+
+// paymentSucceeded records a paid report, ready to be spent on one report.
+// Other one-time payments are left alone.
+func (r *BillingRepository) paymentSucceeded(tx *sql.Tx, event stripe.Event) error {
+	var intent stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &intent); err != nil {
+		return fmt.Errorf("failed to parse payment intent: %w", err)
+	}
+	if intent.Metadata["type"] != "report_generation" {
+		return nil
+	}
+
+	// A report bought before signing in isn't tied to a tenant yet
+	var tenantID sql.NullString
+	id, err := billingTenant(tx, intent.Metadata, customerID(intent.Customer))
+	if err != nil && !errors.Is(err, errBillingTenantUnknown) {
+		return err
+	}
+	if id != "" {
+		tenantID = sql.NullString{String: id, Valid: true}
+	}
+	return recordReportPurchase(tx, intent.ID, tenantID, intent.Metadata["property_id"], customerID(intent.Customer), intent.AmountReceived)
+}
+
+// recordReportPurchase records a report paid for by paymentIntentID, once:
+// a Checkout session's payment is heard about both as the session and as
+// its payment intent
+func recordReportPurchase(tx *sql.Tx, paymentIntentID string, tenantID sql.NullString, propertyID, customer string, amount int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO report_purchases (payment_intent_id, tenant_id, property_id, stripe_customer_id, amount)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (payment_intent_id) DO NOTHING
+	`, paymentIntentID, tenantID, propertyID, customer, amount)
+	if err != nil {
+		return fmt.Errorf("failed to record report purchase: %w", err)
+	}
+	return nil
+}
+
+// checkoutCompleted records what a completed Checkout session bought, as
+// the payment element flow would have: the tenant's customer, and either
+// its subscription or a paid report
+func (r *BillingRepository) checkoutCompleted(tx *sql.Tx, event stripe.Event) error {
+	var checkout stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &checkout); err != nil {
+		return fmt.Errorf("failed to parse checkout session: %w", err)
+	}
+	tenantID, err := billingTenant(tx, checkout.Metadata, customerID(checkout.Customer))
+	if err != nil {
+		return err
+	}
+
+	customer := customerID(checkout.Customer)
+	if customer != "" && checkout.CustomerDetails != nil {
+		// A customer the tenant already has is kept
+		_, err = tx.Exec(`
+			INSERT INTO stripe_customers (tenant_id, stripe_customer_id, email)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, tenantID, customer, checkout.CustomerDetails.Email)
+		if err != nil {
+			return fmt.Errorf("failed to save Stripe customer: %w", err)
+		}
+	}
+
+	switch checkout.Mode {
+	case stripe.CheckoutSessionModeSubscription:
+		if checkout.Subscription == nil || checkout.Subscription.ID == "" {
+			return nil
+		}
+		// The subscription's own events fill in its status and period
+		tier := SubscriptionTier(checkout.Metadata["tier"])
+		return saveSubscription(tx, tenantID, customer, checkout.Subscription.ID, tier, "", 0, 0, 0)
+	case stripe.CheckoutSessionModePayment:
+		if checkout.Metadata["type"] != "report_generation" || checkout.PaymentIntent == nil ||
+			checkout.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+			return nil
+		}
+		return recordReportPurchase(tx, checkout.PaymentIntent.ID, sql.NullString{String: tenantID, Valid: true},
+			checkout.Metadata["property_id"], customer, checkout.AmountTotal)
+	}
+	return nil
+}
+
+// checkoutExpired gives back the trial claimed for a Checkout session that
+// expired without subscribing
+func (r *BillingRepository) checkoutExpired(tx *sql.Tx, event stripe.Event) error {
+	var checkout stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &checkout); err != nil {
+		return fmt.Errorf("failed to parse checkout session: %w", err)
+	}
+	tenantID := checkout.Metadata["tenant_id"]
+	if checkout.Mode != stripe.CheckoutSessionModeSubscription || checkout.Metadata["trial_days"] == "" || tenantID == "" {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE tenants SET trial_started_at = NULL, updated_at = NOW() WHERE id = $1`, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to release trial: %w", err)
+	}
+	return nil
+}
+
+// billingTenant finds the tenant a Stripe object belongs to: the tenant_id
+// in its metadata, or else whoever the customer is stored for
+func billingTenant(tx *sql.Tx, metadata map[string]string, customer string) (string, error) {
+	if tenantID := metadata["tenant_id"]; tenantID != "" {
+		return tenantID, nil
+	}
+	if customer == "" {
+		return "", errBillingTenantUnknown
+	}
+	var tenantID string
+	err := tx.QueryRow(`
+		SELECT tenant_id FROM stripe_customers WHERE stripe_customer_id = $1
+		UNION ALL
+		SELECT tenant_id FROM subscriptions WHERE stripe_customer_id = $1
+		LIMIT 1
+	`, customer).Scan(&tenantID)
+	if err == sql.ErrNoRows {
+		return "", errBillingTenantUnknown
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up Stripe customer: %w", err)
+	}
+	return tenantID, nil
+}
+
+// saveSubscription records a tenant's subscription and puts the tenant on
+// the plan it pays for. An empty tier, for a price that isn't one of the
+// plans, leaves the tier as it was, an empty status the status and no seats
+// the seats. Canceled and unpaid subscriptions fall back to Starter, as does
+// one whose grace period for a failed payment has run out.
+func saveSubscription(tx *sql.Tx, tenantID, customer, subscriptionID string, tier SubscriptionTier, status stripe.SubscriptionStatus, periodEnd, trialEnd, seats int64) error {
+	switch status {
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusUnpaid, stripe.SubscriptionStatusIncompleteExpired:
+		tier = TierStarter
+	}
+	currentPeriodEnd := unixTime(periodEnd)
+	trialEndsAt := unixTime(trialEnd)
+
+	var saved string
+	err := tx.QueryRow(`
+		INSERT INTO subscriptions (tenant_id, stripe_customer_id, stripe_subscription_id, tier, status, current_period_end, trial_end, seats)
+		VALUES ($1, $2, $3, COALESCE(NULLIF($4, ''), 'starter'), COALESCE(NULLIF($5, ''), 'active'), $6, $7, COALESCE(NULLIF($8, 0), 1))
+		ON CONFLICT (tenant_id) DO UPDATE
+		SET stripe_customer_id = COALESCE(NULLIF(EXCLUDED.stripe_customer_id, ''), subscriptions.stripe_customer_id),
+			stripe_subscription_id = EXCLUDED.stripe_subscription_id,
+			tier = COALESCE(NULLIF($4, ''), subscriptions.tier),
+			status = COALESCE(NULLIF($5, ''), subscriptions.status),
+			current_period_end = COALESCE(EXCLUDED.current_period_end, subscriptions.current_period_end),
+			trial_end = COALESCE(EXCLUDED.trial_end, subscriptions.trial_end),
+			seats = COALESCE(NULLIF($8, 0), subscriptions.seats),
+			updated_at = NOW()
+		RETURNING CASE WHEN grace_period_ends_at <= NOW() THEN 'starter' ELSE tier END
+	`, tenantID, customer, subscriptionID, string(tier), string(status), currentPeriodEnd, trialEndsAt, seats).Scan(&saved)
+	if err != nil {
+		return fmt.Errorf("failed to save subscription: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE tenants SET subscription_tier = $2, updated_at = NOW() WHERE id = $1`, tenantID, saved)
+	if err != nil {
+		return fmt.Errorf("failed to update tenant plan: %w", err)
+	}
+	return nil
+}
+
+// tierForPrice returns the plan a Stripe price is for, monthly or yearly,
+// by its lookup key or a tier in its metadata, or "" when it isn't one of
+// the plans
+func tierForPrice(price *stripe.Price) SubscriptionTier {
+	if price == nil {
+		return ""
+	}
+	for tier, plan := range subscriptionPlans() {
+		for _, p := range planPrices(tier, plan) {
+			if p.LookupKey == price.LookupKey {
+				return tier
+			}
+		}
+	}
+	if tier := SubscriptionTier(price.Metadata["tier"]); tier != "" {
+		if _, ok := subscriptionPlans()[tier]; ok {
+			return tier
+		}
+	}
+	return ""
+}
+
+// savePendingCancellation sets or clears when tenantID's subscription cancels
+func savePendingCancellation(db sqlExecer, tenantID string, cancelAt *time.Time) error {
+	_, err := db.Exec(`
+		UPDATE subscriptions SET cancel_at = $2, updated_at = NOW() WHERE tenant_id = $1
+	`, tenantID, cancelAt)
+	if err != nil {
+		return fmt.Errorf("failed to save pending cancellation: %w", err)
+	}
+	return nil
+}
+
+// unixTime converts a Stripe timestamp, or returns nil for an unset one
+func unixTime(seconds int64) *time.Time {
+	if seconds <= 0 {
+		return nil
+	}
+	t := time.Unix(seconds, 0).UTC()
+	return &t
+}
+
+// customerID returns the ID of an expandable customer, or "" without one
+func customerID(customer *stripe.Customer) string {
+	if customer == nil {
+		return ""
+	}
+	return customer.ID
+}
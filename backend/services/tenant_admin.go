@@ -0,0 +1,219 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tenant administration errors
+var (
+	ErrInvalidRole = errors.New("invalid role")
+	ErrLastAdmin   = errors.New("tenant must keep at least one active admin")
+)
+
+// Roles a tenant member can hold. The tenant's creator is its first admin.
+var tenantRoles = map[string]bool{"admin": true, "user": true}
+
+// TenantMember is a user as shown to their tenant's admins
+type TenantMember struct {
+	ID               string     `json:"id"`
+	Email            string     `json:"email"`
+	FirstName        string     `json:"first_name"`
+	LastName         string     `json:"last_name"`
+	Role             string     `json:"role"`
+	IsActive         bool       `json:"is_active"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	TwoFactorMethod  string     `json:"two_factor_method,omitempty"`
+	LastLoginAt      *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// TenantAdminService lets tenant admins manage the other members of their
+// tenant. Every method is scoped to the caller's tenant, so IDs from other
+// tenants behave as if they don't exist.
+type TenantAdminService struct {
+	db          *sql.DB
+	authService *AuthService
+}
+
+// NewTenantAdminService creates a new tenant admin service
+func NewTenantAdminService(db *sql.DB, authService *AuthService) *TenantAdminService {
+	return &TenantAdminService{
+		db:          db,
+		authService: authService,
+	}
+}
+
+// ListUsers returns the tenant's members, including deactivated ones but not
+// those who deleted their account
+func (s *TenantAdminService) ListUsers(tenantID string) ([]TenantMember, error) {
+	rows, err := s.db.Query(`
+		SELECT id, email, COALESCE(first_name, ''), COALESCE(last_name, ''), role, is_active,
+		       two_factor_enabled, COALESCE(two_factor_method, ''), last_login_at, created_at
+		FROM users
+		WHERE tenant_id = $1 AND deleted_at IS NULL
+		ORDER BY created_at
+	`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	members := []TenantMember{}
+	for rows.Next() {
+		var m TenantMember
+		if err := rows.Scan(
+			&m.ID, &m.Email, &m.FirstName, &m.LastName, &m.Role, &m.IsActive,
+			&m.TwoFactorEnabled, &m.TwoFactorMethod, &m.LastLoginAt, &m.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// ChangeRole sets a member's role. Demoting the tenant's last active admin,
+// including yourself, is refused. A demoted admin is signed out everywhere
+// because their access tokens still carry the old role.
+func (s *TenantAdminService) ChangeRole(tenantID, targetID, role string) error {
+	if !tenantRoles[role] {
+		return ErrInvalidRole
+	}
+
+	tx, currentRole, isActive, err := s.lockMember(tenantID, targetID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if currentRole == role {
+		return nil
+	}
+	demoting := currentRole == "admin"
+	if demoting && isActive {
+		if err := s.ensureOtherAdmin(tx, tenantID, targetID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE users SET role = $2, updated_at = NOW() WHERE id = $1`, targetID, role)
+	if err != nil {
+		return fmt.Errorf("failed to change role: %w", err)
+	}
+	if demoting {
+		if err := revokeSessionsTx(tx, targetID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit role change: %w", err)
+	}
+	if demoting {
+		s.authService.forgetSessions(targetID)
+	}
+	return nil
+}
+
+// DeactivateUser stops a member from signing in and revokes all of their
+// sessions. Deactivating the tenant's last active admin is refused.
+func (s *TenantAdminService) DeactivateUser(tenantID, targetID string) error {
+	tx, role, isActive, err := s.lockMember(tenantID, targetID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !isActive {
+		return nil
+	}
+	if role == "admin" {
+		if err := s.ensureOtherAdmin(tx, tenantID, targetID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE users SET is_active = FALSE, updated_at = NOW() WHERE id = $1`, targetID)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate user: %w", err)
+	}
+	if err := revokeSessionsTx(tx, targetID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deactivation: %w", err)
+	}
+	s.authService.forgetSessions(targetID)
+	return nil
+}
+
+// lockMember starts a transaction holding the tenant lock and loads the
+// target, who must belong to the tenant. The caller must roll back the
+// returned transaction.
+func (s *TenantAdminService) lockMember(tenantID, targetID string) (*sql.Tx, string, bool, error) {
+	if _, err := uuid.Parse(targetID); err != nil {
+		return nil, "", false, ErrUserNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// Serialize admin changes per tenant so two admins demoting each other
+	// can't both pass the last-admin check
+	if _, err := tx.Exec(`SELECT id FROM tenants WHERE id = $1 FOR UPDATE`, tenantID); err != nil {
+		tx.Rollback()
+		return nil, "", false, fmt.Errorf("failed to lock tenant: %w", err)
+	}
+
+	var role string
+	var isActive bool
+	err = tx.QueryRow(`
+		SELECT role, is_active FROM users
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+	`, targetID, tenantID).Scan(&role, &isActive)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, "", false, ErrUserNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, "", false, fmt.Errorf("failed to load user: %w", err)
+	}
+	return tx, role, isActive, nil
+}
+
+// ensureOtherAdmin returns ErrLastAdmin unless an active admin other than
+// userID remains in the tenant
+func (s *TenantAdminService) ensureOtherAdmin(tx *sql.Tx, tenantID, userID string) error {
+	var exists bool
+	err := tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM users
+			WHERE tenant_id = $1 AND id <> $2 AND role = 'admin' AND is_active = TRUE AND deleted_at IS NULL
+		)
+	`, tenantID, userID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check remaining admins: %w", err)
+	}
+	if !exists {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// revokeSessionsTx revokes all of a user's sessions inside tx
+func revokeSessionsTx(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(`UPDATE user_sessions SET revoked = TRUE WHERE user_id = $1 AND revoked = FALSE`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	adminID  = "11111111-1111-1111-1111-111111111111"
+	memberID = "22222222-2222-2222-2222-222222222222"
+)
+
+func newTestTenantAdminService(t *testing.T) (*TenantAdminService, sqlmock.Sqlmock) {
+	authService, mock := newTestAuthService(t)
+	return NewTenantAdminService(authService.db, authService), mock
+}
+
+// expectLockedMember mocks lockMember finding userID in tenant-1
+func expectLockedMember(mock sqlmock.Sqlmock, userID, role string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT id FROM tenants WHERE id = \$1 FOR UPDATE`).
+		WithArgs("tenant-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT role, is_active FROM users`).
+		WithArgs(userID, "tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{"role", "is_active"}).AddRow(role, true))
+}
+
+func expectOtherAdmin(mock sqlmock.Sqlmock, userID string, exists bool) {
+	mock.ExpectQuery(`WHERE tenant_id = \$1 AND id <> \$2 AND role = 'admin'`).
+		WithArgs("tenant-1", userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+func TestChangeRole_LastAdminCannotDemoteSelf(t *testing.T) {
+	service, mock := newTestTenantAdminService(t)
+
+	expectLockedMember(mock, adminID, "admin")
+	expectOtherAdmin(mock, adminID, false)
+	mock.ExpectRollback()
+
+	err := service.ChangeRole("tenant-1", adminID, "user")
+
+	assert.ErrorIs(t, err, ErrLastAdmin)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestChangeRole_DemotionWithAnotherAdminSignsOut(t *testing.T) {
+	service, mock := newTestTenantAdminService(t)
+
+	expectLockedMember(mock, adminID, "admin")
+	expectOtherAdmin(mock, adminID, true)
+	mock.ExpectExec(`UPDATE users SET role = \$2`).
+		WithArgs(adminID, "user").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_sessions SET revoked = TRUE`).
+		WithArgs(adminID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	require.NoError(t, service.ChangeRole("tenant-1", adminID, "user"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeactivateUser_LastAdminRefused(t *testing.T) {
+	service, mock := newTestTenantAdminService(t)
+
+	expectLockedMember(mock, adminID, "admin")
+	expectOtherAdmin(mock, adminID, false)
+	mock.ExpectRollback()
+
+	err := service.DeactivateUser("tenant-1", adminID)
+
+	assert.ErrorIs(t, err, ErrLastAdmin)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeactivateUser_RevokesSessions(t *testing.T) {
+	service, mock := newTestTenantAdminService(t)
+
+	expectLockedMember(mock, memberID, "user")
+	mock.ExpectExec(`UPDATE users SET is_active = FALSE`).
+		WithArgs(memberID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_sessions SET revoked = TRUE`).
+		WithArgs(memberID).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	require.NoError(t, service.DeactivateUser("tenant-1", memberID))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTenantAdmin_OtherTenantsUserIsNotFound(t *testing.T) {
+	service, mock := newTestTenantAdminService(t)
+
+	// The tenant_id filter means another tenant's user matches no row
+	for i := 0; i < 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectExec(`FOR UPDATE`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT role, is_active FROM users\s+WHERE id = \$1 AND tenant_id = \$2`).
+			WithArgs(memberID, "tenant-1").
+			WillReturnRows(sqlmock.NewRows([]string{"role", "is_active"}))
+		mock.ExpectRollback()
+	}
+
+	assert.ErrorIs(t, service.ChangeRole("tenant-1", memberID, "admin"), ErrUserNotFound)
+	assert.ErrorIs(t, service.DeactivateUser("tenant-1", memberID), ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing was updated")
+}
+
+func TestChangeRole_RejectsUnknownRoleAndMalformedID(t *testing.T) {
+	service, mock := newTestTenantAdminService(t)
+
+	assert.ErrorIs(t, service.ChangeRole("tenant-1", memberID, "owner"), ErrInvalidRole)
+	assert.ErrorIs(t, service.ChangeRole("tenant-1", "not-a-uuid", "admin"), ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListUsers_ScopedToTenant(t *testing.T) {
+	service, mock := newTestTenantAdminService(t)
+
+	mock.ExpectQuery(`FROM users\s+WHERE tenant_id = \$1 AND deleted_at IS NULL`).
+		WithArgs("tenant-1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "email", "first_name", "last_name", "role", "is_active",
+			"two_factor_enabled", "two_factor_method", "last_login_at", "created_at",
+		}).AddRow(adminID, "owner@example.com", "Owner", "One", "admin", true, true, "totp", nil, time.Now()))
+
+	users, err := service.ListUsers("tenant-1")
+
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "admin", users[0].Role)
+	assert.True(t, users[0].TwoFactorEnabled)
+	assert.Nil(t, users[0].LastLoginAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by every authenticator app)
+const (
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSkewSteps  = 1 // accept codes from one step before/after now
+	totpSecretSize = 20
+)
+
+// TOTP errors
+var (
+	ErrInvalidTOTPCode         = errors.New("invalid authenticator code")
+	ErrTOTPCodeReused          = errors.New("authenticator code already used")
+	ErrTOTPNoPendingEnrollment = errors.New("no authenticator enrollment in progress")
+	ErrTOTPNotEnabled          = errors.New("authenticator 2FA is not enabled")
+)
+
+// TOTP2FAService handles authenticator-app two-factor authentication
+type TOTP2FAService struct {
+	db            *sql.DB
+	encryptionKey []byte
+	issuer        string
+	now           func() time.Time
+}
+
+// TOTPEnrollment is returned when a user starts authenticator enrollment
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// NewTOTP2FAService creates a new TOTP 2FA service. Secrets are encrypted at
+// rest with a key derived from encryptionKey.
+func NewTOTP2FAService(db *sql.DB, encryptionKey string) *TOTP2FAService {
+	key := sha256.Sum256([]byte(encryptionKey))
+	return &TOTP2FAService{
+		db:            db,
+		encryptionKey: key[:],
+		issuer:        "ARVFinder",
+		now:           time.Now,
+	}
+}
+
+// TOTPKeyFromEnv returns TOTP_ENCRYPTION_KEY, falling back to the JWT secret
+// so development setups work without extra configuration
+func TOTPKeyFromEnv() string {
+	if key := os.Getenv("TOTP_ENCRYPTION_KEY"); key != "" {
+		return key
+	}
+	return JWTSecretFromEnv()
+}
+
+// BeginEnrollment generates a new secret and stores it as pending until the
+// user proves their app is set up by confirming a code
+func (s *TOTP2FAService) BeginEnrollment(userID, email string) (*TOTPEnrollment, error) {
+	secretBytes := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secretBytes)
+
+	encrypted, err := s.encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE users
+		SET totp_pending_secret = $2, updated_at = NOW()
+		WHERE id = $1
+	`, userID, encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	label := url.PathEscape(s.issuer + ":" + email)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", s.issuer)
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	return &TOTPEnrollment{
+		Secret:     secret,
+		OTPAuthURI: "otpauth://totp/" + label + "?" + query.Encode(),
+	}, nil
+}
+
+// ConfirmEnrollment activates the pending secret once the user enters a valid
+// code from their app, switching their 2FA method to TOTP
+func (s *TOTP2FAService) ConfirmEnrollment(userID, code string) error {
+	var pending sql.NullString
+	err := s.db.QueryRow(`SELECT totp_pending_secret FROM users WHERE id = $1`, userID).Scan(&pending)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if !pending.Valid || pending.String == "" {
+		return ErrTOTPNoPendingEnrollment
+	}
+
+	secret, err := s.decrypt(pending.String)
+	if err != nil {
+		return err
+	}
+
+	step, ok := s.matchStep(secret, code)
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE users
+		SET two_factor_secret = totp_pending_secret,
+		    totp_pending_secret = NULL,
+		    totp_last_used_step = $2,
+		    two_factor_method = 'totp',
+		    two_factor_enabled = TRUE,
+		    updated_at = NOW()
+		WHERE id = $1
+	`, userID, step)
+	return err
+}
+
+// VerifyCode checks a login code against the user's active secret. Each time
+// step can be redeemed once, so a shoulder-surfed code can't be replayed.
+func (s *TOTP2FAService) VerifyCode(userID, code string) error {
+	var encrypted sql.NullString
+	var method string
+	err := s.db.QueryRow(`
+		SELECT two_factor_secret, two_factor_method FROM users WHERE id = $1
+	`, userID).Scan(&encrypted, &method)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if method != "totp" || !encrypted.Valid {
+		return ErrTOTPNotEnabled
+	}
+
+	secret, err := s.decrypt(encrypted.String)
+	if err != nil {
+		return err
+	}
+
+	step, ok := s.matchStep(secret, code)
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	// Only move forward: a code from the same or an earlier step is a replay
+	result, err := s.db.Exec(`
+		UPDATE users
+		SET totp_last_used_step = $2
+		WHERE id = $1 AND (totp_last_used_step IS NULL OR totp_last_used_step < $2)
+	`, userID, step)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+// matchStep returns the time step whose code matches, checking the current
+// step and totpSkewSteps either side for clock drift
+func (s *TOTP2FAService) matchStep(secret, code string) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := s.now().Unix() / totpPeriod
+	for offset := int64(-totpSkewSteps); offset <= totpSkewSteps; offset++ {
+		step := current + offset
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateTOTPCode returns the code an authenticator app would show for the
+// base32 secret at time t
+func GenerateTOTPCode(secret string, t time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return totpCode(key, t.Unix()/totpPeriod), nil
+}
+
+// totpCode computes the HOTP value (RFC 4226) for a time step
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// encrypt seals a secret with AES-GCM, prefixing the nonce
+func (s *TOTP2FAService) encrypt(plaintext string) (string, error) {
+	block, err := aes.NewCipher(s.encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a secret sealed by encrypt
+func (s *TOTP2FAService) decrypt(encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode TOTP secret: %w", err)
+	}
+
+	block, err := aes.NewCipher(s.encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("TOTP secret is corrupted")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	// SHA-1 test vectors from RFC 6238 Appendix B, truncated to 6 digits
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		code, err := GenerateTOTPCode(testTOTPSecret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "time %d", unix)
+	}
+}
+
+func newTestTOTPService(t *testing.T, now time.Time) (*TOTP2FAService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	service := NewTOTP2FAService(db, "test-key")
+	service.now = func() time.Time { return now }
+	return service, mock
+}
+
+func TestTOTPVerifyCode_AcceptsAdjacentSteps(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	service, _ := newTestTOTPService(t, now)
+
+	for _, offset := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+		code, err := GenerateTOTPCode(testTOTPSecret, now.Add(offset))
+		require.NoError(t, err)
+		_, ok := service.matchStep(testTOTPSecret, code)
+		assert.True(t, ok, "offset %s", offset)
+	}
+
+	code, err := GenerateTOTPCode(testTOTPSecret, now.Add(90*time.Second))
+	require.NoError(t, err)
+	_, ok := service.matchStep(testTOTPSecret, code)
+	assert.False(t, ok)
+}
+
+func TestTOTPVerifyCode_RejectsReuse(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	service, mock := newTestTOTPService(t, now)
+	encrypted, err := service.encrypt(testTOTPSecret)
+	require.NoError(t, err)
+	code, err := GenerateTOTPCode(testTOTPSecret, now)
+	require.NoError(t, err)
+
+	// The conditional update finds the step already redeemed
+	mock.ExpectQuery(`SELECT two_factor_secret, two_factor_method FROM users`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"two_factor_secret", "two_factor_method"}).AddRow(encrypted, "totp"))
+	mock.ExpectExec(`SET totp_last_used_step`).
+		WithArgs("user-1", now.Unix()/totpPeriod).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.ErrorIs(t, service.VerifyCode("user-1", code), ErrTOTPCodeReused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTOTPSecret_EncryptedAtRest(t *testing.T) {
+	service, _ := newTestTOTPService(t, time.Now())
+
+	encrypted, err := service.encrypt(testTOTPSecret)
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, testTOTPSecret)
+
+	decrypted, err := service.decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, testTOTPSecret, decrypted)
+
+	other := NewTOTP2FAService(nil, "other-key")
+	_, err = other.decrypt(encrypted)
+	assert.Error(t, err)
+}
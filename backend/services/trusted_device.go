@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrTrustedDeviceNotFound is returned when revoking a device the user
+// doesn't have
+var ErrTrustedDeviceNotFound = errors.New("trusted device not found")
+
+// trustedDeviceDuration is how long a device may skip 2FA
+const trustedDeviceDuration = 30 * 24 * time.Hour
+
+// TrustedDevice is a device allowed to skip 2FA, as shown to its owner
+type TrustedDevice struct {
+	ID         string     `json:"id"`
+	DeviceInfo string     `json:"device_info"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+// TrustDevice issues a token that lets this device skip 2FA for 30 days.
+// Only its hash is stored, tied to the device fingerprint.
+func (a *AuthService) TrustDevice(userID, deviceInfo, ipAddress string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = a.db.Exec(`
+		INSERT INTO trusted_devices (user_id, token_hash, device_fingerprint, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID, hashToken(token), a.createDeviceFingerprint(deviceInfo, ipAddress),
+		deviceInfo, ipAddress, time.Now().Add(trustedDeviceDuration),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store trusted device: %w", err)
+	}
+
+	return token, nil
+}
+
+// IsTrustedDevice reports whether token is an unexpired trust token for this
+// user on the same device it was issued to
+func (a *AuthService) IsTrustedDevice(userID, token, deviceInfo, ipAddress string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	result, err := a.db.Exec(`
+		UPDATE trusted_devices
+		SET last_used_at = NOW()
+		WHERE token_hash = $1 AND user_id = $2 AND device_fingerprint = $3 AND expires_at > NOW()`,
+		hashToken(token), userID, a.createDeviceFingerprint(deviceInfo, ipAddress),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// ListTrustedDevices returns the user's unexpired trusted devices, newest
+// first
+func (a *AuthService) ListTrustedDevices(userID string) ([]TrustedDevice, error) {
+	rows, err := a.db.Query(`
+		SELECT id, COALESCE(user_agent, ''), COALESCE(host(ip_address), ''),
+		       created_at, last_used_at, expires_at
+		FROM trusted_devices
+		WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []TrustedDevice{}
+	for rows.Next() {
+		var device TrustedDevice
+		if err := rows.Scan(
+			&device.ID, &device.DeviceInfo, &device.IPAddress,
+			&device.CreatedAt, &device.LastUsedAt, &device.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// RevokeTrustedDevice deletes one of the user's trusted devices, so its next
+// login asks for a 2FA code again
+func (a *AuthService) RevokeTrustedDevice(userID, deviceID string) error {
+	if _, err := uuid.Parse(deviceID); err != nil {
+		return ErrTrustedDeviceNotFound
+	}
+
+	result, err := a.db.Exec(`
+		DELETE FROM trusted_devices WHERE id = $1 AND user_id = $2
+	`, deviceID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTrustedDeviceNotFound
+	}
+	return nil
+}
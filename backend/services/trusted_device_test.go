@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustDevice_StoresOnlyHashForThisDevice(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	tokenHash := &capturedArg{}
+	expiresAt := &capturedArg{}
+	mock.ExpectExec(`INSERT INTO trusted_devices`).
+		WithArgs("user-1", tokenHash, service.createDeviceFingerprint("Firefox", "203.0.113.7"), "Firefox", "203.0.113.7", expiresAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	token, err := service.TrustDevice("user-1", "Firefox", "203.0.113.7")
+
+	require.NoError(t, err)
+	assert.Equal(t, hashToken(token), tokenHash.value)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), expiresAt.value.(time.Time), time.Minute)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsTrustedDevice_OtherDeviceIsNotTrusted(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	// The token was issued to Firefox; Chrome's fingerprint matches no row
+	mock.ExpectExec(`UPDATE trusted_devices`).
+		WithArgs(hashToken("trust-token"), "user-1", service.createDeviceFingerprint("Chrome", "203.0.113.7")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	trusted, err := service.IsTrustedDevice("user-1", "trust-token", "Chrome", "203.0.113.7")
+
+	require.NoError(t, err)
+	assert.False(t, trusted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsTrustedDevice_NoTokenSkipsLookup(t *testing.T) {
+	service, mock := newTestAuthService(t)
+
+	trusted, err := service.IsTrustedDevice("user-1", "", "Firefox", "203.0.113.7")
+
+	require.NoError(t, err)
+	assert.False(t, trusted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrArvLimitReached is returned when a tenant has run every ARV
+// calculation its plan includes this month
+var ErrArvLimitReached = errors.New("monthly ARV calculation limit reached")
+
+// ArvUsage is how many ARV calculations a tenant has run in a month, out of
+// its plan's limit
+type ArvUsage struct {
+	Tier  SubscriptionTier `json:"tier"`
+	Month time.Time        `json:"month"` // first of the month, UTC
+	Used  int              `json:"used"`
+	Limit int              `json:"limit"` // -1 for unlimited
+}
+
+// UsageRepository counts tenants' ARV calculations a calendar month at a
+// time, so usage resets on the 1st
+type UsageRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewUsageRepository creates a new usage repository
+func NewUsageRepository(db *sql.DB) *UsageRepository {
+	return &UsageRepository{db: db, now: time.Now}
+}
+
+// usageMonth is the first of the month t falls in, in UTC
+func usageMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// plan returns tenantID's subscription tier and its plan. A tier without a
+// plan gets the Starter plan, as GetSubscriptionStatus does.
+func (r *UsageRepository) plan(tenantID string) (SubscriptionTier, SubscriptionPlan, error) {
+	var tier string
+	err := r.db.QueryRow(`SELECT subscription_tier FROM tenants WHERE id = $1`, tenantID).Scan(&tier)
+	if err != nil {
+		return "", SubscriptionPlan{}, fmt.Errorf("failed to load tenant plan: %w", err)
+	}
+	plan, ok := subscriptionPlans()[SubscriptionTier(tier)]
+	if !ok {
+		return TierStarter, subscriptionPlans()[TierStarter], nil
+	}
+	return SubscriptionTier(tier), plan, nil
+}
+
+// Tier returns tenantID's subscription tier, Starter for a tier without a
+// plan
+func (r *UsageRepository) Tier(tenantID string) (SubscriptionTier, error) {
+	tier, _, err := r.plan(tenantID)
+	return tier, err
+}
+
+// ArvUsage returns how many ARV calculations tenantID has run this month
+func (r *UsageRepository) ArvUsage(tenantID string) (ArvUsage, error) {
+	tier, plan, err := r.plan(tenantID)
+	if err != nil {
+		return ArvUsage{}, err
+	}
+	usage := ArvUsage{Tier: tier, Month: usageMonth(r.now()), Limit: plan.ArvLimit}
+
+	err = r.db.QueryRow(`
+		SELECT arv_calculations FROM usage_records WHERE tenant_id = $1 AND month = $2
+	`, tenantID, usage.Month).Scan(&usage.Used)
+	if err != nil && err != sql.ErrNoRows {
+		return ArvUsage{}, fmt.Errorf("failed to load ARV usage: %w", err)
+	}
+	return usage, nil
+}
+
+// RecordArvCalculation counts one more ARV calculation for tenantID this
+// month, or returns ErrArvLimitReached when its plan's limit is used up.
+// The check and the increment are one statement, so two calculations at
+// once can't both take the last one or count as one.
+func (r *UsageRepository) RecordArvCalculation(tenantID string) (ArvUsage, error) {
+	tier, plan, err := r.plan(tenantID)
+	if err != nil {
+		return ArvUsage{}, err
+	}
+	usage := ArvUsage{Tier: tier, Month: usageMonth(r.now()), Limit: plan.ArvLimit}
+
+	err = r.db.QueryRow(`
+		INSERT INTO usage_records (tenant_id, month, arv_calculations)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (tenant_id, month) DO UPDATE
+		SET arv_calculations = usage_records.arv_calculations + 1, updated_at = NOW()
+		WHERE $3 < 0 OR usage_records.arv_calculations < $3
+		RETURNING arv_calculations
+	`, tenantID, usage.Month, usage.Limit).Scan(&usage.Used)
+	if err == sql.ErrNoRows {
+		usage.Used = usage.Limit
+		return usage, ErrArvLimitReached
+	}
+	if err != nil {
+		return ArvUsage{}, fmt.Errorf("failed to record ARV usage: %w", err)
+	}
+	return usage, nil
+}
+
+// ReleaseArvCalculation takes back a calculation recorded for tenantID in
+// month that didn't go through
+func (r *UsageRepository) ReleaseArvCalculation(tenantID string, month time.Time) error {
+	_, err := r.db.Exec(`
+		UPDATE usage_records SET arv_calculations = arv_calculations - 1, updated_at = NOW()
+		WHERE tenant_id = $1 AND month = $2 AND arv_calculations > 0
+	`, tenantID, month)
+	if err != nil {
+		return fmt.Errorf("failed to release ARV usage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUsageRepository(t *testing.T, now time.Time) (*UsageRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	repo := NewUsageRepository(db)
+	repo.now = func() time.Time { return now }
+	return repo, mock
+}
+
+func expectUsageTier(mock sqlmock.Sqlmock, tenantID string, tier SubscriptionTier) {
+	mock.ExpectQuery(`SELECT subscription_tier FROM tenants WHERE id = \$1`).
+		WithArgs(tenantID).
+		WillReturnRows(sqlmock.NewRows([]string{"subscription_tier"}).AddRow(string(tier)))
+}
+
+const recordUsageQuery = `INSERT INTO usage_records .* ON CONFLICT \(tenant_id, month\) DO UPDATE .* WHERE \$3 < 0 OR usage_records.arv_calculations < \$3`
+
+func TestRecordArvCalculation_ResetsOnTheFirst(t *testing.T) {
+	october := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	november := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	// The last second of October counts towards October's ten...
+	repo, mock := newTestUsageRepository(t, time.Date(2026, time.October, 31, 23, 59, 59, 0, time.UTC))
+	expectUsageTier(mock, "tenant-1", TierStarter)
+	mock.ExpectQuery(recordUsageQuery).
+		WithArgs("tenant-1", october, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}))
+
+	usage, err := repo.RecordArvCalculation("tenant-1")
+	assert.ErrorIs(t, err, ErrArvLimitReached)
+	assert.Equal(t, ArvUsage{Tier: TierStarter, Month: october, Used: 10, Limit: 10}, usage)
+
+	// ...and a minute later November's row starts again at one
+	repo.now = func() time.Time { return time.Date(2026, time.November, 1, 0, 0, 59, 0, time.UTC) }
+	expectUsageTier(mock, "tenant-1", TierStarter)
+	mock.ExpectQuery(recordUsageQuery).
+		WithArgs("tenant-1", november, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}).AddRow(1))
+
+	usage, err = repo.RecordArvCalculation("tenant-1")
+	require.NoError(t, err)
+	assert.Equal(t, ArvUsage{Tier: TierStarter, Month: november, Used: 1, Limit: 10}, usage)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUsageMonth_IsUTC(t *testing.T) {
+	// 8pm on October 31st in New York is already November in UTC
+	newYork := time.FixedZone("EDT", -4*60*60)
+	assert.Equal(t, time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC),
+		usageMonth(time.Date(2026, time.October, 31, 20, 0, 0, 0, newYork)))
+}
+
+func TestRecordArvCalculation_ConcurrentCallsTakeTheLastOneOnce(t *testing.T) {
+	repo, mock := newTestUsageRepository(t, time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	month := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	// Nine used. Postgres runs the conditional upsert for one caller at a
+	// time, so whichever gets the row lock second finds ten and no row comes
+	// back.
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < 2; i++ {
+		expectUsageTier(mock, "tenant-1", TierStarter)
+	}
+	mock.ExpectQuery(recordUsageQuery).
+		WithArgs("tenant-1", month, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}).AddRow(10))
+	mock.ExpectQuery(recordUsageQuery).
+		WithArgs("tenant-1", month, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}))
+
+	var wg sync.WaitGroup
+	results := make([]ArvUsage, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = repo.RecordArvCalculation("tenant-1")
+		}(i)
+	}
+	wg.Wait()
+
+	allowed, refused := 0, 0
+	for i, err := range errs {
+		if err == nil {
+			allowed++
+			assert.Equal(t, 10, results[i].Used)
+		} else {
+			assert.ErrorIs(t, err, ErrArvLimitReached)
+			refused++
+		}
+	}
+	assert.Equal(t, 1, allowed)
+	assert.Equal(t, 1, refused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordArvCalculation_UnlimitedPlan(t *testing.T) {
+	repo, mock := newTestUsageRepository(t, time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	expectUsageTier(mock, "tenant-1", TierProfessional)
+	mock.ExpectQuery(recordUsageQuery).
+		WithArgs("tenant-1", sqlmock.AnyArg(), -1).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}).AddRow(250))
+
+	usage, err := repo.RecordArvCalculation("tenant-1")
+
+	require.NoError(t, err)
+	assert.Equal(t, 250, usage.Used)
+	assert.Equal(t, -1, usage.Limit)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestArvUsage_NoCalculationsThisMonth(t *testing.T) {
+	repo, mock := newTestUsageRepository(t, time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	expectUsageTier(mock, "tenant-1", "legacy")
+	mock.ExpectQuery(`SELECT arv_calculations FROM usage_records WHERE tenant_id = \$1 AND month = \$2`).
+		WithArgs("tenant-1", time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"arv_calculations"}))
+
+	usage, err := repo.ArvUsage("tenant-1")
+
+	require.NoError(t, err)
+	assert.Equal(t, TierStarter, usage.Tier, "an unknown tier gets the Starter plan")
+	assert.Zero(t, usage.Used)
+	assert.Equal(t, 10, usage.Limit)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Profile errors
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrBlankName          = errors.New("name cannot be blank")
+	ErrInvalidPhoneNumber = errors.New("invalid phone number format")
+	ErrPhoneLockedBy2FA   = errors.New("phone number is used for two-factor authentication")
+)
+
+// UserProfile is the part of a user record the user may see about themselves
+type UserProfile struct {
+	ID               string     `json:"id"`
+	TenantID         string     `json:"tenant_id"`
+	Email            string     `json:"email"`
+	EmailVerified    bool       `json:"email_verified"`
+	FirstName        string     `json:"first_name"`
+	LastName         string     `json:"last_name"`
+	PhoneNumber      string     `json:"phone_number"`
+	PhoneVerified    bool       `json:"phone_verified"`
+	Role             string     `json:"role"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	TwoFactorMethod  string     `json:"two_factor_method"`
+	LoginAlertEmail  bool       `json:"login_alert_email"`
+	LoginAlertSMS    bool       `json:"login_alert_sms"`
+	LastLoginAt      *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// UpdateProfileRequest changes profile fields. Omitted fields are left as is;
+// an empty phone number removes it.
+type UpdateProfileRequest struct {
+	FirstName   *string `json:"first_name" binding:"omitempty,min=1,max=100"`
+	LastName    *string `json:"last_name" binding:"omitempty,min=1,max=100"`
+	PhoneNumber *string `json:"phone_number" binding:"omitempty,max=20"`
+
+	// New-device sign-in alerts; SMS alerts need a verified phone
+	LoginAlertEmail *bool `json:"login_alert_email"`
+	LoginAlertSMS   *bool `json:"login_alert_sms"`
+}
+
+// UserService manages a user's own profile
+type UserService struct {
+	db *sql.DB
+}
+
+// NewUserService creates a new user service
+func NewUserService(db *sql.DB) *UserService {
+	return &UserService{db: db}
+}
+
+// GetProfile returns the profile of an active user
+func (s *UserService) GetProfile(userID string) (*UserProfile, error) {
+	var profile UserProfile
+	err := s.db.QueryRow(`
+		SELECT id, tenant_id, email, email_verified, COALESCE(first_name, ''), COALESCE(last_name, ''),
+		       COALESCE(phone_number, ''), phone_verified, role, two_factor_enabled, two_factor_method,
+		       login_alert_email, login_alert_sms, last_login_at, created_at, updated_at
+		FROM users WHERE id = $1 AND is_active = TRUE
+	`, userID).Scan(
+		&profile.ID, &profile.TenantID, &profile.Email, &profile.EmailVerified,
+		&profile.FirstName, &profile.LastName, &profile.PhoneNumber, &profile.PhoneVerified,
+		&profile.Role, &profile.TwoFactorEnabled, &profile.TwoFactorMethod,
+		&profile.LoginAlertEmail, &profile.LoginAlertSMS,
+		&profile.LastLoginAt, &profile.CreatedAt, &profile.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// UpdateProfile applies the requested changes and returns the new profile.
+// A changed phone number is marked unverified until confirmed over SMS.
+func (s *UserService) UpdateProfile(userID string, req UpdateProfileRequest) (*UserProfile, error) {
+	profile, err := s.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	firstName, lastName := profile.FirstName, profile.LastName
+	if req.FirstName != nil {
+		if firstName = strings.TrimSpace(*req.FirstName); firstName == "" {
+			return nil, ErrBlankName
+		}
+	}
+	if req.LastName != nil {
+		if lastName = strings.TrimSpace(*req.LastName); lastName == "" {
+			return nil, ErrBlankName
+		}
+	}
+
+	phone, phoneVerified := profile.PhoneNumber, profile.PhoneVerified
+	if req.PhoneNumber != nil {
+		newPhone := strings.TrimSpace(*req.PhoneNumber)
+		if newPhone != "" {
+			if newPhone, err = NormalizePhoneNumber(newPhone); err != nil {
+				return nil, err
+			}
+		}
+		if newPhone != profile.PhoneNumber {
+			// Changing the number would silently switch SMS 2FA off until
+			// the new one is verified
+			if profile.TwoFactorEnabled && profile.TwoFactorMethod == "sms" {
+				return nil, ErrPhoneLockedBy2FA
+			}
+			phone, phoneVerified = newPhone, false
+		}
+	}
+
+	alertEmail, alertSMS := profile.LoginAlertEmail, profile.LoginAlertSMS
+	if req.LoginAlertEmail != nil {
+		alertEmail = *req.LoginAlertEmail
+	}
+	if req.LoginAlertSMS != nil {
+		alertSMS = *req.LoginAlertSMS
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE users
+		SET first_name = $2, last_name = $3, phone_number = $4, phone_verified = $5,
+		    login_alert_email = $6, login_alert_sms = $7, updated_at = NOW()
+		WHERE id = $1
+	`, userID, firstName, lastName, phone, phoneVerified, alertEmail, alertSMS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update profile: %w", err)
+	}
+
+	return s.GetProfile(userID)
+}
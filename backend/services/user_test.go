@@ -0,0 +1,103 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var profileColumns = []string{
+	"id", "tenant_id", "email", "email_verified", "first_name", "last_name",
+	"phone_number", "phone_verified", "role", "two_factor_enabled", "two_factor_method",
+	"login_alert_email", "login_alert_sms", "last_login_at", "created_at", "updated_at",
+}
+
+func newTestUserService(t *testing.T) (*UserService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewUserService(db), mock
+}
+
+func expectProfile(mock sqlmock.Sqlmock, phone string, phoneVerified, twoFactor bool) {
+	now := time.Now()
+	mock.ExpectQuery(`FROM users WHERE id = \$1 AND is_active = TRUE`).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows(profileColumns).AddRow(
+			"user-1", "tenant-1", "user@example.com", true, "Jane", "Doe",
+			phone, phoneVerified, "user", twoFactor, "sms",
+			true, false, nil, now, now,
+		))
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestUpdateProfile_PhoneChangeResetsVerification(t *testing.T) {
+	service, mock := newTestUserService(t)
+
+	expectProfile(mock, "+15555550100", true, false)
+	mock.ExpectExec(`UPDATE users`).
+		WithArgs("user-1", "Jane", "Doe", "+15555550199", false, true, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectProfile(mock, "+15555550199", false, false)
+
+	profile, err := service.UpdateProfile("user-1", UpdateProfileRequest{PhoneNumber: strPtr("+1 (555) 555-0199")})
+
+	require.NoError(t, err)
+	assert.False(t, profile.PhoneVerified)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProfile_SamePhoneKeepsVerification(t *testing.T) {
+	service, mock := newTestUserService(t)
+
+	expectProfile(mock, "+15555550100", true, false)
+	mock.ExpectExec(`UPDATE users`).
+		WithArgs("user-1", "Janet", "Doe", "+15555550100", true, true, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectProfile(mock, "+15555550100", true, false)
+
+	_, err := service.UpdateProfile("user-1", UpdateProfileRequest{
+		FirstName:   strPtr("Janet"),
+		PhoneNumber: strPtr("+15555550100"),
+	})
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProfile_RejectsInvalidPhone(t *testing.T) {
+	service, mock := newTestUserService(t)
+
+	expectProfile(mock, "", false, false)
+
+	_, err := service.UpdateProfile("user-1", UpdateProfileRequest{PhoneNumber: strPtr("555-0199")})
+
+	assert.ErrorIs(t, err, ErrInvalidPhoneNumber)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProfile_PhoneLockedWhileSMS2FAEnabled(t *testing.T) {
+	service, mock := newTestUserService(t)
+
+	expectProfile(mock, "+15555550100", true, true)
+
+	_, err := service.UpdateProfile("user-1", UpdateProfileRequest{PhoneNumber: strPtr("+15555550199")})
+
+	assert.ErrorIs(t, err, ErrPhoneLockedBy2FA)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProfile_RejectsBlankName(t *testing.T) {
+	service, mock := newTestUserService(t)
+
+	expectProfile(mock, "", false, false)
+
+	_, err := service.UpdateProfile("user-1", UpdateProfileRequest{LastName: strPtr("   ")})
+
+	assert.ErrorIs(t, err, ErrBlankName)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
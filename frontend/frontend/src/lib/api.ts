@@ -29,7 +29,14 @@ export interface ArvCalculationResult {
 	brrrr_max_offer: number;
 	brrrr_profit: number;
 	risk_level: string;
-	recommendations: string[];
+	recommendations: Notice[];
+}
+
+export interface Notice {
+	code: string;
+	severity: 'positive' | 'info' | 'warning' | 'critical';
+	message: string;
+	field?: string;
 }
 
 export interface ApiResponse<T> {